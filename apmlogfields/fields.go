@@ -0,0 +1,148 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package apmlogfields provides the names and values of the fields that the
+// logging modules (apmlogrus, apmslog, apmzap, apmzerolog) add to log records, for
+// correlating logs with traces. Field names configured with SetNames apply
+// to all of the logging modules.
+package apmlogfields
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.elastic.co/apm"
+)
+
+const (
+	// DefaultTraceID is the default field name for the trace ID.
+	DefaultTraceID = "trace.id"
+
+	// DefaultTransactionID is the default field name for the transaction ID.
+	DefaultTransactionID = "transaction.id"
+
+	// DefaultSpanID is the default field name for the span ID.
+	DefaultSpanID = "span.id"
+)
+
+var names atomic.Value
+
+func init() {
+	SetNames(Names{})
+}
+
+// Names holds the names of log correlation fields.
+type Names struct {
+	// TraceID is the field name for the trace ID.
+	// If empty, DefaultTraceID is used.
+	TraceID string
+
+	// TransactionID is the field name for the transaction ID.
+	// If empty, DefaultTransactionID is used.
+	TransactionID string
+
+	// SpanID is the field name for the span ID.
+	// If empty, DefaultSpanID is used.
+	SpanID string
+
+	// ServiceName, if non-empty, is the field name for the name of
+	// the service, as configured in the transaction's tracer. By
+	// default the service name is not added to log records.
+	ServiceName string
+
+	// ServiceEnvironment, if non-empty, is the field name for the
+	// service environment, as configured in the transaction's tracer.
+	// By default the service environment is not added to log records.
+	ServiceEnvironment string
+}
+
+// SetNames sets the log correlation field names used by the logging
+// modules. SetNames should be called before logging begins; records
+// logged with the previous names will not be recognised afterwards.
+func SetNames(n Names) {
+	if n.TraceID == "" {
+		n.TraceID = DefaultTraceID
+	}
+	if n.TransactionID == "" {
+		n.TransactionID = DefaultTransactionID
+	}
+	if n.SpanID == "" {
+		n.SpanID = DefaultSpanID
+	}
+	names.Store(n)
+}
+
+// CurrentNames returns the log correlation field names most recently
+// set with SetNames, with defaults filled in.
+func CurrentNames() Names {
+	return names.Load().(Names)
+}
+
+// Fields holds the values of log correlation fields.
+type Fields struct {
+	// TraceID holds the trace ID.
+	TraceID apm.TraceID
+
+	// TransactionID holds the transaction ID.
+	TransactionID apm.SpanID
+
+	// SpanID holds the span ID. This is only
+	// meaningful if HasSpan is true.
+	SpanID apm.SpanID
+
+	// HasSpan reports whether or not the context
+	// contained a span.
+	HasSpan bool
+
+	// ServiceName holds the service name, if
+	// Names.ServiceName is non-empty.
+	ServiceName string
+
+	// ServiceEnvironment holds the service environment,
+	// if Names.ServiceEnvironment is non-empty.
+	ServiceEnvironment string
+}
+
+// FromContext returns the log correlation field values for the
+// transaction and span contained in ctx, along with the current
+// field names. If ctx does not contain a transaction, FromContext
+// returns false.
+func FromContext(ctx context.Context) (Fields, Names, bool) {
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil {
+		return Fields{}, Names{}, false
+	}
+	n := CurrentNames()
+	traceContext := tx.TraceContext()
+	fields := Fields{
+		TraceID:       traceContext.Trace,
+		TransactionID: traceContext.Span,
+	}
+	if span := apm.SpanFromContext(ctx); span != nil {
+		fields.SpanID = span.TraceContext().Span
+		fields.HasSpan = true
+	}
+	if tracer := tx.Tracer(); tracer != nil {
+		if n.ServiceName != "" {
+			fields.ServiceName = tracer.Service.Name
+		}
+		if n.ServiceEnvironment != "" {
+			fields.ServiceEnvironment = tracer.Service.Environment
+		}
+	}
+	return fields, n, true
+}
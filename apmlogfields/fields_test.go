@@ -0,0 +1,62 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmlogfields_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmlogfields"
+	"go.elastic.co/apm/apmtest"
+)
+
+func TestFromContext(t *testing.T) {
+	_, _, ok := apmlogfields.FromContext(context.Background())
+	assert.False(t, ok)
+
+	defer apmlogfields.SetNames(apmlogfields.Names{})
+	apmlogfields.SetNames(apmlogfields.Names{
+		TraceID:     "trace_id",
+		ServiceName: "service.name",
+	})
+
+	tx := apmtest.DiscardTracer.StartTransaction("name", "type")
+	defer tx.Discard()
+	span := tx.StartSpan("name", "type", nil)
+	defer span.End()
+	ctx := apm.ContextWithSpan(apm.ContextWithTransaction(context.Background(), tx), span)
+
+	fields, names, ok := apmlogfields.FromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, apmlogfields.Names{
+		TraceID:       "trace_id",
+		TransactionID: apmlogfields.DefaultTransactionID,
+		SpanID:        apmlogfields.DefaultSpanID,
+		ServiceName:   "service.name",
+	}, names)
+	assert.Equal(t, apmlogfields.Fields{
+		TraceID:       tx.TraceContext().Trace,
+		TransactionID: tx.TraceContext().Span,
+		SpanID:        span.TraceContext().Span,
+		HasSpan:       true,
+		ServiceName:   apmtest.DiscardTracer.Service.Name,
+	}, fields)
+}
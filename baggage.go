@@ -0,0 +1,82 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"go.elastic.co/apm/internal/apmconfig"
+	"go.elastic.co/apm/internal/wildcard"
+	"go.elastic.co/apm/model"
+)
+
+// BaggageTagPrefix is the prefix added to the keys of baggage members
+// recorded as tags. Tag keys have '.' replaced with '_', so a baggage
+// member with the key "tenant" is recorded in the tag "baggage_tenant".
+const BaggageTagPrefix = "baggage."
+
+// BaggageMember holds a W3C Baggage key/value pair, e.g. received
+// in the "baggage" HTTP header.
+type BaggageMember struct {
+	// Key holds the baggage member's key.
+	Key string
+
+	// Value holds the baggage member's decoded value.
+	Value string
+}
+
+// SetBaggageToAttach sets the wildcard patterns matching the keys of
+// incoming baggage members, passed in TransactionOptions.Baggage, which
+// are recorded as tags on the transaction, its spans, and its errors.
+// If SetBaggageToAttach is called with no arguments, then no baggage
+// will be recorded. By default, all baggage members are recorded.
+func (t *Tracer) SetBaggageToAttach(patterns ...string) {
+	var matchers wildcard.Matchers
+	if len(patterns) != 0 {
+		matchers = make(wildcard.Matchers, len(patterns))
+		for i, p := range patterns {
+			matchers[i] = apmconfig.ParseWildcardPattern(p)
+		}
+	}
+	t.baggageToAttachMu.Lock()
+	t.baggageToAttach = matchers
+	t.baggageToAttachMu.Unlock()
+}
+
+// baggageTags returns the members of baggage with keys matching the
+// tracer's baggage-to-attach patterns, as tags.
+func (t *Tracer) baggageTags(baggage []BaggageMember) []model.StringMapItem {
+	if len(baggage) == 0 {
+		return nil
+	}
+	t.baggageToAttachMu.RLock()
+	matchers := t.baggageToAttach
+	t.baggageToAttachMu.RUnlock()
+	var tags []model.StringMapItem
+	for _, member := range baggage {
+		if member.Key != "" && matchers.MatchAny(member.Key) {
+			tags = append(tags, model.StringMapItem{Key: BaggageTagPrefix + member.Key, Value: member.Value})
+		}
+	}
+	return tags
+}
+
+// setBaggageTags records tags in c.
+func setBaggageTags(c *Context, tags []model.StringMapItem) {
+	for _, tag := range tags {
+		c.SetTag(tag.Key, tag.Value)
+	}
+}
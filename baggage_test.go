@@ -0,0 +1,85 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestBaggageTags(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		Baggage: []apm.BaggageMember{
+			{Key: "tenant", Value: "acme"},
+			{Key: "region", Value: "eu"},
+		},
+	})
+	span := tx.StartSpan("name", "type", nil)
+	e := tracer.NewError(errors.New("boom"))
+	e.SetSpan(span)
+	e.Send()
+	span.End()
+	e = tracer.NewError(errors.New("boom"))
+	e.SetTransaction(tx)
+	e.Send()
+	tx.End()
+	tracer.Flush(nil)
+
+	expect := model.StringMap{
+		{Key: "baggage_region", Value: "eu"},
+		{Key: "baggage_tenant", Value: "acme"},
+	}
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+	require.Len(t, payloads.Errors, 2)
+	assert.Equal(t, expect, payloads.Transactions[0].Context.Tags)
+	assert.Equal(t, expect, payloads.Spans[0].Context.Tags)
+	assert.Equal(t, expect, payloads.Errors[0].Context.Tags)
+	assert.Equal(t, expect, payloads.Errors[1].Context.Tags)
+}
+
+func TestSetBaggageToAttach(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	baggage := []apm.BaggageMember{
+		{Key: "Tenant", Value: "acme"},
+		{Key: "region", Value: "eu"},
+	}
+
+	tracer.SetBaggageToAttach("tenant")
+	tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{Baggage: baggage}).End()
+	tracer.SetBaggageToAttach()
+	tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{Baggage: baggage}).End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, model.StringMap{{Key: "baggage_Tenant", Value: "acme"}}, payloads.Transactions[0].Context.Tags)
+	assert.Nil(t, payloads.Transactions[1].Context)
+}
@@ -0,0 +1,105 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"context"
+	"sync"
+)
+
+// CacheMetrics is a MetricsGatherer which records cache hit and miss
+// counts, for use by instrumentation of caching clients.
+//
+// Counts are recorded per cache type (e.g. "redis") and destination
+// (e.g. the cache server's address), and are reset each time metrics are
+// gathered; the reported "cache.hits" and "cache.misses" metrics thus
+// cover the metrics interval. A "cache.hit_ratio" metric is reported,
+// holding the ratio of hits to lookups in the interval.
+//
+// CacheMetrics must be registered with a Tracer, using
+// Tracer.RegisterMetricsGatherer, for the metrics to be reported.
+// The zero value is ready to use.
+type CacheMetrics struct {
+	mu     sync.Mutex
+	counts map[cacheMetricsKey]*cacheCounts
+}
+
+type cacheMetricsKey struct {
+	cacheType   string
+	destination string
+}
+
+type cacheCounts struct {
+	hits   uint64
+	misses uint64
+}
+
+// Hit records a cache hit for the given cache type and destination.
+// If destination is empty, the hit is recorded without a destination.
+func (m *CacheMetrics) Hit(cacheType, destination string) {
+	m.Add(cacheType, destination, 1, 0)
+}
+
+// Miss records a cache miss for the given cache type and destination.
+// If destination is empty, the miss is recorded without a destination.
+func (m *CacheMetrics) Miss(cacheType, destination string) {
+	m.Add(cacheType, destination, 0, 1)
+}
+
+// Add records the given numbers of cache hits and misses for the given
+// cache type and destination. This may be used for recording the results
+// of multi-key lookups.
+func (m *CacheMetrics) Add(cacheType, destination string, hits, misses uint64) {
+	if hits == 0 && misses == 0 {
+		return
+	}
+	key := cacheMetricsKey{cacheType: cacheType, destination: destination}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts, ok := m.counts[key]
+	if !ok {
+		if m.counts == nil {
+			m.counts = make(map[cacheMetricsKey]*cacheCounts)
+		}
+		counts = &cacheCounts{}
+		m.counts[key] = counts
+	}
+	counts.hits += hits
+	counts.misses += misses
+}
+
+// GatherMetrics adds the cache metrics recorded since the last call
+// to GatherMetrics into out, and resets the recorded counts.
+func (m *CacheMetrics) GatherMetrics(ctx context.Context, out *Metrics) error {
+	m.mu.Lock()
+	counts := m.counts
+	m.counts = nil
+	m.mu.Unlock()
+
+	for key, counts := range counts {
+		labels := []MetricLabel{{Name: "cache_type", Value: key.cacheType}}
+		if key.destination != "" {
+			labels = append(labels, MetricLabel{Name: "destination", Value: key.destination})
+		}
+		total := counts.hits + counts.misses
+		out.Add("cache.hits", labels, float64(counts.hits))
+		out.Add("cache.misses", labels, float64(counts.misses))
+		out.Add("cache.hit_ratio", labels, float64(counts.hits)/float64(total))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestCacheMetrics(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var cacheMetrics apm.CacheMetrics
+	tracer.RegisterMetricsGatherer(&cacheMetrics)
+
+	cacheMetrics.Hit("redis", "localhost:6379")
+	cacheMetrics.Hit("redis", "localhost:6379")
+	cacheMetrics.Miss("redis", "localhost:6379")
+	cacheMetrics.Add("redis", "localhost:6379", 3, 2)
+	cacheMetrics.Miss("memcached", "")
+	tracer.SendMetrics(nil)
+
+	metrics := cacheMetricsets(transport.Payloads().Metrics)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, model.StringMap{{Key: "cache_type", Value: "memcached"}}, metrics[0].Labels)
+	assert.Equal(t, map[string]model.Metric{
+		"cache.hits":      {Value: 0},
+		"cache.misses":    {Value: 1},
+		"cache.hit_ratio": {Value: 0},
+	}, metrics[0].Samples)
+	assert.Equal(t, model.StringMap{
+		{Key: "cache_type", Value: "redis"},
+		{Key: "destination", Value: "localhost:6379"},
+	}, metrics[1].Labels)
+	assert.Equal(t, map[string]model.Metric{
+		"cache.hits":      {Value: 5},
+		"cache.misses":    {Value: 3},
+		"cache.hit_ratio": {Value: 0.625},
+	}, metrics[1].Samples)
+
+	// Counts are reset after they are gathered.
+	transport.ResetPayloads()
+	tracer.SendMetrics(nil)
+	assert.Empty(t, cacheMetricsets(transport.Payloads().Metrics))
+}
+
+func cacheMetricsets(metrics []model.Metrics) []model.Metrics {
+	var out []model.Metrics
+	for _, m := range metrics {
+		if _, ok := m.Samples["cache.hits"]; ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -20,9 +20,9 @@ package apm
 import (
 	"bytes"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
+	"unicode/utf8"
 
 	"go.elastic.co/apm/model"
 )
@@ -46,25 +46,48 @@ const (
 	// CaptureBodyAll captures HTTP request bodies for both transactions
 	// and errors.
 	CaptureBodyAll CaptureBodyMode = CaptureBodyErrors | CaptureBodyTransactions
+
+	// CaptureBodyFailedTransactions captures HTTP request bodies for
+	// errors, and for transactions which fail. A transaction is considered
+	// to have failed if an error is reported for it, or if its HTTP
+	// response status code is 5xx.
+	//
+	// The request body is buffered for all transactions, but only recorded
+	// in the transaction context when the transaction is ended.
+	CaptureBodyFailedTransactions CaptureBodyMode = CaptureBodyErrors | captureBodyFailedTransactions
+
+	captureBodyFailedTransactions CaptureBodyMode = 1 << 2
 )
 
 // CaptureHTTPRequestBody replaces req.Body and returns a possibly nil
 // BodyCapturer which can later be passed to Context.SetHTTPRequestBody
 // for setting the request body in a transaction or error context. If the
-// tracer is not configured to capture HTTP request bodies, then req.Body
+// tracer is not configured to capture HTTP request bodies, or the request's
+// Content-Type is not one of those configured for capture, then req.Body
 // is left alone and nil is returned.
 //
-// This must be called before the request body is read.
+// At most the configured maximum size of the body is buffered; see
+// Tracer.SetCaptureBodyMaxSize. This must be called before the request
+// body is read.
 func (t *Tracer) CaptureHTTPRequestBody(req *http.Request) *BodyCapturer {
 	if req.Body == nil {
 		return nil
 	}
 	t.captureBodyMu.RLock()
 	captureBody := t.captureBody
+	maxSize := t.captureBodyMaxSize
+	contentTypes := t.captureBodyContentTypes
 	t.captureBodyMu.RUnlock()
 	if captureBody == CaptureBodyOff {
 		return nil
 	}
+	contentType := req.Header.Get("Content-Type")
+	if len(contentTypes) != 0 && !contentTypes.MatchAny(contentType) {
+		return nil
+	}
+	if maxSize <= 0 {
+		maxSize = int(defaultCaptureBodyMaxSize)
+	}
 
 	type readerCloser struct {
 		io.Reader
@@ -75,6 +98,7 @@ func (t *Tracer) CaptureHTTPRequestBody(req *http.Request) *BodyCapturer {
 		request:      req,
 		originalBody: req.Body,
 	}
+	bc.buffer.limit = maxSize
 	req.Body = &readerCloser{
 		Reader: io.TeeReader(req.Body, &bc.buffer),
 		Closer: req.Body,
@@ -87,7 +111,7 @@ func (t *Tracer) CaptureHTTPRequestBody(req *http.Request) *BodyCapturer {
 type BodyCapturer struct {
 	captureBody  CaptureBodyMode
 	originalBody io.ReadCloser
-	buffer       bytes.Buffer
+	buffer       limitedBuffer
 	request      *http.Request
 }
 
@@ -98,25 +122,60 @@ func (bc *BodyCapturer) setContext(out *model.RequestBody) bool {
 		// copy if sanitization is necessary, but body
 		// capture shouldn't typically be enabled so
 		// we don't currently optimize this.
-		postForm := make(url.Values, len(bc.request.PostForm))
-		for k, v := range bc.request.PostForm {
-			vcopy := make([]string, len(v))
-			for i := range vcopy {
-				vcopy[i] = truncateString(v[i])
-			}
-			postForm[k] = vcopy
-		}
-		out.Form = postForm
+		out.Form = copyForm(bc.request.PostForm)
 		return true
 	}
 
-	// Read from the buffer and anything remaining in the body.
-	r := io.MultiReader(bytes.NewReader(bc.buffer.Bytes()), bc.originalBody)
-	all, err := ioutil.ReadAll(r)
-	if err != nil {
-		// TODO(axw) log error?
-		return false
+	// Read anything remaining in the body, up to the limit, into
+	// the buffer, so that the body can be recorded in both transaction
+	// and error contexts.
+	if n := bc.buffer.limit - bc.buffer.Len(); n > 0 {
+		if _, err := io.CopyN(&bc.buffer, bc.originalBody, int64(n)); err != nil && err != io.EOF {
+			// TODO(axw) log error?
+			return false
+		}
 	}
-	out.Raw = truncateString(string(all))
+	out.Raw = string(truncateUTF8(bc.buffer.Bytes()))
 	return true
 }
+
+func copyForm(form url.Values) url.Values {
+	out := make(url.Values, len(form))
+	for k, v := range form {
+		vcopy := make([]string, len(v))
+		for i := range vcopy {
+			vcopy[i] = truncateString(v[i])
+		}
+		out[k] = vcopy
+	}
+	return out
+}
+
+// truncateUTF8 returns b without any incomplete UTF-8
+// sequence at its end, left by truncating the body.
+func truncateUTF8(b []byte) []byte {
+	for i := 0; i < utf8.UTFMax-1 && len(b) > 0; i++ {
+		if r, size := utf8.DecodeLastRune(b); r != utf8.RuneError || size != 1 {
+			break
+		}
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// limitedBuffer is a bytes.Buffer which discards
+// writes beyond limit bytes.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if n := b.limit - b.Len(); n < len(p) {
+		if n > 0 {
+			b.Buffer.Write(p[:n])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,72 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import "time"
+
+// childrenTimer tracks the time during which at least one direct
+// child span of a transaction or span is active, for calculating
+// the parent's self-time.
+type childrenTimer struct {
+	active   int
+	start    time.Time
+	duration time.Duration
+
+	// ended is set when the parent has ended, after
+	// which child spans are no longer tracked.
+	ended bool
+}
+
+// childStarted records the start of a child at the given time.
+func (t *childrenTimer) childStarted(start time.Time) {
+	if t.ended {
+		return
+	}
+	t.active++
+	if t.active == 1 {
+		t.start = start
+	}
+}
+
+// childEnded records the end of a child at the given time.
+func (t *childrenTimer) childEnded(end time.Time) {
+	if t.ended || t.active == 0 {
+		return
+	}
+	t.active--
+	if t.active == 0 && end.After(t.start) {
+		t.duration += end.Sub(t.start)
+	}
+}
+
+// selfTime returns the self-time of a parent which ended at the given
+// time with the given duration: its duration minus the time during
+// which its children were active. Children still active when the
+// parent ends are considered to end at the same time as the parent.
+// Subsequent child events are ignored.
+func (t *childrenTimer) selfTime(end time.Time, duration time.Duration) time.Duration {
+	if t.active > 0 && end.After(t.start) {
+		t.duration += end.Sub(t.start)
+	}
+	t.active = 0
+	t.ended = true
+	if d := duration - t.duration; d > 0 {
+		return d
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"time"
+)
+
+// clockSkewEstimator is implemented by transports which estimate the
+// difference between the server's clock and the local clock, such as
+// *transport.HTTPTransport.
+type clockSkewEstimator interface {
+	ClockSkew() (time.Duration, bool)
+}
+
+// SetClockSkewCorrection enables or disables correction of event
+// timestamps for the estimated difference between the APM Server's
+// clock and the local clock.
+//
+// When enabled, the timestamps of subsequently started transactions,
+// spans, and subsequently created errors and gathered metrics are
+// adjusted by the clock skew estimated from the APM Server's responses;
+// see Tracer.ClockSkew. All spans within a transaction are adjusted by
+// the same amount as the transaction, so their timing relative to the
+// transaction is preserved.
+func (t *Tracer) SetClockSkewCorrection(enabled bool) {
+	t.clockSkewMu.Lock()
+	t.clockSkewCorrection = enabled
+	t.clockSkewMu.Unlock()
+}
+
+// ClockSkew returns the estimated difference between the APM Server's
+// clock and the local clock, and whether an estimate is available.
+// The skew is positive if the server's clock is ahead.
+//
+// The skew is estimated by the tracer's transport while sending events,
+// if the transport supports it. The estimate is available whether or
+// not clock skew correction is enabled.
+func (t *Tracer) ClockSkew() (time.Duration, bool) {
+	t.clockSkewMu.RLock()
+	defer t.clockSkewMu.RUnlock()
+	return t.clockSkew, t.clockSkewEstimated
+}
+
+// clockSkewOffset returns the duration by which the timestamps of new
+// events should be adjusted: the estimated clock skew if clock skew
+// correction is enabled, and zero otherwise.
+func (t *Tracer) clockSkewOffset() time.Duration {
+	t.clockSkewMu.RLock()
+	defer t.clockSkewMu.RUnlock()
+	if !t.clockSkewCorrection {
+		return 0
+	}
+	return t.clockSkew
+}
+
+// updateClockSkew updates the estimated clock skew from the transport,
+// if the transport estimates clock skew.
+func (t *Tracer) updateClockSkew() {
+	e, ok := t.Transport.(clockSkewEstimator)
+	if !ok {
+		return
+	}
+	skew, ok := e.ClockSkew()
+	if !ok {
+		return
+	}
+	t.clockSkewMu.Lock()
+	t.clockSkew = skew
+	t.clockSkewEstimated = true
+	t.clockSkewMu.Unlock()
+}
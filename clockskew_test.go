@@ -0,0 +1,107 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestTracerClockSkewCorrection(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.Transport = skewedTransport{RecorderTransport: recorder, skew: time.Hour}
+	tracer.SetClockSkewCorrection(true)
+
+	_, ok := tracer.ClockSkew()
+	assert.False(t, ok)
+
+	start := time.Unix(1500000000, 0).UTC()
+	tx0 := tracer.StartTransactionOptions("before", "type", apm.TransactionOptions{Start: start})
+	tx0.End()
+	tracer.Flush(nil)
+
+	skew, ok := tracer.ClockSkew()
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, skew)
+
+	tx1 := tracer.StartTransactionOptions("after", "type", apm.TransactionOptions{Start: start})
+	span := tx1.StartSpanOptions("span", "type", apm.SpanOptions{Start: start.Add(time.Second)})
+	span.End()
+	e := tracer.NewError(errors.New("boom"))
+	e.Timestamp = start.Add(2 * time.Second)
+	e.Send()
+	tx1.End()
+
+	tracer.SetClockSkewCorrection(false)
+	tx2 := tracer.StartTransactionOptions("disabled", "type", apm.TransactionOptions{Start: start})
+	tx2.End()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 3)
+	require.Len(t, payloads.Spans, 1)
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, model.Time(start), payloads.Transactions[0].Timestamp)
+	assert.Equal(t, model.Time(start.Add(time.Hour)), payloads.Transactions[1].Timestamp)
+	assert.Equal(t, model.Time(start.Add(time.Hour+time.Second)), payloads.Spans[0].Timestamp)
+	assert.Equal(t, model.Time(start.Add(time.Hour+2*time.Second)), payloads.Errors[0].Timestamp)
+	assert.Equal(t, model.Time(start), payloads.Transactions[2].Timestamp)
+}
+
+func TestTracerClockSkewCorrectionSpansUseTransactionSkew(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.Transport = skewedTransport{RecorderTransport: recorder, skew: time.Hour}
+	tracer.SetClockSkewCorrection(true)
+
+	// Spans are adjusted by the same amount as their transaction,
+	// even if the estimate changes while the transaction is active.
+	start := time.Unix(1500000000, 0).UTC()
+	tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{Start: start})
+	tracer.StartTransaction("other", "type").End()
+	tracer.Flush(nil)
+	tx.StartSpanOptions("span", "type", apm.SpanOptions{Start: start.Add(time.Second)}).End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, model.Time(start), payloads.Transactions[1].Timestamp)
+	assert.Equal(t, model.Time(start.Add(time.Second)), payloads.Spans[0].Timestamp)
+}
+
+// skewedTransport is a transporttest.RecorderTransport which
+// reports a fixed clock skew.
+type skewedTransport struct {
+	*transporttest.RecorderTransport
+	skew time.Duration
+}
+
+func (t skewedTransport) ClockSkew() (time.Duration, bool) {
+	return t.skew, true
+}
@@ -0,0 +1,189 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Command apmgo-doctor diagnoses the configuration of the Elastic APM Go
+// agent. It reads the same ELASTIC_APM_* environment variables as the
+// agent, prints the configuration with secrets masked, checks that the
+// APM Server can be reached, and sends a test transaction.
+//
+// apmgo-doctor exits with a non-zero status if any of the checks fail.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+)
+
+const envPrefix = "ELASTIC_APM_"
+
+var (
+	timeout         = flag.Duration("timeout", 10*time.Second, "Timeout for the connectivity check and test transaction")
+	testTransaction = flag.Bool("test-transaction", true, "Send a test transaction to the APM Server")
+)
+
+// secretEnvSubstrings holds substrings of environment variable
+// names whose values must not be printed.
+var secretEnvSubstrings = []string{"SECRET", "TOKEN", "API_KEY", "PASSWORD"}
+
+func main() {
+	flag.Parse()
+	if !doctor(os.Stdout, *timeout, *testTransaction) {
+		os.Exit(1)
+	}
+}
+
+// doctor runs the diagnostics, writing the results to w, and
+// returns true if all checks succeeded.
+func doctor(w io.Writer, timeout time.Duration, sendTestTransaction bool) bool {
+	fmt.Fprintln(w, "Configuration:")
+	printEnvironment(w, os.Environ())
+
+	fmt.Fprintln(w, "Transport:")
+	tr, err := transport.InitDefault()
+	if err != nil {
+		fmt.Fprintf(w, "  FAILED: %s\n", err)
+		return false
+	}
+	fmt.Fprintln(w, "  OK")
+
+	fmt.Fprintln(w, "Tracer:")
+	tracer, err := apm.NewTracer("", "")
+	if err != nil {
+		fmt.Fprintf(w, "  FAILED: %s\n", err)
+		return false
+	}
+	defer tracer.Close()
+	tracer.Transport = tr
+	fmt.Fprintf(w, "  Service name: %s\n", tracer.Service.Name)
+	if tracer.Service.Version != "" {
+		fmt.Fprintf(w, "  Service version: %s\n", tracer.Service.Version)
+	}
+	if tracer.Service.Environment != "" {
+		fmt.Fprintf(w, "  Environment: %s\n", tracer.Service.Environment)
+	}
+	fmt.Fprintf(w, "  Active: %t\n", tracer.Active())
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	fmt.Fprintln(w, "Connectivity:")
+	report, err := tracer.CheckConnectivity(ctx)
+	if report.ServerURL != "" {
+		fmt.Fprintf(w, "  Server URL: %s\n", maskURL(report.ServerURL))
+	}
+	fmt.Fprintf(w, "  Reachable: %t\n", report.Reachable)
+	fmt.Fprintf(w, "  Authorized: %t\n", report.Authorized)
+	if report.ServerVersion != "" {
+		fmt.Fprintf(w, "  Server version: %s\n", report.ServerVersion)
+	}
+	fmt.Fprintf(w, "  Clock skew: %s\n", report.ClockSkew)
+	if err != nil {
+		fmt.Fprintf(w, "  FAILED: %s\n", err)
+		return false
+	}
+	fmt.Fprintln(w, "  OK")
+
+	if !sendTestTransaction {
+		return true
+	}
+	fmt.Fprintln(w, "Test transaction:")
+	if !tracer.Active() {
+		fmt.Fprintln(w, "  SKIPPED: the agent is inactive")
+		return true
+	}
+	tx := tracer.StartTransaction("apmgo-doctor", "doctor")
+	traceID := tx.TraceContext().Trace
+	tx.StartSpan("apmgo-doctor", "doctor", nil).End()
+	tx.End()
+	if err := tracer.FlushContext(ctx); err != nil {
+		fmt.Fprintf(w, "  FAILED: %s\n", err)
+		return false
+	}
+	fmt.Fprintf(w, "  Sent transaction with trace ID %s\n", traceID)
+	fmt.Fprintln(w, "  OK")
+	return true
+}
+
+// printEnvironment writes the ELASTIC_APM_* variables in environ
+// to w, sorted by name, masking the values of secrets.
+func printEnvironment(w io.Writer, environ []string) {
+	var vars []string
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, envPrefix) {
+			vars = append(vars, kv)
+		}
+	}
+	if len(vars) == 0 {
+		fmt.Fprintf(w, "  no %s* environment variables set; using defaults\n", envPrefix)
+		return
+	}
+	sort.Strings(vars)
+	for _, kv := range vars {
+		var key, value string
+		if i := strings.IndexRune(kv, '='); i >= 0 {
+			key, value = kv[:i], kv[i+1:]
+		} else {
+			key = kv
+		}
+		fmt.Fprintf(w, "  %s=%s\n", key, maskValue(key, value))
+	}
+}
+
+// maskValue returns value, the value of the environment variable
+// key, with secrets replaced by "[REDACTED]".
+func maskValue(key, value string) string {
+	if value == "" {
+		return value
+	}
+	for _, s := range secretEnvSubstrings {
+		if strings.Contains(key, s) {
+			return "[REDACTED]"
+		}
+	}
+	switch key {
+	case "ELASTIC_APM_SERVER_URL", "ELASTIC_APM_SERVER_URLS":
+		urls := strings.Split(value, ",")
+		for i, u := range urls {
+			urls[i] = maskURL(u)
+		}
+		return strings.Join(urls, ",")
+	}
+	return value
+}
+
+// maskURL returns s with the password of its
+// user information, if any, redacted.
+func maskURL(s string) string {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	return u.String()
+}
@@ -0,0 +1,388 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/internal/apmconfig"
+	"go.elastic.co/apm/internal/apmlog"
+	"go.elastic.co/apm/transport"
+)
+
+// remoteConfigOptions holds the configuration attributes which may be
+// changed by a config watcher. Each function applies the given value to
+// the tracer, returning a function which restores the previous value.
+var remoteConfigOptions = map[string]func(t *Tracer, value string) (restore func(), err error){
+	"transaction_sample_rate": func(t *Tracer, value string) (func(), error) {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		if ratio < 0.0 || ratio > 1.0 {
+			return nil, errors.Errorf("%v out of range [0,1.0]", ratio)
+		}
+		t.samplerMu.RLock()
+		local := t.sampler
+		t.samplerMu.RUnlock()
+		t.SetSampler(NewRatioSampler(ratio))
+		return func() { t.SetSampler(local) }, nil
+	},
+	"transaction_max_spans": func(t *Tracer, value string) (func(), error) {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		t.maxSpansMu.RLock()
+		local := t.maxSpans
+		t.maxSpansMu.RUnlock()
+		t.SetMaxSpans(n)
+		return func() { t.SetMaxSpans(local) }, nil
+	},
+	"capture_body": func(t *Tracer, value string) (func(), error) {
+		mode, err := parseCaptureBody(value)
+		if err != nil {
+			return nil, err
+		}
+		t.captureBodyMu.RLock()
+		local := t.captureBody
+		t.captureBodyMu.RUnlock()
+		t.SetCaptureBody(mode)
+		return func() { t.SetCaptureBody(local) }, nil
+	},
+	"capture_headers": func(t *Tracer, value string) (func(), error) {
+		capture, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		t.captureHeadersMu.RLock()
+		local := t.captureHeaders
+		t.captureHeadersMu.RUnlock()
+		t.SetCaptureHeaders(capture)
+		return func() { t.SetCaptureHeaders(local) }, nil
+	},
+	"span_frames_min_duration": func(t *Tracer, value string) (func(), error) {
+		d, err := apmconfig.ParseDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		t.spanFramesMinDurationMu.RLock()
+		local := t.spanFramesMinDuration
+		t.spanFramesMinDurationMu.RUnlock()
+		t.SetSpanFramesMinDuration(d)
+		return func() { t.SetSpanFramesMinDuration(local) }, nil
+	},
+	"label_max_count": remoteLabelLimit(func(limits *LabelLimits) *int {
+		return &limits.MaxCount
+	}),
+	"label_max_key_length": remoteLabelLimit(func(limits *LabelLimits) *int {
+		return &limits.MaxKeyLength
+	}),
+	"label_max_value_length": remoteLabelLimit(func(limits *LabelLimits) *int {
+		return &limits.MaxValueLength
+	}),
+	"api_request_size": func(t *Tracer, value string) (func(), error) {
+		size, err := apmconfig.ParseSize(value)
+		if err != nil {
+			return nil, err
+		}
+		if size < minAPIRequestSize || size > maxAPIRequestSize {
+			return nil, errors.Errorf("%s out of range [%s,%s]", size, minAPIRequestSize, maxAPIRequestSize)
+		}
+		return t.swapLoopConfig(func(cfg *tracerConfig) func(*tracerConfig) {
+			local := cfg.requestSize
+			cfg.requestSize = int(size)
+			return func(cfg *tracerConfig) { cfg.requestSize = local }
+		}), nil
+	},
+	"api_request_time": func(t *Tracer, value string) (func(), error) {
+		d, err := apmconfig.ParseDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, errors.Errorf("%s is not positive", d)
+		}
+		return t.swapLoopConfig(func(cfg *tracerConfig) func(*tracerConfig) {
+			local := cfg.requestDuration
+			cfg.requestDuration = d
+			return func(cfg *tracerConfig) { cfg.requestDuration = local }
+		}), nil
+	},
+	"api_buffer_size": func(t *Tracer, value string) (func(), error) {
+		size, err := apmconfig.ParseSize(value)
+		if err != nil {
+			return nil, err
+		}
+		if size < minAPIBufferSize || size > maxAPIBufferSize {
+			return nil, errors.Errorf("%s out of range [%s,%s]", size, minAPIBufferSize, maxAPIBufferSize)
+		}
+		return t.swapLoopConfig(func(cfg *tracerConfig) func(*tracerConfig) {
+			local := cfg.bufferSize
+			cfg.bufferSize = int(size)
+			return func(cfg *tracerConfig) { cfg.bufferSize = local }
+		}), nil
+	},
+	"log_level": func(t *Tracer, value string) (func(), error) {
+		local, err := apmlog.SetDefaultLoggerLevel(value)
+		if err != nil {
+			return nil, err
+		}
+		return func() { apmlog.SetDefaultLoggerLevel(local) }, nil
+	},
+}
+
+// swapLoopConfig applies a change to the configuration held by the tracer's
+// loop, returning a function which restores the previous value. The change
+// returns the function for restoring the previous value, which is called by
+// the loop too, so the loop's configuration is only accessed by the loop.
+func (t *Tracer) swapLoopConfig(change func(cfg *tracerConfig) (restore func(*tracerConfig))) func() {
+	var restore func(*tracerConfig)
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		restore = change(cfg)
+	})
+	return func() {
+		t.sendConfigCommand(func(cfg *tracerConfig) {
+			if restore != nil {
+				restore(cfg)
+			}
+		})
+	}
+}
+
+// ConfigChangeHandler is a function which is called with the complete set
+// of configuration attributes received by the tracer's config watcher,
+// including those not supported by the tracer. This may be used by
+// instrumentation modules to apply their own configuration.
+type ConfigChangeHandler func(attrs map[string]string)
+
+// remoteConfig holds the state of configuration applied by a config watcher.
+type remoteConfig struct {
+	mu       sync.Mutex
+	attrs    map[string]string
+	applied  map[string]string
+	restore  map[string]func()
+	handlers []*struct{ ConfigChangeHandler }
+
+	watcherMu     sync.Mutex
+	watcherSet    bool
+	watcherCancel context.CancelFunc
+}
+
+// SetConfigWatcher sets w as the tracer's config watcher, for applying
+// changes to the agent's configuration at runtime, e.g. central config
+// managed in Kibana. Calling SetConfigWatcher with nil stops watching.
+//
+// If SetConfigWatcher is not called, then the tracer watches its Transport
+// for config changes if it implements transport.ConfigWatcher, starting when
+// the tracer first sends events, unless ELASTIC_APM_CENTRAL_CONFIG is set
+// to false.
+//
+// The supported attributes are transaction_sample_rate,
+// transaction_max_spans, capture_body, capture_headers,
+// span_frames_min_duration, label_max_count, label_max_key_length,
+// label_max_value_length, api_request_size, api_request_time,
+// api_buffer_size, and log_level. Changed attributes override the
+// tracer's local configuration, and when an attribute is removed, the
+// configuration in effect before it was first changed is restored. All
+// attributes are passed to handlers registered with
+// RegisterConfigChangeHandler.
+func (t *Tracer) SetConfigWatcher(w transport.ConfigWatcher) {
+	t.remoteConfig.watcherMu.Lock()
+	defer t.remoteConfig.watcherMu.Unlock()
+	t.setConfigWatcherLocked(w)
+}
+
+// setDefaultConfigWatcher sets w as the config watcher,
+// unless SetConfigWatcher has been called.
+func (t *Tracer) setDefaultConfigWatcher(w transport.ConfigWatcher) {
+	t.remoteConfig.watcherMu.Lock()
+	defer t.remoteConfig.watcherMu.Unlock()
+	if !t.remoteConfig.watcherSet {
+		t.setConfigWatcherLocked(w)
+	}
+}
+
+func (t *Tracer) setConfigWatcherLocked(w transport.ConfigWatcher) {
+	t.remoteConfig.watcherSet = true
+	if t.remoteConfig.watcherCancel != nil {
+		t.remoteConfig.watcherCancel()
+		t.remoteConfig.watcherCancel = nil
+	}
+	if w == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.remoteConfig.watcherCancel = cancel
+
+	var params transport.WatchConfigParams
+	params.Service.Name = t.Service.Name
+	params.Service.Environment = t.Service.Environment
+	changes := w.WatchConfig(ctx, params)
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-t.closing:
+				return
+			case change, ok := <-changes:
+				if !ok {
+					return
+				}
+				if change.Err != nil {
+					t.sendConfigCommand(func(cfg *tracerConfig) {
+						if cfg.logger != nil {
+							cfg.logger.Debugf("failed to fetch config: %s", change.Err)
+						}
+					})
+					continue
+				}
+				t.updateRemoteConfig(change.Attrs)
+			}
+		}
+	}()
+}
+
+// RegisterConfigChangeHandler registers h to be called after each config
+// change received by the tracer's config watcher. If a config change has
+// already been received, h is called immediately with its attributes.
+//
+// RegisterConfigChangeHandler returns a function which will deregister h.
+// It may safely be called multiple times.
+func (t *Tracer) RegisterConfigChangeHandler(h ConfigChangeHandler) func() {
+	wrapped := &struct{ ConfigChangeHandler }{ConfigChangeHandler: h}
+	t.remoteConfig.mu.Lock()
+	t.remoteConfig.handlers = append(t.remoteConfig.handlers, wrapped)
+	attrs := copyConfigAttrs(t.remoteConfig.attrs)
+	t.remoteConfig.mu.Unlock()
+	if attrs != nil {
+		h(attrs)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.remoteConfig.mu.Lock()
+			defer t.remoteConfig.mu.Unlock()
+			for i, handler := range t.remoteConfig.handlers {
+				if handler == wrapped {
+					t.remoteConfig.handlers = append(t.remoteConfig.handlers[:i], t.remoteConfig.handlers[i+1:]...)
+					break
+				}
+			}
+		})
+	}
+}
+
+// updateRemoteConfig applies the supported attributes in attrs, restores
+// the local configuration for removed attributes, and then calls the
+// registered config change handlers.
+func (t *Tracer) updateRemoteConfig(attrs map[string]string) {
+	// Messages are logged after applying the config,
+	// as the logger is owned by the tracer loop.
+	type logMessage struct {
+		error   bool
+		message string
+	}
+	var logs []logMessage
+	logf := func(isError bool, format string, args ...interface{}) {
+		logs = append(logs, logMessage{error: isError, message: fmt.Sprintf(format, args...)})
+	}
+
+	rc := &t.remoteConfig
+	rc.mu.Lock()
+	if rc.applied == nil {
+		rc.applied = make(map[string]string)
+		rc.restore = make(map[string]func())
+	}
+	for key, value := range attrs {
+		apply, ok := remoteConfigOptions[key]
+		if !ok {
+			logf(false, "ignoring unsupported config attribute %q", key)
+			continue
+		}
+		if applied, ok := rc.applied[key]; ok && applied == value {
+			continue
+		}
+		restore, err := apply(t, value)
+		if err != nil {
+			logf(true, "failed to apply config %s=%q: %s", key, value, err)
+			continue
+		}
+		if _, ok := rc.restore[key]; !ok {
+			rc.restore[key] = restore
+		}
+		rc.applied[key] = value
+		logf(false, "applied config %s=%q", key, value)
+	}
+	for key, restore := range rc.restore {
+		if _, ok := attrs[key]; ok {
+			continue
+		}
+		restore()
+		delete(rc.restore, key)
+		delete(rc.applied, key)
+		logf(false, "restored local config for %s", key)
+	}
+	rc.attrs = copyConfigAttrs(attrs)
+	if rc.attrs == nil {
+		rc.attrs = make(map[string]string)
+	}
+	handlers := make([]ConfigChangeHandler, len(rc.handlers))
+	for i, h := range rc.handlers {
+		handlers[i] = h.ConfigChangeHandler
+	}
+	rc.mu.Unlock()
+
+	if len(logs) != 0 {
+		t.sendConfigCommand(func(cfg *tracerConfig) {
+			if cfg.logger == nil {
+				return
+			}
+			for _, log := range logs {
+				if log.error {
+					cfg.logger.Errorf("%s", log.message)
+				} else {
+					cfg.logger.Debugf("%s", log.message)
+				}
+			}
+		})
+	}
+	for _, h := range handlers {
+		attrs := copyConfigAttrs(attrs)
+		if attrs == nil {
+			attrs = make(map[string]string)
+		}
+		h(attrs)
+	}
+}
+
+func copyConfigAttrs(attrs map[string]string) map[string]string {
+	if attrs == nil {
+		return nil
+	}
+	out := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,237 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/transport"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestTracerConfigWatcher(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	watcher := newConfigWatcher()
+	tracer.SetConfigWatcher(watcher)
+	changed := make(chan map[string]string)
+	tracer.RegisterConfigChangeHandler(func(attrs map[string]string) {
+		changed <- attrs
+	})
+
+	watcher.changes <- transport.ConfigChange{Attrs: map[string]string{
+		"transaction_sample_rate": "0",
+		"unsupported":             "value",
+	}}
+	assert.Equal(t, map[string]string{
+		"transaction_sample_rate": "0",
+		"unsupported":             "value",
+	}, <-changed)
+	tracer.StartTransaction("name", "type").End()
+
+	// Removing the attribute restores the local configuration.
+	watcher.changes <- transport.ConfigChange{Attrs: map[string]string{}}
+	assert.Equal(t, map[string]string{}, <-changed)
+	tracer.StartTransaction("name", "type").End()
+
+	tracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.False(t, *payloads.Transactions[0].Sampled)
+	assert.Nil(t, payloads.Transactions[1].Sampled)
+
+	assert.Equal(t, "transporttest", watcher.params.Service.Name)
+}
+
+func TestTracerConfigWatcherInvalidValues(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	watcher := newConfigWatcher()
+	tracer.SetConfigWatcher(watcher)
+	changed := make(chan map[string]string)
+	tracer.RegisterConfigChangeHandler(func(attrs map[string]string) {
+		changed <- attrs
+	})
+
+	watcher.changes <- transport.ConfigChange{Attrs: map[string]string{
+		"transaction_max_spans": "1",
+	}}
+	<-changed
+	watcher.changes <- transport.ConfigChange{Attrs: map[string]string{
+		"transaction_max_spans":   "many",
+		"transaction_sample_rate": "2",
+		"api_request_size":        "1B",
+		"api_request_time":        "0s",
+		"api_buffer_size":         "1GB",
+	}}
+	<-changed
+
+	// Invalid values are ignored: the previous
+	// remote value of transaction_max_spans remains.
+	tx := tracer.StartTransaction("name", "type")
+	tx.StartSpan("one", "type", nil).End()
+	tx.StartSpan("two", "type", nil).End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Nil(t, payloads.Transactions[0].Sampled)
+	assert.Len(t, payloads.Spans, 1)
+	assert.Equal(t, 1, payloads.Transactions[0].SpanCount.Dropped)
+}
+
+func TestTracerConfigWatcherBufferSize(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	unblock := make(chan struct{})
+	tracer.Transport = blockedTransport{
+		Transport: tracer.Transport,
+		unblocked: unblock,
+	}
+
+	watcher := newConfigWatcher()
+	tracer.SetConfigWatcher(watcher)
+	changed := make(chan map[string]string)
+	tracer.RegisterConfigChangeHandler(func(attrs map[string]string) {
+		changed <- attrs
+	})
+
+	watcher.changes <- transport.ConfigChange{Attrs: map[string]string{
+		"api_request_size": "1KB",
+		"api_request_time": "1s",
+	}}
+	<-changed
+
+	// Buffer some transactions, and then shrink the buffer so
+	// that it cannot hold all of them. The oldest ones should
+	// be discarded.
+	const N = 1000
+	for i := 0; i < N; i++ {
+		tracer.StartTransaction(fmt.Sprint(i), "type").End()
+	}
+	watcher.changes <- transport.ConfigChange{Attrs: map[string]string{
+		"api_request_size": "1KB",
+		"api_request_time": "1s",
+		"api_buffer_size":  "10KB",
+	}}
+	<-changed
+	close(unblock)
+	for {
+		stats := tracer.Stats()
+		if stats.TransactionsSent+stats.TransactionsDropped == N {
+			require.NotZero(t, stats.TransactionsSent)
+			require.NotZero(t, stats.TransactionsDropped)
+			break
+		}
+		tracer.Flush(nil)
+	}
+
+	stats := tracer.Stats()
+	p := recorder.Payloads()
+	require.Len(t, p.Transactions, int(stats.TransactionsSent))
+	assert.Equal(t, fmt.Sprint(N-1), p.Transactions[len(p.Transactions)-1].Name)
+}
+
+func TestTracerRegisterConfigChangeHandler(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	watcher := newConfigWatcher()
+	tracer.SetConfigWatcher(watcher)
+	changed := make(chan map[string]string, 1)
+	deregister := tracer.RegisterConfigChangeHandler(func(attrs map[string]string) {
+		changed <- attrs
+	})
+	watcher.changes <- transport.ConfigChange{Attrs: map[string]string{"key": "value"}}
+	<-changed
+
+	// Handlers registered after a change are called immediately.
+	var late map[string]string
+	tracer.RegisterConfigChangeHandler(func(attrs map[string]string) {
+		late = attrs
+	})
+	assert.Equal(t, map[string]string{"key": "value"}, late)
+
+	deregister()
+	deregister()
+	watcher.changes <- transport.ConfigChange{Attrs: map[string]string{"key": "value2"}}
+	watcher.changes <- transport.ConfigChange{Attrs: map[string]string{"key": "value3"}}
+	assert.Empty(t, changed)
+}
+
+func TestTracerDefaultConfigWatcher(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	watcher := newConfigWatcher()
+	tracer.Transport = &watchingTransport{configWatcher: watcher}
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	assert.Equal(t, "transporttest", watcher.params.Service.Name)
+}
+
+func TestTracerDefaultConfigWatcherDisabled(t *testing.T) {
+	os.Setenv("ELASTIC_APM_CENTRAL_CONFIG", "false")
+	defer os.Unsetenv("ELASTIC_APM_CENTRAL_CONFIG")
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	watcher := newConfigWatcher()
+	tracer.Transport = &watchingTransport{configWatcher: watcher}
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	assert.Equal(t, "", watcher.params.Service.Name)
+}
+
+type configWatcher struct {
+	mu      sync.Mutex
+	params  transport.WatchConfigParams
+	changes chan transport.ConfigChange
+}
+
+func newConfigWatcher() *configWatcher {
+	return &configWatcher{changes: make(chan transport.ConfigChange)}
+}
+
+func (w *configWatcher) WatchConfig(ctx context.Context, params transport.WatchConfigParams) <-chan transport.ConfigChange {
+	w.mu.Lock()
+	w.params = params
+	w.mu.Unlock()
+	return w.changes
+}
+
+type watchingTransport struct {
+	transporttest.RecorderTransport
+	*configWatcher
+}
@@ -0,0 +1,156 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/transport"
+)
+
+const (
+	// maxClockSkew is the maximum difference between the local clock
+	// and the APM Server's clock tolerated by CheckConnectivity.
+	maxClockSkew = time.Minute
+
+	// minServerMajorVersion and minServerMinorVersion identify the
+	// oldest APM Server version supporting the intake v2 API.
+	minServerMajorVersion = 6
+	minServerMinorVersion = 5
+)
+
+// ConnectivityReport holds the results of Tracer.CheckConnectivity.
+type ConnectivityReport struct {
+	// ServerURL holds the URL of the APM Server that was checked,
+	// or the empty string if the tracer's transport could not be
+	// checked.
+	ServerURL string
+
+	// Reachable reports whether the APM Server responded.
+	Reachable bool
+
+	// Authorized reports whether the APM Server accepted the agent's
+	// credentials.
+	Authorized bool
+
+	// ServerVersion holds the version reported by the APM Server, or
+	// the empty string if the server did not report its version.
+	ServerVersion string
+
+	// VersionCompatible reports whether ServerVersion is known, and
+	// supports the intake API used by the agent (6.5.0 or newer).
+	VersionCompatible bool
+
+	// ClockSkew holds the difference between the APM Server's clock,
+	// as reported in its Date response header, and the local clock.
+	// ClockSkew is positive if the server's clock is ahead, and zero
+	// if the server did not report its time. The Date header has a
+	// resolution of one second.
+	ClockSkew time.Duration
+}
+
+// CheckConnectivity checks that the tracer can send events to the APM
+// Server, by requesting information from the server with the tracer's
+// transport. This is intended for programs which validate their
+// configuration at startup, and log or expose the results.
+//
+// CheckConnectivity returns a non-nil error if the server could not be
+// reached, the agent was not authorized, the server reported a version
+// older than 6.5.0, or the server's clock differs from the local clock
+// by more than one minute. The report describes what was established
+// in all cases. If the tracer's transport does not support connectivity
+// checks, e.g. because it is not a *transport.HTTPTransport, an error
+// is returned with an empty report.
+func (t *Tracer) CheckConnectivity(ctx context.Context) (ConnectivityReport, error) {
+	var report ConnectivityReport
+	getter, ok := t.Transport.(serverInfoGetter)
+	if !ok {
+		return report, errors.Errorf("transport %T does not support connectivity checks", t.Transport)
+	}
+
+	now := time.Now()
+	info, err := getter.ServerInfo(ctx)
+	if info == nil {
+		return report, errors.Wrap(err, "APM Server unreachable")
+	}
+	report.ServerURL = info.URL.String()
+	report.Reachable = true
+	if !info.Date.IsZero() {
+		// The Date header has a resolution of one second.
+		skew := info.Date.Sub(now)
+		report.ClockSkew = skew - skew%time.Second
+	}
+	if err != nil {
+		if err, ok := err.(*transport.HTTPError); ok {
+			switch err.Response.StatusCode {
+			case http.StatusUnauthorized, http.StatusForbidden:
+				return report, errors.Wrap(err, "agent not authorized")
+			}
+		}
+		return report, err
+	}
+	report.Authorized = true
+	report.ServerVersion = info.Version
+
+	if info.Version != "" {
+		major, minor, ok := parseServerVersion(info.Version)
+		if !ok {
+			return report, errors.Errorf("invalid APM Server version %q", info.Version)
+		}
+		report.VersionCompatible = major > minServerMajorVersion ||
+			major == minServerMajorVersion && minor >= minServerMinorVersion
+		if !report.VersionCompatible {
+			return report, errors.Errorf(
+				"APM Server version %s not supported (requires %d.%d.0 or newer)",
+				info.Version, minServerMajorVersion, minServerMinorVersion,
+			)
+		}
+	}
+	if skew := report.ClockSkew; skew > maxClockSkew || skew < -maxClockSkew {
+		return report, errors.Errorf("clock skew of %s with APM Server exceeds %s", skew, maxClockSkew)
+	}
+	return report, nil
+}
+
+type serverInfoGetter interface {
+	ServerInfo(context.Context) (*transport.ServerInfo, error)
+}
+
+// parseServerVersion parses the major and minor
+// components of an APM Server version string.
+func parseServerVersion(version string) (major, minor int, ok bool) {
+	fields := strings.SplitN(version, ".", 3)
+	if len(fields) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
@@ -0,0 +1,133 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestTracerCheckConnectivity(t *testing.T) {
+	tracer, server := newConnectivityTracer(t, serverInfoHandler("7.4.0", 0))
+	defer server.Close()
+	defer tracer.Close()
+
+	report, err := tracer.CheckConnectivity(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, server.URL, report.ServerURL)
+	assert.True(t, report.Reachable)
+	assert.True(t, report.Authorized)
+	assert.Equal(t, "7.4.0", report.ServerVersion)
+	assert.True(t, report.VersionCompatible)
+	assert.InDelta(t, 0, report.ClockSkew, float64(time.Second))
+}
+
+func TestTracerCheckConnectivityUnauthorized(t *testing.T) {
+	tracer, server := newConnectivityTracer(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	defer tracer.Close()
+
+	report, err := tracer.CheckConnectivity(context.Background())
+	assert.EqualError(t, err, "agent not authorized: request failed with 401 Unauthorized: invalid token")
+	assert.True(t, report.Reachable)
+	assert.False(t, report.Authorized)
+	assert.Empty(t, report.ServerVersion)
+}
+
+func TestTracerCheckConnectivityVersionIncompatible(t *testing.T) {
+	tracer, server := newConnectivityTracer(t, serverInfoHandler("6.4.1", 0))
+	defer server.Close()
+	defer tracer.Close()
+
+	report, err := tracer.CheckConnectivity(context.Background())
+	assert.EqualError(t, err, "APM Server version 6.4.1 not supported (requires 6.5.0 or newer)")
+	assert.True(t, report.Authorized)
+	assert.Equal(t, "6.4.1", report.ServerVersion)
+	assert.False(t, report.VersionCompatible)
+}
+
+func TestTracerCheckConnectivityClockSkew(t *testing.T) {
+	tracer, server := newConnectivityTracer(t, serverInfoHandler("7.4.0", time.Hour))
+	defer server.Close()
+	defer tracer.Close()
+
+	report, err := tracer.CheckConnectivity(context.Background())
+	require.Error(t, err)
+	assert.Regexp(t, "clock skew of .* with APM Server exceeds 1m0s", err.Error())
+	assert.True(t, report.VersionCompatible)
+	assert.InDelta(t, time.Hour, report.ClockSkew, float64(time.Second))
+}
+
+func TestTracerCheckConnectivityUnreachable(t *testing.T) {
+	tracer, server := newConnectivityTracer(t, http.NotFoundHandler())
+	server.Close()
+	defer tracer.Close()
+
+	report, err := tracer.CheckConnectivity(context.Background())
+	require.Error(t, err)
+	assert.Regexp(t, "^APM Server unreachable: sending request failed: ", err.Error())
+	assert.Equal(t, apm.ConnectivityReport{}, report)
+}
+
+func TestTracerCheckConnectivityUnsupportedTransport(t *testing.T) {
+	tracer, err := apm.NewTracer("tracer_testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transporttest.Discard
+
+	report, err := tracer.CheckConnectivity(context.Background())
+	assert.EqualError(t, err, "transport transporttest.ErrorTransport does not support connectivity checks")
+	assert.Equal(t, apm.ConnectivityReport{}, report)
+}
+
+// serverInfoHandler returns an http.Handler which responds to server
+// information requests with version, and a Date header offset by skew.
+func serverInfoHandler(version string, skew time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+		fmt.Fprintf(w, `{"build_date":"2019-10-01T00:00:00Z","build_sha":"abc","version":%q}`, version)
+	})
+}
+
+func newConnectivityTracer(t *testing.T, h http.Handler) (*apm.Tracer, *httptest.Server) {
+	server := httptest.NewServer(h)
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	httpTransport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+	httpTransport.SetServerURL(serverURL)
+
+	tracer, err := apm.NewTracer("tracer_testing", "")
+	require.NoError(t, err)
+	tracer.Transport = httpTransport
+	return tracer, server
+}
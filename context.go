@@ -39,6 +39,11 @@ type Context struct {
 	serviceFramework model.Framework
 	captureHeaders   bool
 	captureBodyMask  CaptureBodyMode
+
+	// failedTransactionBody holds the BodyCapturer to record in the
+	// transaction context if the transaction fails, when the capture
+	// mode is CaptureBodyFailedTransactions.
+	failedTransactionBody *BodyCapturer
 }
 
 func (c *Context) build() *model.Context {
@@ -48,6 +53,7 @@ func (c *Context) build() *model.Context {
 	case c.model.User != nil:
 	case c.model.Service != nil:
 	case len(c.model.Tags) != 0:
+	case len(c.model.Custom) != 0:
 	default:
 		return nil
 	}
@@ -57,7 +63,8 @@ func (c *Context) build() *model.Context {
 func (c *Context) reset() {
 	*c = Context{
 		model: model.Context{
-			Tags: c.model.Tags[:0],
+			Tags:   c.model.Tags[:0],
+			Custom: c.model.Custom[:0],
 		},
 		captureBodyMask: c.captureBodyMask,
 		request: model.Request{
@@ -71,15 +78,37 @@ func (c *Context) reset() {
 
 // SetTag sets a tag in the context. Invalid characters
 // ('.', '*', and '"') in the key will be replaced with
-// an underscore.
+// an underscore. Values are truncated to 10000 characters,
+// and further limited by the tracer's LabelLimits when sent.
 func (c *Context) SetTag(key, value string) {
 	// Note that we do not attempt to de-duplicate the keys.
 	// This is OK, since json.Unmarshal will always take the
 	// final instance.
 	c.model.Tags = append(c.model.Tags, model.StringMapItem{
 		Key:   cleanTagKey(key),
-		Value: truncateString(value),
+		Value: truncateLongString(value),
+	})
+}
+
+// SetCustom sets custom context in the context, with the given key.
+// Invalid characters ('.', '*', and '"') in the key will be replaced
+// with an underscore.
+//
+// The value is encoded as JSON when SetCustom is called, so subsequent
+// changes to value are not reflected in the context. If value cannot be
+// encoded, or exceeds the limits described by ValidateCustomContext,
+// SetCustom returns an error and the context is left unchanged.
+func (c *Context) SetCustom(key string, value interface{}) error {
+	raw, err := encodeCustomContext(value)
+	if err != nil {
+		return err
+	}
+	// As with tags, we do not attempt to de-duplicate the keys.
+	c.model.Custom = append(c.model.Custom, model.IfaceMapItem{
+		Key:   cleanTagKey(key),
+		Value: raw,
 	})
+	return nil
 }
 
 // SetFramework sets the framework name and version in the context.
@@ -177,9 +206,20 @@ func (c *Context) SetHTTPRequest(req *http.Request) {
 // SetHTTPRequestBody sets the request body in context given a (possibly nil)
 // BodyCapturer returned by Tracer.CaptureHTTPRequestBody.
 func (c *Context) SetHTTPRequestBody(bc *BodyCapturer) {
-	if bc == nil || bc.captureBody&c.captureBodyMask == 0 {
+	if bc == nil {
+		return
+	}
+	if bc.captureBody&c.captureBodyMask == 0 {
+		if c.captureBodyMask == CaptureBodyTransactions && bc.captureBody&captureBodyFailedTransactions != 0 {
+			// Defer the decision until the transaction is ended.
+			c.failedTransactionBody = bc
+		}
 		return
 	}
+	c.setHTTPRequestBody(bc)
+}
+
+func (c *Context) setHTTPRequestBody(bc *BodyCapturer) {
 	if bc.setContext(&c.requestBody) {
 		c.request.Body = &c.requestBody
 	}
@@ -19,14 +19,20 @@ package apm_test
 
 import (
 	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.elastic.co/fastjson"
 
 	"go.elastic.co/apm"
 	"go.elastic.co/apm/apmtest"
 	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport/transporttest"
 )
 
 func TestContextTags(t *testing.T) {
@@ -93,9 +99,148 @@ func TestContextFramework(t *testing.T) {
 	})
 }
 
+func TestContextCustom(t *testing.T) {
+	type details struct {
+		Count int      `json:"count"`
+		Names []string `json:"names"`
+	}
+	value := &details{Count: 1, Names: []string{"a"}}
+	tx := testSendTransaction(t, func(tx *apm.Transaction) {
+		require.NoError(t, tx.Context.SetCustom("foo.bar", value))
+		require.NoError(t, tx.Context.SetCustom("baz", map[string]interface{}{"qux": true}))
+		// Changes made after SetCustom returns are not recorded.
+		value.Count++
+	})
+	require.NotNil(t, tx.Context)
+	assert.Equal(t, model.IfaceMap{
+		{Key: "baz", Value: map[string]interface{}{"qux": true}},
+		{Key: "foo_bar", Value: map[string]interface{}{
+			"count": float64(1),
+			"names": []interface{}{"a"},
+		}},
+	}, tx.Context.Custom)
+}
+
+func TestContextCustomInvalid(t *testing.T) {
+	var deep interface{} = "value"
+	for i := 0; i < apm.MaxCustomContextDepth+1; i++ {
+		deep = []interface{}{deep}
+	}
+	for name, value := range map[string]interface{}{
+		"unsupported": make(chan int),
+		"nan":         math.NaN(),
+		"panic":       panicMarshaler{},
+		"deep":        deep,
+		"large":       strings.Repeat("x", apm.MaxCustomContextSize),
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, apm.ValidateCustomContext(value))
+			tx := testSendTransaction(t, func(tx *apm.Transaction) {
+				assert.Error(t, tx.Context.SetCustom("key", value))
+			})
+			assert.Nil(t, tx.Context)
+		})
+	}
+	assert.NoError(t, apm.ValidateCustomContext(deep.([]interface{})[0]))
+}
+
+type panicMarshaler struct{}
+
+func (panicMarshaler) MarshalFastJSON(*fastjson.Writer) error {
+	panic("boom")
+}
+
 func testSendTransaction(t *testing.T, f func(tx *apm.Transaction)) model.Transaction {
 	transaction, _, _ := apmtest.WithTransaction(func(ctx context.Context) {
 		f(apm.TransactionFromContext(ctx))
 	})
 	return transaction
 }
+
+func TestContextCaptureBodyFailedTransactions(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetCaptureBody(apm.CaptureBodyFailedTransactions)
+
+	sendTransaction := func(statusCode int, reportError bool) {
+		req, _ := http.NewRequest("POST", "/", strings.NewReader("foo_bar"))
+		body := tracer.CaptureHTTPRequestBody(req)
+		tx := tracer.StartTransaction("name", "type")
+		if reportError {
+			e := tracer.NewError(errors.New("boom"))
+			e.SetTransaction(tx)
+			e.Context.SetHTTPRequest(req)
+			e.Context.SetHTTPRequestBody(body)
+			e.Send()
+		}
+		tx.Context.SetHTTPRequest(req)
+		tx.Context.SetHTTPRequestBody(body)
+		tx.Context.SetHTTPStatusCode(statusCode)
+		tx.End()
+	}
+	sendTransaction(200, false)
+	sendTransaction(503, false)
+	sendTransaction(200, true)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 3)
+	assert.Nil(t, payloads.Transactions[0].Context.Request.Body)
+	require.NotNil(t, payloads.Transactions[1].Context.Request.Body)
+	assert.Equal(t, "foo_bar", payloads.Transactions[1].Context.Request.Body.Raw)
+	require.NotNil(t, payloads.Transactions[2].Context.Request.Body)
+	assert.Equal(t, "foo_bar", payloads.Transactions[2].Context.Request.Body.Raw)
+
+	require.Len(t, payloads.Errors, 1)
+	require.NotNil(t, payloads.Errors[0].Context.Request.Body)
+	assert.Equal(t, "foo_bar", payloads.Errors[0].Context.Request.Body.Raw)
+}
+
+func TestContextCaptureBodyMaxSize(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetCaptureBody(apm.CaptureBodyAll)
+	tracer.SetCaptureBodyMaxSize(8)
+
+	// The body is truncated to 8 bytes, without splitting
+	// the multi-byte final character.
+	req, _ := http.NewRequest("POST", "/", strings.NewReader("foo_barü_baz"))
+	body := tracer.CaptureHTTPRequestBody(req)
+	tx := tracer.StartTransaction("name", "type")
+	tx.Context.SetHTTPRequest(req)
+	tx.Context.SetHTTPRequestBody(body)
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.NotNil(t, payloads.Transactions[0].Context.Request.Body)
+	assert.Equal(t, "foo_bar", payloads.Transactions[0].Context.Request.Body.Raw)
+}
+
+func TestContextCaptureBodyContentTypes(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetCaptureBody(apm.CaptureBodyAll)
+	tracer.SetCaptureBodyContentTypes("application/json*", "text/*")
+
+	for _, contentType := range []string{"application/json; charset=utf-8", "image/png", ""} {
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(`{"foo":"bar"}`))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		body := tracer.CaptureHTTPRequestBody(req)
+		tx := tracer.StartTransaction("name", "type")
+		tx.Context.SetHTTPRequest(req)
+		tx.Context.SetHTTPRequestBody(body)
+		tx.End()
+	}
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 3)
+	require.NotNil(t, payloads.Transactions[0].Context.Request.Body)
+	assert.Equal(t, `{"foo":"bar"}`, payloads.Transactions[0].Context.Request.Body.Raw)
+	assert.Nil(t, payloads.Transactions[1].Context.Request.Body)
+	assert.Nil(t, payloads.Transactions[2].Context.Request.Body)
+}
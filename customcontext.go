@@ -0,0 +1,128 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.elastic.co/fastjson"
+)
+
+const (
+	// MaxCustomContextDepth is the maximum nesting depth of objects
+	// and arrays in a custom context value.
+	MaxCustomContextDepth = 10
+
+	// MaxCustomContextSize is the maximum size, in bytes, of the
+	// JSON encoding of a custom context value.
+	MaxCustomContextSize = 10 * 1024
+)
+
+// ValidateCustomContext checks that value may be recorded as custom
+// context with Context.SetCustom, returning an error describing why
+// not if it may not.
+//
+// A valid value must be encodable as JSON, either by fastjson or by
+// encoding/json, without error or panic; its JSON encoding must not
+// exceed MaxCustomContextSize bytes; and objects and arrays within it
+// must not be nested more than MaxCustomContextDepth levels deep.
+//
+// ValidateCustomContext may be used to check values ahead of time,
+// e.g. in tests; SetCustom performs the same validation.
+func ValidateCustomContext(value interface{}) error {
+	_, err := encodeCustomContext(value)
+	return err
+}
+
+// customContextValue holds the JSON encoding of a custom context value.
+type customContextValue []byte
+
+// AppendJSON appends v to b.
+func (v customContextValue) AppendJSON(b []byte) []byte {
+	return append(b, v...)
+}
+
+// MarshalJSON returns v.
+func (v customContextValue) MarshalJSON() ([]byte, error) {
+	return v, nil
+}
+
+// encodeCustomContext encodes value as JSON, returning an error if
+// it cannot be encoded or is not a valid custom context value.
+func encodeCustomContext(value interface{}) (_ customContextValue, result error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = errors.Errorf("panic encoding custom context value of type %T: %v", value, r)
+		}
+	}()
+	var w fastjson.Writer
+	if err := fastjson.Marshal(&w, value); err != nil {
+		return nil, errors.Wrapf(err, "failed to encode custom context value of type %T", value)
+	}
+	raw := w.Bytes()
+	if len(raw) > MaxCustomContextSize {
+		return nil, errors.Errorf(
+			"custom context value of type %T exceeds %d bytes when encoded (%d)",
+			value, MaxCustomContextSize, len(raw),
+		)
+	}
+	var valid json.RawMessage
+	if err := json.Unmarshal(raw, &valid); err != nil {
+		// Some values, such as NaN floats, are encoded
+		// by fastjson as invalid JSON.
+		return nil, errors.Errorf("custom context value of type %T is not valid JSON when encoded", value)
+	}
+	if depth := jsonDepth(raw); depth > MaxCustomContextDepth {
+		return nil, errors.Errorf(
+			"custom context value of type %T is nested %d levels deep, exceeding %d",
+			value, depth, MaxCustomContextDepth,
+		)
+	}
+	return customContextValue(raw), nil
+}
+
+// jsonDepth returns the maximum nesting depth of objects and arrays
+// in the valid JSON encoding raw. Scalar values have a depth of zero.
+func jsonDepth(raw []byte) int {
+	var depth, maxDepth int
+	var inString, escaped bool
+	for _, c := range raw {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch c {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return maxDepth
+}
@@ -18,8 +18,10 @@
 package apm
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -29,25 +31,42 @@ import (
 
 	"go.elastic.co/apm/internal/apmconfig"
 	"go.elastic.co/apm/internal/wildcard"
+	"go.elastic.co/apm/model"
 )
 
 const (
-	envMetricsInterval       = "ELASTIC_APM_METRICS_INTERVAL"
-	envMaxSpans              = "ELASTIC_APM_TRANSACTION_MAX_SPANS"
-	envTransactionSampleRate = "ELASTIC_APM_TRANSACTION_SAMPLE_RATE"
-	envSanitizeFieldNames    = "ELASTIC_APM_SANITIZE_FIELD_NAMES"
-	envCaptureHeaders        = "ELASTIC_APM_CAPTURE_HEADERS"
-	envCaptureBody           = "ELASTIC_APM_CAPTURE_BODY"
-	envServiceName           = "ELASTIC_APM_SERVICE_NAME"
-	envServiceVersion        = "ELASTIC_APM_SERVICE_VERSION"
-	envEnvironment           = "ELASTIC_APM_ENVIRONMENT"
-	envSpanFramesMinDuration = "ELASTIC_APM_SPAN_FRAMES_MIN_DURATION"
-	envActive                = "ELASTIC_APM_ACTIVE"
-	envAPIRequestSize        = "ELASTIC_APM_API_REQUEST_SIZE"
-	envAPIRequestTime        = "ELASTIC_APM_API_REQUEST_TIME"
-	envAPIBufferSize         = "ELASTIC_APM_API_BUFFER_SIZE"
-	envMetricsBufferSize     = "ELASTIC_APM_METRICS_BUFFER_SIZE"
-	envDisableMetrics        = "ELASTIC_APM_DISABLE_METRICS"
+	envMetricsInterval             = "ELASTIC_APM_METRICS_INTERVAL"
+	envMaxSpans                    = "ELASTIC_APM_TRANSACTION_MAX_SPANS"
+	envMaxSpansPerSecond           = "ELASTIC_APM_TRANSACTION_MAX_SPANS_PER_SECOND"
+	envLabelMaxCount               = "ELASTIC_APM_LABEL_MAX_COUNT"
+	envLabelMaxKeyLength           = "ELASTIC_APM_LABEL_MAX_KEY_LENGTH"
+	envLabelMaxValueLength         = "ELASTIC_APM_LABEL_MAX_VALUE_LENGTH"
+	envTransactionSampleRate       = "ELASTIC_APM_TRANSACTION_SAMPLE_RATE"
+	envSanitizeFieldNames          = "ELASTIC_APM_SANITIZE_FIELD_NAMES"
+	envCaptureHeaders              = "ELASTIC_APM_CAPTURE_HEADERS"
+	envCaptureBody                 = "ELASTIC_APM_CAPTURE_BODY"
+	envCaptureBodyMaxSize          = "ELASTIC_APM_CAPTURE_BODY_MAX_SIZE"
+	envCaptureBodyContentTypes     = "ELASTIC_APM_CAPTURE_BODY_CONTENT_TYPES"
+	envServiceName                 = "ELASTIC_APM_SERVICE_NAME"
+	envServiceVersion              = "ELASTIC_APM_SERVICE_VERSION"
+	envEnvironment                 = "ELASTIC_APM_ENVIRONMENT"
+	envSpanFramesMinDuration       = "ELASTIC_APM_SPAN_FRAMES_MIN_DURATION"
+	envSpanFramesMinDurationByType = "ELASTIC_APM_SPAN_FRAMES_MIN_DURATION_BY_TYPE"
+	envExitSpanMinDuration         = "ELASTIC_APM_EXIT_SPAN_MIN_DURATION"
+	envBaggageToAttach             = "ELASTIC_APM_BAGGAGE_TO_ATTACH"
+	envSpanCompressionEnabled      = "ELASTIC_APM_SPAN_COMPRESSION_ENABLED"
+	envSpanCompressionExactMatch   = "ELASTIC_APM_SPAN_COMPRESSION_EXACT_MATCH_MAX_DURATION"
+	envSpanCompressionSameKind     = "ELASTIC_APM_SPAN_COMPRESSION_SAME_KIND_MAX_DURATION"
+	envActive                      = "ELASTIC_APM_ACTIVE"
+	envCentralConfig               = "ELASTIC_APM_CENTRAL_CONFIG"
+	envClockSkewCorrection         = "ELASTIC_APM_CLOCK_SKEW_CORRECTION"
+	envAPIRequestSize              = "ELASTIC_APM_API_REQUEST_SIZE"
+	envAPIRequestTime              = "ELASTIC_APM_API_REQUEST_TIME"
+	envAPIBufferSize               = "ELASTIC_APM_API_BUFFER_SIZE"
+	envMetricsBufferSize           = "ELASTIC_APM_METRICS_BUFFER_SIZE"
+	envDisableMetrics              = "ELASTIC_APM_DISABLE_METRICS"
+	envGlobalLabelsFile            = "ELASTIC_APM_GLOBAL_LABELS_FILE"
+	envTransactionNameRewrites     = "ELASTIC_APM_TRANSACTION_NAME_REWRITES"
 
 	defaultAPIRequestSize        = 750 * apmconfig.KByte
 	defaultAPIRequestTime        = 10 * time.Second
@@ -57,7 +76,12 @@ const (
 	defaultMaxSpans              = 500
 	defaultCaptureHeaders        = true
 	defaultCaptureBody           = CaptureBodyOff
+	defaultCaptureBodyMaxSize    = 1 * apmconfig.KByte
 	defaultSpanFramesMinDuration = 5 * time.Millisecond
+	defaultExitSpanMinDuration   = 0
+
+	defaultSpanCompressionExactMatchMaxDuration = 50 * time.Millisecond
+	defaultSpanCompressionSameKindMaxDuration   = 0
 
 	minAPIBufferSize     = 10 * apmconfig.KByte
 	maxAPIBufferSize     = 100 * apmconfig.MByte
@@ -81,6 +105,8 @@ var (
 		"authorization",
 		"set-cookie",
 	}, ","))
+
+	defaultBaggageToAttach = apmconfig.ParseWildcardPatterns("*")
 )
 
 func initialRequestDuration() (time.Duration, error) {
@@ -145,6 +171,41 @@ func initialMaxSpans() (int, error) {
 	return max, nil
 }
 
+func initialMaxSpansPerSecond() (int, error) {
+	value := os.Getenv(envMaxSpansPerSecond)
+	if value == "" {
+		return 0, nil
+	}
+	max, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", envMaxSpansPerSecond)
+	}
+	return max, nil
+}
+
+func initialLabelLimits() (LabelLimits, error) {
+	limits := defaultLabelLimits
+	for _, v := range []struct {
+		env   string
+		field *int
+	}{
+		{envLabelMaxCount, &limits.MaxCount},
+		{envLabelMaxKeyLength, &limits.MaxKeyLength},
+		{envLabelMaxValueLength, &limits.MaxValueLength},
+	} {
+		value := os.Getenv(v.env)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return defaultLabelLimits, errors.Wrapf(err, "failed to parse %s", v.env)
+		}
+		*v.field = n
+	}
+	return limits, nil
+}
+
 // initialSampler returns a nil Sampler if all transactions should be sampled.
 func initialSampler() (Sampler, error) {
 	value := os.Getenv(envTransactionSampleRate)
@@ -168,6 +229,10 @@ func initialSanitizedFieldNames() wildcard.Matchers {
 	return apmconfig.ParseWildcardPatternsEnv(envSanitizeFieldNames, defaultSanitizedFieldNames)
 }
 
+func initialBaggageToAttach() wildcard.Matchers {
+	return apmconfig.ParseWildcardPatternsEnv(envBaggageToAttach, defaultBaggageToAttach)
+}
+
 func initialCaptureHeaders() (bool, error) {
 	return apmconfig.ParseBoolEnv(envCaptureHeaders, defaultCaptureHeaders)
 }
@@ -177,6 +242,14 @@ func initialCaptureBody() (CaptureBodyMode, error) {
 	if value == "" {
 		return defaultCaptureBody, nil
 	}
+	mode, err := parseCaptureBody(value)
+	if err != nil {
+		return -1, errors.Errorf("invalid %s value %q", envCaptureBody, value)
+	}
+	return mode, nil
+}
+
+func parseCaptureBody(value string) (CaptureBodyMode, error) {
 	switch strings.TrimSpace(strings.ToLower(value)) {
 	case "all":
 		return CaptureBodyAll, nil
@@ -184,10 +257,61 @@ func initialCaptureBody() (CaptureBodyMode, error) {
 		return CaptureBodyErrors, nil
 	case "transactions":
 		return CaptureBodyTransactions, nil
+	case "failed_transactions":
+		return CaptureBodyFailedTransactions, nil
 	case "off":
 		return CaptureBodyOff, nil
 	}
-	return -1, errors.Errorf("invalid %s value %q", envCaptureBody, value)
+	return -1, errors.Errorf("invalid capture body mode %q", value)
+}
+
+func initialCaptureBodyMaxSize() (int, error) {
+	size, err := apmconfig.ParseSizeEnv(envCaptureBodyMaxSize, defaultCaptureBodyMaxSize)
+	if err != nil {
+		return 0, err
+	}
+	if size <= 0 {
+		return 0, errors.Errorf("%s must be positive", envCaptureBodyMaxSize)
+	}
+	return int(size), nil
+}
+
+func initialCaptureBodyContentTypes() wildcard.Matchers {
+	return apmconfig.ParseWildcardPatternsEnv(envCaptureBodyContentTypes, nil)
+}
+
+// initialGlobalLabels returns the global labels defined in the file
+// named by ELASTIC_APM_GLOBAL_LABELS_FILE, if specified. The file holds
+// one "key=value" pair per line, with blank lines and lines beginning
+// with "#" ignored; this format is suitable for mounting a Kubernetes
+// ConfigMap as a file.
+func initialGlobalLabels() ([]model.StringMapItem, error) {
+	filename := os.Getenv(envGlobalLabelsFile)
+	if filename == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", envGlobalLabelsFile)
+	}
+	var labels []model.StringMapItem
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sep := strings.IndexRune(line, '=')
+		if sep <= 0 {
+			return nil, errors.Errorf(
+				"invalid %s file %q: line %d is not of the form key=value",
+				envGlobalLabelsFile, filename, i+1,
+			)
+		}
+		key := strings.TrimSpace(line[:sep])
+		value := strings.TrimSpace(line[sep+1:])
+		labels = setGlobalLabel(labels, key, value)
+	}
+	return labels, nil
 }
 
 func initialService() (name, version, environment string) {
@@ -208,10 +332,99 @@ func initialSpanFramesMinDuration() (time.Duration, error) {
 	return apmconfig.ParseDurationEnv(envSpanFramesMinDuration, defaultSpanFramesMinDuration)
 }
 
+// initialSpanFramesMinDurationByType parses a comma-separated list of
+// type=duration pairs, overriding the span frames minimum duration for
+// spans of the given types.
+func initialSpanFramesMinDurationByType() (map[string]time.Duration, error) {
+	value := os.Getenv(envSpanFramesMinDurationByType)
+	if value == "" {
+		return nil, nil
+	}
+	durations := make(map[string]time.Duration)
+	for _, item := range apmconfig.ParseList(value, ",") {
+		sep := strings.IndexRune(item, '=')
+		if sep <= 0 {
+			return nil, errors.Errorf(
+				"failed to parse %s: %q is not of the form type=duration",
+				envSpanFramesMinDurationByType, item,
+			)
+		}
+		d, err := apmconfig.ParseDuration(strings.TrimSpace(item[sep+1:]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", envSpanFramesMinDurationByType)
+		}
+		durations[strings.TrimSpace(item[:sep])] = d
+	}
+	return durations, nil
+}
+
+// initialTransactionNameRewriteRules parses a semicolon-separated list of
+// "pattern => replacement" transaction name rewrite rules.
+func initialTransactionNameRewriteRules() ([]TransactionNameRewriteRule, error) {
+	value := os.Getenv(envTransactionNameRewrites)
+	if value == "" {
+		return nil, nil
+	}
+	var rules []TransactionNameRewriteRule
+	for _, item := range apmconfig.ParseList(value, ";") {
+		sep := strings.Index(item, "=>")
+		if sep < 0 {
+			return nil, errors.Errorf(
+				"failed to parse %s: %q is not of the form pattern => replacement",
+				envTransactionNameRewrites, item,
+			)
+		}
+		pattern, err := regexp.Compile(strings.TrimSpace(item[:sep]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", envTransactionNameRewrites)
+		}
+		rules = append(rules, TransactionNameRewriteRule{
+			Pattern:     pattern,
+			Replacement: strings.TrimSpace(item[sep+2:]),
+		})
+	}
+	return rules, nil
+}
+
+func initialExitSpanMinDuration() (time.Duration, error) {
+	return apmconfig.ParseDurationEnv(envExitSpanMinDuration, defaultExitSpanMinDuration)
+}
+
+func initialSpanCompression() (spanCompressionConfig, error) {
+	cfg := spanCompressionConfig{
+		exactMatchMaxDuration: defaultSpanCompressionExactMatchMaxDuration,
+		sameKindMaxDuration:   defaultSpanCompressionSameKindMaxDuration,
+	}
+	enabled, err := apmconfig.ParseBoolEnv(envSpanCompressionEnabled, false)
+	if err != nil {
+		return cfg, err
+	}
+	exactMatchMaxDuration, err := apmconfig.ParseDurationEnv(envSpanCompressionExactMatch, cfg.exactMatchMaxDuration)
+	if err != nil {
+		return cfg, err
+	}
+	sameKindMaxDuration, err := apmconfig.ParseDurationEnv(envSpanCompressionSameKind, cfg.sameKindMaxDuration)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.enabled = enabled
+	cfg.exactMatchMaxDuration = exactMatchMaxDuration
+	cfg.sameKindMaxDuration = sameKindMaxDuration
+	return cfg, nil
+}
+
 func initialActive() (bool, error) {
 	return apmconfig.ParseBoolEnv(envActive, true)
 }
 
+func initialCentralConfig() (bool, error) {
+	return apmconfig.ParseBoolEnv(envCentralConfig, true)
+}
+
+func initialClockSkewCorrection() (bool, error) {
+	return apmconfig.ParseBoolEnv(envClockSkewCorrection, false)
+}
+
 func initialDisabledMetrics() wildcard.Matchers {
 	return apmconfig.ParseWildcardPatternsEnv(envDisableMetrics, nil)
 }
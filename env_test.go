@@ -44,6 +44,10 @@ func TestTracerRequestTimeEnv(t *testing.T) {
 
 	requestHandled := make(chan struct{}, 1)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/intake/v2/events" {
+			// Ignore agent config requests.
+			return
+		}
 		io.Copy(ioutil.Discard, req.Body)
 		requestHandled <- struct{}{}
 	}))
@@ -189,6 +193,30 @@ func testTracerSanitizeFieldNamesEnv(t *testing.T, envValue, expect string) {
 	})
 }
 
+func TestTracerBaggageToAttachEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_BAGGAGE_TO_ATTACH", "tenant,user.*")
+	defer os.Unsetenv("ELASTIC_APM_BAGGAGE_TO_ATTACH")
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		Baggage: []apm.BaggageMember{
+			{Key: "tenant", Value: "acme"},
+			{Key: "user.id", Value: "123"},
+			{Key: "session", Value: "secret"},
+		},
+	})
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "baggage_tenant", Value: "acme"},
+		{Key: "baggage_user_id", Value: "123"},
+	}, payloads.Transactions[0].Context.Tags)
+}
+
 func TestTracerServiceNameEnvSanitizationSpecified(t *testing.T) {
 	_, _, service := getSubprocessMetadata(t, "ELASTIC_APM_SERVICE_NAME=foo!bar")
 	assert.Equal(t, "foo_bar", service.Name)
@@ -207,6 +235,7 @@ func TestTracerCaptureBodyEnv(t *testing.T) {
 func TestTracerCaptureBodyEnvOff(t *testing.T) {
 	t.Run("unset", func(t *testing.T) { testTracerCaptureBodyEnv(t, "", false) })
 	t.Run("off", func(t *testing.T) { testTracerCaptureBodyEnv(t, "off", false) })
+	t.Run("failed_transactions", func(t *testing.T) { testTracerCaptureBodyEnv(t, "failed_transactions", false) })
 }
 
 func TestTracerCaptureBodyEnvInvalid(t *testing.T) {
@@ -240,6 +269,42 @@ func testTracerCaptureBodyEnv(t *testing.T, envValue string, expectBody bool) {
 	}
 }
 
+func TestTracerCaptureBodyMaxSizeEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_CAPTURE_BODY_MAX_SIZE", "4B")
+	defer os.Unsetenv("ELASTIC_APM_CAPTURE_BODY_MAX_SIZE")
+	os.Setenv("ELASTIC_APM_CAPTURE_BODY_CONTENT_TYPES", "text/*")
+	defer os.Unsetenv("ELASTIC_APM_CAPTURE_BODY_CONTENT_TYPES")
+	os.Setenv("ELASTIC_APM_CAPTURE_BODY", "all")
+	defer os.Unsetenv("ELASTIC_APM_CAPTURE_BODY")
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	for _, contentType := range []string{"text/plain", "application/octet-stream"} {
+		req, _ := http.NewRequest("POST", "/", strings.NewReader("foo_bar"))
+		req.Header.Set("Content-Type", contentType)
+		body := tracer.CaptureHTTPRequestBody(req)
+		tx := tracer.StartTransaction("name", "type")
+		tx.Context.SetHTTPRequest(req)
+		tx.Context.SetHTTPRequestBody(body)
+		tx.End()
+	}
+	tracer.Flush(nil)
+
+	transactions := transport.Payloads().Transactions
+	require.Len(t, transactions, 2)
+	require.NotNil(t, transactions[0].Context.Request.Body)
+	assert.Equal(t, "foo_", transactions[0].Context.Request.Body.Raw)
+	assert.Nil(t, transactions[1].Context.Request.Body)
+}
+
+func TestTracerCaptureBodyMaxSizeEnvInvalid(t *testing.T) {
+	os.Setenv("ELASTIC_APM_CAPTURE_BODY_MAX_SIZE", "0B")
+	defer os.Unsetenv("ELASTIC_APM_CAPTURE_BODY_MAX_SIZE")
+	_, err := apm.NewTracer("", "")
+	assert.EqualError(t, err, "ELASTIC_APM_CAPTURE_BODY_MAX_SIZE must be positive")
+}
+
 func TestTracerSpanFramesMinDurationEnv(t *testing.T) {
 	os.Setenv("ELASTIC_APM_SPAN_FRAMES_MIN_DURATION", "10ms")
 	defer os.Unsetenv("ELASTIC_APM_SPAN_FRAMES_MIN_DURATION")
@@ -275,6 +340,144 @@ func TestTracerSpanFramesMinDurationEnvInvalid(t *testing.T) {
 	assert.EqualError(t, err, "failed to parse ELASTIC_APM_SPAN_FRAMES_MIN_DURATION: invalid duration aeon")
 }
 
+func TestTracerSpanFramesMinDurationByTypeEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_SPAN_FRAMES_MIN_DURATION", "60m")
+	defer os.Unsetenv("ELASTIC_APM_SPAN_FRAMES_MIN_DURATION")
+	os.Setenv("ELASTIC_APM_SPAN_FRAMES_MIN_DURATION_BY_TYPE", "db=0ms, cache=10ms")
+	defer os.Unsetenv("ELASTIC_APM_SPAN_FRAMES_MIN_DURATION_BY_TYPE")
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	for _, spanType := range []string{"db.sql", "cache.redis", "external"} {
+		s := tx.StartSpan("name", spanType, nil)
+		s.Duration = 10 * time.Millisecond
+		s.End()
+	}
+	tx.End()
+	tracer.Flush(nil)
+
+	spans := transport.Payloads().Spans
+	require.Len(t, spans, 3)
+	assert.NotEmpty(t, spans[0].Stacktrace)
+	assert.NotEmpty(t, spans[1].Stacktrace)
+	assert.Empty(t, spans[2].Stacktrace)
+}
+
+func TestTracerSpanFramesMinDurationByTypeEnvInvalid(t *testing.T) {
+	os.Setenv("ELASTIC_APM_SPAN_FRAMES_MIN_DURATION_BY_TYPE", "db")
+	defer os.Unsetenv("ELASTIC_APM_SPAN_FRAMES_MIN_DURATION_BY_TYPE")
+
+	_, err := apm.NewTracer("tracer_testing", "")
+	assert.EqualError(t, err, `failed to parse ELASTIC_APM_SPAN_FRAMES_MIN_DURATION_BY_TYPE: "db" is not of the form type=duration`)
+
+	os.Setenv("ELASTIC_APM_SPAN_FRAMES_MIN_DURATION_BY_TYPE", "db=aeon")
+	_, err = apm.NewTracer("tracer_testing", "")
+	assert.EqualError(t, err, "failed to parse ELASTIC_APM_SPAN_FRAMES_MIN_DURATION_BY_TYPE: invalid duration aeon")
+}
+
+func TestTracerTransactionNameRewritesEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_TRANSACTION_NAME_REWRITES", "^GET /[a-z]{2}/ => GET /; /[0-9]+$ => /{id}")
+	defer os.Unsetenv("ELASTIC_APM_TRANSACTION_NAME_REWRITES")
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tracer.StartTransaction("GET /fr/users/123", "request").End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, "GET /users/{id}", payloads.Transactions[0].Name)
+}
+
+func TestTracerTransactionNameRewritesEnvInvalid(t *testing.T) {
+	os.Setenv("ELASTIC_APM_TRANSACTION_NAME_REWRITES", "foo")
+	defer os.Unsetenv("ELASTIC_APM_TRANSACTION_NAME_REWRITES")
+
+	_, err := apm.NewTracer("tracer_testing", "")
+	assert.EqualError(t, err, `failed to parse ELASTIC_APM_TRANSACTION_NAME_REWRITES: "foo" is not of the form pattern => replacement`)
+
+	os.Setenv("ELASTIC_APM_TRANSACTION_NAME_REWRITES", "( => bar")
+	_, err = apm.NewTracer("tracer_testing", "")
+	assert.EqualError(t, err, "failed to parse ELASTIC_APM_TRANSACTION_NAME_REWRITES: error parsing regexp: missing closing ): `(`")
+}
+
+func TestTracerExitSpanMinDurationEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_EXIT_SPAN_MIN_DURATION", "10ms")
+	defer os.Unsetenv("ELASTIC_APM_EXIT_SPAN_MIN_DURATION")
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	s := tx.StartSpanOptions("name", "type", apm.SpanOptions{ExitSpan: true})
+	s.Duration = 9 * time.Millisecond
+	s.End()
+	s = tx.StartSpanOptions("name", "type", apm.SpanOptions{ExitSpan: true})
+	s.Duration = 10 * time.Millisecond
+	s.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	assert.Len(t, payloads.Spans, 1)
+	assert.Equal(t, 1, payloads.Transactions[0].SpanCount.Dropped)
+}
+
+func TestTracerExitSpanMinDurationEnvInvalid(t *testing.T) {
+	os.Setenv("ELASTIC_APM_EXIT_SPAN_MIN_DURATION", "aeon")
+	defer os.Unsetenv("ELASTIC_APM_EXIT_SPAN_MIN_DURATION")
+
+	_, err := apm.NewTracer("tracer_testing", "")
+	assert.EqualError(t, err, "failed to parse ELASTIC_APM_EXIT_SPAN_MIN_DURATION: invalid duration aeon")
+}
+
+func TestTracerSpanCompressionEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_SPAN_COMPRESSION_ENABLED", "true")
+	defer os.Unsetenv("ELASTIC_APM_SPAN_COMPRESSION_ENABLED")
+	os.Setenv("ELASTIC_APM_SPAN_COMPRESSION_EXACT_MATCH_MAX_DURATION", "10ms")
+	defer os.Unsetenv("ELASTIC_APM_SPAN_COMPRESSION_EXACT_MATCH_MAX_DURATION")
+	os.Setenv("ELASTIC_APM_SPAN_COMPRESSION_SAME_KIND_MAX_DURATION", "5ms")
+	defer os.Unsetenv("ELASTIC_APM_SPAN_COMPRESSION_SAME_KIND_MAX_DURATION")
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	for _, d := range []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 11 * time.Millisecond} {
+		s := tx.StartSpanOptions("name", "type", apm.SpanOptions{ExitSpan: true})
+		s.Duration = d
+		s.End()
+	}
+	for _, name := range []string{"a", "b"} {
+		s := tx.StartSpanOptions(name, "type", apm.SpanOptions{ExitSpan: true})
+		s.Duration = 5 * time.Millisecond
+		s.End()
+	}
+	tx.End()
+	tracer.Flush(nil)
+
+	spans := transport.Payloads().Spans
+	require.Len(t, spans, 3)
+	require.NotNil(t, spans[0].Composite)
+	assert.Equal(t, "exact_match", spans[0].Composite.CompressionStrategy)
+	assert.Equal(t, 2, spans[0].Composite.Count)
+	assert.Nil(t, spans[1].Composite)
+	require.NotNil(t, spans[2].Composite)
+	assert.Equal(t, "same_kind", spans[2].Composite.CompressionStrategy)
+	assert.Equal(t, "Calls to type", spans[2].Name)
+}
+
+func TestTracerSpanCompressionEnvInvalid(t *testing.T) {
+	os.Setenv("ELASTIC_APM_SPAN_COMPRESSION_SAME_KIND_MAX_DURATION", "aeon")
+	defer os.Unsetenv("ELASTIC_APM_SPAN_COMPRESSION_SAME_KIND_MAX_DURATION")
+
+	_, err := apm.NewTracer("tracer_testing", "")
+	assert.EqualError(t, err, "failed to parse ELASTIC_APM_SPAN_COMPRESSION_SAME_KIND_MAX_DURATION: invalid duration aeon")
+}
+
 func TestTracerActiveEnv(t *testing.T) {
 	os.Setenv("ELASTIC_APM_ACTIVE", "false")
 	defer os.Unsetenv("ELASTIC_APM_ACTIVE")
@@ -334,3 +537,81 @@ func TestTracerCaptureHeadersEnv(t *testing.T) {
 	assert.Nil(t, tx.Context.Request.Headers)
 	assert.Nil(t, tx.Context.Response.Headers)
 }
+
+func TestTracerGlobalLabelsFileEnv(t *testing.T) {
+	f, err := ioutil.TempFile("", "apm-global-labels")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("# deployment labels\nbuild_sha = abc123\n\ncanary=true\ncanary=false\n")
+	f.Close()
+
+	os.Setenv("ELASTIC_APM_GLOBAL_LABELS_FILE", f.Name())
+	defer os.Unsetenv("ELASTIC_APM_GLOBAL_LABELS_FILE")
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "build_sha", Value: "abc123"},
+		{Key: "canary", Value: "false"},
+	}, payloads.Transactions[0].Context.Tags)
+}
+
+func TestTracerGlobalLabelsFileEnvInvalid(t *testing.T) {
+	f, err := ioutil.TempFile("", "apm-global-labels")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("build_sha=abc123\ncanary\n")
+	f.Close()
+
+	os.Setenv("ELASTIC_APM_GLOBAL_LABELS_FILE", f.Name())
+	defer os.Unsetenv("ELASTIC_APM_GLOBAL_LABELS_FILE")
+
+	_, err = apm.NewTracer("tracer_testing", "")
+	assert.EqualError(t, err, `invalid ELASTIC_APM_GLOBAL_LABELS_FILE file "`+f.Name()+`": line 2 is not of the form key=value`)
+}
+
+func TestResetDefaultTracer(t *testing.T) {
+	os.Setenv("ELASTIC_APM_SERVICE_NAME", "reset_service")
+	os.Setenv("ELASTIC_APM_ENVIRONMENT", "testing")
+	defer apm.ResetDefaultTracer()
+	defer os.Unsetenv("ELASTIC_APM_ENVIRONMENT")
+	defer os.Unsetenv("ELASTIC_APM_SERVICE_NAME")
+
+	old := apm.DefaultTracer
+	tracer := apm.ResetDefaultTracer()
+	assert.Exactly(t, tracer, apm.DefaultTracer)
+	assert.NotEqual(t, old, tracer)
+	assert.Equal(t, "reset_service", tracer.Service.Name)
+	assert.Equal(t, "testing", tracer.Service.Environment)
+	assert.Exactly(t, transport.Default, tracer.Transport)
+}
+
+func TestTracerMaxSpansPerSecondEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_TRANSACTION_MAX_SPANS_PER_SECOND", "1")
+	defer os.Unsetenv("ELASTIC_APM_TRANSACTION_MAX_SPANS_PER_SECOND")
+
+	tracer, err := apm.NewTracer("tracer_testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.End()
+	s0 := tx.StartSpan("name", "type", nil)
+	s1 := tx.StartSpan("name", "type", nil)
+	assert.False(t, s0.Dropped())
+	assert.True(t, s1.Dropped())
+}
+
+func TestTracerMaxSpansPerSecondEnvInvalid(t *testing.T) {
+	os.Setenv("ELASTIC_APM_TRANSACTION_MAX_SPANS_PER_SECOND", "lots")
+	defer os.Unsetenv("ELASTIC_APM_TRANSACTION_MAX_SPANS_PER_SECOND")
+
+	_, err := apm.NewTracer("tracer_testing", "")
+	assert.EqualError(t, err, `failed to parse ELASTIC_APM_TRANSACTION_MAX_SPANS_PER_SECOND: strconv.Atoi: parsing "lots": invalid syntax`)
+}
@@ -23,6 +23,7 @@ import (
 	"net"
 	"os"
 	"reflect"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -133,10 +134,12 @@ func (t *Tracer) newError() *Error {
 		}
 	}
 	e.Timestamp = time.Now()
+	e.clockSkew = t.clockSkewOffset()
 
 	t.captureHeadersMu.RLock()
 	e.Context.captureHeaders = t.captureHeaders
 	t.captureHeadersMu.RUnlock()
+	t.setGlobalLabels(&e.Context)
 
 	return &Error{ErrorData: e}
 }
@@ -165,6 +168,7 @@ type ErrorData struct {
 	log                ErrorLogRecord
 	transactionSampled bool
 	transactionType    string
+	clockSkew          time.Duration
 
 	// exceptionStacktraceFrames holds the number of stacktrace
 	// frames for the exception; stacktrace may hold frames for
@@ -233,14 +237,17 @@ func (e *Error) Error() string {
 // SetTransaction sets TraceID, TransactionID, and ParentID to the transaction's
 // IDs, and records the transaction's Type and whether or not it was sampled.
 func (e *Error) SetTransaction(tx *Transaction) {
+	tx.resolveSampling()
 	tx.mu.RLock()
 	traceContext := tx.traceContext
 	var txType string
 	if !tx.ended() {
 		txType = tx.Type
+		tx.setErrorReported()
 	}
 	tx.mu.RUnlock()
 	e.setSpanData(traceContext, traceContext.Span, txType)
+	setBaggageTags(&e.Context, tx.baggageTags)
 }
 
 // SetSpan sets TraceID, TransactionID, and ParentID to the span's IDs.
@@ -254,10 +261,15 @@ func (e *Error) SetSpan(s *Span) {
 		s.tx.mu.RLock()
 		if !s.tx.ended() {
 			txType = s.tx.Type
+			s.tx.setErrorReported()
 		}
 		s.tx.mu.RUnlock()
 	}
+	atomic.StoreInt32(&s.propagated, 1)
 	e.setSpanData(s.traceContext, s.transactionID, txType)
+	if s.tx != nil {
+		setBaggageTags(&e.Context, s.tx.baggageTags)
+	}
 }
 
 func (e *Error) setSpanData(traceContext TraceContext, transactionID SpanID, transactionType string) {
@@ -294,6 +306,7 @@ func (e *ErrorData) enqueue() {
 		e.tracer.statsMu.Lock()
 		e.tracer.stats.ErrorsDropped++
 		e.tracer.statsMu.Unlock()
+		e.tracer.eventsDropped("error", 1)
 		e.reset()
 	}
 }
@@ -19,6 +19,7 @@ package apm_test
 
 import (
 	"context"
+	"fmt"
 	"html/template"
 	"os"
 
@@ -92,3 +93,45 @@ elasticApm.init({
 	// })
 	// </script>
 }
+
+func ExampleTraceContextToMap() {
+	// job is a background job, which is stored in a database
+	// by a web request handler, and later processed by a worker.
+	type job struct {
+		Args         string
+		TraceContext map[string]string
+	}
+
+	// In the web request handler, record the trace context of
+	// the request's transaction in the job.
+	enqueue := func(ctx context.Context, args string) job {
+		tx := apm.TransactionFromContext(ctx)
+		return job{Args: args, TraceContext: apm.TraceContextToMap(tx.TraceContext())}
+	}
+
+	// In the worker, start a transaction which continues the trace of
+	// the request that enqueued the job. Alternatively, to start a new
+	// trace linked to the request's trace, pass the trace context in
+	// TransactionOptions.Links.
+	process := func(j job) {
+		var opts apm.TransactionOptions
+		if traceContext, err := apm.TraceContextFromMap(j.TraceContext); err == nil {
+			opts.TraceContext = traceContext
+		}
+		tx := apm.DefaultTracer.StartTransactionOptions("process_job", "job", opts)
+		defer tx.End()
+		fmt.Println(tx.TraceContext().Trace)
+	}
+
+	tx := apm.DefaultTracer.StartTransactionOptions("GET /", "request", apm.TransactionOptions{
+		TraceContext: apm.TraceContext{
+			Trace: apm.TraceID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+			Span:  apm.SpanID{0, 1, 2, 3, 4, 5, 6, 7},
+		},
+	})
+	defer tx.Discard()
+	process(enqueue(apm.ContextWithTransaction(context.Background(), tx), "args"))
+
+	// Output:
+	// 000102030405060708090a0b0c0d0e0f
+}
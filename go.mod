@@ -1,18 +1,29 @@
 module go.elastic.co/apm
 
+go 1.27.1
+
 require (
 	github.com/armon/go-radix v1.0.0
-	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/elastic/go-sysinfo v0.0.0-20190103140604-e68552284485
-	github.com/elastic/go-windows v0.0.0-20180831131045-bb1581babc04 // indirect
 	github.com/google/go-cmp v0.2.0
-	github.com/joeshaw/multierror v0.0.0-20140124173710-69b34d4ec901 // indirect
 	github.com/pkg/errors v0.8.0
-	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/prometheus/procfs v0.0.0-20181005140218-185b4288413d // indirect
 	github.com/santhosh-tekuri/jsonschema v1.2.3
 	github.com/stretchr/testify v1.2.2
 	go.elastic.co/fastjson v1.0.0
 	golang.org/x/sys v0.0.0-20190102155601-82a175fd1598
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/elastic/go-windows v0.0.0-20180831131045-bb1581babc04 // indirect
+	github.com/jessevdk/go-flags v1.4.0 // indirect
+	github.com/joeshaw/multierror v0.0.0-20140124173710-69b34d4ec901 // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/pty v1.1.1 // indirect
+	github.com/kr/text v0.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/prometheus/procfs v0.0.0-20181005140218-185b4288413d // indirect
+	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	gopkg.in/yaml.v2 v2.2.1 // indirect
 	howett.net/plist v0.0.0-20181124034731-591f970eefbb // indirect
 )
@@ -0,0 +1,65 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"encoding/binary"
+)
+
+// IDGenerator is an interface for generating trace and span IDs,
+// which may be registered with Tracer.SetIDGenerator.
+//
+// IDGenerator implementations must be goroutine-safe, and should
+// return IDs that are valid (non-zero) and unique with high
+// probability. Invalid IDs will be replaced with randomly generated
+// ones.
+type IDGenerator interface {
+	// NewTraceID returns a new trace ID.
+	NewTraceID() TraceID
+
+	// NewSpanID returns a new span ID. Span IDs
+	// are used for both transactions and spans.
+	NewSpanID() SpanID
+}
+
+// newTraceID returns a new trace ID, using td's IDGenerator if it
+// has one, and otherwise generating a random trace ID.
+func (td *TransactionData) newTraceID() TraceID {
+	if td.idGenerator != nil {
+		if id := td.idGenerator.NewTraceID(); id.Validate() == nil {
+			return id
+		}
+	}
+	var id TraceID
+	binary.LittleEndian.PutUint64(id[:8], td.rand.Uint64())
+	binary.LittleEndian.PutUint64(id[8:], td.rand.Uint64())
+	return id
+}
+
+// newSpanID returns a new span ID, using td's IDGenerator if it
+// has one, and otherwise generating a random span ID.
+func (td *TransactionData) newSpanID() SpanID {
+	if td.idGenerator != nil {
+		if id := td.idGenerator.NewSpanID(); id.Validate() == nil {
+			return id
+		}
+	}
+	var id SpanID
+	binary.LittleEndian.PutUint64(id[:], td.rand.Uint64())
+	return id
+}
@@ -18,12 +18,14 @@
 package apmlog
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.elastic.co/fastjson"
@@ -74,7 +76,23 @@ func initDefaultLogger() {
 			logLevel = level
 		}
 	}
-	DefaultLogger = levelLogger{w: logWriter, level: logLevel}
+	DefaultLogger = &levelLogger{w: logWriter, level: uint32(logLevel)}
+}
+
+// SetDefaultLoggerLevel sets the level of DefaultLogger, returning the
+// previous level. An error is returned if the level is invalid, or if
+// DefaultLogger was not initialized from ELASTIC_APM_LOG_FILE.
+func SetDefaultLoggerLevel(level string) (previous string, err error) {
+	l, ok := DefaultLogger.(*levelLogger)
+	if !ok {
+		return "", errors.New("default logger is not configured")
+	}
+	newLevel, err := parseLogLevel(level)
+	if err != nil {
+		return "", err
+	}
+	oldLevel := atomic.SwapUint32(&l.level, uint32(newLevel))
+	return logLevel(oldLevel).String(), nil
 }
 
 const (
@@ -123,21 +141,21 @@ type Logger interface {
 
 type levelLogger struct {
 	w     io.Writer
-	level logLevel
+	level uint32 // logLevel
 }
 
 // Debugf logs a message with log.Printf, with a DEBUG prefix.
-func (l levelLogger) Debugf(format string, args ...interface{}) {
+func (l *levelLogger) Debugf(format string, args ...interface{}) {
 	l.logf(debugLevel, format, args...)
 }
 
 // Errorf logs a message with log.Printf, with an ERROR prefix.
-func (l levelLogger) Errorf(format string, args ...interface{}) {
+func (l *levelLogger) Errorf(format string, args ...interface{}) {
 	l.logf(errorLevel, format, args...)
 }
 
-func (l levelLogger) logf(level logLevel, format string, args ...interface{}) {
-	if level < l.level {
+func (l *levelLogger) logf(level logLevel, format string, args ...interface{}) {
+	if level < logLevel(atomic.LoadUint32(&l.level)) {
 		return
 	}
 	jw := fastjsonPool.Get().(*fastjson.Writer)
@@ -177,3 +177,31 @@ func BenchmarkDefaultLogger(b *testing.B) {
 		DefaultLogger.Errorf("debug message")
 	}
 }
+
+func TestSetDefaultLoggerLevel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	DefaultLogger = nil
+	_, err = SetDefaultLoggerLevel("debug")
+	assert.EqualError(t, err, "default logger is not configured")
+
+	os.Setenv("ELASTIC_APM_LOG_FILE", filepath.Join(dir, "log.json"))
+	defer os.Unsetenv("ELASTIC_APM_LOG_FILE")
+	initDefaultLogger()
+	DefaultLogger.Debugf("before")
+
+	previous, err := SetDefaultLoggerLevel("debug")
+	require.NoError(t, err)
+	assert.Equal(t, "error", previous)
+	DefaultLogger.Debugf("after")
+
+	_, err = SetDefaultLoggerLevel("verbose")
+	assert.EqualError(t, err, `invalid log level string "verbose"`)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "log.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "before")
+	assert.Regexp(t, `{"level":"debug","time":".*","message":"after"}`, string(data))
+}
@@ -0,0 +1,52 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package apmnetutil provides utilities for resolving the network
+// addresses recorded in span destination context.
+package apmnetutil
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SplitHostPort splits addr into host and port, returning false if addr
+// does not identify a single network host. Strings holding multiple
+// comma-separated hosts, such as seedlists, are rejected so that they
+// are not recorded as a destination address.
+//
+// If addr has no port, or the port is invalid, defaultPort is returned.
+func SplitHostPort(addr string, defaultPort int) (host string, port int, ok bool) {
+	if addr == "" || strings.IndexRune(addr, ',') != -1 {
+		return "", 0, false
+	}
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		// Assume addr has no port. Strip the brackets from an
+		// IPv6 address, as net.SplitHostPort would have.
+		return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), defaultPort, true
+	}
+	if host == "" {
+		return "", 0, false
+	}
+	port, err = strconv.Atoi(portString)
+	if err != nil || port <= 0 {
+		port = defaultPort
+	}
+	return host, port, true
+}
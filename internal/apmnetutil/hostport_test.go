@@ -0,0 +1,46 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmnetutil_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.elastic.co/apm/internal/apmnetutil"
+)
+
+func TestSplitHostPort(t *testing.T) {
+	type result struct {
+		host string
+		port int
+		ok   bool
+	}
+	test := func(addr string, expect result) {
+		host, port, ok := apmnetutil.SplitHostPort(addr, 1234)
+		assert.Equal(t, expect, result{host, port, ok}, addr)
+	}
+	test("", result{})
+	test("localhost", result{"localhost", 1234, true})
+	test("localhost:5432", result{"localhost", 5432, true})
+	test("localhost:x", result{"localhost", 1234, true})
+	test("[::1]:27017", result{"::1", 27017, true})
+	test("[::1]", result{"::1", 1234, true})
+	test(":5432", result{})
+	test("host1:27017,host2:27017", result{})
+}
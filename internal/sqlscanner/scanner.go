@@ -49,6 +49,12 @@ func (s *Scanner) Text() string {
 	return s.input[s.start:s.end]
 }
 
+// Pos returns the byte offset within the input of the
+// most recently scanned token.
+func (s *Scanner) Pos() int {
+	return s.start
+}
+
 // Scan scans for the next token and returns true if one was
 // found, false if the end of the input stream was reached.
 // When Scan returns true, the token type can be obtained by
@@ -0,0 +1,109 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sqlutil
+
+import (
+	"strconv"
+	"strings"
+
+	"go.elastic.co/apm/internal/apmstrings"
+	"go.elastic.co/apm/internal/sqlscanner"
+)
+
+// MaxInsertSummaryLength is the maximum length, in runes,
+// of the summaries returned by SummarizeInsert.
+const MaxInsertSummaryLength = 1024
+
+// SummarizeInsert returns a summary of a multi-row INSERT or REPLACE
+// statement, in which the rows of the VALUES clause are replaced with
+// a placeholder and a row count, e.g.
+//
+//	INSERT INTO t (a, b) VALUES (...) x1000
+//
+// Any clause following the rows, such as ON CONFLICT or RETURNING, is
+// retained. The summary is truncated to MaxInsertSummaryLength runes.
+//
+// If query is not an INSERT or REPLACE statement with more than one
+// row in its VALUES clause, SummarizeInsert returns false.
+func SummarizeInsert(query string) (summary string, rows int, ok bool) {
+	s := sqlscanner.NewScanner(query)
+	for s.Scan() {
+		if s.Token() != sqlscanner.COMMENT {
+			break
+		}
+	}
+	switch s.Token() {
+	case sqlscanner.INSERT, sqlscanner.REPLACE:
+	default:
+		return "", 0, false
+	}
+
+	// Scan for the VALUES keyword, which the scanner
+	// reports as an identifier, outside of any column
+	// list or subquery.
+	var level int
+	prefixEnd := -1
+	for prefixEnd < 0 && s.Scan() {
+		switch s.Token() {
+		case sqlscanner.LPAREN:
+			level++
+		case sqlscanner.RPAREN:
+			level--
+		case sqlscanner.IDENT:
+			if level == 0 && strings.EqualFold(s.Text(), "VALUES") {
+				prefixEnd = s.Pos() + len(s.Text())
+			}
+		}
+	}
+	if prefixEnd < 0 {
+		return "", 0, false
+	}
+
+	// Count the parenthesised rows, stopping at the
+	// first token outside of a row other than a comma.
+	var tail string
+	level = 0
+scanLoop:
+	for s.Scan() {
+		switch s.Token() {
+		case sqlscanner.COMMENT:
+		case sqlscanner.LPAREN:
+			if level == 0 {
+				rows++
+			}
+			level++
+		case sqlscanner.RPAREN:
+			level--
+		default:
+			// The scanner reports commas as OTHER.
+			if level == 0 && s.Text() != "," {
+				tail = query[s.Pos():]
+				break scanLoop
+			}
+		}
+	}
+	if rows < 2 {
+		return "", 0, false
+	}
+
+	summary = query[:prefixEnd] + " (...) x" + strconv.Itoa(rows)
+	if tail = strings.TrimSpace(tail); tail != "" {
+		summary += " " + tail
+	}
+	return apmstrings.Truncate(summary, MaxInsertSummaryLength), rows, true
+}
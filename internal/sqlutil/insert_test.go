@@ -0,0 +1,77 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sqlutil_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.elastic.co/apm/internal/sqlutil"
+)
+
+func TestSummarizeInsert(t *testing.T) {
+	for _, test := range []struct {
+		input   string
+		summary string
+		rows    int
+	}{{
+		input:   "INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y'), (3, 'z')",
+		summary: "INSERT INTO t (a, b) VALUES (...) x3",
+		rows:    3,
+	}, {
+		input:   "/* batch */ insert into foo.bar values (1,(2)),(?, ?)",
+		summary: "/* batch */ insert into foo.bar values (...) x2",
+		rows:    2,
+	}, {
+		input:   "REPLACE INTO t VALUES ($1, '),('), ($2, 'values') RETURNING id",
+		summary: "REPLACE INTO t VALUES (...) x2 RETURNING id",
+		rows:    2,
+	}, {
+		input:   "INSERT INTO t (a) VALUES (1), (2) ON CONFLICT (a) DO NOTHING",
+		summary: "INSERT INTO t (a) VALUES (...) x2 ON CONFLICT (a) DO NOTHING",
+		rows:    2,
+	}} {
+		summary, rows, ok := sqlutil.SummarizeInsert(test.input)
+		assert.True(t, ok, test.input)
+		assert.Equal(t, test.summary, summary)
+		assert.Equal(t, test.rows, rows)
+	}
+}
+
+func TestSummarizeInsertUnchanged(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"SELECT * FROM t WHERE a IN ((1), (2))",
+		"INSERT INTO t (a, b) VALUES (1, 2)",
+		"INSERT INTO t (a) SELECT a FROM u",
+		"UPDATE t SET a = 1",
+	} {
+		_, _, ok := sqlutil.SummarizeInsert(input)
+		assert.False(t, ok, input)
+	}
+}
+
+func TestSummarizeInsertTruncated(t *testing.T) {
+	columns := strings.Repeat("column, ", 200)
+	summary, rows, ok := sqlutil.SummarizeInsert("INSERT INTO t (" + columns + ") VALUES (1), (2)")
+	assert.True(t, ok)
+	assert.Equal(t, 2, rows)
+	assert.Len(t, summary, sqlutil.MaxInsertSummaryLength)
+}
@@ -0,0 +1,146 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package tracecontext provides functions for formatting and parsing
+// trace context in the W3C Trace-Context formats, shared by the tracer
+// and the instrumentation modules.
+package tracecontext
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	errZeroTraceID = errors.New("zero trace-id is invalid")
+	errZeroSpanID  = errors.New("zero span-id is invalid")
+)
+
+// Traceparent holds the fields of a W3C Trace-Context traceparent.
+type Traceparent struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// String returns the traceparent in the version 00 format.
+func (p Traceparent) String() string {
+	return fmt.Sprintf("%02x-%032x-%016x-%02x", 0, p.TraceID[:], p.SpanID[:], p.Flags)
+}
+
+// TracestateEntry holds a W3C Trace-Context tracestate list member.
+type TracestateEntry struct {
+	Key   string
+	Value string
+}
+
+// ParseTraceparent parses s in the W3C Trace-Context traceparent format:
+//     https://w3c.github.io/trace-context/#traceparent-header
+//
+// As with the version 00 format, later versions are expected to start
+// with version, trace-id, parent-id and trace-flags fields. An error is
+// returned if the trace-id or parent-id is zero.
+func ParseTraceparent(s string) (Traceparent, error) {
+	var out Traceparent
+	if len(s) < 3 || s[2] != '-' {
+		return out, errors.Errorf("invalid traceparent header %q", s)
+	}
+	var version byte
+	if !strings.HasPrefix(s, "00") {
+		decoded, err := hex.DecodeString(s[:2])
+		if err != nil {
+			return out, errors.Wrap(err, "error decoding traceparent header version")
+		}
+		version = decoded[0]
+	}
+	if version == 255 {
+		// "Version 255 is invalid."
+		return out, errors.Errorf("traceparent header version 255 is forbidden")
+	}
+
+	// Version 00, which higher versions are parsed as:
+	//
+	//     version-format   = trace-id "-" parent-id "-" trace-flags
+	//     trace-id         = 32HEXDIG
+	//     parent-id        = 16HEXDIG
+	//     trace-flags      = 2HEXDIG
+	const (
+		traceIDStart = 3
+		traceIDEnd   = traceIDStart + 32
+		spanIDStart  = traceIDEnd + 1
+		spanIDEnd    = spanIDStart + 16
+		flagsStart   = spanIDEnd + 1
+		flagsEnd     = flagsStart + 2
+	)
+	switch {
+	case len(s) < flagsEnd,
+		s[traceIDEnd] != '-',
+		s[spanIDEnd] != '-',
+		version == 0 && len(s) != flagsEnd,
+		version > 0 && len(s) > flagsEnd && s[flagsEnd] != '-':
+		return out, errors.Errorf("invalid version %d traceparent header %q", version, s)
+	}
+	if _, err := hex.Decode(out.TraceID[:], []byte(s[traceIDStart:traceIDEnd])); err != nil {
+		return out, errors.Wrapf(err, "error decoding trace-id for version %d", version)
+	}
+	if out.TraceID == [16]byte{} {
+		return out, errors.Wrap(errZeroTraceID, "invalid trace-id")
+	}
+	if _, err := hex.Decode(out.SpanID[:], []byte(s[spanIDStart:spanIDEnd])); err != nil {
+		return out, errors.Wrapf(err, "error decoding span-id for version %d", version)
+	}
+	if out.SpanID == [8]byte{} {
+		return out, errors.Wrap(errZeroSpanID, "invalid span-id")
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(s[flagsStart:flagsEnd])); err != nil {
+		return out, errors.Wrapf(err, "error decoding trace-options for version %d", version)
+	}
+	out.Flags = flags[0]
+	return out, nil
+}
+
+// ParseTracestate parses the given values in the W3C Trace-Context
+// tracestate format:
+//     https://w3c.github.io/trace-context/#tracestate-header
+//
+// Multiple values are combined in order, as if they were a single
+// comma-separated list. Empty list members are ignored. The keys and
+// values of the entries are not validated.
+func ParseTracestate(values ...string) ([]TracestateEntry, error) {
+	var entries []TracestateEntry
+	for _, value := range values {
+		for _, member := range strings.Split(value, ",") {
+			member = strings.TrimSpace(member)
+			if member == "" {
+				continue
+			}
+			equal := strings.IndexRune(member, '=')
+			if equal == -1 {
+				return nil, errors.Errorf("missing '=' in tracestate entry %q", member)
+			}
+			entries = append(entries, TracestateEntry{
+				Key:   member[:equal],
+				Value: member[equal+1:],
+			})
+		}
+	}
+	return entries, nil
+}
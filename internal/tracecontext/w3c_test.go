@@ -0,0 +1,57 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tracecontext_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/internal/tracecontext"
+)
+
+func TestTraceparent(t *testing.T) {
+	const s = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	p, err := tracecontext.ParseTraceparent(s)
+	require.NoError(t, err)
+	assert.Equal(t, tracecontext.Traceparent{
+		TraceID: [16]byte{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+		SpanID:  [8]byte{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+		Flags:   1,
+	}, p)
+	assert.Equal(t, s, p.String())
+
+	_, err = tracecontext.ParseTraceparent("00-00000000000000000000000000000000-b7ad6b7169203331-01")
+	assert.EqualError(t, err, "invalid trace-id: zero trace-id is invalid")
+	_, err = tracecontext.ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01")
+	assert.EqualError(t, err, "invalid span-id: zero span-id is invalid")
+}
+
+func TestParseTracestate(t *testing.T) {
+	entries, err := tracecontext.ParseTracestate("a=1, b=2", "", "c=")
+	require.NoError(t, err)
+	assert.Equal(t, []tracecontext.TracestateEntry{
+		{Key: "a", Value: "1"},
+		{Key: "b", Value: "2"},
+		{Key: "c", Value: ""},
+	}, entries)
+
+	_, err = tracecontext.ParseTracestate("a")
+	assert.EqualError(t, err, `missing '=' in tracestate entry "a"`)
+}
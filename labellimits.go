@@ -0,0 +1,138 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"strconv"
+
+	"go.elastic.co/apm/internal/apmstrings"
+	"go.elastic.co/apm/model"
+)
+
+const (
+	defaultLabelMaxKeyLength   = 1024
+	defaultLabelMaxValueLength = 1024
+)
+
+var defaultLabelLimits = LabelLimits{
+	MaxKeyLength:   defaultLabelMaxKeyLength,
+	MaxValueLength: defaultLabelMaxValueLength,
+}
+
+// LabelLimits holds the limits applied to labels (tags) recorded for
+// transactions, spans, and errors when they are sent to the APM Server.
+type LabelLimits struct {
+	// MaxCount holds the maximum number of labels recorded for each
+	// transaction, span, or error. Labels beyond the limit, in the
+	// order in which they were set, are dropped. Global labels are
+	// set before any others. If MaxCount is not positive, the number
+	// of labels is unlimited.
+	MaxCount int
+
+	// MaxKeyLength holds the maximum length of label keys, in
+	// characters. Longer keys are truncated. If MaxKeyLength is
+	// not positive, key length is unlimited.
+	MaxKeyLength int
+
+	// MaxValueLength holds the maximum length of label values, in
+	// characters. Longer values are truncated. Label values are
+	// always truncated to 10000 characters when they are set; if
+	// MaxValueLength is not positive, that is the only limit.
+	//
+	// Some versions of APM Server reject label values longer than
+	// 1024 characters.
+	MaxValueLength int
+}
+
+// SetLabelLimits sets the limits applied to the labels of transactions,
+// spans, and errors sent to the APM Server. Labels which exceed the limits
+// are recorded in TracerStats.LabelsTruncated and TracerStats.LabelsDropped.
+//
+// By default, the number of labels is unlimited, and label keys and values
+// are truncated to 1024 characters.
+func (t *Tracer) SetLabelLimits(limits LabelLimits) {
+	t.labelLimitsMu.Lock()
+	t.labelLimits = limits
+	t.labelLimitsMu.Unlock()
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.labelLimits = limits
+	})
+}
+
+// updateLabelLimits calls f with the tracer's label limits, and then
+// sets them with SetLabelLimits. updateLabelLimits returns the limits
+// in effect before the update.
+func (t *Tracer) updateLabelLimits(f func(*LabelLimits)) LabelLimits {
+	t.labelLimitsMu.Lock()
+	local := t.labelLimits
+	limits := local
+	f(&limits)
+	t.labelLimits = limits
+	t.labelLimitsMu.Unlock()
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.labelLimits = limits
+	})
+	return local
+}
+
+// remoteLabelLimit returns a remote config option which sets the label
+// limit referenced by field, restoring the local value when removed.
+func remoteLabelLimit(field func(*LabelLimits) *int) func(t *Tracer, value string) (func(), error) {
+	return func(t *Tracer, value string) (func(), error) {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		local := t.updateLabelLimits(func(limits *LabelLimits) {
+			*field(limits) = n
+		})
+		return func() {
+			t.updateLabelLimits(func(limits *LabelLimits) {
+				*field(limits) = *field(&local)
+			})
+		}, nil
+	}
+}
+
+// applyLabelLimits applies limits to tags in place, returning the
+// limited tags, and records truncated and dropped labels in stats.
+func applyLabelLimits(tags model.StringMap, limits LabelLimits, stats *TracerStats) model.StringMap {
+	if limits.MaxCount > 0 && len(tags) > limits.MaxCount {
+		stats.LabelsDropped += uint64(len(tags) - limits.MaxCount)
+		tags = tags[:limits.MaxCount]
+	}
+	for i, tag := range tags {
+		var truncated bool
+		if limits.MaxKeyLength > 0 {
+			if key := apmstrings.Truncate(tag.Key, limits.MaxKeyLength); len(key) != len(tag.Key) {
+				tags[i].Key = key
+				truncated = true
+			}
+		}
+		if limits.MaxValueLength > 0 {
+			if value := apmstrings.Truncate(tag.Value, limits.MaxValueLength); len(value) != len(tag.Value) {
+				tags[i].Value = value
+				truncated = true
+			}
+		}
+		if truncated {
+			stats.LabelsTruncated++
+		}
+	}
+	return tags
+}
@@ -0,0 +1,155 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestLabelLimitsDefault(t *testing.T) {
+	long := strings.Repeat("x", 2000)
+	tx, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		tx := apm.TransactionFromContext(ctx)
+		tx.Context.SetTag(long, long)
+		span, _ := apm.StartSpan(ctx, "name", "type")
+		span.Context.SetTag("key", long)
+		span.End()
+		e := apm.CaptureError(ctx, errors.New("boom"))
+		e.Context.SetTag("key", long)
+		e.Send()
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, model.StringMap{{Key: long[:1024], Value: long[:1024]}}, tx.Context.Tags)
+	assert.Equal(t, model.StringMap{{Key: "key", Value: long[:1024]}}, spans[0].Context.Tags)
+	assert.Equal(t, model.StringMap{{Key: "key", Value: long[:1024]}}, errs[0].Context.Tags)
+}
+
+func TestTracerSetLabelLimits(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetLabelLimits(apm.LabelLimits{
+		MaxCount:       2,
+		MaxKeyLength:   3,
+		MaxValueLength: 0,
+	})
+
+	long := strings.Repeat("x", 20000)
+	tx := tracer.StartTransaction("name", "type")
+	tx.Context.SetTag("key", long)
+	tx.Context.SetTag("long_key", "value")
+	tx.Context.SetTag("dropped", "value")
+	span := tx.StartSpan("name", "type", nil)
+	span.Context.SetTag("abc", "value")
+	span.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "key", Value: long[:10000]},
+		{Key: "lon", Value: "value"},
+	}, payloads.Transactions[0].Context.Tags)
+	assert.Equal(t, model.StringMap{{Key: "abc", Value: "value"}}, payloads.Spans[0].Context.Tags)
+
+	stats := tracer.Stats()
+	assert.Equal(t, uint64(1), stats.LabelsTruncated)
+	assert.Equal(t, uint64(1), stats.LabelsDropped)
+}
+
+func TestTracerLabelLimitsEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_LABEL_MAX_COUNT", "1")
+	defer os.Unsetenv("ELASTIC_APM_LABEL_MAX_COUNT")
+	os.Setenv("ELASTIC_APM_LABEL_MAX_VALUE_LENGTH", "5000")
+	defer os.Unsetenv("ELASTIC_APM_LABEL_MAX_VALUE_LENGTH")
+
+	long := strings.Repeat("x", 6000)
+	tx, _, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		tx := apm.TransactionFromContext(ctx)
+		tx.Context.SetTag("one", long)
+		tx.Context.SetTag("two", "value")
+	})
+	assert.Equal(t, model.StringMap{{Key: "one", Value: long[:5000]}}, tx.Context.Tags)
+}
+
+func TestTracerLabelLimitsEnvInvalid(t *testing.T) {
+	os.Setenv("ELASTIC_APM_LABEL_MAX_KEY_LENGTH", "long")
+	defer os.Unsetenv("ELASTIC_APM_LABEL_MAX_KEY_LENGTH")
+
+	_, err := apm.NewTracer("tracer_testing", "")
+	assert.EqualError(t, err, `failed to parse ELASTIC_APM_LABEL_MAX_KEY_LENGTH: strconv.Atoi: parsing "long": invalid syntax`)
+}
+
+func TestTracerLabelLimitsConfigWatcher(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetLabelLimits(apm.LabelLimits{MaxKeyLength: 2})
+
+	watcher := newConfigWatcher()
+	tracer.SetConfigWatcher(watcher)
+	changed := make(chan map[string]string)
+	tracer.RegisterConfigChangeHandler(func(attrs map[string]string) {
+		changed <- attrs
+	})
+	sendTransaction := func() {
+		tx := tracer.StartTransaction("name", "type")
+		tx.Context.SetTag("one", "value")
+		tx.Context.SetTag("two", "value")
+		tx.End()
+	}
+
+	watcher.changes <- transport.ConfigChange{Attrs: map[string]string{
+		"label_max_count":        "1",
+		"label_max_value_length": "3",
+	}}
+	<-changed
+	sendTransaction()
+
+	// Removing an attribute restores only the corresponding local limit.
+	watcher.changes <- transport.ConfigChange{Attrs: map[string]string{
+		"label_max_value_length": "3",
+	}}
+	<-changed
+	sendTransaction()
+
+	tracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, model.StringMap{
+		{Key: "on", Value: "val"},
+	}, payloads.Transactions[0].Context.Tags)
+	assert.Equal(t, model.StringMap{
+		{Key: "on", Value: "val"},
+		{Key: "tw", Value: "val"},
+	}, payloads.Transactions[1].Context.Tags)
+}
@@ -0,0 +1,89 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import "go.elastic.co/apm/model"
+
+// SpanLink holds a link from a transaction or span to another span or
+// transaction, which may be in another trace. Links are used to record
+// causal relationships that are not parent/child relationships, such
+// as a batch-processing transaction handling messages produced in
+// several traces.
+type SpanLink struct {
+	// Trace holds the ID of the linked span's trace.
+	Trace TraceID
+
+	// Span holds the ID of the linked span or transaction.
+	Span SpanID
+}
+
+// TraceContextCarrier is the interface implemented by values which carry
+// a propagated trace context, such as messages received by a consumer.
+type TraceContextCarrier interface {
+	// TraceContext returns the trace context carried by the value,
+	// and whether it carries one.
+	TraceContext() (TraceContext, bool)
+}
+
+// TraceContextCarrierFunc is a function type implementing TraceContextCarrier.
+type TraceContextCarrierFunc func() (TraceContext, bool)
+
+// TraceContext returns f().
+func (f TraceContextCarrierFunc) TraceContext() (TraceContext, bool) {
+	return f()
+}
+
+// LinksFromCarriers returns a SpanLink for each of carriers carrying a
+// valid trace context, in order, for passing in TransactionOptions.Links
+// or SpanOptions.Links. Carriers without a trace context are skipped,
+// as are duplicate links, such as for messages produced by the same span.
+//
+// LinksFromCarriers is intended for messaging consumers which process
+// messages in batches, so that a single transaction for the batch can
+// be linked to the trace of each message.
+func LinksFromCarriers(carriers []TraceContextCarrier) []SpanLink {
+	var links []SpanLink
+	seen := make(map[SpanLink]bool, len(carriers))
+	for _, carrier := range carriers {
+		traceContext, ok := carrier.TraceContext()
+		if !ok || traceContext.Trace.Validate() != nil || traceContext.Span.Validate() != nil {
+			continue
+		}
+		link := SpanLink{Trace: traceContext.Trace, Span: traceContext.Span}
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// buildModelLinks returns links as model.SpanLinks, or nil if links is empty.
+func buildModelLinks(links []SpanLink) []model.SpanLink {
+	if len(links) == 0 {
+		return nil
+	}
+	out := make([]model.SpanLink, len(links))
+	for i, link := range links {
+		out[i] = model.SpanLink{
+			TraceID: model.TraceID(link.Trace),
+			SpanID:  model.SpanID(link.Span),
+		}
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestLinksFromCarriers(t *testing.T) {
+	traceContext1 := apm.TraceContext{
+		Trace: apm.TraceID{1},
+		Span:  apm.SpanID{1},
+	}
+	traceContext2 := apm.TraceContext{
+		Trace: apm.TraceID{2},
+		Span:  apm.SpanID{2},
+	}
+	carrier := func(traceContext apm.TraceContext, ok bool) apm.TraceContextCarrier {
+		return apm.TraceContextCarrierFunc(func() (apm.TraceContext, bool) {
+			return traceContext, ok
+		})
+	}
+
+	links := apm.LinksFromCarriers([]apm.TraceContextCarrier{
+		carrier(traceContext1, true),
+		carrier(apm.TraceContext{}, false),
+		carrier(traceContext2, true),
+		carrier(traceContext1, true),      // duplicate
+		carrier(apm.TraceContext{}, true), // invalid
+	})
+	assert.Equal(t, []apm.SpanLink{
+		{Trace: traceContext1.Trace, Span: traceContext1.Span},
+		{Trace: traceContext2.Trace, Span: traceContext2.Span},
+	}, links)
+
+	assert.Nil(t, apm.LinksFromCarriers(nil))
+}
+
+func TestTransactionSpanLinks(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	links := []apm.SpanLink{
+		{Trace: apm.TraceID{1}, Span: apm.SpanID{1}},
+		{Trace: apm.TraceID{2}, Span: apm.SpanID{2}},
+	}
+	tx := tracer.StartTransactionOptions("batch", "messaging", apm.TransactionOptions{Links: links})
+	tx.StartSpanOptions("message", "app", apm.SpanOptions{Links: links[1:]}).End()
+	tx.StartSpan("other", "app", nil).End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 2)
+	assert.Equal(t, []model.SpanLink{
+		{TraceID: model.TraceID{1}, SpanID: model.SpanID{1}},
+		{TraceID: model.TraceID{2}, SpanID: model.SpanID{2}},
+	}, payloads.Transactions[0].Links)
+	assert.Equal(t, []model.SpanLink{
+		{TraceID: model.TraceID{2}, SpanID: model.SpanID{2}},
+	}, payloads.Spans[0].Links)
+	assert.Nil(t, payloads.Spans[1].Links)
+}
@@ -0,0 +1,378 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/model"
+)
+
+// Meter creates instruments for recording application metrics, which
+// are accumulated between metrics gathering intervals and reported
+// along with the tracer's built-in metrics.
+//
+// Instruments are identified by name: calling Counter, Gauge, or
+// Histogram again with the same name returns the existing instrument.
+// Each instrument records a series of values per set of labels.
+//
+// Meter implements MetricsGatherer. The Meter returned by Tracer.Meter
+// is registered with the tracer; other Meters must be registered using
+// Tracer.RegisterMetricsGatherer for their metrics to be reported. The
+// zero value is ready to use.
+type Meter struct {
+	mu         sync.Mutex
+	counters   map[string]*Counter
+	gauges     map[string]*Gauge
+	histograms map[string]*Histogram
+}
+
+// Counter returns the Counter with the given name, creating it if it
+// does not already exist.
+func (m *Meter) Counter(name string) *Counter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.counters[name]
+	if !ok {
+		if m.counters == nil {
+			m.counters = make(map[string]*Counter)
+		}
+		c = &Counter{name: name}
+		m.counters[name] = c
+	}
+	return c
+}
+
+// Gauge returns the Gauge with the given name, creating it if it
+// does not already exist.
+func (m *Meter) Gauge(name string) *Gauge {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.gauges[name]
+	if !ok {
+		if m.gauges == nil {
+			m.gauges = make(map[string]*Gauge)
+		}
+		g = &Gauge{name: name}
+		m.gauges[name] = g
+	}
+	return g
+}
+
+// Histogram returns the Histogram with the given name, creating it
+// with the given bucket boundaries if it does not already exist. If
+// the histogram exists, boundaries is ignored.
+//
+// Each boundary is the inclusive upper bound of a bucket, and there is
+// an additional bucket for values greater than the last boundary. The
+// boundaries are sorted, and duplicates removed; see also
+// ExponentialBoundaries. Histogram panics if boundaries is empty or
+// contains NaN or infinite values.
+func (m *Meter) Histogram(name string, boundaries []float64) *Histogram {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.histograms[name]
+	if !ok {
+		if m.histograms == nil {
+			m.histograms = make(map[string]*Histogram)
+		}
+		h = newHistogram(name, boundaries)
+		m.histograms[name] = h
+	}
+	return h
+}
+
+// GatherMetrics adds the metrics recorded by m's instruments into out.
+// Counters and histograms are reset after they are gathered.
+func (m *Meter) GatherMetrics(ctx context.Context, out *Metrics) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.counters {
+		c.gather(out)
+	}
+	for _, g := range m.gauges {
+		g.gather(out)
+	}
+	for _, h := range m.histograms {
+		h.gather(out)
+	}
+	return nil
+}
+
+// Counter is an instrument which records a sum per label set, such as
+// a number of requests. Sums are reset each time metrics are gathered,
+// so the reported values cover the metrics interval.
+type Counter struct {
+	name   string
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []MetricLabel
+	value  float64
+}
+
+// Inc adds 1 to the counter's sum for the given labels.
+func (c *Counter) Inc(labels ...MetricLabel) {
+	c.Add(1, labels...)
+}
+
+// Add adds value to the counter's sum for the given labels. Counters
+// may only increase: negative and NaN values are ignored.
+func (c *Counter) Add(value float64, labels ...MetricLabel) {
+	if !(value >= 0) {
+		return
+	}
+	labels = sortedMetricLabels(labels)
+	key := metricLabelsKey(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		if c.series == nil {
+			c.series = make(map[string]*counterSeries)
+		}
+		s = &counterSeries{labels: copyMetricLabels(labels)}
+		c.series[key] = s
+	}
+	s.value += value
+}
+
+func (c *Counter) gather(out *Metrics) {
+	c.mu.Lock()
+	series := c.series
+	c.series = nil
+	c.mu.Unlock()
+	for _, s := range series {
+		out.addMetric(c.name, s.labels, model.Metric{Type: "counter", Value: s.value})
+	}
+}
+
+// Gauge is an instrument which records the most recent value per label
+// set, such as a queue length. The most recent value is reported each
+// time metrics are gathered, until the gauge is reset.
+type Gauge struct {
+	name   string
+	mu     sync.Mutex
+	series map[string]*gaugeSeries
+}
+
+type gaugeSeries struct {
+	labels []MetricLabel
+	value  float64
+}
+
+// Set sets the gauge's value for the given labels.
+func (g *Gauge) Set(value float64, labels ...MetricLabel) {
+	labels = sortedMetricLabels(labels)
+	key := metricLabelsKey(labels)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s, ok := g.series[key]
+	if !ok {
+		if g.series == nil {
+			g.series = make(map[string]*gaugeSeries)
+		}
+		s = &gaugeSeries{labels: copyMetricLabels(labels)}
+		g.series[key] = s
+	}
+	s.value = value
+}
+
+// Reset removes the gauge's values for all label sets,
+// so they are no longer reported.
+func (g *Gauge) Reset() {
+	g.mu.Lock()
+	g.series = nil
+	g.mu.Unlock()
+}
+
+func (g *Gauge) gather(out *Metrics) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, s := range g.series {
+		out.addMetric(g.name, s.labels, model.Metric{Type: "gauge", Value: s.value})
+	}
+}
+
+// Histogram is an instrument which records the distribution of values
+// per label set, such as request durations, by counting the values in
+// each of a fixed set of buckets. Counts are reset each time metrics
+// are gathered, so the reported distributions cover the metrics interval.
+//
+// Histograms are reported using the "histogram" metric type, with a
+// representative value for each non-empty bucket: the midpoint of the
+// bucket's boundaries, the last boundary for the overflow bucket, and
+// half the first boundary (if positive) for the first bucket.
+type Histogram struct {
+	name       string
+	boundaries []float64
+	mu         sync.Mutex
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []MetricLabel
+	counts []uint64
+}
+
+func newHistogram(name string, boundaries []float64) *Histogram {
+	if len(boundaries) == 0 {
+		panic(errors.Errorf("histogram %q has no bucket boundaries", name))
+	}
+	sorted := make([]float64, 0, len(boundaries))
+	for _, b := range boundaries {
+		if math.IsNaN(b) {
+			panic(errors.Errorf("histogram %q has NaN bucket boundary", name))
+		}
+		if math.IsInf(b, 0) {
+			panic(errors.Errorf("histogram %q has infinite bucket boundary", name))
+		}
+		sorted = append(sorted, b)
+	}
+	sort.Float64s(sorted)
+	unique := sorted[:1]
+	for _, b := range sorted[1:] {
+		if b != unique[len(unique)-1] {
+			unique = append(unique, b)
+		}
+	}
+	return &Histogram{name: name, boundaries: unique}
+}
+
+// Record records value in the histogram for the given labels.
+// NaN values are ignored.
+func (h *Histogram) Record(value float64, labels ...MetricLabel) {
+	if math.IsNaN(value) {
+		return
+	}
+	bucket := sort.SearchFloat64s(h.boundaries, value)
+	labels = sortedMetricLabels(labels)
+	key := metricLabelsKey(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		if h.series == nil {
+			h.series = make(map[string]*histogramSeries)
+		}
+		s = &histogramSeries{
+			labels: copyMetricLabels(labels),
+			counts: make([]uint64, len(h.boundaries)+1),
+		}
+		h.series[key] = s
+	}
+	s.counts[bucket]++
+}
+
+func (h *Histogram) gather(out *Metrics) {
+	h.mu.Lock()
+	series := h.series
+	h.series = nil
+	h.mu.Unlock()
+	for _, s := range series {
+		metric := model.Metric{Type: "histogram", Values: []float64{}, Counts: []uint64{}}
+		for i, count := range s.counts {
+			if count == 0 {
+				continue
+			}
+			metric.Values = append(metric.Values, h.bucketValue(i))
+			metric.Counts = append(metric.Counts, count)
+		}
+		out.addMetric(h.name, s.labels, metric)
+	}
+}
+
+// bucketValue returns the representative value of the i'th bucket.
+func (h *Histogram) bucketValue(i int) float64 {
+	switch i {
+	case 0:
+		if b := h.boundaries[0]; b > 0 {
+			return b / 2
+		}
+		return h.boundaries[0]
+	case len(h.boundaries):
+		return h.boundaries[i-1]
+	}
+	lower, upper := h.boundaries[i-1], h.boundaries[i]
+	return lower + (upper-lower)/2
+}
+
+// ExponentialBoundaries returns n histogram bucket boundaries, starting
+// at start and multiplying by factor. ExponentialBoundaries panics if
+// start is not positive, factor is not greater than 1, or n is less
+// than 1.
+func ExponentialBoundaries(start, factor float64, n int) []float64 {
+	if !(start > 0) || !(factor > 1) || n < 1 {
+		panic(errors.Errorf(
+			"invalid exponential boundaries (start=%v, factor=%v, n=%d)",
+			start, factor, n,
+		))
+	}
+	boundaries := make([]float64, n)
+	for i := range boundaries {
+		boundaries[i] = start
+		start *= factor
+	}
+	return boundaries
+}
+
+// sortedMetricLabels returns labels sorted by name and value,
+// as expected by Metrics.Add. If labels are not already sorted,
+// a sorted copy is returned.
+func sortedMetricLabels(labels []MetricLabel) []MetricLabel {
+	less := func(labels []MetricLabel) func(i, j int) bool {
+		return func(i, j int) bool {
+			if labels[i].Name != labels[j].Name {
+				return labels[i].Name < labels[j].Name
+			}
+			return labels[i].Value < labels[j].Value
+		}
+	}
+	if sort.SliceIsSorted(labels, less(labels)) {
+		return labels
+	}
+	sorted := copyMetricLabels(labels)
+	sort.Slice(sorted, less(sorted))
+	return sorted
+}
+
+func copyMetricLabels(labels []MetricLabel) []MetricLabel {
+	if len(labels) == 0 {
+		return nil
+	}
+	return append([]MetricLabel(nil), labels...)
+}
+
+// metricLabelsKey returns a string identifying the sorted label set.
+func metricLabelsKey(labels []MetricLabel) string {
+	var b []byte
+	for _, l := range labels {
+		b = append(b, l.Name...)
+		b = append(b, 0)
+		b = append(b, l.Value...)
+		b = append(b, 0)
+	}
+	return string(b)
+}
@@ -0,0 +1,133 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestMeterCounter(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	requests := tracer.Meter().Counter("app.requests")
+	assert.Equal(t, requests, tracer.Meter().Counter("app.requests"))
+	requests.Inc(apm.MetricLabel{Name: "method", Value: "GET"})
+	requests.Add(2, apm.MetricLabel{Name: "method", Value: "GET"})
+	requests.Add(-1, apm.MetricLabel{Name: "method", Value: "GET"}) // ignored
+	requests.Inc(
+		apm.MetricLabel{Name: "status", Value: "500"},
+		apm.MetricLabel{Name: "method", Value: "POST"},
+	)
+	tracer.SendMetrics(nil)
+
+	metrics := meterMetricsets(transport.Payloads().Metrics, "app.requests")
+	require.Len(t, metrics, 2)
+	assert.Equal(t, model.StringMap{{Key: "method", Value: "GET"}}, metrics[0].Labels)
+	assert.Equal(t, model.Metric{Type: "counter", Value: 3}, metrics[0].Samples["app.requests"])
+	assert.Equal(t, model.StringMap{
+		{Key: "method", Value: "POST"},
+		{Key: "status", Value: "500"},
+	}, metrics[1].Labels)
+	assert.Equal(t, model.Metric{Type: "counter", Value: 1}, metrics[1].Samples["app.requests"])
+
+	// Counters are reset after they are gathered.
+	transport.ResetPayloads()
+	tracer.SendMetrics(nil)
+	assert.Empty(t, meterMetricsets(transport.Payloads().Metrics, "app.requests"))
+}
+
+func TestMeterGauge(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	queueLength := tracer.Meter().Gauge("app.queue.length")
+	queueLength.Set(10)
+	queueLength.Set(5)
+	tracer.SendMetrics(nil)
+
+	metrics := meterMetricsets(transport.Payloads().Metrics, "app.queue.length")
+	require.Len(t, metrics, 1)
+	assert.Equal(t, model.Metric{Type: "gauge", Value: 5}, metrics[0].Samples["app.queue.length"])
+
+	// Gauges are reported until they are reset.
+	transport.ResetPayloads()
+	tracer.SendMetrics(nil)
+	assert.Len(t, meterMetricsets(transport.Payloads().Metrics, "app.queue.length"), 1)
+
+	queueLength.Reset()
+	transport.ResetPayloads()
+	tracer.SendMetrics(nil)
+	assert.Empty(t, meterMetricsets(transport.Payloads().Metrics, "app.queue.length"))
+}
+
+func TestMeterHistogram(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	latency := tracer.Meter().Histogram("app.latency", []float64{100, 10, 50, 10})
+	for _, v := range []float64{1, 10, 20, 30, 500, 1000} {
+		latency.Record(v)
+	}
+	tracer.SendMetrics(nil)
+
+	metrics := meterMetricsets(transport.Payloads().Metrics, "app.latency")
+	require.Len(t, metrics, 1)
+	assert.Equal(t, model.Metric{
+		Type:   "histogram",
+		Values: []float64{5, 30, 100},
+		Counts: []uint64{2, 2, 2},
+	}, metrics[0].Samples["app.latency"])
+
+	transport.ResetPayloads()
+	tracer.SendMetrics(nil)
+	assert.Empty(t, meterMetricsets(transport.Payloads().Metrics, "app.latency"))
+}
+
+func TestMeterHistogramInvalidBoundaries(t *testing.T) {
+	var meter apm.Meter
+	assert.Panics(t, func() { meter.Histogram("empty", nil) })
+	assert.Panics(t, func() { meter.Histogram("nan", []float64{1, math.NaN()}) })
+	assert.Panics(t, func() { meter.Histogram("inf", []float64{1, math.Inf(1)}) })
+	assert.Panics(t, func() { meter.Histogram("-inf", []float64{math.Inf(-1), 1}) })
+}
+
+func TestExponentialBoundaries(t *testing.T) {
+	assert.Equal(t, []float64{1, 2, 4, 8}, apm.ExponentialBoundaries(1, 2, 4))
+	assert.Panics(t, func() { apm.ExponentialBoundaries(0, 2, 4) })
+	assert.Panics(t, func() { apm.ExponentialBoundaries(1, 1, 4) })
+	assert.Panics(t, func() { apm.ExponentialBoundaries(1, 2, 0) })
+}
+
+func meterMetricsets(metrics []model.Metrics, name string) []model.Metrics {
+	var out []model.Metrics
+	for _, m := range metrics {
+		if _, ok := m.Samples[name]; ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
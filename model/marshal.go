@@ -537,6 +537,51 @@ func (*StringMapItem) MarshalFastJSON(*fastjson.Writer) error {
 	panic("unreachable")
 }
 
+func (m IfaceMap) isZero() bool {
+	return len(m) == 0
+}
+
+// MarshalFastJSON writes the JSON representation of m to w.
+func (m IfaceMap) MarshalFastJSON(w *fastjson.Writer) (firstErr error) {
+	w.RawByte('{')
+	first := true
+	for _, item := range m {
+		if first {
+			first = false
+		} else {
+			w.RawByte(',')
+		}
+		w.String(item.Key)
+		w.RawByte(':')
+		if err := fastjson.Marshal(w, item.Value); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	w.RawByte('}')
+	return firstErr
+}
+
+// UnmarshalJSON unmarshals the JSON data into m.
+func (m *IfaceMap) UnmarshalJSON(data []byte) error {
+	var mm map[string]interface{}
+	if err := json.Unmarshal(data, &mm); err != nil {
+		return err
+	}
+	*m = make(IfaceMap, 0, len(mm))
+	for k, v := range mm {
+		*m = append(*m, IfaceMapItem{Key: k, Value: v})
+	}
+	sort.Slice(*m, func(i, j int) bool {
+		return (*m)[i].Key < (*m)[j].Key
+	})
+	return nil
+}
+
+// MarshalFastJSON exists to prevent code generation for IfaceMapItem.
+func (*IfaceMapItem) MarshalFastJSON(*fastjson.Writer) error {
+	panic("unreachable")
+}
+
 func (id *TraceID) isZero() bool {
 	return *id == TraceID{}
 }
@@ -584,3 +629,38 @@ func writeHex(w *fastjson.Writer, v []byte) {
 		w.RawByte(hextable[v&0x0f])
 	}
 }
+
+// MarshalFastJSON writes the JSON representation of m to w.
+//
+// Histogram metrics, with non-nil Values, are encoded with "values"
+// and "counts" in place of "value".
+func (m *Metric) MarshalFastJSON(w *fastjson.Writer) error {
+	w.RawByte('{')
+	if m.Values != nil {
+		w.RawString(`"counts":[`)
+		for i, v := range m.Counts {
+			if i != 0 {
+				w.RawByte(',')
+			}
+			w.Uint64(v)
+		}
+		w.RawByte(']')
+		w.RawString(`,"values":[`)
+		for i, v := range m.Values {
+			if i != 0 {
+				w.RawByte(',')
+			}
+			w.Float64(v)
+		}
+		w.RawByte(']')
+	} else {
+		w.RawString(`"value":`)
+		w.Float64(m.Value)
+	}
+	if m.Type != "" {
+		w.RawString(`,"type":`)
+		w.String(m.Type)
+	}
+	w.RawByte('}')
+	return nil
+}
@@ -150,6 +150,22 @@ func TestMarshalSpan(t *testing.T) {
 			},
 		},
 	}, decoded)
+
+	w.Reset()
+	span.Context = nil
+	span.Composite = &model.CompositeSpan{
+		CompressionStrategy: "exact_match",
+		Count:               3,
+		Sum:                 2.5,
+	}
+	span.MarshalFastJSON(&w)
+
+	decoded = mustUnmarshalJSON(w)
+	assert.Equal(t, map[string]interface{}{
+		"compression_strategy": "exact_match",
+		"count":                float64(3),
+		"sum":                  2.5,
+	}, decoded.(map[string]interface{})["composite"])
 }
 
 func TestMarshalMetrics(t *testing.T) {
@@ -176,6 +192,35 @@ func TestMarshalMetrics(t *testing.T) {
 	assert.Equal(t, expect, decoded)
 }
 
+func TestMarshalMetricTypes(t *testing.T) {
+	metrics := model.Metrics{
+		Samples: map[string]model.Metric{
+			"counter": {Type: "counter", Value: 2},
+			"histogram": {
+				Type:   "histogram",
+				Values: []float64{0.5, 1.5},
+				Counts: []uint64{3, 4},
+			},
+		},
+	}
+
+	var w fastjson.Writer
+	metrics.MarshalFastJSON(&w)
+
+	decoded := mustUnmarshalJSON(w)
+	assert.Equal(t, map[string]interface{}{
+		"counter": map[string]interface{}{
+			"type":  "counter",
+			"value": float64(2),
+		},
+		"histogram": map[string]interface{}{
+			"type":   "histogram",
+			"values": []interface{}{0.5, 1.5},
+			"counts": []interface{}{float64(3), float64(4)},
+		},
+	}, decoded.(map[string]interface{})["samples"])
+}
+
 func TestMarshalError(t *testing.T) {
 	var e model.Error
 	time, err := time.Parse("2006-01-02T15:04:05.999Z", "1970-01-01T00:02:03Z")
@@ -316,6 +361,20 @@ func TestMarshalUser(t *testing.T) {
 	assert.Equal(t, `{"email":"foo@example.com","id":"123","username":"bar"}`, string(w.Bytes()))
 }
 
+func TestMarshalIfaceMap(t *testing.T) {
+	m := model.IfaceMap{
+		{Key: "a", Value: map[string]interface{}{"b": []interface{}{"c", 1.5}}},
+		{Key: "d", Value: true},
+	}
+	var w fastjson.Writer
+	require.NoError(t, m.MarshalFastJSON(&w))
+	assert.Equal(t, `{"a":{"b":["c",1.5]},"d":true}`, string(w.Bytes()))
+
+	var out model.IfaceMap
+	require.NoError(t, json.Unmarshal(w.Bytes(), &out))
+	assert.Equal(t, m, out)
+}
+
 func TestMarshalStacktraceFrame(t *testing.T) {
 	f := model.StacktraceFrame{
 		File:         "file.go",
@@ -198,6 +198,20 @@ type Transaction struct {
 
 	// SpanCount holds statistics on spans within a transaction.
 	SpanCount SpanCount `json:"span_count"`
+
+	// Links holds links to other spans or transactions, e.g. those
+	// which produced the messages processed by the transaction.
+	Links []SpanLink `json:"links,omitempty"`
+}
+
+// SpanLink holds a link to a span or transaction, which may be
+// in another trace.
+type SpanLink struct {
+	// TraceID holds the ID of the linked span's trace.
+	TraceID TraceID `json:"trace_id"`
+
+	// SpanID holds the ID of the linked span or transaction.
+	SpanID SpanID `json:"span_id"`
 }
 
 // SpanCount holds statistics on spans within a transaction.
@@ -250,6 +264,28 @@ type Span struct {
 
 	// Stacktrace holds stack frames corresponding to the span.
 	Stacktrace []StacktraceFrame `json:"stacktrace,omitempty"`
+
+	// Composite holds details of a compressed span, representing
+	// a sequence of consecutive, similar spans.
+	Composite *CompositeSpan `json:"composite,omitempty"`
+
+	// Links holds links to other spans or transactions, e.g. those
+	// which produced the messages processed by the span.
+	Links []SpanLink `json:"links,omitempty"`
+}
+
+// CompositeSpan holds details of a compressed span.
+type CompositeSpan struct {
+	// CompressionStrategy holds the strategy used to compress the
+	// spans: "exact_match" or "same_kind".
+	CompressionStrategy string `json:"compression_strategy"`
+
+	// Count holds the number of spans compressed into the span.
+	Count int `json:"count"`
+
+	// Sum holds the sum of the compressed spans' durations,
+	// in milliseconds.
+	Sum float64 `json:"sum"`
 }
 
 // SpanContext holds contextual information relating to the span.
@@ -258,6 +294,10 @@ type SpanContext struct {
 	// operation spans.
 	Database *DatabaseSpanContext `json:"db,omitempty"`
 
+	// Destination holds contextual information about the destination
+	// of a span relating to an operation on an external service.
+	Destination *DestinationSpanContext `json:"destination,omitempty"`
+
 	// HTTP holds contextual information for HTTP client request spans.
 	HTTP *HTTPSpanContext `json:"http,omitempty"`
 
@@ -265,6 +305,17 @@ type SpanContext struct {
 	Tags StringMap `json:"tags,omitempty"`
 }
 
+// DestinationSpanContext holds contextual information about the
+// destination of a span relating to an operation on an external service.
+type DestinationSpanContext struct {
+	// Address holds the network address of the destination service.
+	// This may be a hostname, FQDN, or (IPv4 or IPv6) network address.
+	Address string `json:"address,omitempty"`
+
+	// Port holds the network port of the destination service.
+	Port int `json:"port,omitempty"`
+}
+
 // DatabaseSpanContext holds contextual information for database
 // operation spans.
 type DatabaseSpanContext struct {
@@ -309,6 +360,10 @@ type Context struct {
 	// Tags holds user-defined key/value pairs.
 	Tags StringMap `json:"tags,omitempty"`
 
+	// Custom holds user-defined key/value pairs, whose values
+	// may be arbitrary JSON-encodable values.
+	Custom IfaceMap `json:"custom,omitempty"`
+
 	// Service holds values to overrides service-level metadata.
 	Service *Service `json:"service,omitempty"`
 }
@@ -596,6 +651,19 @@ type Metrics struct {
 
 // Metric holds metric values.
 type Metric struct {
-	// Value holds the metric value.
+	// Type holds an optional metric type: "counter", "gauge",
+	// or "histogram".
+	Type string `json:"type,omitempty"`
+
+	// Value holds the metric value for single-value metrics.
+	// Value is ignored if Values is non-nil.
 	Value float64 `json:"value"`
+
+	// Values holds the representative values of histogram buckets,
+	// in ascending order. Counts holds the corresponding counts.
+	Values []float64 `json:"values,omitempty"`
+
+	// Counts holds the counts of histogram buckets,
+	// corresponding to the bucket values in Values.
+	Counts []uint64 `json:"counts,omitempty"`
 }
@@ -18,6 +18,8 @@
 package apm
 
 import (
+	"time"
+
 	"go.elastic.co/apm/internal/ringbuffer"
 	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/stacktrace"
@@ -103,22 +105,42 @@ func (w *modelWriter) buildModelTransaction(out *model.Transaction, tx *Transact
 	}
 
 	out.ParentID = model.SpanID(td.parentSpan)
-	out.Name = truncateString(td.Name)
+	out.Name = truncateString(rewriteTransactionName(td.Name, w.cfg.transactionNameRewriteRules))
 	out.Type = truncateString(td.Type)
 	out.Result = truncateString(td.Result)
-	out.Timestamp = model.Time(td.timestamp.UTC())
+	out.Timestamp = model.Time(td.timestamp.Add(td.clockSkew).UTC())
 	out.Duration = td.Duration.Seconds() * 1000
 	out.SpanCount.Started = td.spansCreated
 	out.SpanCount.Dropped = td.spansDropped
+	out.Links = buildModelLinks(td.links)
 
 	out.Context = td.Context.build()
-	if len(w.cfg.sanitizedFieldNames) != 0 && out.Context != nil {
-		if out.Context.Request != nil {
-			sanitizeRequest(out.Context.Request, w.cfg.sanitizedFieldNames)
-		}
-		if out.Context.Response != nil {
-			sanitizeResponse(out.Context.Response, w.cfg.sanitizedFieldNames)
-		}
+	w.sanitizeContext(out.Context)
+	if out.Context != nil {
+		out.Context.Tags = w.limitLabels(out.Context.Tags)
+	}
+}
+
+// limitLabels applies the configured label limits to tags,
+// recording any truncated or dropped labels in the tracer stats.
+func (w *modelWriter) limitLabels(tags model.StringMap) model.StringMap {
+	if len(tags) == 0 {
+		return tags
+	}
+	return applyLabelLimits(tags, w.cfg.labelLimits, w.stats)
+}
+
+// sanitizeContext sanitizes the HTTP request and response in
+// a transaction or error context, which may be nil.
+func (w *modelWriter) sanitizeContext(ctx *model.Context) {
+	if len(w.cfg.sanitizedFieldNames) == 0 || ctx == nil {
+		return
+	}
+	if ctx.Request != nil {
+		sanitizeRequest(ctx.Request, w.cfg.sanitizedFieldNames)
+	}
+	if ctx.Response != nil {
+		sanitizeResponse(ctx.Response, w.cfg.sanitizedFieldNames)
 	}
 }
 
@@ -133,9 +155,14 @@ func (w *modelWriter) buildModelSpan(out *model.Span, span *Span, sd *SpanData)
 	out.Type = truncateString(sd.Type)
 	out.Subtype = truncateString(sd.Subtype)
 	out.Action = truncateString(sd.Action)
-	out.Timestamp = model.Time(sd.timestamp.UTC())
+	out.Timestamp = model.Time(sd.timestamp.Add(sd.clockSkew).UTC())
 	out.Duration = sd.Duration.Seconds() * 1000
 	out.Context = sd.Context.build()
+	if out.Context != nil {
+		out.Context.Tags = w.limitLabels(out.Context.Tags)
+	}
+	out.Composite = sd.composite.build()
+	out.Links = buildModelLinks(sd.links)
 
 	w.modelStacktrace = appendModelStacktraceFrames(w.modelStacktrace, sd.stacktrace)
 	out.Stacktrace = w.modelStacktrace
@@ -147,8 +174,12 @@ func (w *modelWriter) buildModelError(out *model.Error, e *ErrorData) {
 	out.TraceID = model.TraceID(e.TraceID)
 	out.ParentID = model.SpanID(e.ParentID)
 	out.TransactionID = model.SpanID(e.TransactionID)
-	out.Timestamp = model.Time(e.Timestamp.UTC())
+	out.Timestamp = model.Time(e.Timestamp.Add(e.clockSkew).UTC())
 	out.Context = e.Context.build()
+	w.sanitizeContext(out.Context)
+	if out.Context != nil {
+		out.Context.Tags = w.limitLabels(out.Context.Tags)
+	}
 	out.Culprit = e.Culprit
 
 	if !e.TransactionID.isZero() {
@@ -216,5 +247,6 @@ func (w *modelWriter) setStacktraceContext(stack []model.StacktraceFrame) {
 			w.cfg.logger.Debugf("setting context failed: %v", err)
 		}
 		w.stats.Errors.SetContext++
+		w.stats.Errors.Last = time.Now()
 	}
 }
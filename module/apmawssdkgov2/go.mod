@@ -0,0 +1,15 @@
+module go.elastic.co/apm/module/apmawssdkgov2
+
+require (
+	github.com/aws/aws-sdk-go-v2 v1.17.1
+	github.com/aws/aws-sdk-go-v2/service/sns v1.18.4
+	github.com/aws/aws-sdk-go-v2/service/sqs v1.19.12
+	github.com/aws/smithy-go v1.13.4
+	github.com/stretchr/testify v1.3.0
+	go.elastic.co/apm v1.3.0
+	go.elastic.co/apm/module/apmhttp v1.3.0
+)
+
+replace go.elastic.co/apm => ../..
+
+replace go.elastic.co/apm/module/apmhttp => ../apmhttp
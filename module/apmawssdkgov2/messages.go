@@ -0,0 +1,230 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.15
+
+package apmawssdkgov2
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmhttp"
+)
+
+const (
+	// TraceparentAttribute is the message attribute in which the trace
+	// context of the sending span is recorded, in the W3C Trace Context
+	// format.
+	TraceparentAttribute = "traceparent"
+
+	// TracestateAttribute is the message attribute in which the W3C
+	// tracestate of the sending span is recorded, if any.
+	TracestateAttribute = "tracestate"
+
+	// maxMessageAttributes is the maximum number of attributes
+	// of an SQS message, or an SNS message delivered to SQS.
+	maxMessageAttributes = 10
+)
+
+// SQSMessageTraceContext returns the trace context recorded in the
+// attributes of msg by an instrumented SQS or SNS client, and reports
+// whether it was found and valid. SNS message attributes are only
+// recorded in SQS message attributes when raw message delivery is
+// enabled for the subscription.
+//
+// The trace context may be used to start a transaction for processing
+// the message, as a continuation of the sender's trace:
+//
+//	traceContext, _ := apmawssdkgov2.SQSMessageTraceContext(msg)
+//	tx := apm.DefaultTracer.StartTransactionOptions("process", "messaging", apm.TransactionOptions{
+//		TraceContext: traceContext,
+//	})
+//
+// Messages are only received with their trace context attributes if they
+// are requested. Receive requests sent by instrumented clients request
+// them in addition to the requested attributes.
+func SQSMessageTraceContext(msg sqstypes.Message) (apm.TraceContext, bool) {
+	return sqsMessageTraceContext(&msg)
+}
+
+// SQSMessageLinks returns a span link for each of msgs with a trace
+// context recorded in its message attributes, as returned by
+// SQSMessageTraceContext, for linking a transaction which processes
+// the messages received in a batch to the senders' traces:
+//
+//	tx := apm.DefaultTracer.StartTransactionOptions("process", "messaging", apm.TransactionOptions{
+//		Links: apmawssdkgov2.SQSMessageLinks(output.Messages),
+//	})
+func SQSMessageLinks(msgs []sqstypes.Message) []apm.SpanLink {
+	carriers := make([]apm.TraceContextCarrier, len(msgs))
+	for i := range msgs {
+		msg := &msgs[i]
+		carriers[i] = apm.TraceContextCarrierFunc(func() (apm.TraceContext, bool) {
+			return sqsMessageTraceContext(msg)
+		})
+	}
+	return apm.LinksFromCarriers(carriers)
+}
+
+func sqsMessageTraceContext(msg *sqstypes.Message) (apm.TraceContext, bool) {
+	traceparent, ok := msg.MessageAttributes[TraceparentAttribute]
+	if !ok || traceparent.StringValue == nil {
+		return apm.TraceContext{}, false
+	}
+	traceContext, err := apmhttp.ParseTraceparentHeader(*traceparent.StringValue)
+	if err != nil || traceContext.Trace.Validate() != nil || traceContext.Span.Validate() != nil {
+		return apm.TraceContext{}, false
+	}
+	if tracestate, ok := msg.MessageAttributes[TracestateAttribute]; ok && tracestate.StringValue != nil {
+		if state, err := apmhttp.ParseTracestateHeader(*tracestate.StringValue); err == nil {
+			traceContext.State = state
+		}
+	}
+	return traceContext, true
+}
+
+// setMessageAttributes returns params, or a copy of params with the trace
+// context attributes set to the trace context of span, or the trace context
+// of the transaction in ctx if span is dropped. In the copy of SQS receive
+// parameters, the trace context attributes are requested.
+//
+// The trace context is not recorded in messages which would exceed the
+// maximum number of message attributes.
+func setMessageAttributes(ctx context.Context, span *apm.Span, params interface{}) interface{} {
+	if params, ok := params.(*sqs.ReceiveMessageInput); ok {
+		return requestTraceContextAttributes(params)
+	}
+
+	var traceContext apm.TraceContext
+	if !span.Dropped() {
+		traceContext = span.TraceContext()
+	} else if tx := apm.TransactionFromContext(ctx); tx != nil {
+		traceContext = tx.TraceContext()
+	}
+	if traceContext.Trace.Validate() != nil {
+		return params
+	}
+	attrs := map[string]string{
+		TraceparentAttribute: apmhttp.FormatTraceparentHeader(traceContext),
+	}
+	if tracestate := traceContext.State.String(); tracestate != "" {
+		attrs[TracestateAttribute] = tracestate
+	}
+
+	switch params := params.(type) {
+	case *sqs.SendMessageInput:
+		in := *params
+		in.MessageAttributes = setSQSMessageAttributes(in.MessageAttributes, attrs)
+		return &in
+	case *sqs.SendMessageBatchInput:
+		in := *params
+		in.Entries = make([]sqstypes.SendMessageBatchRequestEntry, len(params.Entries))
+		for i, entry := range params.Entries {
+			entry.MessageAttributes = setSQSMessageAttributes(entry.MessageAttributes, attrs)
+			in.Entries[i] = entry
+		}
+		return &in
+	case *sns.PublishInput:
+		in := *params
+		in.MessageAttributes = setSNSMessageAttributes(in.MessageAttributes, attrs)
+		return &in
+	}
+	return params
+}
+
+// setSQSMessageAttributes returns a copy of m with the trace context
+// attributes replaced by attrs, or m if the copy would have too many
+// attributes. m is not modified.
+func setSQSMessageAttributes(m map[string]sqstypes.MessageAttributeValue, attrs map[string]string) map[string]sqstypes.MessageAttributeValue {
+	out := make(map[string]sqstypes.MessageAttributeValue, len(m)+len(attrs))
+	for k, v := range m {
+		if !isTraceContextAttribute(k) {
+			out[k] = v
+		}
+	}
+	if len(out)+len(attrs) > maxMessageAttributes {
+		return m
+	}
+	for k, v := range attrs {
+		out[k] = sqstypes.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(v),
+		}
+	}
+	return out
+}
+
+// setSNSMessageAttributes is the SNS equivalent of setSQSMessageAttributes.
+func setSNSMessageAttributes(m map[string]snstypes.MessageAttributeValue, attrs map[string]string) map[string]snstypes.MessageAttributeValue {
+	out := make(map[string]snstypes.MessageAttributeValue, len(m)+len(attrs))
+	for k, v := range m {
+		if !isTraceContextAttribute(k) {
+			out[k] = v
+		}
+	}
+	if len(out)+len(attrs) > maxMessageAttributes {
+		return m
+	}
+	for k, v := range attrs {
+		out[k] = snstypes.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(v),
+		}
+	}
+	return out
+}
+
+// requestTraceContextAttributes returns params, or a copy of params
+// with the trace context attributes added to the requested message
+// attribute names if they are not already requested.
+func requestTraceContextAttributes(params *sqs.ReceiveMessageInput) *sqs.ReceiveMessageInput {
+	var traceparent, tracestate bool
+	for _, name := range params.MessageAttributeNames {
+		switch name {
+		case "All", ".*":
+			return params
+		case TraceparentAttribute:
+			traceparent = true
+		case TracestateAttribute:
+			tracestate = true
+		}
+	}
+	if traceparent && tracestate {
+		return params
+	}
+	in := *params
+	in.MessageAttributeNames = make([]string, len(params.MessageAttributeNames), len(params.MessageAttributeNames)+2)
+	copy(in.MessageAttributeNames, params.MessageAttributeNames)
+	if !traceparent {
+		in.MessageAttributeNames = append(in.MessageAttributeNames, TraceparentAttribute)
+	}
+	if !tracestate {
+		in.MessageAttributeNames = append(in.MessageAttributeNames, TracestateAttribute)
+	}
+	return &in
+}
+
+func isTraceContextAttribute(name string) bool {
+	return name == TraceparentAttribute || name == TracestateAttribute
+}
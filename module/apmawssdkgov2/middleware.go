@@ -0,0 +1,310 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.15
+
+package apmawssdkgov2
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"go.elastic.co/apm"
+)
+
+const (
+	spanMiddlewareID        = "ElasticAPMSpan"
+	destinationMiddlewareID = "ElasticAPMSpanDestination"
+
+	serviceS3       = "S3"
+	serviceDynamoDB = "DynamoDB"
+	serviceSQS      = "SQS"
+	serviceSNS      = "SNS"
+)
+
+// AppendMiddlewares appends to apiOptions a function which adds tracing
+// middleware to the middleware stack of each client operation.
+// apiOptions is intended to be the APIOptions field of an aws.Config,
+// or of the options of an individual client:
+//
+//	cfg, err := config.LoadDefaultConfig(ctx)
+//	if err != nil {
+//		...
+//	}
+//	apmawssdkgov2.AppendMiddlewares(&cfg.APIOptions)
+//	client := s3.NewFromConfig(cfg)
+//
+// Operations of S3, DynamoDB, SQS and SNS clients are reported as exit
+// spans within the transaction in the operation's context, recording
+// the request's region and the bucket, table, queue or topic name, and
+// the endpoint address as the span destination. Errors returned by the
+// operations are reported. Operations of other services are not
+// reported.
+//
+// The trace context of spans for sending SQS messages and publishing
+// SNS messages is recorded in the messages' attributes, and SQS receive
+// requests are altered to request those attributes; see
+// SQSMessageTraceContext. The operation's parameters are not modified.
+func AppendMiddlewares(apiOptions *[]func(*middleware.Stack) error) {
+	*apiOptions = append(*apiOptions, addMiddlewares)
+}
+
+func addMiddlewares(stack *middleware.Stack) error {
+	if err := stack.Initialize.Add(
+		middleware.InitializeMiddlewareFunc(spanMiddlewareID, handleInitialize),
+		middleware.After,
+	); err != nil {
+		return err
+	}
+	return stack.Deserialize.Add(
+		middleware.DeserializeMiddlewareFunc(destinationMiddlewareID, handleDeserialize),
+		middleware.After,
+	)
+}
+
+// handleInitialize starts a span for an operation of a supported
+// service, and ends the span once the operation has completed.
+func handleInitialize(
+	ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+) (middleware.InitializeOutput, middleware.Metadata, error) {
+	op := newOperation(ctx, in.Parameters)
+	if op == nil {
+		return next.HandleInitialize(ctx, in)
+	}
+	span, ctx := apm.StartSpanOptions(ctx, op.spanName(), op.spanType(), apm.SpanOptions{ExitSpan: true})
+	defer span.End()
+	if !span.Dropped() {
+		op.setSpanContext(span)
+	}
+	in.Parameters = setMessageAttributes(ctx, span, in.Parameters)
+
+	out, metadata, err := next.HandleInitialize(context.WithValue(ctx, spanKey{}, span), in)
+	if !span.Dropped() {
+		if requestID, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+			span.Context.SetTag("aws_request_id", requestID)
+		}
+	}
+	if err != nil {
+		if e := apm.CaptureError(ctx, err); e != nil {
+			e.Send()
+		}
+	}
+	return out, metadata, err
+}
+
+// handleDeserialize records the endpoint address, request URL and
+// response status code of each attempt of an operation in the
+// operation's span.
+func handleDeserialize(
+	ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler,
+) (middleware.DeserializeOutput, middleware.Metadata, error) {
+	out, metadata, err := next.HandleDeserialize(ctx, in)
+	span, _ := ctx.Value(spanKey{}).(*apm.Span)
+	if span == nil || span.Dropped() {
+		return out, metadata, err
+	}
+	req, ok := in.Request.(*smithyhttp.Request)
+	if !ok || req.URL == nil {
+		return out, metadata, err
+	}
+	setDestination(span, req.URL)
+	span.Context.SetHTTPRequest(req.Request)
+	if resp, ok := out.RawResponse.(*smithyhttp.Response); ok && resp.Response != nil {
+		span.Context.SetHTTPStatusCode(resp.StatusCode)
+	}
+	return out, metadata, err
+}
+
+type spanKey struct{}
+
+// operation describes an operation of a supported service.
+type operation struct {
+	service  string
+	name     string
+	region   string
+	resource string
+}
+
+// newOperation returns the operation described by the service metadata
+// in ctx and its parameters, or nil if the service is not supported.
+func newOperation(ctx context.Context, params interface{}) *operation {
+	op := &operation{
+		service: awsmiddleware.GetServiceID(ctx),
+		name:    awsmiddleware.GetOperationName(ctx),
+		region:  awsmiddleware.GetRegion(ctx),
+	}
+	switch op.service {
+	case serviceS3:
+		op.resource = stringField(params, "Bucket")
+	case serviceDynamoDB:
+		op.resource = stringField(params, "TableName")
+	case serviceSQS:
+		op.resource = queueName(stringField(params, "QueueUrl"))
+	case serviceSNS:
+		op.resource = topicName(params)
+	default:
+		return nil
+	}
+	return op
+}
+
+// spanName returns the span name for op, e.g. "S3 GetObject bucket",
+// or "SQS SEND to queue" for messaging operations.
+func (op *operation) spanName() string {
+	var name string
+	switch op.action() {
+	case "send", "send_batch":
+		name = op.service + " SEND to"
+	case "poll":
+		name = op.service + " POLL from"
+	case "delete", "delete_batch":
+		name = op.service + " DELETE from"
+	case "publish":
+		name = op.service + " PUBLISH to"
+	default:
+		name = op.service + " " + op.name
+	}
+	if op.resource != "" {
+		name += " " + op.resource
+	}
+	return name
+}
+
+// spanType returns the span type for op, e.g. "storage.s3.GetObject".
+func (op *operation) spanType() string {
+	switch op.service {
+	case serviceS3:
+		return "storage.s3." + op.action()
+	case serviceDynamoDB:
+		return "db.dynamodb." + op.action()
+	}
+	return "messaging." + strings.ToLower(op.service) + "." + op.action()
+}
+
+// action returns the span action for op: the operation name for S3
+// and DynamoDB, and a messaging action for SQS and SNS operations
+// which send, receive or delete messages.
+func (op *operation) action() string {
+	switch op.service {
+	case serviceSQS:
+		switch op.name {
+		case "SendMessage":
+			return "send"
+		case "SendMessageBatch":
+			return "send_batch"
+		case "ReceiveMessage":
+			return "poll"
+		case "DeleteMessage":
+			return "delete"
+		case "DeleteMessageBatch":
+			return "delete_batch"
+		}
+	case serviceSNS:
+		if op.name == "Publish" {
+			return "publish"
+		}
+	}
+	return op.name
+}
+
+func (op *operation) setSpanContext(span *apm.Span) {
+	if op.region != "" {
+		span.Context.SetTag("aws_region", op.region)
+	}
+	if op.service == serviceDynamoDB {
+		span.Context.SetDatabase(apm.DatabaseSpanContext{
+			Type:     "dynamodb",
+			Instance: op.region,
+		})
+	}
+	if op.resource == "" {
+		return
+	}
+	switch op.service {
+	case serviceS3:
+		span.Context.SetTag("s3_bucket", op.resource)
+	case serviceDynamoDB:
+		span.Context.SetTag("dynamodb_table", op.resource)
+	case serviceSQS:
+		span.Context.SetTag("sqs_queue", op.resource)
+	case serviceSNS:
+		span.Context.SetTag("sns_topic", op.resource)
+	}
+}
+
+// stringField returns the value of the string or *string field with
+// the given name of the struct pointed to by params, or the empty
+// string if there is no such field. It is used for parameters common
+// to many operations of a service, such as S3 bucket names.
+func stringField(params interface{}, name string) string {
+	v := reflect.ValueOf(params)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	f := v.FieldByName(name)
+	if f.Kind() == reflect.Ptr && !f.IsNil() {
+		f = f.Elem()
+	}
+	if f.Kind() != reflect.String {
+		return ""
+	}
+	return f.String()
+}
+
+// queueName returns the name of the SQS queue with the given URL.
+func queueName(queueURL string) string {
+	return queueURL[strings.LastIndexByte(queueURL, '/')+1:]
+}
+
+// topicName returns the name of the SNS topic or target of the given
+// parameters. Phone numbers are replaced with "<PHONE_NUMBER>".
+func topicName(params interface{}) string {
+	arn := stringField(params, "TopicArn")
+	if arn == "" {
+		arn = stringField(params, "TargetArn")
+	}
+	if arn == "" {
+		if stringField(params, "PhoneNumber") != "" {
+			return "<PHONE_NUMBER>"
+		}
+		return ""
+	}
+	return arn[strings.LastIndexByte(arn, ':')+1:]
+}
+
+func setDestination(span *apm.Span, u *url.URL) {
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		switch u.Scheme {
+		case "https":
+			port = 443
+		case "http":
+			port = 80
+		}
+	}
+	span.Context.SetDestinationAddress(u.Hostname(), port)
+}
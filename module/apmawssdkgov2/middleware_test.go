@@ -0,0 +1,381 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.15
+
+package apmawssdkgov2_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmawssdkgov2"
+)
+
+type s3GetObjectInput struct {
+	Bucket *string
+	Key    *string
+}
+
+type dynamoDBQueryInput struct {
+	TableName *string
+}
+
+func TestS3(t *testing.T) {
+	var params interface{}
+	tx, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		params, _ = invoke(t, ctx, "S3", "GetObject", &s3GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("key"),
+		}, http.StatusOK, nil)
+	})
+	assert.IsType(t, &s3GetObjectInput{}, params)
+	assert.Empty(t, errs)
+	require.Len(t, spans, 1)
+	assert.Equal(t, tx.ID, spans[0].ParentID)
+	assert.Equal(t, "S3 GetObject bucket", spans[0].Name)
+	assert.Equal(t, "storage", spans[0].Type)
+	assert.Equal(t, "s3", spans[0].Subtype)
+	assert.Equal(t, "GetObject", spans[0].Action)
+	assert.Equal(t, &model.SpanContext{
+		Destination: &model.DestinationSpanContext{
+			Address: "service.us-east-1.amazonaws.com",
+			Port:    443,
+		},
+		HTTP: &model.HTTPSpanContext{
+			URL:        mustParseURL("https://service.us-east-1.amazonaws.com/"),
+			StatusCode: http.StatusOK,
+		},
+		Tags: model.StringMap{
+			{Key: "aws_region", Value: "us-east-1"},
+			{Key: "aws_request_id", Value: "request-id"},
+			{Key: "s3_bucket", Value: "bucket"},
+		},
+	}, spans[0].Context)
+}
+
+func TestDynamoDB(t *testing.T) {
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		invoke(t, ctx, "DynamoDB", "Query", &dynamoDBQueryInput{
+			TableName: aws.String("table"),
+		}, http.StatusOK, nil)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "DynamoDB Query table", spans[0].Name)
+	assert.Equal(t, "db", spans[0].Type)
+	assert.Equal(t, "dynamodb", spans[0].Subtype)
+	assert.Equal(t, "Query", spans[0].Action)
+	assert.Equal(t, &model.DatabaseSpanContext{Type: "dynamodb", Instance: "us-east-1"}, spans[0].Context.Database)
+}
+
+func TestUnsupportedService(t *testing.T) {
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		invoke(t, ctx, "Lambda", "Invoke", &struct{}{}, http.StatusOK, nil)
+	})
+	assert.Empty(t, spans)
+}
+
+func TestError(t *testing.T) {
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := invoke(t, ctx, "S3", "GetObject", &s3GetObjectInput{
+			Bucket: aws.String("bucket"),
+		}, http.StatusForbidden, errors.New("access denied"))
+		assert.EqualError(t, err, "access denied")
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+	assert.Equal(t, http.StatusForbidden, spans[0].Context.HTTP.StatusCode)
+}
+
+func TestSQSSendMessage(t *testing.T) {
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/queue"),
+		MessageBody: aws.String("body"),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"key": {DataType: aws.String("String"), StringValue: aws.String("value")},
+		},
+	}
+	var params interface{}
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		params, _ = invoke(t, ctx, "SQS", "SendMessage", input, http.StatusOK, nil)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "SQS SEND to queue", spans[0].Name)
+	assert.Equal(t, "messaging", spans[0].Type)
+	assert.Equal(t, "sqs", spans[0].Subtype)
+	assert.Equal(t, "send", spans[0].Action)
+
+	// The input is copied, not modified.
+	assert.Len(t, input.MessageAttributes, 1)
+	sent := params.(*sqs.SendMessageInput)
+	assert.Len(t, sent.MessageAttributes, 2)
+	assert.Equal(t, "value", *sent.MessageAttributes["key"].StringValue)
+
+	traceContext, ok := apmawssdkgov2.SQSMessageTraceContext(sqstypes.Message{
+		MessageAttributes: sent.MessageAttributes,
+	})
+	require.True(t, ok)
+	assert.Equal(t, spans[0].TraceID, model.TraceID(traceContext.Trace))
+	assert.Equal(t, spans[0].ID, model.SpanID(traceContext.Span))
+}
+
+func TestSQSSendMessageBatch(t *testing.T) {
+	var params interface{}
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		params, _ = invoke(t, ctx, "SQS", "SendMessageBatch", &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/queue"),
+			Entries: []sqstypes.SendMessageBatchRequestEntry{
+				{Id: aws.String("1"), MessageBody: aws.String("one")},
+				{Id: aws.String("2"), MessageBody: aws.String("two")},
+			},
+		}, http.StatusOK, nil)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "SQS SEND to queue", spans[0].Name)
+	assert.Equal(t, "send_batch", spans[0].Action)
+	for _, entry := range params.(*sqs.SendMessageBatchInput).Entries {
+		traceContext, ok := apmawssdkgov2.SQSMessageTraceContext(sqstypes.Message{
+			MessageAttributes: entry.MessageAttributes,
+		})
+		require.True(t, ok)
+		assert.Equal(t, spans[0].ID, model.SpanID(traceContext.Span))
+	}
+}
+
+func TestSQSSendMessageTooManyAttributes(t *testing.T) {
+	attrs := make(map[string]sqstypes.MessageAttributeValue)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		attrs[key] = sqstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(key)}
+	}
+	var params interface{}
+	apmtest.WithTransaction(func(ctx context.Context) {
+		params, _ = invoke(t, ctx, "SQS", "SendMessage", &sqs.SendMessageInput{
+			QueueUrl:          aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/queue"),
+			MessageAttributes: attrs,
+		}, http.StatusOK, nil)
+	})
+	assert.Len(t, params.(*sqs.SendMessageInput).MessageAttributes, 10)
+	_, ok := apmawssdkgov2.SQSMessageTraceContext(sqstypes.Message{
+		MessageAttributes: params.(*sqs.SendMessageInput).MessageAttributes,
+	})
+	assert.False(t, ok)
+}
+
+func TestSQSMessageLinks(t *testing.T) {
+	message := func(traceparent string) sqstypes.Message {
+		return sqstypes.Message{MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			apmawssdkgov2.TraceparentAttribute: {DataType: aws.String("String"), StringValue: aws.String(traceparent)},
+		}}
+	}
+	links := apmawssdkgov2.SQSMessageLinks([]sqstypes.Message{
+		message("00-0af7651916cd43dd8448eb211c80319c-00f067aa0ba902b7-01"),
+		{},
+		message("00-0af7651916cd43dd8448eb211c80319c-00f067aa0ba902b7-01"),
+		message("00-11111111111111111111111111111111-2222222222222222-01"),
+	})
+	require.Len(t, links, 2)
+	assert.Equal(t, apm.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}, links[0].Span)
+	assert.Equal(t, apm.SpanID{0x22, 0x22, 0x22, 0x22, 0x22, 0x22, 0x22, 0x22}, links[1].Span)
+}
+
+func TestSQSReceiveMessage(t *testing.T) {
+	var params interface{}
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		params, _ = invoke(t, ctx, "SQS", "ReceiveMessage", &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/queue"),
+			MessageAttributeNames: []string{"key"},
+		}, http.StatusOK, nil)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "SQS POLL from queue", spans[0].Name)
+	assert.Equal(t, "poll", spans[0].Action)
+	assert.Equal(t, []string{"key", "traceparent", "tracestate"}, params.(*sqs.ReceiveMessageInput).MessageAttributeNames)
+
+	apmtest.WithTransaction(func(ctx context.Context) {
+		params, _ = invoke(t, ctx, "SQS", "ReceiveMessage", &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/queue"),
+			MessageAttributeNames: []string{"All"},
+		}, http.StatusOK, nil)
+	})
+	assert.Equal(t, []string{"All"}, params.(*sqs.ReceiveMessageInput).MessageAttributeNames)
+}
+
+func TestSNSPublish(t *testing.T) {
+	var params interface{}
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		params, _ = invoke(t, ctx, "SNS", "Publish", &sns.PublishInput{
+			TopicArn: aws.String("arn:aws:sns:us-east-1:123456789012:topic"),
+			Message:  aws.String("message"),
+		}, http.StatusOK, nil)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "SNS PUBLISH to topic", spans[0].Name)
+	assert.Equal(t, "messaging", spans[0].Type)
+	assert.Equal(t, "sns", spans[0].Subtype)
+	assert.Equal(t, "publish", spans[0].Action)
+	assert.Contains(t, spans[0].Context.Tags, model.StringMapItem{Key: "sns_topic", Value: "topic"})
+
+	attrs := params.(*sns.PublishInput).MessageAttributes
+	require.Contains(t, attrs, "traceparent")
+	assert.Equal(t, map[string]snstypes.MessageAttributeValue{
+		"traceparent": {
+			DataType:    aws.String("String"),
+			StringValue: attrs["traceparent"].StringValue,
+		},
+	}, attrs)
+
+	_, spans, _ = apmtest.WithTransaction(func(ctx context.Context) {
+		invoke(t, ctx, "SNS", "Publish", &sns.PublishInput{
+			PhoneNumber: aws.String("+15555555555"),
+			Message:     aws.String("message"),
+		}, http.StatusOK, nil)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "SNS PUBLISH to <PHONE_NUMBER>", spans[0].Name)
+}
+
+func TestSQSMessageTraceContextTracestate(t *testing.T) {
+	traceContext, ok := apmawssdkgov2.SQSMessageTraceContext(sqstypes.Message{
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"traceparent": {StringValue: aws.String("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")},
+			"tracestate":  {StringValue: aws.String("es=s:0.5")},
+		},
+	})
+	require.True(t, ok)
+	assert.Equal(t, "es=s:0.5", traceContext.State.String())
+
+	_, ok = apmawssdkgov2.SQSMessageTraceContext(sqstypes.Message{
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"traceparent": {StringValue: aws.String("invalid")},
+		},
+	})
+	assert.False(t, ok)
+}
+
+// invoke runs the middleware stack of an operation of the service with
+// the given ID, with the tracing middleware added, returning the
+// operation parameters seen by the middleware following the tracing
+// middleware. The stack's handler responds with the given status code
+// and error.
+func invoke(t *testing.T, ctx context.Context, serviceID, operation string, params interface{}, statusCode int, err error) (interface{}, error) {
+	stack := middleware.NewStack(operation, smithyhttp.NewStackRequest)
+	require.NoError(t, stack.Initialize.Add(&awsmiddleware.RegisterServiceMetadata{
+		ServiceID:     serviceID,
+		Region:        "us-east-1",
+		OperationName: operation,
+	}, middleware.Before))
+	require.NoError(t, stack.Serialize.Add(middleware.SerializeMiddlewareFunc("SetURL", func(
+		ctx context.Context, in middleware.SerializeInput, next middleware.SerializeHandler,
+	) (middleware.SerializeOutput, middleware.Metadata, error) {
+		req := in.Request.(*smithyhttp.Request)
+		req.URL, _ = url.Parse("https://service.us-east-1.amazonaws.com/")
+		return next.HandleSerialize(ctx, in)
+	}), middleware.After))
+
+	var apiOptions []func(*middleware.Stack) error
+	apmawssdkgov2.AppendMiddlewares(&apiOptions)
+	for _, fn := range apiOptions {
+		require.NoError(t, fn(stack))
+	}
+
+	var sent interface{}
+	require.NoError(t, stack.Initialize.Add(middleware.InitializeMiddlewareFunc("Capture", func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+	) (middleware.InitializeOutput, middleware.Metadata, error) {
+		sent = in.Parameters
+		return next.HandleInitialize(ctx, in)
+	}), middleware.After))
+
+	handler := middleware.HandlerFunc(func(ctx context.Context, input interface{}) (interface{}, middleware.Metadata, error) {
+		var metadata middleware.Metadata
+		awsmiddleware.SetRequestIDMetadata(&metadata, "request-id")
+		return &smithyhttp.Response{Response: &http.Response{StatusCode: statusCode}}, metadata, err
+	})
+	_, _, err = stack.HandleMiddleware(ctx, params, handler)
+	return sent, err
+}
+
+func mustParseURL(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func TestSNSClient(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		form = req.PostForm
+		w.Header().Set("Content-Type", "text/xml")
+		w.Header().Set("X-Amzn-RequestId", "request-id")
+		fmt.Fprint(w, `<PublishResponse><PublishResult><MessageId>id</MessageId></PublishResult><ResponseMetadata><RequestId>request-id</RequestId></ResponseMetadata></PublishResponse>`)
+	}))
+	defer server.Close()
+
+	options := sns.Options{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		EndpointResolver: sns.EndpointResolverFromURL(server.URL),
+	}
+	apmawssdkgov2.AppendMiddlewares(&options.APIOptions)
+	client := sns.New(options)
+
+	tx, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := client.Publish(ctx, &sns.PublishInput{
+			TopicArn: aws.String("arn:aws:sns:us-east-1:123456789012:topic"),
+			Message:  aws.String("message"),
+		})
+		require.NoError(t, err)
+	})
+	assert.Empty(t, errs)
+	require.Len(t, spans, 1)
+	assert.Equal(t, tx.ID, spans[0].ParentID)
+	assert.Equal(t, "SNS PUBLISH to topic", spans[0].Name)
+
+	serverURL := mustParseURL(server.URL)
+	assert.Equal(t, serverURL.Hostname(), spans[0].Context.Destination.Address)
+	assert.Equal(t, http.StatusOK, spans[0].Context.HTTP.StatusCode)
+	assert.Contains(t, spans[0].Context.Tags, model.StringMapItem{Key: "aws_request_id", Value: "request-id"})
+
+	assert.Equal(t, "traceparent", form.Get("MessageAttributes.entry.1.Name"))
+	assert.Equal(t,
+		fmt.Sprintf("00-%x-%x-01", spans[0].TraceID[:], spans[0].ID[:]),
+		form.Get("MessageAttributes.entry.1.Value.StringValue"),
+	)
+}
@@ -0,0 +1,191 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.12
+
+package apmbadger
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+
+	"go.elastic.co/apm"
+)
+
+// DB wraps a *badger.DB, such that its View and Update methods
+// report transactions as spans.
+type DB struct {
+	*badger.DB
+	keyPrefixSeparator []byte
+}
+
+// Wrap wraps db such that its View and Update methods report
+// transactions as spans, using the transaction or span contained
+// in the context passed to them.
+func Wrap(db *badger.DB, o ...Option) *DB {
+	out := &DB{DB: db}
+	for _, o := range o {
+		o(out)
+	}
+	return out
+}
+
+// View calls db.DB.View, reporting the read-only transaction as a
+// span named "badger View" to Elastic APM.
+//
+// The span is tagged with the key prefixes accessed through txn,
+// and the total size of the values read with Txn.Get.
+func (db *DB) View(ctx context.Context, fn func(*Txn) error) error {
+	return db.do(ctx, "View", "view", db.DB.View, fn)
+}
+
+// Update calls db.DB.Update, reporting the read-write transaction as
+// a span named "badger Update" to Elastic APM.
+//
+// The span is tagged with the key prefixes accessed through txn, and
+// the total sizes of the values read with Txn.Get and written with
+// Txn.Set and Txn.SetEntry.
+func (db *DB) Update(ctx context.Context, fn func(*Txn) error) error {
+	return db.do(ctx, "Update", "update", db.DB.Update, fn)
+}
+
+func (db *DB) do(
+	ctx context.Context,
+	name, action string,
+	method func(func(*badger.Txn) error) error,
+	fn func(*Txn) error,
+) error {
+	span, _ := apm.StartSpan(ctx, "badger "+name, "db.badger."+action)
+	defer span.End()
+
+	txn := &Txn{keyPrefixSeparator: db.keyPrefixSeparator}
+	err := method(func(t *badger.Txn) error {
+		txn.Txn = t
+		return fn(txn)
+	})
+	if !span.Dropped() {
+		txn.setTags(span, action == "update")
+	}
+	return err
+}
+
+// Txn wraps a *badger.Txn, recording the key prefixes accessed and
+// the sizes of the values read and written through it.
+type Txn struct {
+	*badger.Txn
+	keyPrefixSeparator []byte
+	prefixes           map[string]struct{}
+	bytesRead          int64
+	bytesWritten       int64
+}
+
+// Get looks up key, as with badger.Txn.Get.
+func (txn *Txn) Get(key []byte) (*badger.Item, error) {
+	txn.addKeyPrefix(key)
+	item, err := txn.Txn.Get(key)
+	if err == nil {
+		txn.bytesRead += item.ValueSize()
+	}
+	return item, err
+}
+
+// Set adds a key-value pair, as with badger.Txn.Set.
+func (txn *Txn) Set(key, val []byte) error {
+	return txn.SetEntry(badger.NewEntry(key, val))
+}
+
+// SetEntry adds the key-value pair in e, as with badger.Txn.SetEntry.
+func (txn *Txn) SetEntry(e *badger.Entry) error {
+	txn.addKeyPrefix(e.Key)
+	if err := txn.Txn.SetEntry(e); err != nil {
+		return err
+	}
+	txn.bytesWritten += int64(len(e.Value))
+	return nil
+}
+
+// Delete deletes key, as with badger.Txn.Delete.
+func (txn *Txn) Delete(key []byte) error {
+	txn.addKeyPrefix(key)
+	return txn.Txn.Delete(key)
+}
+
+// NewIterator returns a new iterator, as with badger.Txn.NewIterator.
+// If opt.Prefix is non-empty, it is recorded as an accessed prefix.
+func (txn *Txn) NewIterator(opt badger.IteratorOptions) *badger.Iterator {
+	if len(opt.Prefix) > 0 {
+		txn.addPrefix(opt.Prefix)
+	}
+	return txn.Txn.NewIterator(opt)
+}
+
+// addKeyPrefix records the prefix of key, up to the configured key
+// prefix separator, if any. Keys without the separator are ignored.
+func (txn *Txn) addKeyPrefix(key []byte) {
+	if txn.keyPrefixSeparator == nil {
+		return
+	}
+	if i := bytes.Index(key, txn.keyPrefixSeparator); i > 0 {
+		txn.addPrefix(key[:i])
+	}
+}
+
+func (txn *Txn) addPrefix(prefix []byte) {
+	if txn.prefixes == nil {
+		txn.prefixes = make(map[string]struct{})
+	}
+	txn.prefixes[string(prefix)] = struct{}{}
+}
+
+func (txn *Txn) setTags(span *apm.Span, writable bool) {
+	if len(txn.prefixes) > 0 {
+		prefixes := make([]string, 0, len(txn.prefixes))
+		for prefix := range txn.prefixes {
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Strings(prefixes)
+		span.Context.SetTag("badger_prefixes", strings.Join(prefixes, ","))
+	}
+	span.Context.SetTag("badger_bytes_read", strconv.FormatInt(txn.bytesRead, 10))
+	if writable {
+		span.Context.SetTag("badger_bytes_written", strconv.FormatInt(txn.bytesWritten, 10))
+	}
+}
+
+// Option sets options for a DB.
+type Option func(*DB)
+
+// WithKeyPrefixSeparator returns an Option which records the prefix of
+// each key accessed with Get, Set, SetEntry, and Delete, up to the first
+// occurrence of sep. By default, only iterator prefixes are recorded.
+//
+// For example, with the separator ":", accessing the keys "user:1" and
+// "session:abc" in a transaction will record the prefixes "session" and
+// "user".
+func WithKeyPrefixSeparator(sep string) Option {
+	if sep == "" {
+		panic("sep == \"\"")
+	}
+	return func(db *DB) {
+		db.keyPrefixSeparator = []byte(sep)
+	}
+}
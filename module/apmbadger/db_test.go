@@ -0,0 +1,134 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.12
+
+package apmbadger_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmbadger"
+)
+
+func TestUpdateView(t *testing.T) {
+	db, closeDB := openDB(t, apmbadger.WithKeyPrefixSeparator(":"))
+	defer closeDB()
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		err := db.Update(ctx, func(txn *apmbadger.Txn) error {
+			if err := txn.Set([]byte("user:bob"), []byte("bob@example.com")); err != nil {
+				return err
+			}
+			if err := txn.SetEntry(badger.NewEntry([]byte("session:a"), []byte("12345"))); err != nil {
+				return err
+			}
+			return txn.Delete([]byte("unprefixed"))
+		})
+		require.NoError(t, err)
+
+		err = db.View(ctx, func(txn *apmbadger.Txn) error {
+			item, err := txn.Get([]byte("user:bob"))
+			if err != nil {
+				return err
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, "bob@example.com", string(value))
+			_, err = txn.Get([]byte("user:alice"))
+			assert.Equal(t, badger.ErrKeyNotFound, err)
+
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = []byte("session:")
+			it := txn.NewIterator(opts)
+			it.Close()
+			return nil
+		})
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "badger Update", spans[0].Name)
+	assert.Equal(t, "db", spans[0].Type)
+	assert.Equal(t, "badger", spans[0].Subtype)
+	assert.Equal(t, "update", spans[0].Action)
+	assert.Equal(t, model.StringMap{
+		{Key: "badger_bytes_read", Value: "0"},
+		{Key: "badger_bytes_written", Value: "20"},
+		{Key: "badger_prefixes", Value: "session,user"},
+	}, spans[0].Context.Tags)
+
+	assert.Equal(t, "badger View", spans[1].Name)
+	assert.Equal(t, "view", spans[1].Action)
+	assert.Equal(t, model.StringMap{
+		{Key: "badger_bytes_read", Value: "15"},
+		{Key: "badger_prefixes", Value: "session:,user"},
+	}, spans[1].Context.Tags)
+}
+
+func TestViewIteratorPrefixOnly(t *testing.T) {
+	db, closeDB := openDB(t)
+	defer closeDB()
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		err := db.View(ctx, func(txn *apmbadger.Txn) error {
+			txn.Get([]byte("user:bob"))
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = []byte("user:")
+			txn.NewIterator(opts).Close()
+			return nil
+		})
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "badger_bytes_read", Value: "0"},
+		{Key: "badger_prefixes", Value: "user:"},
+	}, spans[0].Context.Tags)
+}
+
+func TestWithKeyPrefixSeparatorEmpty(t *testing.T) {
+	assert.Panics(t, func() { apmbadger.WithKeyPrefixSeparator("") })
+}
+
+func openDB(t *testing.T, o ...apmbadger.Option) (*apmbadger.DB, func()) {
+	dir, err := ioutil.TempDir("", "apmbadger")
+	require.NoError(t, err)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return apmbadger.Wrap(db, o...), func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,9 @@
+module go.elastic.co/apm/module/apmbadger
+
+require (
+	github.com/dgraph-io/badger v1.6.0
+	github.com/stretchr/testify v1.2.2
+	go.elastic.co/apm v1.3.0
+)
+
+replace go.elastic.co/apm => ../..
@@ -0,0 +1,212 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmbolt
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+
+	"go.elastic.co/apm"
+)
+
+// DB wraps a *bolt.DB, such that its View and Update methods
+// report transactions as spans.
+type DB struct {
+	*bolt.DB
+}
+
+// Wrap wraps db such that its View and Update methods report
+// transactions as spans, using the transaction or span contained
+// in the context passed to them.
+func Wrap(db *bolt.DB) *DB {
+	return &DB{DB: db}
+}
+
+// View calls db.DB.View, reporting the read-only transaction as a
+// span named "bolt View" to Elastic APM.
+//
+// The span is tagged with the names of the buckets accessed through
+// tx, and the total size of the values read.
+func (db *DB) View(ctx context.Context, fn func(*Tx) error) error {
+	return db.do(ctx, "View", "view", db.DB.View, fn)
+}
+
+// Update calls db.DB.Update, reporting the read-write transaction as
+// a span named "bolt Update" to Elastic APM.
+//
+// The span is tagged with the names of the buckets accessed through
+// tx, and the total sizes of the values read and written.
+func (db *DB) Update(ctx context.Context, fn func(*Tx) error) error {
+	return db.do(ctx, "Update", "update", db.DB.Update, fn)
+}
+
+func (db *DB) do(
+	ctx context.Context,
+	name, action string,
+	method func(func(*bolt.Tx) error) error,
+	fn func(*Tx) error,
+) error {
+	span, _ := apm.StartSpan(ctx, "bolt "+name, "db.bolt."+action)
+	defer span.End()
+
+	stats := &txStats{}
+	err := method(func(tx *bolt.Tx) error {
+		return fn(&Tx{Tx: tx, stats: stats})
+	})
+	if !span.Dropped() {
+		stats.setTags(span, action == "update")
+	}
+	return err
+}
+
+// Tx wraps a *bolt.Tx, recording the buckets accessed and the sizes
+// of the values read and written through it.
+type Tx struct {
+	*bolt.Tx
+	stats *txStats
+}
+
+// Bucket returns the bucket with the given name, as with
+// bolt.Tx.Bucket, or nil if it does not exist.
+func (tx *Tx) Bucket(name []byte) *Bucket {
+	return tx.stats.bucket(tx.Tx.Bucket(name), "", name)
+}
+
+// CreateBucket creates and returns a new bucket, as with
+// bolt.Tx.CreateBucket.
+func (tx *Tx) CreateBucket(name []byte) (*Bucket, error) {
+	b, err := tx.Tx.CreateBucket(name)
+	return tx.stats.bucket(b, "", name), err
+}
+
+// CreateBucketIfNotExists creates a new bucket if it doesn't already
+// exist and returns it, as with bolt.Tx.CreateBucketIfNotExists.
+func (tx *Tx) CreateBucketIfNotExists(name []byte) (*Bucket, error) {
+	b, err := tx.Tx.CreateBucketIfNotExists(name)
+	return tx.stats.bucket(b, "", name), err
+}
+
+// ForEach calls fn for each bucket in the root, as with bolt.Tx.ForEach.
+func (tx *Tx) ForEach(fn func(name []byte, b *Bucket) error) error {
+	return tx.Tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return fn(name, tx.stats.bucket(b, "", name))
+	})
+}
+
+// boltBucket is an alias for bolt.Bucket, so that it may be embedded
+// in Bucket without conflicting with the Bucket method.
+type boltBucket = bolt.Bucket
+
+// Bucket wraps a *bolt.Bucket, recording the sizes of the values
+// read and written through it.
+type Bucket struct {
+	*boltBucket
+	path  string
+	stats *txStats
+}
+
+// Bucket returns the nested bucket with the given name, as with
+// bolt.Bucket.Bucket, or nil if it does not exist.
+func (b *Bucket) Bucket(name []byte) *Bucket {
+	return b.stats.bucket(b.boltBucket.Bucket(name), b.path, name)
+}
+
+// CreateBucket creates and returns a new nested bucket, as with
+// bolt.Bucket.CreateBucket.
+func (b *Bucket) CreateBucket(name []byte) (*Bucket, error) {
+	nested, err := b.boltBucket.CreateBucket(name)
+	return b.stats.bucket(nested, b.path, name), err
+}
+
+// CreateBucketIfNotExists creates a new nested bucket if it doesn't
+// already exist and returns it, as with bolt.Bucket.CreateBucketIfNotExists.
+func (b *Bucket) CreateBucketIfNotExists(name []byte) (*Bucket, error) {
+	nested, err := b.boltBucket.CreateBucketIfNotExists(name)
+	return b.stats.bucket(nested, b.path, name), err
+}
+
+// Get returns the value for key, as with bolt.Bucket.Get.
+func (b *Bucket) Get(key []byte) []byte {
+	value := b.boltBucket.Get(key)
+	b.stats.bytesRead += len(value)
+	return value
+}
+
+// Put sets the value for key, as with bolt.Bucket.Put.
+func (b *Bucket) Put(key, value []byte) error {
+	if err := b.boltBucket.Put(key, value); err != nil {
+		return err
+	}
+	b.stats.bytesWritten += len(value)
+	return nil
+}
+
+// ForEach calls fn for each key/value pair in the bucket, as with
+// bolt.Bucket.ForEach.
+func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
+	return b.boltBucket.ForEach(func(k, v []byte) error {
+		b.stats.bytesRead += len(v)
+		return fn(k, v)
+	})
+}
+
+// txStats holds the buckets accessed, and the sizes of the
+// values read and written, within a transaction.
+type txStats struct {
+	buckets      map[string]struct{}
+	bytesRead    int
+	bytesWritten int
+}
+
+// bucket records the access of the bucket with the given name nested
+// under parent, returning b wrapped, or nil if b is nil.
+func (s *txStats) bucket(b *bolt.Bucket, parent string, name []byte) *Bucket {
+	if b == nil {
+		return nil
+	}
+	path := string(name)
+	if parent != "" {
+		path = parent + "/" + path
+	}
+	if s.buckets == nil {
+		s.buckets = make(map[string]struct{})
+	}
+	s.buckets[path] = struct{}{}
+	return &Bucket{boltBucket: b, path: path, stats: s}
+}
+
+func (s *txStats) setTags(span *apm.Span, writable bool) {
+	if len(s.buckets) > 0 {
+		buckets := make([]string, 0, len(s.buckets))
+		for name := range s.buckets {
+			buckets = append(buckets, name)
+		}
+		sort.Strings(buckets)
+		span.Context.SetTag("bolt_buckets", strings.Join(buckets, ","))
+	}
+	span.Context.SetTag("bolt_bytes_read", strconv.Itoa(s.bytesRead))
+	if writable {
+		span.Context.SetTag("bolt_bytes_written", strconv.Itoa(s.bytesWritten))
+	}
+}
@@ -0,0 +1,133 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmbolt_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmbolt"
+)
+
+func TestUpdateView(t *testing.T) {
+	db, closeDB := openDB(t)
+	defer closeDB()
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		err := db.Update(ctx, func(tx *apmbolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("users"))
+			if err != nil {
+				return err
+			}
+			nested, err := b.CreateBucket([]byte("sessions"))
+			if err != nil {
+				return err
+			}
+			if err := nested.Put([]byte("a"), []byte("12345")); err != nil {
+				return err
+			}
+			return b.Put([]byte("bob"), []byte("bob@example.com"))
+		})
+		require.NoError(t, err)
+
+		err = db.View(ctx, func(tx *apmbolt.Tx) error {
+			assert.Nil(t, tx.Bucket([]byte("missing")))
+			b := tx.Bucket([]byte("users"))
+			assert.Equal(t, []byte("bob@example.com"), b.Get([]byte("bob")))
+			assert.Nil(t, b.Get([]byte("alice")))
+			return b.Bucket([]byte("sessions")).ForEach(func(k, v []byte) error {
+				return nil
+			})
+		})
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "bolt Update", spans[0].Name)
+	assert.Equal(t, "db", spans[0].Type)
+	assert.Equal(t, "bolt", spans[0].Subtype)
+	assert.Equal(t, "update", spans[0].Action)
+	assert.Equal(t, model.StringMap{
+		{Key: "bolt_buckets", Value: "users,users/sessions"},
+		{Key: "bolt_bytes_read", Value: "0"},
+		{Key: "bolt_bytes_written", Value: "20"},
+	}, spans[0].Context.Tags)
+
+	assert.Equal(t, "bolt View", spans[1].Name)
+	assert.Equal(t, "view", spans[1].Action)
+	assert.Equal(t, model.StringMap{
+		{Key: "bolt_buckets", Value: "users,users/sessions"},
+		{Key: "bolt_bytes_read", Value: "20"},
+	}, spans[1].Context.Tags)
+}
+
+func TestUpdateError(t *testing.T) {
+	db, closeDB := openDB(t)
+	defer closeDB()
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		err := db.Update(ctx, func(tx *apmbolt.Tx) error {
+			_, err := tx.CreateBucket([]byte(""))
+			return err
+		})
+		assert.Equal(t, bolt.ErrBucketNameRequired, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "bolt_bytes_read", Value: "0"},
+		{Key: "bolt_bytes_written", Value: "0"},
+	}, spans[0].Context.Tags)
+}
+
+func TestViewNoTransaction(t *testing.T) {
+	db, closeDB := openDB(t)
+	defer closeDB()
+	called := false
+	err := db.View(context.Background(), func(tx *apmbolt.Tx) error {
+		called = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, called)
+}
+
+func openDB(t *testing.T) (*apmbolt.DB, func()) {
+	dir, err := ioutil.TempDir("", "apmbolt")
+	require.NoError(t, err)
+
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return apmbolt.Wrap(db), func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,9 @@
+module go.elastic.co/apm/module/apmbolt
+
+require (
+	github.com/stretchr/testify v1.2.2
+	go.elastic.co/apm v1.3.0
+	go.etcd.io/bbolt v1.3.3
+)
+
+replace go.elastic.co/apm => ../..
@@ -43,12 +43,16 @@ func Middleware(o ...Option) func(http.Handler) http.Handler {
 		o(&opts)
 	}
 	return func(h http.Handler) http.Handler {
-		return apmhttp.Wrap(
-			h,
+		serverOpts := []apmhttp.ServerOption{
 			apmhttp.WithTracer(opts.tracer),
 			apmhttp.WithServerRequestName(routeRequestName),
 			apmhttp.WithServerRequestIgnorer(opts.requestIgnorer),
-		)
+		}
+		if opts.responseSizeTags {
+			serverOpts = append(serverOpts, apmhttp.WithResponseSizeTags())
+		}
+		serverOpts = append(serverOpts, opts.serverOpts...)
+		return apmhttp.Wrap(h, serverOpts...)
 	}
 }
 
@@ -74,8 +78,10 @@ func getRoutePattern(r *http.Request) (string, bool) {
 }
 
 type options struct {
-	tracer         *apm.Tracer
-	requestIgnorer apmhttp.RequestIgnorerFunc
+	tracer           *apm.Tracer
+	requestIgnorer   apmhttp.RequestIgnorerFunc
+	responseSizeTags bool
+	serverOpts       []apmhttp.ServerOption
 }
 
 // Option sets options for tracing.
@@ -103,3 +109,24 @@ func WithRequestIgnorer(r apmhttp.RequestIgnorerFunc) Option {
 		o.requestIgnorer = r
 	}
 }
+
+// WithResponseSizeTags returns an Option which enables recording
+// the response body size and content encoding as transaction tags.
+// See apmhttp.SetResponseSizeTags for details.
+func WithResponseSizeTags() Option {
+	return func(o *options) {
+		o.responseSizeTags = true
+	}
+}
+
+// WithServerOptions returns an Option which passes opts through to
+// apmhttp.Wrap, enabling the use of any apmhttp.ServerOption, such as
+// apmhttp.WithRecovery or apmhttp.WithProtocolTags. The options are
+// applied after those set by the middleware, so they take precedence;
+// overriding the server request name is not recommended, as it would
+// replace the route-based transaction names.
+func WithServerOptions(opts ...apmhttp.ServerOption) Option {
+	return func(o *options) {
+		o.serverOpts = append(o.serverOpts, opts...)
+	}
+}
@@ -26,7 +26,9 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"go.elastic.co/apm"
 	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/module/apmchi"
 	"go.elastic.co/apm/module/apmhttp"
@@ -142,6 +144,93 @@ func TestMiddleware_NotFound(t *testing.T) {
 	assert.Equal(t, "POST unknown route", transaction.Name)
 }
 
+func TestWithResponseSizeTags(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	r := chi.NewRouter()
+	r.Use(apmchi.Middleware(apmchi.WithTracer(tracer), apmchi.WithResponseSizeTags()))
+	r.Get("/articles/{category}/{id}", articleHandler)
+
+	doRequest(r, "GET", "http://server.testing/articles/fiction/123")
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	assert.Equal(t, model.StringMap{
+		{Key: "response_body_size", Value: "11"},
+	}, payloads.Transactions[0].Context.Tags)
+}
+
+func TestMiddlewareHijackFlush(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	r := chi.NewRouter()
+	r.Use(apmchi.Middleware(apmchi.WithTracer(tracer)))
+	r.Get("/ws", func(w http.ResponseWriter, req *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+		rw.Flush()
+	})
+	r.Get("/events", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: hello\n\n"))
+		w.(http.Flusher).Flush()
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	for _, path := range []string{"/ws", "/events"} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	tags := make(map[string]model.StringMap)
+	for _, tx := range payloads.Transactions {
+		tags[tx.Name] = tx.Context.Tags
+	}
+	assert.Equal(t, map[string]model.StringMap{
+		"GET /ws":     {{Key: "http_hijacked", Value: "true"}},
+		"GET /events": nil,
+	}, tags)
+}
+
+func TestWithServerOptions(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var recovered interface{}
+	recovery := func(w http.ResponseWriter, req *http.Request, resp *apmhttp.Response, body *apm.BodyCapturer, tx *apm.Transaction, v interface{}) {
+		recovered = v
+	}
+
+	r := chi.NewRouter()
+	r.Use(apmchi.Middleware(
+		apmchi.WithTracer(tracer),
+		apmchi.WithServerOptions(apmhttp.WithRecovery(recovery), apmhttp.WithProtocolTags()),
+	))
+	r.Get("/panic", func(w http.ResponseWriter, req *http.Request) { panic("boom") })
+
+	w := doRequest(r, "GET", "http://server.testing/panic")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "boom", recovered)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, "GET /panic", payloads.Transactions[0].Name)
+	assert.Equal(t, model.StringMap{
+		{Key: "http_protocol", Value: "http/1.1"},
+	}, payloads.Transactions[0].Context.Tags)
+}
+
 func TestWithTracer_panics(t *testing.T) {
 	assert.Panics(t, func() {
 		apmchi.WithTracer(nil)
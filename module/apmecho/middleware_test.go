@@ -105,6 +105,55 @@ func TestEchoMiddleware(t *testing.T) {
 	}, transaction.Context)
 }
 
+func TestEchoMiddlewareCORSTraceHeaders(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	// cors is a simple CORS middleware which only allows the Content-Type header.
+	cors := func(h echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.Method != "OPTIONS" {
+				return h(c)
+			}
+			if h := req.Header.Get("Access-Control-Request-Headers"); h != "" && http.CanonicalHeaderKey(h) != "Content-Type" {
+				return c.NoContent(http.StatusForbidden)
+			}
+			c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+			c.Response().Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			return c.NoContent(http.StatusNoContent)
+		}
+	}
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest("OPTIONS", "http://server.testing/hello/isbel", nil)
+		req.Header.Set("Origin", "http://client.testing")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "content-type, elastic-apm-traceparent, traceparent")
+		return req
+	}
+
+	e := echo.New()
+	e.Use(apmecho.Middleware(apmecho.WithTracer(tracer), apmecho.WithCORSTraceHeaders()))
+	e.Use(cors)
+	e.POST("/hello/:name", handleHello)
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, newRequest())
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type, elastic-apm-traceparent, traceparent", w.Header().Get("Access-Control-Allow-Headers"))
+
+	// Without the option, the CORS middleware rejects the request.
+	e = echo.New()
+	e.Use(apmecho.Middleware(apmecho.WithTracer(tracer)))
+	e.Use(cors)
+	e.POST("/hello/:name", handleHello)
+
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, newRequest())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
 func TestEchoMiddlewareUnknownRoute(t *testing.T) {
 	tracer, transport := transporttest.NewRecorderTracer()
 	defer tracer.Close()
@@ -50,22 +50,32 @@ func Middleware(o ...Option) echo.MiddlewareFunc {
 	}
 	return func(h echo.HandlerFunc) echo.HandlerFunc {
 		m := &middleware{
-			tracer:         opts.tracer,
-			handler:        h,
-			requestIgnorer: opts.requestIgnorer,
+			tracer:           opts.tracer,
+			handler:          h,
+			requestIgnorer:   opts.requestIgnorer,
+			corsTraceHeaders: opts.corsTraceHeaders,
 		}
 		return m.handle
 	}
 }
 
 type middleware struct {
-	handler        echo.HandlerFunc
-	tracer         *apm.Tracer
-	requestIgnorer apmhttp.RequestIgnorerFunc
+	handler          echo.HandlerFunc
+	tracer           *apm.Tracer
+	requestIgnorer   apmhttp.RequestIgnorerFunc
+	corsTraceHeaders bool
 }
 
 func (m *middleware) handle(c echo.Context) error {
 	req := c.Request()
+	if m.corsTraceHeaders {
+		if names := apmhttp.StripCORSTraceHeaders(req); names != nil {
+			resp := c.Response()
+			resp.Before(func() {
+				apmhttp.AllowCORSTraceHeaders(resp.Header(), names)
+			})
+		}
+	}
 	if !m.tracer.Active() || m.requestIgnorer(req) {
 		return m.handler(c)
 	}
@@ -149,8 +159,9 @@ func setContext(ctx *apm.Context, req *http.Request, resp *echo.Response, body *
 }
 
 type options struct {
-	tracer         *apm.Tracer
-	requestIgnorer apmhttp.RequestIgnorerFunc
+	tracer           *apm.Tracer
+	requestIgnorer   apmhttp.RequestIgnorerFunc
+	corsTraceHeaders bool
 }
 
 // Option sets options for tracing.
@@ -179,6 +190,21 @@ func WithRequestIgnorer(r apmhttp.RequestIgnorerFunc) Option {
 	}
 }
 
+// WithCORSTraceHeaders returns an Option which enables allowing the
+// distributed tracing headers sent by RUM agents in CORS preflight
+// requests, without adding them to the configuration of the CORS
+// middleware. The headers are removed from the preflight request's
+// Access-Control-Request-Headers before it is handled, and added to
+// the response's Access-Control-Allow-Headers if the request is allowed.
+//
+// The middleware must be added before the CORS middleware for this
+// option to take effect.
+func WithCORSTraceHeaders() Option {
+	return func(o *options) {
+		o.corsTraceHeaders = true
+	}
+}
+
 func isNotFoundHandler(h echo.HandlerFunc) bool {
 	return isHandler(h, notFoundHandlerIdentity, &echo.NotFoundHandler)
 }
@@ -49,7 +49,9 @@ func WrapRoundTripper(r http.RoundTripper, o ...ClientOption) http.RoundTripper
 }
 
 type roundTripper struct {
-	r http.RoundTripper
+	r           http.RoundTripper
+	indexNames  *indexNameSet
+	concurrency *apmhttp.ConcurrencyTracker
 }
 
 // RoundTrip delegates to r.r, emitting a span if req's context contains a transaction.
@@ -64,19 +66,25 @@ func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	ctx := req.Context()
 	tx := apm.TransactionFromContext(ctx)
 	if tx == nil || !tx.Sampled() {
-		return r.r.RoundTrip(req)
+		return r.roundTrip(req)
 	}
 
-	name := requestName(req)
-	span := tx.StartSpan(name, "db.elasticsearch", apm.SpanFromContext(ctx))
+	var name string
+	if r.indexNames != nil {
+		name = r.indexNames.indexRequestName(req)
+	} else {
+		name = requestName(req)
+	}
+	span, ctx := apm.StartSpanOptions(ctx, name, "db.elasticsearch", apm.SpanOptions{
+		ExitSpan: true,
+	})
 	if span.Dropped() {
 		span.End()
-		return r.r.RoundTrip(req)
+		return r.roundTrip(req)
 	}
 
 	statement, req := captureSearchStatement(req)
 	username, _, _ := req.BasicAuth()
-	ctx = apm.ContextWithSpan(ctx, span)
 	req = apmhttp.RequestWithContext(ctx, req)
 	span.Context.SetHTTPRequest(req)
 	span.Context.SetDatabase(apm.DatabaseSpanContext{
@@ -85,7 +93,7 @@ func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 		User:      username,
 	})
 
-	resp, err := r.r.RoundTrip(req)
+	resp, err := r.roundTrip(req)
 	if err != nil {
 		span.End()
 	} else {
@@ -95,6 +103,15 @@ func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return resp, err
 }
 
+// roundTrip calls r.r.RoundTrip, tracking the request's
+// concurrency if a ConcurrencyTracker is configured.
+func (r *roundTripper) roundTrip(req *http.Request) (*http.Response, error) {
+	if r.concurrency != nil {
+		return apmhttp.TrackConcurrency(r.concurrency, r.r, req)
+	}
+	return r.r.RoundTrip(req)
+}
+
 type responseBody struct {
 	span *apm.Span
 	body io.ReadCloser
@@ -126,6 +143,19 @@ func (b *responseBody) endSpan() {
 // ClientOption sets options for tracing client requests.
 type ClientOption func(*roundTripper)
 
+// WithConcurrencyTracker returns a ClientOption which tracks the number
+// of in-flight requests to each Elasticsearch node with t, for reporting
+// the maximum and mean concurrency per node as metrics. See
+// apmhttp.ConcurrencyTracker for details.
+func WithConcurrencyTracker(t *apmhttp.ConcurrencyTracker) ClientOption {
+	if t == nil {
+		panic("t == nil")
+	}
+	return func(rt *roundTripper) {
+		rt.concurrency = t
+	}
+}
+
 // captureSearchStatement captures the search URI query or request body.
 //
 // If the request must be modified (i.e. because the body must be read),
@@ -26,6 +26,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -34,9 +35,12 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context/ctxhttp"
 
+	"go.elastic.co/apm"
 	"go.elastic.co/apm/apmtest"
 	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/module/apmelasticsearch"
+	"go.elastic.co/apm/module/apmhttp"
+	"go.elastic.co/apm/transport/transporttest"
 )
 
 func TestWrapRoundTripper(t *testing.T) {
@@ -277,3 +281,76 @@ type roundTripperFunc func(*http.Request) (*http.Response, error)
 func (r roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 	return r(req)
 }
+
+func TestWithIndexSpanNames(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	client := &http.Client{Transport: apmelasticsearch.WrapRoundTripper(
+		http.DefaultTransport, apmelasticsearch.WithIndexSpanNames(2),
+	)}
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		for _, path := range []string{
+			"/logs-*/_search",
+			"/twitter,facebook/_doc/123",
+			"/twitter",
+			"/metrics-2019.01.01/_search", // exceeds limit
+			"/metrics-2019.01.01",         // exceeds limit
+			"/_bulk",
+			"/_cluster/health",
+		} {
+			resp, err := ctxhttp.Get(ctx, client, server.URL+path)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+	})
+	require.Len(t, spans, 7)
+
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	assert.Equal(t, []string{
+		"Elasticsearch: GET logs-*/_search",
+		"Elasticsearch: GET twitter/_doc",
+		"Elasticsearch: GET twitter",
+		"Elasticsearch: GET _search",
+		"Elasticsearch: GET",
+		"Elasticsearch: GET _bulk",
+		"Elasticsearch: GET _cluster/health",
+	}, names)
+}
+
+func TestWithConcurrencyTracker(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracker := apmhttp.NewConcurrencyTracker()
+	tracer.RegisterMetricsGatherer(tracker)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	client := &http.Client{Transport: apmelasticsearch.WrapRoundTripper(
+		http.DefaultTransport, apmelasticsearch.WithConcurrencyTracker(tracker),
+	)}
+	tx := tracer.StartTransaction("name", "type")
+	resp, err := ctxhttp.Get(apm.ContextWithTransaction(context.Background(), tx), client, server.URL+"/twitter/_search")
+	require.NoError(t, err)
+	resp.Body.Close()
+	tx.End()
+	tracer.Flush(nil)
+	tracer.SendMetrics(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Spans, 1)
+	var found bool
+	for _, m := range payloads.Metrics {
+		if len(m.Labels) == 1 && m.Labels[0].Value == serverURL.Host {
+			found = true
+			assert.Equal(t, float64(1), m.Samples["http.client.concurrency.max"].Value)
+		}
+	}
+	assert.True(t, found)
+}
@@ -0,0 +1,102 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmelasticsearch
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// WithIndexSpanNames returns a ClientOption which includes the target index
+// in span names, e.g. "Elasticsearch: GET logs-*/_search". Document IDs are
+// omitted from such span names, and only the first index of a multi-index
+// request is included.
+//
+// To bound the cardinality of span names, at most maxIndices distinct index
+// names will be included. Requests to other indices are named without the
+// index, e.g. "Elasticsearch: GET _search". maxIndices must be positive.
+//
+// Requests that do not target an index, such as "/_bulk", are named as usual.
+func WithIndexSpanNames(maxIndices int) ClientOption {
+	if maxIndices <= 0 {
+		panic("maxIndices <= 0")
+	}
+	return func(rt *roundTripper) {
+		rt.indexNames = &indexNameSet{max: maxIndices, names: make(map[string]struct{})}
+	}
+}
+
+// indexNameSet records the distinct index names seen,
+// up to a maximum number of names.
+type indexNameSet struct {
+	mu    sync.Mutex
+	max   int
+	names map[string]struct{}
+}
+
+// add reports whether name is recorded in the set,
+// adding it if the set is not yet full.
+func (s *indexNameSet) add(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.names[name]; ok {
+		return true
+	}
+	if len(s.names) >= s.max {
+		return false
+	}
+	s.names[name] = struct{}{}
+	return true
+}
+
+// indexRequestName returns the span name for req, including the first
+// target index if it is recorded in s. If the request does not target
+// an index, e.g. "/_bulk", the default request name is returned.
+func (s *indexNameSet) indexRequestName(req *http.Request) string {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	index := segments[0]
+	if index == "" || strings.HasPrefix(index, "_") {
+		return requestName(req)
+	}
+	if i := strings.IndexRune(index, ','); i >= 0 {
+		index = index[:i]
+	}
+
+	// The endpoint is the first segment beginning with an underscore,
+	// e.g. "_search" or "_doc"; segments following it, such as document
+	// IDs, are omitted.
+	var endpoint string
+	for _, segment := range segments[1:] {
+		if strings.HasPrefix(segment, "_") {
+			endpoint = segment
+			break
+		}
+	}
+
+	name := "Elasticsearch: " + req.Method
+	if s.add(index) {
+		name += " " + index
+		if endpoint != "" {
+			name += "/" + endpoint
+		}
+	} else if endpoint != "" {
+		name += " " + endpoint
+	}
+	return name
+}
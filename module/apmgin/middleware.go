@@ -48,6 +48,7 @@ func Middleware(engine *gin.Engine, o ...Option) gin.HandlerFunc {
 		engine:         engine,
 		tracer:         apm.DefaultTracer,
 		requestIgnorer: apmhttp.DefaultServerRequestIgnorer(),
+		errorTypes:     gin.ErrorTypeAny,
 	}
 	for _, o := range o {
 		o(m)
@@ -56,9 +57,12 @@ func Middleware(engine *gin.Engine, o ...Option) gin.HandlerFunc {
 }
 
 type middleware struct {
-	engine         *gin.Engine
-	tracer         *apm.Tracer
-	requestIgnorer apmhttp.RequestIgnorerFunc
+	engine           *gin.Engine
+	tracer           *apm.Tracer
+	requestIgnorer   apmhttp.RequestIgnorerFunc
+	responseSizeTags bool
+	corsTraceHeaders bool
+	errorTypes       gin.ErrorType
 
 	setRouteMapOnce sync.Once
 	routeMap        map[string]map[string]routeInfo
@@ -69,6 +73,11 @@ type routeInfo struct {
 }
 
 func (m *middleware) handle(c *gin.Context) {
+	if m.corsTraceHeaders {
+		if names := apmhttp.StripCORSTraceHeaders(c.Request); names != nil {
+			c.Writer = &corsResponseWriter{ResponseWriter: c.Writer, names: names}
+		}
+	}
 	if !m.tracer.Active() || m.requestIgnorer(c.Request) {
 		c.Next()
 		return
@@ -118,12 +127,22 @@ func (m *middleware) handle(c *gin.Context) {
 
 		if tx.Sampled() {
 			setContext(&tx.Context, c, body)
+			if m.responseSizeTags {
+				size := c.Writer.Size()
+				if size < 0 {
+					size = 0
+				}
+				apmhttp.SetResponseSizeTags(&tx.Context, int64(size), c.Writer.Header())
+			}
 		}
 
-		for _, err := range c.Errors {
+		for _, err := range c.Errors.ByType(m.errorTypes) {
 			e := m.tracer.NewError(err.Err)
 			e.SetTransaction(tx)
 			setContext(&e.Context, c, body)
+			if name := errorTypeName(err.Type); name != "" {
+				e.Context.SetTag("gin_error_type", name)
+			}
 			e.Handled = true
 			e.Send()
 		}
@@ -139,6 +158,65 @@ func setContext(ctx *apm.Context, c *gin.Context, body *apm.BodyCapturer) {
 	ctx.SetHTTPResponseHeaders(c.Writer.Header())
 }
 
+// errorTypeNames holds the names of the gin.ErrorType bits
+// recorded in the "gin_error_type" tag of reported errors.
+var errorTypeNames = []struct {
+	typ  gin.ErrorType
+	name string
+}{
+	{gin.ErrorTypeBind, "bind"},
+	{gin.ErrorTypeRender, "render"},
+	{gin.ErrorTypePublic, "public"},
+	{gin.ErrorTypePrivate, "private"},
+}
+
+// errorTypeName returns the comma-separated names of the
+// well-known bits set in t, or the empty string if there are none.
+func errorTypeName(t gin.ErrorType) string {
+	var name string
+	for _, n := range errorTypeNames {
+		if t&n.typ == 0 {
+			continue
+		}
+		if name != "" {
+			name += ","
+		}
+		name += n.name
+	}
+	return name
+}
+
+// corsResponseWriter wraps a gin.ResponseWriter, adding the distributed
+// tracing headers stripped from a CORS preflight request to the allowed
+// headers before the response headers are written.
+type corsResponseWriter struct {
+	gin.ResponseWriter
+	names   []string
+	allowed bool
+}
+
+func (w *corsResponseWriter) allow() {
+	if !w.allowed {
+		w.allowed = true
+		apmhttp.AllowCORSTraceHeaders(w.Header(), w.names)
+	}
+}
+
+func (w *corsResponseWriter) WriteHeaderNow() {
+	w.allow()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *corsResponseWriter) Write(data []byte) (int, error) {
+	w.allow()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *corsResponseWriter) WriteString(s string) (int, error) {
+	w.allow()
+	return w.ResponseWriter.WriteString(s)
+}
+
 // Option sets options for tracing.
 type Option func(*middleware)
 
@@ -164,3 +242,39 @@ func WithRequestIgnorer(r apmhttp.RequestIgnorerFunc) Option {
 		m.requestIgnorer = r
 	}
 }
+
+// WithResponseSizeTags returns an Option which enables recording
+// the response body size and content encoding as transaction tags.
+// See apmhttp.SetResponseSizeTags for details.
+func WithResponseSizeTags() Option {
+	return func(m *middleware) {
+		m.responseSizeTags = true
+	}
+}
+
+// WithCORSTraceHeaders returns an Option which enables allowing the
+// distributed tracing headers sent by RUM agents in CORS preflight
+// requests, without adding them to the configuration of the CORS
+// middleware. The headers are removed from the preflight request's
+// Access-Control-Request-Headers before it is handled, and added to
+// the response's Access-Control-Allow-Headers if the request is allowed.
+//
+// The middleware must be added before the CORS middleware for this
+// option to take effect.
+func WithCORSTraceHeaders() Option {
+	return func(m *middleware) {
+		m.corsTraceHeaders = true
+	}
+}
+
+// WithErrorTypes returns an Option which sets the types of the errors
+// attached to the request with gin.Context.Error that are reported to
+// Elastic APM when the request completes, e.g. gin.ErrorTypePrivate to
+// report errors not intended for the client. Reported errors are tagged
+// with "gin_error_type", the names of their types. By default, errors of
+// all types are reported.
+func WithErrorTypes(types gin.ErrorType) Option {
+	return func(m *middleware) {
+		m.errorTypes = types
+	}
+}
@@ -117,6 +117,67 @@ func TestMiddleware(t *testing.T) {
 	}, transaction.Context)
 }
 
+func TestMiddlewareResponseSizeTags(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	e := gin.New()
+	e.Use(apmgin.Middleware(e, apmgin.WithTracer(tracer), apmgin.WithResponseSizeTags()))
+	e.GET("/hello/:name", handleHello)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://server.testing/hello/isbel", nil)
+	e.ServeHTTP(w, req)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	assert.Equal(t, model.StringMap{
+		{Key: "response_body_size", Value: "13"},
+	}, payloads.Transactions[0].Context.Tags)
+}
+
+func TestMiddlewareCORSTraceHeaders(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	// cors is a simple CORS middleware which only allows the Content-Type header.
+	cors := func(c *gin.Context) {
+		if c.Request.Method != "OPTIONS" {
+			return
+		}
+		if h := c.GetHeader("Access-Control-Request-Headers"); h != "" && http.CanonicalHeaderKey(h) != "Content-Type" {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Access-Control-Allow-Headers", "Content-Type")
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+
+	e := gin.New()
+	e.Use(apmgin.Middleware(e, apmgin.WithTracer(tracer), apmgin.WithCORSTraceHeaders()))
+	e.Use(cors)
+	e.POST("/hello/:name", handleHello)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "http://server.testing/hello/isbel", nil)
+	req.Header.Set("Origin", "http://client.testing")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type, elastic-apm-traceparent, traceparent")
+	e.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "Content-Type, elastic-apm-traceparent, traceparent", w.Header().Get("Access-Control-Allow-Headers"))
+
+	// Without the option, the CORS middleware rejects the request.
+	e2 := gin.New()
+	e2.Use(apmgin.Middleware(e2, apmgin.WithTracer(tracer)))
+	e2.Use(cors)
+	w = httptest.NewRecorder()
+	req.Header.Set("Access-Control-Request-Headers", "content-type, elastic-apm-traceparent, traceparent")
+	e2.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
 func TestMiddlewareUnknownRoute(t *testing.T) {
 	debugOutput.Reset()
 	tracer, transport := transporttest.NewRecorderTracer()
@@ -182,6 +243,46 @@ func TestMiddlewareError(t *testing.T) {
 	assertError(t, transport.Payloads(), "handleError", "wot", true)
 }
 
+func TestMiddlewareErrorTypes(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	e := gin.New()
+	e.Use(apmgin.Middleware(e, apmgin.WithTracer(tracer), apmgin.WithErrorTypes(gin.ErrorTypePrivate)))
+	e.GET("/errors", handleErrors)
+
+	w := doRequest(e, "GET", "http://server.testing/errors")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Errors, 1)
+	error0 := assertError(t, payloads, "handleErrors", "private", true)
+	assert.Equal(t, model.StringMap{{Key: "gin_error_type", Value: "private"}}, error0.Context.Tags)
+	assert.Equal(t, payloads.Transactions[0].ID, error0.TransactionID)
+}
+
+func TestMiddlewareErrorTypesDefault(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	e := gin.New()
+	e.Use(apmgin.Middleware(e, apmgin.WithTracer(tracer)))
+	e.GET("/errors", handleErrors)
+
+	doRequest(e, "GET", "http://server.testing/errors")
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Errors, 3)
+	var types []string
+	for _, e := range payloads.Errors {
+		require.Len(t, e.Context.Tags, 1)
+		types = append(types, e.Context.Tags[0].Value)
+	}
+	assert.ElementsMatch(t, []string{"private", "public", "bind"}, types)
+}
+
 func assertError(t *testing.T, payloads transporttest.Payloads, culprit, message string, handled bool) model.Error {
 	error0 := payloads.Errors[0]
 
@@ -207,6 +308,13 @@ func handleError(c *gin.Context) {
 	c.AbortWithError(500, errors.New("wot"))
 }
 
+func handleErrors(c *gin.Context) {
+	c.Error(errors.New("private"))
+	c.Error(errors.New("public")).SetType(gin.ErrorTypePublic)
+	c.Error(errors.New("bind")).SetType(gin.ErrorTypeBind)
+	c.Status(http.StatusBadRequest)
+}
+
 func doRequest(e *gin.Engine, method, url string) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest(method, url, nil)
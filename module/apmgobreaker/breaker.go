@@ -0,0 +1,129 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmgobreaker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sony/gobreaker"
+
+	"go.elastic.co/apm"
+)
+
+// Gatherer is an apm.MetricsGatherer which reports the state of the
+// circuit breakers created with its NewCircuitBreaker method, and the
+// number of state transitions they have made.
+//
+// For each circuit breaker, a metric set labeled with "breaker" holding
+// the breaker's name is reported, containing the metrics:
+//
+//  - gobreaker.state: 0 for closed, 1 for half-open, and 2 for open
+//  - gobreaker.transitions.closed: number of transitions to closed
+//  - gobreaker.transitions.half_open: number of transitions to half-open
+//  - gobreaker.transitions.open: number of transitions to open
+//
+// The Gatherer must be registered with a tracer using
+// apm.Tracer.RegisterMetricsGatherer.
+type Gatherer struct {
+	mu       sync.RWMutex
+	breakers []*CircuitBreaker
+}
+
+// NewGatherer returns a new Gatherer.
+func NewGatherer() *Gatherer {
+	return &Gatherer{}
+}
+
+// NewCircuitBreaker returns a new CircuitBreaker configured with st, as
+// with gobreaker.NewCircuitBreaker, whose metrics are reported by g.
+//
+// If st.OnStateChange is non-nil, it will be called as usual after the
+// state transition has been recorded.
+func (g *Gatherer) NewCircuitBreaker(st gobreaker.Settings) *CircuitBreaker {
+	cb := &CircuitBreaker{}
+	onStateChange := st.OnStateChange
+	st.OnStateChange = func(name string, from, to gobreaker.State) {
+		if int(to) < len(cb.transitions) {
+			atomic.AddUint64(&cb.transitions[to], 1)
+		}
+		if onStateChange != nil {
+			onStateChange(name, from, to)
+		}
+	}
+	cb.CircuitBreaker = gobreaker.NewCircuitBreaker(st)
+
+	g.mu.Lock()
+	g.breakers = append(g.breakers, cb)
+	g.mu.Unlock()
+	return cb
+}
+
+// GatherMetrics gathers circuit breaker metrics into m.
+func (g *Gatherer) GatherMetrics(ctx context.Context, m *apm.Metrics) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, cb := range g.breakers {
+		labels := []apm.MetricLabel{{Name: "breaker", Value: cb.Name()}}
+		m.Add("gobreaker.state", labels, float64(cb.State()))
+		m.Add("gobreaker.transitions.closed", labels, float64(atomic.LoadUint64(&cb.transitions[gobreaker.StateClosed])))
+		m.Add("gobreaker.transitions.half_open", labels, float64(atomic.LoadUint64(&cb.transitions[gobreaker.StateHalfOpen])))
+		m.Add("gobreaker.transitions.open", labels, float64(atomic.LoadUint64(&cb.transitions[gobreaker.StateOpen])))
+	}
+	return nil
+}
+
+// CircuitBreaker wraps a *gobreaker.CircuitBreaker, such that its
+// Execute method reports calls as spans.
+type CircuitBreaker struct {
+	// transitions is indexed by gobreaker.State, and accessed
+	// atomically; it must be 64-bit aligned.
+	transitions [3]uint64
+
+	*gobreaker.CircuitBreaker
+}
+
+// Execute calls req through the circuit breaker, as with
+// gobreaker.CircuitBreaker.Execute, reporting the call as a span
+// named after the circuit breaker.
+//
+// The span is tagged with "gobreaker_state", holding the state of the
+// circuit breaker at the time of the call ("closed", "half-open", or
+// "open"). If the circuit breaker rejects the call, the span is also
+// tagged with "gobreaker_rejected", and req is not called. The context
+// passed to req contains the span, so that any spans started by req
+// are reported as its children.
+func (cb *CircuitBreaker) Execute(ctx context.Context, req func(context.Context) (interface{}, error)) (interface{}, error) {
+	span, ctx := apm.StartSpan(ctx, cb.Name(), "gobreaker")
+	defer span.End()
+	if !span.Dropped() {
+		span.Context.SetTag("gobreaker_state", cb.State().String())
+	}
+	result, err := cb.CircuitBreaker.Execute(func() (interface{}, error) {
+		return req(ctx)
+	})
+	if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+		if !span.Dropped() {
+			span.Context.SetTag("gobreaker_rejected", "true")
+		}
+	}
+	return result, err
+}
@@ -0,0 +1,132 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmgobreaker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmgobreaker"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestExecute(t *testing.T) {
+	var stateChanges []gobreaker.State
+	g := apmgobreaker.NewGatherer()
+	cb := g.NewCircuitBreaker(gobreaker.Settings{
+		Name:    "backend",
+		Timeout: time.Hour,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= 1
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			stateChanges = append(stateChanges, to)
+		},
+	})
+
+	errFailed := errors.New("failed")
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		result, err := cb.Execute(ctx, func(ctx context.Context) (interface{}, error) {
+			span, _ := apm.StartSpan(ctx, "child", "custom")
+			span.End()
+			return "ok", nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "ok", result)
+
+		_, err = cb.Execute(ctx, func(ctx context.Context) (interface{}, error) {
+			return nil, errFailed
+		})
+		assert.Equal(t, errFailed, err)
+
+		_, err = cb.Execute(ctx, func(ctx context.Context) (interface{}, error) {
+			panic("should not be called")
+		})
+		assert.Equal(t, gobreaker.ErrOpenState, err)
+	})
+	require.Len(t, spans, 4)
+	assert.Equal(t, []gobreaker.State{gobreaker.StateOpen}, stateChanges)
+
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, spans[1].ID, spans[0].ParentID)
+	assert.Equal(t, "backend", spans[1].Name)
+	assert.Equal(t, "gobreaker", spans[1].Type)
+	assert.Equal(t, model.StringMap{{Key: "gobreaker_state", Value: "closed"}}, spans[1].Context.Tags)
+	assert.Equal(t, model.StringMap{{Key: "gobreaker_state", Value: "closed"}}, spans[2].Context.Tags)
+	assert.Equal(t, model.StringMap{
+		{Key: "gobreaker_rejected", Value: "true"},
+		{Key: "gobreaker_state", Value: "open"},
+	}, spans[3].Context.Tags)
+}
+
+func TestGatherer(t *testing.T) {
+	g := apmgobreaker.NewGatherer()
+	cb := g.NewCircuitBreaker(gobreaker.Settings{
+		Name:    "backend",
+		Timeout: time.Hour,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= 1
+		},
+	})
+	g.NewCircuitBreaker(gobreaker.Settings{Name: "other"})
+
+	cb.Execute(context.Background(), func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.RegisterMetricsGatherer(g)
+	tracer.SendMetrics(nil)
+
+	var metrics []model.Metrics
+	for _, m := range transport.Payloads().Metrics {
+		if len(m.Labels) != 0 {
+			m.Timestamp = model.Time{}
+			metrics = append(metrics, m)
+		}
+	}
+	assert.Equal(t, []model.Metrics{{
+		Labels: model.StringMap{{Key: "breaker", Value: "backend"}},
+		Samples: map[string]model.Metric{
+			"gobreaker.state":                 {Value: 2},
+			"gobreaker.transitions.closed":    {Value: 0},
+			"gobreaker.transitions.half_open": {Value: 0},
+			"gobreaker.transitions.open":      {Value: 1},
+		},
+	}, {
+		Labels: model.StringMap{{Key: "breaker", Value: "other"}},
+		Samples: map[string]model.Metric{
+			"gobreaker.state":                 {Value: 0},
+			"gobreaker.transitions.closed":    {Value: 0},
+			"gobreaker.transitions.half_open": {Value: 0},
+			"gobreaker.transitions.open":      {Value: 0},
+		},
+	}}, metrics)
+}
@@ -0,0 +1,9 @@
+module go.elastic.co/apm/module/apmgobreaker
+
+require (
+	github.com/sony/gobreaker v0.4.1
+	github.com/stretchr/testify v1.2.2
+	go.elastic.co/apm v1.3.0
+)
+
+replace go.elastic.co/apm => ../..
@@ -0,0 +1,121 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.12
+
+package apmgocloud
+
+import (
+	"context"
+
+	"gocloud.dev/blob"
+
+	"go.elastic.co/apm"
+)
+
+// Bucket wraps a *blob.Bucket, reporting its operations as spans.
+//
+// The ReadAll, WriteAll, Exists, Attributes, Copy and Delete methods
+// are traced, if the context passed in contains a transaction. Other
+// methods are not traced, including NewReader and NewWriter, as the
+// returned readers and writers are not instrumentable.
+type Bucket struct {
+	*blob.Bucket
+	provider provider
+}
+
+// OpenBucket opens the bucket identified by urlstr using
+// blob.OpenBucket, and wraps it with WrapBucket. The URL scheme
+// is used to identify the provider.
+func OpenBucket(ctx context.Context, urlstr string) (*Bucket, error) {
+	p, err := parseProviderURL(urlstr)
+	if err != nil {
+		return nil, err
+	}
+	b, err := blob.OpenBucket(ctx, urlstr)
+	if err != nil {
+		return nil, err
+	}
+	return &Bucket{Bucket: b, provider: p}, nil
+}
+
+// WrapBucket wraps b such that its operations are reported as spans.
+// The scheme is the Go CDK URL scheme identifying the driver backing
+// b (e.g. "s3", or "gs"), and name is the name of the bucket.
+func WrapBucket(b *blob.Bucket, scheme, name string) *Bucket {
+	return &Bucket{Bucket: b, provider: newProvider(scheme, name)}
+}
+
+// ReadAll reads the blob with the given key, reporting a span.
+func (b *Bucket) ReadAll(ctx context.Context, key string) ([]byte, error) {
+	span, ctx := b.startSpan(ctx, "ReadAll", "read")
+	defer span.End()
+	return b.Bucket.ReadAll(ctx, key)
+}
+
+// WriteAll writes p to the blob with the given key, reporting a span.
+func (b *Bucket) WriteAll(ctx context.Context, key string, p []byte, opts *blob.WriterOptions) error {
+	span, ctx := b.startSpan(ctx, "WriteAll", "write")
+	defer span.End()
+	return b.Bucket.WriteAll(ctx, key, p, opts)
+}
+
+// Exists reports whether the blob with the given key exists,
+// reporting a span.
+func (b *Bucket) Exists(ctx context.Context, key string) (bool, error) {
+	span, ctx := b.startSpan(ctx, "Exists", "attributes")
+	defer span.End()
+	return b.Bucket.Exists(ctx, key)
+}
+
+// Attributes returns the attributes of the blob with the given key,
+// reporting a span.
+func (b *Bucket) Attributes(ctx context.Context, key string) (*blob.Attributes, error) {
+	span, ctx := b.startSpan(ctx, "Attributes", "attributes")
+	defer span.End()
+	return b.Bucket.Attributes(ctx, key)
+}
+
+// Copy copies the blob stored at srcKey to dstKey, reporting a span.
+func (b *Bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *blob.CopyOptions) error {
+	span, ctx := b.startSpan(ctx, "Copy", "copy")
+	defer span.End()
+	return b.Bucket.Copy(ctx, dstKey, srcKey, opts)
+}
+
+// Delete deletes the blob with the given key, reporting a span.
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	span, ctx := b.startSpan(ctx, "Delete", "delete")
+	defer span.End()
+	return b.Bucket.Delete(ctx, key)
+}
+
+func (b *Bucket) startSpan(ctx context.Context, method, action string) (*apm.Span, context.Context) {
+	name := b.provider.subtype + " " + method
+	if b.provider.name != "" {
+		name += " " + b.provider.name
+	}
+	return startSpan(ctx, name, b.provider.spanType("storage", action), b.provider)
+}
+
+func startSpan(ctx context.Context, name, spanType string, p provider) (*apm.Span, context.Context) {
+	span, ctx := apm.StartSpanOptions(ctx, name, spanType, apm.SpanOptions{ExitSpan: true})
+	if !span.Dropped() && p.address != "" {
+		span.Context.SetDestinationAddress(p.address, p.port)
+	}
+	return span, ctx
+}
@@ -0,0 +1,76 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.12
+
+package apmgocloud_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "gocloud.dev/blob/memblob"
+
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/module/apmgocloud"
+)
+
+func TestBucket(t *testing.T) {
+	ctx := context.Background()
+	bucket, err := apmgocloud.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		require.NoError(t, bucket.WriteAll(ctx, "key", []byte("value"), nil))
+		data, err := bucket.ReadAll(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "value", string(data))
+		exists, err := bucket.Exists(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		require.NoError(t, bucket.Delete(ctx, "key"))
+	})
+	require.Len(t, spans, 4)
+
+	var names, actions []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+		actions = append(actions, span.Action)
+		assert.Equal(t, "storage", span.Type)
+		assert.Equal(t, "mem", span.Subtype)
+	}
+	assert.Equal(t, []string{"mem WriteAll", "mem ReadAll", "mem Exists", "mem Delete"}, names)
+	assert.Equal(t, []string{"write", "read", "attributes", "delete"}, actions)
+}
+
+func TestWrapBucketName(t *testing.T) {
+	ctx := context.Background()
+	bucket, err := apmgocloud.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	wrapped := apmgocloud.WrapBucket(bucket.Bucket, "s3", "my-bucket")
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		wrapped.Exists(ctx, "key")
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "s3 Exists my-bucket", spans[0].Name)
+	assert.Equal(t, "s3", spans[0].Subtype)
+}
@@ -0,0 +1,124 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.12
+
+package apmgocloud
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"gocloud.dev/docstore"
+
+	"go.elastic.co/apm"
+)
+
+// Collection wraps a *docstore.Collection, reporting its operations
+// as spans.
+//
+// The single-document methods Create, Replace, Put, Delete, Get and
+// Update are traced, if the context passed in contains a transaction.
+// Action lists and queries are not traced.
+type Collection struct {
+	*docstore.Collection
+	provider provider
+}
+
+// OpenCollection opens the collection identified by urlstr using
+// docstore.OpenCollection, and wraps it with WrapCollection. The URL
+// scheme is used to identify the provider.
+func OpenCollection(ctx context.Context, urlstr string) (*Collection, error) {
+	p, err := parseProviderURL(urlstr)
+	if err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(urlstr); err == nil && u.Scheme == "mem" {
+		// mem://collection/keyField
+		p.name = u.Host
+	}
+	c, err := docstore.OpenCollection(ctx, urlstr)
+	if err != nil {
+		return nil, err
+	}
+	return &Collection{Collection: c, provider: p}, nil
+}
+
+// WrapCollection wraps c such that its operations are reported as spans.
+// The scheme is the Go CDK URL scheme identifying the driver backing c
+// (e.g. "dynamodb", or "mongo"), and name is the name of the collection.
+func WrapCollection(c *docstore.Collection, scheme, name string) *Collection {
+	return &Collection{Collection: c, provider: newProvider(scheme, name)}
+}
+
+// Create creates doc in the collection, reporting a span.
+func (c *Collection) Create(ctx context.Context, doc docstore.Document) error {
+	span, ctx := c.startSpan(ctx, "Create")
+	defer span.End()
+	return c.Collection.Create(ctx, doc)
+}
+
+// Replace replaces doc in the collection, reporting a span.
+func (c *Collection) Replace(ctx context.Context, doc docstore.Document) error {
+	span, ctx := c.startSpan(ctx, "Replace")
+	defer span.End()
+	return c.Collection.Replace(ctx, doc)
+}
+
+// Put puts doc into the collection, reporting a span.
+func (c *Collection) Put(ctx context.Context, doc docstore.Document) error {
+	span, ctx := c.startSpan(ctx, "Put")
+	defer span.End()
+	return c.Collection.Put(ctx, doc)
+}
+
+// Delete deletes doc from the collection, reporting a span.
+func (c *Collection) Delete(ctx context.Context, doc docstore.Document) error {
+	span, ctx := c.startSpan(ctx, "Delete")
+	defer span.End()
+	return c.Collection.Delete(ctx, doc)
+}
+
+// Get gets doc from the collection, reporting a span.
+func (c *Collection) Get(ctx context.Context, doc docstore.Document, fps ...docstore.FieldPath) error {
+	span, ctx := c.startSpan(ctx, "Get")
+	defer span.End()
+	return c.Collection.Get(ctx, doc, fps...)
+}
+
+// Update applies mods to doc in the collection, reporting a span.
+func (c *Collection) Update(ctx context.Context, doc docstore.Document, mods docstore.Mods) error {
+	span, ctx := c.startSpan(ctx, "Update")
+	defer span.End()
+	return c.Collection.Update(ctx, doc, mods)
+}
+
+func (c *Collection) startSpan(ctx context.Context, method string) (*apm.Span, context.Context) {
+	name := c.provider.subtype + " " + method
+	if c.provider.name != "" {
+		name = c.provider.name + "." + strings.ToLower(method)
+	}
+	span, ctx := startSpan(ctx, name, c.provider.spanType("db", "query"), c.provider)
+	if !span.Dropped() {
+		span.Context.SetDatabase(apm.DatabaseSpanContext{
+			Instance: c.provider.name,
+			Type:     c.provider.subtype,
+		})
+	}
+	return span, ctx
+}
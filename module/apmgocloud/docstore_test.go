@@ -0,0 +1,69 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.12
+
+package apmgocloud_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "gocloud.dev/docstore/memdocstore"
+
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmgocloud"
+)
+
+type player struct {
+	Name  string
+	Score int
+}
+
+func TestCollection(t *testing.T) {
+	ctx := context.Background()
+	coll, err := apmgocloud.OpenCollection(ctx, "mem://players/Name")
+	require.NoError(t, err)
+	defer coll.Close()
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		require.NoError(t, coll.Put(ctx, &player{Name: "birita", Score: 10}))
+		p := player{Name: "birita"}
+		require.NoError(t, coll.Get(ctx, &p))
+		assert.Equal(t, 10, p.Score)
+		require.NoError(t, coll.Delete(ctx, &p))
+	})
+	require.Len(t, spans, 3)
+
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+		assert.Equal(t, "db", span.Type)
+		assert.Equal(t, "mem", span.Subtype)
+		assert.Equal(t, "query", span.Action)
+		assert.Equal(t, &model.SpanContext{
+			Database: &model.DatabaseSpanContext{
+				Instance: "players",
+				Type:     "mem",
+			},
+		}, span.Context)
+	}
+	assert.Equal(t, []string{"players.put", "players.get", "players.delete"}, names)
+}
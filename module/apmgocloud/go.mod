@@ -0,0 +1,13 @@
+module go.elastic.co/apm/module/apmgocloud
+
+require (
+	github.com/stretchr/testify v1.3.0
+	go.elastic.co/apm v1.3.0
+	go.elastic.co/apm/module/apmhttp v1.3.0
+	gocloud.dev v0.16.0
+	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
+)
+
+replace go.elastic.co/apm => ../..
+
+replace go.elastic.co/apm/module/apmhttp => ../apmhttp
@@ -0,0 +1,187 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.12
+
+package apmgocloud
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// providerSubtypes maps Go CDK URL schemes to span subtypes, for those
+// schemes which do not correspond directly to a well-known service name.
+var providerSubtypes = map[string]string{
+	"awssns":    "sns",
+	"awssqs":    "sqs",
+	"azblob":    "azureblob",
+	"azuresb":   "azureservicebus",
+	"gs":        "gcs",
+	"gcppubsub": "pubsub",
+	"mongo":     "mongodb",
+	"rabbit":    "rabbitmq",
+}
+
+// provider describes the driver backing a Go CDK portable type.
+type provider struct {
+	// subtype holds the span subtype for the driver.
+	subtype string
+
+	// name holds the name of the bucket, topic, subscription or
+	// collection, for use in span names.
+	name string
+
+	// address and port hold the destination address for the driver,
+	// if they can be determined from the URL or the environment.
+	address string
+	port    int
+}
+
+// newProvider returns a provider with the given URL scheme and
+// resource name.
+func newProvider(scheme, name string) provider {
+	subtype, ok := providerSubtypes[scheme]
+	if !ok {
+		subtype = scheme
+	}
+	return provider{subtype: subtype, name: name}
+}
+
+// parseProviderURL returns a provider describing the driver
+// identified by the Go CDK URL urlstr.
+func parseProviderURL(urlstr string) (provider, error) {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		return provider{}, err
+	}
+	name := path.Base(u.Host + u.Path)
+	if name == "." || name == "/" {
+		name = ""
+	}
+	p := newProvider(u.Scheme, name)
+	if u.Scheme == "awssqs" {
+		// SQS queue URLs identify the regional endpoint.
+		p.address = u.Hostname()
+	}
+	return p, nil
+}
+
+// parsePubSubProviderURL returns a provider describing the pubsub
+// driver identified by the Go CDK URL urlstr, with its destination.
+func parsePubSubProviderURL(urlstr string) (provider, error) {
+	p, err := parseProviderURL(urlstr)
+	if err != nil {
+		return provider{}, err
+	}
+	u, _ := url.Parse(urlstr)
+	p.address, p.port = pubsubDestination(u.Scheme, u)
+	return p, nil
+}
+
+// newPubSubProvider returns a provider with the given URL scheme and
+// pubsub topic or subscription name, with its destination.
+func newPubSubProvider(scheme, name string) provider {
+	p := newProvider(scheme, name)
+	p.address, p.port = pubsubDestination(scheme, nil)
+	return p
+}
+
+// pubsubDestination returns the destination address and port of the
+// pubsub driver with the given scheme, using the driver's URL, if known,
+// and the environment variables consulted by the driver. The address is
+// empty for the in-memory driver, and if the destination cannot be
+// determined.
+func pubsubDestination(scheme string, u *url.URL) (string, int) {
+	switch scheme {
+	case "awssqs":
+		if u != nil {
+			return hostPort(u.Host)
+		}
+	case "awssns":
+		// SNS topics are identified by ARN, which
+		// includes the region of the endpoint:
+		// arn:aws:sns:<region>:<account>:<topic>.
+		// Otherwise the region is configured by the
+		// AWS session, e.g. with AWS_REGION.
+		var region string
+		if u != nil {
+			region = u.Query().Get("region")
+			if region == "" {
+				if fields := strings.Split(strings.TrimPrefix(u.Path, "/"), ":"); len(fields) == 6 {
+					region = fields[3]
+				}
+			}
+		}
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region != "" {
+			return "sns." + region + ".amazonaws.com", 0
+		}
+	case "gcppubsub":
+		if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+			return hostPort(host)
+		}
+		return "pubsub.googleapis.com", 443
+	case "azuresb":
+		// The connection string holds the namespace endpoint:
+		// Endpoint=sb://<namespace>.servicebus.windows.net/;...
+		for _, field := range strings.Split(os.Getenv("SERVICEBUS_CONNECTION_STRING"), ";") {
+			if strings.HasPrefix(field, "Endpoint=") {
+				if endpoint, err := url.Parse(field[len("Endpoint="):]); err == nil {
+					return hostPort(endpoint.Host)
+				}
+			}
+		}
+	case "kafka":
+		// Report the first of the comma-separated bootstrap brokers.
+		if brokers := os.Getenv("KAFKA_BROKERS"); brokers != "" {
+			return hostPort(strings.TrimSpace(strings.Split(brokers, ",")[0]))
+		}
+	case "nats":
+		if server, err := url.Parse(os.Getenv("NATS_SERVER_URL")); err == nil {
+			return hostPort(server.Host)
+		}
+	case "rabbit":
+		if server, err := url.Parse(os.Getenv("RABBIT_SERVER_URL")); err == nil {
+			return hostPort(server.Host)
+		}
+	}
+	return "", 0
+}
+
+// hostPort splits hostport into a host and port, returning a zero
+// port if hostport has none.
+func hostPort(hostport string) (string, int) {
+	host, portString, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport, 0
+	}
+	port, _ := strconv.Atoi(portString)
+	return host, port
+}
+
+// spanType returns the span type string for the given type
+// and action, using p's subtype.
+func (p provider) spanType(spanType, action string) string {
+	return spanType + "." + p.subtype + "." + action
+}
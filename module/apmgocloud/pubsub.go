@@ -0,0 +1,191 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.12
+
+package apmgocloud
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gocloud.dev/pubsub"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmhttp"
+)
+
+// TraceparentMetadataKey is the message metadata key in which
+// Topic.Send records the trace context of the sending span.
+var TraceparentMetadataKey = strings.ToLower(apmhttp.TraceparentHeader)
+
+// EnqueueTimeMetadataKey is the message metadata key in which
+// Topic.Send records the time at which the message was sent,
+// formatted as RFC 3339 with nanoseconds.
+const EnqueueTimeMetadataKey = "elastic-apm-enqueue-time"
+
+// Topic wraps a *pubsub.Topic, reporting Send operations as spans.
+type Topic struct {
+	*pubsub.Topic
+	provider provider
+}
+
+// OpenTopic opens the topic identified by urlstr using
+// pubsub.OpenTopic, and wraps it with WrapTopic. The URL scheme
+// is used to identify the provider.
+func OpenTopic(ctx context.Context, urlstr string) (*Topic, error) {
+	p, err := parsePubSubProviderURL(urlstr)
+	if err != nil {
+		return nil, err
+	}
+	t, err := pubsub.OpenTopic(ctx, urlstr)
+	if err != nil {
+		return nil, err
+	}
+	return &Topic{Topic: t, provider: p}, nil
+}
+
+// WrapTopic wraps t such that Send operations are reported as spans.
+// The scheme is the Go CDK URL scheme identifying the driver backing
+// t (e.g. "awssns", or "kafka"), and name is the name of the topic.
+func WrapTopic(t *pubsub.Topic, scheme, name string) *Topic {
+	return &Topic{Topic: t, provider: newPubSubProvider(scheme, name)}
+}
+
+// Send sends m to the topic, reporting a span if ctx contains a
+// transaction. The trace context is recorded in the metadata of a
+// copy of m, with the key TraceparentMetadataKey, so that receivers
+// may continue the trace; see MessageTraceContext. The send time is
+// recorded alongside it with the key EnqueueTimeMetadataKey; see
+// MessageEnqueueTime.
+func (t *Topic) Send(ctx context.Context, m *pubsub.Message) error {
+	span, ctx := startMessagingSpan(ctx, t.provider, "SEND", "send")
+	defer span.End()
+
+	var traceContext apm.TraceContext
+	if !span.Dropped() {
+		traceContext = span.TraceContext()
+	} else if tx := apm.TransactionFromContext(ctx); tx != nil {
+		traceContext = tx.TraceContext()
+	}
+	if traceContext.Trace.Validate() == nil {
+		metadata := make(map[string]string, len(m.Metadata)+2)
+		for k, v := range m.Metadata {
+			metadata[k] = v
+		}
+		metadata[TraceparentMetadataKey] = apmhttp.FormatTraceparentHeader(traceContext)
+		metadata[EnqueueTimeMetadataKey] = time.Now().UTC().Format(time.RFC3339Nano)
+		m = &pubsub.Message{
+			Body:       m.Body,
+			Metadata:   metadata,
+			BeforeSend: m.BeforeSend,
+		}
+	}
+	return t.Topic.Send(ctx, m)
+}
+
+// Subscription wraps a *pubsub.Subscription, reporting Receive
+// operations as spans.
+type Subscription struct {
+	*pubsub.Subscription
+	provider provider
+}
+
+// OpenSubscription opens the subscription identified by urlstr using
+// pubsub.OpenSubscription, and wraps it with WrapSubscription. The URL
+// scheme is used to identify the provider.
+func OpenSubscription(ctx context.Context, urlstr string) (*Subscription, error) {
+	p, err := parsePubSubProviderURL(urlstr)
+	if err != nil {
+		return nil, err
+	}
+	s, err := pubsub.OpenSubscription(ctx, urlstr)
+	if err != nil {
+		return nil, err
+	}
+	return &Subscription{Subscription: s, provider: p}, nil
+}
+
+// WrapSubscription wraps s such that Receive operations are reported
+// as spans. The scheme is the Go CDK URL scheme identifying the driver
+// backing s (e.g. "awssqs", or "kafka"), and name is the name of the
+// subscription.
+func WrapSubscription(s *pubsub.Subscription, scheme, name string) *Subscription {
+	return &Subscription{Subscription: s, provider: newPubSubProvider(scheme, name)}
+}
+
+// Receive receives a message from the subscription, reporting a span
+// if ctx contains a transaction.
+//
+// To trace the processing of received messages as transactions that
+// continue the sender's trace, use MessageTraceContext.
+func (s *Subscription) Receive(ctx context.Context) (*pubsub.Message, error) {
+	span, ctx := startMessagingSpan(ctx, s.provider, "RECEIVE", "receive")
+	defer span.End()
+	return s.Subscription.Receive(ctx)
+}
+
+// startMessagingSpan starts a span for a pubsub operation, named
+// "<subtype> <OPERATION> <name>" as for other Go CDK portable types, e.g.
+// "sqs SEND my-queue", with the driver's destination.
+func startMessagingSpan(ctx context.Context, p provider, operation, action string) (*apm.Span, context.Context) {
+	name := p.subtype + " " + operation
+	if p.name != "" {
+		name += " " + p.name
+	}
+	return startSpan(ctx, name, p.spanType("messaging", action), p)
+}
+
+// MessageTraceContext returns the trace context recorded in m's
+// metadata by Topic.Send, and reports whether it was found and valid.
+//
+// The trace context may be used to start a transaction for processing
+// the message, as a continuation of the sender's trace:
+//
+//	traceContext, _ := apmgocloud.MessageTraceContext(m)
+//	enqueueTime, _ := apmgocloud.MessageEnqueueTime(m)
+//	tx := tracer.StartTransactionOptions("process", "messaging", apm.TransactionOptions{
+//		TraceContext: traceContext,
+//		EnqueueTime:  enqueueTime,
+//	})
+func MessageTraceContext(m *pubsub.Message) (apm.TraceContext, bool) {
+	value, ok := m.Metadata[TraceparentMetadataKey]
+	if !ok {
+		return apm.TraceContext{}, false
+	}
+	traceContext, err := apmhttp.ParseTraceparentHeader(value)
+	if err != nil {
+		return apm.TraceContext{}, false
+	}
+	return traceContext, true
+}
+
+// MessageEnqueueTime returns the send time recorded in m's metadata by
+// Topic.Send, and reports whether it was found and valid. The time may
+// be used as apm.TransactionOptions.EnqueueTime; see MessageTraceContext.
+func MessageEnqueueTime(m *pubsub.Message) (time.Time, bool) {
+	value, ok := m.Metadata[EnqueueTimeMetadataKey]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
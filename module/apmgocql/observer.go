@@ -68,7 +68,8 @@ func (o *Observer) ObserveBatch(ctx context.Context, batch gocql.ObservedBatch)
 
 	for _, statement := range batch.Statements {
 		span, _ := apm.StartSpanOptions(ctx, querySignature(statement), "db.cassandra.query", apm.SpanOptions{
-			Start: batch.Start,
+			Start:    batch.Start,
+			ExitSpan: true,
 		})
 		span.Duration = batchSpan.Duration
 		span.Context.SetDatabase(apm.DatabaseSpanContext{
@@ -88,7 +89,8 @@ func (o *Observer) ObserveBatch(ctx context.Context, batch gocql.ObservedBatch)
 // ObserveQuery observes query results, and creates spans for them.
 func (o *Observer) ObserveQuery(ctx context.Context, query gocql.ObservedQuery) {
 	span, _ := apm.StartSpanOptions(ctx, querySignature(query.Statement), "db.cassandra.query", apm.SpanOptions{
-		Start: query.Start,
+		Start:    query.Start,
+		ExitSpan: true,
 	})
 	span.Duration = query.End.Sub(query.Start)
 	span.Context.SetDatabase(apm.DatabaseSpanContext{
@@ -26,6 +26,16 @@ import (
 	"go.elastic.co/apm"
 )
 
+// CacheMetrics records the cache hit and miss counts of GET, HGET, MGET,
+// and HMGET commands executed by instrumented clients. A command with a
+// nil result is counted as a miss. The redis server address is recorded
+// as the destination for clients created with redis.NewClient.
+//
+// To report the counts as metrics, register CacheMetrics with a tracer:
+//
+//	apm.DefaultTracer.RegisterMetricsGatherer(apmgoredis.CacheMetrics)
+var CacheMetrics = &apm.CacheMetrics{}
+
 // Client is the interface returned by Wrap.
 //
 // Client implements redis.UniversalClient
@@ -51,29 +61,102 @@ type Client interface {
 // Wrap wraps client such that executed commands are reported as spans to Elastic APM,
 // using the client's associated context.
 // A context-specific client may be obtained by using Client.WithContext.
-func Wrap(client redis.UniversalClient) Client {
+//
+// If client already implements Client, it is returned as is, and opts
+// are ignored.
+func Wrap(client redis.UniversalClient, opts ...ClientOption) Client {
+	o := newClientOptions(opts)
 	switch client.(type) {
 	case *redis.Client:
-		return contextClient{Client: client.(*redis.Client)}
+		return contextClient{Client: client.(*redis.Client), opts: o}
 	case *redis.ClusterClient:
-		return contextClusterClient{ClusterClient: client.(*redis.ClusterClient)}
+		return contextClusterClient{ClusterClient: client.(*redis.ClusterClient), opts: o}
 	case *redis.Ring:
-		return contextRingClient{Ring: client.(*redis.Ring)}
+		return contextRingClient{Ring: client.(*redis.Ring), opts: o}
 	}
 
 	return client.(Client)
 
 }
 
+// Instrument installs instrumentation on client in place, using its
+// WrapProcess and WrapProcessPipeline methods, such that executed
+// commands are reported as spans to Elastic APM.
+//
+// Unlike Wrap, Instrument does not hide the concrete type of client,
+// so a *redis.Client, *redis.ClusterClient or *redis.Ring retains its
+// full method set, and may be passed to code that type-asserts it.
+//
+// Spans are reported using the client's associated context, so to
+// report commands as spans the client should be bound to a context
+// containing a transaction or span before calling Instrument:
+//
+//	client := client.WithContext(ctx)
+//	apmgoredis.Instrument(client)
+//
+// Instrument should be called at most once for a given client.
+// Clients derived from an instrumented client with WithContext
+// inherit its instrumentation, including its context.
+func Instrument(client redis.UniversalClient, opts ...ClientOption) {
+	o := newClientOptions(opts)
+	ctx := context.Background()
+	if client, ok := client.(interface {
+		Context() context.Context
+	}); ok {
+		ctx = client.Context()
+	}
+	_, cluster := client.(*redis.ClusterClient)
+	addr := clientAddr(client)
+	client.WrapProcess(process(ctx, addr, cluster, o))
+	client.WrapProcessPipeline(processPipeline(ctx, addr, cluster, o))
+}
+
+// ClientOption sets options for instrumenting clients with Wrap or Instrument.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	suppressDuplicateSpans bool
+}
+
+func newClientOptions(opts []ClientOption) clientOptions {
+	var o clientOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithSuppressDuplicateSpans returns a ClientOption which suppresses the
+// creation of spans for commands, including those executed in pipelines,
+// which are already being reported by an instrumented *redis.ClusterClient
+// in the same trace.
+//
+// A *redis.ClusterClient executes commands using a client per cluster node,
+// and executes redirected commands in pipelines on those node clients. If
+// the node clients are themselves instrumented with a context containing
+// the same transaction, each command would otherwise be reported twice.
+// WithSuppressDuplicateSpans should be passed when instrumenting the node
+// clients; commands executed directly on them are still reported.
+//
+// Cache metrics are not recorded for suppressed commands, as they
+// are recorded by the cluster client.
+func WithSuppressDuplicateSpans() ClientOption {
+	return func(o *clientOptions) {
+		o.suppressDuplicateSpans = true
+	}
+}
+
 type contextClient struct {
 	*redis.Client
+	opts clientOptions
 }
 
 func (c contextClient) WithContext(ctx context.Context) Client {
 	c.Client = c.Client.WithContext(ctx)
 
-	c.WrapProcess(process(ctx))
-	c.WrapProcessPipeline(processPipeline(ctx))
+	addr := clientAddr(c.Client)
+	c.WrapProcess(process(ctx, addr, false, c.opts))
+	c.WrapProcessPipeline(processPipeline(ctx, addr, false, c.opts))
 
 	return c
 }
@@ -88,6 +171,7 @@ func (c contextClient) RingClient() *redis.Ring {
 
 type contextClusterClient struct {
 	*redis.ClusterClient
+	opts clientOptions
 }
 
 func (c contextClusterClient) Cluster() *redis.ClusterClient {
@@ -101,14 +185,15 @@ func (c contextClusterClient) RingClient() *redis.Ring {
 func (c contextClusterClient) WithContext(ctx context.Context) Client {
 	c.ClusterClient = c.ClusterClient.WithContext(ctx)
 
-	c.WrapProcess(process(ctx))
-	c.WrapProcessPipeline(processPipeline(ctx))
+	c.WrapProcess(process(ctx, "", true, c.opts))
+	c.WrapProcessPipeline(processPipeline(ctx, "", true, c.opts))
 
 	return c
 }
 
 type contextRingClient struct {
 	*redis.Ring
+	opts clientOptions
 }
 
 func (c contextRingClient) Cluster() *redis.ClusterClient {
@@ -122,27 +207,48 @@ func (c contextRingClient) RingClient() *redis.Ring {
 func (c contextRingClient) WithContext(ctx context.Context) Client {
 	c.Ring = c.Ring.WithContext(ctx)
 
-	c.WrapProcess(process(ctx))
-	c.WrapProcessPipeline(processPipeline(ctx))
+	c.WrapProcess(process(ctx, "", false, c.opts))
+	c.WrapProcessPipeline(processPipeline(ctx, "", false, c.opts))
 
 	return c
 }
 
-func process(ctx context.Context) func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+// clientAddr returns the server address of client, if it is a
+// *redis.Client. Cluster and ring clients have multiple addresses,
+// so clientAddr returns an empty string for them.
+func clientAddr(client redis.UniversalClient) string {
+	if client, ok := client.(*redis.Client); ok {
+		return client.Options().Addr
+	}
+	return ""
+}
+
+func process(ctx context.Context, addr string, cluster bool, opts clientOptions) func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
 	return func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
 		return func(cmd redis.Cmder) error {
+			if opts.suppressDuplicateSpans && clusterCommandInFlight(ctx, cmd) {
+				return oldProcess(cmd)
+			}
 			spanName := strings.ToUpper(cmd.Name())
-			span, _ := apm.StartSpan(ctx, spanName, "db.redis")
+			span, _ := apm.StartSpanOptions(ctx, spanName, "db.redis", apm.SpanOptions{ExitSpan: true})
 			defer span.End()
+			if cluster {
+				defer startClusterCommand(ctx, span, cmd)()
+			}
 
-			return oldProcess(cmd)
+			err := oldProcess(cmd)
+			recordCacheMetrics(cmd, addr)
+			return err
 		}
 	}
 }
 
-func processPipeline(ctx context.Context) func(oldProcess func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
+func processPipeline(ctx context.Context, addr string, cluster bool, opts clientOptions) func(oldProcess func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
 	return func(oldProcess func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
 		return func(cmds []redis.Cmder) error {
+			if opts.suppressDuplicateSpans && clusterPipelineInFlight(ctx, cmds) {
+				return oldProcess(cmds)
+			}
 			pipelineSpan, ctx := apm.StartSpan(ctx, "(pipeline)", "db.redis")
 
 			for i := len(cmds); i > 0; i-- {
@@ -153,11 +259,47 @@ func processPipeline(ctx context.Context) func(oldProcess func(cmds []redis.Cmde
 
 				span, _ := apm.StartSpan(ctx, cmdName, "db.redis")
 				defer span.End()
+				if cluster {
+					defer startClusterCommand(ctx, span, cmds[i-1])()
+				}
 			}
 
 			defer pipelineSpan.End()
 
-			return oldProcess(cmds)
+			err := oldProcess(cmds)
+			for _, cmd := range cmds {
+				recordCacheMetrics(cmd, addr)
+			}
+			return err
+		}
+	}
+}
+
+// recordCacheMetrics records cache hits and misses in CacheMetrics
+// for executed GET, HGET, MGET, and HMGET commands. Commands which
+// failed with an error other than redis.Nil are not recorded.
+func recordCacheMetrics(cmd redis.Cmder, addr string) {
+	switch strings.ToLower(cmd.Name()) {
+	case "get", "hget":
+		switch cmd.Err() {
+		case nil:
+			CacheMetrics.Hit("redis", addr)
+		case redis.Nil:
+			CacheMetrics.Miss("redis", addr)
+		}
+	case "mget", "hmget":
+		cmd, ok := cmd.(*redis.SliceCmd)
+		if !ok || cmd.Err() != nil {
+			return
+		}
+		var hits, misses uint64
+		for _, v := range cmd.Val() {
+			if v == nil {
+				misses++
+			} else {
+				hits++
+			}
 		}
+		CacheMetrics.Add("redis", addr, hits, misses)
 	}
 }
@@ -29,6 +29,7 @@ import (
 
 	"go.elastic.co/apm"
 	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/module/apmgoredis"
 )
 
@@ -126,6 +127,58 @@ func TestWithContext(t *testing.T) {
 	}
 }
 
+func TestInstrument(t *testing.T) {
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		client := redisEmptyClient().WithContext(ctx)
+		apmgoredis.Instrument(client)
+		client.Ping()
+
+		pipe := client.Pipeline()
+		pipe.Do("")
+		pipe.Exec()
+	})
+	require.Len(t, spans, 3)
+	assert.Equal(t, "PING", spans[0].Name)
+	assert.Equal(t, "(pipeline)", spans[1].Name)
+	assert.Equal(t, "(empty command)", spans[2].Name)
+	for _, span := range spans {
+		assert.Equal(t, "db", span.Type)
+		assert.Equal(t, "redis", span.Subtype)
+	}
+}
+
+func TestInstrumentClusterNode(t *testing.T) {
+	const addr = "127.0.0.1:1"
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func() ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{{
+				Start: 0,
+				End:   16383,
+				Nodes: []redis.ClusterNode{{Addr: addr}},
+			}}, nil
+		},
+		MaxRedirects: -1,
+		OnNewNode:    apmgoredis.InstrumentClusterNode,
+	})
+	defer client.Close()
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		client := apmgoredis.Wrap(client).WithContext(ctx)
+		client.Get("foo")
+		client.Get("{foo}.bar")
+		client.Ping()
+	})
+	require.Len(t, spans, 3)
+	assert.Equal(t, model.StringMap{
+		{Key: "redis_node", Value: addr},
+		{Key: "redis_slot", Value: "12182"},
+	}, spans[0].Context.Tags)
+	assert.Equal(t, spans[0].Context.Tags, spans[1].Context.Tags)
+	assert.Equal(t, model.StringMap{
+		{Key: "redis_node", Value: addr},
+	}, spans[2].Context.Tags)
+}
+
 func TestWrapPipeline(t *testing.T) {
 	for i, testCase := range unitTestCases {
 		t.Run(fmt.Sprintf("test %d", i), func(t *testing.T) {
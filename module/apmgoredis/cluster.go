@@ -0,0 +1,189 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmgoredis
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis"
+
+	"go.elastic.co/apm"
+)
+
+const clusterSlots = 16384
+
+// clusterSpans holds the in-flight commands executed by instrumented
+// cluster clients, as *clusterCommand values keyed by command. Cluster
+// node clients instrumented with InstrumentClusterNode use it to find
+// the span for the command they are processing, and node clients
+// instrumented with WithSuppressDuplicateSpans use it to avoid
+// reporting the command again.
+var clusterSpans sync.Map
+
+// clusterCommand holds the span of an in-flight cluster command,
+// and the ID of the trace to which the span belongs.
+type clusterCommand struct {
+	span  *apm.Span
+	trace apm.TraceID
+}
+
+// keylessCommands holds the names of commands which do not operate on
+// a key, and hence are not assigned a cluster slot.
+var keylessCommands = map[string]bool{
+	"auth":      true,
+	"bgsave":    true,
+	"client":    true,
+	"cluster":   true,
+	"command":   true,
+	"config":    true,
+	"dbsize":    true,
+	"echo":      true,
+	"flushall":  true,
+	"flushdb":   true,
+	"info":      true,
+	"lastsave":  true,
+	"ping":      true,
+	"quit":      true,
+	"readonly":  true,
+	"readwrite": true,
+	"save":      true,
+	"script":    true,
+	"select":    true,
+	"slowlog":   true,
+	"time":      true,
+}
+
+// InstrumentClusterNode instruments the client for a redis cluster node,
+// such that commands executed by an instrumented *redis.ClusterClient
+// record the address of the node which served them in the "redis_node"
+// span tag. InstrumentClusterNode is intended to be used as, or called
+// from, redis.ClusterOptions.OnNewNode:
+//
+//	client := redis.NewClusterClient(&redis.ClusterOptions{
+//		Addrs:     addrs,
+//		OnNewNode: apmgoredis.InstrumentClusterNode,
+//	})
+//
+// If a command is redirected to another node, the node which served
+// the command last is recorded. Commands executed in pipelines are not
+// attributed to nodes.
+func InstrumentClusterNode(node *redis.Client) {
+	addr := node.Options().Addr
+	node.WrapProcess(func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			err := oldProcess(cmd)
+			if c, ok := clusterSpans.Load(cmd); ok {
+				c.(*clusterCommand).span.Context.SetTag("redis_node", addr)
+			}
+			return err
+		}
+	})
+}
+
+// startClusterCommand records the cluster slot of cmd in span, and
+// registers span for attribution by cluster node clients. The returned
+// function must be called once the command has been processed.
+func startClusterCommand(ctx context.Context, span *apm.Span, cmd redis.Cmder) func() {
+	if span.Dropped() {
+		return func() {}
+	}
+	if slot, ok := commandSlot(cmd); ok {
+		span.Context.SetTag("redis_slot", slot)
+	}
+	clusterSpans.Store(cmd, &clusterCommand{
+		span:  span,
+		trace: apm.TransactionFromContext(ctx).TraceContext().Trace,
+	})
+	return func() { clusterSpans.Delete(cmd) }
+}
+
+// clusterCommandInFlight reports whether cmd is being reported by an
+// instrumented cluster client, in the same trace as the transaction
+// in ctx.
+func clusterCommandInFlight(ctx context.Context, cmd redis.Cmder) bool {
+	c, ok := clusterSpans.Load(cmd)
+	if !ok {
+		return false
+	}
+	tx := apm.TransactionFromContext(ctx)
+	return tx != nil && tx.TraceContext().Trace == c.(*clusterCommand).trace
+}
+
+// clusterPipelineInFlight reports whether any of cmds is being reported
+// by an instrumented cluster client, in the same trace as the transaction
+// in ctx. Cluster clients process redirected commands using a pipeline
+// on the node client, prefixed with an ASKING command.
+func clusterPipelineInFlight(ctx context.Context, cmds []redis.Cmder) bool {
+	for _, cmd := range cmds {
+		if clusterCommandInFlight(ctx, cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandSlot returns the cluster slot for cmd, derived from its first
+// key, and a boolean indicating whether cmd has a key.
+func commandSlot(cmd redis.Cmder) (string, bool) {
+	args := cmd.Args()
+	pos := 1
+	switch name := strings.ToLower(cmd.Name()); name {
+	case "eval", "evalsha":
+		if len(args) < 3 || args[2] == "0" {
+			return "", false
+		}
+		pos = 3
+	default:
+		if keylessCommands[name] {
+			return "", false
+		}
+	}
+	if len(args) <= pos {
+		return "", false
+	}
+	key, ok := args[pos].(string)
+	if !ok || key == "" {
+		return "", false
+	}
+	return strconv.Itoa(keySlot(key)), true
+}
+
+// keySlot returns the cluster slot for key, as defined by
+// the redis cluster specification.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % clusterSlots
+}
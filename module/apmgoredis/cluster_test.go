@@ -0,0 +1,132 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmgoredis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+)
+
+func TestWithSuppressDuplicateSpans(t *testing.T) {
+	// Cluster node clients are instrumented with the transaction's
+	// context, as if they were bound to it. If asking is true, GET
+	// commands are executed in a pipeline prefixed with ASKING, as
+	// the cluster client does for redirected commands.
+	test := func(asking bool, opts ...ClientOption) []model.Span {
+		_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+			o := newClientOptions(opts)
+			client := redis.NewClusterClient(&redis.ClusterOptions{
+				ClusterSlots: func() ([]redis.ClusterSlot, error) {
+					return []redis.ClusterSlot{{
+						Start: 0,
+						End:   16383,
+						Nodes: []redis.ClusterNode{{Addr: "127.0.0.1:1"}},
+					}}, nil
+				},
+				MaxRedirects: -1,
+				OnNewNode: func(node *redis.Client) {
+					addr := node.Options().Addr
+					node.WrapProcess(process(ctx, addr, false, o))
+					node.WrapProcessPipeline(processPipeline(ctx, addr, false, o))
+					if asking {
+						node.WrapProcess(func(oldProcess func(redis.Cmder) error) func(redis.Cmder) error {
+							return func(cmd redis.Cmder) error {
+								if cmd.Name() != "get" {
+									return oldProcess(cmd)
+								}
+								pipe := node.Pipeline()
+								pipe.Process(redis.NewCmd("ASKING"))
+								pipe.Process(cmd)
+								_, err := pipe.Exec()
+								return err
+							}
+						})
+					}
+				},
+			})
+			defer client.Close()
+			Wrap(client).WithContext(ctx).Get("foo")
+		})
+		// The cluster client executes COMMAND on the node
+		// client itself to discover command key positions.
+		commandSpans := spans[:0]
+		for _, span := range spans {
+			if span.Name != "COMMAND" {
+				commandSpans = append(commandSpans, span)
+			}
+		}
+		return commandSpans
+	}
+
+	spanNames := func(spans []model.Span) []string {
+		names := make([]string, len(spans))
+		for i, span := range spans {
+			names[i] = span.Name
+		}
+		return names
+	}
+	assert.ElementsMatch(t, []string{"GET", "GET"}, spanNames(test(false)))
+	assert.ElementsMatch(t, []string{"GET", "(pipeline)", "ASKING", "GET"}, spanNames(test(true)))
+
+	for _, asking := range []bool{false, true} {
+		spans := test(asking, WithSuppressDuplicateSpans())
+		require.Len(t, spans, 1)
+		assert.Equal(t, "GET", spans[0].Name)
+		assert.Equal(t, model.StringMap{{Key: "redis_slot", Value: "12182"}}, spans[0].Context.Tags)
+	}
+}
+
+func TestWithSuppressDuplicateSpansOtherTrace(t *testing.T) {
+	// Commands which are not being reported by a cluster
+	// client in the same trace are not suppressed.
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	defer client.Close()
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		Wrap(client, WithSuppressDuplicateSpans()).WithContext(ctx).Get("foo")
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GET", spans[0].Name)
+}
+
+func TestWithSuppressDuplicateSpansClusterPipeline(t *testing.T) {
+	// Commands executed in a cluster client pipeline are not
+	// reported again by node client pipelines executing them.
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		nodeOpts := newClientOptions([]ClientOption{WithSuppressDuplicateSpans()})
+		nodePipeline := processPipeline(ctx, "127.0.0.1:1", false, nodeOpts)(func([]redis.Cmder) error {
+			return nil
+		})
+		clusterPipeline := processPipeline(ctx, "", true, clientOptions{})(nodePipeline)
+		clusterPipeline([]redis.Cmder{
+			redis.NewStringCmd("get", "foo"),
+			redis.NewStringCmd("get", "bar"),
+		})
+	})
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+	}
+	assert.ElementsMatch(t, []string{"(pipeline)", "GET", "GET"}, names)
+}
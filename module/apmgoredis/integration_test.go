@@ -29,7 +29,9 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/module/apmgoredis"
+	"go.elastic.co/apm/transport/transporttest"
 )
 
 func TestMain(m *testing.M) {
@@ -259,6 +261,44 @@ func TestPipelineTransaction(t *testing.T) {
 	}
 }
 
+func TestCacheMetrics(t *testing.T) {
+	client := redisClient(t)
+	defer client.Close()
+	cleanRedis(t, client, clientTypeBase)
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.RegisterMetricsGatherer(apmgoredis.CacheMetrics)
+
+	// Discard counts recorded by other tests.
+	tracer.SendMetrics(nil)
+	transport.ResetPayloads()
+
+	apmgoredis.Instrument(client)
+	require.NoError(t, client.Set("foo", "bar", 0).Err())
+	assert.NoError(t, client.Get("foo").Err())
+	assert.Equal(t, redis.Nil, client.Get("baz").Err())
+	assert.NoError(t, client.MGet("foo", "bar", "baz").Err())
+	tracer.SendMetrics(nil)
+
+	var metrics []model.Metrics
+	for _, m := range transport.Payloads().Metrics {
+		if _, ok := m.Samples["cache.hits"]; ok {
+			metrics = append(metrics, m)
+		}
+	}
+	require.Len(t, metrics, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "cache_type", Value: "redis"},
+		{Key: "destination", Value: client.Options().Addr},
+	}, metrics[0].Labels)
+	assert.Equal(t, map[string]model.Metric{
+		"cache.hits":      {Value: 2},
+		"cache.misses":    {Value: 3},
+		"cache.hit_ratio": {Value: 0.4},
+	}, metrics[0].Samples)
+}
+
 func redisClient(t *testing.T) *redis.Client {
 	redisURL := os.Getenv("REDIS_URL")
 	if redisURL == "" {
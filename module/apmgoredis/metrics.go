@@ -0,0 +1,185 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmgoredis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"go.elastic.co/apm"
+)
+
+// commandDurationBoundaries holds the bucket boundaries, in seconds,
+// of the command latency histogram: 100µs up to ~3.3s.
+var commandDurationBoundaries = apm.ExponentialBoundaries(0.0001, 2, 16)
+
+// Gatherer is an apm.MetricsGatherer which reports connection pool
+// statistics and command latency histograms for redis clients
+// instrumented with Gatherer.Instrument, and the decisions of limiters
+// wrapped with Gatherer.WrapLimiter.
+//
+// To report the metrics, register the Gatherer with a tracer:
+//
+//	apm.DefaultTracer.RegisterMetricsGatherer(gatherer)
+type Gatherer struct {
+	meter    apm.Meter
+	duration *apm.Histogram
+	allowed  *apm.Counter
+	rejected *apm.Counter
+	failures *apm.Counter
+
+	mu      sync.RWMutex
+	clients []gathererClient
+}
+
+type gathererClient struct {
+	name   string
+	addr   string
+	client poolStatsClient
+}
+
+type poolStatsClient interface {
+	PoolStats() *redis.PoolStats
+}
+
+// NewGatherer returns a new Gatherer.
+func NewGatherer() *Gatherer {
+	g := &Gatherer{}
+	g.duration = g.meter.Histogram("redis.command.duration", commandDurationBoundaries)
+	g.allowed = g.meter.Counter("redis.limiter.allowed")
+	g.rejected = g.meter.Counter("redis.limiter.rejected")
+	g.failures = g.meter.Counter("redis.limiter.failures")
+	return g
+}
+
+// Instrument installs instrumentation on client, using its WrapProcess
+// and WrapProcessPipeline methods, such that the latency of executed
+// commands is recorded in the "redis.command.duration" histogram, and
+// the client's connection pool statistics are reported. The metrics are
+// labeled with "client", set to name, and the command name or
+// "(pipeline)". For a *redis.Client, they are also labeled with
+// "destination", the server address.
+//
+// Instrument may be combined with the package-level Instrument or Wrap
+// for reporting commands as spans. Instrument should be called at most
+// once for a given client.
+func (g *Gatherer) Instrument(name string, client redis.UniversalClient) {
+	addr := clientAddr(client)
+	labels := []apm.MetricLabel{{Name: "client", Value: name}}
+	if addr != "" {
+		labels = append(labels, apm.MetricLabel{Name: "destination", Value: addr})
+	}
+	client.WrapProcess(func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			start := time.Now()
+			err := oldProcess(cmd)
+			g.recordDuration(strings.ToUpper(cmd.Name()), time.Since(start), labels)
+			return err
+		}
+	})
+	client.WrapProcessPipeline(func(oldProcess func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			start := time.Now()
+			err := oldProcess(cmds)
+			g.recordDuration("(pipeline)", time.Since(start), labels)
+			return err
+		}
+	})
+	if client, ok := client.(poolStatsClient); ok {
+		g.mu.Lock()
+		g.clients = append(g.clients, gathererClient{name: name, addr: addr, client: client})
+		g.mu.Unlock()
+	}
+}
+
+func (g *Gatherer) recordDuration(command string, d time.Duration, labels []apm.MetricLabel) {
+	commandLabels := make([]apm.MetricLabel, len(labels), len(labels)+1)
+	copy(commandLabels, labels)
+	commandLabels = append(commandLabels, apm.MetricLabel{Name: "command", Value: command})
+	g.duration.Record(d.Seconds(), commandLabels...)
+}
+
+// WrapLimiter returns a redis.Limiter which wraps l, counting the
+// operations it allows ("redis.limiter.allowed") and rejects
+// ("redis.limiter.rejected"), and the allowed operations which failed
+// ("redis.limiter.failures"), excluding redis.Nil results. The counts
+// are labeled with "client", set to name. If l is nil, all operations
+// are allowed.
+//
+// Install the returned limiter with redis.Client.SetLimiter:
+//
+//	client.SetLimiter(gatherer.WrapLimiter("cache", limiter))
+func (g *Gatherer) WrapLimiter(name string, l redis.Limiter) redis.Limiter {
+	return &limiter{
+		Limiter: l,
+		g:       g,
+		labels:  []apm.MetricLabel{{Name: "client", Value: name}},
+	}
+}
+
+// GatherMetrics gathers the connection pool statistics of the
+// instrumented clients, and the recorded command latencies and limiter
+// decisions, into m.
+func (g *Gatherer) GatherMetrics(ctx context.Context, m *apm.Metrics) error {
+	g.mu.RLock()
+	for _, c := range g.clients {
+		labels := []apm.MetricLabel{{Name: "client", Value: c.name}}
+		if c.addr != "" {
+			labels = append(labels, apm.MetricLabel{Name: "destination", Value: c.addr})
+		}
+		stats := c.client.PoolStats()
+		m.Add("redis.pool.hits", labels, float64(stats.Hits))
+		m.Add("redis.pool.misses", labels, float64(stats.Misses))
+		m.Add("redis.pool.timeouts", labels, float64(stats.Timeouts))
+		m.Add("redis.pool.total_conns", labels, float64(stats.TotalConns))
+		m.Add("redis.pool.idle_conns", labels, float64(stats.IdleConns))
+		m.Add("redis.pool.stale_conns", labels, float64(stats.StaleConns))
+	}
+	g.mu.RUnlock()
+	return g.meter.GatherMetrics(ctx, m)
+}
+
+type limiter struct {
+	redis.Limiter
+	g      *Gatherer
+	labels []apm.MetricLabel
+}
+
+func (l *limiter) Allow() error {
+	if l.Limiter != nil {
+		if err := l.Limiter.Allow(); err != nil {
+			l.g.rejected.Inc(l.labels...)
+			return err
+		}
+	}
+	l.g.allowed.Inc(l.labels...)
+	return nil
+}
+
+func (l *limiter) ReportResult(result error) {
+	if result != nil && result != redis.Nil {
+		l.g.failures.Inc(l.labels...)
+	}
+	if l.Limiter != nil {
+		l.Limiter.ReportResult(result)
+	}
+}
@@ -0,0 +1,215 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmgoredis_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmgoredis"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestGatherer(t *testing.T) {
+	addr := pongServer(t)
+	g := apmgoredis.NewGatherer()
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	g.Instrument("cache", client)
+
+	require.NoError(t, client.Ping().Err())
+	require.NoError(t, client.Ping().Err())
+	pipe := client.Pipeline()
+	pipe.Ping()
+	_, err := pipe.Exec()
+	require.NoError(t, err)
+
+	metrics := gatherMetrics(g)
+	require.Len(t, metrics, 3)
+
+	poolLabels := model.StringMap{
+		{Key: "client", Value: "cache"},
+		{Key: "destination", Value: addr},
+	}
+	commandCounts := make(map[string]uint64)
+	for _, m := range metrics {
+		if len(m.Labels) == 2 {
+			assert.Equal(t, poolLabels, m.Labels)
+			assert.Equal(t, map[string]model.Metric{
+				"redis.pool.hits":        {Value: 2},
+				"redis.pool.misses":      {Value: 1},
+				"redis.pool.timeouts":    {Value: 0},
+				"redis.pool.total_conns": {Value: 1},
+				"redis.pool.idle_conns":  {Value: 1},
+				"redis.pool.stale_conns": {Value: 0},
+			}, m.Samples)
+			continue
+		}
+		require.Len(t, m.Labels, 3)
+		assert.Equal(t, model.StringMapItem{Key: "client", Value: "cache"}, m.Labels[0])
+		assert.Equal(t, "command", m.Labels[1].Key)
+		assert.Equal(t, model.StringMapItem{Key: "destination", Value: addr}, m.Labels[2])
+
+		sample, ok := m.Samples["redis.command.duration"]
+		require.True(t, ok)
+		assert.Equal(t, "histogram", sample.Type)
+		for _, count := range sample.Counts {
+			commandCounts[m.Labels[1].Value] += count
+		}
+	}
+	assert.Equal(t, map[string]uint64{"PING": 2, "(pipeline)": 1}, commandCounts)
+
+	// Latency histograms are reset after gathering,
+	// while pool statistics continue to be reported.
+	metrics = gatherMetrics(g)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, poolLabels, metrics[0].Labels)
+}
+
+func TestGathererWrapLimiter(t *testing.T) {
+	addr := pongServer(t)
+	errRejected := errors.New("rejected")
+	rejecting := &testLimiter{}
+
+	g := apmgoredis.NewGatherer()
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	client.SetLimiter(g.WrapLimiter("cache", rejecting))
+	defer client.Close()
+
+	require.NoError(t, client.Ping().Err())
+	rejecting.err = errRejected
+	assert.Equal(t, errRejected, client.Ping().Err())
+	assert.Equal(t, []error{nil}, rejecting.results)
+
+	metrics := gatherMetrics(g)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, model.StringMap{{Key: "client", Value: "cache"}}, metrics[0].Labels)
+	assert.Equal(t, map[string]model.Metric{
+		"redis.limiter.allowed":  {Type: "counter", Value: 1},
+		"redis.limiter.rejected": {Type: "counter", Value: 1},
+	}, metrics[0].Samples)
+}
+
+func TestGathererWrapLimiterNil(t *testing.T) {
+	g := apmgoredis.NewGatherer()
+	client := redis.NewClient(&redis.Options{Addr: closedAddr(t)})
+	client.SetLimiter(g.WrapLimiter("cache", nil))
+	defer client.Close()
+	assert.Error(t, client.Ping().Err())
+
+	metrics := gatherMetrics(g)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, map[string]model.Metric{
+		"redis.limiter.allowed":  {Type: "counter", Value: 1},
+		"redis.limiter.failures": {Type: "counter", Value: 1},
+	}, metrics[0].Samples)
+}
+
+type testLimiter struct {
+	err     error
+	results []error
+}
+
+func (l *testLimiter) Allow() error {
+	return l.err
+}
+
+func (l *testLimiter) ReportResult(result error) {
+	l.results = append(l.results, result)
+}
+
+// gatherMetrics returns the labeled metric sets gathered by g.
+func gatherMetrics(g *apmgoredis.Gatherer) []model.Metrics {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.RegisterMetricsGatherer(g)
+	tracer.SendMetrics(nil)
+
+	var metrics []model.Metrics
+	for _, m := range transport.Payloads().Metrics {
+		if len(m.Labels) != 0 {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// pongServer starts a TCP server which replies PONG to every command,
+// and returns its address.
+func pongServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		defer ln.Close()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					if err := skipCommand(r); err != nil {
+						return
+					}
+					if _, err := conn.Write([]byte("+PONG\r\n")); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// skipCommand reads a RESP array of bulk strings from r.
+func skipCommand(r *bufio.Reader) error {
+	var n int
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Sscanf(line, "*%d\r\n", &n); err != nil {
+		return err
+	}
+	// Each bulk string consists of a length line and a data line.
+	for i := 0; i < 2*n; i++ {
+		if _, err := r.ReadString('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closedAddr returns the address of a TCP listener which has been closed.
+func closedAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
@@ -0,0 +1,9 @@
+module go.elastic.co/apm/module/apmgoredisv8
+
+require (
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/stretchr/testify v1.2.2
+	go.elastic.co/apm v1.3.0
+)
+
+replace go.elastic.co/apm => ../..
@@ -0,0 +1,249 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.17
+
+package apmgoredisv8
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+
+	"go.elastic.co/apm"
+)
+
+// failoverAddr is the placeholder address go-redis assigns to the
+// options of clients created by redis.NewFailoverClient. The actual
+// server address is resolved through sentinels for each connection.
+const failoverAddr = "FailoverClient"
+
+// NewHook returns a redis.Hook which reports commands processed by
+// a client as spans within the transaction in the command's context.
+// Pipelines are reported as a "(pipeline)" span, with a child span
+// for each command. Commands sent by a *redis.PubSub, such as SUBSCRIBE,
+// bypass client hooks and are not reported; PUBLISH is reported as any
+// other command.
+//
+// The hook does not know the address of the server processing the
+// commands, so the spans do not record a destination. Use Instrument
+// to add the hook to a client whose server address is known.
+func NewHook() redis.Hook {
+	return &hook{}
+}
+
+// Instrument adds a hook to client which reports the client's commands
+// as spans within the transaction in each command's context. See NewHook
+// for details.
+//
+// If client is a *redis.Client, the spans record the client's server
+// address as their destination, and its database number as
+// the database instance. Clients created by redis.NewFailoverClient
+// discover their server address through sentinels, so only the
+// database number is recorded for them.
+//
+// Cluster and ring clients route commands to multiple servers. Use
+// InstrumentNode on their node clients to record the server which
+// processed each command.
+func Instrument(client redis.UniversalClient) {
+	h := &hook{}
+	if client, ok := client.(*redis.Client); ok {
+		h.server = newServer(client.Options())
+	}
+	client.AddHook(h)
+}
+
+// InstrumentNode adds a hook to node, a client for a single node of an
+// instrumented *redis.ClusterClient or *redis.Ring, such that spans for
+// commands processed by node record its address and database number.
+// The hook does not report spans itself. InstrumentNode is intended to
+// be called from redis.ClusterOptions.NewClient or
+// redis.RingOptions.NewClient:
+//
+//	client := redis.NewClusterClient(&redis.ClusterOptions{
+//		Addrs: addrs,
+//		NewClient: func(opt *redis.Options) *redis.Client {
+//			node := redis.NewClient(opt)
+//			apmgoredisv8.InstrumentNode(node)
+//			return node
+//		},
+//	})
+//	apmgoredisv8.Instrument(client)
+//
+// If a command is redirected to another node, the node which processed
+// the command last is recorded.
+func InstrumentNode(node *redis.Client) {
+	node.AddHook(&nodeHook{server: newServer(node.Options())})
+}
+
+type hook struct {
+	server *server
+}
+
+// BeforeProcess starts a span for cmd.
+func (h *hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	span, ctx := apm.StartSpanOptions(ctx, commandName(cmd), "db.redis", apm.SpanOptions{ExitSpan: true})
+	h.setSpanContext(span)
+	return context.WithValue(ctx, commandSpansKey{}, &commandSpans{
+		cmds:  []redis.Cmder{cmd},
+		spans: map[redis.Cmder]*apm.Span{cmd: span},
+	}), nil
+}
+
+// AfterProcess ends the span started for cmd by BeforeProcess.
+func (h *hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if spans, ok := ctx.Value(commandSpansKey{}).(*commandSpans); ok {
+		spans.end()
+	}
+	return nil
+}
+
+// BeforeProcessPipeline starts a span for the pipeline, and child
+// spans for each of the commands in cmds.
+func (h *hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	pipelineSpan, ctx := apm.StartSpan(ctx, "(pipeline)", "db.redis")
+	spans := &commandSpans{
+		pipeline: pipelineSpan,
+		cmds:     cmds,
+		spans:    make(map[redis.Cmder]*apm.Span, len(cmds)),
+	}
+	for _, cmd := range cmds {
+		span, _ := apm.StartSpanOptions(ctx, commandName(cmd), "db.redis", apm.SpanOptions{ExitSpan: true})
+		h.setSpanContext(span)
+		spans.spans[cmd] = span
+	}
+	if h.server != nil && !pipelineSpan.Dropped() {
+		h.server.setSpanContext(pipelineSpan)
+	}
+	return context.WithValue(ctx, commandSpansKey{}, spans), nil
+}
+
+// AfterProcessPipeline ends the spans started by BeforeProcessPipeline.
+func (h *hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if spans, ok := ctx.Value(commandSpansKey{}).(*commandSpans); ok {
+		spans.end()
+	}
+	return nil
+}
+
+func (h *hook) setSpanContext(span *apm.Span) {
+	if span.Dropped() {
+		return
+	}
+	if h.server != nil {
+		h.server.setSpanContext(span)
+	} else {
+		span.Context.SetDatabase(apm.DatabaseSpanContext{Type: "redis"})
+	}
+}
+
+// nodeHook is a redis.Hook which records its server in the spans
+// started by the hook of a cluster or ring client, for the commands
+// routed to its node.
+type nodeHook struct {
+	server *server
+}
+
+func (h *nodeHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	h.setSpanContext(ctx, cmd)
+	return ctx, nil
+}
+
+func (h *nodeHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *nodeHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		h.setSpanContext(ctx, cmd)
+	}
+	return ctx, nil
+}
+
+func (h *nodeHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func (h *nodeHook) setSpanContext(ctx context.Context, cmd redis.Cmder) {
+	spans, ok := ctx.Value(commandSpansKey{}).(*commandSpans)
+	if !ok {
+		return
+	}
+	if span, ok := spans.spans[cmd]; ok && !span.Dropped() {
+		h.server.setSpanContext(span)
+	}
+}
+
+type commandSpansKey struct{}
+
+// commandSpans holds the spans started for a command or pipeline,
+// keyed by command, and the commands in the order they were given.
+// Cluster and ring clients pass the context returned by hook to their
+// node clients, whose nodeHook uses commandSpans to find the spans for
+// the commands they process.
+type commandSpans struct {
+	pipeline *apm.Span
+	cmds     []redis.Cmder
+	spans    map[redis.Cmder]*apm.Span
+}
+
+func (s *commandSpans) end() {
+	for _, cmd := range s.cmds {
+		s.spans[cmd].End()
+	}
+	if s.pipeline != nil {
+		s.pipeline.End()
+	}
+}
+
+// server holds the address and database number of a redis server,
+// for recording in span context.
+type server struct {
+	host string
+	port int
+	db   string
+}
+
+func newServer(opt *redis.Options) *server {
+	s := &server{db: strconv.Itoa(opt.DB)}
+	if opt.Addr != failoverAddr {
+		host, portString, err := net.SplitHostPort(opt.Addr)
+		if err != nil {
+			host = opt.Addr
+		}
+		s.host = host
+		s.port, _ = strconv.Atoi(portString)
+	}
+	return s
+}
+
+func (s *server) setSpanContext(span *apm.Span) {
+	if s.host != "" {
+		span.Context.SetDestinationAddress(s.host, s.port)
+	}
+	span.Context.SetDatabase(apm.DatabaseSpanContext{Type: "redis", Instance: s.db})
+}
+
+func commandName(cmd redis.Cmder) string {
+	if name := strings.ToUpper(cmd.Name()); name != "" {
+		return name
+	}
+	return "(empty command)"
+}
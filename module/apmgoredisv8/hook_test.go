@@ -0,0 +1,175 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.17
+
+package apmgoredisv8_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmgoredisv8"
+)
+
+// unreachableAddr is the address of a server which immediately
+// refuses connections, so commands fail without delay.
+const unreachableAddr = "127.0.0.1:1"
+
+func TestInstrument(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: unreachableAddr, DB: 3, MaxRetries: -1})
+	defer client.Close()
+	apmgoredisv8.Instrument(client)
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		client.Ping(ctx)
+		client.Publish(ctx, "channel", "message")
+	})
+	require.Len(t, spans, 2)
+	assert.Equal(t, "PING", spans[0].Name)
+	assert.Equal(t, "PUBLISH", spans[1].Name)
+	for _, span := range spans {
+		assert.Equal(t, "db", span.Type)
+		assert.Equal(t, "redis", span.Subtype)
+		assert.Equal(t, &model.DestinationSpanContext{Address: "127.0.0.1", Port: 1}, span.Context.Destination)
+		assert.Equal(t, &model.DatabaseSpanContext{Type: "redis", Instance: "3"}, span.Context.Database)
+	}
+}
+
+func TestInstrumentPipeline(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: unreachableAddr, MaxRetries: -1})
+	defer client.Close()
+	apmgoredisv8.Instrument(client)
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, "foo", "bar", 0)
+			pipe.Get(ctx, "foo")
+			pipe.Do(ctx, "")
+			return nil
+		})
+		client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Incr(ctx, "foo")
+			return nil
+		})
+	})
+	require.Len(t, spans, 8)
+	assert.Equal(t, "SET", spans[0].Name)
+	assert.Equal(t, "GET", spans[1].Name)
+	assert.Equal(t, "(empty command)", spans[2].Name)
+	assert.Equal(t, "(pipeline)", spans[3].Name)
+	assert.Equal(t, "MULTI", spans[4].Name)
+	assert.Equal(t, "INCR", spans[5].Name)
+	assert.Equal(t, "EXEC", spans[6].Name)
+	assert.Equal(t, "(pipeline)", spans[7].Name)
+	for _, span := range spans[:3] {
+		assert.Equal(t, spans[3].ID, span.ParentID)
+	}
+	for _, span := range spans[4:7] {
+		assert.Equal(t, spans[7].ID, span.ParentID)
+	}
+	for _, span := range spans {
+		assert.Equal(t, "db", span.Type)
+		assert.Equal(t, "redis", span.Subtype)
+		assert.Equal(t, &model.DestinationSpanContext{Address: "127.0.0.1", Port: 1}, span.Context.Destination)
+	}
+}
+
+func TestInstrumentFailoverClient(t *testing.T) {
+	client := redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    "master",
+		SentinelAddrs: []string{unreachableAddr},
+		DB:            2,
+		MaxRetries:    -1,
+	})
+	defer client.Close()
+	apmgoredisv8.Instrument(client)
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		client.Ping(ctx)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "PING", spans[0].Name)
+	assert.Nil(t, spans[0].Context.Destination)
+	assert.Equal(t, &model.DatabaseSpanContext{Type: "redis", Instance: "2"}, spans[0].Context.Database)
+}
+
+func TestNewHook(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: unreachableAddr, MaxRetries: -1})
+	defer client.Close()
+	client.AddHook(apmgoredisv8.NewHook())
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		client.Get(ctx, "foo")
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GET", spans[0].Name)
+	assert.Nil(t, spans[0].Context.Destination)
+	assert.Equal(t, &model.DatabaseSpanContext{Type: "redis"}, spans[0].Context.Database)
+}
+
+func TestInstrumentNode(t *testing.T) {
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{{
+				Start: 0,
+				End:   16383,
+				Nodes: []redis.ClusterNode{{Addr: unreachableAddr}},
+			}}, nil
+		},
+		NewClient: func(opt *redis.Options) *redis.Client {
+			node := redis.NewClient(opt)
+			apmgoredisv8.InstrumentNode(node)
+			return node
+		},
+		MaxRedirects: -1,
+	})
+	defer client.Close()
+	apmgoredisv8.Instrument(client)
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		client.Get(ctx, "foo")
+		client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Get(ctx, "foo")
+			return nil
+		})
+	})
+	require.Len(t, spans, 3)
+	assert.Equal(t, "GET", spans[0].Name)
+	assert.Equal(t, "GET", spans[1].Name)
+	assert.Equal(t, "(pipeline)", spans[2].Name)
+	for _, span := range spans[:2] {
+		assert.Equal(t, &model.DestinationSpanContext{Address: "127.0.0.1", Port: 1}, span.Context.Destination)
+		assert.Equal(t, &model.DatabaseSpanContext{Type: "redis", Instance: "0"}, span.Context.Database)
+	}
+	assert.Nil(t, spans[2].Context)
+}
+
+func TestInstrumentNoTransaction(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: unreachableAddr, MaxRetries: -1})
+	defer client.Close()
+	apmgoredisv8.Instrument(client)
+
+	err := client.Ping(context.Background()).Err()
+	assert.Error(t, err)
+}
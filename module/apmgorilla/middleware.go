@@ -83,12 +83,16 @@ func Middleware(o ...Option) mux.MiddlewareFunc {
 		o(&opts)
 	}
 	return func(h http.Handler) http.Handler {
-		return apmhttp.Wrap(
-			h,
+		serverOpts := []apmhttp.ServerOption{
 			apmhttp.WithTracer(opts.tracer),
 			apmhttp.WithServerRequestName(routeRequestName),
 			apmhttp.WithServerRequestIgnorer(opts.requestIgnorer),
-		)
+		}
+		if opts.responseSizeTags {
+			serverOpts = append(serverOpts, apmhttp.WithResponseSizeTags())
+		}
+		serverOpts = append(serverOpts, opts.serverOpts...)
+		return apmhttp.Wrap(h, serverOpts...)
 	}
 }
 
@@ -103,8 +107,10 @@ func routeRequestName(req *http.Request) string {
 }
 
 type options struct {
-	tracer         *apm.Tracer
-	requestIgnorer apmhttp.RequestIgnorerFunc
+	tracer           *apm.Tracer
+	requestIgnorer   apmhttp.RequestIgnorerFunc
+	responseSizeTags bool
+	serverOpts       []apmhttp.ServerOption
 }
 
 // Option sets options for tracing.
@@ -132,3 +138,24 @@ func WithRequestIgnorer(r apmhttp.RequestIgnorerFunc) Option {
 		o.requestIgnorer = r
 	}
 }
+
+// WithResponseSizeTags returns an Option which enables recording
+// the response body size and content encoding as transaction tags.
+// See apmhttp.SetResponseSizeTags for details.
+func WithResponseSizeTags() Option {
+	return func(o *options) {
+		o.responseSizeTags = true
+	}
+}
+
+// WithServerOptions returns an Option which passes opts through to
+// apmhttp.Wrap, enabling the use of any apmhttp.ServerOption, such as
+// apmhttp.WithRecovery or apmhttp.WithProtocolTags. The options are
+// applied after those set by the middleware, so they take precedence;
+// overriding the server request name is not recommended, as it would
+// replace the route-based transaction names.
+func WithServerOptions(opts ...apmhttp.ServerOption) Option {
+	return func(o *options) {
+		o.serverOpts = append(o.serverOpts, opts...)
+	}
+}
@@ -27,8 +27,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"go.elastic.co/apm"
 	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/module/apmgorilla"
+	"go.elastic.co/apm/module/apmhttp"
 	"go.elastic.co/apm/transport/transporttest"
 )
 
@@ -81,6 +83,52 @@ func TestMuxMiddleware(t *testing.T) {
 	}, transaction.Context)
 }
 
+func TestMuxMiddlewareResponseSizeTags(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	r := mux.NewRouter()
+	r.Use(apmgorilla.Middleware(apmgorilla.WithTracer(tracer), apmgorilla.WithResponseSizeTags()))
+	r.Path("/articles/{category}/{id:[0-9]+}").Handler(http.HandlerFunc(articleHandler))
+
+	doRequest(r, "GET", "http://server.testing/articles/fiction/123")
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	assert.Equal(t, model.StringMap{
+		{Key: "response_body_size", Value: "11"},
+	}, payloads.Transactions[0].Context.Tags)
+}
+
+func TestMuxMiddlewareServerOptions(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var recovered interface{}
+	recovery := func(w http.ResponseWriter, req *http.Request, resp *apmhttp.Response, body *apm.BodyCapturer, tx *apm.Transaction, v interface{}) {
+		recovered = v
+	}
+
+	r := mux.NewRouter()
+	r.Use(apmgorilla.Middleware(
+		apmgorilla.WithTracer(tracer),
+		apmgorilla.WithServerOptions(apmhttp.WithRecovery(recovery), apmhttp.WithProtocolTags()),
+	))
+	r.HandleFunc("/panic", func(w http.ResponseWriter, req *http.Request) { panic("boom") })
+
+	w := doRequest(r, "GET", "http://server.testing/panic")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "boom", recovered)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, "GET /panic", payloads.Transactions[0].Name)
+	assert.Equal(t, model.StringMap{
+		{Key: "http_protocol", Value: "http/1.1"},
+	}, payloads.Transactions[0].Context.Tags)
+}
+
 func TestInstrumentUnknownRoute(t *testing.T) {
 	tracer, transport := transporttest.NewRecorderTracer()
 	defer tracer.Close()
@@ -23,13 +23,17 @@ import (
 	"context"
 	"database/sql"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/jinzhu/gorm"
+	sqlite3 "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/module/apmgorm"
 	_ "go.elastic.co/apm/module/apmgorm/dialects/mysql"
 	_ "go.elastic.co/apm/module/apmgorm/dialects/postgres"
@@ -164,6 +168,42 @@ func TestCaptureErrors(t *testing.T) {
 	assert.Regexp(t, "no such column: bananas", errors[0].Exception.Message)
 }
 
+func TestRetry(t *testing.T) {
+	// Treat errors for a missing "busy" column as retryable.
+	apmsql.Register("sqlite3_retryable", &sqlite3.SQLiteDriver{},
+		apmsql.WithRetryableErrors(func(err error) bool {
+			return strings.Contains(err.Error(), "no such column: busy")
+		}),
+	)
+
+	db, err := apmgorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetLogger(nopLogger{})
+	db.AutoMigrate(&Product{})
+
+	_, spans, errors := apmtest.WithTransaction(func(ctx context.Context) {
+		apmsql.Retry(ctx, "lookup", 2, func(ctx context.Context) error {
+			return apmgorm.WithContext(ctx, db).Where("busy").First(&Product{}).Error
+		})
+	})
+	require.Len(t, spans, 3)
+	for i, span := range spans[:2] {
+		assert.Equal(t, model.StringMap{
+			{Key: "db_attempt", Value: strconv.Itoa(i + 1)},
+			{Key: "db_error", Value: "retryable"},
+		}, span.Context.Tags)
+	}
+	assert.Equal(t, model.StringMap{
+		{Key: "db_retry_attempts", Value: "2"},
+		{Key: "db_retry_outcome", Value: "exhausted"},
+	}, spans[2].Context.Tags)
+
+	// Only the final attempt's error is reported.
+	require.Len(t, errors, 1)
+	assert.Equal(t, spans[1].ID, errors[0].ParentID)
+}
+
 func TestOpenWithDriver(t *testing.T) {
 	db, err := apmgorm.Open("sqlite3", "sqlite3", ":memory:")
 	require.NoError(t, err)
@@ -22,6 +22,7 @@ package apmgorm
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	"github.com/jinzhu/gorm"
 
@@ -134,13 +135,27 @@ func newAfterCallback(dsnInfo apmsql.DSNInfo) func(*gorm.Scope) {
 			Type:      "sql",
 			User:      dsnInfo.User,
 		})
+		if dsnInfo.Address != "" {
+			span.Context.SetDestinationAddress(dsnInfo.Address, dsnInfo.Port)
+		}
+		attempt, maxAttempts := apmsql.RetryAttempt(ctx)
+		if attempt > 0 {
+			span.Context.SetTag("db_attempt", strconv.Itoa(attempt))
+		}
 		defer span.End()
 
-		// Capture errors, except for "record not found", which may be expected.
+		// Capture errors, except for "record not found", which may be expected,
+		// and retryable errors for apmsql.Retry attempts that will be retried.
 		for _, err := range scope.DB().GetErrors() {
 			if gorm.IsRecordNotFoundError(err) {
 				continue
 			}
+			if apmsql.IsRetryableError(err) {
+				span.Context.SetTag("db_error", "retryable")
+				if attempt < maxAttempts {
+					continue
+				}
+			}
 			if e := apm.CaptureError(ctx, err); e != nil {
 				e.Send()
 			}
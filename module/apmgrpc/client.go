@@ -54,7 +54,7 @@ func NewUnaryClientInterceptor(o ...ClientOption) grpc.UnaryClientInterceptor {
 }
 
 func startSpan(ctx context.Context, name string) (*apm.Span, context.Context) {
-	span, ctx := apm.StartSpan(ctx, name, "external.grpc")
+	span, ctx := apm.StartSpanOptions(ctx, name, "external.grpc", apm.SpanOptions{ExitSpan: true})
 	if span.Dropped() {
 		return span, ctx
 	}
@@ -70,8 +70,18 @@ func startSpan(ctx context.Context, name string) (*apm.Span, context.Context) {
 }
 
 type clientOptions struct {
-	tracer *apm.Tracer
+	tracer             *apm.Tracer
+	streamMessageSpans bool
 }
 
 // ClientOption sets options for client-side tracing.
 type ClientOption func(*clientOptions)
+
+// WithClientStreamMessageSpans returns a ClientOption which enables
+// reporting a span for each message received or sent on streams traced
+// by the interceptor returned by NewStreamClientInterceptor.
+func WithClientStreamMessageSpans() ClientOption {
+	return func(o *clientOptions) {
+		o.streamMessageSpans = true
+	}
+}
@@ -1,6 +1,7 @@
 module go.elastic.co/apm/module/apmgrpc
 
 require (
+	github.com/golang/protobuf v1.2.0
 	github.com/grpc-ecosystem/go-grpc-middleware v1.0.0
 	github.com/stretchr/testify v1.2.2
 	go.elastic.co/apm v1.3.0
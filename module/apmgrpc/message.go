@@ -0,0 +1,97 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmgrpc
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	"go.elastic.co/apm/internal/wildcard"
+)
+
+const redacted = "[REDACTED]"
+
+var messageMarshaler = jsonpb.Marshaler{OrigName: true}
+
+// messageCapturer encodes gRPC messages as JSON for recording in
+// transaction and error context.
+type messageCapturer struct {
+	maxSize        int
+	redactedFields wildcard.Matchers
+}
+
+// encode returns the JSON encoding of msg, with the values of any
+// fields matching c.redactedFields replaced, truncated to at most
+// c.maxSize bytes without splitting UTF-8 sequences. If msg is not
+// a protobuf message, or cannot be encoded, encode returns false.
+func (c *messageCapturer) encode(msg interface{}) (string, bool) {
+	pb, ok := msg.(proto.Message)
+	if !ok || pb == nil {
+		return "", false
+	}
+	encoded, err := messageMarshaler.MarshalToString(pb)
+	if err != nil {
+		return "", false
+	}
+	if len(c.redactedFields) != 0 {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(encoded), &decoded); err != nil {
+			return "", false
+		}
+		redactFields(decoded, c.redactedFields)
+		data, err := json.Marshal(decoded)
+		if err != nil {
+			return "", false
+		}
+		encoded = string(data)
+	}
+	if c.maxSize > 0 && len(encoded) > c.maxSize {
+		// Back up to the start of a rune, so
+		// multi-byte characters are not split.
+		n := c.maxSize
+		for n > 0 && !utf8.RuneStart(encoded[n]) {
+			n--
+		}
+		encoded = encoded[:n]
+	}
+	return encoded, true
+}
+
+// redactFields replaces the values of object fields, at any depth,
+// whose names match any of the given matchers.
+func redactFields(v interface{}, matchers wildcard.Matchers) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, fieldValue := range v {
+			if matchers.MatchAny(k) {
+				v[k] = redacted
+				continue
+			}
+			redactFields(fieldValue, matchers)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			redactFields(elem, matchers)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmgrpc
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+
+	"go.elastic.co/apm"
+)
+
+// Gatherer is an apm.MetricsGatherer which reports the connectivity state
+// transitions of client connections watched with Gatherer.WatchConn, and
+// the address updates made by resolvers built by resolver builders wrapped
+// with Gatherer.WrapResolverBuilder.
+//
+// For each target, a metric set labeled with "target" holding the dial
+// target is reported, containing the metrics:
+//
+//  - grpc.client.conns.ready: number of watched connections which are ready
+//  - grpc.client.conns.transient_failure: number of watched connections in
+//    transient failure
+//  - grpc.client.transitions.ready: number of transitions to ready
+//  - grpc.client.transitions.transient_failure: number of transitions to
+//    transient failure
+//  - grpc.client.resolver.updates: number of resolver address updates
+//  - grpc.client.resolver.addresses: number of addresses in the most recent
+//    resolver address update
+//
+// Resolver targets are reported in the form "scheme://authority/endpoint",
+// so connections should be dialed with a target in the same form for their
+// metrics to be reported in the same metric set.
+//
+// The Gatherer must be registered with a tracer using
+// apm.Tracer.RegisterMetricsGatherer.
+type Gatherer struct {
+	mu      sync.RWMutex
+	targets map[string]*targetMetrics
+	order   []*targetMetrics
+}
+
+// NewGatherer returns a new Gatherer.
+func NewGatherer() *Gatherer {
+	return &Gatherer{targets: make(map[string]*targetMetrics)}
+}
+
+// targetMetrics holds the metrics recorded for a target.
+type targetMetrics struct {
+	// accessed atomically; must be 64-bit aligned
+	ready            int64
+	transientFailure int64
+	toReady          uint64
+	toFailure        uint64
+	resolverUpdates  uint64
+	resolverAddrs    int64
+
+	target string
+}
+
+func (g *Gatherer) target(target string) *targetMetrics {
+	g.mu.RLock()
+	t, ok := g.targets[target]
+	g.mu.RUnlock()
+	if ok {
+		return t
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if t, ok := g.targets[target]; ok {
+		return t
+	}
+	t = &targetMetrics{target: target}
+	g.targets[target] = t
+	g.order = append(g.order, t)
+	return t
+}
+
+// WatchConn watches conn's connectivity state until conn is closed,
+// recording its transitions to the ready and transient failure states.
+// The metrics are labeled with conn.Target().
+//
+// Only transitions made after WatchConn is called are recorded, and
+// transitions made in quick succession may be observed as one. WatchConn
+// should be called at most once for a given connection.
+func (g *Gatherer) WatchConn(conn *grpc.ClientConn) {
+	t := g.target(conn.Target())
+	state := conn.GetState()
+	t.enter(state)
+	go func() {
+		for state != connectivity.Shutdown {
+			if !conn.WaitForStateChange(context.Background(), state) {
+				return
+			}
+			newState := conn.GetState()
+			t.leave(state)
+			t.enter(newState)
+			switch newState {
+			case connectivity.Ready:
+				atomic.AddUint64(&t.toReady, 1)
+			case connectivity.TransientFailure:
+				atomic.AddUint64(&t.toFailure, 1)
+			}
+			state = newState
+		}
+	}()
+}
+
+func (t *targetMetrics) enter(state connectivity.State) {
+	t.addState(state, 1)
+}
+
+func (t *targetMetrics) leave(state connectivity.State) {
+	t.addState(state, -1)
+}
+
+func (t *targetMetrics) addState(state connectivity.State, delta int64) {
+	switch state {
+	case connectivity.Ready:
+		atomic.AddInt64(&t.ready, delta)
+	case connectivity.TransientFailure:
+		atomic.AddInt64(&t.transientFailure, delta)
+	}
+}
+
+// WrapResolverBuilder returns a resolver.Builder which wraps b, such that
+// the address updates made by the resolvers it builds are recorded. The
+// metrics are labeled with the resolver target.
+//
+// To record updates for all connections using a scheme, register the
+// returned builder in place of the original:
+//
+//	resolver.Register(gatherer.WrapResolverBuilder(resolver.Get("dns")))
+func (g *Gatherer) WrapResolverBuilder(b resolver.Builder) resolver.Builder {
+	return resolverBuilder{Builder: b, g: g}
+}
+
+type resolverBuilder struct {
+	resolver.Builder
+	g *Gatherer
+}
+
+// Build builds a resolver with the wrapped builder, recording
+// the address updates it makes for target.
+func (b resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOption) (resolver.Resolver, error) {
+	t := b.g.target(target.Scheme + "://" + target.Authority + "/" + target.Endpoint)
+	return b.Builder.Build(target, resolverClientConn{ClientConn: cc, t: t}, opts)
+}
+
+type resolverClientConn struct {
+	resolver.ClientConn
+	t *targetMetrics
+}
+
+// NewAddress records the address update, and passes it on to
+// the wrapped resolver.ClientConn.
+func (cc resolverClientConn) NewAddress(addrs []resolver.Address) {
+	atomic.AddUint64(&cc.t.resolverUpdates, 1)
+	atomic.StoreInt64(&cc.t.resolverAddrs, int64(len(addrs)))
+	cc.ClientConn.NewAddress(addrs)
+}
+
+// GatherMetrics gathers connectivity state and resolver metrics into m.
+func (g *Gatherer) GatherMetrics(ctx context.Context, m *apm.Metrics) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, t := range g.order {
+		labels := []apm.MetricLabel{{Name: "target", Value: t.target}}
+		m.Add("grpc.client.conns.ready", labels, float64(atomic.LoadInt64(&t.ready)))
+		m.Add("grpc.client.conns.transient_failure", labels, float64(atomic.LoadInt64(&t.transientFailure)))
+		m.Add("grpc.client.transitions.ready", labels, float64(atomic.LoadUint64(&t.toReady)))
+		m.Add("grpc.client.transitions.transient_failure", labels, float64(atomic.LoadUint64(&t.toFailure)))
+		m.Add("grpc.client.resolver.updates", labels, float64(atomic.LoadUint64(&t.resolverUpdates)))
+		m.Add("grpc.client.resolver.addresses", labels, float64(atomic.LoadInt64(&t.resolverAddrs)))
+	}
+	return nil
+}
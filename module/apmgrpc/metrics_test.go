@@ -0,0 +1,106 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmgrpc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmgrpc"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestGatherer(t *testing.T) {
+	g := apmgrpc.NewGatherer()
+	s, _, addr := newServer(t, nil)
+	defer s.Stop()
+
+	rb := manual.NewBuilderWithScheme("apmgrpctest")
+	rb.InitialAddrs([]resolver.Address{{Addr: addr.String()}})
+	resolver.Register(g.WrapResolverBuilder(rb))
+
+	conn, err := grpc.Dial("apmgrpctest:///greeter", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	g.WatchConn(conn)
+
+	samples := waitGathererSamples(t, g, func(samples map[string]model.Metric) bool {
+		return samples["grpc.client.transitions.ready"].Value == 1
+	})
+	assert.Equal(t, float64(1), samples["grpc.client.conns.ready"].Value)
+	assert.Equal(t, float64(1), samples["grpc.client.resolver.updates"].Value)
+	assert.Equal(t, float64(1), samples["grpc.client.resolver.addresses"].Value)
+
+	rb.NewAddress([]resolver.Address{{Addr: addr.String()}, {Addr: "localhost:1"}})
+	samples = waitGathererSamples(t, g, func(samples map[string]model.Metric) bool {
+		return samples["grpc.client.resolver.updates"].Value == 2
+	})
+	assert.Equal(t, float64(2), samples["grpc.client.resolver.addresses"].Value)
+
+	rb.NewAddress([]resolver.Address{{Addr: "localhost:1"}})
+	samples = waitGathererSamples(t, g, func(samples map[string]model.Metric) bool {
+		return samples["grpc.client.transitions.transient_failure"].Value >= 1
+	})
+	assert.Equal(t, float64(0), samples["grpc.client.conns.ready"].Value)
+	assert.Equal(t, float64(3), samples["grpc.client.resolver.updates"].Value)
+
+	conn.Close()
+	samples = waitGathererSamples(t, g, func(samples map[string]model.Metric) bool {
+		return samples["grpc.client.conns.ready"].Value == 0 &&
+			samples["grpc.client.conns.transient_failure"].Value == 0
+	})
+	assert.Equal(t, float64(1), samples["grpc.client.transitions.ready"].Value)
+}
+
+// waitGathererSamples gathers metrics from g until cond returns true
+// for the samples of the single reported metric set, failing the test
+// if cond is not satisfied within 10 seconds.
+func waitGathererSamples(t *testing.T, g *apmgrpc.Gatherer, cond func(map[string]model.Metric) bool) map[string]model.Metric {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.RegisterMetricsGatherer(g)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		transport.ResetPayloads()
+		tracer.SendMetrics(nil)
+		var samples map[string]model.Metric
+		for _, m := range transport.Payloads().Metrics {
+			if len(m.Labels) != 0 {
+				require.Equal(t, model.StringMap{{Key: "target", Value: "apmgrpctest:///greeter"}}, m.Labels)
+				samples = m.Samples
+			}
+		}
+		if samples != nil && cond(samples) {
+			return samples
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for metrics, last reported: %v", samples)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
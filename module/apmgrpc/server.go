@@ -20,7 +20,10 @@
 package apmgrpc
 
 import (
+	"crypto/subtle"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
@@ -29,6 +32,8 @@ import (
 	"google.golang.org/grpc/status"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/internal/apmconfig"
+	"go.elastic.co/apm/internal/wildcard"
 	"go.elastic.co/apm/module/apmhttp"
 )
 
@@ -54,6 +59,13 @@ func NewUnaryServerInterceptor(o ...ServerOption) grpc.UnaryServerInterceptor {
 	for _, o := range o {
 		o(&opts)
 	}
+	var messages *messageCapturer
+	if opts.captureMessages {
+		messages = &messageCapturer{
+			maxSize:        opts.messageMaxSize,
+			redactedFields: opts.redactedMessageFields,
+		}
+	}
 	return func(
 		ctx context.Context,
 		req interface{},
@@ -63,18 +75,36 @@ func NewUnaryServerInterceptor(o ...ServerOption) grpc.UnaryServerInterceptor {
 		if !opts.tracer.Active() {
 			return handler(ctx, req)
 		}
-		tx, ctx := startTransaction(ctx, opts.tracer, info.FullMethod)
+		tx, ctx := startTransaction(ctx, opts.tracer, info.FullMethod, opts.forceSample)
 		defer tx.End()
+		if deadline, ok := ctx.Deadline(); ok && tx.Sampled() {
+			budget := setDeadlineTag(tx, deadline)
+			if opts.deadlineUsage {
+				defer setDeadlineUsageTag(tx, time.Now(), budget)
+			}
+		}
 
 		// TODO(axw) define context schema for RPC,
 		// including at least the peer address.
 
+		var requestMessage string
+		var haveRequestMessage bool
+		if messages != nil {
+			requestMessage, haveRequestMessage = messages.encode(req)
+			if haveRequestMessage && tx.Sampled() {
+				tx.Context.SetTag("grpc_request", requestMessage)
+			}
+		}
+
 		defer func() {
 			r := recover()
 			if r != nil {
 				e := opts.tracer.Recovered(r)
 				e.SetTransaction(tx)
 				e.Context.SetFramework("grpc", grpc.Version)
+				if haveRequestMessage {
+					e.Context.SetTag("grpc_request", requestMessage)
+				}
 				e.Handled = opts.recover
 				e.Send()
 				if opts.recover {
@@ -87,11 +117,16 @@ func NewUnaryServerInterceptor(o ...ServerOption) grpc.UnaryServerInterceptor {
 
 		resp, err = handler(ctx, req)
 		setTransactionResult(tx, err)
+		if messages != nil && err == nil && tx.Sampled() {
+			if responseMessage, ok := messages.encode(resp); ok {
+				tx.Context.SetTag("grpc_response", responseMessage)
+			}
+		}
 		return resp, err
 	}
 }
 
-func startTransaction(ctx context.Context, tracer *apm.Tracer, name string) (*apm.Transaction, context.Context) {
+func startTransaction(ctx context.Context, tracer *apm.Tracer, name string, forceSample forceSampleMetadata) (*apm.Transaction, context.Context) {
 	var opts apm.TransactionOptions
 	if md, ok := metadata.FromIncomingContext(ctx); ok {
 		if values := md.Get(traceparentHeader); len(values) == 1 {
@@ -100,12 +135,32 @@ func startTransaction(ctx context.Context, tracer *apm.Tracer, name string) (*ap
 				opts.TraceContext = traceContext
 			}
 		}
+		opts.ForceSample = forceSample.match(md)
 	}
 	tx := tracer.StartTransactionOptions(name, "request", opts)
 	tx.Context.SetFramework("grpc", grpc.Version)
 	return tx, apm.ContextWithTransaction(ctx, tx)
 }
 
+// setDeadlineTag records the time remaining until the caller-imposed
+// deadline as the "grpc_deadline_remaining" tag, returning the remaining
+// time.
+func setDeadlineTag(tx *apm.Transaction, deadline time.Time) time.Duration {
+	budget := time.Until(deadline)
+	tx.Context.SetTag("grpc_deadline_remaining", budget.Round(time.Millisecond).String())
+	return budget
+}
+
+// setDeadlineUsageTag records the percentage of budget consumed since
+// start as the "grpc_deadline_used_percent" tag.
+func setDeadlineUsageTag(tx *apm.Transaction, start time.Time, budget time.Duration) {
+	var percent float64 = 100
+	if budget > 0 {
+		percent = float64(time.Since(start)) / float64(budget) * 100
+	}
+	tx.Context.SetTag("grpc_deadline_used_percent", strconv.FormatFloat(percent, 'f', 1, 64))
+}
+
 func setTransactionResult(tx *apm.Transaction, err error) {
 	if err == nil {
 		tx.Result = codes.OK.String()
@@ -121,6 +176,32 @@ func setTransactionResult(tx *apm.Transaction, err error) {
 type serverOptions struct {
 	tracer  *apm.Tracer
 	recover bool
+
+	captureMessages       bool
+	messageMaxSize        int
+	redactedMessageFields wildcard.Matchers
+
+	forceSample forceSampleMetadata
+
+	deadlineUsage bool
+
+	streamMessageSpans bool
+}
+
+// forceSampleMetadata holds the metadata key and shared secret
+// configured with WithForceSampleMetadata.
+type forceSampleMetadata struct {
+	key    string
+	secret string
+}
+
+// match reports whether md holds f.key with the value f.secret.
+func (f forceSampleMetadata) match(md metadata.MD) bool {
+	if f.key == "" {
+		return false
+	}
+	values := md.Get(f.key)
+	return len(values) == 1 && subtle.ConstantTimeCompare([]byte(values[0]), []byte(f.secret)) == 1
 }
 
 // ServerOption sets options for server-side tracing.
@@ -149,3 +230,88 @@ func WithRecovery() ServerOption {
 		o.recover = true
 	}
 }
+
+// WithMessageCapture returns a ServerOption which enables capturing of
+// request and response messages in the gRPC server interceptor.
+//
+// Messages are encoded as JSON, and recorded in the transaction tags
+// "grpc_request" and "grpc_response". If the handler panics, the request
+// message will also be recorded in the reported error. If maxSize is
+// positive, encoded messages will be truncated to at most maxSize bytes,
+// without splitting multi-byte characters. Tag values are independently
+// truncated by the tracer to 10000 characters, so messages are truncated
+// to that length if maxSize is zero or larger.
+//
+// Message capture may record sensitive information; use
+// WithRedactedMessageFields to redact such fields.
+func WithMessageCapture(maxSize int) ServerOption {
+	return func(o *serverOptions) {
+		o.captureMessages = true
+		o.messageMaxSize = maxSize
+	}
+}
+
+// WithRedactedMessageFields returns a ServerOption which sets the wildcard
+// patterns used for matching message field names whose values should be
+// redacted when capturing messages. Patterns are matched against the
+// original protobuf field names, at any depth, case-insensitively unless
+// prefixed with "(?-i)".
+//
+// This option has no effect unless message capture is enabled with
+// WithMessageCapture.
+func WithRedactedMessageFields(patterns ...string) ServerOption {
+	matchers := make(wildcard.Matchers, len(patterns))
+	for i, p := range patterns {
+		matchers[i] = apmconfig.ParseWildcardPattern(p)
+	}
+	return func(o *serverOptions) {
+		o.redactedMessageFields = matchers
+	}
+}
+
+// WithForceSampleMetadata returns a ServerOption which enables forced
+// sampling of requests carrying the metadata key with the given name
+// and a value equal to secret, regardless of the tracer's sampler or
+// the sampling decision in the incoming trace context. This is intended
+// for capturing traces of specific requests while debugging.
+//
+// The secret guards against clients forcing the sampling of many
+// requests, and must not be empty.
+func WithForceSampleMetadata(key, secret string) ServerOption {
+	if key == "" {
+		panic("key == \"\"")
+	}
+	if secret == "" {
+		panic("secret == \"\"")
+	}
+	return func(o *serverOptions) {
+		o.forceSample = forceSampleMetadata{key: strings.ToLower(key), secret: secret}
+	}
+}
+
+// WithDeadlineUsage returns a ServerOption which records the percentage
+// of the caller-imposed deadline consumed by the handler, in the
+// transaction tag "grpc_deadline_used_percent". Deadline exhaustion is
+// a common cause of gRPC failures which is otherwise hidden from the
+// server.
+//
+// The time remaining until the deadline when the request is received
+// is always recorded in the tag "grpc_deadline_remaining".
+func WithDeadlineUsage() ServerOption {
+	return func(o *serverOptions) {
+		o.deadlineUsage = true
+	}
+}
+
+// WithStreamMessageSpans returns a ServerOption which enables reporting
+// a span for each message received or sent on streams traced by the
+// interceptor returned by NewStreamServerInterceptor. This has no effect
+// on unary requests.
+//
+// Message spans can be useful for diagnosing slow streams, but long-lived
+// streams may exceed the transaction's maximum number of spans.
+func WithStreamMessageSpans() ServerOption {
+	return func(o *serverOptions) {
+		o.streamMessageSpans = true
+	}
+}
@@ -24,7 +24,9 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
@@ -185,6 +187,145 @@ func TestServerRecovery(t *testing.T) {
 	assert.Equal(t, "boom", e.Exception.Message)
 }
 
+func TestServerMessageCapture(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	s, server, addr := newServer(t, tracer,
+		apmgrpc.WithRecovery(),
+		apmgrpc.WithMessageCapture(0),
+		apmgrpc.WithRedactedMessageFields("message"),
+	)
+	defer s.GracefulStop()
+
+	conn, client := newClient(t, addr)
+	defer conn.Close()
+
+	_, err := client.SayHello(context.Background(), &pb.HelloRequest{Name: "birita"})
+	require.NoError(t, err)
+	server.panic = true
+	server.err = errors.New("boom")
+	_, err = client.SayHello(context.Background(), &pb.HelloRequest{Name: "birita"})
+	require.Error(t, err)
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, model.StringMap{
+		{Key: "grpc_request", Value: `{"name":"birita"}`},
+		{Key: "grpc_response", Value: `{"message":"[REDACTED]"}`},
+	}, payloads.Transactions[0].Context.Tags)
+	assert.Equal(t, model.StringMap{
+		{Key: "grpc_request", Value: `{"name":"birita"}`},
+	}, payloads.Transactions[1].Context.Tags)
+
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "grpc_request", Value: `{"name":"birita"}`},
+	}, payloads.Errors[0].Context.Tags)
+}
+
+func TestServerMessageCaptureMaxSize(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	s, _, addr := newServer(t, tracer, apmgrpc.WithMessageCapture(10))
+	defer s.GracefulStop()
+
+	conn, client := newClient(t, addr)
+	defer conn.Close()
+
+	_, err := client.SayHello(context.Background(), &pb.HelloRequest{Name: "birita"})
+	require.NoError(t, err)
+
+	tracer.Flush(nil)
+	tx := transport.Payloads().Transactions[0]
+	assert.Equal(t, model.StringMap{
+		{Key: "grpc_request", Value: `{"name":"b`},
+		{Key: "grpc_response", Value: `{"message"`},
+	}, tx.Context.Tags)
+}
+
+func TestServerMessageCaptureMaxSizeMultibyte(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	s, _, addr := newServer(t, tracer, apmgrpc.WithMessageCapture(10))
+	defer s.GracefulStop()
+
+	conn, client := newClient(t, addr)
+	defer conn.Close()
+
+	// The 10th byte of the encoded request falls within "é",
+	// so the request is truncated before it.
+	_, err := client.SayHello(context.Background(), &pb.HelloRequest{Name: "élan"})
+	require.NoError(t, err)
+
+	tracer.Flush(nil)
+	tx := transport.Payloads().Transactions[0]
+	require.NotEmpty(t, tx.Context.Tags)
+	assert.Equal(t, model.StringMapItem{Key: "grpc_request", Value: `{"name":"`}, tx.Context.Tags[0])
+}
+
+func TestServerForceSampleMetadata(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewRatioSampler(0))
+
+	s, _, addr := newServer(t, tracer, apmgrpc.WithForceSampleMetadata("X-Apm-Force-Sample", "s3cr3t"))
+	defer s.GracefulStop()
+
+	conn, client := newClient(t, addr)
+	defer conn.Close()
+
+	for _, value := range []string{"s3cr3t", "wrong"} {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-apm-force-sample", value)
+		_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "birita"})
+		require.NoError(t, err)
+	}
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Nil(t, payloads.Transactions[0].Sampled) // sampled
+	assert.Equal(t, false, *payloads.Transactions[1].Sampled)
+}
+
+func TestServerDeadline(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	s, _, addr := newServer(t, tracer, apmgrpc.WithDeadlineUsage())
+	defer s.GracefulStop()
+
+	conn, client := newClient(t, addr)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "birita"})
+	require.NoError(t, err)
+	_, err = client.SayHello(context.Background(), &pb.HelloRequest{Name: "birita"})
+	require.NoError(t, err)
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+
+	tags := payloads.Transactions[0].Context.Tags
+	require.Len(t, tags, 2)
+	assert.Equal(t, "grpc_deadline_remaining", tags[0].Key)
+	remaining, err := time.ParseDuration(tags[0].Value)
+	require.NoError(t, err)
+	assert.InDelta(t, time.Minute, remaining, float64(time.Second))
+	assert.Equal(t, "grpc_deadline_used_percent", tags[1].Key)
+	used, err := strconv.ParseFloat(tags[1].Value, 64)
+	require.NoError(t, err)
+	assert.InDelta(t, 0, used, 10)
+
+	assert.Nil(t, payloads.Transactions[1].Context.Tags)
+}
+
 func newServer(t *testing.T, tracer *apm.Tracer, opts ...apmgrpc.ServerOption) (*grpc.Server, *helloworldServer, net.Addr) {
 	// We always install grpc_recovery first to avoid panics
 	// aborting the test process. We install it before the
@@ -0,0 +1,235 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmgrpc
+
+import (
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/stats"
+
+	"go.elastic.co/apm"
+)
+
+// NewServerStatsHandler returns a stats.Handler that traces gRPC
+// requests with the given options, as an alternative to the
+// interceptor returned by NewUnaryServerInterceptor. The handler
+// should be installed with grpc.StatsHandler.
+//
+// The handler will trace transactions with the "grpc" type for each
+// incoming RPC, including streaming RPCs. In addition to what is
+// recorded by the interceptor, the handler records the wire sizes of
+// request and response messages, where reported by gRPC, and the
+// compression algorithm used for incoming messages, in the transaction
+// tags "grpc_request_wire_size", "grpc_response_wire_size", and
+// "grpc_compression" respectively. If
+// WithMessageCapture is specified, the first request and response
+// message of each RPC will be recorded.
+//
+// Stats handlers are not invoked in the same call chain as the server
+// method, so panics cannot be recovered, and WithRecovery has no effect.
+func NewServerStatsHandler(o ...ServerOption) stats.Handler {
+	opts := serverOptions{
+		tracer: apm.DefaultTracer,
+	}
+	for _, o := range o {
+		o(&opts)
+	}
+	h := &serverStatsHandler{tracer: opts.tracer, forceSample: opts.forceSample}
+	if opts.captureMessages {
+		h.messages = &messageCapturer{
+			maxSize:        opts.messageMaxSize,
+			redactedFields: opts.redactedMessageFields,
+		}
+	}
+	return h
+}
+
+// NewClientStatsHandler returns a stats.Handler that traces gRPC
+// requests with the given options, as an alternative to the
+// interceptor returned by NewUnaryClientInterceptor. The handler
+// should be installed with grpc.WithStatsHandler.
+//
+// The handler will trace spans with the "grpc" type for each RPC made,
+// including streaming RPCs, for any client method presented with a
+// context containing a sampled apm.Transaction. The wire sizes of
+// request and response messages, where reported by gRPC, are recorded
+// in the span tags "grpc_request_wire_size" and "grpc_response_wire_size".
+//
+// Stats handlers are not invoked in the same call chain as a stream's
+// SendMsg and RecvMsg methods, so spans cannot be reported for stream
+// messages, and the handler takes no ClientOptions.
+func NewClientStatsHandler() stats.Handler {
+	return clientStatsHandler{}
+}
+
+type serverStatsHandler struct {
+	tracer      *apm.Tracer
+	messages    *messageCapturer
+	forceSample forceSampleMetadata
+}
+
+// TagRPC starts a transaction for the RPC, and returns a context
+// containing it.
+func (h *serverStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	if !h.tracer.Active() {
+		return ctx
+	}
+	tx, ctx := startTransaction(ctx, h.tracer, info.FullMethodName, h.forceSample)
+	return context.WithValue(ctx, rpcStatsKey{}, &rpcStats{tx: tx})
+}
+
+// HandleRPC records RPC stats in the transaction, ending it
+// when the RPC completes.
+func (h *serverStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	rs, ok := ctx.Value(rpcStatsKey{}).(*rpcStats)
+	if !ok || rs.tx == nil {
+		return
+	}
+	messages := h.messages
+	if !rs.tx.Sampled() {
+		messages = nil
+	}
+	rs.handle(s, messages)
+	if end, ok := s.(*stats.End); ok {
+		tx := rs.tx
+		setTransactionResult(tx, end.Error)
+		if tx.Sampled() {
+			rs.setTags(&tx.Context)
+		}
+		tx.End()
+	}
+}
+
+// TagConn returns ctx.
+func (*serverStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+// HandleConn does nothing.
+func (*serverStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+type clientStatsHandler struct{}
+
+// TagRPC starts a span for the RPC, and returns a context containing
+// it, and the outgoing traceparent metadata.
+func (clientStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	span, ctx := startSpan(ctx, info.FullMethodName)
+	if span.Dropped() {
+		span.End()
+		return ctx
+	}
+	return context.WithValue(ctx, rpcStatsKey{}, &rpcStats{span: span})
+}
+
+// HandleRPC records RPC stats in the span, ending it when the
+// RPC completes.
+func (clientStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	rs, ok := ctx.Value(rpcStatsKey{}).(*rpcStats)
+	if !ok || rs.span == nil {
+		return
+	}
+	rs.handle(s, nil)
+	if _, ok := s.(*stats.End); ok {
+		rs.setTags(&rs.span.Context)
+		rs.span.End()
+	}
+}
+
+// TagConn returns ctx.
+func (clientStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+// HandleConn does nothing.
+func (clientStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+type rpcStatsKey struct{}
+
+// rpcStats holds the stats accumulated for an RPC, which
+// may be reported concurrently for streaming RPCs.
+type rpcStats struct {
+	tx   *apm.Transaction
+	span *apm.Span
+
+	mu                  sync.Mutex
+	requestWireSize     int
+	responseWireSize    int
+	compression         string
+	requestMessage      string
+	responseMessage     string
+	haveRequestMessage  bool
+	haveResponseMessage bool
+}
+
+func (rs *rpcStats) handle(s stats.RPCStats, messages *messageCapturer) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	switch s := s.(type) {
+	case *stats.InHeader:
+		rs.compression = s.Compression
+	case *stats.InPayload:
+		if s.Client {
+			rs.responseWireSize += s.WireLength
+			break
+		}
+		rs.requestWireSize += s.WireLength
+		if messages != nil && !rs.haveRequestMessage {
+			rs.requestMessage, rs.haveRequestMessage = messages.encode(s.Payload)
+		}
+	case *stats.OutPayload:
+		if s.Client {
+			rs.requestWireSize += s.WireLength
+			break
+		}
+		rs.responseWireSize += s.WireLength
+		if messages != nil && !rs.haveResponseMessage {
+			rs.responseMessage, rs.haveResponseMessage = messages.encode(s.Payload)
+		}
+	}
+}
+
+// tagSetter is implemented by *apm.Context and *apm.SpanContext.
+type tagSetter interface {
+	SetTag(key, value string)
+}
+
+func (rs *rpcStats) setTags(out tagSetter) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.haveRequestMessage {
+		out.SetTag("grpc_request", rs.requestMessage)
+	}
+	if rs.haveResponseMessage {
+		out.SetTag("grpc_response", rs.responseMessage)
+	}
+	// gRPC may not report the wire length of received messages,
+	// so we only record sizes that have been reported.
+	if rs.requestWireSize > 0 {
+		out.SetTag("grpc_request_wire_size", strconv.Itoa(rs.requestWireSize))
+	}
+	if rs.responseWireSize > 0 {
+		out.SetTag("grpc_response_wire_size", strconv.Itoa(rs.responseWireSize))
+	}
+	if rs.compression != "" {
+		out.SetTag("grpc_compression", rs.compression)
+	}
+}
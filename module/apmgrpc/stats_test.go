@@ -0,0 +1,88 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmgrpc_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmgrpc"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestStatsHandlers(t *testing.T) {
+	serverTracer, serverTransport := transporttest.NewRecorderTracer()
+	defer serverTracer.Close()
+
+	s := grpc.NewServer(grpc.StatsHandler(apmgrpc.NewServerStatsHandler(
+		apmgrpc.WithTracer(serverTracer),
+		apmgrpc.WithMessageCapture(0),
+	)))
+	pb.RegisterGreeterServer(s, &helloworldServer{})
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go s.Serve(lis)
+
+	conn, err := grpc.Dial(
+		lis.Addr().String(), grpc.WithInsecure(),
+		grpc.WithStatsHandler(apmgrpc.NewClientStatsHandler()),
+	)
+	require.NoError(t, err)
+	client := pb.NewGreeterClient(conn)
+
+	_, clientSpans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		resp, err := client.SayHello(ctx, &pb.HelloRequest{Name: "birita"})
+		require.NoError(t, err)
+		assert.Equal(t, resp, &pb.HelloReply{Message: "hello, birita"})
+	})
+	conn.Close()
+	s.GracefulStop() // wait for the server's stats handler to complete
+
+	require.Len(t, clientSpans, 1)
+	assert.Equal(t, "/helloworld.Greeter/SayHello", clientSpans[0].Name)
+	assert.Equal(t, "external", clientSpans[0].Type)
+	assert.Equal(t, "grpc", clientSpans[0].Subtype)
+	assert.Equal(t, model.StringMap{
+		{Key: "grpc_request_wire_size", Value: "13"},
+	}, clientSpans[0].Context.Tags)
+
+	serverTracer.Flush(nil)
+	serverTransactions := serverTransport.Payloads().Transactions
+	require.Len(t, serverTransactions, 1)
+	tx := serverTransactions[0]
+	assert.Equal(t, "/helloworld.Greeter/SayHello", tx.Name)
+	assert.Equal(t, "request", tx.Type)
+	assert.Equal(t, "OK", tx.Result)
+	assert.Equal(t, clientSpans[0].TraceID, tx.TraceID)
+	assert.Equal(t, clientSpans[0].ID, tx.ParentID)
+	assert.Equal(t, model.StringMap{
+		{Key: "grpc_request", Value: `{"name":"birita"}`},
+		{Key: "grpc_response", Value: `{"message":"hello, birita"}`},
+		{Key: "grpc_response_wire_size", Value: "20"},
+	}, tx.Context.Tags)
+}
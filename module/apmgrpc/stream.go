@@ -0,0 +1,344 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmgrpc
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.elastic.co/apm"
+)
+
+// NewStreamServerInterceptor returns a grpc.StreamServerInterceptor that
+// traces gRPC streaming requests with the given options.
+//
+// The interceptor will trace a transaction with the "grpc" type covering
+// the lifetime of each incoming stream. The transaction will be added to
+// the stream's context, so server methods can use apm.StartSpan with the
+// context returned by the stream's Context method. The transaction is
+// tagged with the kind of stream ("client", "server" or "bidi") and the
+// number of messages received and sent, in the tags "grpc_stream",
+// "grpc_messages_received" and "grpc_messages_sent".
+//
+// If RecvMsg or SendMsg fails, and the server method returns no error,
+// the transaction result is set from the status of the failure.
+// If WithStreamMessageSpans is specified, a span will be reported for
+// each message received or sent. WithMessageCapture has no effect on
+// streams.
+//
+// By default, the interceptor will trace with apm.DefaultTracer,
+// and will not recover any panics. Use WithTracer to specify an
+// alternative tracer, and WithRecovery to enable panic recovery.
+func NewStreamServerInterceptor(o ...ServerOption) grpc.StreamServerInterceptor {
+	opts := serverOptions{
+		tracer:  apm.DefaultTracer,
+		recover: false,
+	}
+	for _, o := range o {
+		o(&opts)
+	}
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) (err error) {
+		if !opts.tracer.Active() {
+			return handler(srv, ss)
+		}
+		tx, ctx := startTransaction(ss.Context(), opts.tracer, info.FullMethod, opts.forceSample)
+		defer tx.End()
+		if deadline, ok := ctx.Deadline(); ok && tx.Sampled() {
+			budget := setDeadlineTag(tx, deadline)
+			if opts.deadlineUsage {
+				defer setDeadlineUsageTag(tx, time.Now(), budget)
+			}
+		}
+		stream := &serverStream{
+			ServerStream: ss,
+			ctx:          ctx,
+			method:       info.FullMethod,
+			messageSpans: opts.streamMessageSpans,
+		}
+		if tx.Sampled() {
+			tx.Context.SetTag("grpc_stream", streamKind(info.IsClientStream, info.IsServerStream))
+			defer stream.counts.setTags(tx)
+		}
+
+		defer func() {
+			r := recover()
+			if r != nil {
+				e := opts.tracer.Recovered(r)
+				e.SetTransaction(tx)
+				e.Context.SetFramework("grpc", grpc.Version)
+				e.Handled = opts.recover
+				e.Send()
+				if opts.recover {
+					err = status.Errorf(codes.Internal, "%s", r)
+				} else {
+					panic(r)
+				}
+			}
+		}()
+
+		err = handler(srv, stream)
+		if err == nil {
+			err = stream.err()
+		}
+		setTransactionResult(tx, err)
+		return err
+	}
+}
+
+// NewStreamClientInterceptor returns a grpc.StreamClientInterceptor that
+// traces gRPC streaming requests with the given options.
+//
+// The interceptor will trace a span with the "grpc" type covering the
+// lifetime of each stream opened, for any client method presented with
+// a context containing a sampled apm.Transaction. The span ends when the
+// stream finishes: when RecvMsg returns an error (including io.EOF) or,
+// for streams without server streaming, the response message; when
+// SendMsg fails; or when the stream's context is done. The span is tagged
+// like the transactions of NewStreamServerInterceptor and, if the stream
+// fails, with the status code in the tag "grpc_status".
+//
+// If WithClientStreamMessageSpans is specified, a span will be reported
+// for each message received or sent.
+func NewStreamClientInterceptor(o ...ClientOption) grpc.StreamClientInterceptor {
+	opts := clientOptions{}
+	for _, o := range o {
+		o(&opts)
+	}
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		callOpts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		span, ctx := startSpan(ctx, method)
+		if span.Dropped() {
+			span.End()
+			return streamer(ctx, desc, cc, method, callOpts...)
+		}
+		cs, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil {
+			setStatusTag(span, err)
+			span.End()
+			return nil, err
+		}
+		span.Context.SetTag("grpc_stream", streamKind(desc.ClientStreams, desc.ServerStreams))
+		stream := &clientStream{
+			ClientStream: cs,
+			ctx:          ctx,
+			method:       method,
+			messageSpans: opts.streamMessageSpans,
+			serverStream: desc.ServerStreams,
+			span:         span,
+			done:         make(chan struct{}),
+		}
+		go func() {
+			select {
+			case <-ctx.Done():
+				stream.finish(ctx.Err())
+			case <-stream.done:
+			}
+		}()
+		return stream, nil
+	}
+}
+
+// serverStream wraps a grpc.ServerStream, replacing its context
+// with one containing the transaction, and recording messages.
+type serverStream struct {
+	counts messageCounts // accessed atomically; must be 64-bit aligned
+
+	grpc.ServerStream
+	ctx          context.Context
+	method       string
+	messageSpans bool
+
+	mu       sync.Mutex
+	firstErr error
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	end := startMessageSpan(s.ctx, s.messageSpans, s.method, "recv")
+	err := s.ServerStream.RecvMsg(m)
+	end()
+	if err == nil {
+		atomic.AddInt64(&s.counts.received, 1)
+	} else if err != io.EOF {
+		s.setErr(err)
+	}
+	return err
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	end := startMessageSpan(s.ctx, s.messageSpans, s.method, "send")
+	err := s.ServerStream.SendMsg(m)
+	end()
+	if err == nil {
+		atomic.AddInt64(&s.counts.sent, 1)
+	} else {
+		s.setErr(err)
+	}
+	return err
+}
+
+func (s *serverStream) setErr(err error) {
+	s.mu.Lock()
+	if s.firstErr == nil {
+		s.firstErr = err
+	}
+	s.mu.Unlock()
+}
+
+func (s *serverStream) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.firstErr
+}
+
+// clientStream wraps a grpc.ClientStream, ending the stream's
+// span when the stream finishes, and recording messages.
+type clientStream struct {
+	counts messageCounts // accessed atomically; must be 64-bit aligned
+
+	grpc.ClientStream
+	ctx          context.Context
+	method       string
+	messageSpans bool
+	serverStream bool
+
+	span     *apm.Span
+	done     chan struct{}
+	finished sync.Once
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	end := startMessageSpan(s.ctx, s.messageSpans, s.method, "recv")
+	err := s.ClientStream.RecvMsg(m)
+	end()
+	if err == nil {
+		atomic.AddInt64(&s.counts.received, 1)
+	}
+	if err == io.EOF {
+		s.finish(nil)
+	} else if err != nil || !s.serverStream {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	end := startMessageSpan(s.ctx, s.messageSpans, s.method, "send")
+	err := s.ClientStream.SendMsg(m)
+	end()
+	if err == nil {
+		atomic.AddInt64(&s.counts.sent, 1)
+	} else if err != io.EOF {
+		// SendMsg returns io.EOF if the stream was terminated by the
+		// server, in which case the status is returned by RecvMsg.
+		s.finish(err)
+	}
+	return err
+}
+
+// finish ends the stream's span, recording the status of err if non-nil.
+// Only the first call to finish has any effect.
+func (s *clientStream) finish(err error) {
+	s.finished.Do(func() {
+		close(s.done)
+		if err != nil {
+			setStatusTag(s.span, err)
+		}
+		s.counts.setTags(s.span)
+		s.span.End()
+	})
+}
+
+// messageCounts holds the number of messages received
+// and sent on a stream, updated atomically.
+type messageCounts struct {
+	received int64
+	sent     int64
+}
+
+// setTags records the message counts in the tags of x, which
+// must be an *apm.Transaction or *apm.Span.
+func (c *messageCounts) setTags(x interface{}) {
+	received := strconv.FormatInt(atomic.LoadInt64(&c.received), 10)
+	sent := strconv.FormatInt(atomic.LoadInt64(&c.sent), 10)
+	switch x := x.(type) {
+	case *apm.Transaction:
+		x.Context.SetTag("grpc_messages_received", received)
+		x.Context.SetTag("grpc_messages_sent", sent)
+	case *apm.Span:
+		x.Context.SetTag("grpc_messages_received", received)
+		x.Context.SetTag("grpc_messages_sent", sent)
+	}
+}
+
+// startMessageSpan starts a span for a message received or sent on
+// a stream for method, if enabled, returning a function to end it.
+func startMessageSpan(ctx context.Context, enabled bool, method, action string) func() {
+	if !enabled {
+		return func() {}
+	}
+	span, _ := apm.StartSpan(ctx, method+" "+action, "grpc.stream."+action)
+	return span.End
+}
+
+// setStatusTag records the status code of err in the "grpc_status" span tag.
+func setStatusTag(span *apm.Span, err error) {
+	statusCode := codes.Unknown
+	if s, ok := status.FromError(err); ok {
+		statusCode = s.Code()
+	}
+	span.Context.SetTag("grpc_status", statusCode.String())
+}
+
+// streamKind returns the kind of stream with the given
+// client and server streaming properties.
+func streamKind(clientStreams, serverStreams bool) string {
+	switch {
+	case clientStreams && serverStreams:
+		return "bidi"
+	case clientStreams:
+		return "client"
+	case serverStreams:
+		return "server"
+	}
+	return "unary"
+}
@@ -0,0 +1,376 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmgrpc_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/route_guide/routeguide"
+	"google.golang.org/grpc/status"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmgrpc"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestStreamServerTransaction(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	s, addr := newRouteGuideServer(t, tracer, &routeGuideServer{})
+	defer s.GracefulStop()
+	conn, client := newRouteGuideClient(t, addr)
+	defer conn.Close()
+
+	stream, err := client.ListFeatures(context.Background(), &pb.Rectangle{})
+	require.NoError(t, err)
+	var features []*pb.Feature
+	for {
+		feature, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		features = append(features, feature)
+	}
+	assert.Len(t, features, 3)
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	tx := payloads.Transactions[0]
+	assert.Equal(t, "/routeguide.RouteGuide/ListFeatures", tx.Name)
+	assert.Equal(t, "request", tx.Type)
+	assert.Equal(t, "OK", tx.Result)
+	assert.Equal(t, map[string]string{
+		"grpc_stream":            "server",
+		"grpc_messages_received": "1",
+		"grpc_messages_sent":     "3",
+	}, tagsMap(tx.Context.Tags))
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, "server_span", payloads.Spans[0].Name)
+	assert.Equal(t, tx.ID, payloads.Spans[0].ParentID)
+}
+
+func TestStreamServerTransactionError(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	server := &routeGuideServer{err: status.Errorf(codes.InvalidArgument, "no route")}
+	s, addr := newRouteGuideServer(t, tracer, server)
+	defer s.GracefulStop()
+	conn, client := newRouteGuideClient(t, addr)
+	defer conn.Close()
+
+	stream, err := client.RecordRoute(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.Point{}))
+	require.NoError(t, stream.Send(&pb.Point{}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	tx := payloads.Transactions[0]
+	assert.Equal(t, "/routeguide.RouteGuide/RecordRoute", tx.Name)
+	assert.Equal(t, "InvalidArgument", tx.Result)
+	assert.Equal(t, map[string]string{
+		"grpc_stream":            "client",
+		"grpc_messages_received": "2",
+		"grpc_messages_sent":     "0",
+	}, tagsMap(tx.Context.Tags))
+}
+
+func TestStreamServerRecovery(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	server := &routeGuideServer{panic: true}
+	s, addr := newRouteGuideServer(t, tracer, server, apmgrpc.WithRecovery())
+	defer s.GracefulStop()
+	conn, client := newRouteGuideClient(t, addr)
+	defer conn.Close()
+
+	stream, err := client.RouteChat(context.Background())
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Errors, 1)
+	assert.True(t, payloads.Errors[0].Exception.Handled)
+	assert.Equal(t, payloads.Transactions[0].ID, payloads.Errors[0].TransactionID)
+}
+
+func TestStreamServerMessageSpans(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	s, addr := newRouteGuideServer(t, tracer, &routeGuideServer{}, apmgrpc.WithStreamMessageSpans())
+	defer s.GracefulStop()
+	conn, client := newRouteGuideClient(t, addr)
+	defer conn.Close()
+
+	stream, err := client.RouteChat(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.RouteNote{Message: "hello"}))
+	note, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", note.Message)
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	tx := payloads.Transactions[0]
+	assert.Equal(t, "bidi", tagsMap(tx.Context.Tags)["grpc_stream"])
+
+	// Two RecvMsg calls (one message, then io.EOF), and one SendMsg.
+	var names []string
+	for _, span := range payloads.Spans {
+		assert.Equal(t, tx.ID, span.ParentID)
+		names = append(names, span.Name+" "+span.Type)
+	}
+	assert.ElementsMatch(t, []string{
+		"/routeguide.RouteGuide/RouteChat recv grpc",
+		"/routeguide.RouteGuide/RouteChat recv grpc",
+		"/routeguide.RouteGuide/RouteChat send grpc",
+	}, names)
+}
+
+func TestStreamClientSpan(t *testing.T) {
+	serverTracer, serverTransport := transporttest.NewRecorderTracer()
+	defer serverTracer.Close()
+	s, addr := newRouteGuideServer(t, serverTracer, &routeGuideServer{})
+	defer s.GracefulStop()
+	conn, client := newRouteGuideClient(t, addr)
+	defer conn.Close()
+
+	tx, clientSpans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		stream, err := client.ListFeatures(ctx, &pb.Rectangle{})
+		require.NoError(t, err)
+		for {
+			if _, err := stream.Recv(); err != nil {
+				require.Equal(t, io.EOF, err)
+				break
+			}
+		}
+	})
+	require.Len(t, clientSpans, 1)
+	span := clientSpans[0]
+	assert.Equal(t, "/routeguide.RouteGuide/ListFeatures", span.Name)
+	assert.Equal(t, "external", span.Type)
+	assert.Equal(t, "grpc", span.Subtype)
+	assert.Equal(t, tx.ID, span.ParentID)
+	assert.Equal(t, map[string]string{
+		"grpc_stream":            "server",
+		"grpc_messages_received": "3",
+		"grpc_messages_sent":     "1",
+	}, tagsMap(span.Context.Tags))
+
+	serverTracer.Flush(nil)
+	serverTransactions := serverTransport.Payloads().Transactions
+	require.Len(t, serverTransactions, 1)
+	assert.Equal(t, span.TraceID, serverTransactions[0].TraceID)
+	assert.Equal(t, span.ID, serverTransactions[0].ParentID)
+}
+
+func TestStreamClientSpanError(t *testing.T) {
+	server := &routeGuideServer{err: status.Errorf(codes.NotFound, "no features")}
+	s, addr := newRouteGuideServer(t, nil, server)
+	defer s.GracefulStop()
+	conn, client := newRouteGuideClient(t, addr)
+	defer conn.Close()
+
+	_, clientSpans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		stream, err := client.RouteChat(ctx)
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+	require.Len(t, clientSpans, 1)
+	assert.Equal(t, map[string]string{
+		"grpc_stream":            "bidi",
+		"grpc_status":            "NotFound",
+		"grpc_messages_received": "0",
+		"grpc_messages_sent":     "0",
+	}, tagsMap(clientSpans[0].Context.Tags))
+}
+
+func TestStreamClientSpanContextCanceled(t *testing.T) {
+	s, addr := newRouteGuideServer(t, nil, &routeGuideServer{})
+	defer s.GracefulStop()
+	conn, client := newRouteGuideClient(t, addr)
+	defer conn.Close()
+
+	_, clientSpans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx, cancel := context.WithCancel(ctx)
+		stream, err := client.RouteChat(ctx)
+		require.NoError(t, err)
+		cancel()
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	})
+	require.Len(t, clientSpans, 1)
+	assert.Equal(t, "Canceled", tagsMap(clientSpans[0].Context.Tags)["grpc_status"])
+}
+
+func TestStreamClientMessageSpans(t *testing.T) {
+	s, addr := newRouteGuideServer(t, nil, &routeGuideServer{})
+	defer s.GracefulStop()
+	conn, err := grpc.Dial(
+		addr.String(), grpc.WithInsecure(),
+		grpc.WithStreamInterceptor(apmgrpc.NewStreamClientInterceptor(
+			apmgrpc.WithClientStreamMessageSpans(),
+		)),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewRouteGuideClient(conn)
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		stream, err := client.RecordRoute(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pb.Point{}))
+		summary, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), summary.PointCount)
+	})
+	require.Len(t, spans, 3)
+	streamSpan := spans[2]
+	assert.Equal(t, "/routeguide.RouteGuide/RecordRoute", streamSpan.Name)
+	assert.Equal(t, "/routeguide.RouteGuide/RecordRoute send", spans[0].Name)
+	assert.Equal(t, "/routeguide.RouteGuide/RecordRoute recv", spans[1].Name)
+	for _, span := range spans[:2] {
+		assert.Equal(t, "grpc", span.Type)
+		assert.Equal(t, "stream", span.Subtype)
+		assert.Equal(t, streamSpan.ID, span.ParentID)
+	}
+}
+
+func newRouteGuideServer(t *testing.T, tracer *apm.Tracer, server *routeGuideServer, opts ...apmgrpc.ServerOption) (*grpc.Server, net.Addr) {
+	// As in newServer, we always install grpc_recovery first.
+	interceptors := []grpc.StreamServerInterceptor{grpc_recovery.StreamServerInterceptor()}
+	if tracer != nil {
+		opts = append(opts, apmgrpc.WithTracer(tracer))
+		interceptors = append(interceptors, apmgrpc.NewStreamServerInterceptor(opts...))
+	}
+	s := grpc.NewServer(grpc_middleware.WithStreamServerChain(interceptors...))
+	pb.RegisterRouteGuideServer(s, server)
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go s.Serve(lis)
+	return s, lis.Addr()
+}
+
+func newRouteGuideClient(t *testing.T, addr net.Addr) (*grpc.ClientConn, pb.RouteGuideClient) {
+	conn, err := grpc.Dial(
+		addr.String(), grpc.WithInsecure(),
+		grpc.WithStreamInterceptor(apmgrpc.NewStreamClientInterceptor()),
+	)
+	require.NoError(t, err)
+	return conn, pb.NewRouteGuideClient(conn)
+}
+
+func tagsMap(tags model.StringMap) map[string]string {
+	m := make(map[string]string)
+	for _, tag := range tags {
+		m[tag.Key] = tag.Value
+	}
+	return m
+}
+
+type routeGuideServer struct {
+	panic bool
+	err   error
+}
+
+func (s *routeGuideServer) GetFeature(ctx context.Context, point *pb.Point) (*pb.Feature, error) {
+	return &pb.Feature{Location: point}, nil
+}
+
+func (s *routeGuideServer) ListFeatures(rect *pb.Rectangle, stream pb.RouteGuide_ListFeaturesServer) error {
+	// The stream's context should contain a Transaction
+	// for the gRPC request.
+	span, _ := apm.StartSpan(stream.Context(), "server_span", "type")
+	span.End()
+	if s.err != nil {
+		return s.err
+	}
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(&pb.Feature{Location: rect.Lo}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *routeGuideServer) RecordRoute(stream pb.RouteGuide_RecordRouteServer) error {
+	var summary pb.RouteSummary
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		summary.PointCount++
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return stream.SendAndClose(&summary)
+}
+
+func (s *routeGuideServer) RouteChat(stream pb.RouteGuide_RouteChatServer) error {
+	if s.panic {
+		panic("boom")
+	}
+	if s.err != nil {
+		return s.err
+	}
+	for {
+		note, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := stream.Send(note); err != nil {
+			return err
+		}
+	}
+}
@@ -18,6 +18,7 @@
 package apmhttp
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"sync/atomic"
@@ -59,6 +60,8 @@ func WrapRoundTripper(r http.RoundTripper, o ...ClientOption) http.RoundTripper
 		r:              r,
 		requestName:    ClientRequestName,
 		requestIgnorer: IgnoreNone,
+
+		traceparentHeaders: defaultTraceparentHeaders,
 	}
 	for _, o := range o {
 		o(rt)
@@ -70,20 +73,24 @@ type roundTripper struct {
 	r              http.RoundTripper
 	requestName    RequestNameFunc
 	requestIgnorer RequestIgnorerFunc
+	errorStatus    ClientErrorStatusFunc
+
+	traceparentHeaders []string
+	requestIDHeader    string
+	concurrency        *ConcurrencyTracker
 }
 
 // RoundTrip delegates to r.r, emitting a span if req's context
 // contains a transaction.
+//
+// If req's context contains no transaction, or the transaction's
+// tracer is inactive or closed, the request is passed through to
+// r.r without copying it or wrapping the response body.
 func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	// TODO(axw) propagate Tracestate, adding/shifting the elastic
-	// key to the left most position.
-	if r.requestIgnorer(req) {
-		return r.r.RoundTrip(req)
-	}
 	ctx := req.Context()
 	tx := apm.TransactionFromContext(ctx)
-	if tx == nil {
-		return r.r.RoundTrip(req)
+	if tx == nil || !tx.Tracer().Active() || r.requestIgnorer(req) {
+		return r.roundTrip(req)
 	}
 
 	// RoundTrip is not supposed to mutate req, so copy req
@@ -96,25 +103,37 @@ func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	req = &reqCopy
 
 	traceContext := tx.TraceContext()
+	requestID := r.setRequestIDHeader(ctx, req.Header, traceContext)
 	if !traceContext.Options.Recorded() {
-		req.Header.Set(TraceparentHeader, FormatTraceparentHeader(traceContext))
-		return r.r.RoundTrip(req)
+		r.setTraceparentHeaders(req.Header, traceContext)
+		resp, err := r.roundTrip(req)
+		r.captureErrorStatus(ctx, req, resp, err)
+		return resp, err
 	}
 
-	name := r.requestName(req)
-	span := tx.StartSpan(name, "external.http", apm.SpanFromContext(ctx))
+	name, ok := clientSpanNameFromContext(ctx)
+	if !ok {
+		name = r.requestName(req)
+	}
+	span, spanCtx := apm.StartSpanOptions(ctx, name, "external.http", apm.SpanOptions{
+		ExitSpan: true,
+	})
 	if !span.Dropped() {
 		traceContext = span.TraceContext()
-		ctx = apm.ContextWithSpan(ctx, span)
+		ctx = spanCtx
 		req = RequestWithContext(ctx, req)
 		span.Context.SetHTTPRequest(req)
+		if requestID != "" {
+			span.Context.SetTag(requestIDTag, requestID)
+		}
 	} else {
 		span.End()
 		span = nil
 	}
 
-	req.Header.Set(TraceparentHeader, FormatTraceparentHeader(traceContext))
-	resp, err := r.r.RoundTrip(req)
+	r.setTraceparentHeaders(req.Header, traceContext)
+	resp, err := r.roundTrip(req)
+	r.captureErrorStatus(ctx, req, resp, err)
 	if span != nil {
 		if err != nil {
 			span.End()
@@ -126,6 +145,75 @@ func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return resp, err
 }
 
+// roundTrip calls r.r.RoundTrip, tracking the request's
+// concurrency if a ConcurrencyTracker is configured.
+func (r *roundTripper) roundTrip(req *http.Request) (*http.Response, error) {
+	if r.concurrency != nil {
+		return TrackConcurrency(r.concurrency, r.r, req)
+	}
+	return r.r.RoundTrip(req)
+}
+
+// setTraceparentHeaders sets each of the configured traceparent headers
+// in h to the formatted trace context, and the tracestate header to the
+// trace context's state if it is non-empty.
+func (r *roundTripper) setTraceparentHeaders(h http.Header, traceContext apm.TraceContext) {
+	value := FormatTraceparentHeader(traceContext)
+	for _, header := range r.traceparentHeaders {
+		h.Set(header, value)
+	}
+	if state := traceContext.State.String(); state != "" {
+		h.Set(W3CTracestateHeader, state)
+	}
+}
+
+// setRequestIDHeader sets the configured request ID header in h, if any,
+// and returns the request ID. If h already has a value for the header it
+// is left alone; otherwise the ID is taken from ctx, falling back to the
+// trace ID so that the request can be correlated with the trace.
+func (r *roundTripper) setRequestIDHeader(ctx context.Context, h http.Header, traceContext apm.TraceContext) string {
+	if r.requestIDHeader == "" {
+		return ""
+	}
+	if id := h.Get(r.requestIDHeader); id != "" {
+		return id
+	}
+	id, ok := RequestIDFromContext(ctx)
+	if !ok || id == "" {
+		id = traceContext.Trace.String()
+	}
+	h.Set(r.requestIDHeader, id)
+	return id
+}
+
+// captureErrorStatus reports an error to Elastic APM if resp has a
+// status code matched by r.errorStatus.
+func (r *roundTripper) captureErrorStatus(ctx context.Context, req *http.Request, resp *http.Response, err error) {
+	if r.errorStatus == nil || err != nil || !r.errorStatus(resp.StatusCode) {
+		return
+	}
+	e := apm.CaptureError(ctx, &clientStatusError{
+		method: req.Method,
+		host:   req.URL.Host,
+		path:   req.URL.Path,
+		status: resp.Status,
+	})
+	e.Send()
+}
+
+// clientStatusError is the error reported for client responses
+// whose status code is matched by a ClientErrorStatusFunc.
+type clientStatusError struct {
+	method string
+	host   string
+	path   string
+	status string
+}
+
+func (e *clientStatusError) Error() string {
+	return e.method + " " + e.host + e.path + ": " + e.status
+}
+
 // CloseIdleConnections calls r.r.CloseIdleConnections if the method exists.
 func (r *roundTripper) CloseIdleConnections() {
 	type closeIdler interface {
@@ -176,3 +264,95 @@ func (b *responseBody) endSpan() {
 
 // ClientOption sets options for tracing client requests.
 type ClientOption func(*roundTripper)
+
+// ClientErrorStatusFunc is the type of a function for deciding whether
+// a response with the given status code should be reported as an error.
+type ClientErrorStatusFunc func(statusCode int) bool
+
+// WithClientErrorStatuses returns a ClientOption which sets a function
+// for deciding which response status codes should be reported as errors
+// to Elastic APM. By default, no errors are reported for responses.
+//
+// Errors are reported for the client request span, or if the span is
+// not sampled, for the transaction in the request context. For example,
+// to report an error for all 4xx and 5xx status codes other than 404:
+//
+//	apmhttp.WithClientErrorStatuses(func(statusCode int) bool {
+//		return statusCode >= 400 && statusCode != http.StatusNotFound
+//	})
+func WithClientErrorStatuses(f ClientErrorStatusFunc) ClientOption {
+	return func(rt *roundTripper) {
+		rt.errorStatus = f
+	}
+}
+
+// WithClientTraceparentHeaders returns a ClientOption which sets the HTTP
+// headers with which the trace context is propagated. By default, only
+// TraceparentHeader is sent.
+//
+// Once all services have been upgraded to accept the W3C Trace-Context
+// header, the legacy Elastic APM header can be dropped to reduce the
+// request size:
+//
+//	apmhttp.WithClientTraceparentHeaders(apmhttp.W3CTraceparentHeader)
+func WithClientTraceparentHeaders(names ...string) ClientOption {
+	names = canonicalTraceparentHeaders(names)
+	return func(rt *roundTripper) {
+		rt.traceparentHeaders = names
+	}
+}
+
+// WithClientRequestIDHeader returns a ClientOption which propagates the
+// request ID in the named HTTP header, e.g. RequestIDHeader, for services
+// which correlate requests using IDs rather than trace context. By default,
+// no request ID header is sent.
+//
+// The request ID is taken from the request context, as set by the handler
+// returned by Wrap or by ContextWithRequestID. If there is none, the trace
+// ID is used. Requests which already have the header set are sent as-is.
+// The ID is recorded in the client span's "request_id" tag.
+func WithClientRequestIDHeader(name string) ClientOption {
+	if name == "" {
+		panic("name == \"\"")
+	}
+	name = http.CanonicalHeaderKey(name)
+	return func(rt *roundTripper) {
+		rt.requestIDHeader = name
+	}
+}
+
+// WithClientSpanNameFormatter returns a ClientOption which sets f as the
+// function to use to obtain the span name for the given client request.
+// By default, ClientRequestName is used.
+//
+// This can be used to name spans using URL templates, e.g. "GET /users/{id}",
+// rather than the resolved URLs, which may be of high cardinality. The name
+// in the request context set by ContextWithClientSpanName, if any, takes
+// precedence over f.
+func WithClientSpanNameFormatter(f RequestNameFunc) ClientOption {
+	if f == nil {
+		panic("f == nil")
+	}
+	return func(rt *roundTripper) {
+		rt.requestName = f
+	}
+}
+
+type clientSpanNameKey struct{}
+
+// ContextWithClientSpanName returns a copy of parent in which the given
+// name will be used as the span name for client requests made with the
+// context, in place of the name given by the client's span name formatter.
+//
+// This is useful when the caller knows the URL template for a request:
+//
+//	ctx = apmhttp.ContextWithClientSpanName(ctx, "GET /users/{id}")
+//	req = req.WithContext(ctx)
+func ContextWithClientSpanName(parent context.Context, name string) context.Context {
+	return context.WithValue(parent, clientSpanNameKey{}, name)
+}
+
+func clientSpanNameFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(clientSpanNameKey{}).(string)
+	return name, ok && name != ""
+}
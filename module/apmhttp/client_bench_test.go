@@ -34,16 +34,21 @@ func BenchmarkClient(b *testing.B) {
 	b.Run("baseline", func(b *testing.B) {
 		benchmarkClient(b, func(c *http.Client) *http.Client {
 			return c
-		})
+		}, true)
 	})
 	b.Run("wrapped", func(b *testing.B) {
 		benchmarkClient(b, func(c *http.Client) *http.Client {
 			return apmhttp.WrapClient(c)
-		})
+		}, true)
+	})
+	b.Run("wrapped_tracer_inactive", func(b *testing.B) {
+		benchmarkClient(b, func(c *http.Client) *http.Client {
+			return apmhttp.WrapClient(c)
+		}, false)
 	})
 }
 
-func benchmarkClient(b *testing.B, wrap func(*http.Client) *http.Client) {
+func benchmarkClient(b *testing.B, wrap func(*http.Client) *http.Client, active bool) {
 	server := httptest.NewServer(testMux())
 	defer server.Close()
 	for _, path := range benchmarkPaths {
@@ -51,6 +56,9 @@ func benchmarkClient(b *testing.B, wrap func(*http.Client) *http.Client) {
 			tracer := newTracer()
 			defer tracer.Close()
 			tx := tracer.StartTransaction("name", "type")
+			if !active {
+				tracer.Close()
+			}
 			ctx := apm.ContextWithTransaction(context.Background(), tx)
 			client := wrap(nil)
 			b.ResetTimer()
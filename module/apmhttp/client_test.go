@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -88,6 +89,194 @@ func TestClient(t *testing.T) {
 	assert.Equal(t, transaction.ID, span.ParentID)
 }
 
+func TestClientParentSpan(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	// Client spans are linked to the parent span in the context,
+	// so their durations are excluded from its self-time.
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	parent, ctx := apm.StartSpan(ctx, "parent", "custom")
+	mustGET(ctx, server.URL)
+	parent.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Spans, 2)
+	assert.Equal(t, payloads.Spans[1].ID, payloads.Spans[0].ParentID)
+
+	selfTime, ok := parent.SelfTime()
+	require.True(t, ok)
+	clientDuration := time.Duration(payloads.Spans[0].Duration * float64(time.Millisecond))
+	parentDuration := time.Duration(payloads.Spans[1].Duration * float64(time.Millisecond))
+	assert.InDelta(t, parentDuration-clientDuration, selfTime, float64(time.Millisecond))
+}
+
+func TestClientErrorStatuses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/teapot":
+			w.WriteHeader(http.StatusTeapot)
+		}
+	}))
+	defer server.Close()
+
+	client := apmhttp.WrapClient(nil, apmhttp.WithClientErrorStatuses(func(statusCode int) bool {
+		return statusCode >= 400 && statusCode != http.StatusNotFound
+	}))
+	tx, spans, errors := apmtest.WithTransaction(func(ctx context.Context) {
+		for _, path := range []string{"/", "/missing", "/teapot"} {
+			resp, err := ctxhttp.Get(ctx, client, server.URL+path)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+	})
+	require.Len(t, spans, 3)
+	require.Len(t, errors, 1)
+	assert.Equal(t, tx.ID, errors[0].TransactionID)
+	assert.Equal(t, spans[2].ID, errors[0].ParentID)
+	assert.Equal(t,
+		"GET "+server.Listener.Addr().String()+"/teapot: 418 I'm a teapot",
+		errors[0].Exception.Message,
+	)
+	assert.True(t, errors[0].Exception.Handled)
+}
+
+func TestClientRequestIDHeader(t *testing.T) {
+	var requestIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestIDs = append(requestIDs, req.Header.Get("X-Request-Id"))
+	}))
+	defer server.Close()
+
+	client := apmhttp.WrapClient(nil, apmhttp.WithClientRequestIDHeader("x-request-id"))
+	tx, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		// No request ID in the context: the trace ID is used.
+		resp, err := ctxhttp.Get(ctx, client, server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		// Request ID propagated from the context.
+		resp, err = ctxhttp.Get(apmhttp.ContextWithRequestID(ctx, "req-1"), client, server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		// Request ID header set by the caller is left alone.
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set("X-Request-Id", "req-2")
+		resp, err = ctxhttp.Do(apmhttp.ContextWithRequestID(ctx, "req-1"), client, req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+	traceID := apm.TraceID(tx.TraceID).String()
+	assert.Equal(t, []string{traceID, "req-1", "req-2"}, requestIDs)
+
+	require.Len(t, spans, 3)
+	assert.Equal(t, model.StringMap{{Key: "request_id", Value: traceID}}, spans[0].Context.Tags)
+	assert.Equal(t, model.StringMap{{Key: "request_id", Value: "req-1"}}, spans[1].Context.Tags)
+	assert.Equal(t, model.StringMap{{Key: "request_id", Value: "req-2"}}, spans[2].Context.Tags)
+}
+
+func TestClientRequestIDHeaderDefault(t *testing.T) {
+	var requestID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestID = req.Header.Get("X-Request-Id")
+	}))
+	defer server.Close()
+
+	client := apmhttp.WrapClient(nil)
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		resp, err := ctxhttp.Get(apmhttp.ContextWithRequestID(ctx, "req-1"), client, server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+	assert.Equal(t, "", requestID)
+	require.Len(t, spans, 1)
+	assert.Empty(t, spans[0].Context.Tags)
+}
+
+func TestClientSpanNameFormatter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	client := apmhttp.WrapClient(nil, apmhttp.WithClientSpanNameFormatter(func(req *http.Request) string {
+		return req.Method + " " + strings.Replace(req.URL.Path, "123", "{id}", -1)
+	}))
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		resp, err := ctxhttp.Get(ctx, client, server.URL+"/users/123")
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		// The name in the context takes precedence.
+		ctx = apmhttp.ContextWithClientSpanName(ctx, "GET /users/{user}")
+		resp, err = ctxhttp.Get(ctx, client, server.URL+"/users/123")
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+	require.Len(t, spans, 2)
+	assert.Equal(t, "GET /users/{id}", spans[0].Name)
+	assert.Equal(t, "GET /users/{user}", spans[1].Name)
+}
+
+func TestClientTraceparentHeaders(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headers = req.Header
+	}))
+	defer server.Close()
+
+	client := apmhttp.WrapClient(nil, apmhttp.WithClientTraceparentHeaders(apmhttp.W3CTraceparentHeader))
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		resp, err := ctxhttp.Get(ctx, client, server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+	require.Len(t, spans, 1)
+
+	assert.NotContains(t, headers, apmhttp.TraceparentHeader)
+	traceContext, err := apmhttp.ParseTraceparentHeader(headers.Get("Traceparent"))
+	require.NoError(t, err)
+	assert.Equal(t, spans[0].TraceID, model.TraceID(traceContext.Trace))
+	assert.Equal(t, spans[0].ID, model.SpanID(traceContext.Span))
+}
+
+func TestClientTracestateHeader(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headers = req.Header
+	}))
+	defer server.Close()
+
+	client := apmhttp.WrapClient(nil)
+	apmtest.WithTransaction(func(ctx context.Context) {
+		resp, err := ctxhttp.Get(ctx, client, server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+	assert.NotContains(t, headers, apmhttp.W3CTracestateHeader)
+
+	apmtest.WithTransaction(func(ctx context.Context) {
+		tx := apm.TransactionFromContext(ctx)
+		state, err := tx.TraceContext().State.Set("acme", "1")
+		require.NoError(t, err)
+		tx.SetTraceState(state)
+
+		resp, err := ctxhttp.Get(ctx, client, server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+	assert.Equal(t, "acme=1", headers.Get(apmhttp.W3CTracestateHeader))
+}
+
 func TestClientSpanDropped(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		w.Write([]byte(req.Header.Get("Elastic-Apm-Traceparent")))
@@ -152,6 +341,36 @@ func TestClientTransactionUnsampled(t *testing.T) {
 	assert.Equal(t, transaction.ID, model.SpanID(clientTraceContext.Span))
 }
 
+func TestClientTracerInactive(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	tx := tracer.StartTransaction("name", "type")
+	defer tx.End()
+	tracer.Close()
+
+	resp := &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}
+	var sent *http.Request
+	client := apmhttp.WrapRoundTripper(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = req
+		return resp, nil
+	}))
+	req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+	req = req.WithContext(apm.ContextWithTransaction(context.Background(), tx))
+
+	received, err := client.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, req, sent)
+	assert.Equal(t, resp, received)
+	assert.Empty(t, req.Header)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		client.RoundTrip(req)
+	})
+	assert.Zero(t, allocs)
+
+	tracer.Flush(nil)
+	assert.Empty(t, transport.Payloads())
+}
+
 func TestClientError(t *testing.T) {
 	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
 		client := apmhttp.WrapClient(http.DefaultClient)
@@ -240,3 +459,9 @@ type cancelRequester struct {
 func (r *cancelRequester) CancelRequest(req *http.Request) {
 	r.cancelRequest(req)
 }
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,212 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.elastic.co/apm"
+)
+
+const (
+	// maxConcurrencyDestinations is the maximum number of destinations
+	// tracked by a ConcurrencyTracker. Requests to further destinations
+	// are tracked under otherConcurrencyDestination.
+	maxConcurrencyDestinations = 1000
+
+	otherConcurrencyDestination = "_other"
+)
+
+// ConcurrencyTracker tracks the number of in-flight outgoing requests
+// for each destination host and port. This helps to identify saturation
+// of the connection pool for specific backends, which is hidden by span
+// durations when requests are queued before they are sent.
+//
+// A request is in flight from the time it is passed to the round tripper
+// until its response body is closed or fully read, or the request fails.
+// All requests are tracked, whether or not they are traced.
+//
+// ConcurrencyTracker is an apm.MetricsGatherer, reporting the metrics
+// "http.client.concurrency.max" and "http.client.concurrency.mean": the
+// maximum and time-weighted mean number of in-flight requests since the
+// metrics were last gathered, labeled with the destination. At most 1000
+// destinations are tracked; requests to further destinations are reported
+// with the destination "_other". The ConcurrencyTracker must be registered
+// with a tracer using apm.Tracer.RegisterMetricsGatherer for the metrics
+// to be reported.
+type ConcurrencyTracker struct {
+	mu           sync.Mutex
+	windowStart  time.Time
+	destinations map[string]*destinationConcurrency
+}
+
+type destinationConcurrency struct {
+	inFlight   int
+	max        int
+	lastChange time.Time
+	area       float64 // in-flight request seconds since windowStart
+}
+
+// update records the time elapsed since the last change in the number
+// of in-flight requests, and adds delta to the number.
+func (d *destinationConcurrency) update(now time.Time, windowStart time.Time, delta int) {
+	since := d.lastChange
+	if since.Before(windowStart) {
+		since = windowStart
+	}
+	d.area += float64(d.inFlight) * now.Sub(since).Seconds()
+	d.lastChange = now
+	d.inFlight += delta
+	if d.inFlight > d.max {
+		d.max = d.inFlight
+	}
+}
+
+// NewConcurrencyTracker returns a new ConcurrencyTracker.
+func NewConcurrencyTracker() *ConcurrencyTracker {
+	return &ConcurrencyTracker{
+		windowStart:  time.Now(),
+		destinations: make(map[string]*destinationConcurrency),
+	}
+}
+
+// Track records the start of a request to u's host and port, returning
+// a function that must be called exactly once when the request ends.
+func (t *ConcurrencyTracker) Track(u *url.URL) (done func()) {
+	key := concurrencyDestination(u)
+	now := time.Now()
+	t.mu.Lock()
+	d := t.destinations[key]
+	if d == nil {
+		if len(t.destinations) >= maxConcurrencyDestinations {
+			key = otherConcurrencyDestination
+			d = t.destinations[key]
+		}
+		if d == nil {
+			d = &destinationConcurrency{}
+			t.destinations[key] = d
+		}
+	}
+	d.update(now, t.windowStart, 1)
+	t.mu.Unlock()
+
+	var ended int32
+	return func() {
+		if !atomic.CompareAndSwapInt32(&ended, 0, 1) {
+			return
+		}
+		now := time.Now()
+		t.mu.Lock()
+		d.update(now, t.windowStart, -1)
+		t.mu.Unlock()
+	}
+}
+
+// GatherMetrics gathers concurrency metrics into m.
+func (t *ConcurrencyTracker) GatherMetrics(ctx context.Context, m *apm.Metrics) error {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	window := now.Sub(t.windowStart).Seconds()
+	for key, d := range t.destinations {
+		if d.inFlight == 0 && d.max == 0 {
+			// No requests to the destination since
+			// the metrics were last gathered.
+			delete(t.destinations, key)
+			continue
+		}
+		d.update(now, t.windowStart, 0)
+		labels := []apm.MetricLabel{{Name: "destination", Value: key}}
+		m.Add("http.client.concurrency.max", labels, float64(d.max))
+		if window > 0 {
+			m.Add("http.client.concurrency.mean", labels, d.area/window)
+		}
+		d.max = d.inFlight
+		d.area = 0
+	}
+	t.windowStart = now
+	return nil
+}
+
+// concurrencyDestination returns the host and port of u, using the
+// default port for the scheme if none is specified.
+func concurrencyDestination(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// WithClientConcurrencyTracker returns a ClientOption which tracks the
+// number of in-flight requests for each destination with t.
+func WithClientConcurrencyTracker(t *ConcurrencyTracker) ClientOption {
+	if t == nil {
+		panic("t == nil")
+	}
+	return func(rt *roundTripper) {
+		rt.concurrency = t
+	}
+}
+
+// TrackConcurrency calls r.RoundTrip, tracking the request with t
+// until the response body is closed or fully read, or RoundTrip
+// returns an error. It is intended for use by instrumentation modules
+// that wrap http.RoundTripper, such as module/apmelasticsearch.
+func TrackConcurrency(t *ConcurrencyTracker, r http.RoundTripper, req *http.Request) (*http.Response, error) {
+	done := t.Track(req.URL)
+	resp, err := r.RoundTrip(req)
+	if err != nil {
+		done()
+		return resp, err
+	}
+	resp.Body = &concurrencyBody{body: resp.Body, done: done}
+	return resp, nil
+}
+
+// concurrencyBody wraps a response body, calling done
+// when the body is closed or fully read.
+type concurrencyBody struct {
+	body io.ReadCloser
+	done func()
+}
+
+func (b *concurrencyBody) Close() error {
+	b.done()
+	return b.body.Close()
+}
+
+func (b *concurrencyBody) Read(p []byte) (n int, err error) {
+	n, err = b.body.Read(p)
+	if err == io.EOF {
+		b.done()
+	}
+	return n, err
+}
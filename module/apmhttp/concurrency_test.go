@@ -0,0 +1,111 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmhttp"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestClientConcurrencyTracker(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tracker := apmhttp.NewConcurrencyTracker()
+	tracer.RegisterMetricsGatherer(tracker)
+
+	const N = 3
+	var received sync.WaitGroup
+	received.Add(N)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received.Done()
+		<-release
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	// Requests are tracked whether or not they are traced.
+	client := apmhttp.WrapClient(nil, apmhttp.WithClientConcurrencyTracker(tracker))
+	var done sync.WaitGroup
+	for i := 0; i < N; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			resp, err := client.Get(server.URL)
+			if !assert.NoError(t, err) {
+				return
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	received.Wait()
+	close(release)
+	done.Wait()
+
+	tracer.SendMetrics(nil)
+	metrics := destinationMetrics(transport.Payloads().Metrics, serverURL.Host)
+	require.NotNil(t, metrics)
+	assert.Equal(t, float64(N), metrics["http.client.concurrency.max"].Value)
+	mean := metrics["http.client.concurrency.mean"].Value
+	assert.True(t, mean > 0 && mean <= N, "mean: %v", mean)
+
+	// The destination is idle, so it's not reported again.
+	transport.ResetPayloads()
+	tracer.SendMetrics(nil)
+	assert.Nil(t, destinationMetrics(transport.Payloads().Metrics, serverURL.Host))
+}
+
+func TestConcurrencyTrackerDefaultPort(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tracker := apmhttp.NewConcurrencyTracker()
+	tracer.RegisterMetricsGatherer(tracker)
+	done := tracker.Track(&url.URL{Scheme: "https", Host: "example.com"})
+	tracker.Track(&url.URL{Scheme: "https", Host: "example.com:443"})
+	done()
+	done() // no-op
+
+	tracer.SendMetrics(nil)
+	metrics := destinationMetrics(transport.Payloads().Metrics, "example.com:443")
+	require.NotNil(t, metrics)
+	assert.Equal(t, float64(2), metrics["http.client.concurrency.max"].Value)
+}
+
+func destinationMetrics(metrics []model.Metrics, destination string) map[string]model.Metric {
+	for _, m := range metrics {
+		if len(m.Labels) == 1 && m.Labels[0].Key == "destination" && m.Labels[0].Value == destination {
+			return m.Samples
+		}
+	}
+	return nil
+}
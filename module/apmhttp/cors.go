@@ -0,0 +1,103 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	corsRequestHeadersHeader = "Access-Control-Request-Headers"
+	corsAllowHeadersHeader   = "Access-Control-Allow-Headers"
+	corsAllowOriginHeader    = "Access-Control-Allow-Origin"
+)
+
+// corsTraceHeaders holds the canonical names of the distributed
+// tracing headers which may be sent by RUM agents.
+var corsTraceHeaders = []string{
+	TraceparentHeader,
+	W3CTraceparentHeader,
+	W3CTracestateHeader,
+}
+
+// StripCORSTraceHeaders removes the distributed tracing headers sent by
+// RUM agents (Elastic-Apm-Traceparent, Traceparent, and Tracestate) from
+// the Access-Control-Request-Headers header of req, if req is a CORS
+// preflight request, and returns the names of the removed headers.
+//
+// StripCORSTraceHeaders is intended to be used by middleware in
+// conjunction with AllowCORSTraceHeaders, so that the tracing headers
+// are allowed without requiring them to be added to the configuration
+// of the application's CORS middleware.
+func StripCORSTraceHeaders(req *http.Request) []string {
+	if req.Method != http.MethodOptions || req.Header.Get("Origin") == "" {
+		return nil
+	}
+	values, ok := req.Header[corsRequestHeadersHeader]
+	if !ok {
+		return nil
+	}
+	var stripped, remaining []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if isCORSTraceHeader(name) {
+				stripped = append(stripped, name)
+			} else {
+				remaining = append(remaining, name)
+			}
+		}
+	}
+	if len(stripped) == 0 {
+		return nil
+	}
+	if len(remaining) == 0 {
+		req.Header.Del(corsRequestHeadersHeader)
+	} else {
+		req.Header.Set(corsRequestHeadersHeader, strings.Join(remaining, ", "))
+	}
+	return stripped
+}
+
+// AllowCORSTraceHeaders adds names to the Access-Control-Allow-Headers
+// header in h, if h has an Access-Control-Allow-Origin header, i.e. the
+// CORS preflight request has been allowed.
+func AllowCORSTraceHeaders(h http.Header, names []string) {
+	if len(names) == 0 || h.Get(corsAllowOriginHeader) == "" {
+		return
+	}
+	if allowed := h.Get(corsAllowHeadersHeader); allowed != "" {
+		h.Set(corsAllowHeadersHeader, allowed+", "+strings.Join(names, ", "))
+	} else {
+		h.Set(corsAllowHeadersHeader, strings.Join(names, ", "))
+	}
+}
+
+func isCORSTraceHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, traceHeader := range corsTraceHeaders {
+		if name == traceHeader {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.elastic.co/apm/module/apmhttp"
+)
+
+func TestStripCORSTraceHeaders(t *testing.T) {
+	req, _ := http.NewRequest("OPTIONS", "http://server.testing/", nil)
+	req.Header.Set("Origin", "http://client.testing")
+	req.Header.Set("Access-Control-Request-Headers", "traceparent, x-custom, tracestate")
+	assert.Equal(t, []string{"traceparent", "tracestate"}, apmhttp.StripCORSTraceHeaders(req))
+	assert.Equal(t, "x-custom", req.Header.Get("Access-Control-Request-Headers"))
+
+	req.Header.Set("Access-Control-Request-Headers", "Elastic-Apm-Traceparent")
+	assert.Equal(t, []string{"Elastic-Apm-Traceparent"}, apmhttp.StripCORSTraceHeaders(req))
+	assert.NotContains(t, req.Header, "Access-Control-Request-Headers")
+
+	// Not a CORS preflight request.
+	req.Header.Del("Origin")
+	req.Header.Set("Access-Control-Request-Headers", "traceparent")
+	assert.Nil(t, apmhttp.StripCORSTraceHeaders(req))
+	assert.Equal(t, "traceparent", req.Header.Get("Access-Control-Request-Headers"))
+}
+
+func TestAllowCORSTraceHeaders(t *testing.T) {
+	h := make(http.Header)
+	apmhttp.AllowCORSTraceHeaders(h, []string{"traceparent"})
+	assert.Empty(t, h) // not allowed by the CORS middleware
+
+	h.Set("Access-Control-Allow-Origin", "*")
+	apmhttp.AllowCORSTraceHeaders(h, []string{"traceparent"})
+	assert.Equal(t, "traceparent", h.Get("Access-Control-Allow-Headers"))
+
+	h.Set("Access-Control-Allow-Headers", "X-Custom")
+	apmhttp.AllowCORSTraceHeaders(h, []string{"traceparent", "tracestate"})
+	assert.Equal(t, "X-Custom, traceparent, tracestate", h.Get("Access-Control-Allow-Headers"))
+}
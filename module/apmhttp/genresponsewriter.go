@@ -0,0 +1,97 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build ignore
+
+// genresponsewriter generates responsewriter_generated.go, which defines
+// wrapResponseWriter, returning a value implementing each combination of
+// the optional http.ResponseWriter interfaces.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// optionalInterfaces lists the optional interfaces, along with the
+// name of the type implementing each in responsewriter.go.
+var optionalInterfaces = []struct {
+	iface    string
+	implType string
+}{
+	{"http.Flusher", "responseWriterFlusher"},
+	{"http.Hijacker", "responseWriterHijacker"},
+	{"http.Pusher", "responseWriterPusher"},
+	{"http.CloseNotifier", "responseWriterCloseNotifier"},
+	{"io.ReaderFrom", "responseWriterReaderFrom"},
+}
+
+func main() {
+	license, err := ioutil.ReadFile("responsewriter.go")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var buf bytes.Buffer
+	buf.Write(license[:bytes.Index(license, []byte("\npackage"))])
+	buf.WriteString(`
+// Code generated by "go generate". DO NOT EDIT.
+
+package apmhttp
+
+import (
+	"io"
+	"net/http"
+)
+
+// wrapResponseWriter returns rw, combined with implementations of the
+// optional interfaces implemented by rw.ResponseWriter.
+func wrapResponseWriter(rw *responseWriter) http.ResponseWriter {
+	var mask int
+`)
+	for i, iface := range optionalInterfaces {
+		fmt.Fprintf(&buf, "if _, ok := rw.ResponseWriter.(%s); ok {\nmask |= %d\n}\n", iface.iface, 1<<uint(i))
+	}
+	buf.WriteString("switch mask {\n")
+	for mask := 1; mask < 1<<uint(len(optionalInterfaces)); mask++ {
+		var fields, values, names []string
+		for i, iface := range optionalInterfaces {
+			if mask&(1<<uint(i)) == 0 {
+				continue
+			}
+			names = append(names, iface.iface)
+			fields = append(fields, iface.implType)
+			values = append(values, iface.implType+"{rw}")
+		}
+		fmt.Fprintf(&buf, "case %d: // %s\n", mask, strings.Join(names, ", "))
+		fmt.Fprintf(&buf, "return struct {\n*responseWriter\n%s\n}{rw, %s}\n",
+			strings.Join(fields, "\n"), strings.Join(values, ", "),
+		)
+	}
+	buf.WriteString("}\nreturn rw\n}\n")
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("responsewriter_generated.go", source, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
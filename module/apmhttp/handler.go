@@ -19,7 +19,10 @@ package apmhttp
 
 import (
 	"context"
+	"crypto/subtle"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"go.elastic.co/apm"
 )
@@ -33,6 +36,9 @@ import (
 // By default, the returned Handler will recover panics, reporting
 // them to the configured tracer. To override this behaviour, use
 // WithRecovery.
+//
+// If the tracer is inactive or closed, requests are passed directly to h,
+// without starting a transaction or wrapping the http.ResponseWriter.
 func Wrap(h http.Handler, o ...ServerOption) http.Handler {
 	if h == nil {
 		panic("h == nil")
@@ -42,7 +48,11 @@ func Wrap(h http.Handler, o ...ServerOption) http.Handler {
 		tracer:         apm.DefaultTracer,
 		requestName:    ServerRequestName,
 		requestIgnorer: DefaultServerRequestIgnorer(),
+
+		traceparentHeaders: defaultTraceparentHeaders,
+		requestIDHeaders:   defaultRequestIDHeaders,
 	}
+	handler.healthCheck, handler.healthCheckSampler = defaultHealthCheck()
 	for _, o := range o {
 		o(handler)
 	}
@@ -61,28 +71,68 @@ type handler struct {
 	recovery       RecoveryFunc
 	requestName    RequestNameFunc
 	requestIgnorer RequestIgnorerFunc
+	rateLimiter    *ClientRateLimiter
+
+	healthCheck        HealthCheckFunc
+	healthCheckSampler apm.Sampler
+
+	responseSizeTags   bool
+	protocolTags       bool
+	serverTimingHeader bool
+
+	forceSampleHeader string
+	forceSampleSecret string
+
+	traceparentHeaders []string
+	requestIDHeaders   []string
 }
 
 // ServeHTTP delegates to h.Handler, tracing the transaction with
 // h.Tracer, or apm.DefaultTracer if h.Tracer is nil.
 func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if !h.tracer.Active() || h.requestIgnorer(req) {
+	if !h.tracer.Active() || h.requestIgnorer(req) || (h.rateLimiter != nil && !h.rateLimiter.allow(req)) {
 		h.handler.ServeHTTP(w, req)
 		return
 	}
-	tx, req := StartTransaction(h.tracer, h.requestName(req), req)
+	txType := "request"
+	opts := apm.TransactionOptions{ForceSample: h.forceSample(req)}
+	if h.healthCheck != nil && h.healthCheck(req) {
+		txType = HealthCheckTransactionType
+		opts.Sampler = h.healthCheckSampler
+	}
+	tx, req := startTransaction(h.tracer, h.requestName(req), txType, req, h.traceparentHeaders, opts)
 	defer tx.End()
+	if id := requestID(req.Header, h.requestIDHeaders); id != "" {
+		req = RequestWithContext(ContextWithRequestID(req.Context(), id), req)
+		if tx.Sampled() {
+			tx.Context.SetTag(requestIDTag, id)
+		}
+	}
+	if h.serverTimingHeader {
+		SetServerTimingHeader(w.Header(), tx.TraceContext())
+	}
 
 	body := h.tracer.CaptureHTTPRequestBody(req)
 	w, resp := WrapResponseWriter(w)
 	defer func() {
 		if v := recover(); v != nil {
-			if resp.StatusCode == 0 {
+			if resp.StatusCode == 0 && !resp.Hijacked {
 				w.WriteHeader(http.StatusInternalServerError)
 			}
 			h.recovery(w, req, resp, body, tx, v)
 		}
 		SetTransactionContext(tx, req, resp, body)
+		if resp.Hijacked && tx.Sampled() {
+			// The connection was taken over by the handler, e.g. for
+			// websockets, so the response details may be incomplete.
+			tx.Context.SetTag("http_hijacked", "true")
+		}
+		if h.responseSizeTags && tx.Sampled() {
+			SetResponseSizeTags(&tx.Context, resp.BodySize, resp.Headers)
+		}
+		if h.protocolTags && tx.Sampled() {
+			SetProtocolTags(&tx.Context, req, resp.Headers)
+		}
 	}()
 	h.handler.ServeHTTP(w, req)
 	if resp.StatusCode == 0 {
@@ -96,18 +146,49 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 // If the transaction is not ignored, the request will be
 // returned with the transaction added to its context.
 func StartTransaction(tracer *apm.Tracer, name string, req *http.Request) (*apm.Transaction, *http.Request) {
-	var opts apm.TransactionOptions
-	if values := req.Header[TraceparentHeader]; len(values) == 1 && values[0] != "" {
-		if c, err := ParseTraceparentHeader(values[0]); err == nil {
-			opts.TraceContext = c
+	return startTransaction(tracer, name, "request", req, defaultTraceparentHeaders, apm.TransactionOptions{})
+}
+
+func startTransaction(tracer *apm.Tracer, name, txType string, req *http.Request, traceparentHeaders []string, opts apm.TransactionOptions) (*apm.Transaction, *http.Request) {
+	for _, header := range traceparentHeaders {
+		if values := req.Header[header]; len(values) == 1 && values[0] != "" {
+			if c, err := ParseTraceparentHeader(values[0]); err == nil {
+				if state, err := ParseTracestateHeader(req.Header[W3CTracestateHeader]...); err == nil {
+					c.State = state
+				}
+				opts.TraceContext = c
+				break
+			}
 		}
 	}
-	tx := tracer.StartTransactionOptions(name, "request", opts)
+	if values := req.Header[W3CBaggageHeader]; len(values) != 0 {
+		if baggage, err := ParseBaggageHeader(values...); err == nil {
+			opts.Baggage = baggage
+		}
+	}
+	tx := tracer.StartTransactionOptions(name, txType, opts)
 	ctx := apm.ContextWithTransaction(req.Context(), tx)
 	req = RequestWithContext(ctx, req)
 	return tx, req
 }
 
+// forceSample reports whether req should be sampled regardless of the
+// tracer's sampling decision, due to the request carrying the configured
+// force-sample header with the shared secret value. The header is removed
+// from the request, so that the secret is neither recorded in the
+// transaction context nor seen by the wrapped handler.
+func (h *handler) forceSample(req *http.Request) bool {
+	if h.forceSampleHeader == "" {
+		return false
+	}
+	values, ok := req.Header[h.forceSampleHeader]
+	if !ok {
+		return false
+	}
+	req.Header.Del(h.forceSampleHeader)
+	return len(values) == 1 && subtle.ConstantTimeCompare([]byte(values[0]), []byte(h.forceSampleSecret)) == 1
+}
+
 // SetTransactionContext sets tx.Result and, if the transaction is being
 // sampled, sets tx.Context with information from req, resp, and body.
 func SetTransactionContext(tx *apm.Transaction, req *http.Request, resp *Response, body *apm.BodyCapturer) {
@@ -127,111 +208,45 @@ func SetContext(ctx *apm.Context, req *http.Request, resp *Response, body *apm.B
 	ctx.SetHTTPResponseHeaders(resp.Headers)
 }
 
-// WrapResponseWriter wraps an http.ResponseWriter and returns the wrapped
-// value along with a *Response which will be filled in when the handler
-// is called. The *Response value must not be inspected until after the
-// request has been handled, to avoid data races. If neither of the
-// ResponseWriter's Write or WriteHeader methods are called, then the
-// response's StatusCode field will be zero.
-//
-// The returned http.ResponseWriter implements http.Pusher and http.Hijacker
-// if and only if the provided http.ResponseWriter does.
-func WrapResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *Response) {
-	rw := responseWriter{
-		ResponseWriter: w,
-		resp: Response{
-			Headers: w.Header(),
-		},
-	}
-	h, _ := w.(http.Hijacker)
-	p, _ := w.(http.Pusher)
-	switch {
-	case h != nil && p != nil:
-		rwhp := &responseWriterHijackerPusher{
-			responseWriter: rw,
-			Hijacker:       h,
-			Pusher:         p,
-		}
-		return rwhp, &rwhp.resp
-	case h != nil:
-		rwh := &responseWriterHijacker{
-			responseWriter: rw,
-			Hijacker:       h,
-		}
-		return rwh, &rwh.resp
-	case p != nil:
-		rwp := &responseWriterPusher{
-			responseWriter: rw,
-			Pusher:         p,
-		}
-		return rwp, &rwp.resp
+// SetResponseSizeTags sets tags in ctx recording the number of response
+// body bytes written, and the response content encoding if any. The content
+// encoding is taken from the Content-Encoding header in h, which is typically
+// set by compression middleware.
+func SetResponseSizeTags(ctx *apm.Context, size int64, h http.Header) {
+	ctx.SetTag("response_body_size", strconv.FormatInt(size, 10))
+	if encoding := h.Get("Content-Encoding"); encoding != "" {
+		ctx.SetTag("response_content_encoding", encoding)
 	}
-	return &rw, &rw.resp
-}
-
-// Response records details of the HTTP response.
-type Response struct {
-	// StatusCode records the HTTP status code set via WriteHeader.
-	StatusCode int
-
-	// Headers holds the headers set in the ResponseWriter.
-	Headers http.Header
-}
-
-type responseWriter struct {
-	http.ResponseWriter
-	resp Response
 }
 
-// WriteHeader sets w.resp.StatusCode and calls through to the embedded
-// ResponseWriter.
-func (w *responseWriter) WriteHeader(statusCode int) {
-	w.ResponseWriter.WriteHeader(statusCode)
-	w.resp.StatusCode = statusCode
-}
-
-// Write calls through to the embedded ResponseWriter, setting
-// w.resp.StatusCode to http.StatusOK if WriteHeader has not already
-// been called.
-func (w *responseWriter) Write(data []byte) (int, error) {
-	n, err := w.ResponseWriter.Write(data)
-	if w.resp.StatusCode == 0 {
-		w.resp.StatusCode = http.StatusOK
+// SetProtocolTags sets tags in ctx recording the protocol negotiated for
+// req: "http/1.0", "http/1.1", "h2" for HTTP/2 over TLS, or "h2c" for
+// cleartext HTTP/2.
+//
+// gRPC-Web and other streaming protocols report their status in trailers,
+// after the HTTP status code has been sent, so if the response headers or
+// trailers in h include Grpc-Status then it is recorded as well. Trailers
+// are taken from h using both the names declared in the response's Trailer
+// header and the http.TrailerPrefix convention.
+func SetProtocolTags(ctx *apm.Context, req *http.Request, h http.Header) {
+	ctx.SetTag("http_protocol", requestProtocol(req))
+	if status := h.Get(grpcStatusHeader); status != "" {
+		ctx.SetTag("grpc_status", status)
+	} else if status := h.Get(http.TrailerPrefix + grpcStatusHeader); status != "" {
+		ctx.SetTag("grpc_status", status)
 	}
-	return n, err
 }
 
-// CloseNotify returns w.closeNotify() if w.closeNotify is non-nil,
-// otherwise it returns nil.
-func (w *responseWriter) CloseNotify() <-chan bool {
-	if closeNotifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
-		return closeNotifier.CloseNotify()
-	}
-	return nil
-}
+const grpcStatusHeader = "Grpc-Status"
 
-// Flush calls w.flush() if w.flush is non-nil, otherwise
-// it does nothing.
-func (w *responseWriter) Flush() {
-	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
-		flusher.Flush()
+func requestProtocol(req *http.Request) string {
+	if req.ProtoMajor == 2 {
+		if req.TLS != nil {
+			return "h2"
+		}
+		return "h2c"
 	}
-}
-
-type responseWriterHijacker struct {
-	responseWriter
-	http.Hijacker
-}
-
-type responseWriterPusher struct {
-	responseWriter
-	http.Pusher
-}
-
-type responseWriterHijackerPusher struct {
-	responseWriter
-	http.Hijacker
-	http.Pusher
+	return fmt.Sprintf("http/%d.%d", req.ProtoMajor, req.ProtoMinor)
 }
 
 // ServerOption sets options for tracing server requests.
@@ -290,6 +305,113 @@ func WithServerRequestIgnorer(r RequestIgnorerFunc) ServerOption {
 	}
 }
 
+// WithClientRateLimiter returns a ServerOption which limits the rate at
+// which transactions are created for requests from each client, using l.
+// Requests exceeding the limit are handled without being traced. The same
+// ClientRateLimiter may be shared by multiple handlers, applying a single
+// limit across them.
+func WithClientRateLimiter(l *ClientRateLimiter) ServerOption {
+	if l == nil {
+		panic("l == nil")
+	}
+	return func(h *handler) {
+		h.rateLimiter = l
+	}
+}
+
+// WithResponseSizeTags returns a ServerOption which enables recording
+// the response body size and content encoding as transaction tags.
+// See SetResponseSizeTags for details.
+func WithResponseSizeTags() ServerOption {
+	return func(h *handler) {
+		h.responseSizeTags = true
+	}
+}
+
+// WithProtocolTags returns a ServerOption which enables recording the
+// negotiated HTTP protocol, and any gRPC status sent in the response
+// trailers, as transaction tags. See SetProtocolTags for details.
+func WithProtocolTags() ServerOption {
+	return func(h *handler) {
+		h.protocolTags = true
+	}
+}
+
+// WithServerTimingHeader returns a ServerOption which enables adding
+// the transaction's trace context to responses in a Server-Timing
+// header, so that browsers and RUM agents can correlate them with the
+// backend trace. See SetServerTimingHeader for details.
+//
+// Browsers only expose Server-Timing to scripts for cross-origin
+// requests if the response includes a Timing-Allow-Origin header.
+func WithServerTimingHeader() ServerOption {
+	return func(h *handler) {
+		h.serverTimingHeader = true
+	}
+}
+
+// WithForceSampleHeader returns a ServerOption which enables forced
+// sampling of requests carrying the HTTP header with the given name
+// and a value equal to secret, regardless of the tracer's sampler or
+// the sampling decision in the Traceparent header. This is intended
+// for capturing traces of specific requests while debugging.
+//
+// The secret guards against clients forcing the sampling of many
+// requests, and must not be empty. The header is removed from
+// requests before they are passed to the wrapped handler.
+func WithForceSampleHeader(name, secret string) ServerOption {
+	if name == "" {
+		panic("name == \"\"")
+	}
+	if secret == "" {
+		panic("secret == \"\"")
+	}
+	name = http.CanonicalHeaderKey(name)
+	return func(h *handler) {
+		h.forceSampleHeader = name
+		h.forceSampleSecret = secret
+	}
+}
+
+// WithServerTraceparentHeaders returns a ServerOption which sets the HTTP
+// headers from which the trace context is extracted, in order of precedence.
+// The first header found with a valid traceparent value is used. By default,
+// only TraceparentHeader is consulted.
+//
+// For example, to prefer the W3C Trace-Context header, falling back to the
+// Elastic APM header for services which have not yet been upgraded:
+//
+//	apmhttp.WithServerTraceparentHeaders(apmhttp.W3CTraceparentHeader, apmhttp.TraceparentHeader)
+func WithServerTraceparentHeaders(names ...string) ServerOption {
+	names = canonicalTraceparentHeaders(names)
+	return func(h *handler) {
+		h.traceparentHeaders = names
+	}
+}
+
+// WithServerRequestIDHeaders returns a ServerOption which sets the HTTP
+// headers from which the request ID is taken, in order of precedence.
+// By default, RequestIDHeader and CorrelationIDHeader are consulted.
+// Passing no names disables request ID capture.
+//
+// The first non-empty header value is recorded in the transaction's
+// "request_id" tag, bridging trace IDs
+// with logs and tooling keyed on correlation IDs. The ID is also stored
+// in the request context, for propagation by client requests made with
+// WithClientRequestIDHeader.
+func WithServerRequestIDHeaders(names ...string) ServerOption {
+	canonical := make([]string, len(names))
+	for i, name := range names {
+		if name == "" {
+			panic("name == \"\"")
+		}
+		canonical[i] = http.CanonicalHeaderKey(name)
+	}
+	return func(h *handler) {
+		h.requestIDHeaders = canonical
+	}
+}
+
 // RequestWithContext is equivalent to req.WithContext, except that the URL
 // pointer is copied, rather than the contents.
 func RequestWithContext(ctx context.Context, req *http.Request) *http.Request {
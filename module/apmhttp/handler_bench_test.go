@@ -54,6 +54,19 @@ func BenchmarkHandlerWithMiddleware(b *testing.B) {
 	}
 }
 
+func BenchmarkHandlerWithMiddlewareTracerInactive(b *testing.B) {
+	tracer := newTracer()
+	tracer.Close()
+	wrapHandler := func(in http.Handler) http.Handler {
+		return apmhttp.Wrap(in, apmhttp.WithTracer(tracer))
+	}
+	for _, path := range benchmarkPaths {
+		b.Run(path, func(b *testing.B) {
+			benchmarkHandler(b, path, wrapHandler)
+		})
+	}
+}
+
 func benchmarkHandler(b *testing.B, path string, wrapHandler func(http.Handler) http.Handler) {
 	w := httptest.NewRecorder()
 	h := testMux()
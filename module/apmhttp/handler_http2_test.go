@@ -101,3 +101,35 @@ func TestHandlerHTTP2(t *testing.T) {
 		},
 	}, transaction.Context)
 }
+
+func TestHandlerHTTP2ProtocolTags(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	srv := httptest.NewUnstartedServer(apmhttp.Wrap(handler,
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithProtocolTags(),
+	))
+	err := http2.ConfigureServer(srv.Config, nil)
+	require.NoError(t, err)
+	srv.TLS = srv.Config.TLSConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http2.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}}
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "http_protocol", Value: "h2"},
+	}, payloads.Transactions[0].Context.Tags)
+}
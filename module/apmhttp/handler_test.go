@@ -18,6 +18,7 @@
 package apmhttp_test
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -255,6 +256,34 @@ func TestHandlerRequestIgnorer(t *testing.T) {
 	assert.Empty(t, transport.Payloads())
 }
 
+func TestHandlerTracerInactive(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	tracer.Close()
+
+	w := httptest.NewRecorder()
+	var handlerW http.ResponseWriter
+	var handlerTx *apm.Transaction
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			handlerW = w
+			handlerTx = apm.TransactionFromContext(req.Context())
+		}),
+		apmhttp.WithTracer(tracer),
+	)
+	req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.ResponseWriter(w), handlerW)
+	assert.Nil(t, handlerTx)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		h.ServeHTTP(w, req)
+	})
+	assert.Zero(t, allocs)
+
+	tracer.Flush(nil)
+	assert.Empty(t, transport.Payloads())
+}
+
 func TestHandlerTraceparentHeader(t *testing.T) {
 	tracer, transport := transporttest.NewRecorderTracer()
 	defer tracer.Close()
@@ -284,6 +313,263 @@ func TestHandlerTraceparentHeader(t *testing.T) {
 	assert.Equal(t, "HTTP 4xx", transaction.Result)
 }
 
+func TestHandlerTracestateHeader(t *testing.T) {
+	var state apm.TraceState
+	h := apmhttp.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		state = apm.TransactionFromContext(req.Context()).TraceContext().State
+	}), apmhttp.WithTracer(apmtest.DiscardTracer))
+
+	req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+	req.Header.Set("Elastic-Apm-Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	req.Header.Set("Tracestate", "acme=1,es=s:1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "acme=1,es=s:1", state.String())
+
+	// Tracestate is ignored if it is invalid.
+	req.Header.Set("Tracestate", "acme=1,acme=2")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Zero(t, state)
+
+	// Tracestate is ignored without a valid traceparent.
+	req.Header.Del("Elastic-Apm-Traceparent")
+	req.Header.Set("Tracestate", "acme=1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Zero(t, state)
+}
+
+func TestHandlerBaggageHeader(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetBaggageToAttach("tenant")
+
+	h := apmhttp.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}), apmhttp.WithTracer(tracer))
+	req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+	req.Header.Set("Baggage", "tenant=acme,region=eu")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Baggage is ignored if it is invalid.
+	req.Header.Set("Baggage", "tenant=acme,region")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, model.StringMap{{Key: "baggage_tenant", Value: "acme"}}, payloads.Transactions[0].Context.Tags)
+	assert.Empty(t, payloads.Transactions[1].Context.Tags)
+}
+
+func TestHandlerRequestIDHeaders(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var contextIDs []string
+	h := apmhttp.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id, _ := apmhttp.RequestIDFromContext(req.Context())
+		contextIDs = append(contextIDs, id)
+	}), apmhttp.WithTracer(tracer))
+	for _, headers := range []map[string]string{
+		{"X-Request-ID": "req-1", "X-Correlation-ID": "corr-1"},
+		{"X-Correlation-ID": "corr-2"},
+		{},
+	} {
+		req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	tracer.Flush(nil)
+	assert.Equal(t, []string{"req-1", "corr-2", ""}, contextIDs)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 3)
+	assert.Equal(t, model.StringMap{{Key: "request_id", Value: "req-1"}}, payloads.Transactions[0].Context.Tags)
+	assert.Equal(t, model.StringMap{{Key: "request_id", Value: "corr-2"}}, payloads.Transactions[1].Context.Tags)
+	assert.Empty(t, payloads.Transactions[2].Context.Tags)
+}
+
+func TestHandlerRequestIDHeadersDisabled(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithServerRequestIDHeaders(),
+	)
+	req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Empty(t, payloads.Transactions[0].Context.Tags)
+}
+
+func TestHandlerTraceparentHeaderPrecedence(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithServerTraceparentHeaders("traceparent", apmhttp.TraceparentHeader),
+	)
+	for _, headers := range []map[string]string{{
+		"Traceparent":             "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"Elastic-Apm-Traceparent": "00-11111111111111111111111111111111-2222222222222222-01",
+	}, {
+		"Traceparent":             "invalid",
+		"Elastic-Apm-Traceparent": "00-11111111111111111111111111111111-2222222222222222-01",
+	}} {
+		req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", apm.TraceID(payloads.Transactions[0].TraceID).String())
+	assert.Equal(t, "b7ad6b7169203331", apm.SpanID(payloads.Transactions[0].ParentID).String())
+	assert.Equal(t, "11111111111111111111111111111111", apm.TraceID(payloads.Transactions[1].TraceID).String())
+	assert.Equal(t, "2222222222222222", apm.SpanID(payloads.Transactions[1].ParentID).String())
+}
+
+func TestHandlerResponseSizeTags(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte("foo"))
+			w.Write([]byte("bar"))
+		}),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithResponseSizeTags(),
+	)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+	h.ServeHTTP(w, req)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "response_body_size", Value: "6"},
+		{Key: "response_content_encoding", Value: "gzip"},
+	}, payloads.Transactions[0].Context.Tags)
+}
+
+func TestHandlerServerTimingHeader(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Add("Server-Timing", "db;dur=53")
+		}),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithServerTimingHeader(),
+	)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+	h.ServeHTTP(w, req)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	tx := payloads.Transactions[0]
+	assert.Equal(t, []string{
+		fmt.Sprintf(`traceparent;desc="00-%x-%x-01"`, tx.TraceID[:], tx.ID[:]),
+		"db;dur=53",
+	}, w.Header()["Server-Timing"])
+}
+
+func TestHandlerProtocolTags(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Trailer", "Grpc-Status")
+			w.Write([]byte("foo"))
+			w.Header().Set("Grpc-Status", "5")
+		}),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithProtocolTags(),
+	)
+
+	req, _ := http.NewRequest("POST", "http://server.testing/foo", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Cleartext HTTP/2, with the trailer set using http.TrailerPrefix.
+	h = apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("foo"))
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		}),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithProtocolTags(),
+	)
+	req, _ = http.NewRequest("POST", "http://server.testing/foo", nil)
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/2.0", 2, 0
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, model.StringMap{
+		{Key: "grpc_status", Value: "5"},
+		{Key: "http_protocol", Value: "http/1.1"},
+	}, payloads.Transactions[0].Context.Tags)
+	assert.Equal(t, model.StringMap{
+		{Key: "grpc_status", Value: "0"},
+		{Key: "http_protocol", Value: "h2c"},
+	}, payloads.Transactions[1].Context.Tags)
+}
+
+func TestHandlerForceSampleHeader(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewRatioSampler(0))
+
+	var handlerHeaders []http.Header
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			handlerHeaders = append(handlerHeaders, req.Header)
+		}),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithForceSampleHeader("x-apm-force-sample", "s3cr3t"),
+	)
+	for _, value := range []string{"s3cr3t", "wrong", ""} {
+		req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+		if value != "" {
+			req.Header.Set("X-Apm-Force-Sample", value)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 3)
+	assert.Nil(t, payloads.Transactions[0].Sampled) // sampled
+	assert.Equal(t, false, *payloads.Transactions[1].Sampled)
+	assert.Equal(t, false, *payloads.Transactions[2].Sampled)
+
+	// The header is hidden from the handler, and not recorded.
+	require.Len(t, handlerHeaders, 3)
+	for _, header := range handlerHeaders {
+		assert.NotContains(t, header, "X-Apm-Force-Sample")
+	}
+	assert.Empty(t, payloads.Transactions[0].Context.Request.Headers)
+}
+
 func panicHandler(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusTeapot)
 	panic("foo")
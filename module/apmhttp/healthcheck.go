@@ -0,0 +1,110 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/internal/apmconfig"
+	"go.elastic.co/apm/internal/apmlog"
+	"go.elastic.co/apm/internal/wildcard"
+)
+
+const (
+	envHealthCheckURLs       = "ELASTIC_APM_HEALTHCHECK_URLS"
+	envHealthCheckSampleRate = "ELASTIC_APM_HEALTHCHECK_SAMPLE_RATE"
+
+	// HealthCheckTransactionType is the transaction type
+	// with which health check requests are reported.
+	// See WithHealthCheck.
+	HealthCheckTransactionType = "healthcheck"
+
+	// DefaultHealthCheckSampleRate is the default sample rate
+	// for health check requests.
+	DefaultHealthCheckSampleRate = 0.001
+)
+
+var (
+	defaultHealthCheckOnce    sync.Once
+	defaultHealthCheckFunc    HealthCheckFunc
+	defaultHealthCheckSampler apm.Sampler
+)
+
+// HealthCheckFunc is the type of a function for use in WithHealthCheck,
+// reporting whether or not a server request is a health check.
+type HealthCheckFunc func(*http.Request) bool
+
+// NewWildcardPatternsHealthCheck returns a HealthCheckFunc which matches
+// requests' URL paths against any of the matchers.
+func NewWildcardPatternsHealthCheck(matchers wildcard.Matchers) HealthCheckFunc {
+	if len(matchers) == 0 {
+		panic("len(matchers) == 0")
+	}
+	return func(r *http.Request) bool {
+		return matchers.MatchAny(r.URL.Path)
+	}
+}
+
+// WithHealthCheck returns a ServerOption which classifies requests for
+// which h returns true as health checks. Health checks are reported as
+// transactions of type HealthCheckTransactionType, and the root transactions
+// of traces are sampled at sampleRate, independently of the tracer's sampler.
+// This is an alternative to ignoring health checks altogether, or recording
+// them at the same rate as other requests.
+//
+// If h is nil, no requests are classified as health checks. If sampleRate
+// does not lie within the range [0,1.0], WithHealthCheck will panic.
+//
+// By default, if ELASTIC_APM_HEALTHCHECK_URLS is set, it is treated as a
+// comma-separated list of wildcard patterns matched against URL paths, and
+// matching requests are sampled at ELASTIC_APM_HEALTHCHECK_SAMPLE_RATE, or
+// DefaultHealthCheckSampleRate if that is unset.
+func WithHealthCheck(h HealthCheckFunc, sampleRate float64) ServerOption {
+	sampler := apm.NewRatioSampler(sampleRate)
+	return func(handler *handler) {
+		handler.healthCheck = h
+		handler.healthCheckSampler = sampler
+	}
+}
+
+// defaultHealthCheck returns the HealthCheckFunc and Sampler defined by
+// ELASTIC_APM_HEALTHCHECK_URLS and ELASTIC_APM_HEALTHCHECK_SAMPLE_RATE.
+// The HealthCheckFunc is nil if ELASTIC_APM_HEALTHCHECK_URLS is unset.
+func defaultHealthCheck() (HealthCheckFunc, apm.Sampler) {
+	defaultHealthCheckOnce.Do(func() {
+		matchers := apmconfig.ParseWildcardPatternsEnv(envHealthCheckURLs, nil)
+		if len(matchers) == 0 {
+			return
+		}
+		sampleRate := DefaultHealthCheckSampleRate
+		if value := os.Getenv(envHealthCheckSampleRate); value != "" {
+			if r, err := strconv.ParseFloat(value, 64); err == nil && r >= 0 && r <= 1 {
+				sampleRate = r
+			} else if apmlog.DefaultLogger != nil {
+				apmlog.DefaultLogger.Errorf("invalid %s value %q, using %v", envHealthCheckSampleRate, value, sampleRate)
+			}
+		}
+		defaultHealthCheckFunc = NewWildcardPatternsHealthCheck(matchers)
+		defaultHealthCheckSampler = apm.NewRatioSampler(sampleRate)
+	})
+	return defaultHealthCheckFunc, defaultHealthCheckSampler
+}
@@ -0,0 +1,92 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmhttp"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestHandlerHealthCheck(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	h := apmhttp.Wrap(
+		http.NotFoundHandler(),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithHealthCheck(func(req *http.Request) bool {
+			return req.URL.Path == "/healthz"
+		}, 0),
+	)
+	for _, path := range []string{"/healthz", "/foo"} {
+		req, _ := http.NewRequest("GET", "http://server.testing"+path, nil)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	tracer.Flush(nil)
+
+	transactions := transport.Payloads().Transactions
+	require.Len(t, transactions, 2)
+	assert.Equal(t, "healthcheck", transactions[0].Type)
+	require.NotNil(t, transactions[0].Sampled)
+	assert.False(t, *transactions[0].Sampled)
+	assert.Equal(t, "request", transactions[1].Type)
+	assert.Nil(t, transactions[1].Sampled) // sampled
+
+	assert.Panics(t, func() { apmhttp.WithHealthCheck(nil, 2) })
+}
+
+func TestHandlerHealthCheckEnv(t *testing.T) {
+	if os.Getenv("_INSIDE_TEST") != "1" {
+		cmd := exec.Command(os.Args[0], "-test.run=^"+regexp.QuoteMeta(t.Name())+"$")
+		cmd.Env = append(os.Environ(), "_INSIDE_TEST=1")
+		cmd.Env = append(cmd.Env, "ELASTIC_APM_HEALTHCHECK_URLS=/healthz*,/readyz")
+		cmd.Env = append(cmd.Env, "ELASTIC_APM_HEALTHCHECK_SAMPLE_RATE=1")
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(output))
+		return
+	}
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	h := apmhttp.Wrap(http.NotFoundHandler(), apmhttp.WithTracer(tracer))
+	for _, path := range []string{"/healthz/live", "/readyz", "/foo"} {
+		req, _ := http.NewRequest("GET", "http://server.testing"+path+"?q=1", nil)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	tracer.Flush(nil)
+
+	var types []string
+	var sampled []*bool
+	for _, tx := range transport.Payloads().Transactions {
+		types = append(types, tx.Type)
+		sampled = append(sampled, tx.Sampled)
+	}
+	assert.Equal(t, []string{"healthcheck", "healthcheck", "request"}, types)
+	assert.Equal(t, []*bool{nil, nil, nil}, sampled)
+}
@@ -0,0 +1,146 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/internal/apmhttputil"
+)
+
+// maxRateLimitedClients is the maximum number of client addresses
+// tracked by a ClientRateLimiter within a one-second window.
+const maxRateLimitedClients = 10000
+
+// ClientRateLimiter limits the rate at which transactions are created
+// for requests from each client IP address. Requests exceeding the limit
+// are still handled, but are not traced. This protects the agent and the
+// APM Server during scraping or bot storms, without lowering the sample
+// rate for all traffic.
+//
+// The client address is the connection's remote address. Forwarding
+// headers are ignored unless the connection is from a trusted proxy; see
+// SetTrustedProxies. At most 10,000 clients are tracked each second;
+// requests from further clients within the same second are not traced.
+//
+// ClientRateLimiter is also an apm.MetricsGatherer, reporting the metric
+// "http.server.transactions.rate_limited": the number of requests that
+// have not been traced due to the limit. The ClientRateLimiter must be
+// registered with a tracer using apm.Tracer.RegisterMetricsGatherer for
+// the metric to be reported.
+type ClientRateLimiter struct {
+	limited   uint64 // accessed atomically; must be 64-bit aligned
+	perSecond int
+
+	mu             sync.Mutex
+	trustedProxies []*net.IPNet
+	windowStart    time.Time
+	clients        map[string]int
+}
+
+// NewClientRateLimiter returns a new ClientRateLimiter allowing at most
+// perSecond transactions to be created per second for each client. If
+// perSecond is not positive, NewClientRateLimiter will panic.
+func NewClientRateLimiter(perSecond int) *ClientRateLimiter {
+	if perSecond <= 0 {
+		panic("perSecond <= 0")
+	}
+	return &ClientRateLimiter{
+		perSecond: perSecond,
+		clients:   make(map[string]int),
+	}
+}
+
+// SetTrustedProxies sets the networks of proxies trusted to report the
+// client address. For requests whose connection is from an address within
+// one of networks, the client address is taken from the Forwarded, X-Real-Ip
+// or X-Forwarded-For headers if present, as for the transaction context's
+// remote address. Trusted proxies must replace any such headers sent by
+// clients, or clients may choose the address they are limited by.
+//
+// By default, no proxies are trusted.
+func (l *ClientRateLimiter) SetTrustedProxies(networks ...*net.IPNet) {
+	l.mu.Lock()
+	l.trustedProxies = append([]*net.IPNet(nil), networks...)
+	l.mu.Unlock()
+}
+
+// clientAddr returns the address of the client that sent req.
+func (l *ClientRateLimiter) clientAddr(req *http.Request) string {
+	addr, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		addr = req.RemoteAddr
+	}
+	l.mu.Lock()
+	trustedProxies := l.trustedProxies
+	l.mu.Unlock()
+	if len(trustedProxies) == 0 {
+		return addr
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			var forwarded *apmhttputil.ForwardedHeader
+			if fwd := req.Header.Get("Forwarded"); fwd != "" {
+				parsed := apmhttputil.ParseForwarded(fwd)
+				forwarded = &parsed
+			}
+			return apmhttputil.RemoteAddr(req, forwarded)
+		}
+	}
+	return addr
+}
+
+// allow reports whether a transaction may be created for req,
+// recording the request against the client's limit.
+func (l *ClientRateLimiter) allow(req *http.Request) bool {
+	addr := l.clientAddr(req)
+
+	now := time.Now()
+	l.mu.Lock()
+	if now.Sub(l.windowStart) >= time.Second {
+		l.windowStart = now
+		l.clients = make(map[string]int)
+	}
+	n, ok := l.clients[addr]
+	allowed := n < l.perSecond && (ok || len(l.clients) < maxRateLimitedClients)
+	if allowed {
+		l.clients[addr] = n + 1
+	}
+	l.mu.Unlock()
+
+	if !allowed {
+		atomic.AddUint64(&l.limited, 1)
+	}
+	return allowed
+}
+
+// GatherMetrics gathers rate limiting metrics into m.
+func (l *ClientRateLimiter) GatherMetrics(ctx context.Context, m *apm.Metrics) error {
+	m.Add("http.server.transactions.rate_limited", nil, float64(atomic.LoadUint64(&l.limited)))
+	return nil
+}
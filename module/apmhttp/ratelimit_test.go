@@ -0,0 +1,91 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmhttp"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestClientRateLimiter(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	limiter := apmhttp.NewClientRateLimiter(2)
+	tracer.RegisterMetricsGatherer(limiter)
+
+	var handled int
+	h := apmhttp.Wrap(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { handled++ }),
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithClientRateLimiter(limiter),
+	)
+	serve := func(remoteAddr, forwardedFor string) {
+		req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	for i := 0; i < 5; i++ {
+		serve("10.0.0.1:1234", "")
+	}
+	serve("10.0.0.2:1234", "")
+
+	// Forwarding headers are ignored unless the connection
+	// is from a trusted proxy.
+	for i := 0; i < 3; i++ {
+		serve("10.0.0.3:1234", "192.168.0.1")
+		serve("10.0.0.3:1234", "192.168.0.2")
+	}
+	_, trusted, err := net.ParseCIDR("10.1.0.0/16")
+	require.NoError(t, err)
+	limiter.SetTrustedProxies(trusted)
+	serve("10.1.0.1:1234", "192.168.0.3")
+	serve("10.1.0.2:1234", "192.168.0.3")
+	serve("10.1.0.3:1234", "192.168.0.3")
+	assert.Equal(t, 15, handled)
+
+	tracer.Flush(nil)
+	tracer.SendMetrics(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 7)
+
+	var limited *model.Metric
+	for _, m := range payloads.Metrics {
+		if metric, ok := m.Samples["http.server.transactions.rate_limited"]; ok {
+			limited = &metric
+		}
+	}
+	require.NotNil(t, limited)
+	assert.Equal(t, float64(8), limited.Value)
+}
+
+func TestClientRateLimiterInvalid(t *testing.T) {
+	assert.Panics(t, func() { apmhttp.NewClientRateLimiter(0) })
+}
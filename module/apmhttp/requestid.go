@@ -0,0 +1,69 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	// RequestIDHeader is the conventional HTTP header used by load
+	// balancers and proxies to identify a request.
+	RequestIDHeader = "X-Request-Id"
+
+	// CorrelationIDHeader is an alternative HTTP header used by some
+	// services to correlate requests across service boundaries.
+	CorrelationIDHeader = "X-Correlation-Id"
+
+	// requestIDTag is the tag key with which request IDs are
+	// recorded on transactions and client request spans.
+	requestIDTag = "request_id"
+)
+
+var defaultRequestIDHeaders = []string{RequestIDHeader, CorrelationIDHeader}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of parent in which the given
+// request ID is stored. Client requests made with the context will
+// propagate the ID if WithClientRequestIDHeader is used.
+//
+// The handler returned by Wrap stores the incoming request ID in the
+// request context, so there is usually no need to call this directly.
+func ContextWithRequestID(parent context.Context, id string) context.Context {
+	return context.WithValue(parent, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx by
+// ContextWithRequestID, and a boolean indicating whether one was found.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// requestID returns the first non-empty value of the given headers
+// in h, or the empty string if there is none.
+func requestID(h http.Header, names []string) string {
+	for _, name := range names {
+		if values := h[name]; len(values) != 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+//go:generate go run genresponsewriter.go
+
+// WrapResponseWriter wraps an http.ResponseWriter and returns the wrapped
+// value along with a *Response which will be filled in when the handler
+// is called. The *Response value must not be inspected until after the
+// request has been handled, to avoid data races. If neither of the
+// ResponseWriter's Write or WriteHeader methods are called, then the
+// response's StatusCode field will be zero.
+//
+// The returned http.ResponseWriter implements each of http.Flusher,
+// http.Hijacker, http.Pusher, http.CloseNotifier, and io.ReaderFrom
+// if and only if the provided http.ResponseWriter does.
+func WrapResponseWriter(w http.ResponseWriter) (http.ResponseWriter, *Response) {
+	rw := &responseWriter{
+		ResponseWriter: w,
+		resp: Response{
+			Headers: w.Header(),
+		},
+	}
+	return wrapResponseWriter(rw), &rw.resp
+}
+
+// Response records details of the HTTP response.
+type Response struct {
+	// StatusCode records the HTTP status code set via WriteHeader.
+	StatusCode int
+
+	// BodySize records the number of response body bytes written
+	// via Write or ReadFrom.
+	BodySize int64
+
+	// Headers holds the headers set in the ResponseWriter.
+	Headers http.Header
+
+	// Hijacked records whether the connection was successfully
+	// hijacked via the http.Hijacker interface.
+	Hijacked bool
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	resp Response
+}
+
+// WriteHeader sets w.resp.StatusCode and calls through to the embedded
+// ResponseWriter.
+func (w *responseWriter) WriteHeader(statusCode int) {
+	w.ResponseWriter.WriteHeader(statusCode)
+	w.resp.StatusCode = statusCode
+}
+
+// Write calls through to the embedded ResponseWriter, setting
+// w.resp.StatusCode to http.StatusOK if WriteHeader has not already
+// been called.
+func (w *responseWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.resp.BodySize += int64(n)
+	if w.resp.StatusCode == 0 {
+		w.resp.StatusCode = http.StatusOK
+	}
+	return n, err
+}
+
+// The types below each implement one of the optional interfaces
+// of http.ResponseWriter by calling through to the underlying writer.
+// They are combined by wrapResponseWriter according to the interfaces
+// implemented by the underlying writer.
+
+type responseWriterFlusher struct{ w *responseWriter }
+
+// Flush calls through to the underlying http.Flusher.
+func (f responseWriterFlusher) Flush() {
+	f.w.ResponseWriter.(http.Flusher).Flush()
+}
+
+type responseWriterHijacker struct{ w *responseWriter }
+
+// Hijack calls through to the underlying http.Hijacker, setting
+// w.resp.Hijacked if the connection is successfully hijacked.
+func (h responseWriterHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		h.w.resp.Hijacked = true
+	}
+	return conn, rw, err
+}
+
+type responseWriterPusher struct{ w *responseWriter }
+
+// Push calls through to the underlying http.Pusher.
+func (p responseWriterPusher) Push(target string, opts *http.PushOptions) error {
+	return p.w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+type responseWriterCloseNotifier struct{ w *responseWriter }
+
+// CloseNotify calls through to the underlying http.CloseNotifier.
+func (c responseWriterCloseNotifier) CloseNotify() <-chan bool {
+	return c.w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+type responseWriterReaderFrom struct{ w *responseWriter }
+
+// ReadFrom calls through to the underlying io.ReaderFrom, preserving
+// its fast path (e.g. sendfile), and records the response as Write does.
+func (r responseWriterReaderFrom) ReadFrom(src io.Reader) (int64, error) {
+	n, err := r.w.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	r.w.resp.BodySize += n
+	if r.w.resp.StatusCode == 0 && n > 0 {
+		r.w.resp.StatusCode = http.StatusOK
+	}
+	return n, err
+}
@@ -0,0 +1,84 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmhttp"
+)
+
+func TestWrapResponseWriterInterfaces(t *testing.T) {
+	type flusherPusher struct {
+		http.ResponseWriter
+		http.Flusher
+		http.Pusher
+	}
+	for name, w := range map[string]http.ResponseWriter{
+		"none":           struct{ http.ResponseWriter }{httptest.NewRecorder()},
+		"recorder":       httptest.NewRecorder(),
+		"flusher_pusher": flusherPusher{ResponseWriter: httptest.NewRecorder()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			wrapped, _ := apmhttp.WrapResponseWriter(w)
+			for _, check := range []struct {
+				name string
+				f    func(interface{}) bool
+			}{
+				{"Flusher", func(v interface{}) bool { _, ok := v.(http.Flusher); return ok }},
+				{"Hijacker", func(v interface{}) bool { _, ok := v.(http.Hijacker); return ok }},
+				{"Pusher", func(v interface{}) bool { _, ok := v.(http.Pusher); return ok }},
+				{"CloseNotifier", func(v interface{}) bool { _, ok := v.(http.CloseNotifier); return ok }},
+				{"ReaderFrom", func(v interface{}) bool { _, ok := v.(io.ReaderFrom); return ok }},
+			} {
+				assert.Equal(t, check.f(w), check.f(wrapped), check.name)
+			}
+		})
+	}
+}
+
+func TestWrapResponseWriterReadFrom(t *testing.T) {
+	type result struct {
+		readerFrom bool
+		resp       apmhttp.Response
+	}
+	results := make(chan result, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w, resp := apmhttp.WrapResponseWriter(w)
+		_, ok := w.(io.ReaderFrom)
+		io.Copy(w, strings.NewReader("hello, world"))
+		results <- result{readerFrom: ok, resp: *resp}
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	r := <-results
+	assert.True(t, r.readerFrom)
+	assert.Equal(t, http.StatusOK, r.resp.StatusCode)
+	assert.Equal(t, int64(len("hello, world")), r.resp.BodySize)
+}
@@ -18,13 +18,15 @@
 package apmhttp
 
 import (
-	"encoding/hex"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/pkg/errors"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/internal/tracecontext"
 )
 
 const (
@@ -34,13 +36,67 @@ const (
 	// To avoid producing possibly invalid traceparent headers, we will
 	// use an alternative name until the format is frozen.
 	TraceparentHeader = "Elastic-Apm-Traceparent"
+
+	// W3CTraceparentHeader is the standard W3C Trace-Context HTTP header
+	// for trace propagation. This header is neither sent nor consulted
+	// by default; see WithServerTraceparentHeaders and
+	// WithClientTraceparentHeaders.
+	W3CTraceparentHeader = "Traceparent"
+
+	// W3CTracestateHeader is the standard W3C Trace-Context HTTP header
+	// for vendor-specific trace state. It is consulted and propagated
+	// alongside the traceparent headers.
+	W3CTracestateHeader = "Tracestate"
+
+	// W3CBaggageHeader is the standard W3C HTTP header for propagating
+	// application-defined key/value pairs with a trace. Baggage received
+	// by servers is passed to the tracer in apm.TransactionOptions.Baggage.
+	W3CBaggageHeader = "Baggage"
+
+	// ServerTimingHeader is the HTTP response header in which the
+	// trace context of a server transaction is reported to clients,
+	// such as browsers and RUM agents; see WithServerTimingHeader.
+	ServerTimingHeader = "Server-Timing"
 )
 
+// defaultTraceparentHeaders holds the headers used for trace propagation
+// by default, in order of precedence.
+var defaultTraceparentHeaders = []string{TraceparentHeader}
+
+// canonicalTraceparentHeaders returns the canonical forms of the given
+// header names, panicking if there are none or any are empty.
+func canonicalTraceparentHeaders(names []string) []string {
+	if len(names) == 0 {
+		panic("no header names specified")
+	}
+	canonical := make([]string, len(names))
+	for i, name := range names {
+		if name == "" {
+			panic("name == \"\"")
+		}
+		canonical[i] = http.CanonicalHeaderKey(name)
+	}
+	return canonical
+}
+
 // FormatTraceparentHeader formats the given trace context as a
 // traceparent header.
 func FormatTraceparentHeader(c apm.TraceContext) string {
-	const version = 0
-	return fmt.Sprintf("%02x-%032x-%016x-%02x", 0, c.Trace[:], c.Span[:], c.Options)
+	return tracecontext.Traceparent{
+		TraceID: c.Trace,
+		SpanID:  c.Span,
+		Flags:   byte(c.Options),
+	}.String()
+}
+
+// SetServerTimingHeader adds a Server-Timing "traceparent" metric to h,
+// with its description holding the trace context c formatted as with
+// FormatTraceparentHeader. This enables browsers and other clients to
+// correlate a response with the transaction which served it, e.g.
+//
+//	Server-Timing: traceparent;desc="00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+func SetServerTimingHeader(h http.Header, c apm.TraceContext) {
+	h.Add(ServerTimingHeader, fmt.Sprintf("traceparent;desc=%q", FormatTraceparentHeader(c)))
 }
 
 // ParseTraceparentHeader parses the given header, which is expected to be in
@@ -52,66 +108,71 @@ func FormatTraceparentHeader(c apm.TraceContext) string {
 // trace/span IDs, and validate them as required using their provided Validate
 // methods.
 func ParseTraceparentHeader(h string) (apm.TraceContext, error) {
-	var out apm.TraceContext
-	if len(h) < 3 || h[2] != '-' {
-		return out, errors.Errorf("invalid traceparent header %q", h)
+	p, err := tracecontext.ParseTraceparent(h)
+	if err != nil {
+		return apm.TraceContext{}, err
 	}
-	var version byte
-	if !strings.HasPrefix(h, "00") {
-		decoded, err := hex.DecodeString(h[:2])
-		if err != nil {
-			return out, errors.Wrap(err, "error decoding traceparent header version")
-		}
-		version = decoded[0]
+	return apm.TraceContext{
+		Trace:   p.TraceID,
+		Span:    p.SpanID,
+		Options: apm.TraceOptions(p.Flags),
+	}, nil
+}
+
+// ParseTracestateHeader parses the given header values, which are expected
+// to be in the W3C Trace-Context tracestate format:
+//     https://w3c.github.io/trace-context/#tracestate-field
+//
+// Multiple values are combined in order, as if they were a single
+// comma-separated list. Empty list members are ignored.
+func ParseTracestateHeader(h ...string) (apm.TraceState, error) {
+	parsed, err := tracecontext.ParseTracestate(h...)
+	if err != nil {
+		return apm.TraceState{}, err
 	}
-	h = h[3:]
-
-	switch version {
-	case 255:
-		// "Version 255 is invalid."
-		return out, errors.Errorf("traceparent header version 255 is forbidden")
-	default:
-		// "If higher version is detected - implementation SHOULD try to parse it."
-		fallthrough
-	case 0:
-		// Version 00:
-		//
-		//     version-format   = trace-id "-" span-id "-" trace-options
-		//     trace-id         = 32HEXDIG
-		//     span-id          = 16HEXDIG
-		//     trace-options    = 2HEXDIG
-		const (
-			traceIDEnd        = 32
-			spanIDStart       = traceIDEnd + 1
-			spanIDEnd         = spanIDStart + 16
-			traceOptionsStart = spanIDEnd + 1
-			traceOptionsEnd   = traceOptionsStart + 2
-		)
-		switch {
-		case len(h) < traceOptionsEnd,
-			h[traceIDEnd] != '-',
-			h[spanIDEnd] != '-',
-			version == 0 && len(h) != traceOptionsEnd,
-			version > 0 && len(h) > traceOptionsEnd && h[traceOptionsEnd] != '-':
-			return out, errors.Errorf("invalid version %d traceparent header %q", version, h)
-		}
-		if _, err := hex.Decode(out.Trace[:], []byte(h[:traceIDEnd])); err != nil {
-			return out, errors.Wrapf(err, "error decoding trace-id for version %d", version)
-		}
-		if err := out.Trace.Validate(); err != nil {
-			return out, errors.Wrap(err, "invalid trace-id")
-		}
-		if _, err := hex.Decode(out.Span[:], []byte(h[spanIDStart:spanIDEnd])); err != nil {
-			return out, errors.Wrapf(err, "error decoding span-id for version %d", version)
-		}
-		if err := out.Span.Validate(); err != nil {
-			return out, errors.Wrap(err, "invalid span-id")
-		}
-		var traceOptions [1]byte
-		if _, err := hex.Decode(traceOptions[:], []byte(h[traceOptionsStart:traceOptionsEnd])); err != nil {
-			return out, errors.Wrapf(err, "error decoding trace-options for version %d", version)
+	entries := make([]apm.TraceStateEntry, len(parsed))
+	for i, e := range parsed {
+		entries[i] = apm.TraceStateEntry(e)
+	}
+	out := apm.NewTraceState(entries...)
+	if err := out.Validate(); err != nil {
+		return apm.TraceState{}, err
+	}
+	return out, nil
+}
+
+// ParseBaggageHeader parses the given header values, which are expected
+// to be in the W3C Baggage format:
+//     https://www.w3.org/TR/baggage/#baggage-http-header-format
+//
+// Multiple values are combined in order, as if they were a single
+// comma-separated list. Empty list members and member properties are
+// ignored, and values are percent-decoded.
+func ParseBaggageHeader(h ...string) ([]apm.BaggageMember, error) {
+	var members []apm.BaggageMember
+	for _, value := range h {
+		for _, member := range strings.Split(value, ",") {
+			if semi := strings.IndexRune(member, ';'); semi >= 0 {
+				member = member[:semi]
+			}
+			member = strings.TrimSpace(member)
+			if member == "" {
+				continue
+			}
+			equal := strings.IndexRune(member, '=')
+			if equal == -1 {
+				return nil, errors.Errorf("missing '=' in baggage member %q", member)
+			}
+			key := strings.TrimSpace(member[:equal])
+			if key == "" {
+				return nil, errors.Errorf("missing key in baggage member %q", member)
+			}
+			value, err := url.PathUnescape(strings.TrimSpace(member[equal+1:]))
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid value in baggage member %q", member)
+			}
+			members = append(members, apm.BaggageMember{Key: key, Value: value})
 		}
-		out.Options = apm.TraceOptions(traceOptions[0])
-		return out, nil
 	}
+	return members, nil
 }
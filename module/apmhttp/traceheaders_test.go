@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"go.elastic.co/apm"
 	"go.elastic.co/apm/module/apmhttp"
@@ -66,3 +67,39 @@ func TestParseTraceparentHeader(t *testing.T) {
 	assertParse("fe-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-foo")
 	assertParseError("fe-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01.foo", `invalid version 254 traceparent header`)
 }
+
+func TestParseTracestateHeader(t *testing.T) {
+	state, err := apmhttp.ParseTracestateHeader("acme=1, es=s:1", "", "other@vendor=x y ,")
+	require.NoError(t, err)
+	assert.Equal(t, "acme=1,es=s:1,other@vendor=x y", state.String())
+
+	state, err = apmhttp.ParseTracestateHeader()
+	require.NoError(t, err)
+	assert.Zero(t, state)
+
+	_, err = apmhttp.ParseTracestateHeader("acme")
+	assert.EqualError(t, err, `missing '=' in tracestate entry "acme"`)
+	_, err = apmhttp.ParseTracestateHeader("acme=1", "acme=2")
+	assert.EqualError(t, err, `duplicate tracestate key "acme"`)
+}
+
+func TestParseBaggageHeader(t *testing.T) {
+	baggage, err := apmhttp.ParseBaggageHeader("tenant=acme, user%20name = a%20b;prop=1", "", "region=eu,")
+	require.NoError(t, err)
+	assert.Equal(t, []apm.BaggageMember{
+		{Key: "tenant", Value: "acme"},
+		{Key: "user%20name", Value: "a b"},
+		{Key: "region", Value: "eu"},
+	}, baggage)
+
+	baggage, err = apmhttp.ParseBaggageHeader()
+	require.NoError(t, err)
+	assert.Nil(t, baggage)
+
+	_, err = apmhttp.ParseBaggageHeader("tenant")
+	assert.EqualError(t, err, `missing '=' in baggage member "tenant"`)
+	_, err = apmhttp.ParseBaggageHeader("=acme")
+	assert.EqualError(t, err, `missing key in baggage member "=acme"`)
+	_, err = apmhttp.ParseBaggageHeader("tenant=%zz")
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmlambda
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.elastic.co/apm"
+)
+
+// batchEvent holds the fields of SQS and Kinesis event payloads
+// which are used for describing the batch of records processed by
+// an invocation.
+type batchEvent struct {
+	Records []struct {
+		EventSource string `json:"eventSource"`
+
+		// MessageID holds the SQS message ID.
+		MessageID string `json:"messageId"`
+
+		// Attributes holds the SQS message attributes. SentTimestamp
+		// holds the time the message was sent, in milliseconds since
+		// the Unix epoch.
+		Attributes struct {
+			SentTimestamp string `json:"SentTimestamp"`
+		} `json:"attributes"`
+
+		// Kinesis holds the Kinesis record, whose sequence
+		// number identifies the record in a batch response.
+		// ApproximateArrivalTimestamp holds the time the record
+		// was added to the stream, in seconds since the Unix epoch.
+		Kinesis struct {
+			SequenceNumber              string  `json:"sequenceNumber"`
+			ApproximateArrivalTimestamp float64 `json:"approximateArrivalTimestamp"`
+		} `json:"kinesis"`
+	} `json:"Records"`
+}
+
+// batchResponse holds the fields of a partial batch response,
+// identifying the SQS messages or Kinesis records which failed.
+type batchResponse struct {
+	BatchItemFailures []struct {
+		ItemIdentifier string `json:"itemIdentifier"`
+	} `json:"batchItemFailures"`
+}
+
+// batchEventSource returns the event source for the given invocation
+// payload, and the number of records, if the payload is an SQS or
+// Kinesis batch event. Otherwise it returns an empty string.
+func batchEventSource(payload []byte) (string, int) {
+	var event batchEvent
+	if json.Unmarshal(payload, &event) != nil || len(event.Records) == 0 {
+		return "", 0
+	}
+	switch source := event.Records[0].EventSource; source {
+	case "aws:sqs", "aws:kinesis":
+		return source, len(event.Records)
+	}
+	return "", 0
+}
+
+// batchEnqueueTime returns the time at which the oldest record in the
+// given invocation payload was enqueued, if the payload is an SQS or
+// Kinesis batch event. Otherwise it returns the zero time.
+func batchEnqueueTime(payload []byte) time.Time {
+	var event batchEvent
+	if json.Unmarshal(payload, &event) != nil {
+		return time.Time{}
+	}
+	var oldest time.Time
+	for _, record := range event.Records {
+		var t time.Time
+		switch record.EventSource {
+		case "aws:sqs":
+			ms, err := strconv.ParseInt(record.Attributes.SentTimestamp, 10, 64)
+			if err != nil {
+				continue
+			}
+			t = time.Unix(0, ms*int64(time.Millisecond))
+		case "aws:kinesis":
+			seconds := record.Kinesis.ApproximateArrivalTimestamp
+			if seconds <= 0 {
+				continue
+			}
+			whole, frac := math.Modf(seconds)
+			t = time.Unix(int64(whole), int64(frac*float64(time.Second)))
+		default:
+			continue
+		}
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	return oldest
+}
+
+// batchItemFailures returns the identifiers of the failed items in the
+// given response payload, if it is a partial batch response.
+func batchItemFailures(payload []byte) []string {
+	var response batchResponse
+	if json.Unmarshal(payload, &response) != nil {
+		return nil
+	}
+	var ids []string
+	for _, failure := range response.BatchItemFailures {
+		if failure.ItemIdentifier != "" {
+			ids = append(ids, failure.ItemIdentifier)
+		}
+	}
+	return ids
+}
+
+// setBatchTags sets tags on tx describing the batch of records in the
+// request payload, and the record identifiers reported as failures in
+// the response payload, so poison messages can be traced.
+func setBatchTags(tx *apm.Transaction, request, response []byte) {
+	source, size := batchEventSource(request)
+	if source == "" {
+		return
+	}
+	tx.Context.SetTag("batch_event_source", source)
+	tx.Context.SetTag("batch_size", strconv.Itoa(size))
+	if failures := batchItemFailures(response); len(failures) > 0 {
+		tx.Context.SetTag("batch_item_failure_count", strconv.Itoa(len(failures)))
+		tx.Context.SetTag("batch_item_failures", strings.Join(failures, ","))
+	}
+}
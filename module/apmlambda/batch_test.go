@@ -0,0 +1,68 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmlambda
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchEventSource(t *testing.T) {
+	source, size := batchEventSource([]byte(`{"Records":[
+		{"messageId":"a","eventSource":"aws:sqs"},
+		{"messageId":"b","eventSource":"aws:sqs"}
+	]}`))
+	assert.Equal(t, "aws:sqs", source)
+	assert.Equal(t, 2, size)
+
+	source, size = batchEventSource([]byte(`{"Records":[{"eventSource":"aws:s3"}]}`))
+	assert.Equal(t, "", source)
+	assert.Equal(t, 0, size)
+
+	source, _ = batchEventSource([]byte(`"not an object"`))
+	assert.Equal(t, "", source)
+}
+
+func TestBatchEnqueueTime(t *testing.T) {
+	enqueueTime := batchEnqueueTime([]byte(`{"Records":[
+		{"messageId":"a","eventSource":"aws:sqs","attributes":{"SentTimestamp":"1546300801500"}},
+		{"messageId":"b","eventSource":"aws:sqs","attributes":{"SentTimestamp":"1546300800250"}},
+		{"messageId":"c","eventSource":"aws:sqs","attributes":{"SentTimestamp":"invalid"}}
+	]}`))
+	assert.Equal(t, time.Date(2019, 1, 1, 0, 0, 0, 250*int(time.Millisecond), time.UTC), enqueueTime.UTC())
+
+	enqueueTime = batchEnqueueTime([]byte(`{"Records":[
+		{"eventSource":"aws:kinesis","kinesis":{"sequenceNumber":"1","approximateArrivalTimestamp":1546300800.5}}
+	]}`))
+	assert.Equal(t, time.Date(2019, 1, 1, 0, 0, 0, 500*int(time.Millisecond), time.UTC), enqueueTime.UTC())
+
+	assert.True(t, batchEnqueueTime([]byte(`{"Records":[{"eventSource":"aws:s3"}]}`)).IsZero())
+	assert.True(t, batchEnqueueTime([]byte(`"not an object"`)).IsZero())
+}
+
+func TestBatchItemFailures(t *testing.T) {
+	assert.Equal(t, []string{"a", "c"}, batchItemFailures([]byte(`{"batchItemFailures":[
+		{"itemIdentifier":"a"},
+		{"itemIdentifier":""},
+		{"itemIdentifier":"c"}
+	]}`)))
+	assert.Nil(t, batchItemFailures([]byte(`{}`)))
+	assert.Nil(t, batchItemFailures(nil))
+}
@@ -2,6 +2,7 @@ module go.elastic.co/apm/module/apmlambda
 
 require (
 	github.com/aws/aws-lambda-go v1.8.0
+	github.com/stretchr/testify v1.2.2
 	go.elastic.co/apm v1.3.0
 )
 
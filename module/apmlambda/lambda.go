@@ -74,7 +74,9 @@ func (f *Function) Ping(req *messages.PingRequest, response *messages.PingRespon
 
 // Invoke invokes the Lambda function. This is our main trace point.
 func (f *Function) Invoke(req *messages.InvokeRequest, response *messages.InvokeResponse) error {
-	tx := f.tracer.StartTransaction(lambdacontext.FunctionName, "function")
+	tx := f.tracer.StartTransactionOptions(lambdacontext.FunctionName, "function", apm.TransactionOptions{
+		EnqueueTime: batchEnqueueTime(req.Payload),
+	})
 	defer f.tracer.Flush(nonBlocking)
 	defer tx.End()
 	defer func() {
@@ -103,6 +105,9 @@ func (f *Function) Invoke(req *messages.InvokeRequest, response *messages.Invoke
 	if response.Payload != nil {
 		lambdaContext.Response = formatPayload(response.Payload)
 	}
+	if tx.Sampled() {
+		setBatchTags(tx, req.Payload, response.Payload)
+	}
 	if response.Error != nil {
 		e := f.tracer.NewError(invokeResponseError{response.Error})
 		e.SetTransaction(tx)
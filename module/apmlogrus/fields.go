@@ -22,34 +22,40 @@ import (
 
 	"github.com/sirupsen/logrus"
 
-	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmlogfields"
 )
 
 const (
-	// FieldKeyTraceID is the field key for the trace ID.
-	FieldKeyTraceID = "trace.id"
+	// FieldKeyTraceID is the default field key for the trace ID.
+	// The field keys may be changed with apmlogfields.SetNames.
+	FieldKeyTraceID = apmlogfields.DefaultTraceID
 
-	// FieldKeyTransactionID is the field key for the transaction ID.
-	FieldKeyTransactionID = "transaction.id"
+	// FieldKeyTransactionID is the default field key for the transaction ID.
+	FieldKeyTransactionID = apmlogfields.DefaultTransactionID
 
-	// FieldKeySpanID is the field key for the span ID.
-	FieldKeySpanID = "span.id"
+	// FieldKeySpanID is the default field key for the span ID.
+	FieldKeySpanID = apmlogfields.DefaultSpanID
 )
 
 // TraceContext returns a logrus.Fields containing the trace
 // context of the transaction and span contained in ctx, if any.
 func TraceContext(ctx context.Context) logrus.Fields {
-	tx := apm.TransactionFromContext(ctx)
-	if tx == nil {
+	values, names, ok := apmlogfields.FromContext(ctx)
+	if !ok {
 		return nil
 	}
-	traceContext := tx.TraceContext()
 	fields := logrus.Fields{
-		FieldKeyTraceID:       traceContext.Trace,
-		FieldKeyTransactionID: traceContext.Span,
+		names.TraceID:       values.TraceID,
+		names.TransactionID: values.TransactionID,
 	}
-	if span := apm.SpanFromContext(ctx); span != nil {
-		fields[FieldKeySpanID] = span.TraceContext().Span
+	if values.HasSpan {
+		fields[names.SpanID] = values.SpanID
+	}
+	if names.ServiceName != "" {
+		fields[names.ServiceName] = values.ServiceName
+	}
+	if names.ServiceEnvironment != "" {
+		fields[names.ServiceEnvironment] = values.ServiceEnvironment
 	}
 	return fields
 }
@@ -29,6 +29,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmlogfields"
 	"go.elastic.co/apm/apmtest"
 	"go.elastic.co/apm/module/apmlogrus"
 )
@@ -53,6 +54,28 @@ func TestTraceContext(t *testing.T) {
 	)
 }
 
+func TestTraceContextCustomNames(t *testing.T) {
+	defer apmlogfields.SetNames(apmlogfields.Names{})
+	apmlogfields.SetNames(apmlogfields.Names{
+		TraceID:     "trace_id",
+		ServiceName: "service.name",
+	})
+
+	var buf bytes.Buffer
+	logger := newLogger(&buf)
+	tx, _, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		logger.WithTime(time.Unix(0, 0).UTC()).WithFields(apmlogrus.TraceContext(ctx)).Debug("beep")
+	})
+
+	assert.Equal(t,
+		fmt.Sprintf(
+			`{"level":"debug","msg":"beep","service.name":"transporttest","time":"1970-01-01T00:00:00Z","trace_id":"%x","transaction.id":"%x"}`+"\n",
+			tx.TraceID[:], tx.ID[:],
+		),
+		buf.String(),
+	)
+}
+
 func TestTraceContextTextFormatter(t *testing.T) {
 	var buf bytes.Buffer
 	logger := newLogger(&buf)
@@ -24,6 +24,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmlogfields"
 	"go.elastic.co/apm/stacktrace"
 )
 
@@ -103,14 +104,15 @@ func (h *Hook) Fire(entry *logrus.Entry) error {
 
 	// Extract trace context added with apmlogrus.TraceContext,
 	// and include it in the reported error.
-	if traceID, ok := entry.Data[FieldKeyTraceID].(apm.TraceID); ok {
+	names := apmlogfields.CurrentNames()
+	if traceID, ok := entry.Data[names.TraceID].(apm.TraceID); ok {
 		errlog.TraceID = traceID
 	}
-	if transactionID, ok := entry.Data[FieldKeyTransactionID].(apm.SpanID); ok {
+	if transactionID, ok := entry.Data[names.TransactionID].(apm.SpanID); ok {
 		errlog.TransactionID = transactionID
 		errlog.ParentID = transactionID
 	}
-	if spanID, ok := entry.Data[FieldKeySpanID].(apm.SpanID); ok {
+	if spanID, ok := entry.Data[names.SpanID].(apm.SpanID); ok {
 		errlog.ParentID = spanID
 	}
 
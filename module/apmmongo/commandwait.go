@@ -0,0 +1,77 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmmongo
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type commandWaitKey struct{}
+
+// commandWait records the start of an operation, for reporting the
+// time waited until its first command is started.
+type commandWait struct {
+	start time.Time
+
+	// reported is set to 1 once the duration has been reported by the
+	// first command started with the context. reported must be accessed
+	// atomically.
+	reported int32
+}
+
+// ContextWithCommandWaitTiming returns a copy of ctx recording the
+// current time, such that the first command started with the returned
+// context, or a context derived from it, records the time elapsed since
+// ContextWithCommandWaitTiming was called in the span tag
+// "mongodb_command_wait_ms".
+//
+// The MongoDB driver selects a server, and obtains a connection to it,
+// before starting a command, but does not report the time taken to do
+// so. Server selection may take up to the client's server selection
+// timeout if no suitable server is available, e.g. during a replica set
+// election. Calling ContextWithCommandWaitTiming immediately before
+// executing an operation enables that time to be distinguished from the
+// time taken to execute the command:
+//
+//	ctx = apmmongo.ContextWithCommandWaitTiming(ctx)
+//	cursor, err := collection.Find(ctx, filter)
+//
+// The recorded duration is the pre-command wait time: it includes server
+// selection and obtaining a connection, but also any other work done
+// between calling ContextWithCommandWaitTiming and the driver starting
+// the command, such as encoding the operation's documents. Subsequent
+// commands started with the context, such as the getMore commands issued
+// when iterating over a cursor, do not record the tag.
+func ContextWithCommandWaitTiming(ctx context.Context) context.Context {
+	return context.WithValue(ctx, commandWaitKey{}, &commandWait{start: time.Now()})
+}
+
+// commandWaitDuration returns the time elapsed since ctx was created
+// by ContextWithCommandWaitTiming, formatted in milliseconds, if it
+// was and the duration has not already been reported for another command.
+func commandWaitDuration(ctx context.Context) (string, bool) {
+	s, ok := ctx.Value(commandWaitKey{}).(*commandWait)
+	if !ok || !atomic.CompareAndSwapInt32(&s.reported, 0, 1) {
+		return "", false
+	}
+	d := time.Since(s.start)
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), true
+}
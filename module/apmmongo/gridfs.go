@@ -0,0 +1,310 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmmongo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go.elastic.co/apm"
+)
+
+// Bucket wraps a *gridfs.Bucket, such that its upload, download, and
+// delete methods report each file operation as a single span, rather
+// than a span for each command on the files and chunks collections.
+//
+// Spans are named "<bucket>.upload", "<bucket>.download" and
+// "<bucket>.delete", and are tagged with the file name or ID and, for
+// uploads and downloads, the file size in bytes and number of chunks.
+type Bucket struct {
+	*gridfs.Bucket
+	name      string
+	database  string
+	chunkSize int32
+}
+
+// NewBucket creates a GridFS bucket in db, as with gridfs.NewBucket,
+// and wraps it such that file operations are reported as spans.
+func NewBucket(db *mongo.Database, opts ...*options.BucketOptions) (*Bucket, error) {
+	b, err := gridfs.NewBucket(db, opts...)
+	if err != nil {
+		return nil, err
+	}
+	merged := options.MergeBucketOptions(opts...)
+	return &Bucket{
+		Bucket:    b,
+		name:      *merged.Name,
+		database:  db.Name(),
+		chunkSize: *merged.ChunkSizeBytes,
+	}, nil
+}
+
+// UploadFromStream uploads a file read from source, as with
+// gridfs.Bucket.UploadFromStream, reporting a span if ctx contains
+// a transaction.
+func (b *Bucket) UploadFromStream(ctx context.Context, filename string, source io.Reader, opts ...*options.UploadOptions) (primitive.ObjectID, error) {
+	fileID := primitive.NewObjectID()
+	err := b.UploadFromStreamWithID(ctx, fileID, filename, source, opts...)
+	return fileID, err
+}
+
+// UploadFromStreamWithID uploads a file with the given ID read from
+// source, as with gridfs.Bucket.UploadFromStreamWithID, reporting a
+// span if ctx contains a transaction.
+func (b *Bucket) UploadFromStreamWithID(ctx context.Context, fileID interface{}, filename string, source io.Reader, opts ...*options.UploadOptions) error {
+	span, ctx := b.startSpan(ctx, "upload")
+	defer span.End()
+	counter := &countingReader{r: source}
+	err := b.Bucket.UploadFromStreamWithID(fileID, filename, counter, opts...)
+	if !span.Dropped() {
+		chunkSize := b.chunkSize
+		if merged := options.MergeUploadOptions(opts...); merged.ChunkSizeBytes != nil {
+			chunkSize = *merged.ChunkSizeBytes
+		}
+		span.Context.SetTag("gridfs_filename", filename)
+		setFileSizeTags(span, counter.n, chunkSize)
+	}
+	b.captureError(ctx, err)
+	return err
+}
+
+// OpenUploadStream opens a stream for uploading a file with the given
+// name, as with gridfs.Bucket.OpenUploadStream, reporting a span which
+// ends when the stream is closed or aborted if ctx contains a transaction.
+func (b *Bucket) OpenUploadStream(ctx context.Context, filename string, opts ...*options.UploadOptions) (*UploadStream, error) {
+	return b.OpenUploadStreamWithID(ctx, primitive.NewObjectID(), filename, opts...)
+}
+
+// OpenUploadStreamWithID opens a stream for uploading a file with the
+// given ID and name, as with gridfs.Bucket.OpenUploadStreamWithID,
+// reporting a span which ends when the stream is closed or aborted if
+// ctx contains a transaction.
+func (b *Bucket) OpenUploadStreamWithID(ctx context.Context, fileID interface{}, filename string, opts ...*options.UploadOptions) (*UploadStream, error) {
+	span, ctx := b.startSpan(ctx, "upload")
+	us, err := b.Bucket.OpenUploadStreamWithID(fileID, filename, opts...)
+	if err != nil {
+		b.captureError(ctx, err)
+		span.End()
+		return nil, err
+	}
+	chunkSize := b.chunkSize
+	if merged := options.MergeUploadOptions(opts...); merged.ChunkSizeBytes != nil {
+		chunkSize = *merged.ChunkSizeBytes
+	}
+	if !span.Dropped() {
+		span.Context.SetTag("gridfs_filename", filename)
+	}
+	return &UploadStream{UploadStream: us, span: span, ctx: ctx, chunkSize: chunkSize}, nil
+}
+
+// UploadStream wraps a *gridfs.UploadStream, reporting the upload as a
+// span which ends when the stream is closed or aborted.
+type UploadStream struct {
+	*gridfs.UploadStream
+	span      *apm.Span
+	ctx       context.Context
+	chunkSize int32
+	size      int64
+}
+
+// Write writes p to the stream, as with gridfs.UploadStream.Write.
+func (us *UploadStream) Write(p []byte) (int, error) {
+	n, err := us.UploadStream.Write(p)
+	us.size += int64(n)
+	return n, err
+}
+
+// Close closes the stream, as with gridfs.UploadStream.Close, ending
+// the upload span.
+func (us *UploadStream) Close() error {
+	err := us.UploadStream.Close()
+	us.end(err)
+	return err
+}
+
+// Abort aborts the upload, as with gridfs.UploadStream.Abort, ending
+// the upload span.
+func (us *UploadStream) Abort() error {
+	err := us.UploadStream.Abort()
+	us.end(err)
+	return err
+}
+
+func (us *UploadStream) end(err error) {
+	if us.span == nil {
+		return
+	}
+	if !us.span.Dropped() {
+		setFileSizeTags(us.span, us.size, us.chunkSize)
+	}
+	if e := apm.CaptureError(us.ctx, err); e != nil {
+		e.Send()
+	}
+	us.span.End()
+	us.span = nil
+}
+
+// OpenDownloadStream opens a stream for downloading the file with the
+// given ID, as with gridfs.Bucket.OpenDownloadStream, reporting a span
+// which ends when the stream is closed if ctx contains a transaction.
+//
+// The number of chunks is computed assuming the file was uploaded
+// with the bucket's chunk size.
+func (b *Bucket) OpenDownloadStream(ctx context.Context, fileID interface{}) (*DownloadStream, error) {
+	span, ctx := b.startSpan(ctx, "download")
+	ds, err := b.Bucket.OpenDownloadStream(fileID)
+	if err != nil {
+		b.captureError(ctx, err)
+		span.End()
+		return nil, err
+	}
+	if !span.Dropped() {
+		span.Context.SetTag("gridfs_file_id", formatFileID(fileID))
+	}
+	return &DownloadStream{DownloadStream: ds, span: span, chunkSize: b.chunkSize}, nil
+}
+
+// DownloadStream wraps a *gridfs.DownloadStream, reporting the download
+// as a span which ends when the stream is closed.
+type DownloadStream struct {
+	*gridfs.DownloadStream
+	span      *apm.Span
+	chunkSize int32
+	size      int64
+}
+
+// Read reads from the stream, as with gridfs.DownloadStream.Read.
+func (ds *DownloadStream) Read(p []byte) (int, error) {
+	n, err := ds.DownloadStream.Read(p)
+	ds.size += int64(n)
+	return n, err
+}
+
+// Close closes the stream, as with gridfs.DownloadStream.Close, ending
+// the download span.
+func (ds *DownloadStream) Close() error {
+	err := ds.DownloadStream.Close()
+	if ds.span != nil {
+		if !ds.span.Dropped() {
+			setFileSizeTags(ds.span, ds.size, ds.chunkSize)
+		}
+		ds.span.End()
+		ds.span = nil
+	}
+	return err
+}
+
+// DownloadToStream downloads the file with the given ID into stream,
+// as with gridfs.Bucket.DownloadToStream, reporting a span if ctx
+// contains a transaction.
+//
+// The number of chunks is computed assuming the file was uploaded
+// with the bucket's chunk size.
+func (b *Bucket) DownloadToStream(ctx context.Context, fileID interface{}, stream io.Writer) (int64, error) {
+	span, ctx := b.startSpan(ctx, "download")
+	defer span.End()
+	n, err := b.Bucket.DownloadToStream(fileID, stream)
+	if !span.Dropped() {
+		span.Context.SetTag("gridfs_file_id", formatFileID(fileID))
+		setFileSizeTags(span, n, b.chunkSize)
+	}
+	b.captureError(ctx, err)
+	return n, err
+}
+
+// DownloadToStreamByName downloads the file with the given name into
+// stream, as with gridfs.Bucket.DownloadToStreamByName, reporting a span
+// if ctx contains a transaction.
+//
+// The number of chunks is computed assuming the file was uploaded
+// with the bucket's chunk size.
+func (b *Bucket) DownloadToStreamByName(ctx context.Context, filename string, stream io.Writer, opts ...*options.NameOptions) (int64, error) {
+	span, ctx := b.startSpan(ctx, "download")
+	defer span.End()
+	n, err := b.Bucket.DownloadToStreamByName(filename, stream, opts...)
+	if !span.Dropped() {
+		span.Context.SetTag("gridfs_filename", filename)
+		setFileSizeTags(span, n, b.chunkSize)
+	}
+	b.captureError(ctx, err)
+	return n, err
+}
+
+// Delete deletes the file with the given ID and its chunks, as with
+// gridfs.Bucket.Delete, reporting a span if ctx contains a transaction.
+func (b *Bucket) Delete(ctx context.Context, fileID interface{}) error {
+	span, ctx := b.startSpan(ctx, "delete")
+	defer span.End()
+	err := b.Bucket.Delete(fileID)
+	if !span.Dropped() {
+		span.Context.SetTag("gridfs_file_id", formatFileID(fileID))
+	}
+	b.captureError(ctx, err)
+	return err
+}
+
+func (b *Bucket) startSpan(ctx context.Context, action string) (*apm.Span, context.Context) {
+	span, ctx := apm.StartSpanOptions(ctx, b.name+"."+action, "db.mongodb."+action, apm.SpanOptions{ExitSpan: true})
+	if !span.Dropped() {
+		span.Context.SetDatabase(apm.DatabaseSpanContext{
+			Instance: b.database,
+			Type:     "mongodb",
+		})
+	}
+	return span, ctx
+}
+
+func (b *Bucket) captureError(ctx context.Context, err error) {
+	if e := apm.CaptureError(ctx, err); e != nil {
+		e.Send()
+	}
+}
+
+func setFileSizeTags(span *apm.Span, size int64, chunkSize int32) {
+	span.Context.SetTag("gridfs_size", strconv.FormatInt(size, 10))
+	if chunkSize > 0 {
+		chunks := (size + int64(chunkSize) - 1) / int64(chunkSize)
+		span.Context.SetTag("gridfs_chunks", strconv.FormatInt(chunks, 10))
+	}
+}
+
+func formatFileID(fileID interface{}) string {
+	if id, ok := fileID.(primitive.ObjectID); ok {
+		return id.Hex()
+	}
+	return fmt.Sprint(fileID)
+}
+
+// countingReader is an io.Reader which counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,73 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmmongo_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmmongo"
+)
+
+func TestBucketServerUnavailable(t *testing.T) {
+	client, err := mongo.NewClient(options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(10 * time.Millisecond),
+	)
+	require.NoError(t, err)
+	require.NoError(t, client.Connect(context.Background()))
+	defer client.Disconnect(context.Background())
+
+	bucket, err := apmmongo.NewBucket(client.Database("test_db"))
+	require.NoError(t, err)
+
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := bucket.UploadFromStream(ctx, "hello.txt", strings.NewReader("hello"))
+		assert.Error(t, err)
+		_, err = bucket.DownloadToStreamByName(ctx, "hello.txt", &bytes.Buffer{})
+		assert.Error(t, err)
+	})
+	require.Len(t, spans, 2)
+	require.Len(t, errs, 2)
+
+	assert.Equal(t, "fs.upload", spans[0].Name)
+	assert.Equal(t, "db", spans[0].Type)
+	assert.Equal(t, "mongodb", spans[0].Subtype)
+	assert.Equal(t, "upload", spans[0].Action)
+	assert.Equal(t, &model.DatabaseSpanContext{Instance: "test_db", Type: "mongodb"}, spans[0].Context.Database)
+	assert.Equal(t, model.StringMap{
+		{Key: "gridfs_chunks", Value: "0"},
+		{Key: "gridfs_filename", Value: "hello.txt"},
+		{Key: "gridfs_size", Value: "0"},
+	}, spans[0].Context.Tags)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+
+	assert.Equal(t, "fs.download", spans[1].Name)
+	assert.Equal(t, "download", spans[1].Action)
+	assert.Equal(t, spans[1].ID, errs[1].ParentID)
+}
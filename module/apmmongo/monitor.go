@@ -19,7 +19,10 @@ package apmmongo
 
 import (
 	"context"
+	"encoding/hex"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,8 +31,10 @@ import (
 	"go.mongodb.org/mongo-driver/bson/bsonrw"
 	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/internal/apmnetutil"
 )
 
 var (
@@ -74,7 +79,8 @@ func CommandMonitor(opts ...Option) *event.CommandMonitor {
 type commandMonitor struct {
 	// TODO(axw) record number of active commands and report as a
 	// metric so users can, for example, identify unclosed cursors.
-	bsonRegistry *bsoncodec.Registry
+	bsonRegistry   *bsoncodec.Registry
+	readPreference string
 
 	mu    sync.Mutex
 	spans map[commandKey]*apm.Span
@@ -90,7 +96,8 @@ func (c *commandMonitor) started(ctx context.Context, event *event.CommandStarte
 	if collectionName, ok := collectionName(event.CommandName, event.Command); ok {
 		spanName = collectionName + "." + spanName
 	}
-	span, _ := apm.StartSpan(ctx, spanName, "db.mongodb.query")
+	commandWait, hasCommandWait := commandWaitDuration(ctx)
+	span, _ := apm.StartSpanOptions(ctx, spanName, "db.mongodb.query", apm.SpanOptions{ExitSpan: true})
 	if span.Dropped() {
 		return
 	}
@@ -117,6 +124,18 @@ func (c *commandMonitor) started(ctx context.Context, event *event.CommandStarte
 		Type:      "mongodb",
 		Statement: statement,
 	})
+	if host, port, ok := connectionAddress(event.ConnectionID); ok {
+		span.Context.SetDestinationAddress(host, port)
+	}
+	if txnID, ok := sessionTransactionID(event.Command); ok {
+		span.Context.SetTag("mongodb_transaction", txnID)
+	}
+	if mode, ok := readPreferenceMode(event.CommandName, event.Command, c.readPreference); ok {
+		span.Context.SetTag("mongodb_read_preference", mode)
+	}
+	if hasCommandWait {
+		span.Context.SetTag("mongodb_command_wait_ms", commandWait)
+	}
 
 	// The command/event monitoring API does not provide a means of associating
 	// arbitrary data with a request, so we must maintain our own map.
@@ -152,6 +171,39 @@ func (c *commandMonitor) finished(ctx context.Context, event *event.CommandFinis
 	span.End()
 }
 
+// connectionAddress returns the host and port of the server to which
+// the connection with the given ID is made. The driver formats connection
+// IDs as "<host:port>[-<n>]", so this identifies the server actually used,
+// as opposed to any seedlist or SRV record named in the connection string.
+func connectionAddress(connectionID string) (string, int, bool) {
+	if i := strings.LastIndex(connectionID, "[-"); i != -1 {
+		connectionID = connectionID[:i]
+	}
+	return apmnetutil.SplitHostPort(connectionID, 27017)
+}
+
+// sessionTransactionID returns an identifier for the multi-document
+// transaction in which command is executed, if any. The identifier is
+// formed from the session ID and transaction number, and is shared by
+// all commands in the transaction, including commitTransaction and
+// abortTransaction. Retryable writes also carry a transaction number,
+// so commands are only considered part of a transaction if they have
+// "autocommit" set to false.
+func sessionTransactionID(command bson.Raw) (string, bool) {
+	if autocommit, ok := command.Lookup("autocommit").BooleanOK(); !ok || autocommit {
+		return "", false
+	}
+	txnNumber, ok := command.Lookup("txnNumber").Int64OK()
+	if !ok {
+		return "", false
+	}
+	_, sessionID, ok := command.Lookup("lsid", "id").BinaryOK()
+	if !ok {
+		return "", false
+	}
+	return hex.EncodeToString(sessionID) + "-" + strconv.FormatInt(txnNumber, 10), true
+}
+
 func collectionName(commandName string, command bson.Raw) (string, bool) {
 	switch commandName {
 	case
@@ -198,3 +250,70 @@ func collectionName(commandName string, command bson.Raw) (string, bool) {
 
 // Option sets options for tracing MongoDB commands.
 type Option func(*commandMonitor)
+
+// WithReadPreference returns an Option which sets the read preference
+// configured for the client, such that read commands sent without a
+// read preference are tagged with its mode. By default, only commands
+// sent with a read preference are tagged.
+func WithReadPreference(rp *readpref.ReadPref) Option {
+	return func(cm *commandMonitor) {
+		if rp != nil {
+			cm.readPreference = readPreferenceModeName(rp.Mode())
+		}
+	}
+}
+
+// readPreferenceMode returns the mode of the read preference sent with
+// command or, if there is none and commandName is a read command, the
+// fallback mode if it is non-empty. The driver only sends a read
+// preference with commands that require one, e.g. when reading from
+// a secondary via mongos; write commands are always sent to the primary.
+func readPreferenceMode(commandName string, command bson.Raw, fallback string) (string, bool) {
+	if mode, ok := command.Lookup("$readPreference", "mode").StringValueOK(); ok {
+		return mode, true
+	}
+	if !readCommand(commandName) {
+		return "", false
+	}
+	return fallback, fallback != ""
+}
+
+// readCommand reports whether the named command reads data, and
+// so may be sent to a server selected by the read preference.
+func readCommand(commandName string) bool {
+	switch commandName {
+	case
+		"aggregate",
+		"collStats",
+		"count",
+		"dbStats",
+		"distinct",
+		"find",
+		"geoNear",
+		"geoSearch",
+		"listCollections",
+		"listIndexes",
+		"mapReduce",
+		"parallelCollectionScan":
+		return true
+	}
+	return false
+}
+
+// readPreferenceModeName returns the name of mode as sent in the
+// $readPreference document of commands, or "" if mode is unknown.
+func readPreferenceModeName(mode readpref.Mode) string {
+	switch mode {
+	case readpref.PrimaryMode:
+		return "primary"
+	case readpref.PrimaryPreferredMode:
+		return "primaryPreferred"
+	case readpref.SecondaryMode:
+		return "secondary"
+	case readpref.SecondaryPreferredMode:
+		return "secondaryPreferred"
+	case readpref.NearestMode:
+		return "nearest"
+	}
+	return ""
+}
@@ -18,8 +18,10 @@
 package apmmongo_test
 
 import (
+	"bytes"
 	"context"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -112,3 +114,36 @@ func (suite *IntegrationSuite) TestCommandMonitor() {
 	suite.Equal("(UserNotFound) User 'bob@test_db' not found", errs[0].Exception.Message)
 	suite.Equal(model.ExceptionCode{String: "UserNotFound"}, errs[0].Exception.Code)
 }
+
+func (suite *IntegrationSuite) TestGridFS() {
+	bucket, err := apmmongo.NewBucket(
+		suite.client.Database("test_db"),
+		options.GridFSBucket().SetName("files").SetChunkSizeBytes(4),
+	)
+	suite.Require().NoError(err)
+
+	var downloaded bytes.Buffer
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		fileID, err := bucket.UploadFromStream(ctx, "hello.txt", strings.NewReader("hello, world"))
+		suite.Require().NoError(err)
+		_, err = bucket.DownloadToStream(ctx, fileID, &downloaded)
+		suite.Require().NoError(err)
+		suite.Require().NoError(bucket.Delete(ctx, fileID))
+	})
+	suite.Empty(errs)
+	suite.Equal("hello, world", downloaded.String())
+
+	// A single span is reported for each file operation, rather
+	// than for each command on the files and chunks collections.
+	suite.Require().Len(spans, 3)
+	suite.Equal("files.upload", spans[0].Name)
+	suite.Equal("upload", spans[0].Action)
+	suite.Equal(model.StringMap{
+		{Key: "gridfs_chunks", Value: "3"},
+		{Key: "gridfs_filename", Value: "hello.txt"},
+		{Key: "gridfs_size", Value: "12"},
+	}, spans[0].Context.Tags)
+	suite.Equal("files.download", spans[1].Name)
+	suite.Equal("3", spans[1].Context.Tags[0].Value)
+	suite.Equal("files.delete", spans[2].Name)
+}
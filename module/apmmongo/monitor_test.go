@@ -19,14 +19,17 @@ package apmmongo_test
 
 import (
 	"context"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 
 	"go.elastic.co/apm"
 	"go.elastic.co/apm/apmtest"
@@ -111,14 +114,14 @@ func testCommandMonitorFinished(t *testing.T, failure string) {
 			DatabaseName: "test_db",
 			CommandName:  "find",
 			RequestID:    42,
-			ConnectionID: "rainbow",
+			ConnectionID: "mongo.testing:27018[-3]",
 			Command:      mustRawBSON(bson.D{{Key: "find", Value: "test_coll"}}),
 		})
 		finished := event.CommandFinishedEvent{
 			DurationNanos: int64(123 * time.Millisecond),
 			CommandName:   "find",
 			RequestID:     42,
-			ConnectionID:  "rainbow",
+			ConnectionID:  "mongo.testing:27018[-3]",
 		}
 		if failure == "" {
 			cm.Succeeded(ctx, &event.CommandSucceededEvent{
@@ -147,9 +150,162 @@ func testCommandMonitorFinished(t *testing.T, failure string) {
 			Type:      "mongodb",
 			Statement: `{"find":"test_coll"}`,
 		},
+		Destination: &model.DestinationSpanContext{
+			Address: "mongo.testing",
+			Port:    27018,
+		},
 	}, spans[0].Context)
 }
 
+func TestCommandMonitorSessionTransaction(t *testing.T) {
+	sessionID := primitive.Binary{Subtype: 4, Data: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}}
+	commands := []struct {
+		name    string
+		command bson.D
+	}{{
+		name: "insert",
+		command: bson.D{
+			{Key: "insert", Value: "test_coll"},
+			{Key: "lsid", Value: bson.D{{Key: "id", Value: sessionID}}},
+			{Key: "txnNumber", Value: int64(2)},
+			{Key: "startTransaction", Value: true},
+			{Key: "autocommit", Value: false},
+		},
+	}, {
+		name: "commitTransaction",
+		command: bson.D{
+			{Key: "commitTransaction", Value: 1},
+			{Key: "lsid", Value: bson.D{{Key: "id", Value: sessionID}}},
+			{Key: "txnNumber", Value: int64(2)},
+			{Key: "autocommit", Value: false},
+		},
+	}, {
+		// Retryable writes have a transaction number,
+		// but are not part of a multi-document transaction.
+		name: "update",
+		command: bson.D{
+			{Key: "update", Value: "test_coll"},
+			{Key: "lsid", Value: bson.D{{Key: "id", Value: sessionID}}},
+			{Key: "txnNumber", Value: int64(3)},
+		},
+	}}
+
+	cm := apmmongo.CommandMonitor()
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		for i, command := range commands {
+			cm.Started(ctx, &event.CommandStartedEvent{
+				DatabaseName: "test_db",
+				CommandName:  command.name,
+				RequestID:    int64(i),
+				ConnectionID: "rainbow",
+				Command:      mustRawBSON(command.command),
+			})
+			cm.Succeeded(ctx, &event.CommandSucceededEvent{
+				CommandFinishedEvent: event.CommandFinishedEvent{
+					CommandName:  command.name,
+					RequestID:    int64(i),
+					ConnectionID: "rainbow",
+				},
+			})
+		}
+	})
+	require.Len(t, spans, 3)
+
+	expectedTags := model.StringMap{{
+		Key:   "mongodb_transaction",
+		Value: "000102030405060708090a0b0c0d0e0f-2",
+	}}
+	assert.Equal(t, "test_coll.insert", spans[0].Name)
+	assert.Equal(t, expectedTags, spans[0].Context.Tags)
+	assert.Equal(t, "commitTransaction", spans[1].Name)
+	assert.Equal(t, expectedTags, spans[1].Context.Tags)
+	assert.Equal(t, "test_coll.update", spans[2].Name)
+	assert.Empty(t, spans[2].Context.Tags)
+}
+
+func TestCommandMonitorReadPreference(t *testing.T) {
+	cm := apmmongo.CommandMonitor()
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		for i, command := range []bson.D{{
+			{Key: "find", Value: "test_coll"},
+			{Key: "$readPreference", Value: bson.D{{Key: "mode", Value: "secondaryPreferred"}}},
+		}, {
+			{Key: "find", Value: "test_coll"},
+		}} {
+			testCommandMonitorCommand(ctx, cm, int64(i), mustRawBSON(command))
+		}
+	})
+	require.Len(t, spans, 2)
+	assert.Equal(t, model.StringMap{
+		{Key: "mongodb_read_preference", Value: "secondaryPreferred"},
+	}, spans[0].Context.Tags)
+	assert.Nil(t, spans[1].Context.Tags)
+}
+
+func TestCommandMonitorReadPreferenceFallback(t *testing.T) {
+	cm := apmmongo.CommandMonitor(apmmongo.WithReadPreference(readpref.Nearest()))
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		for i, command := range []bson.D{{
+			{Key: "find", Value: "test_coll"},
+			{Key: "$readPreference", Value: bson.D{{Key: "mode", Value: "secondaryPreferred"}}},
+		}, {
+			{Key: "find", Value: "test_coll"},
+		}, {
+			// Write commands are always sent to the primary.
+			{Key: "insert", Value: "test_coll"},
+		}} {
+			testCommandMonitorCommand(ctx, cm, int64(i), mustRawBSON(command))
+		}
+	})
+	require.Len(t, spans, 3)
+	assert.Equal(t, model.StringMap{
+		{Key: "mongodb_read_preference", Value: "secondaryPreferred"},
+	}, spans[0].Context.Tags)
+	assert.Equal(t, model.StringMap{
+		{Key: "mongodb_read_preference", Value: "nearest"},
+	}, spans[1].Context.Tags)
+	assert.Equal(t, "test_coll.insert", spans[2].Name)
+	assert.Nil(t, spans[2].Context.Tags)
+}
+
+func TestCommandMonitorCommandWaitTiming(t *testing.T) {
+	cm := apmmongo.CommandMonitor()
+	command := mustRawBSON(bson.D{{Key: "find", Value: "test_coll"}})
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx = apmmongo.ContextWithCommandWaitTiming(ctx)
+		time.Sleep(10 * time.Millisecond)
+		testCommandMonitorCommand(ctx, cm, 1, command)
+		// Only the first command started with the
+		// context records the command wait time.
+		testCommandMonitorCommand(ctx, cm, 2, command)
+	})
+	require.Len(t, spans, 2)
+	require.Len(t, spans[0].Context.Tags, 1)
+	assert.Equal(t, "mongodb_command_wait_ms", spans[0].Context.Tags[0].Key)
+	wait, err := strconv.ParseFloat(spans[0].Context.Tags[0].Value, 64)
+	require.NoError(t, err)
+	assert.True(t, wait >= 10)
+	assert.Nil(t, spans[1].Context.Tags)
+}
+
+func testCommandMonitorCommand(ctx context.Context, cm *event.CommandMonitor, requestID int64, command bson.Raw) {
+	commandName := command.Index(0).Key()
+	cm.Started(ctx, &event.CommandStartedEvent{
+		DatabaseName: "test_db",
+		CommandName:  commandName,
+		RequestID:    requestID,
+		ConnectionID: "mongo.testing:27018[-3]",
+		Command:      command,
+	})
+	cm.Succeeded(ctx, &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{
+			CommandName:  commandName,
+			RequestID:    requestID,
+			ConnectionID: "mongo.testing:27018[-3]",
+		},
+	})
+}
+
 func TestCommandMonitorStartedNotFinished(t *testing.T) {
 	cm := apmmongo.CommandMonitor()
 	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
@@ -157,7 +313,7 @@ func TestCommandMonitorStartedNotFinished(t *testing.T) {
 			DatabaseName: "test_db",
 			CommandName:  "find",
 			RequestID:    42,
-			ConnectionID: "rainbow",
+			ConnectionID: "mongo.testing:27018[-3]",
 		})
 	})
 	assert.Empty(t, spans)
@@ -172,7 +328,7 @@ func TestCommandMonitorFinishedNotStarted(t *testing.T) {
 				DurationNanos: int64(123 * time.Millisecond),
 				CommandName:   "find",
 				RequestID:     42,
-				ConnectionID:  "rainbow",
+				ConnectionID:  "mongo.testing:27018[-3]",
 			},
 			Failure: "buhbow",
 		})
@@ -19,8 +19,10 @@ package apmot
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
@@ -143,11 +145,37 @@ func (s *otSpan) setSpanContext() {
 		httpMethod      string
 		haveDBContext   bool
 		haveHTTPContext bool
+		spanKind        string
+		peerHostname    string
+		peerIPv4        string
+		peerIPv6        string
+		peerAddress     string
+		peerAddressPort int
+		peerPort        int
 	)
 	for k, v := range s.tags {
 		switch k {
 		case "component":
 			component = fmt.Sprint(v)
+		case "span.kind":
+			spanKind = fmt.Sprint(v)
+		case "peer.hostname":
+			peerHostname = fmt.Sprint(v)
+		case "peer.ipv4":
+			peerIPv4 = fmt.Sprint(v)
+		case "peer.ipv6":
+			peerIPv6 = fmt.Sprint(v)
+		case "peer.address":
+			// peer.address may be host:port, or some other
+			// address format, e.g. a Unix socket path. In the
+			// latter case the whole address is recorded.
+			peerAddress = fmt.Sprint(v)
+			if host, port, err := net.SplitHostPort(peerAddress); err == nil {
+				peerAddress = host
+				peerAddressPort, _ = strconv.Atoi(port)
+			}
+		case "peer.port":
+			peerPort, _ = strconv.Atoi(fmt.Sprint(v))
 		case "db.instance":
 			dbContext.Instance = fmt.Sprint(v)
 			haveDBContext = true
@@ -197,8 +225,31 @@ func (s *otSpan) setSpanContext() {
 		}
 		s.span.Context.SetDatabase(dbContext)
 	}
+	// Tags are visited in random order, so the destination address
+	// is chosen with a fixed precedence: peer.hostname, peer.ipv4,
+	// peer.ipv6, then peer.address; and peer.port over the port in
+	// peer.address.
+	peerHost := peerHostname
+	for _, host := range []string{peerIPv4, peerIPv6, peerAddress} {
+		if peerHost == "" {
+			peerHost = host
+		}
+	}
+	if peerPort == 0 {
+		peerPort = peerAddressPort
+	}
+	if peerHost != "" || peerPort > 0 {
+		s.span.Context.SetDestinationAddress(peerHost, peerPort)
+	}
 	if s.span.Type == "" {
-		s.span.Type = "custom"
+		switch spanKind {
+		case "producer", "consumer":
+			s.span.Type = "messaging"
+		case "client":
+			s.span.Type = "external"
+		default:
+			s.span.Type = "custom"
+		}
 		s.span.Subtype = component
 	}
 }
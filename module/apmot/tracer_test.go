@@ -97,21 +97,28 @@ func TestSpanType(t *testing.T) {
 
 	type test struct {
 		Tag     opentracing.Tag
+		Kind    opentracing.Tag
 		Type    string
 		Subtype string
 	}
 	tests := []test{
 		{Tag: opentracing.Tag{Key: "component", Value: "foo"}, Type: "custom", Subtype: "foo"},
-		{Tag: opentracing.Tag{Key: "db.type", Value: "sql"}, Type: "db", Subtype: "sql"},
-		{Tag: opentracing.Tag{Key: "http.url", Value: "http://testing.invalid:8000"}, Type: "external", Subtype: "http"},
+		{Tag: opentracing.Tag{Key: "db.type", Value: "sql"}, Kind: ext.SpanKindRPCClient, Type: "db", Subtype: "sql"},
+		{Tag: opentracing.Tag{Key: "http.url", Value: "http://testing.invalid:8000"}, Kind: ext.SpanKindRPCClient, Type: "external", Subtype: "http"},
 		{Tag: opentracing.Tag{Key: "foo", Value: "bar"}, Type: "custom"}, // default
-		{Tag: opentracing.Tag{Key: "type", Value: "baz"}, Type: "baz"},
+		{Tag: opentracing.Tag{Key: "type", Value: "baz"}, Kind: ext.SpanKindRPCClient, Type: "baz"},
+		{Tag: opentracing.Tag{Key: "component", Value: "thrift"}, Kind: ext.SpanKindRPCClient, Type: "external", Subtype: "thrift"},
+		{Tag: opentracing.Tag{Key: "component", Value: "kafka"}, Kind: ext.SpanKindProducer, Type: "messaging", Subtype: "kafka"},
+		{Tag: opentracing.Tag{Key: "component", Value: "kafka"}, Kind: ext.SpanKindConsumer, Type: "messaging", Subtype: "kafka"},
 	}
 
 	txSpan := tracer.StartSpan("tx")
 	for _, test := range tests {
-		span := tracer.StartSpan("child", opentracing.ChildOf(txSpan.Context()), test.Tag)
-		ext.SpanKindRPCClient.Set(span)
+		opts := []opentracing.StartSpanOption{opentracing.ChildOf(txSpan.Context()), test.Tag}
+		if test.Kind.Key != "" {
+			opts = append(opts, test.Kind)
+		}
+		span := tracer.StartSpan("child", opts...)
 		span.Finish()
 	}
 	txSpan.Finish()
@@ -122,9 +129,61 @@ func TestSpanType(t *testing.T) {
 	require.Len(t, payloads.Spans, len(tests))
 	for i, test := range tests {
 		assert.Equal(t, test.Type, payloads.Spans[i].Type)
+		assert.Equal(t, test.Subtype, payloads.Spans[i].Subtype)
+		if context := payloads.Spans[i].Context; context != nil {
+			for _, tag := range context.Tags {
+				assert.NotEqual(t, "span_kind", tag.Key)
+			}
+		}
 	}
 }
 
+func TestSpanPeerDestination(t *testing.T) {
+	tracer, apmtracer, recorder := newTestTracer()
+	defer apmtracer.Close()
+
+	txSpan := tracer.StartSpan("tx")
+	span := tracer.StartSpan("child", opentracing.ChildOf(txSpan.Context()), ext.SpanKindRPCClient)
+	ext.PeerHostname.Set(span, "testing.invalid")
+	ext.PeerHostIPv4.SetString(span, "10.1.2.3")
+	ext.PeerPort.Set(span, 8080)
+	ext.PeerService.Set(span, "inventory")
+	span.Finish()
+	span = tracer.StartSpan("child", opentracing.ChildOf(txSpan.Context()), ext.SpanKindRPCClient)
+	span.SetTag("peer.address", "10.1.2.3:9000")
+	span.Finish()
+	span = tracer.StartSpan("child", opentracing.ChildOf(txSpan.Context()), ext.SpanKindRPCClient)
+	ext.PeerHostIPv6.Set(span, "::1")
+	ext.PeerHostIPv4.SetString(span, "10.1.2.3")
+	span.SetTag("peer.address", "10.1.2.4:9000")
+	ext.PeerPort.Set(span, 8080)
+	span.Finish()
+	txSpan.Finish()
+
+	apmtracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Spans, 3)
+	assert.Equal(t, &model.SpanContext{
+		Destination: &model.DestinationSpanContext{
+			Address: "testing.invalid",
+			Port:    8080,
+		},
+		Tags: model.StringMap{{Key: "peer_service", Value: "inventory"}},
+	}, payloads.Spans[0].Context)
+	assert.Equal(t, &model.SpanContext{
+		Destination: &model.DestinationSpanContext{
+			Address: "10.1.2.3",
+			Port:    9000,
+		},
+	}, payloads.Spans[1].Context)
+	assert.Equal(t, &model.SpanContext{
+		Destination: &model.DestinationSpanContext{
+			Address: "10.1.2.3",
+			Port:    8080,
+		},
+	}, payloads.Spans[2].Context)
+}
+
 func TestDBSpan(t *testing.T) {
 	tracer, apmtracer, recorder := newTestTracer()
 	defer apmtracer.Close()
@@ -0,0 +1,120 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.13
+
+package apmsarama
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+
+	"go.elastic.co/apm"
+)
+
+// ConsumerGroupHandler is implemented by consumer group handlers which
+// process messages individually, for wrapping with WrapConsumerGroupHandler.
+type ConsumerGroupHandler interface {
+	// Setup is run at the beginning of a new session, before
+	// ConsumeMessage. See sarama.ConsumerGroupHandler.Setup.
+	Setup(sarama.ConsumerGroupSession) error
+
+	// Cleanup is run at the end of a session, once all ConsumeMessage
+	// calls have returned. See sarama.ConsumerGroupHandler.Cleanup.
+	Cleanup(sarama.ConsumerGroupSession) error
+
+	// ConsumeMessage processes msg, which was consumed in session.
+	// The context contains the transaction for processing msg, and
+	// is canceled when the session ends. If ConsumeMessage returns
+	// an error, no further messages are consumed from msg's claim.
+	ConsumeMessage(ctx context.Context, session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) error
+}
+
+// WrapConsumerGroupHandler returns a sarama.ConsumerGroupHandler which
+// consumes the messages of each claim with h.ConsumeMessage, reporting
+// a transaction for each message.
+//
+// Each transaction continues the trace recorded in the message headers
+// by the producer, if any, and records the message's timestamp as its
+// enqueue time; see apm.TransactionOptions.EnqueueTime. Transactions are
+// named "kafka RECEIVE from <topic>", and tagged with the topic, partition
+// and offset of the message. Errors returned by, and panics in,
+// ConsumeMessage are reported; see apm.RecoverWithTransaction.
+func WrapConsumerGroupHandler(h ConsumerGroupHandler, o ...ConsumerOption) sarama.ConsumerGroupHandler {
+	opts := consumerOptions{tracer: apm.DefaultTracer}
+	for _, o := range o {
+		o(&opts)
+	}
+	return &consumerGroupHandler{
+		ConsumerGroupHandler: h,
+		tracer:               opts.tracer,
+	}
+}
+
+type consumerGroupHandler struct {
+	ConsumerGroupHandler
+	tracer *apm.Tracer
+}
+
+// ConsumeClaim consumes the messages of claim until the claim's message
+// channel is closed, or h.ConsumeMessage returns an error.
+func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		if err := h.consumeMessage(session, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *consumerGroupHandler) consumeMessage(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) error {
+	opts := apm.TransactionOptions{EnqueueTime: msg.Timestamp}
+	if traceContext, ok := ConsumerMessageTraceContext(msg); ok {
+		opts.TraceContext = traceContext
+	}
+	name := "kafka RECEIVE from " + msg.Topic
+	return apm.RecoverWithTransaction(session.Context(), h.tracer, name, "messaging", opts, func(ctx context.Context) error {
+		tx := apm.TransactionFromContext(ctx)
+		if tx.Sampled() {
+			tx.Context.SetTag("kafka_topic", msg.Topic)
+			tx.Context.SetTag("kafka_partition", strconv.FormatInt(int64(msg.Partition), 10))
+			tx.Context.SetTag("kafka_offset", strconv.FormatInt(msg.Offset, 10))
+		}
+		return h.ConsumeMessage(ctx, session, msg)
+	})
+}
+
+// ConsumerOption sets options for tracing consumer group handlers.
+type ConsumerOption func(*consumerOptions)
+
+type consumerOptions struct {
+	tracer *apm.Tracer
+}
+
+// WithTracer returns a ConsumerOption which sets t as the tracer
+// to use for tracing consumed messages. By default, apm.DefaultTracer
+// is used.
+func WithTracer(t *apm.Tracer) ConsumerOption {
+	if t == nil {
+		panic("t == nil")
+	}
+	return func(o *consumerOptions) {
+		o.tracer = t
+	}
+}
@@ -0,0 +1,194 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.13
+
+package apmsarama_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmsarama"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestWrapConsumerGroupHandler(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	traceContext := apm.TraceContext{
+		Trace: apm.TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+		Span:  apm.SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+	}
+	msgs := []*sarama.ConsumerMessage{{
+		Topic:     "orders",
+		Partition: 2,
+		Offset:    10,
+		Timestamp: time.Now().Add(-time.Second),
+		Headers: []*sarama.RecordHeader{{
+			Key:   []byte("elastic-apm-traceparent"),
+			Value: []byte("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"),
+		}},
+	}, {
+		Topic:     "orders",
+		Partition: 2,
+		Offset:    11,
+	}}
+
+	var handler testConsumerGroupHandler
+	handler.consume = func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		span, _ := apm.StartSpan(ctx, "process", "custom")
+		span.End()
+		return nil
+	}
+	err := apmsarama.WrapConsumerGroupHandler(&handler, apmsarama.WithTracer(tracer)).ConsumeClaim(
+		testConsumerGroupSession{ctx: context.Background()}, newTestConsumerGroupClaim(msgs...),
+	)
+	require.NoError(t, err)
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	require.Len(t, payloads.Spans, 2)
+
+	tx := payloads.Transactions[0]
+	assert.Equal(t, "kafka RECEIVE from orders", tx.Name)
+	assert.Equal(t, "messaging", tx.Type)
+	assert.Equal(t, "success", tx.Result)
+	assert.Equal(t, model.TraceID(traceContext.Trace), tx.TraceID)
+	assert.Equal(t, model.SpanID(traceContext.Span), tx.ParentID)
+	require.NotNil(t, tx.Context)
+	tags := make(map[string]string)
+	for _, tag := range tx.Context.Tags {
+		tags[tag.Key] = tag.Value
+	}
+	assert.Equal(t, "orders", tags["kafka_topic"])
+	assert.Equal(t, "2", tags["kafka_partition"])
+	assert.Equal(t, "10", tags["kafka_offset"])
+	assert.Contains(t, tags, apm.MessagingQueueLatencyTag)
+	assert.Equal(t, tx.ID, payloads.Spans[0].ParentID)
+
+	// The second message has no trace context,
+	// so its transaction starts a new trace.
+	assert.NotEqual(t, tx.TraceID, payloads.Transactions[1].TraceID)
+	assert.Equal(t, model.SpanID{}, payloads.Transactions[1].ParentID)
+}
+
+func TestWrapConsumerGroupHandlerError(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var handler testConsumerGroupHandler
+	handler.consume = func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		return errors.New("boom")
+	}
+	err := apmsarama.WrapConsumerGroupHandler(&handler, apmsarama.WithTracer(tracer)).ConsumeClaim(
+		testConsumerGroupSession{ctx: context.Background()},
+		newTestConsumerGroupClaim(&sarama.ConsumerMessage{Topic: "a"}, &sarama.ConsumerMessage{Topic: "b"}),
+	)
+	assert.EqualError(t, err, "boom")
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, "failure", payloads.Transactions[0].Result)
+	assert.Equal(t, payloads.Transactions[0].ID, payloads.Errors[0].ParentID)
+}
+
+func TestConsumerMessageTraceContext(t *testing.T) {
+	msg := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+		nil,
+		{Key: []byte("elastic-apm-traceparent"), Value: []byte("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")},
+		{Key: []byte("traceparent"), Value: []byte("00-0af7651916cd43dd8448eb211c80319c-00f067aa0ba902b7-01")},
+		{Key: []byte("tracestate"), Value: []byte("acme=x")},
+	}}
+	traceContext, ok := apmsarama.ConsumerMessageTraceContext(msg)
+	require.True(t, ok)
+	assert.Equal(t, apm.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}, traceContext.Span)
+	assert.Equal(t, "acme=x", traceContext.State.String())
+
+	msg.Headers[2].Value = []byte("invalid")
+	traceContext, ok = apmsarama.ConsumerMessageTraceContext(msg)
+	require.True(t, ok)
+	assert.Equal(t, apm.SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31}, traceContext.Span)
+
+	_, ok = apmsarama.ConsumerMessageTraceContext(&sarama.ConsumerMessage{})
+	assert.False(t, ok)
+}
+
+func TestConsumerMessageLinks(t *testing.T) {
+	msgs := []*sarama.ConsumerMessage{{Headers: []*sarama.RecordHeader{
+		{Key: []byte("traceparent"), Value: []byte("00-0af7651916cd43dd8448eb211c80319c-00f067aa0ba902b7-01")},
+	}}, {}, {Headers: []*sarama.RecordHeader{
+		{Key: []byte("elastic-apm-traceparent"), Value: []byte("00-11111111111111111111111111111111-2222222222222222-01")},
+	}}}
+	assert.Equal(t, []apm.SpanLink{{
+		Trace: apm.TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+		Span:  apm.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	}, {
+		Trace: apm.TraceID{0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11},
+		Span:  apm.SpanID{0x22, 0x22, 0x22, 0x22, 0x22, 0x22, 0x22, 0x22},
+	}}, apmsarama.ConsumerMessageLinks(msgs))
+}
+
+type testConsumerGroupHandler struct {
+	consume func(context.Context, *sarama.ConsumerMessage) error
+}
+
+func (*testConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (*testConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h *testConsumerGroupHandler) ConsumeMessage(ctx context.Context, session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) error {
+	return h.consume(ctx, msg)
+}
+
+type testConsumerGroupSession struct {
+	sarama.ConsumerGroupSession
+	ctx context.Context
+}
+
+func (s testConsumerGroupSession) Context() context.Context {
+	return s.ctx
+}
+
+type testConsumerGroupClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func newTestConsumerGroupClaim(msgs ...*sarama.ConsumerMessage) testConsumerGroupClaim {
+	messages := make(chan *sarama.ConsumerMessage, len(msgs))
+	for _, msg := range msgs {
+		messages <- msg
+	}
+	close(messages)
+	return testConsumerGroupClaim{messages: messages}
+}
+
+func (c testConsumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
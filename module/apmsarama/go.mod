@@ -0,0 +1,13 @@
+module go.elastic.co/apm/module/apmsarama
+
+require (
+	github.com/Shopify/sarama v1.24.1
+	github.com/klauspost/cpuid v1.3.1 // indirect
+	github.com/stretchr/testify v1.3.0
+	go.elastic.co/apm v1.3.0
+	go.elastic.co/apm/module/apmhttp v1.3.0
+)
+
+replace go.elastic.co/apm => ../..
+
+replace go.elastic.co/apm/module/apmhttp => ../apmhttp
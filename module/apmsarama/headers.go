@@ -0,0 +1,127 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.13
+
+package apmsarama
+
+import (
+	"strings"
+
+	"github.com/Shopify/sarama"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmhttp"
+)
+
+var (
+	// TraceparentHeader is the record header in which producers record
+	// the trace context of the sending span, in the Elastic format.
+	TraceparentHeader = strings.ToLower(apmhttp.TraceparentHeader)
+
+	// W3CTraceparentHeader is the record header in which producers
+	// record the trace context of the sending span, in the W3C Trace
+	// Context format.
+	W3CTraceparentHeader = strings.ToLower(apmhttp.W3CTraceparentHeader)
+
+	// W3CTracestateHeader is the record header in which producers
+	// record the W3C tracestate of the sending span, if any.
+	W3CTracestateHeader = strings.ToLower(apmhttp.W3CTracestateHeader)
+)
+
+// ConsumerMessageTraceContext returns the trace context recorded in the
+// headers of msg by an instrumented producer, and reports whether it was
+// found and valid. The W3C traceparent header takes precedence over the
+// Elastic traceparent header.
+//
+// The trace context may be used to start a transaction for processing
+// the message, as a continuation of the producer's trace. This is done
+// by handlers wrapped with WrapConsumerGroupHandler.
+func ConsumerMessageTraceContext(msg *sarama.ConsumerMessage) (apm.TraceContext, bool) {
+	var traceparent, elasticTraceparent, tracestate []string
+	for _, header := range msg.Headers {
+		if header == nil {
+			continue
+		}
+		switch string(header.Key) {
+		case W3CTraceparentHeader:
+			traceparent = append(traceparent, string(header.Value))
+		case TraceparentHeader:
+			elasticTraceparent = append(elasticTraceparent, string(header.Value))
+		case W3CTracestateHeader:
+			tracestate = append(tracestate, string(header.Value))
+		}
+	}
+	for _, values := range [][]string{traceparent, elasticTraceparent} {
+		if len(values) == 0 {
+			continue
+		}
+		traceContext, err := apmhttp.ParseTraceparentHeader(values[0])
+		if err != nil || traceContext.Trace.Validate() != nil || traceContext.Span.Validate() != nil {
+			continue
+		}
+		if len(tracestate) > 0 {
+			if state, err := apmhttp.ParseTracestateHeader(tracestate...); err == nil {
+				traceContext.State = state
+			}
+		}
+		return traceContext, true
+	}
+	return apm.TraceContext{}, false
+}
+
+// ConsumerMessageLinks returns a span link for each of msgs with a
+// trace context recorded in its headers, as returned by
+// ConsumerMessageTraceContext, for linking a transaction which
+// processes the messages as a batch to the producers' traces:
+//
+//	tx := apm.DefaultTracer.StartTransactionOptions("process", "messaging", apm.TransactionOptions{
+//		Links: apmsarama.ConsumerMessageLinks(msgs),
+//	})
+func ConsumerMessageLinks(msgs []*sarama.ConsumerMessage) []apm.SpanLink {
+	carriers := make([]apm.TraceContextCarrier, len(msgs))
+	for i, msg := range msgs {
+		msg := msg
+		carriers[i] = apm.TraceContextCarrierFunc(func() (apm.TraceContext, bool) {
+			return ConsumerMessageTraceContext(msg)
+		})
+	}
+	return apm.LinksFromCarriers(carriers)
+}
+
+// setTraceContextHeaders returns headers with the trace context headers
+// set to traceContext, replacing any existing trace context headers.
+// The headers slice is not modified.
+func setTraceContextHeaders(headers []sarama.RecordHeader, traceContext apm.TraceContext) []sarama.RecordHeader {
+	out := make([]sarama.RecordHeader, 0, len(headers)+3)
+	for _, header := range headers {
+		switch string(header.Key) {
+		case TraceparentHeader, W3CTraceparentHeader, W3CTracestateHeader:
+			continue
+		}
+		out = append(out, header)
+	}
+	traceparent := []byte(apmhttp.FormatTraceparentHeader(traceContext))
+	out = append(out,
+		sarama.RecordHeader{Key: []byte(TraceparentHeader), Value: traceparent},
+		sarama.RecordHeader{Key: []byte(W3CTraceparentHeader), Value: traceparent},
+	)
+	if tracestate := traceContext.State.String(); tracestate != "" {
+		out = append(out, sarama.RecordHeader{Key: []byte(W3CTracestateHeader), Value: []byte(tracestate)})
+	}
+	return out
+}
@@ -0,0 +1,266 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.13
+
+package apmsarama
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/Shopify/sarama"
+
+	"go.elastic.co/apm"
+)
+
+// SyncProducer wraps a sarama.SyncProducer, reporting sends as spans.
+type SyncProducer struct {
+	sarama.SyncProducer
+	client sarama.Client
+}
+
+// WrapSyncProducer wraps p such that messages sent with the returned
+// SyncProducer's methods are reported as spans, and carry the trace
+// context in their headers. Headers require Kafka 0.11 or newer,
+// configured with sarama.Config.Version.
+func WrapSyncProducer(p sarama.SyncProducer, o ...ProducerOption) *SyncProducer {
+	opts := newProducerOptions(o...)
+	return &SyncProducer{SyncProducer: p, client: opts.client}
+}
+
+// SendMessage sends msg, reporting a span if ctx contains a transaction.
+// The trace context is added to msg's headers, so that consumers may
+// continue the trace; see ConsumerMessageTraceContext.
+func (p *SyncProducer) SendMessage(ctx context.Context, msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	span, ctx := startSendSpan(ctx, msg)
+	defer span.End()
+	partition, offset, err = p.SyncProducer.SendMessage(msg)
+	finishSendSpan(ctx, span, p.client, msg, err)
+	return partition, offset, err
+}
+
+// SendMessages sends msgs, reporting a span for each message if ctx
+// contains a transaction. See SendMessage for details.
+func (p *SyncProducer) SendMessages(ctx context.Context, msgs []*sarama.ProducerMessage) error {
+	spans := make([]*apm.Span, len(msgs))
+	spanContexts := make([]context.Context, len(msgs))
+	for i, msg := range msgs {
+		spans[i], spanContexts[i] = startSendSpan(ctx, msg)
+	}
+	err := p.SyncProducer.SendMessages(msgs)
+	msgErrors := make(map[*sarama.ProducerMessage]error)
+	if errs, ok := err.(sarama.ProducerErrors); ok {
+		for _, err := range errs {
+			msgErrors[err.Msg] = err.Err
+		}
+	} else if err != nil {
+		for _, msg := range msgs {
+			msgErrors[msg] = err
+		}
+	}
+	for i, msg := range msgs {
+		finishSendSpan(spanContexts[i], spans[i], p.client, msg, msgErrors[msg])
+		spans[i].End()
+	}
+	return err
+}
+
+// AsyncProducer wraps a sarama.AsyncProducer, reporting sends as spans.
+//
+// Messages must be sent with the Send method to be traced; messages
+// sent directly to the Input channel are not traced. The Successes and
+// Errors channels of the wrapped producer must not be used.
+type AsyncProducer struct {
+	sarama.AsyncProducer
+	client    sarama.Client
+	track     bool
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+
+	mu    sync.Mutex
+	spans map[*sarama.ProducerMessage]inflightSend
+}
+
+type inflightSend struct {
+	span *apm.Span
+	ctx  context.Context
+}
+
+// WrapAsyncProducer wraps p, which must have been created with cfg, such
+// that messages sent with the returned AsyncProducer's Send method are
+// reported as spans, and carry the trace context in their headers.
+//
+// If cfg.Producer.Return.Successes and cfg.Producer.Return.Errors are both
+// true, spans end when the message is acknowledged or fails, and record
+// the result. Otherwise, spans end when the message is queued for sending.
+func WrapAsyncProducer(cfg *sarama.Config, p sarama.AsyncProducer, o ...ProducerOption) *AsyncProducer {
+	opts := newProducerOptions(o...)
+	ap := &AsyncProducer{
+		AsyncProducer: p,
+		client:        opts.client,
+		track:         cfg.Producer.Return.Successes && cfg.Producer.Return.Errors,
+		successes:     make(chan *sarama.ProducerMessage, cfg.ChannelBufferSize),
+		errors:        make(chan *sarama.ProducerError, cfg.ChannelBufferSize),
+		spans:         make(map[*sarama.ProducerMessage]inflightSend),
+	}
+	go func() {
+		defer close(ap.successes)
+		for msg := range p.Successes() {
+			ap.finish(msg, nil)
+			ap.successes <- msg
+		}
+	}()
+	go func() {
+		defer close(ap.errors)
+		for err := range p.Errors() {
+			ap.finish(err.Msg, err.Err)
+			ap.errors <- err
+		}
+	}()
+	return ap
+}
+
+// Send queues msg for sending, reporting a span if ctx contains a
+// transaction. The trace context is added to msg's headers, so that
+// consumers may continue the trace; see ConsumerMessageTraceContext.
+//
+// Send blocks until msg is accepted by the producer's Input channel,
+// or ctx is canceled, in which case ctx.Err() is returned.
+func (p *AsyncProducer) Send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	span, spanCtx := startSendSpan(ctx, msg)
+	track := p.track && !span.Dropped()
+	if track {
+		p.mu.Lock()
+		p.spans[msg] = inflightSend{span: span, ctx: spanCtx}
+		p.mu.Unlock()
+	}
+	select {
+	case p.AsyncProducer.Input() <- msg:
+		if !track {
+			span.End()
+		}
+		return nil
+	case <-ctx.Done():
+		if track {
+			p.mu.Lock()
+			delete(p.spans, msg)
+			p.mu.Unlock()
+		}
+		span.End()
+		return ctx.Err()
+	}
+}
+
+// Successes returns the success output channel, in place of
+// the wrapped producer's. See sarama.AsyncProducer.Successes.
+func (p *AsyncProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+// Errors returns the error output channel, in place of
+// the wrapped producer's. See sarama.AsyncProducer.Errors.
+func (p *AsyncProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+func (p *AsyncProducer) finish(msg *sarama.ProducerMessage, err error) {
+	p.mu.Lock()
+	inflight, ok := p.spans[msg]
+	delete(p.spans, msg)
+	p.mu.Unlock()
+	if ok {
+		finishSendSpan(inflight.ctx, inflight.span, p.client, msg, err)
+		inflight.span.End()
+	}
+}
+
+// startSendSpan starts a span for sending msg, and sets the trace
+// context headers of msg to the span's trace context, or the trace
+// context of the transaction in ctx if the span is dropped.
+func startSendSpan(ctx context.Context, msg *sarama.ProducerMessage) (*apm.Span, context.Context) {
+	span, ctx := apm.StartSpanOptions(ctx, "kafka SEND to "+msg.Topic, "messaging.kafka.send", apm.SpanOptions{ExitSpan: true})
+	var traceContext apm.TraceContext
+	if !span.Dropped() {
+		traceContext = span.TraceContext()
+		span.Context.SetTag("kafka_topic", msg.Topic)
+	} else if tx := apm.TransactionFromContext(ctx); tx != nil {
+		traceContext = tx.TraceContext()
+	}
+	if traceContext.Trace.Validate() == nil {
+		msg.Headers = setTraceContextHeaders(msg.Headers, traceContext)
+	}
+	return span, ctx
+}
+
+// finishSendSpan records the result of sending msg in span. If err is
+// non-nil, it is reported as an error. If client is non-nil, the address
+// of the leader for msg's partition is recorded as the destination.
+func finishSendSpan(ctx context.Context, span *apm.Span, client sarama.Client, msg *sarama.ProducerMessage, err error) {
+	if span.Dropped() {
+		return
+	}
+	if err != nil {
+		if e := apm.CaptureError(ctx, err); e != nil {
+			e.Send()
+		}
+		return
+	}
+	span.Context.SetTag("kafka_partition", strconv.FormatInt(int64(msg.Partition), 10))
+	span.Context.SetTag("kafka_offset", strconv.FormatInt(msg.Offset, 10))
+	if client != nil {
+		if broker, err := client.Leader(msg.Topic, msg.Partition); err == nil {
+			setDestination(span, broker.Addr())
+		}
+	}
+}
+
+// ProducerOption sets options for tracing producers.
+type ProducerOption func(*producerOptions)
+
+type producerOptions struct {
+	client sarama.Client
+}
+
+func newProducerOptions(o ...ProducerOption) producerOptions {
+	var opts producerOptions
+	for _, o := range o {
+		o(&opts)
+	}
+	return opts
+}
+
+// WithClient returns a ProducerOption which sets the client used by the
+// producer, e.g. the client passed to sarama.NewSyncProducerFromClient.
+// The client is used to record the address of the partition leader which
+// accepted each message as the span destination.
+func WithClient(client sarama.Client) ProducerOption {
+	return func(o *producerOptions) {
+		o.client = client
+	}
+}
+
+func setDestination(span *apm.Span, addr string) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	port, _ := strconv.Atoi(portString)
+	span.Context.SetDestinationAddress(host, port)
+}
@@ -0,0 +1,228 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.13
+
+package apmsarama_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmsarama"
+)
+
+func TestSyncProducerSendMessage(t *testing.T) {
+	mockProducer := mocks.NewSyncProducer(t, nil)
+	mockProducer.ExpectSendMessageAndSucceed()
+	producer := apmsarama.WrapSyncProducer(mockProducer)
+	defer producer.Close()
+
+	msg := &sarama.ProducerMessage{
+		Topic:   "orders",
+		Value:   sarama.StringEncoder("value"),
+		Headers: []sarama.RecordHeader{{Key: []byte("traceparent"), Value: []byte("stale")}},
+	}
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		_, _, err := producer.SendMessage(ctx, msg)
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Empty(t, errs)
+	assert.Equal(t, "kafka SEND to orders", spans[0].Name)
+	assert.Equal(t, "messaging", spans[0].Type)
+	assert.Equal(t, "kafka", spans[0].Subtype)
+	assert.Equal(t, "send", spans[0].Action)
+	assert.Equal(t, model.StringMap{
+		{Key: "kafka_offset", Value: "1"},
+		{Key: "kafka_partition", Value: "0"},
+		{Key: "kafka_topic", Value: "orders"},
+	}, spans[0].Context.Tags)
+	assert.Nil(t, spans[0].Context.Destination)
+
+	traceContext, ok := apmsarama.ConsumerMessageTraceContext(consumerMessage(msg))
+	require.True(t, ok)
+	assert.Equal(t, spans[0].TraceID, model.TraceID(traceContext.Trace))
+	assert.Equal(t, spans[0].ID, model.SpanID(traceContext.Span))
+	assert.Len(t, msg.Headers, 2)
+}
+
+func TestSyncProducerSendMessageError(t *testing.T) {
+	mockProducer := mocks.NewSyncProducer(t, nil)
+	mockProducer.ExpectSendMessageAndFail(errors.New("boom"))
+	producer := apmsarama.WrapSyncProducer(mockProducer)
+	defer producer.Close()
+
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		_, _, err := producer.SendMessage(ctx, &sarama.ProducerMessage{Topic: "orders"})
+		assert.EqualError(t, err, "boom")
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "boom", errs[0].Exception.Message)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+	assert.Equal(t, model.StringMap{{Key: "kafka_topic", Value: "orders"}}, spans[0].Context.Tags)
+}
+
+func TestSyncProducerSendMessages(t *testing.T) {
+	mockProducer := mocks.NewSyncProducer(t, nil)
+	mockProducer.ExpectSendMessageAndSucceed()
+	mockProducer.ExpectSendMessageAndSucceed()
+	producer := apmsarama.WrapSyncProducer(mockProducer)
+	defer producer.Close()
+
+	msgs := []*sarama.ProducerMessage{{Topic: "a"}, {Topic: "b"}}
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		require.NoError(t, producer.SendMessages(ctx, msgs))
+	})
+	require.Len(t, spans, 2)
+	assert.Equal(t, "kafka SEND to a", spans[0].Name)
+	assert.Equal(t, "kafka SEND to b", spans[1].Name)
+	for i, msg := range msgs {
+		traceContext, ok := apmsarama.ConsumerMessageTraceContext(consumerMessage(msg))
+		require.True(t, ok)
+		assert.Equal(t, spans[i].ID, model.SpanID(traceContext.Span))
+	}
+}
+
+func TestSyncProducerWithClient(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()),
+	})
+	client, err := sarama.NewClient([]string{broker.Addr()}, sarama.NewConfig())
+	require.NoError(t, err)
+	defer client.Close()
+
+	mockProducer := mocks.NewSyncProducer(t, nil)
+	mockProducer.ExpectSendMessageAndSucceed()
+	producer := apmsarama.WrapSyncProducer(mockProducer, apmsarama.WithClient(client))
+	defer producer.Close()
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		_, _, err := producer.SendMessage(ctx, &sarama.ProducerMessage{Topic: "orders"})
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+
+	host, portString, err := net.SplitHostPort(broker.Addr())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portString)
+	require.NoError(t, err)
+	assert.Equal(t, &model.DestinationSpanContext{Address: host, Port: port}, spans[0].Context.Destination)
+}
+
+func TestAsyncProducerSend(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+	mockProducer := mocks.NewAsyncProducer(t, cfg)
+	mockProducer.ExpectInputAndSucceed()
+	mockProducer.ExpectInputAndFail(errors.New("boom"))
+	producer := apmsarama.WrapAsyncProducer(cfg, mockProducer)
+
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		msg1 := &sarama.ProducerMessage{Topic: "orders"}
+		require.NoError(t, producer.Send(ctx, msg1))
+		assert.Equal(t, msg1, <-producer.Successes())
+
+		msg2 := &sarama.ProducerMessage{Topic: "orders"}
+		require.NoError(t, producer.Send(ctx, msg2))
+		err := <-producer.Errors()
+		assert.Equal(t, msg2, err.Msg)
+	})
+	require.NoError(t, producer.Close())
+
+	require.Len(t, spans, 2)
+	require.Len(t, errs, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "kafka_offset", Value: "1"},
+		{Key: "kafka_partition", Value: "0"},
+		{Key: "kafka_topic", Value: "orders"},
+	}, spans[0].Context.Tags)
+	assert.Equal(t, model.StringMap{{Key: "kafka_topic", Value: "orders"}}, spans[1].Context.Tags)
+	assert.Equal(t, spans[1].ID, errs[0].ParentID)
+
+	_, ok := <-producer.Successes()
+	assert.False(t, ok)
+	_, ok = <-producer.Errors()
+	assert.False(t, ok)
+}
+
+func TestAsyncProducerSendUntracked(t *testing.T) {
+	cfg := sarama.NewConfig()
+	mockProducer := mocks.NewAsyncProducer(t, cfg)
+	mockProducer.ExpectInputAndSucceed()
+	producer := apmsarama.WrapAsyncProducer(cfg, mockProducer)
+
+	msg := &sarama.ProducerMessage{Topic: "orders"}
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		require.NoError(t, producer.Send(ctx, msg))
+	})
+	require.NoError(t, producer.Close())
+
+	// Successes are not returned, so the span
+	// ends once the message has been queued.
+	require.Len(t, spans, 1)
+	assert.Equal(t, model.StringMap{{Key: "kafka_topic", Value: "orders"}}, spans[0].Context.Tags)
+	_, ok := apmsarama.ConsumerMessageTraceContext(consumerMessage(msg))
+	assert.True(t, ok)
+}
+
+func TestAsyncProducerSendContextCanceled(t *testing.T) {
+	cfg := sarama.NewConfig()
+	mockProducer := mocks.NewAsyncProducer(t, cfg)
+	defer mockProducer.Close()
+	producer := apmsarama.WrapAsyncProducer(cfg, blockingAsyncProducer{mockProducer})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := producer.Send(ctx, &sarama.ProducerMessage{Topic: "orders"})
+	assert.Equal(t, context.Canceled, err)
+}
+
+// blockingAsyncProducer is a sarama.AsyncProducer
+// whose Input channel never accepts messages.
+type blockingAsyncProducer struct {
+	sarama.AsyncProducer
+}
+
+func (blockingAsyncProducer) Input() chan<- *sarama.ProducerMessage {
+	return nil
+}
+
+// consumerMessage returns a sarama.ConsumerMessage
+// with the topic and headers of msg.
+func consumerMessage(msg *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	headers := make([]*sarama.RecordHeader, len(msg.Headers))
+	for i := range msg.Headers {
+		headers[i] = &msg.Headers[i]
+	}
+	return &sarama.ConsumerMessage{Topic: msg.Topic, Headers: headers}
+}
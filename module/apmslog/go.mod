@@ -0,0 +1,8 @@
+module go.elastic.co/apm/module/apmslog
+
+require (
+	github.com/stretchr/testify v1.3.0
+	go.elastic.co/apm v1.3.0
+)
+
+replace go.elastic.co/apm => ../..
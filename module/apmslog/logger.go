@@ -0,0 +1,93 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.21
+
+package apmslog
+
+import (
+	"context"
+	"log/slog"
+
+	"go.elastic.co/apm/apmlogfields"
+)
+
+const (
+	// FieldKeyTraceID is the default field key for the trace ID.
+	// The field keys may be changed with apmlogfields.SetNames.
+	FieldKeyTraceID = apmlogfields.DefaultTraceID
+
+	// FieldKeyTransactionID is the default field key for the transaction ID.
+	FieldKeyTransactionID = apmlogfields.DefaultTransactionID
+
+	// FieldKeySpanID is the default field key for the span ID.
+	FieldKeySpanID = apmlogfields.DefaultSpanID
+)
+
+type loggerKey struct{}
+
+// TraceContext returns slog.Attrs containing the trace context of the
+// transaction and span contained in ctx, if any. The attributes are
+// returned as a []any, for passing to slog.Logger.With or the logging
+// methods.
+func TraceContext(ctx context.Context) []any {
+	values, names, ok := apmlogfields.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	attrs := []any{
+		slog.String(names.TraceID, values.TraceID.String()),
+		slog.String(names.TransactionID, values.TransactionID.String()),
+	}
+	if values.HasSpan {
+		attrs = append(attrs, slog.String(names.SpanID, values.SpanID.String()))
+	}
+	if names.ServiceName != "" {
+		attrs = append(attrs, slog.String(names.ServiceName, values.ServiceName))
+	}
+	if names.ServiceEnvironment != "" {
+		attrs = append(attrs, slog.String(names.ServiceEnvironment, values.ServiceEnvironment))
+	}
+	return attrs
+}
+
+// ContextWithLogger returns a copy of parent in which logger is stored,
+// to be returned with trace context attributes by FromContext.
+func ContextWithLogger(parent context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(parent, loggerKey{}, logger)
+}
+
+// FromContext returns a logger with the attributes returned by
+// TraceContext for the transaction and span contained in ctx, so log
+// records can be correlated with the trace without passing the
+// attributes on each call. Service name and environment attributes
+// are included if their names are set with apmlogfields.SetNames.
+//
+// The returned logger is derived from the logger stored in ctx with
+// ContextWithLogger, or the default logger returned by slog.Default
+// if there is none. If ctx does not contain a transaction, the logger
+// is returned without additional attributes.
+func FromContext(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	if !ok || logger == nil {
+		logger = slog.Default()
+	}
+	if attrs := TraceContext(ctx); len(attrs) != 0 {
+		logger = logger.With(attrs...)
+	}
+	return logger
+}
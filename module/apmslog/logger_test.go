@@ -0,0 +1,105 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.21
+
+package apmslog_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmlogfields"
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/module/apmslog"
+)
+
+func TestTraceContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf)
+
+	tx, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		span, ctx := apm.StartSpan(ctx, "name", "type")
+		defer span.End()
+		logger.With(apmslog.TraceContext(ctx)...).Debug("beep")
+		logger.Debug("beep", apmslog.TraceContext(ctx)...)
+	})
+	require.Len(t, spans, 1)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	for _, line := range lines {
+		assert.Equal(t, fmt.Sprintf(
+			`{"level":"DEBUG","msg":"beep","trace.id":"%x","transaction.id":"%x","span.id":"%x"}`,
+			tx.TraceID[:], tx.ID[:], spans[0].ID[:],
+		), line)
+	}
+}
+
+func TestTraceContextEmpty(t *testing.T) {
+	// apmslog.TraceContext will return nil if the context does not contain a transaction.
+	assert.Nil(t, apmslog.TraceContext(context.Background()))
+}
+
+func TestFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf)
+
+	apmlogfields.SetNames(apmlogfields.Names{ServiceName: "service.name"})
+	defer apmlogfields.SetNames(apmlogfields.Names{})
+
+	tx, _, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx = apmslog.ContextWithLogger(ctx, logger)
+		apmslog.FromContext(ctx).Debug("beep")
+	})
+	assert.Equal(t, fmt.Sprintf(
+		`{"level":"DEBUG","msg":"beep","trace.id":"%x","transaction.id":"%x","service.name":"transporttest"}`+"\n",
+		tx.TraceID[:], tx.ID[:],
+	), buf.String())
+}
+
+func TestFromContextEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf)
+
+	ctx := apmslog.ContextWithLogger(context.Background(), logger)
+	apmslog.FromContext(ctx).Debug("beep")
+	assert.Equal(t, `{"level":"DEBUG","msg":"beep"}`+"\n", buf.String())
+
+	// Without a logger in the context, the default logger is used.
+	assert.Equal(t, slog.Default(), apmslog.FromContext(context.Background()))
+}
+
+func newLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
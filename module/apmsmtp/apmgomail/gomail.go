@@ -0,0 +1,83 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.16
+
+// Package apmgomail provides helpers for tracing github.com/wneessen/go-mail
+// sends as spans.
+package apmgomail
+
+import (
+	"context"
+
+	"github.com/wneessen/go-mail"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmsmtp"
+)
+
+// DialAndSend calls c.DialAndSendWithContext(ctx, msgs...), and also
+// reports the send as an exit span with apmsmtp.StartSpan, and any error
+// it returns with apm.CaptureError.
+//
+// The span is tagged with the total number of recipients and the total
+// size of the messages. Computing the size requires rendering each
+// message an additional time, which is done only if ctx contains a
+// sampled transaction.
+func DialAndSend(ctx context.Context, c *mail.Client, msgs ...*mail.Msg) error {
+	return send(ctx, c, msgs, func(ctx context.Context) error {
+		return c.DialAndSendWithContext(ctx, msgs...)
+	})
+}
+
+// Send calls c.Send(msgs...) on an already connected client, and also
+// reports the send as described for DialAndSend.
+func Send(ctx context.Context, c *mail.Client, msgs ...*mail.Msg) error {
+	return send(ctx, c, msgs, func(context.Context) error {
+		return c.Send(msgs...)
+	})
+}
+
+func send(ctx context.Context, c *mail.Client, msgs []*mail.Msg, fn func(context.Context) error) error {
+	if apm.TransactionFromContext(ctx).Sampled() {
+		var recipients, size int
+		for _, m := range msgs {
+			if rcpts, err := m.GetRecipients(); err == nil {
+				recipients += len(rcpts)
+			}
+			var w countingWriter
+			m.WriteTo(&w)
+			size += int(w)
+		}
+		var span *apm.Span
+		span, ctx = apmsmtp.StartSpan(ctx, c.ServerAddr(), recipients, size)
+		defer span.End()
+	}
+	err := fn(ctx)
+	if e := apm.CaptureError(ctx, err); e != nil {
+		e.Send()
+	}
+	return err
+}
+
+// countingWriter is an io.Writer which counts the bytes written to it.
+type countingWriter int
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,137 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.16
+
+package apmgomail_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wneessen/go-mail"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmsmtp/apmgomail"
+	"go.elastic.co/apm/module/apmsmtp/internal/smtptest"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestDialAndSend(t *testing.T) {
+	server := smtptest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+
+	msg1 := newMsg(t, "alice@example.com", "bob@example.com")
+	msg2 := newMsg(t, "carol@example.com")
+	var buf bytes.Buffer
+	msg1.WriteTo(&buf)
+	msg2.WriteTo(&buf)
+
+	_, spans, errors := apmtest.WithTransaction(func(ctx context.Context) {
+		err := apmgomail.DialAndSend(ctx, client, msg1, msg2)
+		require.NoError(t, err)
+	})
+	assert.Empty(t, errors)
+	require.Len(t, spans, 1)
+	assert.Equal(t, "SMTP send", spans[0].Name)
+	assert.Equal(t, "external", spans[0].Type)
+	assert.Equal(t, "smtp", spans[0].Subtype)
+	assert.Equal(t, "127.0.0.1", spans[0].Context.Destination.Address)
+	assert.Equal(t, client.ServerAddr(), net.JoinHostPort("127.0.0.1", strconv.Itoa(spans[0].Context.Destination.Port)))
+	assert.Equal(t, model.StringMap{
+		{Key: "smtp_message_size", Value: strconv.Itoa(buf.Len())},
+		{Key: "smtp_recipients", Value: "3"},
+	}, spans[0].Context.Tags)
+	assert.Len(t, server.Messages(), 2)
+}
+
+func TestDialAndSendError(t *testing.T) {
+	server := smtptest.NewServer()
+	server.RejectRecipients = true
+	defer server.Close()
+	client := newClient(t, server)
+	defer client.Close() // go-mail leaves the connection open on error
+
+	_, spans, errors := apmtest.WithTransaction(func(ctx context.Context) {
+		err := apmgomail.DialAndSend(ctx, client, newMsg(t, "alice@example.com"))
+		assert.Error(t, err)
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errors, 1)
+	assert.Equal(t, spans[0].ID, errors[0].ParentID)
+}
+
+func TestDialAndSendErrorUnsampled(t *testing.T) {
+	server := smtptest.NewServer()
+	server.RejectRecipients = true
+	defer server.Close()
+	client := newClient(t, server)
+	defer client.Close()
+
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewRatioSampler(0))
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	err := apmgomail.DialAndSend(ctx, client, newMsg(t, "alice@example.com"))
+	assert.Error(t, err)
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	assert.Empty(t, payloads.Spans)
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, payloads.Transactions[0].ID, payloads.Errors[0].ParentID)
+}
+
+func TestSendNoTransaction(t *testing.T) {
+	server := smtptest.NewServer()
+	defer server.Close()
+	client := newClient(t, server)
+
+	err := apmgomail.DialAndSend(context.Background(), client, newMsg(t, "alice@example.com"))
+	require.NoError(t, err)
+	assert.Len(t, server.Messages(), 1)
+}
+
+func newClient(t *testing.T, server *smtptest.Server) *mail.Client {
+	host, portString, err := net.SplitHostPort(server.Addr)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portString)
+	require.NoError(t, err)
+	client, err := mail.NewClient(host, mail.WithPort(port), mail.WithTLSPolicy(mail.NoTLS))
+	require.NoError(t, err)
+	return client
+}
+
+func newMsg(t *testing.T, to ...string) *mail.Msg {
+	m := mail.NewMsg()
+	require.NoError(t, m.From("root@example.com"))
+	require.NoError(t, m.To(to...))
+	m.Subject("hello")
+	m.SetBodyString(mail.TypeTextPlain, "world")
+	return m
+}
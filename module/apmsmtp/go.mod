@@ -0,0 +1,9 @@
+module go.elastic.co/apm/module/apmsmtp
+
+require (
+	github.com/stretchr/testify v1.2.2
+	github.com/wneessen/go-mail v0.4.0
+	go.elastic.co/apm v1.3.0
+)
+
+replace go.elastic.co/apm => ../..
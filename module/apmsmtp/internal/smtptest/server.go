@@ -0,0 +1,163 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.10
+
+// Package smtptest provides a minimal SMTP server for testing.
+package smtptest
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Message holds a message received by a Server.
+type Message struct {
+	From string
+	To   []string
+	Data string
+}
+
+// Server is a minimal SMTP server, which accepts all messages
+// without authentication.
+type Server struct {
+	Addr string
+
+	// RejectRecipients, if true, causes all RCPT commands to be
+	// rejected with a permanent error.
+	RejectRecipients bool
+
+	listener net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewServer starts and returns a new Server listening on a
+// loopback address. The server must be closed with Close.
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{Addr: l.Addr().String(), listener: l}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+	return s
+}
+
+// Close closes the server's listener, and waits for all
+// connections to be closed.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// Messages returns the messages received by the server.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	tc := textproto.NewConn(conn)
+	tc.PrintfLine("220 smtptest ready")
+
+	var msg Message
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			tc.PrintfLine("250 smtptest")
+		case "MAIL":
+			msg = Message{From: addressArg(line)}
+			tc.PrintfLine("250 OK")
+		case "RCPT":
+			if s.RejectRecipients {
+				tc.PrintfLine("550 mailbox unavailable")
+				continue
+			}
+			msg.To = append(msg.To, addressArg(line))
+			tc.PrintfLine("250 OK")
+		case "DATA":
+			tc.PrintfLine("354 go ahead")
+			data, err := readData(tc.R)
+			if err != nil {
+				return
+			}
+			msg.Data = data
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			tc.PrintfLine("250 OK")
+		case "RSET", "NOOP":
+			tc.PrintfLine("250 OK")
+		case "QUIT":
+			tc.PrintfLine("221 bye")
+			return
+		default:
+			tc.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func addressArg(line string) string {
+	if i := strings.IndexRune(line, '<'); i >= 0 {
+		if j := strings.IndexRune(line[i:], '>'); j >= 0 {
+			return line[i+1 : i+j]
+		}
+	}
+	return ""
+}
+
+func readData(r *bufio.Reader) (string, error) {
+	var data strings.Builder
+	tr := textproto.NewReader(r)
+	for {
+		line, err := tr.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		if line == "." {
+			return data.String(), nil
+		}
+		data.WriteString(strings.TrimPrefix(line, "."))
+		data.WriteString("\r\n")
+	}
+}
@@ -0,0 +1,62 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmsmtp
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strconv"
+
+	"go.elastic.co/apm"
+)
+
+// SendMail calls smtp.SendMail(addr, a, from, to, msg), and also reports
+// the send as an exit span with StartSpan, and any error it returns with
+// apm.CaptureError.
+func SendMail(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	span, ctx := StartSpan(ctx, addr, len(to), len(msg))
+	defer span.End()
+	err := smtp.SendMail(addr, a, from, to, msg)
+	if e := apm.CaptureError(ctx, err); e != nil {
+		e.Send()
+	}
+	return err
+}
+
+// StartSpan starts and returns an exit span for sending mail through the
+// server at addr, which is expected to be in "host:port" form, along with
+// a context containing the span.
+//
+// The span's destination is set to the mail server, and it is tagged with
+// the number of recipients and the message size in bytes. StartSpan is
+// intended for use by wrappers of other mail-sending libraries.
+func StartSpan(ctx context.Context, addr string, recipients, size int) (*apm.Span, context.Context) {
+	span, ctx := apm.StartSpanOptions(ctx, "SMTP send", "external.smtp", apm.SpanOptions{ExitSpan: true})
+	if !span.Dropped() {
+		host, portString, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		port, _ := strconv.Atoi(portString)
+		span.Context.SetDestinationAddress(host, port)
+		span.Context.SetTag("smtp_recipients", strconv.Itoa(recipients))
+		span.Context.SetTag("smtp_message_size", strconv.Itoa(size))
+	}
+	return span, ctx
+}
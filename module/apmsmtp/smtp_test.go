@@ -0,0 +1,80 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.10
+
+package apmsmtp_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmsmtp"
+	"go.elastic.co/apm/module/apmsmtp/internal/smtptest"
+)
+
+func TestSendMail(t *testing.T) {
+	server := smtptest.NewServer()
+	defer server.Close()
+
+	msg := []byte("Subject: hello\r\n\r\nworld\r\n")
+	to := []string{"alice@example.com", "bob@example.com"}
+	tx, spans, errors := apmtest.WithTransaction(func(ctx context.Context) {
+		err := apmsmtp.SendMail(ctx, server.Addr, nil, "root@example.com", to, msg)
+		require.NoError(t, err)
+	})
+	assert.Empty(t, errors)
+	require.Len(t, spans, 1)
+	assert.Equal(t, tx.ID, spans[0].ParentID)
+	assert.Equal(t, "SMTP send", spans[0].Name)
+	assert.Equal(t, "external", spans[0].Type)
+	assert.Equal(t, "smtp", spans[0].Subtype)
+
+	host, portString, _ := net.SplitHostPort(server.Addr)
+	port, _ := strconv.Atoi(portString)
+	assert.Equal(t, &model.DestinationSpanContext{Address: host, Port: port}, spans[0].Context.Destination)
+	assert.Equal(t, model.StringMap{
+		{Key: "smtp_message_size", Value: strconv.Itoa(len(msg))},
+		{Key: "smtp_recipients", Value: "2"},
+	}, spans[0].Context.Tags)
+
+	messages := server.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, to, messages[0].To)
+}
+
+func TestSendMailError(t *testing.T) {
+	server := smtptest.NewServer()
+	server.RejectRecipients = true
+	defer server.Close()
+
+	_, spans, errors := apmtest.WithTransaction(func(ctx context.Context) {
+		err := apmsmtp.SendMail(ctx, server.Addr, nil, "root@example.com", []string{"alice@example.com"}, []byte("hello"))
+		assert.Error(t, err)
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errors, 1)
+	assert.Equal(t, spans[0].ID, errors[0].ParentID)
+	assert.Contains(t, errors[0].Exception.Message, "mailbox unavailable")
+}
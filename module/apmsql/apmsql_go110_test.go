@@ -47,3 +47,21 @@ func TestConnect(t *testing.T) {
 	assert.Equal(t, "ping", spans[1].Name)
 	assert.Equal(t, "ping", spans[1].Action)
 }
+
+func TestConnectDestination(t *testing.T) {
+	db, err := apmsql.Open("sqlite3_proxied", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		err := db.PingContext(ctx)
+		assert.NoError(t, err)
+	})
+	require.Len(t, spans, 2)
+	assert.Equal(t, "connect", spans[0].Name)
+	require.NotNil(t, spans[0].Context)
+	assert.Equal(t, "main", spans[0].Context.Database.Instance)
+	assert.Equal(t, "alice", spans[0].Context.Database.User)
+	assert.Equal(t, "db.local", spans[0].Context.Destination.Address)
+	assert.Equal(t, 5432, spans[0].Context.Destination.Port)
+}
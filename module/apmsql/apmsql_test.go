@@ -22,6 +22,7 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"testing"
+	"time"
 
 	sqlite3 "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
@@ -35,6 +36,32 @@ import (
 
 func init() {
 	apmsql.Register("sqlite3_test", &sqlite3TestDriver{})
+	apmsql.Register("sqlite3_replica", &sqlite3.SQLiteDriver{}, apmsql.WithRole("replica"))
+	apmsql.Register("sqlite3_dsnrole", &sqlite3.SQLiteDriver{},
+		apmsql.WithRole("replica"),
+		apmsql.WithDSNParser(func(dsn string) apmsql.DSNInfo {
+			return apmsql.DSNInfo{Role: "primary"}
+		}),
+	)
+	apmsql.Register("sqlite3_pooled", &sqlite3.SQLiteDriver{},
+		apmsql.WithPoolMode("transaction"),
+		apmsql.WithDSNParser(func(dsn string) apmsql.DSNInfo {
+			return apmsql.DSNInfo{Address: "pgbouncer.local", Port: 6432}
+		}),
+	)
+	apmsql.Register("sqlite3_pooled_socket", &sqlite3.SQLiteDriver{},
+		apmsql.WithPoolMode("transaction"),
+		apmsql.WithDSNParser(func(dsn string) apmsql.DSNInfo {
+			return apmsql.DSNInfo{Address: "/var/run/pgbouncer"}
+		}),
+	)
+	apmsql.Register("sqlite3_proxied", &sqlite3.SQLiteDriver{},
+		apmsql.WithPoolMode("transaction"),
+		apmsql.WithDestination("db.local", 5432),
+		apmsql.WithDSNParser(func(dsn string) apmsql.DSNInfo {
+			return apmsql.DSNInfo{Database: "main", User: "alice", Address: "pgbouncer.local", Port: 6432}
+		}),
+	)
 }
 
 func TestPingContext(t *testing.T) {
@@ -101,6 +128,31 @@ func TestQueryContext(t *testing.T) {
 	}, spans[0].Context)
 }
 
+func TestExecContextBatchInsert(t *testing.T) {
+	db, err := apmsql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE foo (bar INT, baz TEXT)")
+	require.NoError(t, err)
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := db.ExecContext(ctx, "INSERT INTO foo (bar, baz) VALUES (1, 'a'), (2, 'b'), (3, 'c')")
+		require.NoError(t, err)
+		_, err = db.ExecContext(ctx, "INSERT INTO foo (bar, baz) VALUES (4, 'd')")
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "INSERT INTO foo", spans[0].Name)
+	assert.Equal(t, "INSERT INTO foo (bar, baz) VALUES (...) x3", spans[0].Context.Database.Statement)
+	assert.Equal(t, model.StringMap{{Key: "db_insert_rows", Value: "3"}}, spans[0].Context.Tags)
+
+	// Single-row statements are recorded as-is.
+	assert.Equal(t, "INSERT INTO foo (bar, baz) VALUES (4, 'd')", spans[1].Context.Database.Statement)
+	assert.Empty(t, spans[1].Context.Tags)
+}
+
 func TestPrepareContext(t *testing.T) {
 	db, err := apmsql.Open("sqlite3", ":memory:")
 	require.NoError(t, err)
@@ -213,6 +265,7 @@ func TestCaptureErrors(t *testing.T) {
 	assert.Equal(t, "db", spans[0].Type)
 	assert.Equal(t, "sqlite3", spans[0].Subtype)
 	assert.Equal(t, "query", spans[0].Action)
+	assert.Equal(t, model.StringMap{{Key: "db_error", Value: "driver"}}, spans[0].Context.Tags)
 	assert.Equal(t, "no such table: thin_air", errors[0].Exception.Message)
 }
 
@@ -262,6 +315,119 @@ func TestContextCanceled(t *testing.T) {
 	assert.Len(t, errors, 0) // no "context canceled" errors reported
 }
 
+func TestContextDeadlineExceeded(t *testing.T) {
+	db, err := apmsql.Open("sqlite3_test", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	defer func() { testQueryContext = nil }()
+	testQueryContext = func(ctx context.Context, conn *sqlite3.SQLiteConn, query string, args []driver.NamedValue) (driver.Rows, error) {
+		return nil, context.DeadlineExceeded
+	}
+
+	db.Ping() // connect
+	_, spans, errors := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		_, err := db.QueryContext(ctx, "SELECT * FROM foo")
+		require.Error(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "db_error", Value: "deadline_exceeded"},
+		{Key: "db_timeout", Value: "1m0s"},
+	}, spans[0].Context.Tags)
+
+	require.Len(t, errors, 1)
+	assert.Equal(t, "context deadline exceeded", errors[0].Culprit)
+	assert.Equal(t, "context deadline exceeded", errors[0].Exception.Message)
+}
+
+func TestRole(t *testing.T) {
+	for driverName, role := range map[string]string{
+		"sqlite3_replica": "replica",
+		"sqlite3_dsnrole": "primary",
+	} {
+		db, err := apmsql.Open(driverName, ":memory:")
+		require.NoError(t, err)
+		defer db.Close()
+
+		db.Ping() // connect
+		_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+			_, err := db.ExecContext(ctx, "CREATE TABLE foo (bar INT)")
+			require.NoError(t, err)
+		})
+		require.Len(t, spans, 1)
+		assert.Equal(t, model.StringMap{{Key: "db_role", Value: role}}, spans[0].Context.Tags)
+	}
+}
+
+func TestPoolMode(t *testing.T) {
+	test := func(driverName, pooler, address string) {
+		db, err := apmsql.Open(driverName, ":memory:")
+		require.NoError(t, err)
+		defer db.Close()
+
+		db.Ping() // connect
+		_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+			stmt, err := db.PrepareContext(ctx, "SELECT 1")
+			require.NoError(t, err)
+			defer stmt.Close()
+			_, err = stmt.ExecContext(ctx)
+			require.NoError(t, err)
+		})
+		require.Len(t, spans, 1) // no prepare span
+		assert.Equal(t, "exec", spans[0].Action)
+		assert.Equal(t, model.StringMap{
+			{Key: "db_pool_mode", Value: "transaction"},
+			{Key: "db_pooler", Value: pooler},
+		}, spans[0].Context.Tags)
+		assert.Equal(t, address, spans[0].Context.Destination.Address)
+	}
+	test("sqlite3_pooled", "pgbouncer.local:6432", "pgbouncer.local")
+
+	// Addresses without a port, such as Unix socket
+	// paths, are recorded as the pooler as they are.
+	test("sqlite3_pooled_socket", "/var/run/pgbouncer", "/var/run/pgbouncer")
+}
+
+func TestDestination(t *testing.T) {
+	db, err := apmsql.Open("sqlite3_proxied", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	db.Ping() // connect
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := db.ExecContext(ctx, "CREATE TABLE foo (bar INT)")
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, &model.SpanContext{
+		Database: &model.DatabaseSpanContext{
+			Instance:  "main",
+			Statement: "CREATE TABLE foo (bar INT)",
+			Type:      "sql",
+			User:      "alice",
+		},
+		Destination: &model.DestinationSpanContext{
+			Address: "db.local",
+			Port:    5432,
+		},
+		Tags: model.StringMap{
+			{Key: "db_pool_mode", Value: "transaction"},
+			{Key: "db_pooler", Value: "pgbouncer.local:6432"},
+		},
+	}, spans[0].Context)
+}
+
+func TestDriverDSNParserDestination(t *testing.T) {
+	info := apmsql.DriverDSNParser("sqlite3_proxied")("")
+	assert.Equal(t, "main", info.Database)
+	assert.Equal(t, "alice", info.User)
+	assert.Equal(t, "db.local", info.Address)
+	assert.Equal(t, 5432, info.Port)
+}
+
 type sqlite3TestDriver struct {
 	sqlite3.SQLiteDriver
 }
@@ -21,8 +21,12 @@ import (
 	"context"
 	"database/sql/driver"
 	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/internal/sqlutil"
 )
 
 func newConn(in driver.Conn, d *tracingDriver, dsnInfo DSNInfo) driver.Conn {
@@ -64,18 +68,60 @@ func (c *conn) startStmtSpan(ctx context.Context, stmt, spanType string) (*apm.S
 }
 
 func (c *conn) startSpan(ctx context.Context, name, spanType, stmt string) (*apm.Span, context.Context) {
-	span, ctx := apm.StartSpan(ctx, name, spanType)
+	span, ctx := apm.StartSpanOptions(ctx, name, spanType, apm.SpanOptions{ExitSpan: true})
 	if !span.Dropped() {
+		// Multi-row INSERT statements may be megabytes long, so
+		// record a summary of the statement and its row count.
+		summary, rows, summarized := sqlutil.SummarizeInsert(stmt)
+		if summarized {
+			stmt = summary
+		}
 		span.Context.SetDatabase(apm.DatabaseSpanContext{
 			Instance:  c.dsnInfo.Database,
 			Statement: stmt,
 			Type:      "sql",
 			User:      c.dsnInfo.User,
 		})
+		if c.dsnInfo.Address != "" {
+			span.Context.SetDestinationAddress(c.dsnInfo.Address, c.dsnInfo.Port)
+		}
+		if summarized {
+			span.Context.SetTag("db_insert_rows", strconv.Itoa(rows))
+		}
+		if c.dsnInfo.Role != "" {
+			span.Context.SetTag("db_role", c.dsnInfo.Role)
+		}
+		if c.dsnInfo.PoolMode != "" {
+			// Unless overridden with WithDestination, the destination
+			// address identifies the pooler, not the database server.
+			span.Context.SetTag("db_pool_mode", c.dsnInfo.PoolMode)
+			if c.dsnInfo.pooler != "" {
+				span.Context.SetTag("db_pooler", c.dsnInfo.pooler)
+			}
+		}
+		if state := retryStateFromContext(ctx); state != nil {
+			span.Context.SetTag("db_attempt", strconv.Itoa(state.attempt))
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			// Record the time remaining until the context's deadline,
+			// which is the effective timeout for the operation.
+			timeout := roundMillisecond(time.Until(deadline))
+			span.Context.SetTag("db_timeout", timeout.String())
+		}
 	}
 	return span, ctx
 }
 
+// roundMillisecond rounds d to the nearest millisecond, rounding
+// halfway values away from zero, as with Duration.Round in Go 1.9+.
+func roundMillisecond(d time.Duration) time.Duration {
+	half := time.Millisecond / 2
+	if d < 0 {
+		half = -half
+	}
+	return (d + half) / time.Millisecond * time.Millisecond
+}
+
 func (c *conn) finishSpan(ctx context.Context, span *apm.Span, resultError *error) {
 	if *resultError == driver.ErrSkip {
 		// TODO(axw) mark span as abandoned,
@@ -85,22 +131,73 @@ func (c *conn) finishSpan(ctx context.Context, span *apm.Span, resultError *erro
 		// in check.
 		return
 	}
-	switch *resultError {
-	case nil, driver.ErrBadConn, context.Canceled:
+	errorType := classifyError(ctx, *resultError)
+	retryState := retryStateFromContext(ctx)
+	if errorType == "driver" && c.driver.isRetryableError(*resultError) {
+		errorType = "retryable"
+		if retryState != nil {
+			atomic.StoreInt32(&retryState.retryable, 1)
+		}
+	}
+	if errorType != "" {
+		span.Context.SetTag("db_error", errorType)
+	}
+	switch errorType {
+	case "", "bad_connection", "canceled":
 		// ErrBadConn is used by the connection pooling
 		// logic in database/sql, and so is expected and
 		// should not be reported.
 		//
-		// context.Canceled means the callers canceled
+		// Cancellation means the callers canceled
 		// the operation, so this is also expected.
+	case "retryable":
+		if retryState != nil && retryState.attempt < retryState.maxAttempts {
+			// The operation will be retried by Retry,
+			// so don't report the error.
+			break
+		}
+		fallthrough
 	default:
 		if e := apm.CaptureError(ctx, *resultError); e != nil {
+			if errorType == "deadline_exceeded" {
+				// Group timeouts together, distinct from
+				// the errors returned by the driver.
+				e.Culprit = context.DeadlineExceeded.Error()
+			}
 			e.Send()
 		}
 	}
 	span.End()
 }
 
+// classifyError returns a string describing the cause of err:
+// "bad_connection", "canceled", "deadline_exceeded", or "driver".
+// If err is nil, classifyError returns an empty string.
+//
+// Drivers may return their own errors when a query is interrupted
+// due to context cancellation, so if the context is done then err
+// is attributed to the context's error.
+func classifyError(ctx context.Context, err error) string {
+	if err == nil {
+		return ""
+	}
+	if err == driver.ErrBadConn {
+		return "bad_connection"
+	}
+	if err != context.Canceled && err != context.DeadlineExceeded {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+	}
+	switch err {
+	case context.Canceled:
+		return "canceled"
+	case context.DeadlineExceeded:
+		return "deadline_exceeded"
+	}
+	return "driver"
+}
+
 func (c *conn) Ping(ctx context.Context) (resultError error) {
 	if c.pinger == nil {
 		return nil
@@ -137,8 +234,14 @@ func (*conn) Query(query string, args []driver.Value) (driver.Rows, error) {
 }
 
 func (c *conn) PrepareContext(ctx context.Context, query string) (_ driver.Stmt, resultError error) {
-	span, ctx := c.startStmtSpan(ctx, query, c.driver.prepareSpanType)
-	defer c.finishSpan(ctx, span, &resultError)
+	if !c.dsnInfo.statementPooled() {
+		// Server-side prepared statements are not reliable behind
+		// a transaction or statement pooler, so we only record spans
+		// for preparing statements when connected directly.
+		var span *apm.Span
+		span, ctx = c.startStmtSpan(ctx, query, c.driver.prepareSpanType)
+		defer c.finishSpan(ctx, span, &resultError)
+	}
 	var stmt driver.Stmt
 	var err error
 	if c.connPrepareContext != nil {
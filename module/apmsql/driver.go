@@ -21,6 +21,8 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
+	"net"
+	"strconv"
 	"sync"
 
 	"go.elastic.co/apm/internal/sqlutil"
@@ -87,8 +89,10 @@ func newTracingDriver(driver driver.Driver, opts ...WrapOption) *tracingDriver {
 }
 
 // DriverDSNParser returns the DSNParserFunc for the registered driver.
-// If there is no such registered driver, the parser function that is
-// returned will return empty DSNInfo structures.
+// The DSNInfo structures returned by the function reflect any options
+// supplied to Register, such as WithDestination. If there is no such
+// registered driver, the parser function that is returned will return
+// empty DSNInfo structures.
 func DriverDSNParser(driverName string) DSNParserFunc {
 	driversMu.RLock()
 	driver := drivers[driverName]
@@ -97,7 +101,7 @@ func DriverDSNParser(driverName string) DSNParserFunc {
 	if driver == nil {
 		return genericDSNParser
 	}
-	return driver.dsnParser
+	return driver.parseDSN
 }
 
 // WrapOption is an option that can be supplied to Wrap.
@@ -123,10 +127,56 @@ func WithDSNParser(f DSNParserFunc) WrapOption {
 	}
 }
 
+// WithRole returns a WrapOption which sets the role of the database
+// servers connected to with the driver, e.g. "primary" or "replica",
+// for recording in spans. This is useful for identifying which server
+// handled a query in a read/write-split architecture, by registering
+// the same driver under a different name for each role.
+//
+// The role may also be inferred from the data source name by a custom
+// DSN parser; see DSNInfo.Role.
+func WithRole(role string) WrapOption {
+	return func(d *tracingDriver) {
+		d.role = role
+	}
+}
+
+// WithPoolMode returns a WrapOption which records that connections
+// made with the driver go through a connection pooler, such as PgBouncer,
+// operating in the given pool mode: "session", "transaction" or "statement".
+//
+// The pool mode may also be inferred from the data source name by a custom
+// DSN parser; see DSNInfo.PoolMode.
+func WithPoolMode(mode string) WrapOption {
+	return func(d *tracingDriver) {
+		d.poolMode = mode
+	}
+}
+
+// WithDestination returns a WrapOption which sets the destination
+// address and port recorded in spans, overriding those parsed from
+// the data source name. This is useful when connections go through
+// a proxy, such as PgBouncer or RDS Proxy, and spans should identify
+// the database server behind it rather than the proxy.
+//
+// If a pool mode is specified, the address parsed from the data source
+// name is recorded as that of the pooler.
+func WithDestination(address string, port int) WrapOption {
+	return func(d *tracingDriver) {
+		d.destinationAddress = address
+		d.destinationPort = port
+	}
+}
+
 type tracingDriver struct {
 	driver.Driver
-	driverName string
-	dsnParser  DSNParserFunc
+	driverName         string
+	dsnParser          DSNParserFunc
+	role               string
+	poolMode           string
+	destinationAddress string
+	destinationPort    int
+	retryableError     RetryableErrorFunc
 
 	connectSpanType string
 	execSpanType    string
@@ -139,6 +189,10 @@ func (d *tracingDriver) formatSpanType(suffix string) string {
 	return fmt.Sprintf("db.%s.%s", d.driverName, suffix)
 }
 
+func (d *tracingDriver) isRetryableError(err error) bool {
+	return d.retryableError != nil && d.retryableError(err)
+}
+
 // querySignature returns the value to use in Span.Name for
 // a database query.
 func (d *tracingDriver) querySignature(query string) string {
@@ -150,5 +204,29 @@ func (d *tracingDriver) Open(name string) (driver.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	return newConn(conn, d, d.dsnParser(name)), nil
+	return newConn(conn, d, d.parseDSN(name)), nil
+}
+
+// parseDSN parses the data source name with d.dsnParser, setting the
+// returned DSNInfo's Role and PoolMode to d.role and d.poolMode if
+// the parser did not set them, and overriding its Address and Port
+// with those specified by WithDestination.
+func (d *tracingDriver) parseDSN(name string) DSNInfo {
+	info := d.dsnParser(name)
+	if info.Role == "" {
+		info.Role = d.role
+	}
+	if info.PoolMode == "" {
+		info.PoolMode = d.poolMode
+	}
+	if info.Port > 0 {
+		info.pooler = net.JoinHostPort(info.Address, strconv.Itoa(info.Port))
+	} else {
+		info.pooler = info.Address
+	}
+	if d.destinationAddress != "" {
+		info.Address = d.destinationAddress
+		info.Port = d.destinationPort
+	}
+	return info
 }
@@ -49,13 +49,16 @@ type driverConnector struct {
 func (d *driverConnector) Connect(ctx context.Context) (driver.Conn, error) {
 	span, ctx := apm.StartSpan(ctx, "connect", d.driver.connectSpanType)
 	defer span.End()
-	dsnInfo := d.driver.dsnParser(d.name)
+	dsnInfo := d.driver.parseDSN(d.name)
 	if !span.Dropped() {
 		span.Context.SetDatabase(apm.DatabaseSpanContext{
 			Instance: dsnInfo.Database,
 			Type:     "sql",
 			User:     dsnInfo.User,
 		})
+		if dsnInfo.Address != "" {
+			span.Context.SetDestinationAddress(dsnInfo.Address, dsnInfo.Port)
+		}
 	}
 	conn, err := d.connect(ctx)
 	if err != nil {
@@ -25,6 +25,46 @@ type DSNInfo struct {
 	// User is the username that the DSN specifies for authenticating the
 	// database connection.
 	User string
+
+	// Address is the network address of the database server identified
+	// by the DSN. This is left empty if the DSN does not identify a single
+	// server, e.g. if it names multiple hosts or a Unix domain socket.
+	Address string
+
+	// Port is the network port of the database server identified by the
+	// DSN. Port is only meaningful if Address is non-empty.
+	Port int
+
+	// Role is the role of the database server identified by the DSN in
+	// a read/write-split architecture, e.g. "primary" or "replica". If
+	// non-empty, this is recorded in spans, and takes precedence over
+	// the role specified with WithRole.
+	Role string
+
+	// PoolMode is the pooling mode of a connection pooler, such as
+	// PgBouncer, sitting between the client and the database server
+	// identified by the DSN: "session", "transaction" or "statement".
+	// If non-empty, this takes precedence over the pool mode specified
+	// with WithPoolMode.
+	//
+	// When PoolMode is non-empty, Address and Port identify the pooler
+	// rather than the database server, and spans are tagged accordingly,
+	// unless the database server's address is specified with
+	// WithDestination.
+	// In "transaction" and "statement" modes, server-side prepared
+	// statements cannot be relied upon, so no spans are recorded for
+	// preparing statements.
+	PoolMode string
+
+	// pooler holds the address and port parsed from the DSN, which
+	// identify the pooler when PoolMode is non-empty.
+	pooler string
+}
+
+// statementPooled reports whether info identifies a pooler that may
+// assign a different server connection to each transaction or statement.
+func (info DSNInfo) statementPooled() bool {
+	return info.PoolMode == "transaction" || info.PoolMode == "statement"
 }
 
 // DSNParserFunc is the type of a function that can be used for parsing a
@@ -24,5 +24,8 @@ import (
 )
 
 func init() {
-	apmsql.Register("mysql", &mysql.MySQLDriver{}, apmsql.WithDSNParser(ParseDSN))
+	apmsql.Register("mysql", &mysql.MySQLDriver{},
+		apmsql.WithDSNParser(ParseDSN),
+		apmsql.WithRetryableErrors(IsRetryableError),
+	)
 }
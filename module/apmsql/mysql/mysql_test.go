@@ -28,7 +28,7 @@ import (
 	"go.elastic.co/apm/apmtest"
 	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/module/apmsql"
-	_ "go.elastic.co/apm/module/apmsql/mysql"
+	apmmysql "go.elastic.co/apm/module/apmsql/mysql"
 )
 
 var mysqlHost = os.Getenv("MYSQL_HOST")
@@ -38,7 +38,8 @@ func TestQueryContext(t *testing.T) {
 		t.Skipf("MYSQL_HOST not specified")
 	}
 
-	db, err := apmsql.Open("mysql", "root:hunter2@tcp("+mysqlHost+")/test_db")
+	dsn := "root:hunter2@tcp(" + mysqlHost + ")/test_db"
+	db, err := apmsql.Open("mysql", dsn)
 	require.NoError(t, err)
 	defer db.Close()
 
@@ -55,6 +56,7 @@ func TestQueryContext(t *testing.T) {
 	assert.NotNil(t, spans[0].ID)
 	assert.Equal(t, "SELECT FROM foo", spans[0].Name)
 	assert.Equal(t, "mysql", spans[0].Subtype)
+	dsnInfo := apmmysql.ParseDSN(dsn)
 	assert.Equal(t, &model.SpanContext{
 		Database: &model.DatabaseSpanContext{
 			Instance:  "test_db",
@@ -62,5 +64,9 @@ func TestQueryContext(t *testing.T) {
 			Type:      "sql",
 			User:      "root",
 		},
+		Destination: &model.DestinationSpanContext{
+			Address: dsnInfo.Address,
+			Port:    dsnInfo.Port,
+		},
 	}, spans[0].Context)
 }
@@ -20,6 +20,7 @@ package apmmysql
 import (
 	"github.com/go-sql-driver/mysql"
 
+	"go.elastic.co/apm/internal/apmnetutil"
 	"go.elastic.co/apm/module/apmsql"
 )
 
@@ -31,8 +32,15 @@ func ParseDSN(name string) apmsql.DSNInfo {
 		// so just return a zero value.
 		return apmsql.DSNInfo{}
 	}
-	return apmsql.DSNInfo{
+	info := apmsql.DSNInfo{
 		Database: cfg.DBName,
 		User:     cfg.User,
 	}
+	if cfg.Net == "tcp" {
+		if host, port, ok := apmnetutil.SplitHostPort(cfg.Addr, 3306); ok {
+			info.Address = host
+			info.Port = port
+		}
+	}
+	return info
 }
@@ -30,6 +30,18 @@ func TestParseDSN(t *testing.T) {
 	info := apmmysql.ParseDSN("user:pass@/dbname")
 	assert.Equal(t, "dbname", info.Database)
 	assert.Equal(t, "user", info.User)
+	assert.Equal(t, "127.0.0.1", info.Address)
+	assert.Equal(t, 3306, info.Port)
+}
+
+func TestParseDSNAddress(t *testing.T) {
+	info := apmmysql.ParseDSN("user:pass@tcp(mysql.testing:3307)/dbname")
+	assert.Equal(t, "mysql.testing", info.Address)
+	assert.Equal(t, 3307, info.Port)
+
+	info = apmmysql.ParseDSN("user:pass@unix(/tmp/mysql.sock)/dbname")
+	assert.Equal(t, "", info.Address)
+	assert.Equal(t, 0, info.Port)
 }
 
 func TestParseDSNError(t *testing.T) {
@@ -24,5 +24,8 @@ import (
 )
 
 func init() {
-	apmsql.Register("postgres", &pq.Driver{}, apmsql.WithDSNParser(ParseDSN))
+	apmsql.Register("postgres", &pq.Driver{},
+		apmsql.WithDSNParser(ParseDSN),
+		apmsql.WithRetryableErrors(IsRetryableError),
+	)
 }
@@ -26,6 +26,7 @@ import (
 	"strings"
 	"unicode"
 
+	"go.elastic.co/apm/internal/apmnetutil"
 	"go.elastic.co/apm/module/apmsql"
 )
 
@@ -51,6 +52,25 @@ func ParseDSN(name string) apmsql.DSNInfo {
 	if info.User == "" {
 		info.User = os.Getenv("PGUSER")
 	}
+
+	host, port := opts["host"], opts["port"]
+	if host == "" {
+		host = os.Getenv("PGHOST")
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = os.Getenv("PGPORT")
+	}
+	// Hosts beginning with a slash identify a Unix domain socket
+	// directory, which is not a meaningful destination address.
+	if !strings.HasPrefix(host, "/") {
+		if host, port, ok := apmnetutil.SplitHostPort(net.JoinHostPort(host, port), 5432); ok {
+			info.Address = host
+			info.Port = port
+		}
+	}
 	return info
 }
 
@@ -28,7 +28,7 @@ import (
 	"go.elastic.co/apm/apmtest"
 	"go.elastic.co/apm/model"
 	"go.elastic.co/apm/module/apmsql"
-	_ "go.elastic.co/apm/module/apmsql/pq"
+	apmpq "go.elastic.co/apm/module/apmsql/pq"
 )
 
 func TestQueryContext(t *testing.T) {
@@ -36,7 +36,8 @@ func TestQueryContext(t *testing.T) {
 		t.Skipf("PGHOST not specified")
 	}
 
-	db, err := apmsql.Open("postgres", "user=postgres password=hunter2 dbname=test_db sslmode=disable")
+	dsn := "user=postgres password=hunter2 dbname=test_db sslmode=disable"
+	db, err := apmsql.Open("postgres", dsn)
 	require.NoError(t, err)
 	defer db.Close()
 
@@ -53,6 +54,7 @@ func TestQueryContext(t *testing.T) {
 	assert.NotNil(t, spans[0].ID)
 	assert.Equal(t, "SELECT FROM foo", spans[0].Name)
 	assert.Equal(t, "postgresql", spans[0].Subtype)
+	dsnInfo := apmpq.ParseDSN(dsn)
 	assert.Equal(t, &model.SpanContext{
 		Database: &model.DatabaseSpanContext{
 			Instance:  "test_db",
@@ -60,5 +62,9 @@ func TestQueryContext(t *testing.T) {
 			Type:      "sql",
 			User:      "postgres",
 		},
+		Destination: &model.DestinationSpanContext{
+			Address: dsnInfo.Address,
+			Port:    dsnInfo.Port,
+		},
 	}, spans[0].Context)
 }
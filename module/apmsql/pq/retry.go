@@ -0,0 +1,36 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmpq
+
+import "github.com/lib/pq"
+
+// IsRetryableError reports whether err is a PostgreSQL serialization
+// failure (SQLSTATE 40001) or deadlock (SQLSTATE 40P01), such that
+// the transaction may succeed if retried.
+func IsRetryableError(err error) bool {
+	var code pq.ErrorCode
+	switch err := err.(type) {
+	case *pq.Error:
+		code = err.Code
+	case pq.Error:
+		code = err.Code
+	default:
+		return false
+	}
+	return code == "40001" || code == "40P01"
+}
@@ -0,0 +1,39 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmpq_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"go.elastic.co/apm/module/apmsql"
+	apmpq "go.elastic.co/apm/module/apmsql/pq"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	assert.True(t, apmpq.IsRetryableError(&pq.Error{Code: "40001"}))
+	assert.True(t, apmpq.IsRetryableError(pq.Error{Code: "40P01"}))
+	assert.False(t, apmpq.IsRetryableError(&pq.Error{Code: "23505"}))
+	assert.False(t, apmpq.IsRetryableError(errors.New("40001")))
+
+	// The function is registered with apmsql.
+	assert.True(t, apmsql.IsRetryableError(&pq.Error{Code: "40001"}))
+}
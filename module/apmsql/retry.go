@@ -0,0 +1,147 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmsql
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"go.elastic.co/apm"
+)
+
+// RetryableErrorFunc is the type of a function for deciding whether
+// an error returned by a driver is transient, such that the operation
+// may succeed if retried, e.g. a serialization failure or deadlock.
+type RetryableErrorFunc func(error) bool
+
+// WithRetryableErrors returns a WrapOption which sets a function for
+// identifying retryable errors returned by the driver. Failed operations
+// are then tagged with a db_error of "retryable" rather than "driver",
+// and the errors are not reported for attempts made by Retry that are
+// followed by another attempt.
+//
+// The driver packages in module/apmsql register functions identifying
+// PostgreSQL serialization failures and deadlocks, and MySQL deadlocks.
+func WithRetryableErrors(f RetryableErrorFunc) WrapOption {
+	return func(d *tracingDriver) {
+		d.retryableError = f
+	}
+}
+
+// IsRetryableError reports whether err, or any error that it wraps,
+// is identified as retryable by a driver registered with Register.
+func IsRetryableError(err error) bool {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	for ; err != nil; err = unwrapError(err) {
+		for _, d := range drivers {
+			if d.isRetryableError(err) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func unwrapError(err error) error {
+	switch err := err.(type) {
+	case interface{ Unwrap() error }:
+		return err.Unwrap()
+	case interface{ Cause() error }:
+		return err.Cause()
+	}
+	return nil
+}
+
+// Retry calls f until it succeeds, returns an error that is not
+// retryable, or has been called maxAttempts times, and returns the
+// result of the final call. An error is retryable if it is identified
+// by IsRetryableError, or if a traced operation during the attempt
+// failed with a retryable error.
+//
+// Attempts are grouped under a span with the given name, tagged with
+// the number of attempts made in db_retry_attempts, and the outcome in
+// db_retry_outcome: "success", "failure" for errors that are not
+// retryable, or "exhausted" if the final attempt failed with a
+// retryable error. Spans for the operations of each attempt are tagged
+// with db_attempt, and retryable errors are reported only for the
+// final attempt.
+//
+// Retry does not wait between attempts; f may do so if necessary.
+func Retry(ctx context.Context, name string, maxAttempts int, f func(ctx context.Context) error) error {
+	if maxAttempts < 1 {
+		panic("maxAttempts < 1")
+	}
+	span, ctx := apm.StartSpan(ctx, name, "db.retry")
+	defer span.End()
+
+	var err error
+	var retryable bool
+	attempt := 1
+	for ; ; attempt++ {
+		state := &retryState{attempt: attempt, maxAttempts: maxAttempts}
+		err = f(context.WithValue(ctx, retryStateKey{}, state))
+		if err == nil {
+			break
+		}
+		retryable = atomic.LoadInt32(&state.retryable) != 0 || IsRetryableError(err)
+		if !retryable || attempt == maxAttempts {
+			break
+		}
+	}
+	if !span.Dropped() {
+		outcome := "success"
+		if err != nil {
+			outcome = "failure"
+			if retryable {
+				outcome = "exhausted"
+			}
+		}
+		span.Context.SetTag("db_retry_attempts", strconv.Itoa(attempt))
+		span.Context.SetTag("db_retry_outcome", outcome)
+	}
+	return err
+}
+
+// RetryAttempt returns the number of the Retry attempt in progress
+// in ctx, starting from 1, and the maximum number of attempts. If ctx
+// does not belong to a Retry attempt, RetryAttempt returns zeroes.
+func RetryAttempt(ctx context.Context) (attempt, maxAttempts int) {
+	if state := retryStateFromContext(ctx); state != nil {
+		return state.attempt, state.maxAttempts
+	}
+	return 0, 0
+}
+
+type retryStateKey struct{}
+
+type retryState struct {
+	attempt     int
+	maxAttempts int
+
+	// retryable is set to 1 if a traced operation during the
+	// attempt fails with a retryable error. Operations may be
+	// made in other goroutines, so it is accessed atomically.
+	retryable int32
+}
+
+func retryStateFromContext(ctx context.Context) *retryState {
+	state, _ := ctx.Value(retryStateKey{}).(*retryState)
+	return state
+}
@@ -0,0 +1,131 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmsql_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmsql"
+)
+
+func init() {
+	// Treat queries against a missing "busy" table as
+	// failing with a retryable error.
+	apmsql.Register("sqlite3_retryable", &sqlite3.SQLiteDriver{},
+		apmsql.WithRetryableErrors(func(err error) bool {
+			return strings.Contains(err.Error(), "no such table: busy")
+		}),
+	)
+}
+
+func TestRetry(t *testing.T) {
+	db, err := apmsql.Open("sqlite3_retryable", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.Ping() // connect
+
+	var attempts []int
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		err := apmsql.Retry(ctx, "transfer", 3, func(ctx context.Context) error {
+			attempt, maxAttempts := apmsql.RetryAttempt(ctx)
+			assert.Equal(t, 3, maxAttempts)
+			attempts = append(attempts, attempt)
+			if attempt < 3 {
+				_, err := db.ExecContext(ctx, "SELECT * FROM busy")
+				return err
+			}
+			_, err := db.ExecContext(ctx, "SELECT 1")
+			return err
+		})
+		assert.NoError(t, err)
+	})
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+	assert.Empty(t, errs) // retried errors are not reported
+
+	require.Len(t, spans, 4)
+	for i, span := range spans[:3] {
+		expected := model.StringMap{{Key: "db_attempt", Value: fmt.Sprint(i + 1)}}
+		if i < 2 {
+			expected = append(expected, model.StringMapItem{Key: "db_error", Value: "retryable"})
+		}
+		assert.Equal(t, expected, span.Context.Tags)
+		assert.Equal(t, spans[3].ID, span.ParentID)
+	}
+	assert.Equal(t, "transfer", spans[3].Name)
+	assert.Equal(t, "db", spans[3].Type)
+	assert.Equal(t, "retry", spans[3].Subtype)
+	assert.Equal(t, model.StringMap{
+		{Key: "db_retry_attempts", Value: "3"},
+		{Key: "db_retry_outcome", Value: "success"},
+	}, spans[3].Context.Tags)
+}
+
+func TestRetryExhausted(t *testing.T) {
+	db, err := apmsql.Open("sqlite3_retryable", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.Ping() // connect
+
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		err := apmsql.Retry(ctx, "transfer", 2, func(ctx context.Context) error {
+			_, err := db.ExecContext(ctx, "SELECT * FROM busy")
+			return err
+		})
+		assert.Error(t, err)
+	})
+	require.Len(t, spans, 3)
+	require.Len(t, errs, 1) // only the final attempt's error is reported
+	assert.Equal(t, spans[1].ID, errs[0].ParentID)
+	assert.Equal(t, model.StringMap{
+		{Key: "db_retry_attempts", Value: "2"},
+		{Key: "db_retry_outcome", Value: "exhausted"},
+	}, spans[2].Context.Tags)
+}
+
+func TestRetryFailure(t *testing.T) {
+	var calls int
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		err := apmsql.Retry(ctx, "transfer", 3, func(ctx context.Context) error {
+			calls++
+			return errors.New("not retryable")
+		})
+		assert.EqualError(t, err, "not retryable")
+	})
+	assert.Equal(t, 1, calls)
+	require.Len(t, spans, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "db_retry_attempts", Value: "1"},
+		{Key: "db_retry_outcome", Value: "failure"},
+	}, spans[0].Context.Tags)
+}
+
+func TestRetryAttemptNoRetry(t *testing.T) {
+	attempt, maxAttempts := apmsql.RetryAttempt(context.Background())
+	assert.Zero(t, attempt)
+	assert.Zero(t, maxAttempts)
+}
@@ -0,0 +1,119 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmtesting
+
+import (
+	"context"
+	"testing"
+
+	"go.elastic.co/apm"
+)
+
+const (
+	// TransactionType is the type of transactions recorded for tests.
+	TransactionType = "test"
+
+	// ResultPass is the transaction result recorded for passing tests.
+	ResultPass = "PASS"
+
+	// ResultFail is the transaction result recorded for failed tests.
+	ResultFail = "FAIL"
+
+	// ResultSkip is the transaction result recorded for skipped tests.
+	ResultSkip = "SKIP"
+)
+
+// Trace calls f, recording the execution of the test t as a transaction
+// named t.Name(). The context passed to f contains the transaction, and
+// may be used for creating spans for operations performed by the test.
+//
+// The transaction result is set to ResultPass, ResultFail, or ResultSkip
+// according to the state of t after f returns, panics, or stops the
+// test's goroutine with t.FailNow or t.SkipNow. The tracer is flushed
+// before Trace returns, so that the transaction is not lost when the
+// test binary exits.
+func Trace(t *testing.T, f func(ctx context.Context), o ...Option) {
+	opts := gatherOptions(o...)
+	tx := opts.tracer.StartTransaction(t.Name(), TransactionType)
+	if opts.suite != "" {
+		tx.Context.SetTag("test_suite", opts.suite)
+	}
+	defer func() {
+		v := recover()
+		switch {
+		case v != nil || t.Failed():
+			tx.Result = ResultFail
+		case t.Skipped():
+			tx.Result = ResultSkip
+		default:
+			tx.Result = ResultPass
+		}
+		tx.End()
+		opts.tracer.Flush(nil)
+		if v != nil {
+			panic(v)
+		}
+	}()
+	f(apm.ContextWithTransaction(context.Background(), tx))
+}
+
+// Run runs f as a subtest of t called name, as with t.Run, recording
+// the subtest's execution as a transaction using Trace.
+func Run(t *testing.T, name string, f func(t *testing.T, ctx context.Context), o ...Option) bool {
+	return t.Run(name, func(t *testing.T) {
+		Trace(t, func(ctx context.Context) { f(t, ctx) }, o...)
+	})
+}
+
+type options struct {
+	tracer *apm.Tracer
+	suite  string
+}
+
+func gatherOptions(o ...Option) options {
+	opts := options{tracer: apm.DefaultTracer}
+	for _, o := range o {
+		o(&opts)
+	}
+	return opts
+}
+
+// Option sets options for tracing tests.
+type Option func(*options)
+
+// WithTracer returns an Option which sets t as the tracer
+// to use for tracing tests. By default, apm.DefaultTracer
+// is used.
+func WithTracer(t *apm.Tracer) Option {
+	if t == nil {
+		panic("t == nil")
+	}
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// WithSuite returns an Option which sets the name of the test
+// suite, recorded in the "test_suite" tag of test transactions.
+// This may be used to distinguish tests with the same name in
+// different packages.
+func WithSuite(name string) Option {
+	return func(o *options) {
+		o.suite = name
+	}
+}
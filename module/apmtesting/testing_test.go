@@ -0,0 +1,79 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmtesting_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/module/apmtesting"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestRun(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	opts := []apmtesting.Option{
+		apmtesting.WithTracer(tracer),
+		apmtesting.WithSuite("apmtesting"),
+	}
+	apmtesting.Run(t, "pass", func(t *testing.T, ctx context.Context) {
+		span, _ := apm.StartSpan(ctx, "setup", "test")
+		span.End()
+	}, opts...)
+	apmtesting.Run(t, "skip", func(t *testing.T, ctx context.Context) {
+		t.Skip("skipping")
+	}, opts...)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	require.Len(t, payloads.Spans, 1)
+
+	pass := payloads.Transactions[0]
+	assert.Equal(t, "TestRun/pass", pass.Name)
+	assert.Equal(t, "test", pass.Type)
+	assert.Equal(t, "PASS", pass.Result)
+	assert.Equal(t, model.StringMap{{Key: "test_suite", Value: "apmtesting"}}, pass.Context.Tags)
+	assert.Equal(t, pass.ID, payloads.Spans[0].ParentID)
+
+	skip := payloads.Transactions[1]
+	assert.Equal(t, "TestRun/skip", skip.Name)
+	assert.Equal(t, "SKIP", skip.Result)
+}
+
+func TestTracePanic(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	assert.Panics(t, func() {
+		apmtesting.Trace(t, func(ctx context.Context) {
+			panic("boom")
+		}, apmtesting.WithTracer(tracer))
+	})
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, "TestTracePanic", payloads.Transactions[0].Name)
+	assert.Equal(t, "FAIL", payloads.Transactions[0].Result)
+}
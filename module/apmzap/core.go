@@ -26,6 +26,7 @@ import (
 	"go.uber.org/zap/zapcore"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmlogfields"
 	"go.elastic.co/apm/stacktrace"
 )
 
@@ -173,15 +174,16 @@ type traceContext struct {
 }
 
 func (c *traceContext) fields(fields []zapcore.Field) {
+	names := apmlogfields.CurrentNames()
 	for _, field := range fields {
 		switch field.Key {
 		case "error":
 			c.err, _ = field.Interface.(error)
-		case FieldKeyTraceID:
+		case names.TraceID:
 			c.traceID, _ = field.Interface.(apm.TraceID)
-		case FieldKeyTransactionID:
+		case names.TransactionID:
 			c.transactionID, _ = field.Interface.(apm.SpanID)
-		case FieldKeySpanID:
+		case names.SpanID:
 			c.spanID, _ = field.Interface.(apm.SpanID)
 		}
 	}
@@ -25,34 +25,40 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
-	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmlogfields"
 )
 
 const (
-	// FieldKeyTraceID is the field key for the trace ID.
-	FieldKeyTraceID = "trace.id"
+	// FieldKeyTraceID is the default field key for the trace ID.
+	// The field keys may be changed with apmlogfields.SetNames.
+	FieldKeyTraceID = apmlogfields.DefaultTraceID
 
-	// FieldKeyTransactionID is the field key for the transaction ID.
-	FieldKeyTransactionID = "transaction.id"
+	// FieldKeyTransactionID is the default field key for the transaction ID.
+	FieldKeyTransactionID = apmlogfields.DefaultTransactionID
 
-	// FieldKeySpanID is the field key for the span ID.
-	FieldKeySpanID = "span.id"
+	// FieldKeySpanID is the default field key for the span ID.
+	FieldKeySpanID = apmlogfields.DefaultSpanID
 )
 
 // TraceContext returns zap.Fields containing the trace context
 // of the transaction and span contained in ctx, if any.
 func TraceContext(ctx context.Context) []zapcore.Field {
-	tx := apm.TransactionFromContext(ctx)
-	if tx == nil {
+	values, names, ok := apmlogfields.FromContext(ctx)
+	if !ok {
 		return nil
 	}
-	traceContext := tx.TraceContext()
 	fields := []zapcore.Field{
-		zap.Stringer(FieldKeyTraceID, traceContext.Trace),
-		zap.Stringer(FieldKeyTransactionID, traceContext.Span),
+		zap.Stringer(names.TraceID, values.TraceID),
+		zap.Stringer(names.TransactionID, values.TransactionID),
 	}
-	if span := apm.SpanFromContext(ctx); span != nil {
-		fields = append(fields, zap.Stringer(FieldKeySpanID, span.TraceContext().Span))
+	if values.HasSpan {
+		fields = append(fields, zap.Stringer(names.SpanID, values.SpanID))
+	}
+	if names.ServiceName != "" {
+		fields = append(fields, zap.String(names.ServiceName, values.ServiceName))
+	}
+	if names.ServiceEnvironment != "" {
+		fields = append(fields, zap.String(names.ServiceEnvironment, values.ServiceEnvironment))
 	}
 	return fields
 }
@@ -0,0 +1,55 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmzap
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of parent in which logger is stored,
+// to be returned with trace context fields by FromContext.
+func ContextWithLogger(parent context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(parent, loggerKey{}, logger)
+}
+
+// FromContext returns a logger with the fields returned by TraceContext
+// for the transaction and span contained in ctx, so log records can be
+// correlated with the trace without passing the fields on each call.
+// Service name and environment fields are included if their names are
+// set with apmlogfields.SetNames.
+//
+// The returned logger is derived from the logger stored in ctx with
+// ContextWithLogger, or the global logger returned by zap.L if there
+// is none. If ctx does not contain a transaction, the logger is
+// returned without additional fields.
+func FromContext(ctx context.Context) *zap.Logger {
+	logger, ok := ctx.Value(loggerKey{}).(*zap.Logger)
+	if !ok || logger == nil {
+		logger = zap.L()
+	}
+	if fields := TraceContext(ctx); len(fields) != 0 {
+		logger = logger.With(fields...)
+	}
+	return logger
+}
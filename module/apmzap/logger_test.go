@@ -0,0 +1,80 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build go1.9
+
+package apmzap_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmlogfields"
+	"go.elastic.co/apm/apmtest"
+	"go.elastic.co/apm/module/apmzap"
+)
+
+func TestFromContext(t *testing.T) {
+	var buf zaptest.Buffer
+	logger := newLogger(&buf, zap.DebugLevel)
+
+	apmlogfields.SetNames(apmlogfields.Names{ServiceName: "service.name"})
+	defer apmlogfields.SetNames(apmlogfields.Names{})
+
+	tx, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx = apmzap.ContextWithLogger(ctx, logger)
+		apmzap.FromContext(ctx).Debug("beep")
+
+		span, ctx := apm.StartSpan(ctx, "name", "type")
+		defer span.End()
+		apmzap.FromContext(ctx).Debug("boop")
+	})
+	require.Len(t, spans, 1)
+	lines := buf.Lines()
+	require.Len(t, lines, 2)
+
+	assert.Equal(t, fmt.Sprintf(
+		`{"level":"debug","message":"beep","trace.id":"%x","transaction.id":"%x","service.name":"transporttest"}`,
+		tx.TraceID[:], tx.ID[:],
+	), lines[0])
+	assert.Equal(t, fmt.Sprintf(
+		`{"level":"debug","message":"boop","trace.id":"%x","transaction.id":"%x","span.id":"%x","service.name":"transporttest"}`,
+		tx.TraceID[:], tx.ID[:], spans[0].ID[:],
+	), lines[1])
+}
+
+func TestFromContextEmpty(t *testing.T) {
+	var buf zaptest.Buffer
+	logger := newLogger(&buf, zap.DebugLevel)
+
+	ctx := apmzap.ContextWithLogger(context.Background(), logger)
+	apmzap.FromContext(ctx).Debug("beep")
+
+	lines := buf.Lines()
+	require.Len(t, lines, 1)
+	assert.Equal(t, `{"level":"debug","message":"beep"}`, lines[0])
+
+	// Without a logger in the context, the global logger is used.
+	assert.Equal(t, zap.L(), apmzap.FromContext(context.Background()))
+}
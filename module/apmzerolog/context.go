@@ -22,18 +22,19 @@ import (
 
 	"github.com/rs/zerolog"
 
-	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmlogfields"
 )
 
 const (
-	// SpanIDFieldName is the field name for the span ID.
-	SpanIDFieldName = "span.id"
+	// SpanIDFieldName is the default field name for the span ID.
+	// The field names may be changed with apmlogfields.SetNames.
+	SpanIDFieldName = apmlogfields.DefaultSpanID
 
-	// TraceIDFieldName is the field name for the trace ID.
-	TraceIDFieldName = "trace.id"
+	// TraceIDFieldName is the default field name for the trace ID.
+	TraceIDFieldName = apmlogfields.DefaultTraceID
 
-	// TransactionIDFieldName is the field name for the transaction ID.
-	TransactionIDFieldName = "transaction.id"
+	// TransactionIDFieldName is the default field name for the transaction ID.
+	TransactionIDFieldName = apmlogfields.DefaultTransactionID
 )
 
 // TraceContextHook returns a zerolog.Hook that will add any trace context
@@ -47,15 +48,19 @@ type traceContextHook struct {
 }
 
 func (h traceContextHook) Run(e *zerolog.Event, level zerolog.Level, message string) {
-	tx := apm.TransactionFromContext(h.ctx)
-	if tx == nil {
+	values, names, ok := apmlogfields.FromContext(h.ctx)
+	if !ok {
 		return
 	}
-	traceContext := tx.TraceContext()
-	e.Hex(TraceIDFieldName, traceContext.Trace[:])
-	e.Hex(TransactionIDFieldName, traceContext.Span[:])
-	if span := apm.SpanFromContext(h.ctx); span != nil {
-		spanTraceContext := span.TraceContext()
-		e.Hex("span.id", spanTraceContext.Span[:])
+	e.Hex(names.TraceID, values.TraceID[:])
+	e.Hex(names.TransactionID, values.TransactionID[:])
+	if values.HasSpan {
+		e.Hex(names.SpanID, values.SpanID[:])
+	}
+	if names.ServiceName != "" {
+		e.Str(names.ServiceName, values.ServiceName)
+	}
+	if names.ServiceEnvironment != "" {
+		e.Str(names.ServiceEnvironment, values.ServiceEnvironment)
 	}
 }
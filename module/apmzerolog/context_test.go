@@ -29,6 +29,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmlogfields"
 	"go.elastic.co/apm/apmtest"
 	"go.elastic.co/apm/module/apmzerolog"
 )
@@ -83,3 +84,28 @@ func TestTraceContextHookSpan(t *testing.T) {
 		buf.String(),
 	)
 }
+
+func TestTraceContextHookCustomNames(t *testing.T) {
+	defer apmlogfields.SetNames(apmlogfields.Names{})
+	apmlogfields.SetNames(apmlogfields.Names{
+		SpanID:             "span_id",
+		ServiceEnvironment: "service.environment",
+	})
+
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+
+	tx, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		span, ctx := apm.StartSpan(ctx, "name", "type")
+		logger := logger.Hook(apmzerolog.TraceContextHook(ctx))
+		logger.Info().Msg("message")
+		span.End()
+	})
+
+	require.Len(t, spans, 1)
+	assert.Equal(t, fmt.Sprintf(`
+{"level":"info","trace.id":"%x","transaction.id":"%x","span_id":"%x","service.environment":"","message":"message"}
+`[1:], tx.TraceID, tx.ID, spans[0].ID),
+		buf.String(),
+	)
+}
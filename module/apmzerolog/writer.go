@@ -31,6 +31,7 @@ import (
 	"github.com/rs/zerolog/pkgerrors"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmlogfields"
 	"go.elastic.co/apm/stacktrace"
 )
 
@@ -180,18 +181,19 @@ func (l *logRecord) decode(r io.Reader) (result error) {
 		l.err = err
 	}
 
-	if strval, ok := m[SpanIDFieldName].(string); ok {
+	names := apmlogfields.CurrentNames()
+	if strval, ok := m[names.SpanID].(string); ok {
 		if err := decodeHex(l.spanID[:], strval); err != nil {
 			return errors.Wrap(err, "invalid span.id")
 		}
 	}
 
-	if strval, ok := m[TraceIDFieldName].(string); ok {
+	if strval, ok := m[names.TraceID].(string); ok {
 		if err := decodeHex(l.traceID[:], strval); err != nil {
 			return errors.Wrap(err, "invalid trace.id")
 		}
 	}
-	if strval, ok := m[TransactionIDFieldName].(string); ok {
+	if strval, ok := m[names.TransactionID].(string); ok {
 		if err := decodeHex(l.transactionID[:], strval); err != nil {
 			return errors.Wrap(err, "invalid transaction.id")
 		}
@@ -0,0 +1,138 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// ResultSuccess is the transaction result set by
+	// RecoverWithTransaction when the function succeeds.
+	ResultSuccess = "success"
+
+	// ResultFailure is the transaction result set by
+	// RecoverWithTransaction when the function fails.
+	ResultFailure = "failure"
+
+	// goroutineCrashFlushTimeout is the maximum amount of time spent
+	// flushing the tracer after reporting a panic in a goroutine.
+	goroutineCrashFlushTimeout = 5 * time.Second
+)
+
+// RecoverWithTransaction calls f with a context derived from ctx,
+// containing a new transaction started with tracer, recovering and
+// reporting any panic. This is intended for instrumenting message
+// consumers, and other handlers for which there is no dedicated
+// instrumentation module.
+//
+// The transaction is started with the given name, type, and options.
+// To continue a trace propagated with a message, opts.TraceContext
+// should be set to the trace context extracted from the message; for
+// example, using apmhttp.ParseTraceparentHeader.
+//
+// If f returns an error, the error is reported and the transaction's
+// result is set to ResultFailure. If f panics, the panic is recovered,
+// reported, and the transaction's result is set to ResultFailure. Otherwise
+// the transaction result is set to ResultSuccess. If f sets the result of
+// the transaction, it is left unchanged.
+//
+// RecoverWithTransaction returns the error returned by f or, if f panicked,
+// an error describing the recovered value.
+func RecoverWithTransaction(
+	ctx context.Context,
+	tracer *Tracer,
+	name, transactionType string,
+	opts TransactionOptions,
+	f func(context.Context) error,
+) (resultErr error) {
+	tx := tracer.StartTransactionOptions(name, transactionType, opts)
+	ctx = ContextWithTransaction(ctx, tx)
+	defer func() {
+		if v := recover(); v != nil {
+			e := tracer.Recovered(v)
+			e.SetTransaction(tx)
+			e.Send()
+			if err, ok := v.(error); ok {
+				resultErr = err
+			} else {
+				resultErr = fmt.Errorf("%v", v)
+			}
+		} else if resultErr != nil {
+			e := tracer.NewError(resultErr)
+			e.Handled = true
+			e.SetTransaction(tx)
+			e.Send()
+		}
+		if tx.Result == "" {
+			if resultErr != nil {
+				tx.Result = ResultFailure
+			} else {
+				tx.Result = ResultSuccess
+			}
+		}
+		tx.End()
+	}()
+	return f(ctx)
+}
+
+// Go calls f with ctx in a new goroutine, reporting any panic with tracer
+// before the program crashes. See WrapGoroutine for details.
+func Go(ctx context.Context, tracer *Tracer, f func(context.Context)) {
+	go WrapGoroutine(ctx, tracer, f)()
+}
+
+// WrapGoroutine returns a function which calls f with ctx, for running
+// in a new goroutine: go apm.WrapGoroutine(ctx, tracer, f)().
+//
+// Panics can only be recovered in the goroutine in which they occur, so
+// a panic in a background goroutine crashes the program without being
+// reported. If f panics, the returned function reports the panic as an
+// unhandled error, associated with the span or transaction in ctx if any,
+// and waits up to 5 seconds for the tracer to flush before panicking again
+// with the recovered value. Panics are not suppressed; to recover from
+// panics, use RecoverWithTransaction. Memory faults may be reported in the
+// same way by calling runtime/debug.SetPanicOnFault from within f.
+//
+// If the goroutine may outlive the operation which started it, ctx should
+// be created with DetachedContext so that f is not canceled along with
+// the operation.
+func WrapGoroutine(ctx context.Context, tracer *Tracer, f func(context.Context)) func() {
+	return func() {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			e := tracer.Recovered(v)
+			if span := SpanFromContext(ctx); span != nil {
+				e.SetSpan(span)
+			} else if tx := TransactionFromContext(ctx); tx != nil {
+				e.SetTransaction(tx)
+			}
+			e.Send()
+			flushCtx, cancel := context.WithTimeout(context.Background(), goroutineCrashFlushTimeout)
+			tracer.FlushContext(flushCtx)
+			cancel()
+			panic(v)
+		}()
+		f(ctx)
+	}
+}
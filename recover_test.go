@@ -0,0 +1,135 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestRecoverWithTransaction(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	parent := apm.TraceContext{
+		Trace:   apm.TraceID{1},
+		Span:    apm.SpanID{2},
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	}
+	opts := apm.TransactionOptions{TraceContext: parent}
+
+	err := apm.RecoverWithTransaction(context.Background(), tracer, "ok", "messaging", opts, func(ctx context.Context) error {
+		span, _ := apm.StartSpan(ctx, "span", "type")
+		span.End()
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = apm.RecoverWithTransaction(context.Background(), tracer, "error", "messaging", opts, func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+	assert.EqualError(t, err, "boom")
+
+	err = apm.RecoverWithTransaction(context.Background(), tracer, "panic", "messaging", opts, func(ctx context.Context) error {
+		panic("kaboom")
+	})
+	assert.EqualError(t, err, "kaboom")
+
+	err = apm.RecoverWithTransaction(context.Background(), tracer, "result", "messaging", opts, func(ctx context.Context) error {
+		apm.TransactionFromContext(ctx).Result = "requeued"
+		return errors.New("boom")
+	})
+	assert.EqualError(t, err, "boom")
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 4)
+	require.Len(t, payloads.Spans, 1)
+	require.Len(t, payloads.Errors, 3)
+
+	results := make(map[string]string)
+	for _, tx := range payloads.Transactions {
+		assert.Equal(t, "messaging", tx.Type)
+		assert.Equal(t, model.TraceID(parent.Trace), tx.TraceID)
+		assert.Equal(t, model.SpanID(parent.Span), tx.ParentID)
+		results[tx.Name] = tx.Result
+	}
+	assert.Equal(t, map[string]string{
+		"ok":     "success",
+		"error":  "failure",
+		"panic":  "failure",
+		"result": "requeued",
+	}, results)
+	assert.Equal(t, payloads.Transactions[0].ID, payloads.Spans[0].ParentID)
+
+	assert.Equal(t, "boom", payloads.Errors[0].Exception.Message)
+	assert.True(t, payloads.Errors[0].Exception.Handled)
+	assert.Equal(t, "kaboom", payloads.Errors[1].Exception.Message)
+	assert.False(t, payloads.Errors[1].Exception.Handled)
+	assert.Equal(t, payloads.Transactions[2].ID, payloads.Errors[1].TransactionID)
+}
+
+func TestWrapGoroutine(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	wrapped := apm.WrapGoroutine(ctx, tracer, func(ctx context.Context) {
+		panic("kaboom")
+	})
+
+	recovered := make(chan interface{})
+	go func() {
+		defer func() { recovered <- recover() }()
+		wrapped()
+	}()
+	assert.Equal(t, "kaboom", <-recovered)
+	tx.End()
+
+	// The error is flushed before WrapGoroutine panics again.
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, "kaboom", payloads.Errors[0].Exception.Message)
+	assert.False(t, payloads.Errors[0].Exception.Handled)
+	assert.Equal(t, model.SpanID(tx.TraceContext().Span), payloads.Errors[0].TransactionID)
+}
+
+func TestGo(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	done := make(chan struct{})
+	type contextKey struct{}
+	ctx := context.WithValue(context.Background(), contextKey{}, "value")
+	apm.Go(ctx, tracer, func(ctx context.Context) {
+		assert.Equal(t, "value", ctx.Value(contextKey{}))
+		close(done)
+	})
+	<-done
+	tracer.Flush(nil)
+	assert.Empty(t, transport.Payloads().Errors)
+}
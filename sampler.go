@@ -21,8 +21,12 @@ import (
 	"encoding/binary"
 	"math"
 	"math/big"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/internal/wildcard"
 )
 
 // Sampler provides a means of sampling transactions.
@@ -35,6 +39,102 @@ type Sampler interface {
 	Sample(TraceContext) bool
 }
 
+// ExtendedSampler may be implemented by Samplers that take the
+// transaction's name and type into account, and that report the
+// effective sample rate.
+//
+// If the tracer's Sampler implements ExtendedSampler, SampleExtended
+// is called in place of Sample, and the sample rate is propagated in
+// the trace context's tracestate. If the transaction's name is changed
+// before any spans are started, or its trace context is propagated,
+// the sampling decision is re-evaluated with the new name. Decisions
+// made by NewRatioSampler and NewAdaptiveSampler, which do not depend
+// on the name, are never re-evaluated.
+type ExtendedSampler interface {
+	Sampler
+
+	// SampleExtended indicates whether or not a transaction
+	// should be sampled, and the sample rate with which the
+	// decision was made.
+	SampleExtended(SampleParams) SampleResult
+}
+
+// SampleParams holds parameters for ExtendedSampler.SampleExtended.
+type SampleParams struct {
+	// TraceContext holds the trace context of the transaction.
+	TraceContext TraceContext
+
+	// TransactionName holds the name of the transaction.
+	TransactionName string
+
+	// TransactionType holds the type of the transaction.
+	TransactionType string
+}
+
+// SampleResult holds the result of ExtendedSampler.SampleExtended.
+type SampleResult struct {
+	// Sampled reports whether or not the transaction should be sampled.
+	Sampled bool
+
+	// SampleRate holds the sample rate in effect for the transaction,
+	// in the range [0,1.0].
+	SampleRate float64
+}
+
+// nameIndependentSampler is implemented by the built-in samplers whose
+// decisions do not depend on the transaction name, and so need not be
+// re-evaluated when the name is changed.
+type nameIndependentSampler interface {
+	nameIndependent()
+}
+
+func isNameIndependent(s Sampler) bool {
+	_, ok := s.(nameIndependentSampler)
+	return ok
+}
+
+// SampleDecisionCallback is the type of a function that may be
+// registered with Tracer.SetSampleDecisionCallback, to be notified
+// of transaction sampling decisions.
+type SampleDecisionCallback func(SampleDecision)
+
+// SampleDecision describes the sampling decision made for a transaction.
+type SampleDecision struct {
+	// TransactionName holds the name of the transaction, at the time
+	// the sampling decision was made.
+	TransactionName string
+
+	// ParentTraceContext holds the trace context supplied when the
+	// transaction was started. This is the zero value for the root
+	// transaction of a trace.
+	ParentTraceContext TraceContext
+
+	// Sampled reports whether or not the transaction was sampled.
+	Sampled bool
+
+	// Reason holds the reason for the sampling decision, which will
+	// be one of the SampleReason* constants.
+	Reason string
+}
+
+const (
+	// SampleReasonParent indicates that the sampling decision was
+	// inherited from the transaction's parent trace context.
+	SampleReasonParent = "parent"
+
+	// SampleReasonSampler indicates that the sampling decision was
+	// made by the tracer's Sampler.
+	SampleReasonSampler = "sampler"
+
+	// SampleReasonNoSampler indicates that the transaction was sampled
+	// because the tracer has no Sampler configured.
+	SampleReasonNoSampler = "no_sampler"
+
+	// SampleReasonForced indicates that the transaction was sampled
+	// because TransactionOptions.ForceSample was set.
+	SampleReasonForced = "forced"
+)
+
 // NewRatioSampler returns a new Sampler with the given ratio
 //
 // A ratio of 1.0 samples 100% of transactions, a ratio of 0.5
@@ -42,20 +142,27 @@ type Sampler interface {
 // within the range [0,1.0], NewRatioSampler will panic.
 //
 // The returned Sampler bases its decision on the value of the
-// transaction ID, so there is no synchronization involved.
+// transaction ID, so there is no synchronization involved. It
+// implements ExtendedSampler, reporting the ratio as the sample
+// rate.
 func NewRatioSampler(r float64) Sampler {
 	if r < 0 || r > 1.0 {
 		panic(errors.Errorf("ratio %v out of range [0,1.0]", r))
 	}
+	return newRatioSampler(r)
+}
+
+func newRatioSampler(r float64) ratioSampler {
 	var x big.Float
 	x.SetUint64(math.MaxUint64)
 	x.Mul(&x, big.NewFloat(r))
 	ceil, _ := x.Uint64()
-	return ratioSampler{ceil}
+	return ratioSampler{ceil: ceil, ratio: r}
 }
 
 type ratioSampler struct {
-	ceil uint64
+	ceil  uint64
+	ratio float64
 }
 
 // Sample samples the transaction according to the configured
@@ -64,3 +171,149 @@ func (s ratioSampler) Sample(c TraceContext) bool {
 	v := binary.BigEndian.Uint64(c.Span[:])
 	return v > 0 && v-1 < s.ceil
 }
+
+// SampleExtended samples the transaction as with Sample,
+// reporting the configured ratio as the sample rate.
+func (s ratioSampler) SampleExtended(p SampleParams) SampleResult {
+	return SampleResult{Sampled: s.Sample(p.TraceContext), SampleRate: s.ratio}
+}
+
+func (ratioSampler) nameIndependent() {}
+
+// TransactionSamplingRule describes the sample rate for transactions
+// with a matching name and type. See NewTransactionSampler.
+type TransactionSamplingRule struct {
+	// Name, if non-empty, is a wildcard pattern matched
+	// case-insensitively against the transaction name,
+	// e.g. "GET /healthz" or "GET /api/*".
+	Name string
+
+	// Type, if non-empty, is matched exactly against the
+	// transaction type, e.g. "request".
+	Type string
+
+	// Rate is the ratio of matching transactions to sample,
+	// in the range [0,1.0].
+	Rate float64
+}
+
+// NewTransactionSampler returns a new Sampler which samples transactions
+// with the rate of the first rule matching the transaction's name and
+// type, or with defaultRate if no rule matches. For example, the rule
+// {Name: "GET /healthz", Rate: 0.001} samples 0.1% of health checks.
+//
+// As with NewRatioSampler, decisions are based on the value of the
+// transaction ID. If any of the rates do not lie within the range
+// [0,1.0], NewTransactionSampler will panic.
+func NewTransactionSampler(defaultRate float64, rules ...TransactionSamplingRule) Sampler {
+	s := transactionSampler{
+		defaultSampler: NewRatioSampler(defaultRate).(ratioSampler),
+		rules:          make([]transactionSamplerRule, len(rules)),
+	}
+	for i, rule := range rules {
+		s.rules[i] = transactionSamplerRule{
+			txType:  rule.Type,
+			sampler: NewRatioSampler(rule.Rate).(ratioSampler),
+		}
+		if rule.Name != "" {
+			s.rules[i].name = wildcard.NewMatcher(rule.Name, wildcard.CaseInsensitive)
+		}
+	}
+	return s
+}
+
+type transactionSampler struct {
+	defaultSampler ratioSampler
+	rules          []transactionSamplerRule
+}
+
+type transactionSamplerRule struct {
+	name    *wildcard.Matcher
+	txType  string
+	sampler ratioSampler
+}
+
+// Sample samples the transaction with the default rate, as
+// the transaction's name and type are not known.
+func (s transactionSampler) Sample(c TraceContext) bool {
+	return s.defaultSampler.Sample(c)
+}
+
+// SampleExtended samples the transaction with the rate
+// of the first rule matching its name and type.
+func (s transactionSampler) SampleExtended(p SampleParams) SampleResult {
+	for _, rule := range s.rules {
+		if rule.txType != "" && rule.txType != p.TransactionType {
+			continue
+		}
+		if rule.name != nil && !rule.name.Match(p.TransactionName) {
+			continue
+		}
+		return rule.sampler.SampleExtended(p)
+	}
+	return s.defaultSampler.SampleExtended(p)
+}
+
+// NewAdaptiveSampler returns a new Sampler which adapts its sample rate
+// to sample at most maxPerSecond transactions per second. Each second,
+// the sample rate is adjusted based on the rate at which transactions
+// were started in the previous second, so that the target is met under
+// steady load; bursts within a second are capped at maxPerSecond.
+//
+// Unlike the samplers returned by NewRatioSampler and NewTransactionSampler,
+// the returned Sampler synchronizes access to its state. If maxPerSecond
+// is not positive, NewAdaptiveSampler will panic.
+func NewAdaptiveSampler(maxPerSecond int) Sampler {
+	if maxPerSecond <= 0 {
+		panic(errors.Errorf("max per second %d must be positive", maxPerSecond))
+	}
+	return &adaptiveSampler{
+		max:     maxPerSecond,
+		sampler: newRatioSampler(1),
+	}
+}
+
+type adaptiveSampler struct {
+	max int
+
+	mu          sync.Mutex
+	sampler     ratioSampler
+	windowStart time.Time
+	seen        int
+	sampled     int
+}
+
+// Sample samples the transaction according to the current
+// sample rate, and the number sampled in the current second.
+func (s *adaptiveSampler) Sample(c TraceContext) bool {
+	return s.SampleExtended(SampleParams{TraceContext: c}).Sampled
+}
+
+// SampleExtended samples the transaction as with Sample,
+// reporting the current sample rate.
+func (s *adaptiveSampler) SampleExtended(p SampleParams) SampleResult {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elapsed := now.Sub(s.windowStart); elapsed >= time.Second {
+		if !s.windowStart.IsZero() && s.seen > 0 {
+			perSecond := float64(s.seen) / elapsed.Seconds()
+			s.sampler = newRatioSampler(math.Min(1, float64(s.max)/perSecond))
+		}
+		s.windowStart = now
+		s.seen = 0
+		s.sampled = 0
+	}
+	s.seen++
+	result := s.sampler.SampleExtended(p)
+	if result.Sampled {
+		if s.sampled >= s.max {
+			result.Sampled = false
+		} else {
+			s.sampled++
+		}
+	}
+	return result
+}
+
+func (*adaptiveSampler) nameIndependent() {}
@@ -24,8 +24,11 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport/transporttest"
 )
 
 func TestRatioSampler(t *testing.T) {
@@ -86,3 +89,260 @@ func TestRatioSamplerNever(t *testing.T) {
 		Span: apm.SpanID{255, 255, 255, 255, 255, 255, 255, 255},
 	}))
 }
+
+func TestSampleDecisionCallback(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var decisions []apm.SampleDecision
+	tracer.SetSampleDecisionCallback(func(d apm.SampleDecision) {
+		decisions = append(decisions, d)
+	})
+
+	tx := tracer.StartTransaction("root", "type")
+	tx.End()
+
+	tracer.SetSampler(apm.NewRatioSampler(0))
+	tx = tracer.StartTransaction("unsampled", "type")
+	tx.End()
+
+	parent := apm.TraceContext{
+		Trace:   apm.TraceID{1},
+		Span:    apm.SpanID{2},
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	}
+	tx = tracer.StartTransactionOptions("child", "type", apm.TransactionOptions{TraceContext: parent})
+	tx.End()
+
+	assert.Equal(t, []apm.SampleDecision{{
+		TransactionName: "root",
+		Sampled:         true,
+		Reason:          apm.SampleReasonNoSampler,
+	}, {
+		TransactionName: "unsampled",
+		Sampled:         false,
+		Reason:          apm.SampleReasonSampler,
+	}, {
+		TransactionName:    "child",
+		ParentTraceContext: parent,
+		Sampled:            true,
+		Reason:             apm.SampleReasonParent,
+	}}, decisions)
+}
+
+func TestSamplingReasonTag(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSamplingReasonTag(true)
+
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+
+	transactions := transport.Payloads().Transactions
+	require.Len(t, transactions, 1)
+	assert.Equal(t, model.StringMap{
+		{Key: "sampling_reason", Value: "no_sampler"},
+	}, transactions[0].Context.Tags)
+}
+
+func TestForceSample(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewRatioSampler(0))
+
+	var decisions []apm.SampleDecision
+	tracer.SetSampleDecisionCallback(func(d apm.SampleDecision) {
+		decisions = append(decisions, d)
+	})
+
+	tx := tracer.StartTransactionOptions("root", "type", apm.TransactionOptions{ForceSample: true})
+	assert.True(t, tx.Sampled())
+	tx.End()
+
+	parent := apm.TraceContext{Trace: apm.TraceID{1}, Span: apm.SpanID{2}}
+	tx = tracer.StartTransactionOptions("child", "type", apm.TransactionOptions{
+		TraceContext: parent,
+		ForceSample:  true,
+	})
+	assert.True(t, tx.Sampled())
+	assert.Equal(t, parent.Trace, tx.TraceContext().Trace)
+	tx.End()
+
+	assert.Equal(t, []apm.SampleDecision{{
+		TransactionName: "root",
+		Sampled:         true,
+		Reason:          apm.SampleReasonForced,
+	}, {
+		TransactionName:    "child",
+		ParentTraceContext: parent,
+		Sampled:            true,
+		Reason:             apm.SampleReasonForced,
+	}}, decisions)
+}
+
+func TestTransactionSampler(t *testing.T) {
+	s := apm.NewTransactionSampler(0.5,
+		apm.TransactionSamplingRule{Name: "GET /healthz", Rate: 0},
+		apm.TransactionSamplingRule{Name: "POST /checkout*", Type: "request", Rate: 1},
+	).(apm.ExtendedSampler)
+
+	traceContext := apm.TraceContext{Span: apm.SpanID{255, 255, 255, 255, 255, 255, 255, 255}}
+	sample := func(name, transactionType string) apm.SampleResult {
+		return s.SampleExtended(apm.SampleParams{
+			TraceContext:    traceContext,
+			TransactionName: name,
+			TransactionType: transactionType,
+		})
+	}
+	assert.Equal(t, apm.SampleResult{Sampled: false, SampleRate: 0}, sample("get /HEALTHZ", "request"))
+	assert.Equal(t, apm.SampleResult{Sampled: true, SampleRate: 1}, sample("POST /checkout/confirm", "request"))
+	assert.Equal(t, apm.SampleResult{Sampled: false, SampleRate: 0.5}, sample("POST /checkout", "messaging"))
+	assert.Equal(t, apm.SampleResult{Sampled: false, SampleRate: 0.5}, sample("GET /", "request"))
+	assert.False(t, s.Sample(traceContext))
+
+	assert.Panics(t, func() {
+		apm.NewTransactionSampler(1, apm.TransactionSamplingRule{Rate: 1.5})
+	})
+}
+
+func TestAdaptiveSampler(t *testing.T) {
+	s := apm.NewAdaptiveSampler(10).(apm.ExtendedSampler)
+
+	// Within the first second, the sample rate is 1.0 and
+	// at most 10 transactions are sampled.
+	rng := rand.New(rand.NewSource(0))
+	var sampled int
+	for i := 0; i < 1000; i++ {
+		var traceContext apm.TraceContext
+		binary.LittleEndian.PutUint64(traceContext.Span[:], rng.Uint64())
+		result := s.SampleExtended(apm.SampleParams{TraceContext: traceContext})
+		assert.Equal(t, 1.0, result.SampleRate)
+		if result.Sampled {
+			sampled++
+		}
+	}
+	assert.Equal(t, 10, sampled)
+
+	assert.Panics(t, func() { apm.NewAdaptiveSampler(0) })
+}
+
+func TestSamplerTracestate(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	assert.Equal(t, "", tx.TraceContext().State.String())
+	tx.End()
+
+	tracer.SetSampler(apm.NewRatioSampler(0.25))
+	tx = tracer.StartTransaction("name", "type")
+	assert.Equal(t, "es=s:0.25", tx.TraceContext().State.String())
+	tx.End()
+
+	// The sample rate is propagated as received by non-root transactions.
+	state, err := apm.NewTraceState(apm.TraceStateEntry{Key: "es", Value: "s:0.5"}).Set("acme", "1")
+	require.NoError(t, err)
+	tx = tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		TraceContext: apm.TraceContext{Trace: apm.TraceID{1}, Span: apm.SpanID{2}, State: state},
+	})
+	assert.Equal(t, "acme=1,es=s:0.5", tx.TraceContext().State.String())
+	tx.End()
+}
+
+func TestSamplerTransactionNameSetLate(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewTransactionSampler(1,
+		apm.TransactionSamplingRule{Name: "GET /healthz", Rate: 0},
+	))
+
+	var decisions []apm.SampleDecision
+	tracer.SetSampleDecisionCallback(func(d apm.SampleDecision) {
+		decisions = append(decisions, d)
+	})
+
+	tx := tracer.StartTransaction("GET", "request")
+	assert.True(t, tx.Sampled())
+	tx.Name = "GET /healthz"
+	span := tx.StartSpan("name", "type", nil)
+	assert.True(t, span.Dropped())
+	span.End()
+	assert.False(t, tx.Sampled())
+	assert.Equal(t, "es=s:0", tx.TraceContext().State.String())
+	tx.End()
+
+	// Once the decision has been propagated, it is final.
+	tx = tracer.StartTransaction("GET", "request")
+	traceContext := tx.TraceContext()
+	tx.Name = "GET /healthz"
+	tx.End()
+	assert.True(t, traceContext.Options.Recorded())
+	assert.True(t, tx.Sampled())
+
+	tracer.Flush(nil)
+	transactions := transport.Payloads().Transactions
+	require.Len(t, transactions, 2)
+	require.NotNil(t, transactions[0].Sampled)
+	assert.False(t, *transactions[0].Sampled)
+	assert.Equal(t, []apm.SampleDecision{{
+		TransactionName: "GET /healthz",
+		Sampled:         false,
+		Reason:          apm.SampleReasonSampler,
+	}, {
+		TransactionName: "GET",
+		Sampled:         true,
+		Reason:          apm.SampleReasonSampler,
+	}}, decisions)
+}
+
+func TestAdaptiveSamplerTransactionNameSetLate(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewAdaptiveSampler(1))
+
+	var decisions []apm.SampleDecision
+	tracer.SetSampleDecisionCallback(func(d apm.SampleDecision) {
+		decisions = append(decisions, d)
+	})
+
+	// The adaptive sampler's decision does not depend on the name,
+	// so it is not re-evaluated, and the transaction is counted once.
+	tx := tracer.StartTransaction("GET", "request")
+	tx.Name = "GET /"
+	span := tx.StartSpan("name", "type", nil)
+	assert.False(t, span.Dropped())
+	span.End()
+	assert.True(t, tx.Sampled())
+	tx.End()
+
+	assert.Equal(t, []apm.SampleDecision{{
+		TransactionName: "GET",
+		Sampled:         true,
+		Reason:          apm.SampleReasonSampler,
+	}}, decisions)
+}
+
+func TestTransactionOptionsSampler(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSampler(apm.NewRatioSampler(1))
+
+	tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		Sampler: apm.NewRatioSampler(0),
+	})
+	assert.False(t, tx.Sampled())
+	assert.Equal(t, "es=s:0", tx.TraceContext().State.String())
+	tx.End()
+
+	// The parent's sampling decision takes precedence.
+	tx = tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		TraceContext: apm.TraceContext{
+			Trace:   apm.TraceID{1},
+			Span:    apm.SpanID{2},
+			Options: apm.TraceOptions(0).WithRecorded(true),
+		},
+		Sampler: apm.NewRatioSampler(0),
+	})
+	assert.True(t, tx.Sampled())
+	tx.End()
+}
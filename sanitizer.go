@@ -18,15 +18,27 @@
 package apm
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+
 	"go.elastic.co/apm/internal/wildcard"
 	"go.elastic.co/apm/model"
 )
 
 const redacted = "[REDACTED]"
 
+// maxJSONSanitizeDepth is the maximum nesting depth of JSON bodies
+// scanned by sanitizeJSONBody, matching that of encoding/json. The
+// scan is recursive, so deeper bodies are redacted in their entirety
+// rather than risking exhaustion of the stack.
+const maxJSONSanitizeDepth = 10000
+
 // sanitizeRequest sanitizes HTTP request data, redacting the
-// values of cookies, headers and forms whose corresponding keys
-// match any of the given wildcard patterns.
+// values of cookies, headers, forms, and fields of raw URL-encoded
+// or JSON request bodies, whose corresponding keys match any of the
+// given wildcard patterns.
 func sanitizeRequest(r *model.Request, matchers wildcard.Matchers) {
 	for _, c := range r.Cookies {
 		if !matchers.MatchAny(c.Name) {
@@ -45,6 +57,226 @@ func sanitizeRequest(r *model.Request, matchers wildcard.Matchers) {
 			}
 		}
 	}
+	if r.Body != nil && r.Body.Raw != "" {
+		r.Body.Raw = sanitizeJSONBody(r.Body.Raw, matchers)
+		r.Body.Raw = sanitizeFormBody(r.Body.Raw, matchers)
+	}
+}
+
+// sanitizeFormBody returns body with the values of URL-encoded fields
+// whose names match any of the given wildcard patterns redacted. Other
+// fields, and bodies which are not URL-encoded, are returned unchanged.
+func sanitizeFormBody(body string, matchers wildcard.Matchers) string {
+	if !strings.Contains(body, "=") || strings.ContainsAny(body, " \t\r\n{}[]\"") {
+		return body
+	}
+	fields := strings.Split(body, "&")
+	var changed bool
+	for i, field := range fields {
+		sep := strings.IndexRune(field, '=')
+		if sep < 0 {
+			continue
+		}
+		key, err := url.QueryUnescape(field[:sep])
+		if err != nil || !matchers.MatchAny(key) {
+			continue
+		}
+		fields[i] = field[:sep+1] + redacted
+		changed = true
+	}
+	if !changed {
+		return body
+	}
+	return strings.Join(fields, "&")
+}
+
+// sanitizeJSONBody returns body with the values of object members whose
+// names match any of the given wildcard patterns redacted, if body holds
+// a JSON object or array; otherwise body is returned unchanged.
+//
+// Only redacted values are replaced; the rest of body, including its
+// formatting, is preserved. If body is not valid JSON, e.g. because it
+// was truncated, members up to the point of the error are redacted, and
+// a redacted value which runs to the end of body is replaced entirely.
+// If body is nested more deeply than maxJSONSanitizeDepth, it is replaced
+// entirely.
+func sanitizeJSONBody(body string, matchers wildcard.Matchers) string {
+	s := jsonSanitizer{body: body, matchers: matchers}
+	i := s.skipSpace(0)
+	if i == len(body) || (body[i] != '{' && body[i] != '[') {
+		return body
+	}
+	s.value(i)
+	if s.tooDeep {
+		return redacted
+	}
+	if len(s.redactions) == 0 {
+		return body
+	}
+	var buf bytes.Buffer
+	var last int
+	for _, r := range s.redactions {
+		buf.WriteString(body[last:r.start])
+		buf.WriteString(`"` + redacted + `"`)
+		last = r.end
+	}
+	buf.WriteString(body[last:])
+	return buf.String()
+}
+
+// jsonSanitizer scans a JSON document, recording the byte ranges
+// of values to be redacted.
+type jsonSanitizer struct {
+	body       string
+	matchers   wildcard.Matchers
+	redactions []jsonRange
+
+	// depth holds the number of objects and arrays enclosing the
+	// value being scanned, and tooDeep records whether it exceeded
+	// maxJSONSanitizeDepth.
+	depth   int
+	tooDeep bool
+}
+
+type jsonRange struct {
+	start, end int
+}
+
+func (s *jsonSanitizer) skipSpace(i int) int {
+	for i < len(s.body) {
+		switch s.body[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// value scans the JSON value starting at i, returning the index
+// following it, and whether the value is valid.
+func (s *jsonSanitizer) value(i int) (int, bool) {
+	if i == len(s.body) {
+		return i, false
+	}
+	switch s.body[i] {
+	case '{', '[':
+		if s.depth == maxJSONSanitizeDepth {
+			s.tooDeep = true
+			return i, false
+		}
+		s.depth++
+		var end int
+		var ok bool
+		if s.body[i] == '{' {
+			end, ok = s.object(i + 1)
+		} else {
+			end, ok = s.array(i + 1)
+		}
+		s.depth--
+		return end, ok
+	case '"':
+		return s.str(i + 1)
+	}
+	start := i
+	for i < len(s.body) && strings.IndexByte("+-.0123456789Eaeflnrstu", s.body[i]) >= 0 {
+		i++
+	}
+	return i, i > start
+}
+
+// object scans the members of a JSON object, following its opening
+// brace at i-1, redacting the values of matching members.
+func (s *jsonSanitizer) object(i int) (int, bool) {
+	i = s.skipSpace(i)
+	if i < len(s.body) && s.body[i] == '}' {
+		return i + 1, true
+	}
+	for {
+		if i == len(s.body) || s.body[i] != '"' {
+			return i, false
+		}
+		keyEnd, ok := s.str(i + 1)
+		if !ok {
+			return keyEnd, false
+		}
+		var key string
+		if err := json.Unmarshal([]byte(s.body[i:keyEnd]), &key); err != nil {
+			return keyEnd, false
+		}
+		i = s.skipSpace(keyEnd)
+		if i == len(s.body) || s.body[i] != ':' {
+			return i, false
+		}
+		valueStart := s.skipSpace(i + 1)
+		n := len(s.redactions)
+		valueEnd, ok := s.value(valueStart)
+		if s.matchers.MatchAny(key) {
+			if !ok {
+				valueEnd = len(s.body)
+			}
+			s.redactions = append(s.redactions[:n], jsonRange{valueStart, valueEnd})
+		}
+		if !ok {
+			return valueEnd, false
+		}
+		i = s.skipSpace(valueEnd)
+		if i == len(s.body) {
+			return i, false
+		}
+		switch s.body[i] {
+		case '}':
+			return i + 1, true
+		case ',':
+			i = s.skipSpace(i + 1)
+		default:
+			return i, false
+		}
+	}
+}
+
+// array scans the elements of a JSON array, following its opening
+// bracket at i-1.
+func (s *jsonSanitizer) array(i int) (int, bool) {
+	i = s.skipSpace(i)
+	if i < len(s.body) && s.body[i] == ']' {
+		return i + 1, true
+	}
+	for {
+		end, ok := s.value(i)
+		if !ok {
+			return end, false
+		}
+		i = s.skipSpace(end)
+		if i == len(s.body) {
+			return i, false
+		}
+		switch s.body[i] {
+		case ']':
+			return i + 1, true
+		case ',':
+			i = s.skipSpace(i + 1)
+		default:
+			return i, false
+		}
+	}
+}
+
+// str scans a JSON string, following its opening quote
+// at i-1, returning the index following the closing quote.
+func (s *jsonSanitizer) str(i int) (int, bool) {
+	for i < len(s.body) {
+		switch s.body[i] {
+		case '\\':
+			i += 2
+		case '"':
+			return i + 1, true
+		default:
+			i++
+		}
+	}
+	return len(s.body), false
 }
 
 // sanitizeResponse sanitizes HTTP response data, redacting
@@ -19,7 +19,9 @@ package apm_test
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -104,3 +106,104 @@ func testSetSanitizedFieldNames(t *testing.T, expect string, sanitized ...string
 		{Name: "secret", Value: expect},
 	})
 }
+
+func TestSanitizeRequestBody(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetCaptureBody(apm.CaptureBodyAll)
+
+	send := func(contentType, body string) {
+		req, _ := http.NewRequest("POST", "http://server.testing/", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		bc := tracer.CaptureHTTPRequestBody(req)
+		tx := tracer.StartTransaction("name", "type")
+		e := tracer.NewError(errors.New("boom"))
+		e.SetTransaction(tx)
+		e.Context.SetHTTPRequest(req)
+		e.Context.SetHTTPRequestBody(bc)
+		e.Send()
+		tx.Context.SetHTTPRequest(req)
+		tx.Context.SetHTTPRequestBody(bc)
+		tx.End()
+	}
+	send("application/json", `{"user": "alice", "password": {"old": "a", "new": "b"}, "items": [{"api_key": 1}, 2]}`)
+	send("text/plain", "my password is hunter2")
+	send("application/x-www-form-urlencoded", "user=alice&password=hunter2&other")
+	send("application/json", `{"user": "alice", "cards": [{"number": 1}], "secret": "hun`)
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 4)
+	require.Len(t, payloads.Errors, 4)
+	for i, expected := range []*model.RequestBody{
+		{Raw: `{"user": "alice", "password": "[REDACTED]", "items": [{"api_key": "[REDACTED]"}, 2]}`},
+		{Raw: "my password is hunter2"},
+		{Raw: "user=alice&password=[REDACTED]&other"},
+		{Raw: `{"user": "alice", "cards": "[REDACTED]", "secret": "[REDACTED]"`},
+	} {
+		assert.Equal(t, expected, payloads.Transactions[i].Context.Request.Body)
+		assert.Equal(t, expected, payloads.Errors[i].Context.Request.Body)
+	}
+}
+
+func TestSanitizeRequestBodyDeeplyNested(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetCaptureBody(apm.CaptureBodyAll)
+	tracer.SetCaptureBodyMaxSize(8 << 20)
+
+	send := func(body string) {
+		req, _ := http.NewRequest("POST", "http://server.testing/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		bc := tracer.CaptureHTTPRequestBody(req)
+		tx := tracer.StartTransaction("name", "type")
+		tx.Context.SetHTTPRequest(req)
+		tx.Context.SetHTTPRequestBody(bc)
+		tx.End()
+	}
+	nested := func(depth int, value string) string {
+		return strings.Repeat("[", depth) + value + strings.Repeat("]", depth)
+	}
+	send(nested(100, `{"password": "hunter2"}`))
+	send(nested(10000, `{"password": "hunter2"}`))
+	send(strings.Repeat("[", 8<<20))
+	tracer.Flush(nil)
+
+	// Bodies nested too deeply to be scanned are redacted entirely.
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 3)
+	assert.Equal(t, nested(100, `{"password": "[REDACTED]"}`), payloads.Transactions[0].Context.Request.Body.Raw)
+	assert.Equal(t, "[REDACTED]", payloads.Transactions[1].Context.Request.Body.Raw)
+	assert.Equal(t, "[REDACTED]", payloads.Transactions[2].Context.Request.Body.Raw)
+}
+
+func TestSanitizeRequestBodyUnredacted(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetCaptureBody(apm.CaptureBodyAll)
+
+	// Bodies with nothing to redact are recorded as captured.
+	bodies := []string{
+		`{hello world`,
+		`{"a":"x<y&z"}`,
+		`[1, 2,   3]`,
+		`{"a":"trunc`,
+		`{"a": {"b": [true, null, -1.5e3]}, "c": "\"secret\""}`,
+	}
+	for _, body := range bodies {
+		req, _ := http.NewRequest("POST", "http://server.testing/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		bc := tracer.CaptureHTTPRequestBody(req)
+		tx := tracer.StartTransaction("name", "type")
+		tx.Context.SetHTTPRequest(req)
+		tx.Context.SetHTTPRequestBody(bc)
+		tx.End()
+	}
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, len(bodies))
+	for i, body := range bodies {
+		assert.Equal(t, &model.RequestBody{Raw: body}, payloads.Transactions[i].Context.Request.Body)
+	}
+}
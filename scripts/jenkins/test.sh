@@ -13,3 +13,4 @@ go get -v -t ./...
 mkdir -p build
 
 (go test -race ./... -v 2>&1 | go-junit-report > build/junit-apm-agent-go.xml) || echo -e "\033[31;49mTests FAILED\033[0m"
+(go test -race -tags requirefips ./transport -v 2>&1 | go-junit-report > build/junit-apm-agent-go-fips.xml) || echo -e "\033[31;49mFIPS tests FAILED\033[0m"
@@ -19,9 +19,9 @@ package apm
 
 import (
 	cryptorand "crypto/rand"
-	"encoding/binary"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.elastic.co/apm/stacktrace"
@@ -62,35 +62,51 @@ func (tx *Transaction) StartSpan(name, spanType string, parent *Span) *Span {
 // the span type, subtype, and action; a single dot separates span
 // type and subtype, and the action will not be set.
 func (tx *Transaction) StartSpanOptions(name, spanType string, opts SpanOptions) *Span {
-	if tx == nil || !tx.traceContext.Options.Recorded() {
+	if tx == nil {
+		return newDroppedSpan()
+	}
+	tx.resolveSampling()
+	if !tx.traceContext.Options.Recorded() {
 		return newDroppedSpan()
 	}
 
-	if opts.Parent == (TraceContext{}) {
-		if opts.parent != nil {
-			opts.Parent = opts.parent.TraceContext()
-		} else {
-			opts.Parent = tx.traceContext
-		}
+	if opts.Parent == (TraceContext{}) && opts.parent != nil {
+		opts.Parent = opts.parent.TraceContext()
 	}
-	transactionID := tx.traceContext.Span
 
 	// Prevent tx from being ended while we're starting a span.
 	tx.mu.RLock()
 	defer tx.mu.RUnlock()
 
+	if opts.Parent == (TraceContext{}) {
+		opts.Parent = tx.traceContext
+	}
+	transactionID := tx.traceContext.Span
+
 	if tx.ended() {
 		return tx.tracer.StartSpan(name, spanType, transactionID, opts)
 	}
 
-	// Guard access to spansCreated, spansDropped, maxSpans, timestamp,
-	// rand, and spanFramesMinDuration.
+	// Guard access to spansCreated, spansDropped, maxSpans, the span
+	// rate window, timestamp, rand, and spanFramesMinDuration.
 	tx.TransactionData.mu.Lock()
 	defer tx.TransactionData.mu.Unlock()
 	if tx.maxSpans > 0 && tx.spansCreated >= tx.maxSpans {
 		tx.spansDropped++
 		return newDroppedSpan()
 	}
+	if tx.maxSpansPerSecond > 0 {
+		now := time.Now()
+		if now.Sub(tx.spanRateStart) >= time.Second {
+			tx.spanRateStart = now
+			tx.spanRateCount = 0
+		}
+		if tx.spanRateCount >= tx.maxSpansPerSecond {
+			tx.spansDropped++
+			return newDroppedSpan()
+		}
+		tx.spanRateCount++
+	}
 
 	// Calculate the span time relative to the transaction timestamp so
 	// that wall-clock adjustments occurring after the transaction start
@@ -105,10 +121,26 @@ func (tx *Transaction) StartSpanOptions(name, spanType string, opts SpanOptions)
 	if opts.SpanID.Validate() == nil {
 		span.traceContext.Span = opts.SpanID
 	} else {
-		binary.LittleEndian.PutUint64(span.traceContext.Span[:], tx.rand.Uint64())
+		span.traceContext.Span = tx.newSpanID()
+	}
+	span.stackFramesMinDuration = spanFramesMinDuration(
+		spanType, tx.spanFramesMinDuration, tx.spanFramesMinDurationByType,
+	)
+	if opts.ExitSpan {
+		span.exitSpanMinDuration = tx.exitSpanMinDuration
+		span.spanCompression = tx.spanCompression
+	}
+	if opts.parent != nil && !opts.parent.dropped() && opts.Parent.Span == opts.parent.traceContext.Span {
+		span.parent = opts.parent
 	}
-	span.stackFramesMinDuration = tx.spanFramesMinDuration
 	span.tx = tx
+	span.clockSkew = tx.clockSkew
+	for _, tag := range tx.baggageTags {
+		span.Context.SetTag(tag.Key, tag.Value)
+	}
+	span.withParentChildrenTimer(func(t *childrenTimer) {
+		t.childStarted(opts.Start)
+	})
 	tx.spansCreated++
 	return span
 }
@@ -133,8 +165,16 @@ func (t *Tracer) StartSpan(name, spanType string, transactionID SpanID, opts Spa
 	if opts.SpanID.Validate() == nil {
 		spanID = opts.SpanID
 	} else {
-		if _, err := cryptorand.Read(spanID[:]); err != nil {
-			return newDroppedSpan()
+		t.idGeneratorMu.RLock()
+		idGenerator := t.idGenerator
+		t.idGeneratorMu.RUnlock()
+		if idGenerator != nil {
+			spanID = idGenerator.NewSpanID()
+		}
+		if spanID.Validate() != nil {
+			if _, err := cryptorand.Read(spanID[:]); err != nil {
+				return newDroppedSpan()
+			}
 		}
 	}
 	if opts.Start.IsZero() {
@@ -143,11 +183,30 @@ func (t *Tracer) StartSpan(name, spanType string, transactionID SpanID, opts Spa
 	span := t.startSpan(name, spanType, transactionID, opts)
 	span.traceContext.Span = spanID
 	t.spanFramesMinDurationMu.RLock()
-	span.stackFramesMinDuration = t.spanFramesMinDuration
+	span.stackFramesMinDuration = spanFramesMinDuration(
+		spanType, t.spanFramesMinDuration, t.spanFramesMinDurationByType,
+	)
 	t.spanFramesMinDurationMu.RUnlock()
 	return span
 }
 
+// spanFramesMinDuration returns the minimum duration for spans of the
+// given type after which their stack frames are captured: the duration
+// in byType keyed by the span type up to the first ".", if any, or else
+// defaultDuration.
+func spanFramesMinDuration(spanType string, defaultDuration time.Duration, byType map[string]time.Duration) time.Duration {
+	if len(byType) == 0 {
+		return defaultDuration
+	}
+	if dot := strings.IndexRune(spanType, '.'); dot >= 0 {
+		spanType = spanType[:dot]
+	}
+	if d, ok := byType[spanType]; ok {
+		return d
+	}
+	return defaultDuration
+}
+
 // SpanOptions holds options for Transaction.StartSpanOptions and Tracer.StartSpan.
 type SpanOptions struct {
 	// Parent, if non-zero, holds the trace context of the parent span.
@@ -163,6 +222,16 @@ type SpanOptions struct {
 	// callers of Transaction.StartSpanOptions.
 	parent *Span
 
+	// ExitSpan indicates that the span describes an operation on an
+	// external service, such as a database query or an outgoing HTTP
+	// request.
+	//
+	// Exit spans whose duration is less than the tracer's configured
+	// exit span minimum duration will be dropped when they are ended.
+	// If span compression is enabled, consecutive similar exit spans
+	// may be compressed into a single composite span.
+	ExitSpan bool
+
 	// Start is the start time of the span. If this has the zero value,
 	// time.Now() will be used instead.
 	//
@@ -176,6 +245,11 @@ type SpanOptions struct {
 	// transaction timestamp. Calculating the timstamp in this way will ensure
 	// monotonicity of events within a transaction.
 	Start time.Time
+
+	// Links holds links to other spans or transactions, e.g. those which
+	// produced the messages processed by the span. Spans with links are
+	// never compressed. See also LinksFromCarriers.
+	Links []SpanLink
 }
 
 func (t *Tracer) startSpan(name, spanType string, transactionID SpanID, opts SpanOptions) *Span {
@@ -189,6 +263,11 @@ func (t *Tracer) startSpan(name, spanType string, transactionID SpanID, opts Spa
 	span.parentID = opts.Parent.Span
 	span.transactionID = transactionID
 	span.timestamp = opts.Start
+	span.clockSkew = t.clockSkewOffset()
+	span.exit = opts.ExitSpan
+	if len(opts.Links) != 0 {
+		span.links = append([]SpanLink(nil), opts.Links...)
+	}
 	span.Type = spanType
 	if dot := strings.IndexRune(spanType, '.'); dot != -1 {
 		span.Type = spanType[:dot]
@@ -212,9 +291,24 @@ func newDroppedSpan() *Span {
 type Span struct {
 	tracer        *Tracer      // nil if span is dropped
 	tx            *Transaction // nil if span is dropped
+	parent        *Span        // nil if the parent is the transaction, or unknown
 	traceContext  TraceContext
 	transactionID SpanID
 
+	// childrenMu guards children, selfTime and fastDropped. No
+	// other locks may be acquired while holding childrenMu.
+	childrenMu  sync.Mutex
+	children    childrenTimer
+	selfTime    time.Duration
+	fastDropped bool
+
+	// propagated is set to 1 when the span's trace context has been
+	// obtained, e.g. for propagating to another service, starting a
+	// child span, or associating an error. Spans whose trace context
+	// has been propagated are never compressed, as events may refer
+	// to their IDs. propagated must be accessed atomically.
+	propagated int32
+
 	mu sync.RWMutex
 
 	// SpanData holds the span data. This field is set to nil when
@@ -223,10 +317,14 @@ type Span struct {
 }
 
 // TraceContext returns the span's TraceContext.
+//
+// Calling TraceContext prevents the span from being compressed,
+// as the span's ID may be referenced by other events.
 func (s *Span) TraceContext() TraceContext {
 	if s == nil {
 		return TraceContext{}
 	}
+	atomic.StoreInt32(&s.propagated, 1)
 	return s.traceContext
 }
 
@@ -245,6 +343,41 @@ func (s *Span) SetStacktrace(skip int) {
 	s.SpanData.setStacktrace(skip + 1)
 }
 
+// SelfTime returns the span's self-time: its duration, minus the time
+// during which at least one of its direct child spans was active. Child
+// spans still active when s ends are treated as ending with s.
+//
+// Unlike other methods, SelfTime may be called after End. SelfTime
+// returns false if s has not ended, or is dropped, including exit spans
+// dropped when ending for being shorter than the exit span minimum duration.
+func (s *Span) SelfTime() (time.Duration, bool) {
+	if s == nil {
+		return 0, false
+	}
+	s.childrenMu.Lock()
+	defer s.childrenMu.Unlock()
+	return s.selfTime, s.children.ended && !s.fastDropped
+}
+
+// withParentChildrenTimer calls f with the children timer of s's
+// parent, locked, if the parent is known: either s's transaction,
+// or a span from which s was started.
+func (s *Span) withParentChildrenTimer(f func(*childrenTimer)) {
+	var mu *sync.Mutex
+	var t *childrenTimer
+	switch {
+	case s.parent != nil:
+		mu, t = &s.parent.childrenMu, &s.parent.children
+	case s.tx != nil && s.parentID == s.tx.traceContext.Span:
+		mu, t = &s.tx.childrenMu, &s.tx.children
+	default:
+		return
+	}
+	mu.Lock()
+	f(t)
+	mu.Unlock()
+}
+
 // Dropped indicates whether or not the span is dropped, meaning it will not
 // be included in any transaction. Spans are dropped by Transaction.StartSpan
 // if the transaction is nil, non-sampled, or the transaction's max spans
@@ -270,6 +403,38 @@ func (s *Span) End() {
 	if s.ended() {
 		return
 	}
+	s.end()
+}
+
+// EndWithDuration sets the start time and duration of s, and then
+// ends it as with End. This may be used for recording spans whose
+// timings are obtained from an external system, e.g. when importing
+// or replaying events after the fact.
+//
+// If start is the zero value, the span's existing start time will be
+// retained. A negative duration is treated as zero.
+func (s *Span) EndWithDuration(start time.Time, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended() {
+		return
+	}
+	if !s.dropped() {
+		if !start.IsZero() {
+			s.timestamp = start
+		}
+		if d < 0 {
+			d = 0
+		}
+		s.Duration = d
+	}
+	s.end()
+}
+
+// end ends s. This must be called with s.mu held, by End or
+// EndWithDuration, as the stack trace is captured relative to
+// their caller.
+func (s *Span) end() {
 	if s.dropped() {
 		droppedSpanDataPool.Put(s.SpanData)
 		s.SpanData = nil
@@ -278,17 +443,57 @@ func (s *Span) End() {
 	if s.Duration < 0 {
 		s.Duration = time.Since(s.timestamp)
 	}
+	end := s.timestamp.Add(s.Duration)
+	s.childrenMu.Lock()
+	s.selfTime = s.children.selfTime(end, s.Duration)
+	s.childrenMu.Unlock()
+	s.withParentChildrenTimer(func(t *childrenTimer) {
+		t.childEnded(end)
+	})
+	if s.exit && s.Duration < s.exitSpanMinDuration && atomic.LoadInt32(&s.propagated) == 0 && s.dropFast() {
+		s.childrenMu.Lock()
+		s.fastDropped = true
+		s.childrenMu.Unlock()
+		s.reset(s.tracer)
+		s.SpanData = nil
+		return
+	}
 	if len(s.stacktrace) == 0 && s.Duration >= s.stackFramesMinDuration {
-		s.setStacktrace(1)
+		s.setStacktrace(2)
+	}
+	s.flushCompressedSpan()
+	if !s.compress() {
+		s.enqueue(s.SpanData)
 	}
-	s.enqueue()
 	s.SpanData = nil
 }
 
-func (s *Span) enqueue() {
+// dropFast records s as dropped in its transaction's span count, for exit
+// spans which ended before the exit span minimum duration, and whose trace
+// context has not been propagated. If the transaction has already ended,
+// dropFast returns false and the span should be reported.
+func (s *Span) dropFast() bool {
+	if s.tx == nil {
+		return false
+	}
+	s.tx.mu.RLock()
+	defer s.tx.mu.RUnlock()
+	if s.tx.ended() {
+		return false
+	}
+	s.tx.TransactionData.mu.Lock()
+	s.tx.spansCreated--
+	s.tx.spansDropped++
+	s.tx.TransactionData.mu.Unlock()
+	return true
+}
+
+// enqueue enqueues s, with the given span data, for sending
+// to the APM Server.
+func (s *Span) enqueue(sd *SpanData) {
 	event := tracerEvent{eventType: spanEvent}
 	event.span.Span = s
-	event.span.SpanData = s.SpanData
+	event.span.SpanData = sd
 	select {
 	case s.tracer.events <- event:
 	default:
@@ -296,7 +501,8 @@ func (s *Span) enqueue() {
 		s.tracer.statsMu.Lock()
 		s.tracer.stats.SpansDropped++
 		s.tracer.statsMu.Unlock()
-		s.reset(s.tracer)
+		s.tracer.eventsDropped("span", 1)
+		sd.reset(s.tracer)
 	}
 }
 
@@ -308,9 +514,21 @@ func (s *Span) ended() bool {
 // When a span is ended or discarded, its SpanData field will be set
 // to nil.
 type SpanData struct {
+	exit                   bool
 	parentID               SpanID
 	stackFramesMinDuration time.Duration
+	exitSpanMinDuration    time.Duration
+	spanCompression        spanCompressionConfig
 	timestamp              time.Time
+	clockSkew              time.Duration
+	links                  []SpanLink
+
+	// composite records the spans compressed into this one, if any.
+	composite compositeSpan
+
+	// compressedSpan holds the most recently ended child span which
+	// may be compressed with its subsequently ended siblings.
+	compressedSpan compressedSpan
 
 	// Name holds the span name, initialized with the value passed to StartSpan.
 	Name string
@@ -0,0 +1,224 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.elastic.co/apm/model"
+)
+
+const (
+	compressionStrategyExactMatch = "exact_match"
+	compressionStrategySameKind   = "same_kind"
+)
+
+// spanCompressionConfig holds the span compression configuration.
+type spanCompressionConfig struct {
+	enabled               bool
+	exactMatchMaxDuration time.Duration
+	sameKindMaxDuration   time.Duration
+}
+
+// compositeSpan records the spans compressed into a span.
+type compositeSpan struct {
+	// count holds the number of spans compressed, including the
+	// composite span itself. count is zero if no spans have
+	// been compressed.
+	count               int
+	sum                 time.Duration
+	compressionStrategy string
+}
+
+func (c *compositeSpan) build() *model.CompositeSpan {
+	if c.count == 0 {
+		return nil
+	}
+	return &model.CompositeSpan{
+		CompressionStrategy: c.compressionStrategy,
+		Count:               c.count,
+		Sum:                 c.sum.Seconds() * 1000,
+	}
+}
+
+// compressedSpan holds an ended span, which has not yet been enqueued
+// so that it may be compressed with its subsequently ended siblings.
+type compressedSpan struct {
+	span *Span
+	data *SpanData
+}
+
+// enqueue enqueues the buffered span, if any.
+func (cs compressedSpan) enqueue() {
+	if cs.span != nil {
+		cs.span.enqueue(cs.data)
+	}
+}
+
+// compress attempts to compress sd, the data of an ended span, into the
+// buffered span, reporting whether it was compressed. The buffered span
+// must be non-nil.
+func (cs *compressedSpan) compress(sd *SpanData) bool {
+	buffered := cs.data
+	if buffered.Type != sd.Type || buffered.Subtype != sd.Subtype {
+		return false
+	}
+	if buffered.Context.destination != sd.Context.destination {
+		return false
+	}
+	cfg := buffered.spanCompression
+	var strategy string
+	switch buffered.composite.compressionStrategy {
+	case compressionStrategyExactMatch:
+		if buffered.Name == sd.Name && sd.Duration <= cfg.exactMatchMaxDuration {
+			strategy = compressionStrategyExactMatch
+		}
+	case compressionStrategySameKind:
+		if sd.Duration <= cfg.sameKindMaxDuration {
+			strategy = compressionStrategySameKind
+		}
+	default:
+		maxDuration := buffered.Duration
+		if sd.Duration > maxDuration {
+			maxDuration = sd.Duration
+		}
+		if buffered.Name == sd.Name && maxDuration <= cfg.exactMatchMaxDuration {
+			strategy = compressionStrategyExactMatch
+		} else if maxDuration <= cfg.sameKindMaxDuration {
+			strategy = compressionStrategySameKind
+			buffered.Name = sameKindSpanName(buffered)
+		}
+	}
+	if strategy == "" {
+		return false
+	}
+
+	if buffered.composite.count == 0 {
+		buffered.composite.count = 1
+		buffered.composite.sum = buffered.Duration
+		buffered.composite.compressionStrategy = strategy
+	}
+	buffered.composite.count++
+	buffered.composite.sum += sd.Duration
+	if end := sd.timestamp.Add(sd.Duration); end.After(buffered.timestamp.Add(buffered.Duration)) {
+		buffered.Duration = end.Sub(buffered.timestamp)
+	}
+	return true
+}
+
+// sameKindSpanName returns the name for a span compressed
+// with the "same_kind" strategy: "Calls to <destination>".
+func sameKindSpanName(sd *SpanData) string {
+	destination := sd.Context.destination.Address
+	if destination != "" && sd.Context.destination.Port > 0 {
+		destination += ":" + strconv.Itoa(sd.Context.destination.Port)
+	}
+	if destination == "" {
+		destination = sd.Subtype
+	}
+	if destination == "" {
+		destination = sd.Type
+	}
+	return "Calls to " + destination
+}
+
+// compressible reports whether s may be compressed with its siblings.
+//
+// This must be called with s.mu held.
+func (s *Span) compressible() bool {
+	return s.exit && s.spanCompression.enabled && len(s.links) == 0 && atomic.LoadInt32(&s.propagated) == 0
+}
+
+// compress attempts to compress the ended span s into the most recently
+// ended sibling buffered by its parent, or otherwise to buffer s in its
+// place, reporting whether s has been compressed or buffered. Any sibling
+// which s cannot be compressed into is enqueued, preserving the order in
+// which the spans ended.
+//
+// If compress returns false, s should be enqueued by the caller.
+//
+// This must be called with s.mu held, and s must not have ended.
+func (s *Span) compress() bool {
+	var evicted compressedSpan
+	var consumed, compressed bool
+	s.withParentCompressedSpan(func(buffered *compressedSpan) {
+		switch {
+		case !s.compressible():
+			evicted, *buffered = *buffered, compressedSpan{}
+		case buffered.span == nil:
+			*buffered = compressedSpan{span: s, data: s.SpanData}
+			consumed = true
+		case buffered.compress(s.SpanData):
+			consumed, compressed = true, true
+		default:
+			evicted = *buffered
+			*buffered = compressedSpan{span: s, data: s.SpanData}
+			consumed = true
+		}
+	})
+	evicted.enqueue()
+	if compressed {
+		// Compressed spans count only once towards the
+		// transaction's started spans and max spans limit.
+		s.tx.mu.RLock()
+		if !s.tx.ended() {
+			s.tx.TransactionData.mu.Lock()
+			s.tx.spansCreated--
+			s.tx.TransactionData.mu.Unlock()
+		}
+		s.tx.mu.RUnlock()
+		s.reset(s.tracer)
+	}
+	return consumed
+}
+
+// withParentCompressedSpan calls f with the compressed span buffer of
+// s's parent, locked, if the parent is known and has not ended.
+//
+// This must be called with s.mu held.
+func (s *Span) withParentCompressedSpan(f func(*compressedSpan)) {
+	if s.parent != nil {
+		s.parent.mu.Lock()
+		defer s.parent.mu.Unlock()
+		if !s.parent.ended() {
+			f(&s.parent.compressedSpan)
+		}
+		return
+	}
+	if s.tx == nil || s.parentID != s.tx.traceContext.Span {
+		return
+	}
+	s.tx.mu.RLock()
+	defer s.tx.mu.RUnlock()
+	if s.tx.ended() {
+		return
+	}
+	s.tx.TransactionData.mu.Lock()
+	defer s.tx.TransactionData.mu.Unlock()
+	f(&s.tx.compressedSpan)
+}
+
+// flushCompressedSpan enqueues the child span buffered for compression,
+// if any. This must be called with s.mu held, and s must not have ended.
+func (s *Span) flushCompressedSpan() {
+	buffered := s.compressedSpan
+	s.compressedSpan = compressedSpan{}
+	buffered.enqueue()
+}
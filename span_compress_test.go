@@ -0,0 +1,243 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestSpanCompressionExactMatch(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSpanCompressionEnabled(true)
+
+	tx := tracer.StartTransaction("name", "type")
+	start := time.Now().Truncate(time.Millisecond)
+	for i := 0; i < 5; i++ {
+		span := tx.StartSpanOptions("GET", "db.redis", apm.SpanOptions{ExitSpan: true})
+		span.Context.SetDestinationAddress("redis", 6379)
+		span.EndWithDuration(start.Add(time.Duration(i)*2*time.Millisecond), time.Millisecond)
+	}
+	span := tx.StartSpanOptions("SET", "db.redis", apm.SpanOptions{ExitSpan: true})
+	span.Context.SetDestinationAddress("redis", 6379)
+	span.EndWithDuration(start.Add(10*time.Millisecond), time.Millisecond)
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Spans, 2)
+	assert.Equal(t, "GET", payloads.Spans[0].Name)
+	assert.Equal(t, model.Time(start.UTC()), payloads.Spans[0].Timestamp)
+	assert.Equal(t, float64(9), payloads.Spans[0].Duration)
+	assert.Equal(t, &model.CompositeSpan{
+		CompressionStrategy: "exact_match",
+		Count:               5,
+		Sum:                 5,
+	}, payloads.Spans[0].Composite)
+	assert.Equal(t, "SET", payloads.Spans[1].Name)
+	assert.Nil(t, payloads.Spans[1].Composite)
+
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, 2, payloads.Transactions[0].SpanCount.Started)
+	assert.Equal(t, 0, payloads.Transactions[0].SpanCount.Dropped)
+}
+
+func TestSpanCompressionSameKind(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSpanCompressionEnabled(true)
+	tracer.SetSpanCompressionSameKindMaxDuration(5 * time.Millisecond)
+
+	tx := tracer.StartTransaction("name", "type")
+	for _, name := range []string{"SELECT FROM foo", "SELECT FROM bar", "SELECT FROM baz"} {
+		span := tx.StartSpanOptions(name, "db.postgresql.query", apm.SpanOptions{ExitSpan: true})
+		span.Context.SetDestinationAddress("postgres", 5432)
+		span.Duration = 2 * time.Millisecond
+		span.End()
+	}
+	// A slow span breaks the sequence.
+	span := tx.StartSpanOptions("SELECT FROM qux", "db.postgresql.query", apm.SpanOptions{ExitSpan: true})
+	span.Context.SetDestinationAddress("postgres", 5432)
+	span.Duration = 6 * time.Millisecond
+	span.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	spans := recorder.Payloads().Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "Calls to postgres:5432", spans[0].Name)
+	assert.Equal(t, &model.CompositeSpan{
+		CompressionStrategy: "same_kind",
+		Count:               3,
+		Sum:                 6,
+	}, spans[0].Composite)
+	assert.Equal(t, "SELECT FROM qux", spans[1].Name)
+	assert.Nil(t, spans[1].Composite)
+}
+
+func TestSpanCompressionDisabled(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	for i := 0; i < 3; i++ {
+		span := tx.StartSpanOptions("GET", "db.redis", apm.SpanOptions{ExitSpan: true})
+		span.Duration = time.Millisecond
+		span.End()
+	}
+	tx.End()
+	tracer.Flush(nil)
+
+	spans := recorder.Payloads().Spans
+	require.Len(t, spans, 3)
+	for _, span := range spans {
+		assert.Nil(t, span.Composite)
+	}
+}
+
+func TestSpanCompressionIncompatible(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSpanCompressionEnabled(true)
+
+	tx := tracer.StartTransaction("name", "type")
+	startSpan := func(name string, exit bool) *apm.Span {
+		span := tx.StartSpanOptions(name, "db.redis", apm.SpanOptions{ExitSpan: exit})
+		span.Context.SetDestinationAddress("redis", 6379)
+		span.Duration = time.Millisecond
+		return span
+	}
+	startSpan("GET", true).End()
+	startSpan("GET", true).End()
+
+	// Spans whose trace context has been propagated are not compressed.
+	propagated := startSpan("GET", true)
+	propagated.TraceContext()
+	propagated.End()
+
+	// Spans with a different destination are not compressed.
+	other := startSpan("GET", true)
+	other.Context.SetDestinationAddress("redis-2", 6379)
+	other.End()
+
+	// Non-exit spans are not compressed.
+	startSpan("GET", false).End()
+	startSpan("GET", true).End()
+
+	// Slow spans are not compressed.
+	slow := startSpan("GET", true)
+	slow.Duration = 51 * time.Millisecond
+	slow.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	spans := recorder.Payloads().Spans
+	require.Len(t, spans, 6)
+	var counts []int
+	for _, span := range spans {
+		count := 1
+		if span.Composite != nil {
+			count = span.Composite.Count
+		}
+		counts = append(counts, count)
+	}
+	assert.Equal(t, []int{2, 1, 1, 1, 1, 1}, counts)
+	assert.Equal(t, "redis-2", spans[2].Context.Destination.Address)
+}
+
+func TestSpanCompressionLinks(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSpanCompressionEnabled(true)
+
+	tx := tracer.StartTransaction("name", "type")
+	start := time.Now()
+	links := []apm.SpanLink{{Trace: apm.TraceID{1}, Span: apm.SpanID{1}}}
+	for i := 0; i < 3; i++ {
+		span := tx.StartSpanOptions("GET", "db.redis", apm.SpanOptions{ExitSpan: true, Links: links})
+		span.Context.SetDestinationAddress("redis", 6379)
+		span.EndWithDuration(start.Add(time.Duration(i)*2*time.Millisecond), time.Millisecond)
+	}
+	tx.End()
+	tracer.Flush(nil)
+
+	// Spans with links are never compressed.
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Spans, 3)
+	for _, span := range payloads.Spans {
+		assert.Nil(t, span.Composite)
+		assert.Len(t, span.Links, 1)
+	}
+}
+
+func TestSpanCompressionChildSpans(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSpanCompressionEnabled(true)
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	parent, ctx := apm.StartSpan(ctx, "parent", "custom")
+	for i := 0; i < 3; i++ {
+		span, _ := apm.StartSpanOptions(ctx, "GET", "db.redis", apm.SpanOptions{ExitSpan: true})
+		span.End()
+	}
+	parent.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	spans := recorder.Payloads().Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "GET", spans[0].Name)
+	assert.Equal(t, spans[1].ID, spans[0].ParentID)
+	require.NotNil(t, spans[0].Composite)
+	assert.Equal(t, 3, spans[0].Composite.Count)
+	assert.Equal(t, "parent", spans[1].Name)
+	assert.Nil(t, spans[1].Composite)
+}
+
+func TestSpanCompressionMaxSpans(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetSpanCompressionEnabled(true)
+	tracer.SetMaxSpans(2)
+
+	tx := tracer.StartTransaction("name", "type")
+	for i := 0; i < 10; i++ {
+		span := tx.StartSpanOptions("GET", "db.redis", apm.SpanOptions{ExitSpan: true})
+		span.Duration = time.Millisecond
+		span.End()
+	}
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, 10, payloads.Spans[0].Composite.Count)
+	assert.Equal(t, 1, payloads.Transactions[0].SpanCount.Started)
+	assert.Equal(t, 0, payloads.Transactions[0].SpanCount.Dropped)
+}
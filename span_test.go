@@ -105,6 +105,18 @@ func TestSpanTiming(t *testing.T) {
 	assert.InDelta(t, span.Duration, 200, 100)
 }
 
+func TestSpanEndWithDuration(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		span, _ := apm.StartSpan(ctx, "name", "type")
+		span.EndWithDuration(start, 3*time.Second)
+		span.End() // no-op
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, model.Time(start), spans[0].Timestamp)
+	assert.Equal(t, 3000.0, spans[0].Duration)
+}
+
 func TestSpanType(t *testing.T) {
 	spanTypes := []string{"type", "type.subtype", "type.subtype.action", "type.subtype.action.figure"}
 	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
@@ -135,3 +147,61 @@ func TestTracerStartSpanIDSpecified(t *testing.T) {
 	require.Len(t, spans, 1)
 	assert.Equal(t, model.SpanID(spanID), spans[0].ID)
 }
+
+func TestExitSpanMinDuration(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetExitSpanMinDuration(time.Millisecond)
+
+	tx := tracer.StartTransaction("name", "type")
+	fast := tx.StartSpanOptions("fast", "db", apm.SpanOptions{ExitSpan: true})
+	fast.Duration = 999 * time.Microsecond
+	fast.End()
+	slow := tx.StartSpanOptions("slow", "db", apm.SpanOptions{ExitSpan: true})
+	slow.Duration = time.Millisecond
+	slow.End()
+	internal := tx.StartSpan("internal", "type", nil)
+	internal.Duration = time.Microsecond
+	internal.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Spans, 2)
+	assert.Equal(t, "slow", payloads.Spans[0].Name)
+	assert.Equal(t, "internal", payloads.Spans[1].Name)
+
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, 2, payloads.Transactions[0].SpanCount.Started)
+	assert.Equal(t, 1, payloads.Transactions[0].SpanCount.Dropped)
+
+	_, ok := fast.SelfTime()
+	assert.False(t, ok)
+	selfTime, ok := slow.SelfTime()
+	assert.True(t, ok)
+	assert.Equal(t, time.Millisecond, selfTime)
+}
+
+func TestExitSpanMinDurationPropagated(t *testing.T) {
+	tracer, r := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetExitSpanMinDuration(time.Millisecond)
+
+	// Exit spans whose trace context has been propagated may be
+	// referenced by downstream services, so they are never dropped
+	// for being too short.
+	tx := tracer.StartTransaction("name", "type")
+	span := tx.StartSpanOptions("fast", "db", apm.SpanOptions{ExitSpan: true})
+	traceContext := span.TraceContext()
+	span.Duration = time.Microsecond
+	span.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := r.Payloads()
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, model.SpanID(traceContext.Span), payloads.Spans[0].ID)
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, 1, payloads.Transactions[0].SpanCount.Started)
+	assert.Equal(t, 0, payloads.Transactions[0].SpanCount.Dropped)
+}
@@ -25,9 +25,10 @@ import (
 
 // SpanContext provides methods for setting span context.
 type SpanContext struct {
-	model    model.SpanContext
-	database model.DatabaseSpanContext
-	http     model.HTTPSpanContext
+	model       model.SpanContext
+	database    model.DatabaseSpanContext
+	destination model.DestinationSpanContext
+	http        model.HTTPSpanContext
 }
 
 // DatabaseSpanContext holds database span context.
@@ -50,6 +51,7 @@ func (c *SpanContext) build() *model.SpanContext {
 	switch {
 	case len(c.model.Tags) != 0:
 	case c.model.Database != nil:
+	case c.model.Destination != nil:
 	case c.model.HTTP != nil:
 	default:
 		return nil
@@ -67,14 +69,15 @@ func (c *SpanContext) reset() {
 
 // SetTag sets a tag in the context. Invalid characters
 // ('.', '*', and '"') in the key will be replaced with
-// an underscore.
+// an underscore. Values are truncated to 10000 characters,
+// and further limited by the tracer's LabelLimits when sent.
 func (c *SpanContext) SetTag(key, value string) {
 	// Note that we do not attempt to de-duplicate the keys.
 	// This is OK, since json.Unmarshal will always take the
 	// final instance.
 	c.model.Tags = append(c.model.Tags, model.StringMapItem{
 		Key:   cleanTagKey(key),
-		Value: truncateString(value),
+		Value: truncateLongString(value),
 	})
 }
 
@@ -89,6 +92,19 @@ func (c *SpanContext) SetDatabase(db DatabaseSpanContext) {
 	c.model.Database = &c.database
 }
 
+// SetDestinationAddress sets the destination address and port in the
+// context. If addr is empty, or port is non-positive, they will be
+// omitted from the context.
+func (c *SpanContext) SetDestinationAddress(addr string, port int) {
+	if addr != "" {
+		c.destination.Address = truncateString(addr)
+	}
+	if port > 0 {
+		c.destination.Port = port
+	}
+	c.model.Destination = &c.destination
+}
+
 // SetHTTPRequest sets the details of the HTTP request in the context.
 //
 // This function relates to client requests. If the request URL contains
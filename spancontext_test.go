@@ -43,3 +43,16 @@ func TestSpanContextSetTag(t *testing.T) {
 		{Key: "foo", Value: "bar!"},
 	}, spans[0].Context.Tags)
 }
+
+func TestSpanContextSetDestinationAddress(t *testing.T) {
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		span, _ := apm.StartSpan(ctx, "name", "type")
+		span.Context.SetDestinationAddress("db.testing", 5432)
+		span.End()
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, &model.DestinationSpanContext{
+		Address: "db.testing",
+		Port:    5432,
+	}, spans[0].Context.Destination)
+}
@@ -43,6 +43,10 @@ type TraceContext struct {
 
 	// Options holds the trace options propagated by the parent.
 	Options TraceOptions
+
+	// State holds vendor-specific trace state propagated by the
+	// parent, which will be propagated to children.
+	State TraceState
 }
 
 // TraceID identifies a trace forest.
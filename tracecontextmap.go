@@ -0,0 +1,112 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/internal/tracecontext"
+)
+
+const (
+	// TraceparentMapKey is the key under which TraceContextToMap records
+	// the trace context in the W3C Trace-Context traceparent format.
+	TraceparentMapKey = "traceparent"
+
+	// TracestateMapKey is the key under which TraceContextToMap records
+	// the trace state in the W3C Trace-Context tracestate format.
+	TracestateMapKey = "tracestate"
+)
+
+// TraceContextToMap returns a map holding c in the W3C Trace-Context
+// traceparent and tracestate formats, keyed by TraceparentMapKey and
+// TracestateMapKey. The tracestate entry is omitted if c has no state.
+//
+// TraceContextToMap is intended for persisting trace context alongside
+// data which will be processed outside of the current request, possibly
+// by another process, such as in the payload of a background job. The
+// map may be stored as, for example, a JSON column, and the trace context
+// later restored with TraceContextFromMap:
+//
+//	job.TraceContext = apm.TraceContextToMap(tx.TraceContext())
+func TraceContextToMap(c TraceContext) map[string]string {
+	m := map[string]string{
+		TraceparentMapKey: tracecontext.Traceparent{
+			TraceID: c.Trace,
+			SpanID:  c.Span,
+			Flags:   byte(c.Options),
+		}.String(),
+	}
+	if s := c.State.String(); s != "" {
+		m[TracestateMapKey] = s
+	}
+	return m
+}
+
+// TraceContextFromMap returns the trace context held in m, as recorded by
+// TraceContextToMap, or by another agent in the W3C Trace-Context formats.
+//
+// An error is returned if m does not hold a valid traceparent. An invalid
+// tracestate is ignored, and the returned TraceContext will have no state.
+//
+// The returned trace context may be used to continue the trace, by passing
+// it in TransactionOptions.TraceContext, or to link a new trace to it, by
+// passing a SpanLink in TransactionOptions.Links.
+func TraceContextFromMap(m map[string]string) (TraceContext, error) {
+	traceparent, ok := m[TraceparentMapKey]
+	if !ok {
+		return TraceContext{}, errors.Errorf("missing %s", TraceparentMapKey)
+	}
+	c, err := parseTraceparent(traceparent)
+	if err != nil {
+		return TraceContext{}, err
+	}
+	if tracestate, ok := m[TracestateMapKey]; ok {
+		if state, err := parseTracestate(tracestate); err == nil {
+			c.State = state
+		}
+	}
+	return c, nil
+}
+
+// parseTraceparent parses s in the W3C Trace-Context traceparent format.
+func parseTraceparent(s string) (TraceContext, error) {
+	p, err := tracecontext.ParseTraceparent(s)
+	if err != nil {
+		return TraceContext{}, err
+	}
+	return TraceContext{Trace: p.TraceID, Span: p.SpanID, Options: TraceOptions(p.Flags)}, nil
+}
+
+// parseTracestate parses the given values in the W3C Trace-Context
+// tracestate format.
+func parseTracestate(values ...string) (TraceState, error) {
+	parsed, err := tracecontext.ParseTracestate(values...)
+	if err != nil {
+		return TraceState{}, err
+	}
+	entries := make([]TraceStateEntry, len(parsed))
+	for i, e := range parsed {
+		entries[i] = TraceStateEntry(e)
+	}
+	state := NewTraceState(entries...)
+	if err := state.Validate(); err != nil {
+		return TraceState{}, err
+	}
+	return state, nil
+}
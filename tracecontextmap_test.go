@@ -0,0 +1,91 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+)
+
+func TestTraceContextMap(t *testing.T) {
+	state := apm.NewTraceState(apm.TraceStateEntry{Key: "vendor", Value: "value"})
+	c := apm.TraceContext{
+		Trace:   apm.TraceID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		Span:    apm.SpanID{0, 1, 2, 3, 4, 5, 6, 7},
+		Options: apm.TraceOptions(0).WithRecorded(true),
+		State:   state,
+	}
+	m := apm.TraceContextToMap(c)
+	assert.Equal(t, map[string]string{
+		"traceparent": "00-000102030405060708090a0b0c0d0e0f-0001020304050607-01",
+		"tracestate":  "vendor=value",
+	}, m)
+
+	out, err := apm.TraceContextFromMap(m)
+	require.NoError(t, err)
+	assert.Equal(t, c, out)
+
+	c.State = apm.TraceState{}
+	m = apm.TraceContextToMap(c)
+	assert.NotContains(t, m, apm.TracestateMapKey)
+	out, err = apm.TraceContextFromMap(m)
+	require.NoError(t, err)
+	assert.Equal(t, c, out)
+}
+
+func TestTraceContextFromMapFutureVersion(t *testing.T) {
+	out, err := apm.TraceContextFromMap(map[string]string{
+		"traceparent": "01-000102030405060708090a0b0c0d0e0f-0001020304050607-00-future",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, apm.TraceContext{
+		Trace: apm.TraceID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		Span:  apm.SpanID{0, 1, 2, 3, 4, 5, 6, 7},
+	}, out)
+}
+
+func TestTraceContextFromMapInvalidTracestate(t *testing.T) {
+	out, err := apm.TraceContextFromMap(map[string]string{
+		"traceparent": "00-000102030405060708090a0b0c0d0e0f-0001020304050607-01",
+		"tracestate":  "invalid",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, apm.TraceState{}, out.State)
+}
+
+func TestTraceContextFromMapInvalid(t *testing.T) {
+	for _, traceparent := range []string{
+		"",
+		"00-000102030405060708090a0b0c0d0e0f-0001020304050607",
+		"00-000102030405060708090a0b0c0d0e0f-0001020304050607-01-extra",
+		"ff-000102030405060708090a0b0c0d0e0f-0001020304050607-01",
+		"00-00000000000000000000000000000000-0001020304050607-01",
+		"00-000102030405060708090a0b0c0d0e0f-0000000000000000-01",
+		"00-zz0102030405060708090a0b0c0d0e0f-0001020304050607-01",
+		"00_000102030405060708090a0b0c0d0e0f-0001020304050607-01",
+	} {
+		_, err := apm.TraceContextFromMap(map[string]string{"traceparent": traceparent})
+		assert.Error(t, err, traceparent)
+	}
+	_, err := apm.TraceContextFromMap(nil)
+	assert.EqualError(t, err, "missing traceparent")
+}
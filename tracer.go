@@ -28,6 +28,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"go.elastic.co/apm/internal/apmconfig"
 	"go.elastic.co/apm/internal/apmlog"
 	"go.elastic.co/apm/internal/iochan"
@@ -63,23 +65,53 @@ func init() {
 	DefaultTracer = newTracer(opts)
 }
 
+// ResetDefaultTracer closes DefaultTracer and replaces it with a new Tracer
+// configured from the current environment variables, returning the new Tracer.
+// transport.Default is re-initialized first, so the new Tracer also picks up
+// any changes to the ELASTIC_APM_SERVER_* environment variables.
+//
+// ResetDefaultTracer is intended for test harnesses and plugins whose
+// environment is populated after package initialization. It must not be
+// called concurrently with any use of DefaultTracer.
+func ResetDefaultTracer() *Tracer {
+	transport.InitDefault()
+	var opts options
+	opts.init(true)
+	old := DefaultTracer
+	DefaultTracer = newTracer(opts)
+	old.Close()
+	return DefaultTracer
+}
+
 type options struct {
-	requestDuration       time.Duration
-	metricsInterval       time.Duration
-	maxSpans              int
-	requestSize           int
-	bufferSize            int
-	metricsBufferSize     int
-	sampler               Sampler
-	sanitizedFieldNames   wildcard.Matchers
-	disabledMetrics       wildcard.Matchers
-	captureHeaders        bool
-	captureBody           CaptureBodyMode
-	spanFramesMinDuration time.Duration
-	serviceName           string
-	serviceVersion        string
-	serviceEnvironment    string
-	active                bool
+	requestDuration             time.Duration
+	metricsInterval             time.Duration
+	maxSpans                    int
+	maxSpansPerSecond           int
+	labelLimits                 LabelLimits
+	requestSize                 int
+	bufferSize                  int
+	metricsBufferSize           int
+	sampler                     Sampler
+	sanitizedFieldNames         wildcard.Matchers
+	disabledMetrics             wildcard.Matchers
+	captureHeaders              bool
+	captureBody                 CaptureBodyMode
+	captureBodyMaxSize          int
+	captureBodyContentTypes     wildcard.Matchers
+	spanFramesMinDuration       time.Duration
+	spanFramesMinDurationByType map[string]time.Duration
+	exitSpanMinDuration         time.Duration
+	spanCompression             spanCompressionConfig
+	serviceName                 string
+	serviceVersion              string
+	serviceEnvironment          string
+	globalLabels                []model.StringMapItem
+	baggageToAttach             wildcard.Matchers
+	transactionNameRewriteRules []TransactionNameRewriteRule
+	active                      bool
+	centralConfig               bool
+	clockSkewCorrection         bool
 }
 
 func (opts *options) init(continueOnError bool) error {
@@ -126,6 +158,16 @@ func (opts *options) init(continueOnError bool) error {
 		maxSpans = defaultMaxSpans
 	}
 
+	maxSpansPerSecond, err := initialMaxSpansPerSecond()
+	if failed(err) {
+		maxSpansPerSecond = 0
+	}
+
+	labelLimits, err := initialLabelLimits()
+	if failed(err) {
+		labelLimits = defaultLabelLimits
+	}
+
 	sampler, err := initialSampler()
 	if failed(err) {
 		sampler = nil
@@ -141,16 +183,59 @@ func (opts *options) init(continueOnError bool) error {
 		captureBody = CaptureBodyOff
 	}
 
+	captureBodyMaxSize, err := initialCaptureBodyMaxSize()
+	if failed(err) {
+		captureBodyMaxSize = int(defaultCaptureBodyMaxSize)
+	}
+
 	spanFramesMinDuration, err := initialSpanFramesMinDuration()
 	if failed(err) {
 		spanFramesMinDuration = defaultSpanFramesMinDuration
 	}
 
+	spanFramesMinDurationByType, err := initialSpanFramesMinDurationByType()
+	if failed(err) {
+		spanFramesMinDurationByType = nil
+	}
+
+	exitSpanMinDuration, err := initialExitSpanMinDuration()
+	if failed(err) {
+		exitSpanMinDuration = defaultExitSpanMinDuration
+	}
+
+	spanCompression, err := initialSpanCompression()
+	if failed(err) {
+		spanCompression = spanCompressionConfig{
+			exactMatchMaxDuration: defaultSpanCompressionExactMatchMaxDuration,
+			sameKindMaxDuration:   defaultSpanCompressionSameKindMaxDuration,
+		}
+	}
+
+	globalLabels, err := initialGlobalLabels()
+	if failed(err) {
+		globalLabels = nil
+	}
+
+	transactionNameRewriteRules, err := initialTransactionNameRewriteRules()
+	if failed(err) {
+		transactionNameRewriteRules = nil
+	}
+
 	active, err := initialActive()
 	if failed(err) {
 		active = true
 	}
 
+	centralConfig, err := initialCentralConfig()
+	if failed(err) {
+		centralConfig = true
+	}
+
+	clockSkewCorrection, err := initialClockSkewCorrection()
+	if failed(err) {
+		clockSkewCorrection = false
+	}
+
 	if len(errs) != 0 && !continueOnError {
 		return errs[0]
 	}
@@ -164,14 +249,26 @@ func (opts *options) init(continueOnError bool) error {
 	opts.bufferSize = bufferSize
 	opts.metricsBufferSize = metricsBufferSize
 	opts.maxSpans = maxSpans
+	opts.maxSpansPerSecond = maxSpansPerSecond
+	opts.labelLimits = labelLimits
 	opts.sampler = sampler
 	opts.sanitizedFieldNames = initialSanitizedFieldNames()
 	opts.disabledMetrics = initialDisabledMetrics()
 	opts.captureHeaders = captureHeaders
 	opts.captureBody = captureBody
+	opts.captureBodyMaxSize = captureBodyMaxSize
+	opts.captureBodyContentTypes = initialCaptureBodyContentTypes()
 	opts.spanFramesMinDuration = spanFramesMinDuration
+	opts.spanFramesMinDurationByType = spanFramesMinDurationByType
+	opts.exitSpanMinDuration = exitSpanMinDuration
+	opts.spanCompression = spanCompression
 	opts.serviceName, opts.serviceVersion, opts.serviceEnvironment = initialService()
+	opts.globalLabels = globalLabels
+	opts.baggageToAttach = initialBaggageToAttach()
+	opts.transactionNameRewriteRules = transactionNameRewriteRules
 	opts.active = active
+	opts.centralConfig = centralConfig
+	opts.clockSkewCorrection = clockSkewCorrection
 	return nil
 }
 
@@ -210,7 +307,7 @@ type Tracer struct {
 	metricsBufferSize int
 	closing           chan struct{}
 	closed            chan struct{}
-	forceFlush        chan chan<- struct{}
+	forceFlush        chan chan<- error
 	forceSendMetrics  chan chan<- struct{}
 	configCommands    chan tracerConfigCommand
 	events            chan tracerEvent
@@ -218,20 +315,58 @@ type Tracer struct {
 	statsMu sync.Mutex
 	stats   TracerStats
 
-	maxSpansMu sync.RWMutex
-	maxSpans   int
+	maxSpansMu        sync.RWMutex
+	maxSpans          int
+	maxSpansPerSecond int
 
-	spanFramesMinDurationMu sync.RWMutex
-	spanFramesMinDuration   time.Duration
+	labelLimitsMu sync.RWMutex
+	labelLimits   LabelLimits
 
-	samplerMu sync.RWMutex
-	sampler   Sampler
+	spanFramesMinDurationMu     sync.RWMutex
+	spanFramesMinDuration       time.Duration
+	spanFramesMinDurationByType map[string]time.Duration
+
+	exitSpanMinDurationMu sync.RWMutex
+	exitSpanMinDuration   time.Duration
+
+	spanCompressionMu sync.RWMutex
+	spanCompression   spanCompressionConfig
+
+	samplerMu              sync.RWMutex
+	sampler                Sampler
+	sampleDecisionCallback SampleDecisionCallback
+	samplingReasonTag      bool
+
+	idGeneratorMu sync.RWMutex
+	idGenerator   IDGenerator
+
+	droppedEventHandlerMu sync.RWMutex
+	droppedEventHandler   DroppedEventHandler
+	droppedEvents         droppedEvents
 
 	captureHeadersMu sync.RWMutex
 	captureHeaders   bool
 
-	captureBodyMu sync.RWMutex
-	captureBody   CaptureBodyMode
+	captureBodyMu           sync.RWMutex
+	captureBody             CaptureBodyMode
+	captureBodyMaxSize      int
+	captureBodyContentTypes wildcard.Matchers
+
+	globalLabelsMu sync.RWMutex
+	globalLabels   []model.StringMapItem
+
+	baggageToAttachMu sync.RWMutex
+	baggageToAttach   wildcard.Matchers
+
+	clockSkewMu         sync.RWMutex
+	clockSkew           time.Duration
+	clockSkewEstimated  bool
+	clockSkewCorrection bool
+
+	meter *Meter
+
+	centralConfig bool
+	remoteConfig  remoteConfig
 
 	errorDataPool       sync.Pool
 	spanDataPool        sync.Pool
@@ -262,23 +397,35 @@ func NewTracer(serviceName, serviceVersion string) (*Tracer, error) {
 
 func newTracer(opts options) *Tracer {
 	t := &Tracer{
-		Transport:             transport.Default,
-		process:               &currentProcess,
-		system:                &localSystem,
-		closing:               make(chan struct{}),
-		closed:                make(chan struct{}),
-		forceFlush:            make(chan chan<- struct{}),
-		forceSendMetrics:      make(chan chan<- struct{}),
-		configCommands:        make(chan tracerConfigCommand),
-		events:                make(chan tracerEvent, tracerEventChannelCap),
-		active:                1,
-		maxSpans:              opts.maxSpans,
-		sampler:               opts.sampler,
-		captureHeaders:        opts.captureHeaders,
-		captureBody:           opts.captureBody,
-		spanFramesMinDuration: opts.spanFramesMinDuration,
-		bufferSize:            opts.bufferSize,
-		metricsBufferSize:     opts.metricsBufferSize,
+		Transport:                   transport.Default,
+		process:                     &currentProcess,
+		system:                      &localSystem,
+		closing:                     make(chan struct{}),
+		closed:                      make(chan struct{}),
+		forceFlush:                  make(chan chan<- error),
+		forceSendMetrics:            make(chan chan<- struct{}),
+		configCommands:              make(chan tracerConfigCommand),
+		events:                      make(chan tracerEvent, tracerEventChannelCap),
+		active:                      1,
+		maxSpans:                    opts.maxSpans,
+		maxSpansPerSecond:           opts.maxSpansPerSecond,
+		labelLimits:                 opts.labelLimits,
+		sampler:                     opts.sampler,
+		captureHeaders:              opts.captureHeaders,
+		captureBody:                 opts.captureBody,
+		captureBodyMaxSize:          opts.captureBodyMaxSize,
+		captureBodyContentTypes:     opts.captureBodyContentTypes,
+		spanFramesMinDuration:       opts.spanFramesMinDuration,
+		spanFramesMinDurationByType: opts.spanFramesMinDurationByType,
+		exitSpanMinDuration:         opts.exitSpanMinDuration,
+		spanCompression:             opts.spanCompression,
+		globalLabels:                opts.globalLabels,
+		baggageToAttach:             opts.baggageToAttach,
+		bufferSize:                  opts.bufferSize,
+		metricsBufferSize:           opts.metricsBufferSize,
+		meter:                       &Meter{},
+		centralConfig:               opts.centralConfig,
+		clockSkewCorrection:         opts.clockSkewCorrection,
 	}
 	t.Service.Name = opts.serviceName
 	t.Service.Version = opts.serviceVersion
@@ -295,11 +442,14 @@ func newTracer(opts options) *Tracer {
 		cfg.metricsInterval = opts.metricsInterval
 		cfg.requestDuration = opts.requestDuration
 		cfg.requestSize = opts.requestSize
+		cfg.bufferSize = opts.bufferSize
 		cfg.sanitizedFieldNames = opts.sanitizedFieldNames
+		cfg.labelLimits = opts.labelLimits
 		cfg.disabledMetrics = opts.disabledMetrics
+		cfg.transactionNameRewriteRules = opts.transactionNameRewriteRules
 		cfg.preContext = defaultPreContext
 		cfg.postContext = defaultPostContext
-		cfg.metricsGatherers = []MetricsGatherer{newBuiltinMetricsGatherer(t)}
+		cfg.metricsGatherers = []MetricsGatherer{newBuiltinMetricsGatherer(t), t.meter}
 		if apmlog.DefaultLogger != nil {
 			cfg.logger = apmlog.DefaultLogger
 		}
@@ -310,15 +460,18 @@ func newTracer(opts options) *Tracer {
 // tracerConfig holds the tracer's runtime configuration, which may be modified
 // by sending a tracerConfigCommand to the tracer's configCommands channel.
 type tracerConfig struct {
-	requestSize             int
-	requestDuration         time.Duration
-	metricsInterval         time.Duration
-	logger                  Logger
-	metricsGatherers        []MetricsGatherer
-	contextSetter           stacktrace.ContextSetter
-	preContext, postContext int
-	sanitizedFieldNames     wildcard.Matchers
-	disabledMetrics         wildcard.Matchers
+	requestSize                 int
+	bufferSize                  int
+	requestDuration             time.Duration
+	metricsInterval             time.Duration
+	logger                      Logger
+	metricsGatherers            []MetricsGatherer
+	contextSetter               stacktrace.ContextSetter
+	preContext, postContext     int
+	sanitizedFieldNames         wildcard.Matchers
+	disabledMetrics             wildcard.Matchers
+	transactionNameRewriteRules []TransactionNameRewriteRule
+	labelLimits                 LabelLimits
 }
 
 type tracerConfigCommand func(*tracerConfig)
@@ -338,15 +491,49 @@ func (t *Tracer) Close() {
 // has queued to the APM server, the tracer is stopped, or the abort channel
 // is signaled.
 func (t *Tracer) Flush(abort <-chan struct{}) {
-	flushed := make(chan struct{}, 1)
+	t.flush(abort)
+}
+
+// FlushContext waits for the Tracer to flush any transactions and errors it
+// currently has queued to the APM server, the tracer is stopped, or ctx is
+// canceled. This is intended for use by programs which must ensure that their
+// events have been sent before exiting, e.g. serverless functions and batch
+// jobs.
+//
+// FlushContext returns nil if the queued events were sent successfully, and
+// otherwise returns an error describing why they were not: ctx.Err() if ctx
+// was canceled, the error returned by the transport if the request to the
+// APM server failed, or an error indicating that the tracer was closed.
+func (t *Tracer) FlushContext(ctx context.Context) error {
+	err := t.flush(ctx.Done())
+	if err == errFlushAborted {
+		return ctx.Err()
+	}
+	return err
+}
+
+var (
+	errFlushAborted = errors.New("flush aborted")
+	errTracerClosed = errors.New("tracer closed")
+)
+
+func (t *Tracer) flush(abort <-chan struct{}) error {
+	flushed := make(chan error, 1)
 	select {
 	case t.forceFlush <- flushed:
 		select {
 		case <-abort:
-		case <-flushed:
+			return errFlushAborted
+		case err := <-flushed:
+			if err != nil {
+				return errors.Wrap(err, "failed to send events")
+			}
+			return nil
 		case <-t.closed:
+			return errTracerClosed
 		}
 	case <-t.closed:
+		return errTracerClosed
 	}
 }
 
@@ -365,6 +552,42 @@ func (t *Tracer) SetRequestDuration(d time.Duration) {
 	})
 }
 
+// SetRequestSize sets the maximum size in bytes of a request to the APM
+// server for streaming data, before the request is closed and a new one
+// is started. The size must be within the range accepted for the
+// ELASTIC_APM_API_REQUEST_SIZE environment variable.
+func (t *Tracer) SetRequestSize(size int) error {
+	if size < int(minAPIRequestSize) || size > int(maxAPIRequestSize) {
+		return errors.Errorf(
+			"request size must be at least %s and less than %s, got %d",
+			minAPIRequestSize, maxAPIRequestSize, size,
+		)
+	}
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.requestSize = size
+	})
+	return nil
+}
+
+// SetBufferSize sets the size in bytes of the buffer holding events
+// waiting to be sent to the APM server. If the buffer is full, the oldest
+// events are dropped to make room for new ones. If the new size is smaller
+// than the size of the events currently buffered, the oldest events will
+// be dropped. The size must be within the range accepted for the
+// ELASTIC_APM_API_BUFFER_SIZE environment variable.
+func (t *Tracer) SetBufferSize(size int) error {
+	if size < int(minAPIBufferSize) || size > int(maxAPIBufferSize) {
+		return errors.Errorf(
+			"buffer size must be at least %s and less than %s, got %d",
+			minAPIBufferSize, maxAPIBufferSize, size,
+		)
+	}
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.bufferSize = size
+	})
+	return nil
+}
+
 // SetMetricsInterval sets the metrics interval -- the amount of time in
 // between metrics samples being gathered.
 func (t *Tracer) SetMetricsInterval(d time.Duration) {
@@ -413,6 +636,31 @@ func (t *Tracer) SetSanitizedFieldNames(patterns ...string) error {
 	return nil
 }
 
+// SetTransactionNameRewriteRules sets the rules for rewriting transaction
+// names before they are sent to the APM server. The rules are applied in
+// order, each to the result of the previous rule. If
+// SetTransactionNameRewriteRules is called with no arguments, then
+// transaction names will not be rewritten. An error is returned, and
+// the rules left unchanged, if any rule has a nil Pattern.
+func (t *Tracer) SetTransactionNameRewriteRules(rules ...TransactionNameRewriteRule) error {
+	for i, rule := range rules {
+		if rule.Pattern == nil {
+			return errors.Errorf("transaction name rewrite rule %d has a nil Pattern", i)
+		}
+	}
+	rules = append([]TransactionNameRewriteRule(nil), rules...)
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.transactionNameRewriteRules = rules
+	})
+	return nil
+}
+
+// Meter returns the tracer's Meter, for recording application metrics
+// which are reported along with the tracer's built-in metrics.
+func (t *Tracer) Meter() *Meter {
+	return t.meter
+}
+
 // RegisterMetricsGatherer registers g for periodic (or forced) metrics
 // gathering by t.
 //
@@ -456,6 +704,63 @@ func (t *Tracer) SetSampler(s Sampler) {
 	t.samplerMu.Unlock()
 }
 
+// SetSampleDecisionCallback sets a function to be called with the
+// sampling decision for each transaction started by the tracer. The
+// callback is invoked once per transaction, synchronously, so it must
+// be goroutine-safe and should return quickly. It is invoked by
+// StartTransactionOptions, unless the decision may be re-evaluated
+// when the transaction's name changes (see ExtendedSampler), in which
+// case it is invoked once the decision is final. It is valid to pass
+// nil, in which case no callback will be invoked.
+func (t *Tracer) SetSampleDecisionCallback(f SampleDecisionCallback) {
+	t.samplerMu.Lock()
+	t.sampleDecisionCallback = f
+	t.samplerMu.Unlock()
+}
+
+// SetIDGenerator sets the IDGenerator used for generating trace and
+// span IDs for transactions and spans started by the tracer, e.g. in
+// order to produce deterministic IDs in tests, or to embed a prefix in
+// IDs. Transactions started before SetIDGenerator is called, and their
+// spans, will continue to use the previous generator. It is valid to
+// pass nil, in which case IDs will be randomly generated.
+//
+// The transaction ID of a root transaction is taken from the trace ID
+// by default; if an IDGenerator is set, it will be generated with
+// NewSpanID instead.
+func (t *Tracer) SetIDGenerator(g IDGenerator) {
+	t.idGeneratorMu.Lock()
+	t.idGenerator = g
+	t.idGeneratorMu.Unlock()
+}
+
+// SetDroppedEventHandler sets a function to be called when the tracer
+// drops transactions, spans, or errors, either because the event queue
+// is full or because events were evicted from the tracer's buffer before
+// they could be sent. This may be used to raise alerts or log when events
+// are lost, rather than polling Tracer.Stats.
+//
+// The handler is invoked by a separate goroutine, so it never blocks the
+// application or the tracer. Events dropped while the handler is running
+// are counted, and reported in a single call per kind once it returns, so
+// a slow handler delays but does not lose notifications. It is valid to
+// pass nil, in which case no handler will be invoked.
+func (t *Tracer) SetDroppedEventHandler(h DroppedEventHandler) {
+	t.droppedEventHandlerMu.Lock()
+	t.droppedEventHandler = h
+	t.droppedEventHandlerMu.Unlock()
+}
+
+// SetSamplingReasonTag sets whether or not transactions should be
+// tagged with the reason for their sampling decision, for debugging.
+// When enabled, each transaction will have a "sampling_reason" tag
+// holding one of the SampleReason* values.
+func (t *Tracer) SetSamplingReasonTag(enabled bool) {
+	t.samplerMu.Lock()
+	t.samplingReasonTag = enabled
+	t.samplerMu.Unlock()
+}
+
 // SetMaxSpans sets the maximum number of spans that will be added
 // to a transaction before dropping spans. If set to a non-positive
 // value, the number of spans is unlimited.
@@ -465,6 +770,17 @@ func (t *Tracer) SetMaxSpans(n int) {
 	t.maxSpansMu.Unlock()
 }
 
+// SetMaxSpansPerSecond sets the maximum number of spans that will be
+// added to a transaction in any one second before dropping spans, in
+// addition to the limit set by SetMaxSpans. This prevents a tight loop
+// of instrumented calls from filling the tracer's buffers. If set to a
+// non-positive value, the span rate is unlimited.
+func (t *Tracer) SetMaxSpansPerSecond(n int) {
+	t.maxSpansMu.Lock()
+	t.maxSpansPerSecond = n
+	t.maxSpansMu.Unlock()
+}
+
 // SetSpanFramesMinDuration sets the minimum duration for a span after which
 // we will capture its stack frames.
 func (t *Tracer) SetSpanFramesMinDuration(d time.Duration) {
@@ -473,6 +789,70 @@ func (t *Tracer) SetSpanFramesMinDuration(d time.Duration) {
 	t.spanFramesMinDurationMu.Unlock()
 }
 
+// SetSpanFramesMinDurationByType sets the minimum durations for spans of
+// specific types after which we will capture their stack frames, overriding
+// the duration set by SetSpanFramesMinDuration. The keys of m are matched
+// against the span type, up to the first ".", i.e. the key "db" applies
+// to spans with the type "db.mysql.query". Any previously set overrides
+// are replaced.
+func (t *Tracer) SetSpanFramesMinDurationByType(m map[string]time.Duration) {
+	// Copy m, so it may be shared by transactions without locking.
+	var durations map[string]time.Duration
+	if len(m) > 0 {
+		durations = make(map[string]time.Duration, len(m))
+		for k, v := range m {
+			durations[k] = v
+		}
+	}
+	t.spanFramesMinDurationMu.Lock()
+	t.spanFramesMinDurationByType = durations
+	t.spanFramesMinDurationMu.Unlock()
+}
+
+// SetExitSpanMinDuration sets the minimum duration for an exit span to be
+// reported. Exit spans ending in less time will be dropped, and counted in
+// the transaction's dropped span count. If set to a non-positive value, no
+// exit spans will be dropped due to their duration.
+func (t *Tracer) SetExitSpanMinDuration(d time.Duration) {
+	t.exitSpanMinDurationMu.Lock()
+	t.exitSpanMinDuration = d
+	t.exitSpanMinDurationMu.Unlock()
+}
+
+// SetSpanCompressionEnabled enables or disables span compression. When
+// enabled, consecutive exit spans with the same parent, type, subtype and
+// destination are compressed into a single composite span, recording the
+// number of spans and the sum of their durations. Exit spans are only
+// compressed if their trace context has not been propagated, i.e. if
+// Span.TraceContext has not been called and no child spans or errors
+// have been associated with them.
+//
+// Span compression is disabled by default.
+func (t *Tracer) SetSpanCompressionEnabled(enabled bool) {
+	t.spanCompressionMu.Lock()
+	t.spanCompression.enabled = enabled
+	t.spanCompressionMu.Unlock()
+}
+
+// SetSpanCompressionExactMatchMaxDuration sets the maximum duration of
+// spans with the same name to be compressed with each other, when span
+// compression is enabled.
+func (t *Tracer) SetSpanCompressionExactMatchMaxDuration(d time.Duration) {
+	t.spanCompressionMu.Lock()
+	t.spanCompression.exactMatchMaxDuration = d
+	t.spanCompressionMu.Unlock()
+}
+
+// SetSpanCompressionSameKindMaxDuration sets the maximum duration of spans
+// with different names to be compressed with each other, when span
+// compression is enabled. Spans compressed in this way are renamed to
+// "Calls to <destination>".
+func (t *Tracer) SetSpanCompressionSameKindMaxDuration(d time.Duration) {
+	t.spanCompressionMu.Lock()
+	t.spanCompression.sameKindMaxDuration = d
+	t.spanCompressionMu.Unlock()
+}
+
 // SetCaptureHeaders enables or disables capturing of HTTP headers.
 func (t *Tracer) SetCaptureHeaders(capture bool) {
 	t.captureHeadersMu.Lock()
@@ -480,6 +860,48 @@ func (t *Tracer) SetCaptureHeaders(capture bool) {
 	t.captureHeadersMu.Unlock()
 }
 
+// SetGlobalLabel sets a label which will be recorded as a tag on all
+// subsequently started transactions, and subsequently created errors.
+// This may be used for recording deployment-specific information, such
+// as the build revision or canary group, which may change at runtime.
+//
+// If value is empty, the global label with the given key is removed.
+// Global labels may also be defined in a file, named by the environment
+// variable ELASTIC_APM_GLOBAL_LABELS_FILE. Tags set on a transaction or
+// error override global labels with the same key.
+func (t *Tracer) SetGlobalLabel(key, value string) {
+	t.globalLabelsMu.Lock()
+	t.globalLabels = setGlobalLabel(t.globalLabels, key, value)
+	t.globalLabelsMu.Unlock()
+}
+
+// setGlobalLabel returns a copy of labels with the label key set
+// to value, or removed if value is empty. Labels are copied rather
+// than updated in place, so that readers may use them without holding
+// the lock once obtained.
+func setGlobalLabel(labels []model.StringMapItem, key, value string) []model.StringMapItem {
+	out := make([]model.StringMapItem, 0, len(labels)+1)
+	for _, label := range labels {
+		if label.Key != key {
+			out = append(out, label)
+		}
+	}
+	if value != "" {
+		out = append(out, model.StringMapItem{Key: key, Value: value})
+	}
+	return out
+}
+
+// setGlobalLabels records the tracer's global labels as tags in c.
+func (t *Tracer) setGlobalLabels(c *Context) {
+	t.globalLabelsMu.RLock()
+	labels := t.globalLabels
+	t.globalLabelsMu.RUnlock()
+	for _, label := range labels {
+		c.SetTag(label.Key, label.Value)
+	}
+}
+
 // SetCaptureBody sets the HTTP request body capture mode.
 func (t *Tracer) SetCaptureBody(mode CaptureBodyMode) {
 	t.captureBodyMu.Lock()
@@ -487,6 +909,37 @@ func (t *Tracer) SetCaptureBody(mode CaptureBodyMode) {
 	t.captureBodyMu.Unlock()
 }
 
+// SetCaptureBodyMaxSize sets the maximum number of bytes of each HTTP
+// request body to capture. Longer bodies are truncated. If size is not
+// positive, the default of 1KB is used.
+func (t *Tracer) SetCaptureBodyMaxSize(size int) {
+	if size <= 0 {
+		size = int(defaultCaptureBodyMaxSize)
+	}
+	t.captureBodyMu.Lock()
+	t.captureBodyMaxSize = size
+	t.captureBodyMu.Unlock()
+}
+
+// SetCaptureBodyContentTypes sets the wildcard patterns that will be used
+// to match the Content-Type of HTTP requests whose bodies are captured.
+// Bodies of requests whose Content-Type does not match any of the patterns,
+// or which have no Content-Type, are not captured. If
+// SetCaptureBodyContentTypes is called with no arguments, then bodies are
+// captured regardless of their Content-Type.
+func (t *Tracer) SetCaptureBodyContentTypes(patterns ...string) {
+	var matchers wildcard.Matchers
+	if len(patterns) != 0 {
+		matchers = make(wildcard.Matchers, len(patterns))
+		for i, p := range patterns {
+			matchers[i] = apmconfig.ParseWildcardPattern(p)
+		}
+	}
+	t.captureBodyMu.Lock()
+	t.captureBodyContentTypes = matchers
+	t.captureBodyMu.Unlock()
+}
+
 // SendMetrics forces the tracer to gather and send metrics immediately,
 // blocking until the metrics have been sent or the abort channel is
 // signalled.
@@ -503,8 +956,12 @@ func (t *Tracer) SendMetrics(abort <-chan struct{}) {
 	}
 }
 
-// Stats returns the current TracerStats. This will return the most
-// recent values even after the tracer has been closed.
+// Stats returns a snapshot of the current TracerStats. This will return
+// the most recent values even after the tracer has been closed.
+//
+// Stats is safe for concurrent use, and the counters in the returned
+// snapshot are mutually consistent, so it may be called periodically
+// to export the statistics, for example to Prometheus.
 func (t *Tracer) Stats() TracerStats {
 	t.statsMu.Lock()
 	stats := t.stats
@@ -522,7 +979,7 @@ func (t *Tracer) loop() {
 	var requestBuf bytes.Buffer
 	var metadata []byte
 	var gracePeriod time.Duration = -1
-	var flushed chan<- struct{}
+	var flushed chan<- error
 	var requestBufTransactions, requestBufSpans, requestBufErrors, requestBufMetricsets uint64
 	zlibWriter, _ := zlib.NewWriterLevel(&requestBuf, zlib.BestSpeed)
 	zlibFlushed := true
@@ -545,14 +1002,26 @@ func (t *Tracer) loop() {
 	defer close(sendStreamRequest)
 	go func() {
 		jitterRand := rand.New(rand.NewSource(time.Now().UnixNano()))
+		watchingConfig := !t.centralConfig
 		for gracePeriod := range sendStreamRequest {
+			if !watchingConfig {
+				// Watch the transport for config changes once the
+				// tracer starts sending events, as the Transport
+				// field may be replaced until then.
+				if w, ok := t.Transport.(transport.ConfigWatcher); ok {
+					t.setDefaultConfigWatcher(w)
+				}
+				watchingConfig = true
+			}
 			if gracePeriod > 0 {
 				select {
 				case <-time.After(jitterDuration(gracePeriod, jitterRand, gracePeriodJitter)):
 				case <-ctx.Done():
 				}
 			}
-			requestResult <- t.Transport.SendStream(ctx, iochanReader)
+			err := t.Transport.SendStream(ctx, iochanReader)
+			t.updateClockSkew()
+			requestResult <- err
 		}
 	}()
 
@@ -562,6 +1031,9 @@ func (t *Tracer) loop() {
 	var gatheringMetrics bool
 	var metricsTimerStart time.Time
 	metricsBuffer := ringbuffer.New(t.metricsBufferSize)
+	metricsBuffer.Evicted = func(ringbuffer.BlockHeader) {
+		stats.MetricsetsDropped++
+	}
 	gatheredMetrics := make(chan struct{}, 1)
 	metricsTimer := time.NewTimer(0)
 	if !metricsTimer.Stop() {
@@ -597,6 +1069,10 @@ func (t *Tracer) loop() {
 		case cmd := <-t.configCommands:
 			oldMetricsInterval := cfg.metricsInterval
 			cmd(&cfg)
+			if cfg.bufferSize != buffer.Cap() {
+				buffer = resizeBuffer(buffer, cfg.bufferSize)
+				modelWriter.buffer = buffer
+			}
 			if !gatheringMetrics && cfg.metricsInterval != oldMetricsInterval {
 				if metricsTimerStart.IsZero() {
 					if cfg.metricsInterval > 0 {
@@ -667,7 +1143,7 @@ func (t *Tracer) loop() {
 				}
 			}
 			if !requestActive && buffer.Len() == 0 && metricsBuffer.Len() == 0 {
-				flushed <- struct{}{}
+				flushed <- nil
 				continue
 			}
 			closeRequest = true
@@ -675,6 +1151,7 @@ func (t *Tracer) loop() {
 		case err := <-requestResult:
 			if err != nil {
 				stats.Errors.SendStream++
+				stats.Errors.Last = time.Now()
 				gracePeriod = nextGracePeriod(gracePeriod)
 				if cfg.logger != nil {
 					logf := cfg.logger.Debugf
@@ -690,6 +1167,7 @@ func (t *Tracer) loop() {
 				stats.TransactionsSent += requestBufTransactions
 				stats.SpansSent += requestBufSpans
 				stats.ErrorsSent += requestBufErrors
+				stats.MetricsetsSent += requestBufMetricsets
 				if cfg.logger != nil {
 					s := func(n uint64) string {
 						if n != 1 {
@@ -707,9 +1185,7 @@ func (t *Tracer) loop() {
 				}
 			}
 			if !stats.isZero() {
-				t.statsMu.Lock()
-				t.stats.accumulate(stats)
-				t.statsMu.Unlock()
+				t.accumulateStats(stats)
 				stats = TracerStats{}
 			}
 			if sentMetrics != nil && requestBufMetricsets > 0 {
@@ -717,7 +1193,7 @@ func (t *Tracer) loop() {
 				sentMetrics = nil
 			}
 			if flushed != nil {
-				flushed <- struct{}{}
+				flushed <- err
 				flushed = nil
 			}
 			if req.Buf != nil {
@@ -744,9 +1220,7 @@ func (t *Tracer) loop() {
 		}
 
 		if !stats.isZero() {
-			t.statsMu.Lock()
-			t.stats.accumulate(stats)
-			t.statsMu.Unlock()
+			t.accumulateStats(stats)
 			stats = TracerStats{}
 		}
 
@@ -763,6 +1237,10 @@ func (t *Tracer) loop() {
 			if buffer.Len() == 0 && metricsBuffer.Len() == 0 {
 				continue
 			}
+			if gracePeriod >= 0 {
+				// The previous request failed.
+				stats.TransportRetries++
+			}
 			sendStreamRequest <- gracePeriod
 			if metadata == nil {
 				metadata = t.jsonRequestMetadata()
@@ -841,6 +1319,26 @@ func (t *Tracer) loop() {
 	}
 }
 
+// resizeBuffer returns a new ringbuffer.Buffer of the given size, holding
+// the blocks in buffer. If the blocks do not all fit in the new buffer,
+// the oldest blocks are evicted.
+func resizeBuffer(buffer *ringbuffer.Buffer, size int) *ringbuffer.Buffer {
+	resized := ringbuffer.New(size)
+	resized.Evicted = buffer.Evicted
+	var block bytes.Buffer
+	for buffer.Len() > 0 {
+		block.Reset()
+		h, _, err := buffer.WriteBlockTo(&block)
+		if err != nil {
+			break
+		}
+		if _, err := resized.WriteBlock(block.Bytes(), h.Tag); err != nil {
+			resized.Evicted(h)
+		}
+	}
+	return resized
+}
+
 // jsonRequestMetadata returns a JSON-encoded metadata object that features
 // at the head of every request body. This is called exactly once, when the
 // first request is made.
@@ -862,7 +1360,7 @@ func (t *Tracer) jsonRequestMetadata() []byte {
 // metrics gatherers. Once all gatherers have returned, a value
 // will be sent on the "gathered" channel.
 func (t *Tracer) gatherMetrics(ctx context.Context, gatherers []MetricsGatherer, m *Metrics, l Logger, gathered chan<- struct{}) {
-	timestamp := model.Time(time.Now().UTC())
+	timestamp := model.Time(time.Now().Add(t.clockSkewOffset()).UTC())
 	var group sync.WaitGroup
 	for _, g := range gatherers {
 		group.Add(1)
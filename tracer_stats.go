@@ -17,6 +17,11 @@
 
 package apm
 
+import (
+	"sync"
+	"time"
+)
+
 // TracerStats holds statistics for a Tracer.
 type TracerStats struct {
 	Errors              TracerStatsErrors
@@ -26,12 +31,30 @@ type TracerStats struct {
 	TransactionsDropped uint64
 	SpansSent           uint64
 	SpansDropped        uint64
+	MetricsetsSent      uint64
+	MetricsetsDropped   uint64
+
+	// TransportRetries holds the number of requests sent to the
+	// APM Server following a failed request.
+	TransportRetries uint64
+
+	// LabelsTruncated holds the number of labels whose key or value
+	// was truncated to the tracer's LabelLimits.
+	LabelsTruncated uint64
+
+	// LabelsDropped holds the number of labels dropped due to the
+	// tracer's LabelLimits.MaxCount.
+	LabelsDropped uint64
 }
 
 // TracerStatsErrors holds error statistics for a Tracer.
 type TracerStatsErrors struct {
 	SetContext uint64
 	SendStream uint64
+
+	// Last holds the time of the most recent SetContext or
+	// SendStream error, or the zero time if there has been none.
+	Last time.Time
 }
 
 func (s TracerStats) isZero() bool {
@@ -49,4 +72,107 @@ func (s *TracerStats) accumulate(rhs TracerStats) {
 	s.SpansDropped += rhs.SpansDropped
 	s.TransactionsSent += rhs.TransactionsSent
 	s.TransactionsDropped += rhs.TransactionsDropped
+	s.MetricsetsSent += rhs.MetricsetsSent
+	s.MetricsetsDropped += rhs.MetricsetsDropped
+	s.TransportRetries += rhs.TransportRetries
+	s.LabelsTruncated += rhs.LabelsTruncated
+	s.LabelsDropped += rhs.LabelsDropped
+	if rhs.Errors.Last.After(s.Errors.Last) {
+		s.Errors.Last = rhs.Errors.Last
+	}
+}
+
+// DroppedEventHandler is the type of a function that may be registered
+// with Tracer.SetDroppedEventHandler, to be notified when the tracer drops
+// events. The kind is one of "transaction", "span", "error", or
+// "metricset", and count holds the number of events of that kind which
+// were dropped.
+type DroppedEventHandler func(kind string, count uint64)
+
+// droppedEventKinds holds the kinds of events passed to DroppedEventHandler,
+// in the order in which pending counts are delivered.
+var droppedEventKinds = [...]string{"transaction", "span", "error", "metricset"}
+
+// droppedEvents holds the counts of dropped events which are
+// pending delivery to the tracer's DroppedEventHandler.
+type droppedEvents struct {
+	mu         sync.Mutex
+	counts     [len(droppedEventKinds)]uint64
+	delivering bool
+}
+
+// eventsDropped records the given kind and count of dropped events for
+// delivery to the tracer's DroppedEventHandler, if any. The handler is
+// called by a separate goroutine, so the caller is never blocked by it;
+// counts recorded while the handler is running are combined, and passed
+// to the handler once it returns.
+func (t *Tracer) eventsDropped(kind string, count uint64) {
+	t.droppedEventHandlerMu.RLock()
+	h := t.droppedEventHandler
+	t.droppedEventHandlerMu.RUnlock()
+	if h == nil {
+		return
+	}
+	d := &t.droppedEvents
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, k := range droppedEventKinds {
+		if k == kind {
+			d.counts[i] += count
+			break
+		}
+	}
+	if !d.delivering {
+		d.delivering = true
+		go t.deliverDroppedEvents()
+	}
+}
+
+// deliverDroppedEvents calls the tracer's DroppedEventHandler with the
+// pending counts of dropped events, until there are none.
+func (t *Tracer) deliverDroppedEvents() {
+	d := &t.droppedEvents
+	for {
+		d.mu.Lock()
+		counts := d.counts
+		d.counts = [len(droppedEventKinds)]uint64{}
+		if counts == d.counts {
+			d.delivering = false
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+
+		t.droppedEventHandlerMu.RLock()
+		h := t.droppedEventHandler
+		t.droppedEventHandlerMu.RUnlock()
+		if h == nil {
+			continue
+		}
+		for i, count := range counts {
+			if count > 0 {
+				h(droppedEventKinds[i], count)
+			}
+		}
+	}
+}
+
+// accumulateStats accumulates stats into the tracer's stats, and
+// notifies the tracer's DroppedEventHandler of any dropped events.
+func (t *Tracer) accumulateStats(stats TracerStats) {
+	t.statsMu.Lock()
+	t.stats.accumulate(stats)
+	t.statsMu.Unlock()
+	if stats.TransactionsDropped > 0 {
+		t.eventsDropped("transaction", stats.TransactionsDropped)
+	}
+	if stats.SpansDropped > 0 {
+		t.eventsDropped("span", stats.SpansDropped)
+	}
+	if stats.ErrorsDropped > 0 {
+		t.eventsDropped("error", stats.ErrorsDropped)
+	}
+	if stats.MetricsetsDropped > 0 {
+		t.eventsDropped("metricset", stats.MetricsetsDropped)
+	}
 }
@@ -0,0 +1,203 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ElasticTracestateVendorKey is the tracestate key reserved for
+	// Elastic APM agents. Entries with this key are propagated as
+	// received, and cannot be modified with TraceState.Set or
+	// TraceState.Delete. The agent sets the entry for the root
+	// transaction of a trace when its Sampler implements
+	// ExtendedSampler, recording the sample rate as "s:<rate>".
+	ElasticTracestateVendorKey = "es"
+
+	maxTracestateEntries    = 32
+	maxTracestateValueBytes = 256
+)
+
+var tracestateKeyRegexp = regexp.MustCompile(`^[a-z](([a-z0-9_*/-]{0,255})|([a-z0-9_*/-]{0,240}@[a-z][a-z0-9_*/-]{0,13}))$`)
+
+// TraceState holds vendor-specific trace state, propagated in the W3C
+// Trace-Context tracestate header.
+//
+// TraceState values are immutable; methods that modify the state return
+// a new TraceState. The zero value is an empty TraceState.
+type TraceState struct {
+	// s holds the entries in their canonical, comma-separated
+	// header form, keeping TraceState (and TraceContext) comparable.
+	s string
+}
+
+// TraceStateEntry holds a tracestate key/value pair.
+type TraceStateEntry struct {
+	// Key holds the vendor key.
+	Key string
+
+	// Value holds the vendor-specific opaque value.
+	Value string
+}
+
+// Validate validates the tracestate entry's key and value.
+func (e TraceStateEntry) Validate() error {
+	if !tracestateKeyRegexp.MatchString(e.Key) {
+		return errors.Errorf("invalid tracestate key %q", e.Key)
+	}
+	if e.Value == "" || len(e.Value) > maxTracestateValueBytes || strings.HasSuffix(e.Value, " ") {
+		return errors.Errorf("invalid tracestate value %q for key %q", e.Value, e.Key)
+	}
+	for _, r := range e.Value {
+		if r < 0x20 || r > 0x7e || r == ',' || r == '=' {
+			return errors.Errorf("invalid tracestate value %q for key %q", e.Value, e.Key)
+		}
+	}
+	return nil
+}
+
+// NewTraceState returns a TraceState holding entries, in order. Entries are
+// not validated; use TraceState.Validate to check them.
+func NewTraceState(entries ...TraceStateEntry) TraceState {
+	fields := make([]string, len(entries))
+	for i, e := range entries {
+		fields[i] = e.Key + "=" + e.Value
+	}
+	return TraceState{s: strings.Join(fields, ",")}
+}
+
+// Entries returns the tracestate entries, in order.
+func (s TraceState) Entries() []TraceStateEntry {
+	if s.s == "" {
+		return nil
+	}
+	fields := strings.Split(s.s, ",")
+	entries := make([]TraceStateEntry, len(fields))
+	for i, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		entries[i].Key = kv[0]
+		if len(kv) == 2 {
+			entries[i].Value = kv[1]
+		}
+	}
+	return entries
+}
+
+// Get returns the value of the entry with the given key,
+// and reports whether the entry exists.
+func (s TraceState) Get(key string) (string, bool) {
+	for _, e := range s.Entries() {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set returns a copy of s with the entry for key set to value. As required
+// by the W3C Trace-Context specification, the entry is moved to the front,
+// and if the state exceeds 32 entries then the last entries are removed.
+//
+// Set returns an error if the entry is invalid, or if key is reserved for
+// the agent; see ElasticTracestateVendorKey.
+func (s TraceState) Set(key, value string) (TraceState, error) {
+	entry := TraceStateEntry{Key: key, Value: value}
+	if err := entry.Validate(); err != nil {
+		return s, err
+	}
+	if key == ElasticTracestateVendorKey {
+		return s, errReservedTracestateKey
+	}
+	entries := append([]TraceStateEntry{entry}, s.without(key)...)
+	if len(entries) > maxTracestateEntries {
+		entries = entries[:maxTracestateEntries]
+	}
+	return NewTraceState(entries...), nil
+}
+
+// Delete returns a copy of s without the entry for key. Delete returns an
+// error if key is reserved for the agent; see ElasticTracestateVendorKey.
+func (s TraceState) Delete(key string) (TraceState, error) {
+	if key == ElasticTracestateVendorKey {
+		return s, errReservedTracestateKey
+	}
+	return NewTraceState(s.without(key)...), nil
+}
+
+func (s TraceState) without(key string) []TraceStateEntry {
+	entries := s.Entries()
+	out := entries[:0]
+	for _, e := range entries {
+		if e.Key != key {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Validate validates the tracestate entries, checking that each is valid,
+// that keys are unique, and that there are at most 32 entries.
+func (s TraceState) Validate() error {
+	entries := s.Entries()
+	if len(entries) > maxTracestateEntries {
+		return fmt.Errorf("tracestate has %d entries, more than the maximum of %d", len(entries), maxTracestateEntries)
+	}
+	keys := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if err := e.Validate(); err != nil {
+			return err
+		}
+		if keys[e.Key] {
+			return errors.Errorf("duplicate tracestate key %q", e.Key)
+		}
+		keys[e.Key] = true
+	}
+	return nil
+}
+
+// String returns s in the W3C tracestate header format.
+func (s TraceState) String() string {
+	return s.s
+}
+
+// withSampleRate returns a copy of s with the Elastic entry set to record
+// the sample rate r. As with Set, the entry is moved to the front.
+func (s TraceState) withSampleRate(r float64) TraceState {
+	entry := TraceStateEntry{Key: ElasticTracestateVendorKey, Value: "s:" + formatSampleRate(r)}
+	entries := append([]TraceStateEntry{entry}, s.without(ElasticTracestateVendorKey)...)
+	if len(entries) > maxTracestateEntries {
+		entries = entries[:maxTracestateEntries]
+	}
+	return NewTraceState(entries...)
+}
+
+// formatSampleRate formats r with at most four decimal places,
+// and without trailing zeroes.
+func formatSampleRate(r float64) string {
+	s := strconv.FormatFloat(r, 'f', 4, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+var errReservedTracestateKey = errors.New("tracestate key " + ElasticTracestateVendorKey + " is reserved for the agent")
@@ -0,0 +1,146 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmtest"
+)
+
+func TestTraceStateSet(t *testing.T) {
+	s := apm.NewTraceState(
+		apm.TraceStateEntry{Key: "es", Value: "s:1"},
+		apm.TraceStateEntry{Key: "acme", Value: "old"},
+	)
+	require.NoError(t, s.Validate())
+
+	s, err := s.Set("acme", "new")
+	require.NoError(t, err)
+	s, err = s.Set("other@vendor", "x y")
+	require.NoError(t, err)
+	assert.Equal(t, "other@vendor=x y,acme=new,es=s:1", s.String())
+
+	value, ok := s.Get("acme")
+	assert.True(t, ok)
+	assert.Equal(t, "new", value)
+	_, ok = s.Get("missing")
+	assert.False(t, ok)
+
+	s, err = s.Delete("acme")
+	require.NoError(t, err)
+	assert.Equal(t, []apm.TraceStateEntry{
+		{Key: "other@vendor", Value: "x y"},
+		{Key: "es", Value: "s:1"},
+	}, s.Entries())
+}
+
+func TestTraceStateReservedKey(t *testing.T) {
+	s := apm.NewTraceState(apm.TraceStateEntry{Key: "es", Value: "s:1"})
+	_, err := s.Set("es", "s:0")
+	assert.EqualError(t, err, "tracestate key es is reserved for the agent")
+	_, err = s.Delete("es")
+	assert.EqualError(t, err, "tracestate key es is reserved for the agent")
+}
+
+func TestTraceStateInvalid(t *testing.T) {
+	var s apm.TraceState
+	for _, entry := range []apm.TraceStateEntry{
+		{Key: "UPPER", Value: "x"},
+		{Key: "key", Value: ""},
+		{Key: "key", Value: "a,b"},
+		{Key: "key", Value: "a=b"},
+		{Key: "key", Value: "trailing "},
+		{Key: "key", Value: strings.Repeat("x", 257)},
+	} {
+		_, err := s.Set(entry.Key, entry.Value)
+		assert.Error(t, err, "%+v", entry)
+	}
+
+	assert.EqualError(t, apm.NewTraceState(
+		apm.TraceStateEntry{Key: "a", Value: "1"},
+		apm.TraceStateEntry{Key: "a", Value: "2"},
+	).Validate(), `duplicate tracestate key "a"`)
+}
+
+func TestTraceStateMaxEntries(t *testing.T) {
+	var s apm.TraceState
+	var err error
+	for i := 0; i < 33; i++ {
+		s, err = s.Set(string(rune('a'+i%26))+strings.Repeat("x", i/26), "v")
+		require.NoError(t, err)
+	}
+	entries := s.Entries()
+	assert.Len(t, entries, 32)
+	assert.Equal(t, "gx", entries[0].Key)
+	assert.Equal(t, "b", entries[31].Key)
+}
+
+func TestTransactionTraceState(t *testing.T) {
+	state := apm.NewTraceState(apm.TraceStateEntry{Key: "acme", Value: "1"})
+	tx := apmtest.DiscardTracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		TraceContext: apm.TraceContext{
+			Trace:   apm.TraceID{1},
+			Span:    apm.SpanID{1},
+			Options: apm.TraceOptions(0).WithRecorded(true),
+			State:   state,
+		},
+	})
+	defer tx.End()
+	assert.Equal(t, state, tx.TraceContext().State)
+
+	state, err := state.Set("other", "2")
+	require.NoError(t, err)
+	tx.SetTraceState(state)
+
+	span := tx.StartSpan("name", "type", nil)
+	defer span.End()
+	assert.Equal(t, "other=2,acme=1", span.TraceContext().State.String())
+}
+
+func TestTransactionTraceStateConcurrent(t *testing.T) {
+	tx := apmtest.DiscardTracer.StartTransaction("name", "type")
+	defer tx.End()
+
+	// SetTraceState may be called while other goroutines
+	// propagate the transaction's trace context.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			tx.SetTraceState(apm.NewTraceState(apm.TraceStateEntry{Key: "acme", Value: strconv.Itoa(i)}))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			tx.TraceContext()
+			tx.StartSpan("name", "type", nil).End()
+		}
+	}()
+	wg.Wait()
+	assert.Equal(t, "acme=99", tx.TraceContext().State.String())
+}
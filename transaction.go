@@ -21,8 +21,12 @@ import (
 	cryptorand "crypto/rand"
 	"encoding/binary"
 	"math/rand"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.elastic.co/apm/model"
 )
 
 // StartTransaction returns a new Transaction with the specified
@@ -55,26 +59,32 @@ func (t *Tracer) StartTransactionOptions(name, transactionType string, opts Tran
 	tx.Name = name
 	tx.Type = transactionType
 
+	t.idGeneratorMu.RLock()
+	tx.idGenerator = t.idGenerator
+	t.idGeneratorMu.RUnlock()
+
 	var root bool
 	if opts.TraceContext.Trace.Validate() == nil {
 		tx.traceContext.Trace = opts.TraceContext.Trace
 		tx.traceContext.Options = opts.TraceContext.Options
+		tx.traceContext.State = opts.TraceContext.State
 		if opts.TraceContext.Span.Validate() == nil {
 			tx.parentSpan = opts.TraceContext.Span
 		}
 		if opts.TransactionID.Validate() == nil {
 			tx.traceContext.Span = opts.TransactionID
 		} else {
-			binary.LittleEndian.PutUint64(tx.traceContext.Span[:], tx.rand.Uint64())
+			tx.traceContext.Span = tx.newSpanID()
 		}
 	} else {
 		// Start a new trace. We reuse the trace ID for the root transaction's ID
-		// if one is not specified in the options.
+		// if one is not specified in the options, and no IDGenerator is set.
 		root = true
-		binary.LittleEndian.PutUint64(tx.traceContext.Trace[:8], tx.rand.Uint64())
-		binary.LittleEndian.PutUint64(tx.traceContext.Trace[8:], tx.rand.Uint64())
+		tx.traceContext.Trace = tx.newTraceID()
 		if opts.TransactionID.Validate() == nil {
 			tx.traceContext.Span = opts.TransactionID
+		} else if tx.idGenerator != nil {
+			tx.traceContext.Span = tx.newSpanID()
 		} else {
 			copy(tx.traceContext.Span[:], tx.traceContext.Trace[:])
 		}
@@ -85,39 +95,178 @@ func (t *Tracer) StartTransactionOptions(name, transactionType string, opts Tran
 	// creations are dropped.
 	t.maxSpansMu.RLock()
 	tx.maxSpans = t.maxSpans
+	tx.maxSpansPerSecond = t.maxSpansPerSecond
 	t.maxSpansMu.RUnlock()
 
 	t.spanFramesMinDurationMu.RLock()
 	tx.spanFramesMinDuration = t.spanFramesMinDuration
+	tx.spanFramesMinDurationByType = t.spanFramesMinDurationByType
 	t.spanFramesMinDurationMu.RUnlock()
 
+	t.exitSpanMinDurationMu.RLock()
+	tx.exitSpanMinDuration = t.exitSpanMinDuration
+	t.exitSpanMinDurationMu.RUnlock()
+
+	t.spanCompressionMu.RLock()
+	tx.spanCompression = t.spanCompression
+	t.spanCompressionMu.RUnlock()
+
 	t.captureHeadersMu.RLock()
 	tx.Context.captureHeaders = t.captureHeaders
 	t.captureHeadersMu.RUnlock()
-
-	if root {
-		t.samplerMu.RLock()
-		sampler := t.sampler
-		t.samplerMu.RUnlock()
-		if sampler == nil || sampler.Sample(tx.traceContext) {
-			o := tx.traceContext.Options.WithRecorded(true)
-			tx.traceContext.Options = o
+	t.setGlobalLabels(&tx.Context)
+	tx.baggageTags = t.baggageTags(opts.Baggage)
+	setBaggageTags(&tx.Context, tx.baggageTags)
+
+	t.samplerMu.RLock()
+	sampler := t.sampler
+	if opts.Sampler != nil {
+		sampler = opts.Sampler
+	}
+	sampleDecisionCallback := t.sampleDecisionCallback
+	samplingReasonTag := t.samplingReasonTag
+	t.samplerMu.RUnlock()
+
+	var sampleReason string
+	if opts.ForceSample {
+		sampleReason = SampleReasonForced
+		tx.traceContext.Options = tx.traceContext.Options.WithRecorded(true)
+	} else if root {
+		sampleReason = SampleReasonNoSampler
+		if sampler != nil {
+			sampleReason = SampleReasonSampler
+			if s, ok := sampler.(ExtendedSampler); ok && !isNameIndependent(s) {
+				// Re-evaluate the decision if the name is changed
+				// before the decision is propagated.
+				tx.resampler = s
+				tx.sampledName = name
+			}
 		}
+		tx.sample(sampler)
 	} else {
 		// TODO(axw) make this behaviour configurable. In some cases
 		// it may not be a good idea to honour the recorded flag, as
 		// it may open up the application to DoS by forced sampling.
 		// Even ignoring bad actors, a service that has many feeder
 		// applications may end up being sampled at a very high rate.
+		sampleReason = SampleReasonParent
 		tx.traceContext.Options = opts.TraceContext.Options
 	}
+	if tx.resampler == nil {
+		tx.samplingResolved = 1
+	}
+	if samplingReasonTag {
+		tx.Context.SetTag("sampling_reason", sampleReason)
+	}
+	if sampleDecisionCallback != nil && tx.resampler == nil {
+		// If the decision may be re-evaluated, the callback
+		// is invoked once it has been resolved.
+		decision := SampleDecision{
+			TransactionName: name,
+			Sampled:         tx.traceContext.Options.Recorded(),
+			Reason:          sampleReason,
+		}
+		if !root {
+			decision.ParentTraceContext = opts.TraceContext
+		}
+		sampleDecisionCallback(decision)
+	}
 	tx.timestamp = opts.Start
 	if tx.timestamp.IsZero() {
 		tx.timestamp = time.Now()
 	}
+	tx.clockSkew = t.clockSkewOffset()
+	if len(opts.Links) != 0 {
+		tx.links = append([]SpanLink(nil), opts.Links...)
+	}
+	if !opts.EnqueueTime.IsZero() {
+		latency := tx.timestamp.Sub(opts.EnqueueTime)
+		if latency < 0 {
+			latency = 0
+		}
+		tx.queueLatency = strconv.FormatFloat(
+			float64(latency)/float64(time.Millisecond), 'f', 3, 64,
+		)
+		if tx.resampler == nil {
+			tx.setQueueLatencyTag()
+		}
+	}
 	return tx
 }
 
+// setQueueLatencyTag records tx's queue latency, if any, in the
+// MessagingQueueLatencyTag tag if tx is sampled. This must be called
+// once tx's sampling decision is final, and before tx has ended.
+func (tx *Transaction) setQueueLatencyTag() {
+	if tx.queueLatency != "" && tx.traceContext.Options.Recorded() {
+		tx.Context.SetTag(MessagingQueueLatencyTag, tx.queueLatency)
+	}
+}
+
+// sample makes the sampling decision for the root transaction tx with
+// sampler, which may be nil, recording the sample rate in tx's trace
+// state if sampler implements ExtendedSampler.
+func (tx *Transaction) sample(sampler Sampler) {
+	sampled := true
+	if s, ok := sampler.(ExtendedSampler); ok {
+		result := s.SampleExtended(SampleParams{
+			TraceContext:    tx.traceContext,
+			TransactionName: tx.Name,
+			TransactionType: tx.Type,
+		})
+		sampled = result.Sampled
+		tx.traceContext.State = tx.traceContext.State.withSampleRate(result.SampleRate)
+	} else if sampler != nil {
+		sampled = sampler.Sample(tx.traceContext)
+	}
+	tx.traceContext.Options = tx.traceContext.Options.WithRecorded(sampled)
+}
+
+// resolveSampling makes tx's sampling decision final, first re-evaluating
+// it if tx's name has changed since the decision was made, and notifies
+// the tracer's SampleDecisionCallback of the final decision. This must be
+// called before the decision is propagated, or recorded by spans or errors.
+func (tx *Transaction) resolveSampling() {
+	if atomic.LoadInt32(&tx.samplingResolved) != 0 {
+		return
+	}
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.resolveSamplingLocked()
+}
+
+// resolveSamplingLocked is like resolveSampling, but must be called with
+// tx.mu held.
+func (tx *Transaction) resolveSamplingLocked() {
+	if atomic.LoadInt32(&tx.samplingResolved) != 0 {
+		return
+	}
+	if !tx.ended() {
+		if tx.Name != tx.sampledName {
+			tx.sample(tx.resampler)
+			tx.sampledName = tx.Name
+		}
+		tx.setQueueLatencyTag()
+	}
+	atomic.StoreInt32(&tx.samplingResolved, 1)
+
+	tx.tracer.samplerMu.RLock()
+	sampleDecisionCallback := tx.tracer.sampleDecisionCallback
+	tx.tracer.samplerMu.RUnlock()
+	if sampleDecisionCallback != nil {
+		sampleDecisionCallback(SampleDecision{
+			TransactionName: tx.sampledName,
+			Sampled:         tx.traceContext.Options.Recorded(),
+			Reason:          SampleReasonSampler,
+		})
+	}
+}
+
+// MessagingQueueLatencyTag is the transaction tag in which the time spent
+// by a message in a queue before processing, in milliseconds, is recorded.
+// See TransactionOptions.EnqueueTime.
+const MessagingQueueLatencyTag = "messaging_queue_latency"
+
 // TransactionOptions holds options for Tracer.StartTransactionOptions.
 type TransactionOptions struct {
 	// TraceContext holds the TraceContext for a new transaction. If this is
@@ -131,6 +280,35 @@ type TransactionOptions struct {
 	// Start is the start time of the transaction. If this has the
 	// zero value, time.Now() will be used instead.
 	Start time.Time
+
+	// ForceSample, if true, causes the transaction to be sampled
+	// regardless of the tracer's sampler, and the sampling decision
+	// in TraceContext. This is intended for capturing traces of
+	// specific requests, e.g. while debugging.
+	ForceSample bool
+
+	// Sampler, if non-nil, is used in place of the tracer's sampler
+	// to make the sampling decision for the root transaction of a
+	// trace. This may be used for sampling a class of transactions,
+	// such as health checks, at an independent rate.
+	Sampler Sampler
+
+	// EnqueueTime, if non-zero, is the time at which the message
+	// processed by a messaging transaction was enqueued. The time
+	// from EnqueueTime to the transaction's start is recorded in the
+	// MessagingQueueLatencyTag tag, if the transaction is sampled.
+	EnqueueTime time.Time
+
+	// Baggage holds the W3C Baggage received with the transaction's
+	// trace context. Members with keys matching the tracer's
+	// baggage-to-attach patterns are recorded as tags, prefixed with
+	// BaggageTagPrefix, on the transaction, its spans, and its errors.
+	Baggage []BaggageMember
+
+	// Links holds links to other spans or transactions, e.g. those which
+	// produced the messages processed by the transaction. See also
+	// LinksFromCarriers.
+	Links []SpanLink
 }
 
 // Transaction describes an event occurring in the monitored service.
@@ -140,16 +318,48 @@ type Transaction struct {
 
 	mu sync.RWMutex
 
+	// childrenMu guards children and selfTime. No other locks
+	// may be acquired while holding childrenMu.
+	childrenMu sync.Mutex
+	children   childrenTimer
+	selfTime   time.Duration
+
+	// resampler, if non-nil, is the sampler with which the sampling
+	// decision is re-evaluated if the transaction's name is changed
+	// from sampledName before the decision is resolved. Once resolved,
+	// samplingResolved is set atomically to 1.
+	resampler        ExtendedSampler
+	sampledName      string
+	samplingResolved int32
+
+	// queueLatency holds the formatted time from
+	// TransactionOptions.EnqueueTime to the transaction's start,
+	// recorded as a tag once the sampling decision is resolved.
+	queueLatency string
+
+	// baggageTags holds the tags recorded from the transaction's
+	// incoming baggage. It is immutable once the transaction starts.
+	baggageTags []model.StringMapItem
+
 	// TransactionData holds the transaction data. This field is set to
 	// nil when either of the transaction's End or Discard methods are called.
 	*TransactionData
 }
 
 // Sampled reports whether or not the transaction is sampled.
+//
+// If the tracer's Sampler implements ExtendedSampler, and its decision may
+// depend on the transaction name, the sampling decision for the root
+// transaction of a trace is re-evaluated when its name has been changed,
+// and spans are started, its trace context is propagated, an error is
+// reported, or it is ended. Until then, Sampled reports the decision made
+// with the name passed to StartTransaction.
 func (tx *Transaction) Sampled() bool {
 	if tx == nil {
 		return false
 	}
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
 	return tx.traceContext.Options.Recorded()
 }
 
@@ -161,9 +371,38 @@ func (tx *Transaction) TraceContext() TraceContext {
 	if tx == nil {
 		return TraceContext{}
 	}
+	tx.resolveSampling()
+	// tx.mu guards traceContext.State, which may
+	// be changed with SetTraceState.
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
 	return tx.traceContext
 }
 
+// SelfTime returns the transaction's self-time: its duration, minus the
+// time during which at least one of its direct child spans was active.
+// Child spans still active when tx ends are treated as ending with tx.
+//
+// Unlike other methods, SelfTime may be called after End. SelfTime
+// returns false if tx has not ended, or was discarded.
+func (tx *Transaction) SelfTime() (time.Duration, bool) {
+	if tx == nil {
+		return 0, false
+	}
+	tx.childrenMu.Lock()
+	defer tx.childrenMu.Unlock()
+	return tx.selfTime, tx.children.ended
+}
+
+// Tracer returns the Tracer with which the transaction was started.
+// If tx is nil, Tracer returns nil.
+func (tx *Transaction) Tracer() *Tracer {
+	if tx == nil {
+		return nil
+	}
+	return tx.tracer
+}
+
 // EnsureParent returns the span ID for for tx's parent, generating a
 // parent span ID if one has not already been set and tx has not been
 // ended. If tx is nil or has been ended, a zero (invalid) SpanID is
@@ -196,6 +435,60 @@ func (tx *Transaction) EnsureParent() SpanID {
 	return tx.parentSpan
 }
 
+// SetParent re-parents tx, replacing its trace ID, parent ID and trace
+// state with those of parent. The transaction ID and sampling decision
+// are retained: parent's trace options, including its sampled flag, are
+// ignored.
+//
+// This method can be used by frameworks that only learn of the real
+// parent after the transaction has been started, e.g. after decoding
+// a message envelope. Spans record the trace ID at the time they are
+// started, so SetParent must be called before any spans are started;
+// it must also not be called concurrently with other methods of tx.
+//
+// SetParent reports whether tx was re-parented. It returns false if
+// tx is nil or has been ended, if spans have already been started, or
+// if parent's trace or span ID is invalid.
+func (tx *Transaction) SetParent(parent TraceContext) bool {
+	if tx == nil {
+		return false
+	}
+	if parent.Trace.Validate() != nil || parent.Span.Validate() != nil {
+		return false
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.ended() {
+		return false
+	}
+
+	tx.TransactionData.mu.Lock()
+	defer tx.TransactionData.mu.Unlock()
+	if tx.spansCreated > 0 || tx.spansDropped > 0 {
+		return false
+	}
+	// Make the sampling decision final before replacing the
+	// trace ID, with which it may otherwise be re-evaluated.
+	tx.resolveSamplingLocked()
+	tx.traceContext.Trace = parent.Trace
+	tx.traceContext.State = parent.State
+	tx.parentSpan = parent.Span
+	return true
+}
+
+// SetTraceState sets the vendor-specific trace state of tx's trace context,
+// e.g. to add an entry with TraceState.Set. The new state is propagated by
+// spans started, and outgoing requests made, after SetTraceState returns.
+// SetTraceState has no effect if tx has been ended.
+func (tx *Transaction) SetTraceState(s TraceState) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if !tx.ended() {
+		tx.traceContext.State = s
+	}
+}
+
 // Discard discards a previously started transaction.
 //
 // Calling Discard will set tx's TransactionData field to nil, so callers must
@@ -206,6 +499,8 @@ func (tx *Transaction) Discard() {
 	if tx.ended() {
 		return
 	}
+	tx.resolveSamplingLocked()
+	tx.flushCompressedSpan()
 	tx.reset(tx.tracer)
 }
 
@@ -222,13 +517,64 @@ func (tx *Transaction) End() {
 	if tx.ended() {
 		return
 	}
+	tx.end()
+}
+
+// EndWithDuration sets the start time and duration of tx, and then
+// ends it as with End. This may be used for recording transactions
+// whose timings are obtained from an external system, e.g. when
+// importing or replaying events after the fact.
+//
+// If start is the zero value, the transaction's existing start time
+// will be retained. A negative duration is treated as zero.
+func (tx *Transaction) EndWithDuration(start time.Time, d time.Duration) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.ended() {
+		return
+	}
+	if !start.IsZero() {
+		tx.timestamp = start
+	}
+	if d < 0 {
+		d = 0
+	}
+	tx.Duration = d
+	tx.end()
+}
+
+// end ends tx. This must be called with tx.mu held.
+func (tx *Transaction) end() {
 	if tx.Duration < 0 {
 		tx.Duration = time.Since(tx.timestamp)
 	}
+	if bc := tx.Context.failedTransactionBody; bc != nil {
+		tx.TransactionData.mu.Lock()
+		failed := tx.errorReported || tx.Context.response.StatusCode >= 500
+		tx.TransactionData.mu.Unlock()
+		if failed {
+			tx.Context.setHTTPRequestBody(bc)
+		}
+	}
+	tx.resolveSamplingLocked()
+	tx.childrenMu.Lock()
+	tx.selfTime = tx.children.selfTime(tx.timestamp.Add(tx.Duration), tx.Duration)
+	tx.childrenMu.Unlock()
+	tx.flushCompressedSpan()
 	tx.enqueue()
 	tx.TransactionData = nil
 }
 
+// flushCompressedSpan enqueues the child span buffered for compression,
+// if any. This must be called with tx.mu held, and tx must not have ended.
+func (tx *Transaction) flushCompressedSpan() {
+	tx.TransactionData.mu.Lock()
+	buffered := tx.compressedSpan
+	tx.compressedSpan = compressedSpan{}
+	tx.TransactionData.mu.Unlock()
+	buffered.enqueue()
+}
+
 func (tx *Transaction) enqueue() {
 	event := tracerEvent{eventType: transactionEvent}
 	event.tx.Transaction = tx
@@ -240,10 +586,20 @@ func (tx *Transaction) enqueue() {
 		tx.tracer.statsMu.Lock()
 		tx.tracer.stats.TransactionsDropped++
 		tx.tracer.statsMu.Unlock()
+		tx.tracer.eventsDropped("transaction", 1)
 		tx.reset(tx.tracer)
 	}
 }
 
+// setErrorReported records that an error has been reported for tx.
+//
+// This must be called with tx.mu held, and tx must not have ended.
+func (tx *Transaction) setErrorReported() {
+	tx.TransactionData.mu.Lock()
+	tx.errorReported = true
+	tx.TransactionData.mu.Unlock()
+}
+
 // ended reports whether or not End or Discard has been called.
 //
 // This must be called with tx.mu held.
@@ -276,17 +632,35 @@ type TransactionData struct {
 	// Result holds the transaction result.
 	Result string
 
-	maxSpans              int
-	spanFramesMinDuration time.Duration
-	timestamp             time.Time
-
-	mu           sync.Mutex
-	spansCreated int
-	spansDropped int
-	rand         *rand.Rand // for ID generation
+	maxSpans                    int
+	maxSpansPerSecond           int
+	spanFramesMinDuration       time.Duration
+	spanFramesMinDurationByType map[string]time.Duration
+	exitSpanMinDuration         time.Duration
+	spanCompression             spanCompressionConfig
+	timestamp                   time.Time
+	// clockSkew holds the duration by which the timestamps of the
+	// transaction and its spans are adjusted when they are encoded.
+	clockSkew time.Duration
+	links     []SpanLink
+
+	mu            sync.Mutex
+	spansCreated  int
+	spansDropped  int
+	errorReported bool
+	// spanRateStart and spanRateCount record the start of the current
+	// one-second window for maxSpansPerSecond, and the number of spans
+	// started within it.
+	spanRateStart time.Time
+	spanRateCount int
+	rand          *rand.Rand // for ID generation
+	idGenerator   IDGenerator
 	// parentSpan holds the transaction's parent ID. It is protected by
 	// mu, since it can be updated by calling EnsureParent.
 	parentSpan SpanID
+	// compressedSpan holds the most recently ended child span which may
+	// be compressed with its subsequently ended siblings.
+	compressedSpan compressedSpan
 }
 
 // reset resets the TransactionData back to its zero state and places it back
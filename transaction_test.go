@@ -18,7 +18,9 @@
 package apm_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -135,6 +137,186 @@ func TestTransactionEnsureParent(t *testing.T) {
 	assert.Equal(t, model.SpanID(parentSpan), payloads.Transactions[0].ParentID)
 }
 
+func TestTransactionSetParent(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	transactionID := tx.TraceContext().Span
+	assert.False(t, tx.SetParent(apm.TraceContext{}))
+
+	parent := apm.TraceContext{
+		Trace: apm.TraceID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		Span:  apm.SpanID{0, 1, 2, 3, 4, 5, 6, 7},
+	}
+	parentState := apm.NewTraceState(apm.TraceStateEntry{Key: "vendor", Value: "value"})
+	parent.State = parentState
+	assert.True(t, tx.SetParent(parent))
+	assert.Equal(t, parent.Trace, tx.TraceContext().Trace)
+	assert.Equal(t, transactionID, tx.TraceContext().Span)
+	assert.Equal(t, parentState, tx.TraceContext().State)
+
+	// The parent's sampled flag is ignored.
+	assert.False(t, parent.Options.Recorded())
+	assert.True(t, tx.Sampled())
+
+	// Once a span has been started, the transaction
+	// can no longer be re-parented.
+	tx.StartSpan("name", "type", nil).End()
+	assert.False(t, tx.SetParent(apm.TraceContext{
+		Trace: apm.TraceID{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0},
+		Span:  apm.SpanID{7, 6, 5, 4, 3, 2, 1, 0},
+	}))
+	tx.End()
+	assert.False(t, tx.SetParent(parent))
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, model.TraceID(parent.Trace), payloads.Transactions[0].TraceID)
+	assert.Equal(t, model.SpanID(parent.Span), payloads.Transactions[0].ParentID)
+	assert.Equal(t, model.SpanID(transactionID), payloads.Transactions[0].ID)
+	assert.Equal(t, model.TraceID(parent.Trace), payloads.Spans[0].TraceID)
+}
+
+func TestTransactionEndWithDuration(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	tx := tracer.StartTransaction("name", "type")
+	tx.EndWithDuration(start, 2*time.Second)
+	tx.End() // no-op
+
+	tx = tracer.StartTransaction("name", "type")
+	tx.EndWithDuration(time.Time{}, -time.Second)
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, model.Time(start), payloads.Transactions[0].Timestamp)
+	assert.Equal(t, 2000.0, payloads.Transactions[0].Duration)
+	assert.NotEqual(t, model.Time(start), payloads.Transactions[1].Timestamp)
+	assert.Equal(t, 0.0, payloads.Transactions[1].Duration)
+}
+
+func TestTransactionEnqueueTime(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		Start:       start,
+		EnqueueTime: start.Add(-1500 * time.Microsecond),
+	}).End()
+	tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		Start:       start,
+		EnqueueTime: start.Add(time.Second), // clock skew
+	}).End()
+	tracer.StartTransaction("name", "type").End()
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 3)
+	assert.Equal(t, model.StringMap{{Key: "messaging_queue_latency", Value: "1.500"}}, payloads.Transactions[0].Context.Tags)
+	assert.Equal(t, model.StringMap{{Key: "messaging_queue_latency", Value: "0.000"}}, payloads.Transactions[1].Context.Tags)
+	assert.Nil(t, payloads.Transactions[2].Context)
+
+	// The latency is not recorded for unsampled transactions.
+	transport.ResetPayloads()
+	tracer.SetSampler(apm.NewRatioSampler(0))
+	tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		EnqueueTime: start.Add(-time.Second),
+	}).End()
+	tracer.Flush(nil)
+	payloads = transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Nil(t, payloads.Transactions[0].Context)
+
+	// The latency is recorded if the transaction is sampled once
+	// the decision is re-evaluated with a name set after it starts.
+	transport.ResetPayloads()
+	tracer.SetSampler(apm.NewTransactionSampler(0,
+		apm.TransactionSamplingRule{Name: "process orders", Rate: 1},
+	))
+	tx := tracer.StartTransactionOptions("process", "messaging", apm.TransactionOptions{
+		Start:       start,
+		EnqueueTime: start.Add(-2 * time.Millisecond),
+	})
+	assert.False(t, tx.Sampled())
+	tx.Name = "process orders"
+	tx.End()
+	tracer.Flush(nil)
+	payloads = transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.NotNil(t, payloads.Transactions[0].Context)
+	assert.Equal(t, model.StringMap{{Key: "messaging_queue_latency", Value: "2.000"}}, payloads.Transactions[0].Context.Tags)
+}
+
+func TestTransactionSelfTime(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	start := time.Now()
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	ms := func(ms int) time.Duration {
+		return time.Duration(ms) * time.Millisecond
+	}
+
+	tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{Start: start})
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	a := tx.StartSpanOptions("a", "type", apm.SpanOptions{Start: at(10)})
+	b := tx.StartSpanOptions("b", "type", apm.SpanOptions{Start: at(20)})
+	a.EndWithDuration(time.Time{}, ms(20))
+	b.EndWithDuration(time.Time{}, ms(20))
+
+	// Only direct children are accounted in the self-time.
+	c, ctx := apm.StartSpanOptions(ctx, "c", "type", apm.SpanOptions{Start: at(50)})
+	d, _ := apm.StartSpanOptions(ctx, "d", "type", apm.SpanOptions{Start: at(52)})
+	d.EndWithDuration(time.Time{}, ms(3))
+	_, ok := c.SelfTime()
+	assert.False(t, ok)
+	c.EndWithDuration(time.Time{}, ms(10))
+
+	// Children still active when the transaction ends
+	// are treated as ending with the transaction.
+	e := tx.StartSpanOptions("e", "type", apm.SpanOptions{Start: at(90)})
+
+	_, ok = tx.SelfTime()
+	assert.False(t, ok)
+	tx.EndWithDuration(time.Time{}, ms(100))
+	e.EndWithDuration(time.Time{}, ms(20))
+
+	selfTime, ok := tx.SelfTime()
+	assert.True(t, ok)
+	assert.Equal(t, ms(50), selfTime)
+
+	selfTime, ok = c.SelfTime()
+	assert.True(t, ok)
+	assert.Equal(t, ms(7), selfTime)
+
+	selfTime, ok = e.SelfTime()
+	assert.True(t, ok)
+	assert.Equal(t, ms(20), selfTime)
+}
+
+func TestTransactionSelfTimeDiscarded(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.Discard()
+	_, ok := tx.SelfTime()
+	assert.False(t, ok)
+
+	var nilTransaction *apm.Transaction
+	_, ok = nilTransaction.SelfTime()
+	assert.False(t, ok)
+}
+
 type samplerFunc func(apm.TraceContext) bool
 
 func (f samplerFunc) Sample(t apm.TraceContext) bool {
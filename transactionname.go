@@ -0,0 +1,43 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import "regexp"
+
+// TransactionNameRewriteRule is a rule for rewriting transaction names
+// before they are sent to the APM server, e.g. to strip locale prefixes
+// from URL paths. See Tracer.SetTransactionNameRewriteRules.
+type TransactionNameRewriteRule struct {
+	// Pattern is the regular expression to match in transaction names.
+	// Pattern must not be nil.
+	Pattern *regexp.Regexp
+
+	// Replacement is the text with which matches of Pattern are replaced.
+	// Inside Replacement, $ signs are interpreted as in regexp.Expand, so
+	// for example $1 represents the text of the first submatch.
+	Replacement string
+}
+
+// rewriteTransactionName returns name, rewritten by applying each of the
+// rules in order to the result of the previous rule.
+func rewriteTransactionName(name string, rules []TransactionNameRewriteRule) string {
+	for _, rule := range rules {
+		name = rule.Pattern.ReplaceAllString(name, rule.Replacement)
+	}
+	return name
+}
@@ -31,3 +31,36 @@ type Transport interface {
 	// terminates.
 	SendStream(context.Context, io.Reader) error
 }
+
+// ConfigWatcher provides an interface for watching changes to the agent's
+// configuration, such as central configuration managed in Kibana.
+type ConfigWatcher interface {
+	// WatchConfig watches for changes to the configuration of the agent
+	// identified by params, sending them to the returned channel. The
+	// channel is closed when ctx is canceled.
+	//
+	// If an error occurs fetching the configuration, it is sent in a
+	// ConfigChange with Err set, and watching continues.
+	WatchConfig(ctx context.Context, params WatchConfigParams) <-chan ConfigChange
+}
+
+// WatchConfigParams holds parameters for watching configuration changes.
+type WatchConfigParams struct {
+	// Service holds the name and, optionally, the environment of the
+	// service whose configuration should be watched.
+	Service struct {
+		Name        string
+		Environment string
+	}
+}
+
+// ConfigChange holds a configuration change: either an error, or the
+// complete set of configuration attributes.
+type ConfigChange struct {
+	// Err holds an error that occurred while fetching the configuration.
+	Err error
+
+	// Attrs holds the configuration attributes. Attrs may be empty,
+	// meaning that the agent should revert to its local configuration.
+	Attrs map[string]string
+}
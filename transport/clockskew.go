@@ -0,0 +1,114 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// clockSkewEstimator estimates the difference between the APM Server's
+// clock and the local clock, using the Date header of server responses.
+//
+// The Date header has a resolution of one second, and is generated at
+// some point between the request being sent and the response being
+// received. Each response therefore bounds the skew to an interval:
+//
+//	date - received <= skew < date + 1s - sent
+//
+// The intervals of successive responses are intersected, narrowing the
+// estimate to within a network round trip. If an interval is disjoint
+// from the current estimate, e.g. because the local clock was adjusted,
+// the estimate is restarted from that interval.
+type clockSkewEstimator struct {
+	mu           sync.Mutex
+	lower, upper time.Duration
+	valid        bool
+}
+
+// observe records the Date header of a response received at the time
+// received, for a request that was completely sent at the time sent.
+func (e *clockSkewEstimator) observe(sent, received time.Time, resp *http.Response) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil || sent.IsZero() || received.Before(sent) {
+		return
+	}
+	// Strip the monotonic clock readings, so the local
+	// times are compared with the server's wall clock.
+	lower := date.Sub(received.Round(0))
+	upper := date.Add(time.Second).Sub(sent.Round(0))
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.valid && lower < e.upper && upper > e.lower {
+		if lower > e.lower {
+			e.lower = lower
+		}
+		if upper < e.upper {
+			e.upper = upper
+		}
+		return
+	}
+	e.lower, e.upper, e.valid = lower, upper, true
+}
+
+// estimate returns the estimated clock skew, and whether any responses
+// have been observed. The skew is positive if the server's clock is
+// ahead of the local clock, and zero if the clocks cannot be shown to
+// differ.
+func (e *clockSkewEstimator) estimate() (time.Duration, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.valid {
+		return 0, false
+	}
+	if e.lower <= 0 && e.upper >= 0 {
+		return 0, true
+	}
+	return e.lower + (e.upper-e.lower)/2, true
+}
+
+// timedReader wraps an io.Reader, recording the time at which
+// the reader was completely consumed.
+type timedReader struct {
+	r   io.Reader
+	mu  sync.Mutex
+	eof time.Time
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		r.mu.Lock()
+		if r.eof.IsZero() {
+			r.eof = time.Now()
+		}
+		r.mu.Unlock()
+	}
+	return n, err
+}
+
+// consumed returns the time at which the reader was completely
+// consumed, or the zero value if it has not been.
+func (r *timedReader) consumed() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.eof
+}
@@ -0,0 +1,120 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport
+
+import (
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/model"
+)
+
+// Exporter provides an interface for exporting decoded model entities to
+// backends other than the Elastic APM server. Use NewExporterTransport to
+// adapt an Exporter to a Transport for use with a Tracer. Methods are not
+// required to be safe for concurrent use.
+type Exporter interface {
+	// Export exports a batch of events, returning when the events
+	// have been exported or ctx is cancelled.
+	Export(ctx context.Context, batch *Batch) error
+}
+
+// Batch holds the metadata and events decoded from a single stream.
+type Batch struct {
+	// System, Process and Service hold the stream metadata,
+	// which applies to all events in the batch.
+	System  model.System
+	Process model.Process
+	Service model.Service
+
+	Transactions []model.Transaction
+	Spans        []model.Span
+	Errors       []model.Error
+	Metrics      []model.Metrics
+}
+
+// NewExporterTransport returns a Transport which decodes each stream into
+// a Batch, and passes it to e. Empty batches are not exported.
+func NewExporterTransport(e Exporter) Transport {
+	return exporterTransport{exporter: e}
+}
+
+type exporterTransport struct {
+	exporter Exporter
+}
+
+// SendStream decodes the stream, and exports the resulting batch.
+func (t exporterTransport) SendStream(ctx context.Context, r io.Reader) error {
+	var batch Batch
+	if err := decodeStream(r, &batch); err != nil {
+		return err
+	}
+	if len(batch.Transactions)+len(batch.Spans)+len(batch.Errors)+len(batch.Metrics) == 0 {
+		return nil
+	}
+	return t.exporter.Export(ctx, &batch)
+}
+
+// decodeStream decodes the zlib-compressed, newline-delimited
+// JSON stream r into batch.
+func decodeStream(r io.Reader, batch *Batch) error {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to decompress stream")
+	}
+	defer zr.Close()
+
+	decoder := json.NewDecoder(zr)
+	for {
+		var payload struct {
+			Metadata *struct {
+				System  model.System  `json:"system"`
+				Process model.Process `json:"process"`
+				Service model.Service `json:"service"`
+			} `json:"metadata"`
+			Error       *model.Error       `json:"error"`
+			Metrics     *model.Metrics     `json:"metricset"`
+			Span        *model.Span        `json:"span"`
+			Transaction *model.Transaction `json:"transaction"`
+		}
+		if err := decoder.Decode(&payload); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.Wrap(err, "failed to decode stream")
+		}
+		switch {
+		case payload.Metadata != nil:
+			batch.System = payload.Metadata.System
+			batch.Process = payload.Metadata.Process
+			batch.Service = payload.Metadata.Service
+		case payload.Error != nil:
+			batch.Errors = append(batch.Errors, *payload.Error)
+		case payload.Metrics != nil:
+			batch.Metrics = append(batch.Metrics, *payload.Metrics)
+		case payload.Span != nil:
+			batch.Spans = append(batch.Spans, *payload.Span)
+		case payload.Transaction != nil:
+			batch.Transactions = append(batch.Transactions, *payload.Transaction)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestExporterTransport(t *testing.T) {
+	var exporter recorderExporter
+	tracer, err := apm.NewTracer("exporter_test", "1.0")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transport.NewExporterTransport(&exporter)
+
+	tx := tracer.StartTransaction("name", "type")
+	span := tx.StartSpan("span", "db.sql.query", nil)
+	span.End()
+	tracer.NewError(errors.New("boom")).Send()
+	tx.End()
+	tracer.Flush(nil)
+
+	require.Len(t, exporter.batches, 1)
+	batch := exporter.batches[0]
+	assert.Equal(t, "exporter_test", batch.Service.Name)
+	assert.Equal(t, "1.0", batch.Service.Version)
+	require.Len(t, batch.Transactions, 1)
+	require.Len(t, batch.Spans, 1)
+	require.Len(t, batch.Errors, 1)
+	assert.Equal(t, "name", batch.Transactions[0].Name)
+	assert.Equal(t, "span", batch.Spans[0].Name)
+	assert.Equal(t, "boom", batch.Errors[0].Exception.Message)
+}
+
+func TestExporterTransportEmptyStream(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(`{"metadata":{"service":{"name":"exporter_test"}}}` + "\n"))
+	zw.Close()
+
+	var exporter recorderExporter
+	transport := transport.NewExporterTransport(&exporter)
+	err := transport.SendStream(context.Background(), &buf)
+	assert.NoError(t, err)
+	assert.Empty(t, exporter.batches)
+}
+
+func TestExporterTransportInvalidStream(t *testing.T) {
+	var exporter recorderExporter
+	transport := transport.NewExporterTransport(&exporter)
+	err := transport.SendStream(context.Background(), strings.NewReader("not zlib"))
+	assert.EqualError(t, err, "failed to decompress stream: zlib: invalid header")
+	assert.Empty(t, exporter.batches)
+}
+
+func TestFanoutTransport(t *testing.T) {
+	var primary, secondary transporttest.RecorderTransport
+	tracer, err := apm.NewTracer("fanout_test", "1.0")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transport.NewFanoutTransport(&primary, &secondary)
+
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+
+	require.Len(t, primary.Payloads().Transactions, 1)
+	assert.Equal(t, primary.Payloads(), secondary.Payloads())
+}
+
+func TestFanoutTransportErrors(t *testing.T) {
+	var secondary transporttest.RecorderTransport
+	primaryErr := errors.New("primary failed")
+	primary := transportFunc(func(ctx context.Context, r io.Reader) error {
+		return primaryErr
+	})
+	tracer, err := apm.NewTracer("fanout_test", "1.0")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transport.NewFanoutTransport(primary, &secondary)
+
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+
+	// The primary transport did not read the stream,
+	// but the secondary transport should receive it all.
+	assert.Len(t, secondary.Payloads().Transactions, 1)
+	assert.Equal(t, uint64(1), tracer.Stats().Errors.SendStream)
+}
+
+func TestFanoutTransportForwarding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"version":"7.4.0"}`)
+	}))
+	defer server.Close()
+
+	primary, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+	primary.SetServerURL(mustParseURL(server.URL))
+
+	var secondary transporttest.RecorderTransport
+	fanout := transport.NewFanoutTransport(primary, &secondary)
+	require.Implements(t, (*transport.ConfigWatcher)(nil), fanout)
+
+	info, err := fanout.(interface {
+		ServerInfo(context.Context) (*transport.ServerInfo, error)
+	}).ServerInfo(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "7.4.0", info.Version)
+
+	_, ok := fanout.(interface {
+		ClockSkew() (time.Duration, bool)
+	}).ClockSkew()
+	assert.True(t, ok)
+}
+
+func TestFanoutTransportForwardingUnsupported(t *testing.T) {
+	var primary, secondary transporttest.RecorderTransport
+	fanout := transport.NewFanoutTransport(&primary, &secondary)
+
+	changes := fanout.(transport.ConfigWatcher).WatchConfig(context.Background(), transport.WatchConfigParams{})
+	_, ok := <-changes
+	assert.False(t, ok)
+
+	_, err := fanout.(interface {
+		ServerInfo(context.Context) (*transport.ServerInfo, error)
+	}).ServerInfo(context.Background())
+	assert.EqualError(t, err, "transport *transporttest.RecorderTransport does not support connectivity checks")
+
+	_, ok = fanout.(interface {
+		ClockSkew() (time.Duration, bool)
+	}).ClockSkew()
+	assert.False(t, ok)
+}
+
+type recorderExporter struct {
+	batches []*transport.Batch
+}
+
+func (e *recorderExporter) Export(ctx context.Context, batch *transport.Batch) error {
+	e.batches = append(e.batches, batch)
+	return nil
+}
+
+type transportFunc func(context.Context, io.Reader) error
+
+func (f transportFunc) SendStream(ctx context.Context, r io.Reader) error {
+	return f(ctx, r)
+}
@@ -0,0 +1,102 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// NewFanoutTransport returns a Transport which sends each stream to
+// primary, and then to each of the secondary transports, for example
+// to send events to both the Elastic APM server and an OpenTelemetry
+// collector.
+//
+// Each stream is buffered in memory while it is sent to primary, and
+// replayed to the secondary transports in order. If primary returns
+// before reading the entire stream, e.g. because the server could not be
+// reached, the remainder of the stream is read for the secondary transports.
+// All transports are sent the stream, and the first error encountered, if
+// any, is returned.
+//
+// The returned Transport forwards configuration watching, connectivity
+// checks and clock skew estimation to primary, where supported.
+func NewFanoutTransport(primary Transport, secondary ...Transport) Transport {
+	return &fanoutTransport{primary: primary, secondary: secondary}
+}
+
+type fanoutTransport struct {
+	primary   Transport
+	secondary []Transport
+	buf       bytes.Buffer
+}
+
+// SendStream sends the stream to each of the transports.
+func (t *fanoutTransport) SendStream(ctx context.Context, r io.Reader) error {
+	t.buf.Reset()
+	stream := &eofReader{r: io.TeeReader(r, &t.buf)}
+	err := t.primary.SendStream(ctx, stream)
+	if !stream.eof {
+		// Make sure the secondary transports receive the
+		// entire stream, even if primary stops reading early.
+		// The stream must not be read again after io.EOF,
+		// as the Tracer will not respond.
+		if _, copyErr := io.Copy(ioutil.Discard, stream); err == nil {
+			err = copyErr
+		}
+	}
+	for _, secondary := range t.secondary {
+		if secondaryErr := secondary.SendStream(ctx, bytes.NewReader(t.buf.Bytes())); err == nil {
+			err = secondaryErr
+		}
+	}
+	return err
+}
+
+// WatchConfig watches the primary transport for config changes.
+func (t *fanoutTransport) WatchConfig(ctx context.Context, params WatchConfigParams) <-chan ConfigChange {
+	return forwardWatchConfig(t.primary, ctx, params)
+}
+
+// ServerInfo requests information about the server from the primary transport.
+func (t *fanoutTransport) ServerInfo(ctx context.Context) (*ServerInfo, error) {
+	return forwardServerInfo(t.primary, ctx)
+}
+
+// ClockSkew returns the clock skew estimated by the primary transport.
+func (t *fanoutTransport) ClockSkew() (time.Duration, bool) {
+	return forwardClockSkew(t.primary)
+}
+
+// eofReader is an io.Reader which records whether
+// the underlying reader has returned io.EOF.
+type eofReader struct {
+	r   io.Reader
+	eof bool
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
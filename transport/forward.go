@@ -0,0 +1,65 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// serverInfoGetter and clockSkewReporter are the optional interfaces,
+// besides ConfigWatcher, that the Tracer looks for on its Transport.
+// Transports which wrap another Transport forward them to it.
+type serverInfoGetter interface {
+	ServerInfo(context.Context) (*ServerInfo, error)
+}
+
+type clockSkewReporter interface {
+	ClockSkew() (time.Duration, bool)
+}
+
+// forwardWatchConfig calls t.WatchConfig if t is a ConfigWatcher, and
+// otherwise returns a closed channel, so that no changes are received.
+func forwardWatchConfig(t Transport, ctx context.Context, params WatchConfigParams) <-chan ConfigChange {
+	if w, ok := t.(ConfigWatcher); ok {
+		return w.WatchConfig(ctx, params)
+	}
+	changes := make(chan ConfigChange)
+	close(changes)
+	return changes
+}
+
+// forwardServerInfo calls t.ServerInfo if t implements it, and
+// otherwise returns an error.
+func forwardServerInfo(t Transport, ctx context.Context) (*ServerInfo, error) {
+	if g, ok := t.(serverInfoGetter); ok {
+		return g.ServerInfo(ctx)
+	}
+	return nil, errors.Errorf("transport %T does not support connectivity checks", t)
+}
+
+// forwardClockSkew calls t.ClockSkew if t implements it, and
+// otherwise reports that the clock skew is unknown.
+func forwardClockSkew(t Transport) (time.Duration, bool) {
+	if e, ok := t.(clockSkewReporter); ok {
+		return e.ClockSkew()
+	}
+	return 0, false
+}
@@ -22,6 +22,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io"
@@ -30,7 +31,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -39,7 +42,8 @@ import (
 )
 
 const (
-	intakePath = "/intake/v2/events"
+	intakePath      = "/intake/v2/events"
+	agentConfigPath = "/config/v1/agents"
 
 	envSecretToken      = "ELASTIC_APM_SECRET_TOKEN"
 	envServerURLs       = "ELASTIC_APM_SERVER_URLS"
@@ -56,6 +60,8 @@ var (
 
 	defaultServerURL, _  = url.Parse("http://localhost:8200")
 	defaultServerTimeout = 30 * time.Second
+
+	defaultConfigWatchInterval = 5 * time.Minute
 )
 
 // HTTPTransport is an implementation of Transport, sending payloads via
@@ -66,9 +72,15 @@ type HTTPTransport struct {
 	Client  *http.Client
 	headers http.Header
 
-	intakeURLs     []*url.URL
-	intakeURLIndex int
-	shuffleRand    *rand.Rand
+	serverURLs  []*url.URL
+	intakeURLs  []*url.URL
+	shuffleRand *rand.Rand
+	clockSkew   clockSkewEstimator
+
+	// urlIndexMu guards urlIndex, which is advanced by SendStream and
+	// read by ServerInfo and WatchConfig, possibly concurrently.
+	urlIndexMu sync.Mutex
+	urlIndex   int
 }
 
 // NewHTTPTransport returns a new HTTPTransport which can be used for
@@ -96,6 +108,21 @@ type HTTPTransport struct {
 //   when using HTTPS. By default, the transport will verify server
 //   certificates.
 //
+// - ELASTIC_APM_SERVER_TLS_MIN_VERSION: the minimum TLS version to use
+//   when connecting to the APM Server: "1.0", "1.1", "1.2" or "1.3".
+//
+// - ELASTIC_APM_SERVER_TLS_CIPHER_SUITES: a comma-separated list of TLS
+//   1.0-1.2 cipher suite names, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+//   By default, Go's default cipher suites are used.
+//
+// - ELASTIC_APM_SERVER_TLS_SERVER_NAME: the server name used to verify
+//   the APM Server's certificate, if different from the URL's host name.
+//
+// If the agent is built with the requirefips build tag, the TLS
+// configuration is restricted to FIPS-approved versions, cipher suites
+// and curves, and server certificate verification cannot be disabled.
+// See SetTLSConfig for configuring TLS programmatically.
+//
 func NewHTTPTransport() (*HTTPTransport, error) {
 	verifyServerCert, err := apmconfig.ParseBoolEnv(envVerifyServerCert, true)
 	if err != nil {
@@ -129,6 +156,12 @@ func NewHTTPTransport() (*HTTPTransport, error) {
 			return verifyPeerCertificate(rawCerts, serverCert)
 		}
 	}
+	if err := configureTLSFromEnv(tlsConfig); err != nil {
+		return nil, err
+	}
+	if err := checkFIPSTLSConfig(tlsConfig); err != nil {
+		return nil, err
+	}
 
 	client := &http.Client{
 		Timeout: serverTimeout,
@@ -163,21 +196,34 @@ func (t *HTTPTransport) SetServerURL(u ...*url.URL) {
 	if len(u) == 0 {
 		panic("SetServerURL expects at least one URL")
 	}
-	intakeURLs := make([]*url.URL, len(u))
-	for i, u := range u {
-		intakeURLs[i] = urlWithPath(u, intakePath)
-	}
-	if n := len(intakeURLs); n > 0 {
+	serverURLs := make([]*url.URL, len(u))
+	copy(serverURLs, u)
+	if n := len(serverURLs); n > 0 {
 		if t.shuffleRand == nil {
 			t.shuffleRand = rand.New(rand.NewSource(time.Now().UnixNano()))
 		}
 		for i := n - 1; i > 0; i-- {
 			j := t.shuffleRand.Intn(i + 1)
-			intakeURLs[i], intakeURLs[j] = intakeURLs[j], intakeURLs[i]
+			serverURLs[i], serverURLs[j] = serverURLs[j], serverURLs[i]
 		}
 	}
+	intakeURLs := make([]*url.URL, len(serverURLs))
+	for i, u := range serverURLs {
+		intakeURLs[i] = urlWithPath(u, intakePath)
+	}
+	t.serverURLs = serverURLs
 	t.intakeURLs = intakeURLs
-	t.intakeURLIndex = 0
+	t.urlIndexMu.Lock()
+	t.urlIndex = 0
+	t.urlIndexMu.Unlock()
+}
+
+// currentURLIndex returns the index of the server URL that the
+// next stream will be sent to.
+func (t *HTTPTransport) currentURLIndex() int {
+	t.urlIndexMu.Lock()
+	defer t.urlIndexMu.Unlock()
+	return t.urlIndex
 }
 
 // SetUserAgent sets the User-Agent header that will be sent with each request.
@@ -200,22 +246,250 @@ func (t *HTTPTransport) SetSecretToken(secretToken string) {
 // the transport is configured with more than one APM Server URL, then the
 // following request will be sent to the next URL in the list.
 func (t *HTTPTransport) SendStream(ctx context.Context, r io.Reader) error {
-	intakeURL := t.intakeURLs[t.intakeURLIndex]
+	urlIndex := t.currentURLIndex()
+	intakeURL := t.intakeURLs[urlIndex]
 	req := t.newRequest(intakeURL)
 	req = requestWithContext(ctx, req)
-	req.Body = ioutil.NopCloser(r)
-	if err := t.sendRequest(req); err != nil {
-		t.intakeURLIndex = (t.intakeURLIndex + 1) % len(t.intakeURLs)
+	body := &timedReader{r: r}
+	req.Body = ioutil.NopCloser(body)
+	if err := t.sendRequest(req, body); err != nil {
+		t.urlIndexMu.Lock()
+		if t.urlIndex == urlIndex {
+			t.urlIndex = (urlIndex + 1) % len(t.intakeURLs)
+		}
+		t.urlIndexMu.Unlock()
 		return err
 	}
 	return nil
 }
 
-func (t *HTTPTransport) sendRequest(req *http.Request) error {
+// ClockSkew returns the estimated difference between the APM Server's
+// clock and the local clock, and whether the server has responded to
+// any requests. The skew is positive if the server's clock is ahead.
+//
+// The skew is estimated from the Date header of server responses and
+// the time taken by the requests, and becomes more precise as more
+// requests are sent. Because the Date header has a resolution of one
+// second, the skew is reported as zero unless the clocks are known to
+// differ.
+func (t *HTTPTransport) ClockSkew() (time.Duration, bool) {
+	return t.clockSkew.estimate()
+}
+
+// ServerInfo requests information about the APM Server that the next
+// stream will be sent to, from its root endpoint. If the server responds
+// with a status other than 200 OK, an *HTTPError is returned along with
+// the server's URL and Date; the version is only known for successful
+// responses. If no response is received, the returned *ServerInfo is nil.
+//
+// The Authorization and User-Agent headers are sent as for streams, so
+// ServerInfo can be used to check that the agent is authorized to send
+// data to the server.
+func (t *HTTPTransport) ServerInfo(ctx context.Context) (*ServerInfo, error) {
+	serverURL := t.serverURLs[t.currentURLIndex()]
+	infoURL := serverURL
+	if !strings.HasSuffix(infoURL.Path, "/") {
+		infoURL = urlWithPath(infoURL, "/")
+	}
+	req, err := http.NewRequest("GET", infoURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"Authorization", "User-Agent"} {
+		if value := t.headers.Get(key); value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+	sent := time.Now()
+	resp, err := t.Client.Do(requestWithContext(ctx, req))
+	if err != nil {
+		return nil, errors.Wrap(err, "sending request failed")
+	}
+	defer resp.Body.Close()
+	t.clockSkew.observe(sent, time.Now(), resp)
+
+	info := &ServerInfo{URL: serverURL}
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		info.Date = date
+	}
+	bodyContents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return info, errors.Wrap(err, "reading response failed")
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(bodyContents))
+		return info, &HTTPError{
+			Response: resp,
+			Message:  strings.TrimSpace(string(bodyContents)),
+		}
+	}
+
+	// APM Server 7.0 and newer report the version at the top level
+	// of the response; older servers report it in the "ok" object.
+	var body struct {
+		Version string `json:"version"`
+		OK      struct {
+			Version string `json:"version"`
+		} `json:"ok"`
+	}
+	if err := json.Unmarshal(bodyContents, &body); err == nil {
+		info.Version = body.Version
+		if info.Version == "" {
+			info.Version = body.OK.Version
+		}
+	}
+	return info, nil
+}
+
+// ServerInfo holds information about an APM Server, as returned by
+// HTTPTransport.ServerInfo.
+type ServerInfo struct {
+	// URL holds the URL of the APM Server.
+	URL *url.URL
+
+	// Version holds the version of the APM Server, or the empty
+	// string if the server did not report its version.
+	Version string
+
+	// Date holds the time reported in the server's Date response
+	// header, or the zero value if the header was missing or invalid.
+	Date time.Time
+}
+
+// WatchConfig polls the APM Server's agent configuration endpoint for
+// changes to the configuration of the agent identified by params,
+// implementing ConfigWatcher.
+//
+// The configuration is requested immediately, and then again after the
+// period specified by the server's Cache-Control max-age directive,
+// defaulting to 5 minutes. The server's ETag is sent in subsequent requests
+// so that the server can report which configuration has been applied, and
+// changes are only sent to the returned channel when the configuration is
+// modified.
+//
+// The server URLs and Authorization and User-Agent headers are those
+// configured when WatchConfig is called. Upon error, the following request
+// is sent to the next URL in the list.
+func (t *HTTPTransport) WatchConfig(ctx context.Context, params WatchConfigParams) <-chan ConfigChange {
+	query := make(url.Values)
+	query.Set("service.name", params.Service.Name)
+	if params.Service.Environment != "" {
+		query.Set("service.environment", params.Service.Environment)
+	}
+	configURLs := make([]*url.URL, len(t.serverURLs))
+	for i, u := range t.serverURLs {
+		configURL := urlWithPath(u, agentConfigPath)
+		configURL.RawQuery = query.Encode()
+		configURLs[i] = configURL
+	}
+	headers := make(http.Header)
+	for _, key := range []string{"Authorization", "User-Agent"} {
+		if value := t.headers.Get(key); value != "" {
+			headers.Set(key, value)
+		}
+	}
+	client := t.Client
+	urlIndex := t.currentURLIndex()
+
+	changes := make(chan ConfigChange)
+	go func() {
+		defer close(changes)
+		var etag string
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+
+			req, _ := http.NewRequest("GET", configURLs[urlIndex].String(), nil)
+			for k, v := range headers {
+				req.Header[k] = v
+			}
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			change, newETag, interval, modified := fetchConfig(client, requestWithContext(ctx, req), &t.clockSkew)
+			if change.Err != nil {
+				urlIndex = (urlIndex + 1) % len(configURLs)
+			} else {
+				etag = newETag
+			}
+			if modified || change.Err != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case changes <- change:
+				}
+			}
+			timer.Reset(interval)
+		}
+	}()
+	return changes
+}
+
+// fetchConfig sends an agent configuration request, returning the
+// configuration change, the ETag to send with the next request, the
+// interval until the next request, and whether the configuration has
+// been modified. The response's Date header is observed by clockSkew.
+func fetchConfig(client *http.Client, req *http.Request, clockSkew *clockSkewEstimator) (ConfigChange, string, time.Duration, bool) {
+	sent := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return ConfigChange{Err: errors.Wrap(err, "sending config request failed")}, "", defaultConfigWatchInterval, false
+	}
+	defer resp.Body.Close()
+	clockSkew.observe(sent, time.Now(), resp)
+
+	interval := defaultConfigWatchInterval
+	if maxAge, ok := cacheControlMaxAge(resp.Header.Get("Cache-Control")); ok {
+		interval = maxAge
+	}
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return ConfigChange{}, req.Header.Get("If-None-Match"), interval, false
+	case http.StatusOK:
+		attrs := make(map[string]string)
+		if err := json.NewDecoder(resp.Body).Decode(&attrs); err != nil {
+			return ConfigChange{Err: errors.Wrap(err, "decoding config response failed")}, "", interval, false
+		}
+		return ConfigChange{Attrs: attrs}, resp.Header.Get("Etag"), interval, true
+	}
+	bodyContents, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(bodyContents))
+	}
+	return ConfigChange{Err: &HTTPError{
+		Response: resp,
+		Message:  strings.TrimSpace(string(bodyContents)),
+	}}, "", interval, false
+}
+
+// cacheControlMaxAge returns the positive max-age directive
+// in the given Cache-Control header value, if any.
+func cacheControlMaxAge(cacheControl string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		seconds, err := strconv.Atoi(directive[len("max-age="):])
+		if err != nil || seconds <= 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
+
+func (t *HTTPTransport) sendRequest(req *http.Request, body *timedReader) error {
 	resp, err := t.Client.Do(req)
 	if err != nil {
 		return errors.Wrap(err, "sending request failed")
 	}
+	t.clockSkew.observe(body.consumed(), time.Now(), resp)
 	switch resp.StatusCode {
 	case http.StatusOK, http.StatusAccepted:
 		resp.Body.Close()
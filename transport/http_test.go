@@ -31,6 +31,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -49,6 +50,9 @@ func init() {
 	os.Unsetenv("ELASTIC_APM_SECRET_TOKEN")
 	os.Unsetenv("ELASTIC_APM_SERVER_CERT")
 	os.Unsetenv("ELASTIC_APM_VERIFY_SERVER_CERT")
+	os.Unsetenv("ELASTIC_APM_SERVER_TLS_MIN_VERSION")
+	os.Unsetenv("ELASTIC_APM_SERVER_TLS_CIPHER_SUITES")
+	os.Unsetenv("ELASTIC_APM_SERVER_TLS_SERVER_NAME")
 }
 
 func TestNewHTTPTransportDefaultURL(t *testing.T) {
@@ -169,6 +173,7 @@ func TestHTTPTransportTLS(t *testing.T) {
 }
 
 func TestHTTPTransportEnvVerifyServerCert(t *testing.T) {
+	skipIfFIPS(t)
 	var h recordingHandler
 	server := httptest.NewTLSServer(&h)
 	defer server.Close()
@@ -238,6 +243,7 @@ func TestHTTPTransportServerTimeout(t *testing.T) {
 }
 
 func TestHTTPTransportServerFailover(t *testing.T) {
+	skipIfFIPS(t)
 	defer patchEnv("ELASTIC_APM_VERIFY_SERVER_CERT", "false")()
 
 	var hosts []string
@@ -280,6 +286,150 @@ func TestHTTPTransportV2NotFound(t *testing.T) {
 	assert.EqualError(t, err, fmt.Sprintf("request failed with 404 Not Found: %s/intake/v2/events not found (requires APM Server 6.5.0 or newer)", server.URL))
 }
 
+func TestHTTPTransportServerInfo(t *testing.T) {
+	var h recordingHandler
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h.ServeHTTP(w, req)
+		w.Header().Set("Date", "Mon, 02 Jan 2006 15:04:05 GMT")
+		fmt.Fprint(w, `{"build_date":"2019-10-01T00:00:00Z","build_sha":"abc","version":"7.4.0"}`)
+	}))
+	defer server.Close()
+
+	transport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+	transport.SetServerURL(mustParseURL(server.URL))
+	transport.SetSecretToken("hunter2")
+	transport.SetUserAgent("agent")
+
+	info, err := transport.ServerInfo(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, server.URL, info.URL.String())
+	assert.Equal(t, "7.4.0", info.Version)
+	assert.Equal(t, time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC), info.Date)
+
+	require.Len(t, h.requests, 1)
+	assert.Equal(t, "GET", h.requests[0].Method)
+	assert.Equal(t, "/", h.requests[0].URL.Path)
+	assert.Equal(t, "agent", h.requests[0].UserAgent())
+	assertAuthorization(t, h.requests[0], "hunter2")
+}
+
+func TestHTTPTransportServerInfoLegacy(t *testing.T) {
+	transport, server := newHTTPTransport(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"ok":{"build_date":"2019-01-01T00:00:00Z","build_sha":"abc","version":"6.6.0"}}`)
+	}))
+	defer server.Close()
+
+	info, err := transport.ServerInfo(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "6.6.0", info.Version)
+}
+
+func TestHTTPTransportServerInfoError(t *testing.T) {
+	transport, server := newHTTPTransport(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	info, err := transport.ServerInfo(context.Background())
+	assert.EqualError(t, err, "request failed with 401 Unauthorized: invalid token")
+	require.NotNil(t, info)
+	assert.Equal(t, server.URL, info.URL.String())
+	assert.False(t, info.Date.IsZero())
+	assert.Empty(t, info.Version)
+
+	server.Close()
+	info, err = transport.ServerInfo(context.Background())
+	assert.Error(t, err)
+	assert.Nil(t, info)
+}
+
+func TestHTTPTransportClockSkew(t *testing.T) {
+	serverSkew := int64(time.Hour)
+	httpTransport, server := newHTTPTransport(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		now := time.Now().Add(time.Duration(atomic.LoadInt64(&serverSkew)))
+		w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+
+	_, ok := httpTransport.ClockSkew()
+	assert.False(t, ok)
+
+	assertClockSkew := func(expected time.Duration) {
+		for i := 0; i < 3; i++ {
+			err := httpTransport.SendStream(context.Background(), strings.NewReader(""))
+			require.NoError(t, err)
+		}
+		skew, ok := httpTransport.ClockSkew()
+		assert.True(t, ok)
+		assert.InDelta(t, float64(expected), float64(skew), float64(time.Second))
+	}
+	assertClockSkew(time.Hour)
+
+	// The estimate is restarted when the server's
+	// responses are inconsistent with it.
+	atomic.StoreInt64(&serverSkew, int64(-time.Hour))
+	assertClockSkew(-time.Hour)
+
+	// The clocks cannot be shown to differ by less than a second.
+	atomic.StoreInt64(&serverSkew, 0)
+	assertClockSkew(0)
+	skew, _ := httpTransport.ClockSkew()
+	assert.Equal(t, time.Duration(0), skew)
+}
+
+func TestHTTPTransportWatchConfig(t *testing.T) {
+	var requests []*http.Request
+	responses := make(chan func(w http.ResponseWriter))
+	httpTransport, server := newHTTPTransport(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req)
+		(<-responses)(w)
+	}))
+	defer server.Close()
+	httpTransport.SetSecretToken("hunter2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var params transport.WatchConfigParams
+	params.Service.Name = "name"
+	params.Service.Environment = "env"
+	changes := httpTransport.WatchConfig(ctx, params)
+
+	responses <- func(w http.ResponseWriter) {
+		w.Header().Set("Cache-Control", "max-age=1")
+		w.Header().Set("Etag", `"abc"`)
+		fmt.Fprint(w, `{"transaction_sample_rate":"0.5"}`)
+	}
+	change := <-changes
+	require.NoError(t, change.Err)
+	assert.Equal(t, map[string]string{"transaction_sample_rate": "0.5"}, change.Attrs)
+
+	// Unmodified configuration is not sent to the channel.
+	responses <- func(w http.ResponseWriter) {
+		w.Header().Set("Cache-Control", "max-age=1")
+		w.WriteHeader(http.StatusNotModified)
+	}
+	responses <- func(w http.ResponseWriter) {
+		w.Header().Set("Cache-Control", "max-age=1")
+		http.Error(w, "disabled", http.StatusForbidden)
+	}
+	change = <-changes
+	assert.EqualError(t, change.Err, "request failed with 403 Forbidden: disabled")
+
+	cancel()
+	for range changes {
+	}
+
+	require.Len(t, requests, 3)
+	assert.Equal(t, "/config/v1/agents", requests[0].URL.Path)
+	assert.Equal(t, "service.environment=env&service.name=name", requests[0].URL.RawQuery)
+	assertAuthorization(t, requests[0], "hunter2")
+	assert.Equal(t, "", requests[0].Header.Get("If-None-Match"))
+	assert.Equal(t, `"abc"`, requests[1].Header.Get("If-None-Match"))
+	assert.Equal(t, `"abc"`, requests[2].Header.Get("If-None-Match"))
+}
+
 func TestHTTPTransportServerCert(t *testing.T) {
 	var h recordingHandler
 	server := httptest.NewUnstartedServer(&h)
@@ -0,0 +1,448 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/model"
+)
+
+const envOTLPEndpoint = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+const defaultOTLPEndpoint = "http://localhost:4318"
+
+// OTLPExporter is an Exporter which sends events to an OpenTelemetry
+// collector using OTLP/HTTP with JSON encoding. Transactions and spans
+// are sent as trace data, errors as log records, and metrics as gauges.
+// The OTLP/gRPC protocol is not supported.
+type OTLPExporter struct {
+	// Client exposes the http.Client used by the OTLPExporter for
+	// sending requests to the collector.
+	Client *http.Client
+
+	headers    http.Header
+	tracesURL  *url.URL
+	logsURL    *url.URL
+	metricsURL *url.URL
+}
+
+// NewOTLPExporter returns a new OTLPExporter which sends events to the
+// OTLP/HTTP endpoint with the given base URL, e.g. "http://localhost:4318".
+// Traces, logs and metrics are sent to the signal-specific paths below the
+// base URL.
+//
+// If endpoint is empty, the OTEL_EXPORTER_OTLP_ENDPOINT environment variable
+// is used, defaulting to "http://localhost:4318".
+func NewOTLPExporter(endpoint string) (*OTLPExporter, error) {
+	if endpoint == "" {
+		endpoint = os.Getenv(envOTLPEndpoint)
+		if endpoint == "" {
+			endpoint = defaultOTLPEndpoint
+		}
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse OTLP endpoint %q", endpoint)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("invalid OTLP endpoint %q: expected http or https URL", endpoint)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if u.RawPath != "" {
+		u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/json")
+	return &OTLPExporter{
+		Client:     &http.Client{Timeout: defaultServerTimeout},
+		headers:    headers,
+		tracesURL:  urlWithPath(u, "/v1/traces"),
+		logsURL:    urlWithPath(u, "/v1/logs"),
+		metricsURL: urlWithPath(u, "/v1/metrics"),
+	}, nil
+}
+
+// SetHeader sets an HTTP header to send with each request, e.g.
+// for authenticating with the collector.
+//
+// SetHeader must not be called concurrently with Export.
+func (e *OTLPExporter) SetHeader(key, value string) {
+	e.headers.Set(key, value)
+}
+
+// Export sends the transactions and spans, errors, and metrics in batch
+// to the collector, in up to three requests. All requests are attempted,
+// and the first error encountered, if any, is returned.
+func (e *OTLPExporter) Export(ctx context.Context, batch *Batch) error {
+	resource := otlpResource{Attributes: otlpResourceAttributes(batch.Service)}
+	var result error
+	send := func(u *url.URL, request interface{}) {
+		if err := e.send(ctx, u, request); err != nil && result == nil {
+			result = err
+		}
+	}
+	if len(batch.Transactions)+len(batch.Spans) != 0 {
+		spans := make([]otlpSpan, 0, len(batch.Transactions)+len(batch.Spans))
+		for i := range batch.Transactions {
+			spans = append(spans, otlpTransactionSpan(&batch.Transactions[i]))
+		}
+		for i := range batch.Spans {
+			spans = append(spans, otlpSpanSpan(&batch.Spans[i]))
+		}
+		send(e.tracesURL, newOTLPTraceRequest(batch.Service, spans))
+	}
+	if len(batch.Errors) != 0 {
+		records := make([]otlpLogRecord, len(batch.Errors))
+		for i := range batch.Errors {
+			records[i] = otlpErrorLogRecord(&batch.Errors[i])
+		}
+		send(e.logsURL, otlpExportLogsServiceRequest{
+			ResourceLogs: []otlpResourceLogs{{
+				Resource:  resource,
+				ScopeLogs: []otlpScopeLogs{{Scope: otlpAgentScope, LogRecords: records}},
+			}},
+		})
+	}
+	if len(batch.Metrics) != 0 {
+		send(e.metricsURL, otlpExportMetricsServiceRequest{
+			ResourceMetrics: []otlpResourceMetrics{{
+				Resource:     resource,
+				ScopeMetrics: []otlpScopeMetrics{{Scope: otlpAgentScope, Metrics: otlpGauges(batch.Metrics)}},
+			}},
+		})
+	}
+	return result
+}
+
+func (e *OTLPExporter) send(ctx context.Context, u *url.URL, request interface{}) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode OTLP request")
+	}
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range e.headers {
+		req.Header[k] = v
+	}
+	resp, err := e.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "sending OTLP request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	bodyContents, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(bodyContents))
+	}
+	return &HTTPError{
+		Response: resp,
+		Message:  strings.TrimSpace(string(bodyContents)),
+	}
+}
+
+var otlpAgentScope = otlpScope{Name: "go.elastic.co/apm"}
+
+func newOTLPTraceRequest(service model.Service, spans []otlpSpan) otlpExportTraceServiceRequest {
+	return otlpExportTraceServiceRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   otlpResource{Attributes: otlpResourceAttributes(service)},
+			ScopeSpans: []otlpScopeSpans{{Scope: otlpAgentScope, Spans: spans}},
+		}},
+	}
+}
+
+func otlpResourceAttributes(service model.Service) []otlpAttribute {
+	attrs := []otlpAttribute{stringAttribute("service.name", service.Name)}
+	if service.Version != "" {
+		attrs = append(attrs, stringAttribute("service.version", service.Version))
+	}
+	if service.Environment != "" {
+		attrs = append(attrs, stringAttribute("deployment.environment", service.Environment))
+	}
+	return attrs
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+)
+
+func otlpTransactionSpan(tx *model.Transaction) otlpSpan {
+	span := newOTLPSpan(tx.TraceID, tx.ID, tx.ParentID, tx.Name, tx.Timestamp, tx.Duration)
+	span.Kind = otlpSpanKindServer
+	span.Attributes = append(span.Attributes, stringAttribute("transaction.type", tx.Type))
+	if tx.Result != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("transaction.result", tx.Result))
+	}
+	if tx.Context != nil {
+		span.Attributes = appendTagAttributes(span.Attributes, tx.Context.Tags)
+	}
+	return span
+}
+
+func otlpSpanSpan(s *model.Span) otlpSpan {
+	span := newOTLPSpan(s.TraceID, s.ID, s.ParentID, s.Name, s.Timestamp, s.Duration)
+	span.Kind = otlpSpanKindInternal
+	span.Attributes = append(span.Attributes, stringAttribute("span.type", s.Type))
+	if s.Subtype != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("span.subtype", s.Subtype))
+	}
+	if s.Action != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("span.action", s.Action))
+	}
+	if s.Context != nil {
+		if dest := s.Context.Destination; dest != nil {
+			span.Kind = otlpSpanKindClient
+			if dest.Address != "" {
+				span.Attributes = append(span.Attributes, stringAttribute("net.peer.name", dest.Address))
+			}
+			if dest.Port != 0 {
+				span.Attributes = append(span.Attributes, stringAttribute("net.peer.port", strconv.Itoa(dest.Port)))
+			}
+		}
+		span.Attributes = appendTagAttributes(span.Attributes, s.Context.Tags)
+	}
+	return span
+}
+
+func newOTLPSpan(
+	traceID model.TraceID,
+	spanID, parentID model.SpanID,
+	name string,
+	timestamp model.Time,
+	durationMillis float64,
+) otlpSpan {
+	start := time.Time(timestamp)
+	end := start.Add(time.Duration(durationMillis * float64(time.Millisecond)))
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(traceID[:]),
+		SpanID:            hex.EncodeToString(spanID[:]),
+		Name:              name,
+		StartTimeUnixNano: strconv.FormatInt(start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+	}
+	if parentID != (model.SpanID{}) {
+		span.ParentSpanID = hex.EncodeToString(parentID[:])
+	}
+	return span
+}
+
+// otlpSeverityNumberError is the OTLP severity number for ERROR.
+const otlpSeverityNumberError = 17
+
+func otlpErrorLogRecord(e *model.Error) otlpLogRecord {
+	record := otlpLogRecord{
+		TimeUnixNano:   strconv.FormatInt(time.Time(e.Timestamp).UnixNano(), 10),
+		SeverityNumber: otlpSeverityNumberError,
+		SeverityText:   "ERROR",
+	}
+	if e.TraceID != (model.TraceID{}) {
+		record.TraceID = hex.EncodeToString(e.TraceID[:])
+	}
+	if e.ParentID != (model.SpanID{}) {
+		record.SpanID = hex.EncodeToString(e.ParentID[:])
+	}
+	if e.Exception.Message != "" {
+		record.Body.StringValue = e.Exception.Message
+		exceptionType := e.Exception.Type
+		if e.Exception.Module != "" {
+			exceptionType = e.Exception.Module + "." + exceptionType
+		}
+		record.Attributes = append(record.Attributes,
+			stringAttribute("exception.type", exceptionType),
+			stringAttribute("exception.message", e.Exception.Message),
+		)
+	} else {
+		record.Body.StringValue = e.Log.Message
+	}
+	if e.Culprit != "" {
+		record.Attributes = append(record.Attributes, stringAttribute("error.culprit", e.Culprit))
+	}
+	if e.Context != nil {
+		record.Attributes = appendTagAttributes(record.Attributes, e.Context.Tags)
+	}
+	return record
+}
+
+// otlpGauges returns a gauge for each metric name in metrics, ordered by
+// name, with a data point for each metricset holding a sample of the metric.
+// Non-finite values cannot be encoded as JSON, and are omitted. Histogram
+// samples hold only representative bucket values, not the bucket boundaries
+// required by OTLP histograms, and are also omitted.
+func otlpGauges(metrics []model.Metrics) []otlpMetric {
+	var names []string
+	gauges := make(map[string]*otlpMetric)
+	for _, m := range metrics {
+		timestamp := strconv.FormatInt(time.Time(m.Timestamp).UnixNano(), 10)
+		attrs := appendTagAttributes(nil, m.Labels)
+		for name, sample := range m.Samples {
+			if sample.Values != nil || math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+			gauge, ok := gauges[name]
+			if !ok {
+				gauge = &otlpMetric{Name: name}
+				gauges[name] = gauge
+				names = append(names, name)
+			}
+			gauge.Gauge.DataPoints = append(gauge.Gauge.DataPoints, otlpNumberDataPoint{
+				Attributes:   attrs,
+				TimeUnixNano: timestamp,
+				AsDouble:     sample.Value,
+			})
+		}
+	}
+	sort.Strings(names)
+	out := make([]otlpMetric, len(names))
+	for i, name := range names {
+		out[i] = *gauges[name]
+	}
+	return out
+}
+
+func appendTagAttributes(attrs []otlpAttribute, tags model.StringMap) []otlpAttribute {
+	for _, tag := range tags {
+		attrs = append(attrs, stringAttribute(tag.Key, tag.Value))
+	}
+	return attrs
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpAnyValue{StringValue: value}}
+}
+
+// The types below correspond to the OTLP/JSON encoding of
+// opentelemetry.proto.collector.trace.v1.ExportTraceServiceRequest,
+// opentelemetry.proto.collector.logs.v1.ExportLogsServiceRequest, and
+// opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest.
+
+type otlpExportTraceServiceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpExportLogsServiceRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano   string          `json:"timeUnixNano"`
+	SeverityNumber int             `json:"severityNumber"`
+	SeverityText   string          `json:"severityText"`
+	Body           otlpAnyValue    `json:"body"`
+	Attributes     []otlpAttribute `json:"attributes,omitempty"`
+	TraceID        string          `json:"traceId,omitempty"`
+	SpanID         string          `json:"spanId,omitempty"`
+}
+
+type otlpExportMetricsServiceRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name  string    `json:"name"`
+	Gauge otlpGauge `json:"gauge"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	TimeUnixNano string          `json:"timeUnixNano"`
+	AsDouble     float64         `json:"asDouble"`
+}
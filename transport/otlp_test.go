@@ -0,0 +1,156 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport_test
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body map[string]interface{}
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer abc123", req.Header.Get("Authorization"))
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		mu.Lock()
+		requests[req.URL.Path] = body
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	exporter, err := transport.NewOTLPExporter(server.URL + "/")
+	require.NoError(t, err)
+	exporter.SetHeader("Authorization", "Bearer abc123")
+
+	tracer, err := apm.NewTracer("otlp_test", "1.0")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transport.NewExporterTransport(exporter)
+	tracer.RegisterMetricsGatherer(apm.GatherMetricsFunc(
+		func(ctx context.Context, m *apm.Metrics) error {
+			m.Add("requests", []apm.MetricLabel{{Name: "method", Value: "GET"}}, 42)
+			return nil
+		},
+	))
+
+	tx := tracer.StartTransaction("name", "type")
+	span := tx.StartSpan("span", "db.sql.query", nil)
+	span.Context.SetDestinationAddress("db.local", 5432)
+	span.End()
+	e := tracer.NewError(errors.New("boom"))
+	e.SetTransaction(tx)
+	e.Send()
+	tx.End()
+	tracer.Flush(nil)
+	tracer.SendMetrics(nil)
+	tracer.Flush(nil)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Contains(t, requests, "/v1/traces")
+	require.Contains(t, requests, "/v1/logs")
+	require.Contains(t, requests, "/v1/metrics")
+
+	traceTx := tx.TraceContext()
+	spans := requests["/v1/traces"]["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	require.Len(t, spans, 2)
+	assert.Equal(t, "name", spans[0].(map[string]interface{})["name"])
+	assert.Equal(t, hex.EncodeToString(traceTx.Trace[:]), spans[0].(map[string]interface{})["traceId"])
+	assert.Equal(t, "span", spans[1].(map[string]interface{})["name"])
+	assert.Equal(t, float64(3), spans[1].(map[string]interface{})["kind"])
+
+	logRecords := requests["/v1/logs"]["resourceLogs"].([]interface{})[0].(map[string]interface{})["scopeLogs"].([]interface{})[0].(map[string]interface{})["logRecords"].([]interface{})
+	require.Len(t, logRecords, 1)
+	logRecord := logRecords[0].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"stringValue": "boom"}, logRecord["body"])
+	assert.Equal(t, "ERROR", logRecord["severityText"])
+	assert.Equal(t, hex.EncodeToString(traceTx.Trace[:]), logRecord["traceId"])
+	assert.Equal(t, hex.EncodeToString(traceTx.Span[:]), logRecord["spanId"])
+	assert.Contains(t, logRecord["attributes"], map[string]interface{}{
+		"key":   "exception.type",
+		"value": map[string]interface{}{"stringValue": "errors.errorString"},
+	})
+
+	var gauge map[string]interface{}
+	metrics := requests["/v1/metrics"]["resourceMetrics"].([]interface{})[0].(map[string]interface{})["scopeMetrics"].([]interface{})[0].(map[string]interface{})["metrics"].([]interface{})
+	for _, m := range metrics {
+		if m := m.(map[string]interface{}); m["name"] == "requests" {
+			gauge = m["gauge"].(map[string]interface{})
+		}
+	}
+	require.NotNil(t, gauge)
+	dataPoints := gauge["dataPoints"].([]interface{})
+	require.Len(t, dataPoints, 1)
+	assert.Equal(t, float64(42), dataPoints[0].(map[string]interface{})["asDouble"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"key":   "method",
+		"value": map[string]interface{}{"stringValue": "GET"},
+	}}, dataPoints[0].(map[string]interface{})["attributes"])
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "no thanks", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	exporter, err := transport.NewOTLPExporter(server.URL)
+	require.NoError(t, err)
+	err = exporter.Export(context.Background(), &transport.Batch{
+		Transactions: []model.Transaction{{Name: "name"}},
+	})
+	assert.EqualError(t, err, "request failed with 503 Service Unavailable: no thanks")
+}
+
+func TestNewOTLPExporterEndpoint(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		paths = append(paths, req.URL.Path)
+	}))
+	defer server.Close()
+
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL+"/otlp")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	exporter, err := transport.NewOTLPExporter("")
+	require.NoError(t, err)
+	err = exporter.Export(context.Background(), &transport.Batch{
+		Errors: []model.Error{{Log: model.Log{Message: "log message"}}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/otlp/v1/logs"}, paths)
+
+	_, err = transport.NewOTLPExporter("localhost:4318")
+	assert.EqualError(t, err, `invalid OTLP endpoint "localhost:4318": expected http or https URL`)
+}
@@ -0,0 +1,165 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/model"
+)
+
+// TeeTransport is a Transport which sends streams to another Transport,
+// and mirrors a sample of the transactions and spans in each stream to
+// an io.Writer, such as a local file, for offline analysis.
+//
+// Mirrored events are written as OTLP/JSON trace data: each stream is
+// written as a single line holding an ExportTraceServiceRequest. Errors
+// and metrics are not mirrored.
+//
+// TeeTransport forwards configuration watching, connectivity checks and
+// clock skew estimation to the underlying Transport, where supported.
+type TeeTransport struct {
+	transport Transport
+
+	mu         sync.Mutex
+	w          io.Writer
+	sampleRate float64
+	buf        bytes.Buffer
+}
+
+// NewTeeTransport returns a new TeeTransport which sends streams to t,
+// mirroring them to w. By default all events are mirrored; this may be
+// changed with SetSampleRate.
+func NewTeeTransport(t Transport, w io.Writer) *TeeTransport {
+	return &TeeTransport{transport: t, w: w, sampleRate: 1}
+}
+
+// SetSampleRate sets the proportion of traces whose transactions and
+// spans are mirrored, which must be in the range [0,1]. Sampling is based
+// on the trace ID, so either all or none of the mirrored events of a
+// trace are written.
+func (t *TeeTransport) SetSampleRate(r float64) error {
+	if r < 0 || r > 1 || math.IsNaN(r) {
+		return errors.Errorf("sample rate %v out of range [0,1]", r)
+	}
+	t.mu.Lock()
+	t.sampleRate = r
+	t.mu.Unlock()
+	return nil
+}
+
+// SendStream sends the stream to the underlying Transport, and then
+// mirrors the sampled events read from the stream. Failure to mirror
+// events does not affect the result.
+func (t *TeeTransport) SendStream(ctx context.Context, r io.Reader) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf.Reset()
+	err := t.transport.SendStream(ctx, io.TeeReader(r, &t.buf))
+	t.mirror()
+	return err
+}
+
+// WatchConfig watches the underlying Transport for config changes. If
+// the underlying Transport is not a ConfigWatcher, the returned channel
+// is closed.
+func (t *TeeTransport) WatchConfig(ctx context.Context, params WatchConfigParams) <-chan ConfigChange {
+	return forwardWatchConfig(t.transport, ctx, params)
+}
+
+// ServerInfo requests information about the server from the underlying
+// Transport, returning an error if it does not support connectivity checks.
+func (t *TeeTransport) ServerInfo(ctx context.Context) (*ServerInfo, error) {
+	return forwardServerInfo(t.transport, ctx)
+}
+
+// ClockSkew returns the clock skew estimated by the underlying Transport,
+// if any.
+func (t *TeeTransport) ClockSkew() (time.Duration, bool) {
+	return forwardClockSkew(t.transport)
+}
+
+func (t *TeeTransport) mirror() {
+	if t.sampleRate == 0 {
+		return
+	}
+	zr, err := zlib.NewReader(&t.buf)
+	if err != nil {
+		return
+	}
+	defer zr.Close()
+
+	var service model.Service
+	var spans []otlpSpan
+	decoder := json.NewDecoder(zr)
+	for {
+		var payload struct {
+			Metadata *struct {
+				Service model.Service `json:"service"`
+			} `json:"metadata"`
+			Span        *model.Span        `json:"span"`
+			Transaction *model.Transaction `json:"transaction"`
+		}
+		// Streams may be truncated if the underlying transport
+		// fails, so we mirror whatever could be decoded.
+		if err := decoder.Decode(&payload); err != nil {
+			break
+		}
+		switch {
+		case payload.Metadata != nil:
+			service = payload.Metadata.Service
+		case payload.Transaction != nil:
+			if tx := payload.Transaction; t.sampled(tx.TraceID) {
+				spans = append(spans, otlpTransactionSpan(tx))
+			}
+		case payload.Span != nil:
+			if span := payload.Span; t.sampled(span.TraceID) {
+				spans = append(spans, otlpSpanSpan(span))
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(newOTLPTraceRequest(service, spans))
+	if err != nil {
+		return
+	}
+	t.w.Write(append(data, '\n'))
+}
+
+// sampled reports whether events for the trace with the given ID should
+// be mirrored, using the trailing (random) bytes of the trace ID.
+func (t *TeeTransport) sampled(traceID model.TraceID) bool {
+	if t.sampleRate >= 1 {
+		return true
+	}
+	threshold := uint64(t.sampleRate * math.MaxUint64)
+	return binary.BigEndian.Uint64(traceID[8:]) < threshold
+}
@@ -0,0 +1,143 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestTeeTransport(t *testing.T) {
+	var recorder transporttest.RecorderTransport
+	var buf bytes.Buffer
+	tracer, err := apm.NewTracer("tee_test", "1.0")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = transport.NewTeeTransport(&recorder, &buf)
+
+	tx := tracer.StartTransaction("name", "type")
+	span := tx.StartSpan("span", "db.sql.query", nil)
+	span.Context.SetDestinationAddress("db.local", 5432)
+	span.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+
+	var request struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]interface{} `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Kind         int    `json:"kind"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &request))
+	require.Len(t, request.ResourceSpans, 1)
+	assert.Contains(t, request.ResourceSpans[0].Resource.Attributes, map[string]interface{}{
+		"key":   "service.name",
+		"value": map[string]interface{}{"stringValue": "tee_test"},
+	})
+	require.Len(t, request.ResourceSpans[0].ScopeSpans, 1)
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+
+	txModel, spanModel := payloads.Transactions[0], payloads.Spans[0]
+	assert.Equal(t, "span", spans[0].Name)
+	assert.Equal(t, 3, spans[0].Kind) // client
+	assert.Equal(t, hex.EncodeToString(txModel.ID[:]), spans[0].ParentSpanID)
+	assert.Equal(t, hex.EncodeToString(spanModel.ID[:]), spans[0].SpanID)
+	assert.Equal(t, "name", spans[1].Name)
+	assert.Equal(t, 2, spans[1].Kind) // server
+	assert.Equal(t, hex.EncodeToString(txModel.TraceID[:]), spans[1].TraceID)
+	assert.Equal(t, "", spans[1].ParentSpanID)
+}
+
+func TestTeeTransportSampleRate(t *testing.T) {
+	var buf bytes.Buffer
+	tee := transport.NewTeeTransport(transport.Discard, &buf)
+	assert.Error(t, tee.SetSampleRate(-1))
+	assert.Error(t, tee.SetSampleRate(1.5))
+	require.NoError(t, tee.SetSampleRate(0))
+
+	tracer, err := apm.NewTracer("tee_test", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.Transport = tee
+
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+	assert.Zero(t, buf.Len())
+	assert.Equal(t, uint64(1), tracer.Stats().TransactionsSent)
+}
+
+func TestTeeTransportError(t *testing.T) {
+	var buf bytes.Buffer
+	tee := transport.NewTeeTransport(transporttest.ErrorTransport{Error: assert.AnError}, &buf)
+	err := tee.SendStream(context.Background(), bytes.NewReader(nil))
+	assert.Equal(t, assert.AnError, err)
+	assert.Zero(t, buf.Len())
+}
+
+func TestTeeTransportForwarding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"version":"7.4.0"}`)
+	}))
+	defer server.Close()
+
+	httpTransport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+	httpTransport.SetServerURL(mustParseURL(server.URL))
+
+	tee := transport.NewTeeTransport(httpTransport, ioutil.Discard)
+	info, err := tee.ServerInfo(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "7.4.0", info.Version)
+	_, ok := tee.ClockSkew()
+	assert.True(t, ok)
+
+	tee = transport.NewTeeTransport(&transporttest.RecorderTransport{}, ioutil.Discard)
+	_, err = tee.ServerInfo(context.Background())
+	assert.Error(t, err)
+	_, ok = <-tee.WatchConfig(context.Background(), transport.WatchConfigParams{})
+	assert.False(t, ok)
+}
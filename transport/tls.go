@@ -0,0 +1,150 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	envServerTLSMinVersion   = "ELASTIC_APM_SERVER_TLS_MIN_VERSION"
+	envServerTLSCipherSuites = "ELASTIC_APM_SERVER_TLS_CIPHER_SUITES"
+	envServerTLSServerName   = "ELASTIC_APM_SERVER_TLS_SERVER_NAME"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	// "1.3" is added in tls_go112.go.
+}
+
+// cipherSuites holds the IANA names and IDs of the TLS 1.0-1.2
+// cipher suites which may be configured. Cipher suites with
+// known security issues are omitted.
+var cipherSuites = []struct {
+	name string
+	id   uint16
+}{
+	{"TLS_RSA_WITH_AES_128_CBC_SHA", tls.TLS_RSA_WITH_AES_128_CBC_SHA},
+	{"TLS_RSA_WITH_AES_256_CBC_SHA", tls.TLS_RSA_WITH_AES_256_CBC_SHA},
+	{"TLS_RSA_WITH_AES_128_GCM_SHA256", tls.TLS_RSA_WITH_AES_128_GCM_SHA256},
+	{"TLS_RSA_WITH_AES_256_GCM_SHA384", tls.TLS_RSA_WITH_AES_256_GCM_SHA384},
+	{"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA", tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA},
+	{"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA", tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+	{"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA", tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA},
+	{"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA", tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA},
+	{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+	{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+	{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256", tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305},
+	{"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305},
+}
+
+// SetTLSConfig sets the TLS configuration used for connecting to the
+// APM Server, replacing any configuration made through environment
+// variables. The configuration is cloned, so later changes to config
+// have no effect on the transport. If config is nil, the default TLS
+// configuration is used.
+//
+// SetTLSConfig returns an error if the transport's Client.Transport is
+// not an *http.Transport, or if the agent is built with the requirefips
+// build tag and the configuration is not FIPS-compliant.
+func (t *HTTPTransport) SetTLSConfig(config *tls.Config) error {
+	httpTransport, ok := t.Client.Transport.(*http.Transport)
+	if !ok {
+		return errors.Errorf("cannot set TLS config for %T", t.Client.Transport)
+	}
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	if err := checkFIPSTLSConfig(config); err != nil {
+		return err
+	}
+	httpTransport.TLSClientConfig = config
+	return nil
+}
+
+// configureTLSFromEnv sets the minimum TLS version, cipher suites and
+// server name in config from environment variables, if specified.
+func configureTLSFromEnv(config *tls.Config) error {
+	if value := os.Getenv(envServerTLSMinVersion); value != "" {
+		version, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(value), "tlsv")]
+		if !ok {
+			return errors.Errorf("failed to parse %s: unknown TLS version %q", envServerTLSMinVersion, value)
+		}
+		config.MinVersion = version
+	}
+	if value := os.Getenv(envServerTLSCipherSuites); value != "" {
+		cipherSuites, err := parseCipherSuites(value)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse %s", envServerTLSCipherSuites)
+		}
+		config.CipherSuites = cipherSuites
+	}
+	config.ServerName = os.Getenv(envServerTLSServerName)
+	return nil
+}
+
+// parseCipherSuites parses a comma-separated list of cipher suite
+// names, as defined by IANA, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+// Cipher suites with known security issues are not accepted.
+func parseCipherSuites(value string) ([]uint16, error) {
+	var cipherSuites []uint16
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := cipherSuiteID(name)
+		if !ok {
+			return nil, errors.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		cipherSuites = append(cipherSuites, id)
+	}
+	return cipherSuites, nil
+}
+
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suite := range cipherSuites {
+		if strings.EqualFold(suite.name, name) {
+			return suite.id, true
+		}
+	}
+	return 0, false
+}
+
+// cipherSuiteName returns the IANA name of the cipher suite with
+// the given ID, or its hexadecimal ID if it is not configurable.
+func cipherSuiteName(id uint16) string {
+	for _, suite := range cipherSuites {
+		if suite.id == id {
+			return suite.name
+		}
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
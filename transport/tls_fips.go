@@ -0,0 +1,92 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build requirefips
+
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// fipsCipherSuites holds the TLS 1.2 cipher suites approved for
+// use in FIPS 140 mode. TLS 1.3 cipher suites are not configurable.
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// checkFIPSTLSConfig restricts config to FIPS-approved TLS versions,
+// cipher suites and curves, filling in defaults where unspecified,
+// and returns an error if config specifies anything else.
+func checkFIPSTLSConfig(config *tls.Config) error {
+	if config.InsecureSkipVerify && config.VerifyPeerCertificate == nil {
+		return errors.New("server certificate verification cannot be disabled in FIPS mode")
+	}
+	switch {
+	case config.MinVersion == 0:
+		config.MinVersion = tls.VersionTLS12
+	case config.MinVersion < tls.VersionTLS12:
+		return errors.New("TLS versions older than 1.2 are not allowed in FIPS mode")
+	}
+	if len(config.CipherSuites) == 0 {
+		config.CipherSuites = fipsCipherSuites
+	}
+	for _, id := range config.CipherSuites {
+		if !fipsCipherSuite(id) {
+			return errors.Errorf("cipher suite %s is not allowed in FIPS mode", cipherSuiteName(id))
+		}
+	}
+	if len(config.CurvePreferences) == 0 {
+		config.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+	}
+	for _, curve := range config.CurvePreferences {
+		if curve != tls.CurveP256 && curve != tls.CurveP384 && curve != tls.CurveP521 {
+			return errors.Errorf("curve %s is not allowed in FIPS mode", curveName(curve))
+		}
+	}
+	return nil
+}
+
+func fipsCipherSuite(id uint16) bool {
+	for _, fipsID := range fipsCipherSuites {
+		if id == fipsID {
+			return true
+		}
+	}
+	return false
+}
+
+// curveName returns the name of curve, for error messages.
+func curveName(curve tls.CurveID) string {
+	switch curve {
+	case tls.CurveP256:
+		return "P-256"
+	case tls.CurveP384:
+		return "P-384"
+	case tls.CurveP521:
+		return "P-521"
+	case tls.X25519:
+		return "X25519"
+	}
+	return fmt.Sprintf("%d", curve)
+}
@@ -0,0 +1,68 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// +build requirefips
+
+package transport_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/transport"
+)
+
+// skipIfFIPS skips tests which disable server certificate
+// verification, which is not allowed in FIPS mode.
+func skipIfFIPS(t *testing.T) {
+	t.Skip("server certificate verification cannot be disabled in FIPS mode")
+}
+
+func TestHTTPTransportFIPSDefaults(t *testing.T) {
+	transport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+	tlsConfig := transport.Client.Transport.(*http.Transport).TLSClientConfig
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Len(t, tlsConfig.CipherSuites, 4)
+	assert.Equal(t, []tls.CurveID{tls.CurveP256, tls.CurveP384}, tlsConfig.CurvePreferences)
+}
+
+func TestHTTPTransportFIPSVerifyServerCert(t *testing.T) {
+	defer patchEnv("ELASTIC_APM_VERIFY_SERVER_CERT", "false")()
+	_, err := transport.NewHTTPTransport()
+	assert.EqualError(t, err, "server certificate verification cannot be disabled in FIPS mode")
+}
+
+func TestHTTPTransportFIPSSetTLSConfig(t *testing.T) {
+	transport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+
+	err = transport.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS11})
+	assert.EqualError(t, err, "TLS versions older than 1.2 are not allowed in FIPS mode")
+
+	err = transport.SetTLSConfig(&tls.Config{CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305}})
+	assert.EqualError(t, err, "cipher suite TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256 is not allowed in FIPS mode")
+
+	err = transport.SetTLSConfig(&tls.Config{CurvePreferences: []tls.CurveID{tls.X25519}})
+	assert.EqualError(t, err, "curve X25519 is not allowed in FIPS mode")
+
+	assert.NoError(t, transport.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS13}))
+}
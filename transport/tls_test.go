@@ -0,0 +1,101 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/transport"
+)
+
+func TestHTTPTransportEnvTLS(t *testing.T) {
+	defer patchEnv("ELASTIC_APM_SERVER_TLS_MIN_VERSION", "1.2")()
+	defer patchEnv("ELASTIC_APM_SERVER_TLS_CIPHER_SUITES", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")()
+	defer patchEnv("ELASTIC_APM_SERVER_TLS_SERVER_NAME", "apm.testing")()
+
+	transport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+	tlsConfig := transport.Client.Transport.(*http.Transport).TLSClientConfig
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Equal(t, []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	}, tlsConfig.CipherSuites)
+	assert.Equal(t, "apm.testing", tlsConfig.ServerName)
+}
+
+func TestHTTPTransportEnvTLSInvalid(t *testing.T) {
+	for _, test := range []struct {
+		key, value, err string
+	}{{
+		key:   "ELASTIC_APM_SERVER_TLS_MIN_VERSION",
+		value: "1.4",
+		err:   `failed to parse ELASTIC_APM_SERVER_TLS_MIN_VERSION: unknown TLS version "1.4"`,
+	}, {
+		key:   "ELASTIC_APM_SERVER_TLS_CIPHER_SUITES",
+		value: "TLS_RSA_WITH_RC4_128_SHA",
+		err:   `failed to parse ELASTIC_APM_SERVER_TLS_CIPHER_SUITES: unknown or insecure cipher suite "TLS_RSA_WITH_RC4_128_SHA"`,
+	}} {
+		unpatch := patchEnv(test.key, test.value)
+		_, err := transport.NewHTTPTransport()
+		unpatch()
+		assert.EqualError(t, err, test.err)
+	}
+}
+
+func TestHTTPTransportSetTLSConfig(t *testing.T) {
+	var h recordingHandler
+	server := httptest.NewTLSServer(&h)
+	defer server.Close()
+	defer patchEnv("ELASTIC_APM_SERVER_URLS", server.URL)()
+
+	transport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+
+	certificate, err := x509.ParseCertificate(server.TLS.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	certpool := x509.NewCertPool()
+	certpool.AddCert(certificate)
+	config := &tls.Config{
+		RootCAs:    certpool,
+		MinVersion: tls.VersionTLS12,
+		ServerName: "example.com", // httptest certificates are valid for example.com
+	}
+	require.NoError(t, transport.SetTLSConfig(config))
+	config.ServerName = "changed.testing" // config is cloned
+
+	err = transport.SendStream(context.Background(), strings.NewReader(""))
+	assert.NoError(t, err)
+}
+
+func TestHTTPTransportSetTLSConfigCustomTransport(t *testing.T) {
+	transport, err := transport.NewHTTPTransport()
+	require.NoError(t, err)
+	transport.Client.Transport = http.NewFileTransport(http.Dir("."))
+	err = transport.SetTLSConfig(&tls.Config{})
+	assert.EqualError(t, err, "cannot set TLS config for http.fileTransport")
+}
@@ -0,0 +1,124 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transporttest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/model"
+	"go.elastic.co/apm/transport"
+)
+
+const intakePath = "/intake/v2/events"
+
+// NewServerTracer returns a new apm.Tracer and Server, where the tracer
+// sends events over HTTP to the server. This may be used for end-to-end
+// tests of instrumentation, which exercise the tracer's HTTP transport.
+//
+// The caller is responsible for closing both the tracer and the server.
+func NewServerTracer() (*apm.Tracer, *Server) {
+	server := NewServer()
+	tracer, err := server.NewTracer("transporttest")
+	if err != nil {
+		server.Close()
+		panic(err)
+	}
+	return tracer, server
+}
+
+// Server is a mock Elastic APM Server, recording the events it
+// receives through the intake API. The recorded events can be
+// retrieved using the Payloads method.
+type Server struct {
+	*httptest.Server
+	recorder RecorderTransport
+}
+
+// NewServer returns a new, started, Server.
+func NewServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// NewTracer returns a new apm.Tracer with the given service name,
+// with an HTTP transport configured to send events to s.
+func (s *Server) NewTracer(serviceName string) (*apm.Tracer, error) {
+	serverURL, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	httpTransport, err := transport.NewHTTPTransport()
+	if err != nil {
+		return nil, err
+	}
+	httpTransport.SetServerURL(serverURL)
+	tracer, err := apm.NewTracer(serviceName, "")
+	if err != nil {
+		return nil, err
+	}
+	tracer.Transport = httpTransport
+	return tracer, nil
+}
+
+// ResetPayloads clears out any recorded payloads.
+func (s *Server) ResetPayloads() {
+	s.recorder.ResetPayloads()
+}
+
+// Metadata returns the metadata recorded by the server. If metadata
+// is yet to be received, this method will panic.
+func (s *Server) Metadata() (model.System, model.Process, model.Service) {
+	return s.recorder.Metadata()
+}
+
+// Payloads returns the payloads recorded by the server.
+func (s *Server) Payloads() Payloads {
+	return s.recorder.Payloads()
+}
+
+func (s *Server) handle(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != intakePath {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.record(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) record(req *http.Request) (err error) {
+	// RecorderTransport panics on invalid streams,
+	// since it is expected to be used directly in tests.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return s.recorder.record(req.Context(), req.Body)
+}
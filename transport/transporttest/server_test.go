@@ -0,0 +1,67 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transporttest_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/transport/transporttest"
+)
+
+func TestServerTracer(t *testing.T) {
+	tracer, server := transporttest.NewServerTracer()
+	defer server.Close()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.StartSpan("span", "type", nil).End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := server.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, "name", payloads.Transactions[0].Name)
+	assert.Equal(t, "span", payloads.Spans[0].Name)
+
+	_, _, service := server.Metadata()
+	assert.Equal(t, "transporttest", service.Name)
+	assert.Zero(t, tracer.Stats().Errors)
+
+	server.ResetPayloads()
+	assert.Zero(t, server.Payloads())
+}
+
+func TestServerInvalidRequest(t *testing.T) {
+	server := transporttest.NewServer()
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/intake/v2/events", "application/x-ndjson", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/intake/v2/events")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
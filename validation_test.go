@@ -89,6 +89,18 @@ func TestValidateSpanType(t *testing.T) {
 	})
 }
 
+func TestValidateLinks(t *testing.T) {
+	links := []apm.SpanLink{{
+		Trace: apm.TraceID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		Span:  apm.SpanID{0, 1, 2, 3, 4, 5, 6, 7},
+	}}
+	validatePayloads(t, func(tracer *apm.Tracer) {
+		tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{Links: links})
+		tx.StartSpanOptions("name", "type", apm.SpanOptions{Links: links}).End()
+		tx.End()
+	})
+}
+
 func TestValidateDatabaseSpanContextInstance(t *testing.T) {
 	validateSpan(t, func(s *apm.Span) {
 		s.Context.SetDatabase(apm.DatabaseSpanContext{
@@ -275,6 +287,9 @@ func TestValidateMetrics(t *testing.T) {
 	validatePayloads(t, func(tracer *apm.Tracer) {
 		unregister := tracer.RegisterMetricsGatherer(apm.GatherMetricsFunc(gather))
 		defer unregister()
+		tracer.Meter().Counter("counter").Inc()
+		tracer.Meter().Gauge("gauge").Set(-66)
+		tracer.Meter().Histogram("histogram", []float64{1, 10}).Record(5)
 		tracer.SendMetrics(nil)
 	})
 }